@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +16,7 @@ func NewInstallCmd(rootCmd *cobra.Command) *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:   "install-autocomplete",
-		Short: "Install shell completion for pics",
+		Short: i18n.T("cmd.install_autocomplete.short"),
 		Long: `Install shell completion for the pics CLI.
 
 Automatically detects your shell and installs the appropriate completion script.
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +16,7 @@ func NewUninstallCmd() *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:   "uninstall-autocomplete",
-		Short: "Uninstall shell completion for pics",
+		Short: i18n.T("cmd.uninstall_autocomplete.short"),
 		Long:  `Uninstall shell completion script for the pics CLI.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			home, err := os.UserHomeDir()
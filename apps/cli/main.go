@@ -1,13 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/acm19/pics/apps/cli/completion"
+	"github.com/acm19/pics/apps/cli/output"
+	"github.com/acm19/pics/apps/cli/preview"
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
 	"github.com/acm19/pics/internal/pics"
 	"github.com/barasher/go-exiftool"
@@ -18,67 +32,638 @@ import (
 var version = "dev"
 
 var rootCmd = &cobra.Command{
-	Use:     "pics",
-	Short:   "A Go application for organising and compressing photos and videos",
-	Long:    `Pics helps you organise media files, compress images, and backup/restore to S3.`,
-	Version: version,
+	Use:              "pics",
+	Short:            i18n.T("cmd.root.short"),
+	Long:             `Pics helps you organise media files, compress images, and backup/restore to S3.`,
+	Version:          version,
+	PersistentPreRun: setupCommand,
 }
 
 var parseCmd = &cobra.Command{
-	Use:   "parse SOURCE_DIR TARGET_DIR",
-	Short: "Process and organise media files",
-	Long:  `Copies media files from source subdirectories, optionally compresses JPEGs, and organises into date-based directories.`,
-	Args:  cobra.ExactArgs(2),
-	Run:   runParse,
+	Use:   "parse SOURCE_DIR... TARGET_DIR",
+	Short: i18n.T("cmd.parse.short"),
+	Long: `Copies media files from source subdirectories, optionally compresses JPEGs, and organises into date-based directories.
+Several source directories are imported as a single run, so files of the same date are numbered in one sequence.
+Paths matched by .picsignore files (gitignore syntax) in a SOURCE_DIR or below are skipped.
+With --files-from, only the listed files are imported and TARGET_DIR is the only argument.`,
+	Args: parseArgs,
+	Run:  runParse,
+}
+
+var importScansCmd = &cobra.Command{
+	Use:   "import-scans SOURCE_DIR... TARGET_DIR",
+	Short: i18n.T("cmd.import_scans.short"),
+	Long: `Imports scanned photos, which carry no usable capture date, like parse but under the date given with --date.
+The date is written to each image as its capture date (DateTimeOriginal and CreateDate) together with
+XMP-pics:DateCirca, which records how precise it is, and all files go to the directory of that date.
+Scans are compressed with the archive preset (quality 90) unless --rate says otherwise.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runImportScans,
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch SOURCE_DIR TARGET_DIR",
+	Short: i18n.T("cmd.watch.short"),
+	Long: `Watches SOURCE_DIR, such as the mount point of an SD card or a Syncthing folder, and imports the
+media files that appear in it into TARGET_DIR as parse does, until stopped with Ctrl-C.
+SOURCE_DIR is scanned as soon as it changes (on Linux) and every --interval, and new files are
+imported together once none of them has changed for --settle, so copies in progress are left alone.
+Only the date directories each import adds files to are numbered. The files imported are recorded
+in TARGET_DIR, so they aren't imported again when the watch is restarted, until they are gone from
+SOURCE_DIR; files that fail are tried again once they change.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runWatch,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import TARGET_DIR",
+	Short: i18n.T("cmd.import.short"),
+	Long: `Finds the mounted cameras, phones and camera cards, those with a DCIM directory, and imports the
+media files in their DCIM directories into TARGET_DIR as parse does, one device after another.
+With --only-new, the files imported from a device before are left out: what was imported from each
+device is recorded in TARGET_DIR. Cameras and phones are found when mounted as a volume, by the
+system or by GVFS over PTP or MTP; --list shows the devices found.`,
+	Args: importArgs,
+	Run:  runImport,
 }
 
 var renameCmd = &cobra.Command{
 	Use:   "rename DIRECTORY NAME",
-	Short: "Rename a date-based directory and its images",
-	Long:  `Renames a date-based directory (format: YYYY MM Month DD [current-name]) and updates all image filenames.`,
-	Args:  cobra.ExactArgs(2),
-	Run:   runRename,
+	Short: i18n.T("cmd.rename.short"),
+	Long: `Renames a date-based directory (format: YYYY MM Month DD [current-name]) and updates all image filenames.
+
+Every rename is recorded in the directory, so "pics rename --undo DIRECTORY" restores the previous
+directory name and file names, one rename at a time.`,
+	Args: renameArgs,
+	Run:  runRename,
 }
 
 var backupCmd = &cobra.Command{
 	Use:   "backup SOURCE_DIR BUCKET",
-	Short: "Backup directories to S3",
-	Long:  `Creates tar.gz archives of each subdirectory and uploads to S3 with deduplication (MD5 hash comparison).`,
-	Args:  cobra.ExactArgs(2),
-	Run:   runBackup,
+	Short: i18n.T("cmd.backup.short"),
+	Long: `Creates tar.gz archives of each subdirectory and uploads to S3 with deduplication (MD5 hash comparison).
+Paths matched by .picsignore files (gitignore syntax) in SOURCE_DIR or below are left out of the archives.
+
+The two phases can run separately: "backup --archive-only --staging-dir STAGING SOURCE_DIR" keeps
+the archives in STAGING, and "backup --upload-only STAGING BUCKET" uploads them later.
+With --verify, nothing is uploaded: the archives are created and compared with those in BUCKET,
+listing the directories whose backup is stale or missing. Exits with status 1 when there are any.
+BUCKET may carry a key prefix, e.g. photos/family/, so several libraries can share a bucket.`,
+	Args: backupArgs,
+	Run:  runBackup,
 }
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore BUCKET TARGET_DIR",
-	Short: "Restore directories from S3",
-	Long:  `Downloads and extracts backup archives from S3 with optional date-range filtering.`,
-	Args:  cobra.ExactArgs(2),
-	Run:   runRestore,
+	Short: i18n.T("cmd.restore.short"),
+	Long: `Downloads and extracts backup archives from S3 with optional date-range filtering.
+BUCKET may carry a key prefix, e.g. photos/family/, to restore a library sharing a bucket.
+With --dry-run, the directories that would be downloaded are listed and nothing is restored.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runRestore,
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list BUCKET",
+	Short: i18n.T("cmd.list.short"),
+	Long: `Lists the directories backed up to S3 with their size, number of images and videos and when
+they were last uploaded, filtered by date like restore.
+BUCKET may carry a key prefix, e.g. photos/family/, to list a library sharing a bucket.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runList,
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync BUCKET TARGET_DIR",
+	Short: i18n.T("cmd.sync.short"),
+	Long: `Compares the date directories of TARGET_DIR with those synced to BUCKET from other machines,
+uploads the directories that changed locally and downloads those that changed in the bucket.
+Directories changed on both sides since the last sync are reported as conflicts and left alone;
+with --merge-conflicts their files are combined like "restore --merge" and uploaded again.
+Nothing is deleted: a directory or file missing on one side is copied from the other.
+BUCKET may carry a key prefix, e.g. photos/family/, so several libraries can share a bucket.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runSync,
+}
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub TARGET_DIR",
+	Short: i18n.T("cmd.scrub.short"),
+	Long: `Checks the files of TARGET_DIR against the checksums recorded the first time they were scrubbed,
+to catch files corrupted on disk (bit rot). A file whose contents changed while its size and
+modification time didn't is corrupted; new and modified files get their checksums recorded.
+With --bucket, corrupted files are replaced with a good copy from the backups in BUCKET, and with
+--quarantine those left are moved to .pics-quarantine. Run it on a schedule with --interval to
+spread the reading of a large library over several runs.
+Exits with status 1 when corrupted files were found and not repaired.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runScrub,
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff DIR_A DIR_B",
+	Short: i18n.T("cmd.diff.short"),
+	Long: `Reports files added, removed and changed in DIR_B relative to DIR_A, grouped by date directory.
+Exits with status 1 when the libraries differ.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDiff,
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify TARGET_DIR",
+	Short: i18n.T("cmd.verify.short"),
+	Long: `Checks that TARGET_DIR is organised the way parse leaves it: date directories are named
+"YYYY MM Month DD [name]", their images and the videos in their videos directory are named after
+them with sequence numbers that have no gaps or duplicates, and no file is empty.
+With --json, the report is printed as JSON for scripts and monitoring.
+Exits with status 1 when problems were found.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runVerify,
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats TARGET_DIR",
+	Short: i18n.T("cmd.stats.short"),
+	Long: `Reports the photos and videos of the date directories of TARGET_DIR by year and month, their
+total size, the average size of the JPEGs, how many directories are named after an event, and the
+largest directories.
+With --json, the report is printed as JSON for scripts and monitoring.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runStats,
+}
+
+var checkManifestCmd = &cobra.Command{
+	Use:   "check-manifest DIR",
+	Short: i18n.T("cmd.check_manifest.short"),
+	Long: `Checks the files of DIR against the SHA-256 checksums of its manifest.sha256, written by
+"parse --manifest" or "backup --manifest", to find files changed by bit rot or by accident. DIR
+may be a date directory or a library, whose date directories with a manifest are all checked.
+Files missing or changed are reported, and so are files the manifest doesn't list.
+With --json, the report is printed as JSON for scripts and monitoring.
+Exits with status 1 when a file is missing or changed.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runCheckManifest,
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge SOURCE_LIBRARY TARGET_LIBRARY",
+	Short: i18n.T("cmd.merge.short"),
+	Long: `Copies the directories of SOURCE_LIBRARY, organised by parse, into TARGET_LIBRARY, such as when
+consolidating the libraries of two machines. A directory TARGET_LIBRARY has already is combined
+with it: files with the same contents are skipped, and the others are numbered after its files,
+in its videos directory too. Other directories are copied whole. Copies are verified, and
+SOURCE_LIBRARY is left as it is.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runMerge,
+}
+
+var restoreNamesCmd = &cobra.Command{
+	Use:   "restore-names DIRECTORY",
+	Short: i18n.T("cmd.restore_names.short"),
+	Long: `Renames the images and videos of DIRECTORY, and of its videos directory, back to the original
+names parse and rename record in their OriginalFileName tag.
+A file whose original name is taken keeps its name with --on-conflict skip, or gets a number
+before its extension with --on-conflict suffix, e.g. "IMG_0001 (2).JPG".
+The renames are recorded like those of rename, so "pics rename --undo DIRECTORY" numbers the
+files again.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runRestoreNames,
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search LIBRARY_DIR",
+	Short: i18n.T("cmd.search.short"),
+	Long: `Lists the media files of LIBRARY_DIR rated in other tools, read from their EXIF/XMP rating.
+Quote filters using < or >, e.g. --rating '>=4', or write them as --rating 4+.
+With --export, the files found are copied to DIR instead, keeping their date directories.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runSearch,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export DIR OUT",
+	Short: i18n.T("cmd.export.short"),
+	Long: `Writes copies of the favourites of DIR, the images rated five stars, to OUT, sized for a use.
+The phone preset (the default) makes JPEGs of at most 2048 pixels a side at quality 80, without
+the GPS position, all straight in OUT, ready to sync back to a phone or share in messaging apps.
+Pick other images by rating with --rating, or export every image with --all.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runExport,
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: i18n.T("cmd.sessions.short"),
+	Long: `Lists the temporary files left by runs of parse, backup and restore that were interrupted,
+e.g. by a crash or a reboot, in the system temp directory, the --staging-dir and, for parses, the
+.pics-session directory of each --target. Recover them with 'pics sessions recover ID'.`,
+	Args: cobra.NoArgs,
+	Run:  runSessions,
+}
+
+var sessionsRecoverCmd = &cobra.Command{
+	Use:   "recover ID",
+	Short: i18n.T("cmd.sessions_recover.short"),
+	Long: `Recovers the temporary files of an interrupted run:
+  --adopt   organises the files an interrupted parse had already copied into its target directory
+  --clean   removes them
+  --resume  adopts them if possible, picks up a parse interrupted while copying with parse --resume,
+            otherwise removes them and runs the interrupted command again`,
+	Args: cobra.ExactArgs(1),
+	Run:  runSessionsRecover,
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash DIR",
+	Short: i18n.T("cmd.trash.short"),
+	Long: `Lists the runs in the .pics-trash directory of DIR, where parse --move puts the source files
+it imported. Each run keeps the files it moved, with their paths within DIR, until it is emptied:
+parse --move empties the runs older than its --trash-retention, and 'pics trash empty DIR' empties
+them at once. 'pics trash restore DIR' puts the files of a run back where they were.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runTrash,
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty DIR",
+	Short: i18n.T("cmd.trash_empty.short"),
+	Long: `Deletes the runs in the .pics-trash directory of DIR for good, freeing their space. With
+--older-than, only the runs that started longer ago than that are deleted.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runTrashEmpty,
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore DIR [RUN]",
+	Short: i18n.T("cmd.trash_restore.short"),
+	Long: `Moves the files of a run in the .pics-trash directory of DIR back to where they were in DIR,
+the newest run unless RUN names another, as listed by 'pics trash DIR'. Files whose place has
+been taken since stay in the trash.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  runTrashRestore,
 }
 
 var (
 	compressJPEGs bool
-	jpegQuality   int
+	jpegQuality   string
 	maxConcurrent int
 	fromFilter    string
 	toFilter      string
+	stagingDir    string
+	excludeDirs   []string
+	useSHA256     bool
+	targetSize    string
+	progressive   bool
+	compressVideo bool
+	videoCRF      int
+	videoBitrate  string
+	videoWorkers  int
+	minCompress   string
+	minBPP        float64
+	maxDimension  int
+	outputFormats []string
+	logFile       string
+	logFormat     string
+	logLevel      string
+	quiet         bool
+	verbose       bool
+	owner         string
+	chownToMe     bool
+	maxArchive    string
+	maxExtract    string
+	offlineMode   bool
+	filesFrom     string
+	mergeRestore  bool
+	restorePolicy string
+	refreshList   bool
+	verifyMeta    float64
+	verifyCopy    bool
+	moveFiles     bool
+	trashKeep     time.Duration
+	pruneDirs     bool
+	trashAge      time.Duration
+	minFileSize   string
+	stallTimeout  time.Duration
+	originalName  string
+	sequenceOrder string
+	notifyViewer  string
+	notifyURL     string
+	notifyLibrary string
+	albumKeywords bool
+	archiveOnly   bool
+	uploadOnly    string
+	ratingFilter  string
+	favourites    bool
+	exportDir     string
+	exportPreset  string
+	exportAll     bool
+	mergeConflict bool
+	repairBucket  string
+	quarantine    bool
+	scrubInterval time.Duration
+	withUndated   bool
+	scanDate      string
+	scanQuality   string
+	assignedDate  *pics.ApproximateDate
+	noColour      bool
+	adoptSession  bool
+	cleanSession  bool
+	resumeSession bool
+	sessionsIn    []string
+	resumeParse   bool
+	runTimeout    time.Duration
+	objectTimeout time.Duration
+	objectTags    bool
+	imageExts     []string
+	s3Endpoint    string
+	s3Region      string
+	s3PathStyle   bool
+	dryRun        bool
+	backupMode    string
+	verifyJSON    bool
+	verifyBackup  bool
+	appendPlace   bool
+	placesFile    string
+	geocoderURL   string
+	timeZone      string
+	undoRename    bool
+	nameConflict  string
+	statsJSON     bool
+	writeManifest bool
+	manifestJSON  bool
+	streamUpload  bool
+	storageClass  string
+	waitRestore   bool
+	bandwidth     string
+	watchInterval time.Duration
+	watchSettle   time.Duration
+	importDevice  string
+	mountRoots    []string
+	onlyNew       bool
+	listDevices   bool
+	provenance    bool
+	resultJSON    bool
 )
 
+// timeoutGrace is how long a command may keep running past its --timeout to stop cleanly before
+// it is ended
+const timeoutGrace = time.Minute
+
 func init() {
+	// Global flags
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Also write logs to this file (appended to)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Format of log lines: text (key=value) or json (one object per line, for log collectors)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Only log lines at this level or above: debug, info, warn or error (default: info, or debug with DEBUG set)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only print errors and the final summary, without progress (same as --log-level error)")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Also log the debug lines, such as each file handled (same as --log-level debug)")
+	rootCmd.PersistentFlags().BoolVar(&noColour, "no-color", false, "Print tables and lists without colour (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().StringSliceVar(&imageExts, "image-ext", nil, "Also treat files with these extensions as images, e.g. gif,cr2,nef,arw,dng,raf (also set by PICS_IMAGE_EXTENSIONS)")
+
 	// Parse command flags
 	parseCmd.Flags().BoolVarP(&compressJPEGs, "compress", "c", true, "Enable JPEG compression")
-	parseCmd.Flags().IntVarP(&jpegQuality, "rate", "r", 50, "JPEG compression quality (0-100)")
+	parseCmd.Flags().StringVarP(&jpegQuality, "rate", "r", "50", "JPEG compression quality (0-100), or a preset: archive (90), web (75) or share (60)")
+	parseCmd.Flags().StringVar(&targetSize, "target-size", "", "Size budget per JPEG, e.g. 1.5MB or 800KB (overrides --rate)")
+	parseCmd.Flags().BoolVar(&progressive, "progressive", false, "Write compressed JPEGs as progressive JPEGs")
+	parseCmd.Flags().BoolVar(&compressVideo, "compress-videos", false, "Re-encode MOV and MP4 videos to H.264 with ffmpeg, keeping those that don't shrink as they are")
+	parseCmd.Flags().IntVar(&videoCRF, "video-crf", 23, "H.264 constant rate factor videos are compressed at (0-51, lower is better quality)")
+	parseCmd.Flags().StringVar(&videoBitrate, "video-bitrate", "", "Bitrate videos are compressed at, e.g. 4M or 2500k bits per second (overrides --video-crf)")
+	parseCmd.Flags().IntVar(&videoWorkers, "video-workers", 1, "Maximum videos compressed at once, apart from the images (0 = no limit)")
+	parseCmd.Flags().StringVar(&minCompress, "min-compress-size", "", "Copy JPEGs smaller than this size without compressing them, e.g. 500KB")
+	parseCmd.Flags().Float64Var(&minBPP, "min-bpp", 0, "Copy JPEGs at or below this many bits per pixel without compressing them, e.g. 1.5, as they are compact already")
+	parseCmd.Flags().IntVar(&maxDimension, "max-dimension", 0, "Downscale JPEGs larger than this many pixels on their longest side before compressing them, e.g. 3840 (0 keeps their size)")
+	parseCmd.Flags().StringSliceVar(&outputFormats, "output-format", nil, "Format to write images in: jpeg, jpeg-lossless, webp or avif for JPEGs, or EXT=FORMAT per type of image, e.g. webp,png=avif")
+	parseCmd.Flags().StringVar(&minFileSize, "min-file-size", "10KB", "Skip files smaller than this size, such as thumbnail caches, e.g. 50KB (0 imports all)")
+	parseCmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 5*time.Minute, "Stop exiftool, jpegoptim or ffmpeg and move on when a file makes no progress for this long (0 waits indefinitely)")
+	parseCmd.Flags().Float64Var(&verifyMeta, "verify-metadata", 0, "Check that compression keeps the EXIF date, orientation and GPS of this fraction of JPEGs, e.g. 0.1 (alone: all)")
+	parseCmd.Flags().Lookup("verify-metadata").NoOptDefVal = "1"
+	parseCmd.Flags().BoolVar(&verifyCopy, "verify-copy", false, "Hash every file with SHA-256 while copying it and check the copy against it")
+	parseCmd.Flags().BoolVar(&moveFiles, "move", false, "Move each source file to the .pics-trash directory of its source once it is imported, e.g. from a card dump, until 'pics trash empty' frees its space (implies --verify-copy)")
+	parseCmd.Flags().DurationVar(&trashKeep, "trash-retention", pics.DefaultTrashRetention, "With --move, empty the runs of the trash older than this, e.g. 168h (0 keeps them until 'pics trash empty')")
+	parseCmd.Flags().BoolVar(&pruneDirs, "prune-dirs", false, "With --move, remove the source subdirectories left empty, listing those kept and why")
+	parseCmd.Flags().BoolVar(&resumeParse, "resume", false, "Pick up an interrupted parse into TARGET_DIR, keeping the files it already copied and compressed")
+	parseCmd.Flags().StringVar(&timeZone, "timezone", "", "Date files in this time zone, e.g. Asia/Tokyo or +09:00, to put photos of a trip in the right day (default: the offset each file was taken in)")
+	parseCmd.Flags().BoolVar(&appendPlace, "location", false, "Append the place new date directories were taken at, from the GPS position of their files, to their names, e.g. 2023 06 June 15 Barcelona")
+	parseCmd.Flags().StringVar(&placesFile, "places", "", "Look places up offline in this file for --location: a GeoNames dump such as cities1000.txt, or a CSV of name,latitude,longitude")
+	parseCmd.Flags().StringVar(&geocoderURL, "geocoder-url", pics.DefaultGeocoderURL, "Nominatim compatible reverse geocoding API --location asks without --places")
+	parseCmd.Flags().BoolVar(&writeManifest, "manifest", false, "Write a manifest.sha256 with the checksums of their files to the date directories the parse changes, for check-manifest")
+	parseCmd.Flags().BoolVar(&albumKeywords, "album-keywords", false, "Add the names of the source subdirectories, e.g. Wedding, to the keywords of imported images")
+	parseCmd.Flags().BoolVar(&provenance, "provenance", false, "Write the source path, import date, pics version and JPEG quality of each imported image to its XMP metadata")
+	parseCmd.Flags().StringVar(&originalName, "original-name", "keep", "What to do with an OriginalFileName other tools already wrote: keep, overwrite or append-history")
+	parseCmd.Flags().StringVar(&sequenceOrder, "sequence-order", "date", "Order files taken at the same time are numbered in: date (then filename) or capture (then camera, sub-second time and original name)")
+	parseCmd.Flags().StringVar(&notifyViewer, "notify", "", "Ask this viewer to pick up the imported files once done: immich or photoprism (API token in PICS_VIEWER_TOKEN)")
+	parseCmd.Flags().StringVar(&notifyURL, "notify-url", "", "Address of the viewer to notify, e.g. http://photos.local:2283")
+	parseCmd.Flags().StringVar(&notifyLibrary, "notify-library", "", "ID of the Immich external library holding TARGET_DIR")
+	parseCmd.Flags().BoolVar(&offlineMode, "offline", false, "Refuse any network access while parsing")
+	parseCmd.Flags().BoolVar(&resultJSON, "json", false, "Print the result as JSON")
+	parseCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Abort the parse if it takes longer than this, e.g. 2h (0 waits indefinitely)")
+	parseCmd.Flags().StringVar(&filesFrom, "files-from", "", "Import the files listed in this file, one path per line, instead of SOURCE_DIRs (- reads standard input)")
+
+	// Import scans command flags
+	importScansCmd.Flags().StringVar(&scanDate, "date", "", "Approximate date of the scans: YYYY, YYYY-MM or YYYY-MM-DD")
+	importScansCmd.Flags().StringVarP(&scanQuality, "rate", "r", "archive", "JPEG compression quality (0-100), or a preset: archive (90), web (75) or share (60)")
+	importScansCmd.Flags().BoolVar(&verifyCopy, "verify-copy", false, "Hash every file with SHA-256 while copying it and check the copy against it")
+	importScansCmd.Flags().BoolVar(&resumeParse, "resume", false, "Pick up an interrupted import into TARGET_DIR, keeping the files it already copied and compressed")
+	importScansCmd.Flags().BoolVar(&albumKeywords, "album-keywords", false, "Add the names of the source subdirectories, e.g. Roll 12, to the keywords of imported images")
+	importScansCmd.Flags().BoolVar(&provenance, "provenance", false, "Write the source path, import date, pics version and JPEG quality of each imported image to its XMP metadata")
+	importScansCmd.Flags().StringVar(&sequenceOrder, "sequence-order", "date", "Order files taken at the same time are numbered in: date (then filename) or capture (then camera, sub-second time and original name)")
+	importScansCmd.Flags().StringVar(&notifyViewer, "notify", "", "Ask this viewer to pick up the imported files once done: immich or photoprism (API token in PICS_VIEWER_TOKEN)")
+	importScansCmd.Flags().StringVar(&notifyURL, "notify-url", "", "Address of the viewer to notify, e.g. http://photos.local:2283")
+	importScansCmd.Flags().StringVar(&notifyLibrary, "notify-library", "", "ID of the Immich external library holding TARGET_DIR")
+	importScansCmd.Flags().BoolVar(&offlineMode, "offline", false, "Refuse any network access while importing")
+	importScansCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Abort the import if it takes longer than this, e.g. 2h (0 waits indefinitely)")
+	importScansCmd.MarkFlagRequired("date")
+
+	// Watch command flags
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "How often SOURCE_DIR is scanned for new files, besides when it changes")
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", 30*time.Second, "How long new files must stay unchanged before they are imported")
+	watchCmd.Flags().BoolVarP(&compressJPEGs, "compress", "c", true, "Enable JPEG compression")
+	watchCmd.Flags().StringVarP(&jpegQuality, "rate", "r", "50", "JPEG compression quality (0-100), or a preset: archive (90), web (75) or share (60)")
+	watchCmd.Flags().StringVar(&targetSize, "target-size", "", "Size budget per JPEG, e.g. 1.5MB or 800KB (overrides --rate)")
+	watchCmd.Flags().BoolVar(&progressive, "progressive", false, "Write compressed JPEGs as progressive JPEGs")
+	watchCmd.Flags().BoolVar(&compressVideo, "compress-videos", false, "Re-encode MOV and MP4 videos to H.264 with ffmpeg, keeping those that don't shrink as they are")
+	watchCmd.Flags().IntVar(&videoCRF, "video-crf", 23, "H.264 constant rate factor videos are compressed at (0-51, lower is better quality)")
+	watchCmd.Flags().StringVar(&videoBitrate, "video-bitrate", "", "Bitrate videos are compressed at, e.g. 4M or 2500k bits per second (overrides --video-crf)")
+	watchCmd.Flags().IntVar(&videoWorkers, "video-workers", 1, "Maximum videos compressed at once, apart from the images (0 = no limit)")
+	watchCmd.Flags().StringVar(&minCompress, "min-compress-size", "", "Copy JPEGs smaller than this size without compressing them, e.g. 500KB")
+	watchCmd.Flags().Float64Var(&minBPP, "min-bpp", 0, "Copy JPEGs at or below this many bits per pixel without compressing them, e.g. 1.5, as they are compact already")
+	watchCmd.Flags().IntVar(&maxDimension, "max-dimension", 0, "Downscale JPEGs larger than this many pixels on their longest side before compressing them, e.g. 3840 (0 keeps their size)")
+	watchCmd.Flags().StringVar(&minFileSize, "min-file-size", "10KB", "Skip files smaller than this size, such as thumbnail caches, e.g. 50KB (0 imports all)")
+	watchCmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 5*time.Minute, "Stop exiftool, jpegoptim or ffmpeg and move on when a file makes no progress for this long (0 waits indefinitely)")
+	watchCmd.Flags().BoolVar(&verifyCopy, "verify-copy", false, "Hash every file with SHA-256 while copying it and check the copy against it")
+	watchCmd.Flags().BoolVar(&moveFiles, "move", false, "Move each source file to the .pics-trash directory of its source once it is imported, e.g. from a card dump, until 'pics trash empty' frees its space (implies --verify-copy)")
+	watchCmd.Flags().DurationVar(&trashKeep, "trash-retention", pics.DefaultTrashRetention, "With --move, empty the runs of the trash older than this, e.g. 168h (0 keeps them until 'pics trash empty')")
+	watchCmd.Flags().BoolVar(&pruneDirs, "prune-dirs", false, "With --move, remove the source subdirectories left empty, listing those kept and why")
+	watchCmd.Flags().StringVar(&timeZone, "timezone", "", "Date files in this time zone, e.g. Asia/Tokyo or +09:00 (default: the offset each file was taken in)")
+	watchCmd.Flags().BoolVar(&writeManifest, "manifest", false, "Write a manifest.sha256 with the checksums of their files to the date directories each import changes, for check-manifest")
+	watchCmd.Flags().BoolVar(&albumKeywords, "album-keywords", false, "Add the names of the source subdirectories, e.g. Wedding, to the keywords of imported images")
+	watchCmd.Flags().BoolVar(&provenance, "provenance", false, "Write the source path, import date, pics version and JPEG quality of each imported image to its XMP metadata")
+	watchCmd.Flags().StringVar(&originalName, "original-name", "keep", "What to do with an OriginalFileName other tools already wrote: keep, overwrite or append-history")
+	watchCmd.Flags().StringVar(&sequenceOrder, "sequence-order", "date", "Order files taken at the same time are numbered in: date (then filename) or capture (then camera, sub-second time and original name)")
+	watchCmd.Flags().StringVar(&notifyViewer, "notify", "", "Ask this viewer to pick up the imported files after each import: immich or photoprism (API token in PICS_VIEWER_TOKEN)")
+	watchCmd.Flags().StringVar(&notifyURL, "notify-url", "", "Address of the viewer to notify, e.g. http://photos.local:2283")
+	watchCmd.Flags().StringVar(&notifyLibrary, "notify-library", "", "ID of the Immich external library holding TARGET_DIR")
+	watchCmd.Flags().BoolVar(&offlineMode, "offline", false, "Refuse any network access while watching")
+
+	// Import command flags
+	importCmd.Flags().StringVar(&importDevice, "device", "", "Only import from the device with this name, as shown by --list (default: all devices found)")
+	importCmd.Flags().StringArrayVar(&mountRoots, "mount-root", nil, "Look for devices in this directory, or import this mounted volume, instead of where removable volumes are usually mounted (repeatable)")
+	importCmd.Flags().BoolVar(&onlyNew, "only-new", false, "Leave out the files imported from each device before")
+	importCmd.Flags().BoolVar(&listDevices, "list", false, "List the devices found and exit")
+	importCmd.Flags().BoolVarP(&compressJPEGs, "compress", "c", true, "Enable JPEG compression")
+	importCmd.Flags().StringVarP(&jpegQuality, "rate", "r", "50", "JPEG compression quality (0-100), or a preset: archive (90), web (75) or share (60)")
+	importCmd.Flags().StringVar(&targetSize, "target-size", "", "Size budget per JPEG, e.g. 1.5MB or 800KB (overrides --rate)")
+	importCmd.Flags().BoolVar(&progressive, "progressive", false, "Write compressed JPEGs as progressive JPEGs")
+	importCmd.Flags().BoolVar(&compressVideo, "compress-videos", false, "Re-encode MOV and MP4 videos to H.264 with ffmpeg, keeping those that don't shrink as they are")
+	importCmd.Flags().IntVar(&videoCRF, "video-crf", 23, "H.264 constant rate factor videos are compressed at (0-51, lower is better quality)")
+	importCmd.Flags().StringVar(&videoBitrate, "video-bitrate", "", "Bitrate videos are compressed at, e.g. 4M or 2500k bits per second (overrides --video-crf)")
+	importCmd.Flags().IntVar(&videoWorkers, "video-workers", 1, "Maximum videos compressed at once, apart from the images (0 = no limit)")
+	importCmd.Flags().StringVar(&minCompress, "min-compress-size", "", "Copy JPEGs smaller than this size without compressing them, e.g. 500KB")
+	importCmd.Flags().Float64Var(&minBPP, "min-bpp", 0, "Copy JPEGs at or below this many bits per pixel without compressing them, e.g. 1.5, as they are compact already")
+	importCmd.Flags().IntVar(&maxDimension, "max-dimension", 0, "Downscale JPEGs larger than this many pixels on their longest side before compressing them, e.g. 3840 (0 keeps their size)")
+	importCmd.Flags().StringVar(&minFileSize, "min-file-size", "10KB", "Skip files smaller than this size, such as thumbnail caches, e.g. 50KB (0 imports all)")
+	importCmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 5*time.Minute, "Stop exiftool, jpegoptim or ffmpeg and move on when a file makes no progress for this long (0 waits indefinitely)")
+	importCmd.Flags().BoolVar(&verifyCopy, "verify-copy", false, "Hash every file with SHA-256 while copying it and check the copy against it")
+	importCmd.Flags().BoolVar(&moveFiles, "move", false, "Move each file to a .pics-trash directory next to it on the device once it is imported, until 'pics trash empty' frees its space (implies --verify-copy)")
+	importCmd.Flags().DurationVar(&trashKeep, "trash-retention", pics.DefaultTrashRetention, "With --move, empty the runs of the trash older than this, e.g. 168h (0 keeps them until 'pics trash empty')")
+	importCmd.Flags().BoolVar(&pruneDirs, "prune-dirs", false, "With --move, remove the source subdirectories left empty, listing those kept and why")
+	importCmd.Flags().StringVar(&timeZone, "timezone", "", "Date files in this time zone, e.g. Asia/Tokyo or +09:00 (default: the offset each file was taken in)")
+	importCmd.Flags().BoolVar(&writeManifest, "manifest", false, "Write a manifest.sha256 with the checksums of their files to the date directories the import changes, for check-manifest")
+	importCmd.Flags().BoolVar(&provenance, "provenance", false, "Write the source path, import date, pics version and JPEG quality of each imported image to its XMP metadata")
+	importCmd.Flags().StringVar(&originalName, "original-name", "keep", "What to do with an OriginalFileName other tools already wrote: keep, overwrite or append-history")
+	importCmd.Flags().StringVar(&sequenceOrder, "sequence-order", "date", "Order files taken at the same time are numbered in: date (then filename) or capture (then camera, sub-second time and original name)")
+	importCmd.Flags().StringVar(&notifyViewer, "notify", "", "Ask this viewer to pick up the imported files once done: immich or photoprism (API token in PICS_VIEWER_TOKEN)")
+	importCmd.Flags().StringVar(&notifyURL, "notify-url", "", "Address of the viewer to notify, e.g. http://photos.local:2283")
+	importCmd.Flags().StringVar(&notifyLibrary, "notify-library", "", "ID of the Immich external library holding TARGET_DIR")
+	importCmd.Flags().BoolVar(&offlineMode, "offline", false, "Refuse any network access while importing")
+	importCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Abort the import if it takes longer than this, e.g. 2h (0 waits indefinitely)")
+
+	// Rename command flags
+	renameCmd.Flags().StringVar(&sequenceOrder, "sequence-order", "date", "Order files taken at the same time are numbered in: date (then filename) or capture (then camera, sub-second time and original name)")
+	renameCmd.Flags().BoolVar(&offlineMode, "offline", false, "Refuse any network access while renaming")
+	renameCmd.Flags().BoolVar(&undoRename, "undo", false, "Restore the directory and file names before the latest rename of DIRECTORY")
+	renameCmd.Flags().BoolVar(&resultJSON, "json", false, "Print the result as JSON")
 
 	// Backup command flags
 	backupCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 5, "Maximum concurrent operations")
+	backupCmd.Flags().StringVar(&backupMode, "mode", "archive", "How directories are stored: archive (one tar.gz each) or incremental (file by file, uploading only the files that changed)")
+	backupCmd.Flags().StringVar(&stagingDir, "staging-dir", "", "Directory where archives are created before upload (default: system temp directory)")
+	backupCmd.Flags().StringArrayVar(&excludeDirs, "exclude-dir", nil, "Glob pattern for directories to skip, relative to SOURCE_DIR (repeatable)")
+	backupCmd.Flags().BoolVar(&useSHA256, "sha256", false, "Have S3 verify and store a SHA-256 checksum for each archive")
+	backupCmd.Flags().StringVar(&maxArchive, "max-archive-size", "", "Split directories larger than this into several archives, e.g. 10GB")
+	backupCmd.Flags().BoolVar(&archiveOnly, "archive-only", false, "Only create the archives and keep them in --staging-dir for a later --upload-only (takes SOURCE_DIR alone)")
+	backupCmd.Flags().StringVar(&uploadOnly, "upload-only", "", "Upload the archives kept in this staging directory by --archive-only (takes BUCKET alone)")
+	backupCmd.Flags().BoolVar(&verifyBackup, "verify", false, "Compare the directories with their backup in BUCKET instead of uploading, with the same --mode, --max-archive-size and --exclude-dir as the backup")
+	backupCmd.MarkFlagsMutuallyExclusive("verify", "archive-only")
+	backupCmd.MarkFlagsMutuallyExclusive("verify", "upload-only")
+	backupCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Abort the backup if it takes longer than this, e.g. 6h (0 waits indefinitely)")
+	backupCmd.Flags().DurationVar(&objectTimeout, "object-timeout", 0, "Fail the upload of an archive, or any other request to the destination, that takes longer than this, e.g. 30m (0 waits indefinitely)")
+	backupCmd.Flags().BoolVar(&writeManifest, "manifest", false, "Write a manifest.sha256 with the checksums of their files to the directories without one, so the archives carry it")
+	backupCmd.Flags().StringVar(&storageClass, "storage-class", "", "S3 storage class of the archives: STANDARD, STANDARD_IA, GLACIER_IR, GLACIER or DEEP_ARCHIVE (default: that of the bucket)")
+	backupCmd.Flags().StringVar(&bandwidth, "bandwidth-limit", "", "Upload at most this many bytes per second between all archives, e.g. 10MB/s (default: no limit)")
+	backupCmd.Flags().BoolVar(&streamUpload, "stream", false, "Upload each archive in parts while it is created instead of creating it in --staging-dir first, so no staging space is needed")
+	backupCmd.Flags().BoolVar(&objectTags, "tags", false, "Tag each archive with its image and video counts, year and month (needs s3:PutObjectTagging)")
+	backupCmd.Flags().StringVar(&s3Endpoint, "endpoint", "", "URL of an S3-compatible service such as MinIO, Backblaze B2 or Wasabi, e.g. https://s3.wasabisys.com (default: AWS)")
+	backupCmd.Flags().StringVar(&s3Region, "region", "", "Region of the bucket (default: from the AWS config or AWS_REGION, or us-east-1 with --endpoint)")
+	backupCmd.Flags().BoolVar(&s3PathStyle, "path-style", false, "Address the bucket in the URL path instead of the host name, as MinIO and most self-hosted services need")
+	backupCmd.Flags().BoolVar(&resultJSON, "json", false, "Print the result as JSON")
+	backupCmd.MarkFlagsMutuallyExclusive("archive-only", "upload-only")
 
 	// Restore command flags
 	restoreCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 5, "Maximum concurrent operations")
 	restoreCmd.Flags().StringVar(&fromFilter, "from", "", "Lower bound in format YYYY or MM/YYYY")
 	restoreCmd.Flags().StringVar(&toFilter, "to", "", "Upper bound in format YYYY or MM/YYYY")
+	restoreCmd.Flags().BoolVar(&withUndated, "include-undated", false, "Also restore directories without a date, such as review, when --from or --to is set")
+	restoreCmd.Flags().StringVar(&stagingDir, "staging-dir", "", "Directory where archives are downloaded before extraction (default: system temp directory)")
+	restoreCmd.Flags().BoolVar(&useSHA256, "verify-sha256", false, "Verify each downloaded archive against its SHA-256 checksum in S3")
+	restoreCmd.Flags().StringVar(&owner, "owner", "", "Assign restored files to this numeric UID:GID")
+	restoreCmd.Flags().BoolVar(&chownToMe, "chown-to-me", false, "Assign restored files to the invoking user (the sudo user when run with sudo)")
+	restoreCmd.Flags().StringVar(&restorePolicy, "on-conflict", string(pics.RestorePolicyFail), "What to do with date directories that already exist: fail, skip-existing, overwrite (replace them with their backup) or merge")
+	restoreCmd.Flags().BoolVar(&mergeRestore, "merge", false, "Merge into existing date directories, skipping files already there and numbering the others after the existing ones (same as --on-conflict merge)")
+	restoreCmd.Flags().StringVar(&maxExtract, "max-extract-size", "", "Fail archives whose files take more than this once extracted, e.g. 50GB (default: no limit)")
+	restoreCmd.Flags().StringVar(&bandwidth, "bandwidth-limit", "", "Download at most this many bytes per second between all archives, e.g. 10MB/s (default: no limit)")
+	restoreCmd.Flags().BoolVar(&waitRestore, "wait-for-restore", false, "Wait for archives in GLACIER or DEEP_ARCHIVE to be restored in the bucket, which takes hours, and download them instead of skipping their directories")
+	restoreCmd.Flags().BoolVar(&refreshList, "refresh", false, "List the bucket again instead of using the cached listing")
+	restoreCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Abort the restore if it takes longer than this, e.g. 6h (0 waits indefinitely)")
+	restoreCmd.Flags().DurationVar(&objectTimeout, "object-timeout", 0, "Fail the download of an archive, or any other request to the destination, that takes longer than this, e.g. 30m (0 waits indefinitely)")
+	restoreCmd.Flags().StringVar(&s3Endpoint, "endpoint", "", "URL of an S3-compatible service such as MinIO, Backblaze B2 or Wasabi, e.g. https://s3.wasabisys.com (default: AWS)")
+	restoreCmd.Flags().StringVar(&s3Region, "region", "", "Region of the bucket (default: from the AWS config or AWS_REGION, or us-east-1 with --endpoint)")
+	restoreCmd.Flags().BoolVar(&s3PathStyle, "path-style", false, "Address the bucket in the URL path instead of the host name, as MinIO and most self-hosted services need")
+	restoreCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the directories that would be downloaded, and whether they already exist, without restoring them")
+	restoreCmd.Flags().BoolVar(&resultJSON, "json", false, "Print the result as JSON")
+	restoreCmd.MarkFlagsMutuallyExclusive("owner", "chown-to-me")
+
+	listCmd.Flags().StringVar(&fromFilter, "from", "", "Lower bound in format YYYY or MM/YYYY")
+	listCmd.Flags().StringVar(&toFilter, "to", "", "Upper bound in format YYYY or MM/YYYY")
+	listCmd.Flags().BoolVar(&withUndated, "include-undated", false, "Also list directories without a date, such as review, when --from or --to is set")
+	listCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 5, "Maximum concurrent operations")
+	listCmd.Flags().BoolVar(&refreshList, "refresh", false, "List the bucket again instead of using the cached listing")
+	listCmd.Flags().DurationVar(&objectTimeout, "object-timeout", 0, "Fail any request to the destination that takes longer than this, e.g. 5m (0 waits indefinitely)")
+	listCmd.Flags().StringVar(&s3Endpoint, "endpoint", "", "URL of an S3-compatible service such as MinIO, Backblaze B2 or Wasabi, e.g. https://s3.wasabisys.com (default: AWS)")
+	listCmd.Flags().StringVar(&s3Region, "region", "", "Region of the bucket (default: from the AWS config or AWS_REGION, or us-east-1 with --endpoint)")
+	listCmd.Flags().BoolVar(&s3PathStyle, "path-style", false, "Address the bucket in the URL path instead of the host name, as MinIO and most self-hosted services need")
+
+	// Sync command flags
+	syncCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 5, "Maximum concurrent operations")
+	syncCmd.Flags().StringVar(&stagingDir, "staging-dir", "", "Directory where archives are created and downloaded (default: system temp directory)")
+	syncCmd.Flags().StringVar(&maxArchive, "max-archive-size", "", "Split directories larger than this into several archives, e.g. 10GB")
+	syncCmd.Flags().BoolVar(&objectTags, "tags", false, "Tag each archive with its image and video counts, year and month (needs s3:PutObjectTagging)")
+	syncCmd.Flags().BoolVar(&mergeConflict, "merge-conflicts", false, "Combine the files of directories changed both locally and in the bucket, and upload the result")
+	syncCmd.Flags().StringVar(&s3Endpoint, "endpoint", "", "URL of an S3-compatible service such as MinIO, Backblaze B2 or Wasabi, e.g. https://s3.wasabisys.com (default: AWS)")
+	syncCmd.Flags().StringVar(&s3Region, "region", "", "Region of the bucket (default: from the AWS config or AWS_REGION, or us-east-1 with --endpoint)")
+	syncCmd.Flags().BoolVar(&s3PathStyle, "path-style", false, "Address the bucket in the URL path instead of the host name, as MinIO and most self-hosted services need")
+
+	// Scrub command flags
+	scrubCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 4, "Maximum files verified concurrently")
+	scrubCmd.Flags().StringVar(&repairBucket, "bucket", "", "Replace corrupted files with good copies from the backups in this bucket, e.g. photos or photos/family/")
+	scrubCmd.Flags().StringVar(&stagingDir, "staging-dir", "", "Directory where archives are downloaded to recover files (default: system temp directory)")
+	scrubCmd.Flags().BoolVar(&quarantine, "quarantine", false, "Move corrupted files that weren't repaired to .pics-quarantine in TARGET_DIR")
+	scrubCmd.Flags().DurationVar(&scrubInterval, "interval", 0, "Skip files verified less than this long ago, e.g. 720h (0 verifies every file)")
+	scrubCmd.Flags().StringVar(&s3Endpoint, "endpoint", "", "URL of an S3-compatible service such as MinIO, Backblaze B2 or Wasabi, e.g. https://s3.wasabisys.com (default: AWS)")
+	scrubCmd.Flags().StringVar(&s3Region, "region", "", "Region of the bucket (default: from the AWS config or AWS_REGION, or us-east-1 with --endpoint)")
+	scrubCmd.Flags().BoolVar(&s3PathStyle, "path-style", false, "Address the bucket in the URL path instead of the host name, as MinIO and most self-hosted services need")
+
+	// Verify command flags
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "Print the report as JSON")
+
+	// Stats flags
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Print the report as JSON")
+
+	// Check manifest command flags
+	checkManifestCmd.Flags().BoolVar(&manifestJSON, "json", false, "Print the report as JSON")
+
+	// Restore names flags
+	restoreNamesCmd.Flags().StringVar(&nameConflict, "on-conflict", string(pics.NameConflictSkip), "What to do with a file whose original name is taken: skip (keep its name) or suffix (add a number)")
+	restoreNamesCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the names that would be restored without renaming any file")
+
+	// Search command flags
+	searchCmd.Flags().StringVar(&ratingFilter, "rating", "", "Star rating to match, e.g. '>=4', 4+, '<=2' or 5 (-1 is rejected)")
+	searchCmd.Flags().BoolVar(&favourites, "favourites", false, "Match favourites, files rated five stars")
+	searchCmd.Flags().StringVar(&exportDir, "export", "", "Copy the files found to this directory instead of listing them")
+	searchCmd.MarkFlagsMutuallyExclusive("rating", "favourites")
+	searchCmd.MarkFlagsOneRequired("rating", "favourites")
+
+	// Export command flags
+	exportCmd.Flags().StringVar(&exportPreset, "preset", "phone", "What the copies are for: phone (2048 pixels, quality 80, no GPS position, flattened names)")
+	exportCmd.Flags().StringVar(&ratingFilter, "rating", "", "Export the images with this star rating instead of the favourites, e.g. '>=4' or 4+")
+	exportCmd.Flags().BoolVar(&exportAll, "all", false, "Export every image instead of the favourites")
+	exportCmd.MarkFlagsMutuallyExclusive("rating", "all")
+
+	// Sessions command flags
+	sessionsCmd.PersistentFlags().StringVar(&stagingDir, "staging-dir", "", "Also look for temporary files in this staging directory")
+	sessionsCmd.PersistentFlags().StringArrayVar(&sessionsIn, "target", nil, "Also look for interrupted parses into this target directory (repeatable)")
+	sessionsRecoverCmd.Flags().BoolVar(&adoptSession, "adopt", false, "Organise the files an interrupted parse copied into its target directory")
+	sessionsRecoverCmd.Flags().BoolVar(&cleanSession, "clean", false, "Remove the temporary files")
+	sessionsRecoverCmd.Flags().BoolVar(&resumeSession, "resume", false, "Adopt the files if possible, resume a parse interrupted while copying, otherwise remove them and run the interrupted command again")
+	sessionsRecoverCmd.MarkFlagsMutuallyExclusive("adopt", "clean", "resume")
+	sessionsRecoverCmd.MarkFlagsOneRequired("adopt", "clean", "resume")
+	sessionsCmd.AddCommand(sessionsRecoverCmd)
+
+	// Trash command flags
+	trashEmptyCmd.Flags().DurationVar(&trashAge, "older-than", 0, "Only empty the runs that started longer ago than this, e.g. 720h (0 empties all)")
+	trashCmd.AddCommand(trashEmptyCmd, trashRestoreCmd)
 
 	// Add all subcommands
-	rootCmd.AddCommand(parseCmd, renameCmd, backupCmd, restoreCmd)
+	rootCmd.AddCommand(parseCmd, importScansCmd, watchCmd, importCmd, renameCmd, backupCmd, restoreCmd, listCmd, syncCmd, scrubCmd, diffCmd, verifyCmd, statsCmd, checkManifestCmd, mergeCmd, restoreNamesCmd, searchCmd, exportCmd, sessionsCmd, trashCmd, preview.NewPreviewCmd(), preview.NewContactSheetCmd())
 
 	// Add autocomplete commands
 	rootCmd.AddCommand(completion.NewInstallCmd(rootCmd))
@@ -91,9 +676,139 @@ func main() {
 	}
 }
 
+// newRenderer returns the renderer of the tables and lists a command prints to standard output
+func newRenderer() *output.Renderer {
+	return output.New(os.Stdout, output.ColourEnabled(os.Stdout, noColour))
+}
+
+// setupCommand applies the global flags before any command runs
+func setupCommand(cmd *cobra.Command, args []string) {
+	setupLogging(cmd, args)
+	setupImageExtensions(cmd)
+	applyOfflineMode()
+}
+
+// setupLogging applies --log-format and --log-level, and sends logs to the --log-file as well,
+// if one was given
+func setupLogging(cmd *cobra.Command, args []string) {
+	format, err := logger.ParseFormat(logFormat)
+	if err != nil {
+		logger.Error("Invalid log format", "value", logFormat, "error", err)
+		os.Exit(1)
+	}
+	logger.SetFormat(format)
+	level, ok, err := logLevelFromFlags()
+	if err != nil {
+		logger.Error("Invalid log level", "error", err)
+		os.Exit(1)
+	}
+	if ok {
+		logger.SetLevel(level)
+	}
+	if jsonOutput() {
+		// Keep stdout for the result alone
+		logger.SetConsole(os.Stderr)
+	}
+
+	if logFile == "" {
+		return
+	}
+	if err := logger.SetLogFile(logFile); err != nil {
+		logger.Error("Failed to set up log file", "path", logFile, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Logging to file", "path", logFile, "command", cmd.Name())
+}
+
+// jsonOutput reports whether the command prints its result or report as JSON on stdout
+func jsonOutput() bool {
+	return resultJSON || verifyJSON || statsJSON || manifestJSON
+}
+
+// logLevelFromFlags returns the level of --log-level, --quiet or --verbose, and false if none was
+// given, leaving the level to DEBUG
+func logLevelFromFlags() (slog.Level, bool, error) {
+	if quiet && verbose {
+		return 0, false, fmt.Errorf("--quiet and --verbose can't be used together")
+	}
+	if logLevel != "" {
+		if quiet || verbose {
+			return 0, false, fmt.Errorf("--log-level can't be used with --quiet or --verbose")
+		}
+		level, err := logger.ParseLevel(logLevel)
+		return level, err == nil, err
+	}
+	switch {
+	case quiet:
+		return slog.LevelError, true, nil
+	case verbose:
+		return slog.LevelDebug, true, nil
+	}
+	return 0, false, nil
+}
+
+// setupImageExtensions adds the image formats of --image-ext, or of PICS_IMAGE_EXTENSIONS without
+// it, to those every command supports, exiting on invalid extensions
+func setupImageExtensions(cmd *cobra.Command) {
+	values := imageExts
+	if !cmd.Flags().Changed("image-ext") {
+		if env := os.Getenv("PICS_IMAGE_EXTENSIONS"); env != "" {
+			values = strings.Split(env, ",")
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	extra, err := parseImageExtensions(values)
+	if err != nil {
+		logger.Error("Invalid image extension", "error", err)
+		os.Exit(1)
+	}
+	pics.SetExtensions(append(pics.DefaultImageExtensions(), extra...), pics.DefaultVideoExtensions())
+	logger.Info("Supporting additional image formats", "extensions", extra)
+}
+
+// parseImageExtensions parses the extensions of additional image formats, leaving out those
+// supported already. Video extensions are refused, since a file can't be both.
+func parseImageExtensions(values []string) ([]string, error) {
+	defaults := pics.NewExtensionsWithConfig(pics.DefaultImageExtensions(), pics.DefaultVideoExtensions())
+	var exts []string
+	for _, value := range values {
+		ext, err := pics.ParseExtension(value)
+		if err != nil {
+			return nil, err
+		}
+		if defaults.IsVideo(ext) {
+			return nil, fmt.Errorf("%s is a video format", ext)
+		}
+		if defaults.IsImage(ext) || slices.Contains(exts, ext) {
+			continue
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
 func runParse(cmd *cobra.Command, args []string) {
-	sourceDir := args[0]
-	targetDir := args[1]
+	warnOrphanedSessions(args[len(args)-1])
+	// Once files are moved into the library the parse runs to completion, so only the import
+	// may end the process
+	stopExit := endWhenStuck(runTimeout)
+	defer stopExit()
+	notifier := newViewerNotifier()
+	geocoder := newParseGeocoder()
+
+	targetDir := args[len(args)-1]
+	sourceDirs := args[:len(args)-1]
+	var files []string
+	if filesFrom != "" {
+		var err error
+		files, err = loadFileList(filesFrom)
+		if err != nil {
+			logger.Error("Failed to read file list", "path", filesFrom, "error", err)
+			os.Exit(1)
+		}
+	}
 
 	// Initialise exiftool for this command
 	et, err := exiftool.NewExiftool()
@@ -103,147 +818,2110 @@ func runParse(cmd *cobra.Command, args []string) {
 	}
 	defer et.Close()
 
+	opts := parseOptionsFromFlags(geocoder)
+	opts.OnOrganising = stopExit
 	fileStats := pics.NewFileStats()
-	if err := fileStats.ValidateDirectories(sourceDir, targetDir); err != nil {
-		logger.Error("Directory validation failed", "error", err)
+	for _, sourceDir := range sourceDirs {
+		if err := fileStats.ValidateDirectories(sourceDir, targetDir); err != nil {
+			logger.Error("Directory validation failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+		logger.Error("Directory validation failed", "error", fmt.Errorf("TARGET_DIR is not a valid directory: %s", targetDir))
 		os.Exit(1)
 	}
 
-	opts := pics.DefaultParseOptions()
-	opts.CompressJPEGs = compressJPEGs
-	opts.JPEGQuality = jpegQuality
-
-	sourceCount, err := fileStats.GetFileCount(sourceDir)
-	if err != nil {
-		logger.Error("Error counting source files", "error", err)
-		os.Exit(1)
+	sourceCount := countSupportedFiles(files)
+	for _, sourceDir := range sourceDirs {
+		count, err := fileStats.GetFileCount(sourceDir)
+		if err != nil {
+			logger.Error("Error counting source files", "source", sourceDir, "error", err)
+			os.Exit(1)
+		}
+		sourceCount += count
 	}
 
-	logger.Info("Starting media parsing", "source", sourceDir, "target", targetDir)
 	organiser := pics.NewFileOrganiser(et)
 	exifWriter := pics.NewExifWriter(et)
-	parser := pics.NewMediaParser("", organiser, exifWriter)
-	if err := parser.Parse(sourceDir, targetDir, opts); err != nil {
+	parser := pics.NewMediaParser("", "", organiser, exifWriter, pics.NewMetadataReader(et))
+	ctx, stop := interruptContext()
+	defer stop()
+	var report pics.ParseReport
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	if filesFrom != "" {
+		logger.Info("Starting media parsing", "files", len(files), "target", targetDir)
+		report, err = parser.ParseFiles(ctx, files, targetDir, opts)
+	} else {
+		logger.Info("Starting media parsing", "sources", sourceDirs, "target", targetDir)
+		report, err = parser.Parse(ctx, sourceDirs, targetDir, opts)
+	}
+	stopProgress()
+	if err != nil {
 		logger.Error("Parse failed", "error", err)
-		os.Exit(1)
+		exitFailure(report, err)
 	}
 
-	targetCount, err := fileStats.GetFileCount(targetDir)
+	targetStats, err := fileStats.GetStats(targetDir)
 	if err != nil {
 		logger.Error("Error counting target files", "error", err)
 		os.Exit(1)
 	}
+	targetCount := targetStats.Media().Files
 
+	// Files skipped because they kept changing or are too small are expected to be missing
+	sourceCount -= len(report.Changing) + len(report.TooSmall)
 	if sourceCount != targetCount {
 		logger.Error("File count mismatch", "source_files", sourceCount, "target_files", targetCount, "difference", targetCount-sourceCount)
-		os.Exit(1)
+		exitFailure(report, fmt.Errorf("file count mismatch: %d source files, %d in the target", sourceCount, targetCount))
 	}
 
-	logger.Info("Processing completed successfully", "files_processed", sourceCount, "verification", "source and target file counts match")
-}
+	logger.Info("Processing completed successfully", "files_processed", sourceCount, "verification", "source and target file counts match",
+		"images", targetStats.ByClass[pics.MediaClassImage].Files, "videos", targetStats.ByClass[pics.MediaClassVideo].Files,
+		"library_size", pics.FormatByteSize(targetStats.Media().Bytes))
+	logReviewFiles(report, targetDir)
+	logChangingFiles(report)
+	logTooSmallFiles(report)
+	logKeptDirs(report)
+	printResult(report, report.Summary())
 
-func runRename(cmd *cobra.Command, args []string) {
-	directory := args[0]
-	newName := args[1]
+	// The files are imported whether or not the viewer picks them up
+	if notifier != nil {
+		if err := notifier.NotifyImported(context.Background()); err != nil {
+			logger.Warn("Failed to notify viewer", "viewer", notifyViewer, "error", err)
+		} else {
+			logger.Info("Viewer notified of imported files", "viewer", notifyViewer, "url", notifyURL)
+		}
+	}
+}
 
-	// Initialise exiftool for this command
-	et, err := exiftool.NewExiftool()
+// parseOptionsFromFlags returns the parse options of the parse flags, exiting on invalid values
+func parseOptionsFromFlags(geocoder pics.Geocoder) pics.ParseOptions {
+	opts := pics.DefaultParseOptions()
+	opts.CompressJPEGs = compressJPEGs
+	quality, err := pics.ParseJPEGQuality(jpegQuality)
 	if err != nil {
-		logger.Error("Failed to initialise exiftool", "error", err)
+		logger.Error("Invalid JPEG quality", "value", jpegQuality, "error", err)
 		os.Exit(1)
 	}
-	defer et.Close()
+	opts.JPEGQuality = quality
+	opts.ProgressiveJPEGs = progressive
+	if targetSize != "" {
+		size, err := parseByteSize(targetSize)
+		if err != nil {
+			logger.Error("Invalid target size (expected e.g. 1.5MB or 800KB)", "value", targetSize, "error", err)
+			os.Exit(1)
+		}
+		opts.JPEGTargetSize = size
+	}
+	opts.CompressVideos = compressVideo
+	if err := pics.ValidateVideoCRF(videoCRF); err != nil {
+		logger.Error("Invalid video CRF", "value", videoCRF, "error", err)
+		os.Exit(1)
+	}
+	opts.VideoCRF = videoCRF
+	if videoBitrate != "" {
+		bitrate, err := parseBitrate(videoBitrate)
+		if err != nil {
+			logger.Error("Invalid video bitrate (expected e.g. 4M or 2500k)", "value", videoBitrate, "error", err)
+			os.Exit(1)
+		}
+		opts.VideoBitrate = bitrate
+	}
+	if videoWorkers < 0 {
+		logger.Error("Invalid number of video workers (expected 0 or more)", "value", videoWorkers)
+		os.Exit(1)
+	}
+	opts.MaxVideoConcurrency = videoWorkers
+	if minCompress != "" {
+		size, err := parseByteSize(minCompress)
+		if err != nil {
+			logger.Error("Invalid minimum compression size (expected e.g. 500KB)", "value", minCompress, "error", err)
+			os.Exit(1)
+		}
+		opts.MinSizeForCompression = size
+	}
 
-	renamer := pics.NewDirectoryRenamer(et)
-	if err := renamer.RenameDirectory(directory, newName); err != nil {
-		logger.Error("Rename failed", "error", err)
+	if minBPP < 0 {
+		logger.Error("Invalid minimum bits per pixel (expected a positive number, or 0)", "value", minBPP)
 		os.Exit(1)
 	}
+	opts.MinBitsPerPixel = minBPP
+	if maxDimension < 0 {
+		logger.Error("Invalid maximum dimension (expected a number of pixels, or 0)", "value", maxDimension)
+		os.Exit(1)
+	}
+	opts.MaxDimension = maxDimension
+	if len(outputFormats) > 0 {
+		formats, err := pics.ParseOutputFormats(outputFormats)
+		if err != nil {
+			logger.Error("Invalid output format", "error", err)
+			os.Exit(1)
+		}
+		opts.OutputFormats = formats
+	}
 
-	logger.Info("Rename completed successfully")
+	opts.MinFileSize = 0
+	if minFileSize != "0" {
+		size, err := parseByteSize(minFileSize)
+		if err != nil {
+			logger.Error("Invalid minimum file size (expected e.g. 10KB, or 0)", "value", minFileSize, "error", err)
+			os.Exit(1)
+		}
+		opts.MinFileSize = size
+	}
+	if verifyMeta < 0 || verifyMeta > 1 {
+		logger.Error("Invalid metadata verification rate (expected a fraction between 0 and 1)", "value", verifyMeta)
+		os.Exit(1)
+	}
+	opts.VerifyMetadataRate = verifyMeta
+	opts.VerifyCopy = verifyCopy
+	opts.MoveFiles = moveFiles
+	opts.TrashRetention = trashKeep
+	opts.PruneSourceDirs = pruneDirs
+	opts.Resume = resumeParse
+	opts.StallTimeout = stallTimeout
+	opts.Timeout = runTimeout
+	policy, err := pics.ParseOriginalNamePolicy(originalName)
+	if err != nil {
+		logger.Error("Invalid original name policy", "value", originalName, "error", err)
+		os.Exit(1)
+	}
+	opts.OriginalNamePolicy = policy
+	opts.SequenceOrder = parseSequenceOrderFlag()
+	opts.AlbumKeywords = albumKeywords
+	opts.WriteProvenance = provenance
+	opts.Version = version
+	opts.AssignedDate = assignedDate
+	if timeZone != "" {
+		zone, err := pics.ParseTimeZone(timeZone)
+		if err != nil {
+			logger.Error("Invalid time zone", "value", timeZone, "error", err)
+			os.Exit(1)
+		}
+		opts.TimeZone = zone
+	}
+	opts.AppendLocation = geocoder != nil
+	opts.Geocoder = geocoder
+	opts.WriteManifests = writeManifest
+	return opts
 }
 
-func runBackup(cmd *cobra.Command, args []string) {
-	sourceDir := args[0]
-	bucket := args[1]
-
-	// Validate source directory exists
-	if info, err := os.Stat(sourceDir); err != nil {
-		logger.Error("Source directory does not exist", "directory", sourceDir, "error", err)
+// newViewerNotifier creates the notifier of --notify, or returns nil without --notify, exiting
+// on invalid options
+func newViewerNotifier() pics.ViewerNotifier {
+	if notifyViewer == "" {
+		return nil
+	}
+	if offlineMode {
+		logger.Error("--notify needs network access and can't be used with --offline")
 		os.Exit(1)
-	} else if !info.IsDir() {
-		logger.Error("Source path is not a directory", "path", sourceDir)
+	}
+	kind, err := pics.ParseViewerKind(notifyViewer)
+	if err != nil {
+		logger.Error("Invalid viewer", "value", notifyViewer, "error", err)
 		os.Exit(1)
 	}
 
-	// Create backup instance
-	ctx := context.Background()
-	backup, err := pics.NewS3Backup(ctx)
+	opts := pics.DefaultViewerOptions()
+	opts.Kind = kind
+	opts.URL = notifyURL
+	opts.Token = os.Getenv("PICS_VIEWER_TOKEN")
+	opts.LibraryID = notifyLibrary
+	notifier, err := pics.NewViewerNotifier(opts)
 	if err != nil {
-		logger.Error("Failed to initialise backup", "error", err)
+		logger.Error("Invalid viewer options", "error", err)
 		os.Exit(1)
 	}
+	return notifier
+}
 
-	logger.Info("Starting backup", "source", sourceDir, "bucket", bucket, "max_concurrent", maxConcurrent)
-	if err := backup.BackupDirectories(ctx, sourceDir, bucket, maxConcurrent, nil); err != nil {
-		logger.Error("Backup failed", "error", err)
+// newParseGeocoder creates the geocoder of --location, or returns nil without --location,
+// exiting on invalid options
+func newParseGeocoder() pics.Geocoder {
+	if !appendPlace {
+		return nil
+	}
+	if offlineMode && placesFile == "" {
+		logger.Error("--location needs network access without --places and can't be used with --offline")
 		os.Exit(1)
 	}
 
-	logger.Info("Backup completed successfully")
+	opts := pics.DefaultGeocoderOptions()
+	opts.PlacesFile = placesFile
+	opts.URL = geocoderURL
+	geocoder, err := pics.NewGeocoder(opts)
+	if err != nil {
+		logger.Error("Invalid geocoder options", "error", err)
+		os.Exit(1)
+	}
+	return geocoder
 }
 
-func runRestore(cmd *cobra.Command, args []string) {
-	bucket := args[0]
-	targetDir := args[1]
+// runImportScans runs parse with the date of the scans and the import profile for scans:
+// archive quality, and no minimum file size, since small scans are still photos
+func runImportScans(cmd *cobra.Command, args []string) {
+	date, err := pics.ParseApproximateDate(scanDate)
+	if err != nil {
+		logger.Error("Invalid scan date", "value", scanDate, "error", err)
+		os.Exit(1)
+	}
+	assignedDate = &date
+	jpegQuality = scanQuality
+	minFileSize = "0"
+	runParse(cmd, args)
+}
 
-	// Parse filter
-	var filter pics.RestoreFilter
+func runWatch(cmd *cobra.Command, args []string) {
+	warnOrphanedSessions(args[1])
+	notifier := newViewerNotifier()
+	sourceDir, targetDir := args[0], args[1]
+	if err := pics.NewFileStats().ValidateDirectories(sourceDir, targetDir); err != nil {
+		logger.Error("Directory validation failed", "error", err)
+		os.Exit(1)
+	}
+	if watchInterval <= 0 {
+		logger.Error("Invalid scan interval (expected e.g. 5s)", "value", watchInterval)
+		os.Exit(1)
+	}
 
-	if fromFilter != "" {
-		year, month, err := parseYearMonth(fromFilter)
-		if err != nil {
-			logger.Error("Invalid FROM value (expected YYYY or MM/YYYY)", "value", fromFilter, "error", err)
-			os.Exit(1)
-		}
-		filter.FromYear = year
-		filter.FromMonth = month
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
 	}
+	defer et.Close()
 
-	if toFilter != "" {
-		year, month, err := parseYearMonth(toFilter)
-		if err != nil {
-			logger.Error("Invalid TO value (expected YYYY or MM/YYYY)", "value", toFilter, "error", err)
-			os.Exit(1)
+	opts := pics.DefaultWatchOptions()
+	opts.Parse = parseOptionsFromFlags(nil)
+	opts.PollInterval = watchInterval
+	opts.Settle = watchSettle
+	opts.OnBatch = func(report pics.ParseReport) {
+		logReviewFiles(report, targetDir)
+		logChangingFiles(report)
+		logTooSmallFiles(report)
+		logKeptDirs(report)
+		fmt.Print(report.Summary())
+		if notifier != nil {
+			if err := notifier.NotifyImported(context.Background()); err != nil {
+				logger.Warn("Failed to notify viewer", "viewer", notifyViewer, "error", err)
+			}
 		}
-		filter.ToYear = year
-		filter.ToMonth = month
 	}
 
-	// Validate target directory exists
-	if info, err := os.Stat(targetDir); err != nil {
-		logger.Error("Target directory does not exist", "directory", targetDir, "error", err)
+	parser := pics.NewMediaParser("", "", pics.NewFileOrganiser(et), pics.NewExifWriter(et), pics.NewMetadataReader(et))
+	ctx, stop := interruptContext()
+	defer stop()
+	report, err := pics.NewWatcher(parser).Watch(ctx, sourceDir, targetDir, opts)
+	if err != nil {
+		logger.Error("Watch failed", "error", err)
 		os.Exit(1)
-	} else if !info.IsDir() {
-		logger.Error("Target path is not a directory", "path", targetDir)
+	}
+	logger.Info("Watch stopped", "batches", report.Batches, "imported", report.Imported, "failed", report.Failed, "duration", report.Duration.Round(time.Second))
+	if report.Failed > 0 {
+		os.Exit(partialFailureStatus)
+	}
+}
+
+// importArgs requires TARGET_DIR, unless only listing the devices
+func importArgs(cmd *cobra.Command, args []string) error {
+	if listDevices {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	roots := mountRoots
+	if len(roots) == 0 {
+		roots = pics.DefaultMountRoots()
+	}
+	devices := pics.FindDevices(roots)
+	if importDevice != "" {
+		devices = slices.DeleteFunc(devices, func(device pics.Device) bool { return device.Name != importDevice })
+	}
+	if listDevices {
+		printDevices(newRenderer(), devices)
+		return
+	}
+	if len(devices) == 0 {
+		if importDevice != "" {
+			logger.Error("Device not found, list the devices found with --list", "device", importDevice)
+		} else {
+			logger.Error("No cameras, phones or cards with a DCIM directory found", "searched", roots)
+		}
 		os.Exit(1)
 	}
 
-	// Create backup instance
-	ctx := context.Background()
-	backup, err := pics.NewS3Backup(ctx)
-	if err != nil {
-		logger.Error("Failed to initialise backup", "error", err)
+	warnOrphanedSessions(args[0])
+	notifier := newViewerNotifier()
+	targetDir := args[0]
+	if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+		logger.Error("Directory validation failed", "error", fmt.Errorf("TARGET_DIR is not a valid directory: %s", targetDir))
 		os.Exit(1)
 	}
 
-	logger.Info("Starting restore", "bucket", bucket, "target", targetDir, "max_concurrent", maxConcurrent, "filter", filter)
-	if err := backup.RestoreDirectories(ctx, bucket, targetDir, filter, maxConcurrent, nil); err != nil {
-		logger.Error("Restore failed", "error", err)
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
 		os.Exit(1)
 	}
+	defer et.Close()
 
-	logger.Info("Restore completed successfully")
+	opts := pics.DeviceImportOptions{Parse: parseOptionsFromFlags(nil), OnlyNew: onlyNew}
+	parser := pics.NewMediaParser("", "", pics.NewFileOrganiser(et), pics.NewExifWriter(et), pics.NewMetadataReader(et))
+	importer := pics.NewDeviceImporter(parser)
+	ctx, stop := interruptContext()
+	defer stop()
+	imported := 0
+	start := time.Now()
+	for _, device := range devices {
+		// --timeout bounds the whole run, so each device gets what is left of it
+		if runTimeout > 0 {
+			opts.Parse.Timeout = runTimeout - time.Since(start)
+			if opts.Parse.Timeout <= 0 {
+				logger.Error("Import did not finish within the timeout", "timeout", runTimeout, "device", device.Name)
+				os.Exit(1)
+			}
+		}
+		report, err := importFromDevice(ctx, importer, device, targetDir, opts)
+		if err != nil {
+			logger.Error("Import failed", "device", device.Name, "error", err)
+			os.Exit(1)
+		}
+		if report.Found-report.AlreadyImported == 0 {
+			continue
+		}
+		logReviewFiles(report.Parse, targetDir)
+		logChangingFiles(report.Parse)
+		logTooSmallFiles(report.Parse)
+		logKeptDirs(report.Parse)
+		fmt.Print(report.Parse.Summary())
+		imported += report.Parse.Imported
+	}
+
+	// The files are imported whether or not the viewer picks them up
+	if notifier != nil && imported > 0 {
+		if err := notifier.NotifyImported(context.Background()); err != nil {
+			logger.Warn("Failed to notify viewer", "viewer", notifyViewer, "error", err)
+		} else {
+			logger.Info("Viewer notified of imported files", "viewer", notifyViewer, "url", notifyURL)
+		}
+	}
+}
+
+// importFromDevice imports the new files of device, showing the progress of the import
+func importFromDevice(ctx context.Context, importer pics.DeviceImporter, device pics.Device, targetDir string, opts pics.DeviceImportOptions) (pics.DeviceImportReport, error) {
+	// As with parse, only importing the files of the device may end the process
+	stopExit := endWhenStuck(opts.Parse.Timeout)
+	defer stopExit()
+	opts.Parse.OnOrganising = stopExit
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.Parse.ProgressChan = progress
+	return importer.Import(ctx, device, targetDir, opts)
+}
+
+// printDevices prints a table of the devices found
+func printDevices(out *output.Renderer, devices []pics.Device) {
+	if len(devices) == 0 {
+		out.Line(i18n.T("devices.none"))
+		return
+	}
+	table := output.Table{Header: []string{i18n.T("devices.name"), i18n.T("devices.dcim")}}
+	for _, device := range devices {
+		table.Rows = append(table.Rows, []output.Cell{output.Text(device.Name), output.Text(device.DCIM)})
+	}
+	out.Table(table)
+}
+
+// parseArgs requires TARGET_DIR alone with --files-from, and at least one SOURCE_DIR before it otherwise
+func parseArgs(cmd *cobra.Command, args []string) error {
+	if filesFrom != "" {
+		return cobra.ExactArgs(1)(cmd, args)
+	}
+	return cobra.MinimumNArgs(2)(cmd, args)
+}
+
+// loadFileList reads the file list at path, or standard input when path is "-"
+func loadFileList(path string) ([]string, error) {
+	if path == "-" {
+		return readFileList(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readFileList(f)
+}
+
+// readFileList reads one path per line, skipping blank lines and paths listed before, however
+// they were written. Lines are kept verbatim apart from Windows line endings, as file names may
+// start or end with spaces.
+func readFileList(r io.Reader) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		path := filepath.Clean(line)
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve listed file %s: %w", path, err)
+		}
+		if seen[absPath] {
+			logger.Debug("Skipping repeated file in list", "file", path)
+			continue
+		}
+		seen[absPath] = true
+		files = append(files, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files listed")
+	}
+	return files, nil
+}
+
+// countSupportedFiles counts the files parse will import from a file list
+func countSupportedFiles(files []string) int {
+	extensions := pics.NewExtensions()
+	count := 0
+	for _, file := range files {
+		if extensions.IsSupported(file) {
+			count++
+		}
+	}
+	return count
+}
+
+// logReviewFiles lists the files parse moved to the review directory
+func logReviewFiles(report pics.ParseReport, targetDir string) {
+	if len(report.Review) == 0 {
+		return
+	}
+	logger.Warn("Some files have implausible dates and need review", "count", len(report.Review), "directory", filepath.Join(targetDir, pics.ReviewDirName))
+	for _, file := range report.Review {
+		logger.Warn("  - "+file.Name, "date", file.Date.Format("2006-01-02 15:04:05"), "reason", file.Reason)
+	}
+}
+
+// logTooSmallFiles lists the files parse skipped because they are smaller than --min-file-size
+func logTooSmallFiles(report pics.ParseReport) {
+	if len(report.TooSmall) == 0 {
+		return
+	}
+	logger.Info("Some files were skipped because they are smaller than the minimum file size", "count", len(report.TooSmall), "min_file_size", minFileSize)
+	for _, file := range report.TooSmall {
+		logger.Info("  - " + file)
+	}
+}
+
+// logChangingFiles lists the files parse skipped because they kept changing while being copied
+func logChangingFiles(report pics.ParseReport) {
+	if len(report.Changing) == 0 {
+		return
+	}
+	logger.Warn("Some files were still being written and were skipped, parse them again once they are complete", "count", len(report.Changing))
+	for _, file := range report.Changing {
+		logger.Warn("  - " + file)
+	}
+}
+
+// logKeptDirs lists the source directories --prune-dirs left in place, and why
+func logKeptDirs(report pics.ParseReport) {
+	if len(report.KeptDirs) == 0 {
+		return
+	}
+	logger.Info("Some source directories were left in place", "count", len(report.KeptDirs), "removed", report.Pruned)
+	for _, dir := range report.KeptDirs {
+		logger.Info("  - "+dir.Dir, "reason", dir.Reason)
+	}
+}
+
+// renameArgs requires DIRECTORY alone with --undo, and DIRECTORY and NAME otherwise
+func renameArgs(cmd *cobra.Command, args []string) error {
+	if undoRename {
+		return cobra.ExactArgs(1)(cmd, args)
+	}
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
+func runRename(cmd *cobra.Command, args []string) {
+	directory := args[0]
+	if undoRename {
+		// Restoring names reads nothing but the journal, so exiftool isn't needed
+		restored, err := pics.NewDirectoryRenamer(nil).UndoRename(directory)
+		if err != nil {
+			logger.Error("Undo failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Rename undone", "directory", restored)
+		return
+	}
+
+	newName := args[1]
+	order := parseSequenceOrderFlag()
+
+	// Initialise exiftool for this command
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
+	}
+	defer et.Close()
+
+	renamer := pics.NewDirectoryRenamer(et)
+	report, err := renamer.RenameDirectory(directory, newName, order)
+	if err != nil {
+		logger.Error("Rename failed", "error", err)
+		exitFailure(report, err)
+	}
+
+	logger.Info("Rename completed successfully", "directory", report.Directory, "images", report.Images, "videos", report.Videos)
+	if resultJSON {
+		printJSON(report)
+	}
+}
+
+// checkBucketPath exits if path isn't a bucket optionally followed by a key prefix, e.g. photos/family/,
+// or the file:// or sftp:// URI of another destination
+func checkBucketPath(path string) {
+	if err := pics.ValidateDestination(path); err != nil {
+		logger.Error("Invalid bucket (expected BUCKET, BUCKET/PREFIX/, s3://BUCKET/PREFIX/, file:///PATH or sftp://[USER@]HOST[:PORT]/PATH)", "value", path, "error", err)
+		os.Exit(1)
+	}
+}
+
+// s3ConfigFlags builds the S3 connection settings from --endpoint, --region and --path-style,
+// exiting on an invalid endpoint
+func s3ConfigFlags() pics.S3Config {
+	if s3Endpoint != "" {
+		if err := pics.ValidateS3Endpoint(s3Endpoint); err != nil {
+			logger.Error("Invalid endpoint", "value", s3Endpoint, "error", err)
+			os.Exit(1)
+		}
+	}
+	cfg := pics.DefaultS3Config()
+	cfg.Endpoint = s3Endpoint
+	cfg.Region = s3Region
+	cfg.PathStyle = s3PathStyle
+	return cfg
+}
+
+// bandwidthLimitFlag parses --bandwidth-limit, exiting on an invalid rate (0 = no limit)
+func bandwidthLimitFlag() int64 {
+	if bandwidth == "" {
+		return 0
+	}
+	rate, err := parseBandwidth(bandwidth)
+	if err != nil {
+		logger.Error("Invalid bandwidth limit (expected e.g. 10MB/s)", "value", bandwidth, "error", err)
+		os.Exit(1)
+	}
+	return rate
+}
+
+// restorePolicyFlag parses --on-conflict of restore, which --merge sets to merge, exiting on an
+// invalid policy or one --merge contradicts
+func restorePolicyFlag(cmd *cobra.Command) pics.RestorePolicy {
+	policy, err := pics.ParseRestorePolicy(restorePolicy)
+	if err != nil {
+		logger.Error("Invalid --on-conflict", "error", err)
+		os.Exit(1)
+	}
+	if !mergeRestore {
+		return policy
+	}
+	if cmd.Flags().Changed("on-conflict") && policy != pics.RestorePolicyMerge {
+		logger.Error("--merge can't be combined with --on-conflict " + string(policy))
+		os.Exit(1)
+	}
+	return pics.RestorePolicyMerge
+}
+
+// restoreFilterFlags builds the date filter of --from, --to and --include-undated, exiting on an
+// invalid date
+func restoreFilterFlags() pics.RestoreFilter {
+	var filter pics.RestoreFilter
+
+	if fromFilter != "" {
+		year, month, err := parseYearMonth(fromFilter)
+		if err != nil {
+			logger.Error("Invalid FROM value (expected YYYY or MM/YYYY)", "value", fromFilter, "error", err)
+			os.Exit(1)
+		}
+		filter.FromYear = year
+		filter.FromMonth = month
+	}
+
+	if toFilter != "" {
+		year, month, err := parseYearMonth(toFilter)
+		if err != nil {
+			logger.Error("Invalid TO value (expected YYYY or MM/YYYY)", "value", toFilter, "error", err)
+			os.Exit(1)
+		}
+		filter.ToYear = year
+		filter.ToMonth = month
+	}
+	filter.IncludeUndated = withUndated
+	return filter
+}
+
+// inventoryCacheDir returns the directory bucket listings are cached in, or "" to list the
+// bucket on every run when there is none
+func inventoryCacheDir() string {
+	cacheDir, err := pics.DefaultInventoryCacheDir()
+	if err != nil {
+		logger.Warn("No cache directory, the bucket will be listed on every run", "error", err)
+		return ""
+	}
+	return cacheDir
+}
+
+// parseSequenceOrderFlag parses --sequence-order, exiting on an invalid order
+func parseSequenceOrderFlag() pics.SequenceOrder {
+	order, err := pics.ParseSequenceOrder(sequenceOrder)
+	if err != nil {
+		logger.Error("Invalid sequence order", "value", sequenceOrder, "error", err)
+		os.Exit(1)
+	}
+	return order
+}
+
+func runBackup(cmd *cobra.Command, args []string) {
+	warnOrphanedSessions()
+	mode, err := pics.ParseBackupMode(backupMode)
+	if err != nil {
+		logger.Error("Invalid backup mode", "value", backupMode, "error", err)
+		os.Exit(1)
+	}
+	if mode == pics.BackupModeIncremental && (archiveOnly || uploadOnly != "" || maxArchive != "") {
+		logger.Error("--mode incremental uploads files as they are and can't be combined with --archive-only, --upload-only or --max-archive-size")
+		os.Exit(1)
+	}
+	if streamUpload && (mode == pics.BackupModeIncremental || archiveOnly || uploadOnly != "") {
+		logger.Error("--stream uploads archives while they are created and can't be combined with --mode incremental, --archive-only or --upload-only")
+		os.Exit(1)
+	}
+	var class pics.StorageClass
+	if storageClass != "" {
+		if class, err = pics.ParseStorageClass(storageClass); err != nil {
+			logger.Error("Invalid storage class", "value", storageClass, "error", err)
+			os.Exit(1)
+		}
+		if mode == pics.BackupModeIncremental && class.Archived() {
+			logger.Error("--mode incremental restores files one by one and can't store them in GLACIER or DEEP_ARCHIVE")
+			os.Exit(1)
+		}
+	}
+	if uploadOnly != "" {
+		checkBucketPath(args[0])
+		runUploadOnly(args[0], class)
+		return
+	}
+
+	sourceDir := args[0]
+	bucket := ""
+	if !archiveOnly {
+		bucket = args[1]
+		checkBucketPath(bucket)
+	} else if stagingDir == "" {
+		logger.Error("--archive-only needs --staging-dir to keep the archives in")
+		os.Exit(1)
+	}
+
+	// Validate source directory exists
+	if info, err := os.Stat(sourceDir); err != nil {
+		logger.Error("Source directory does not exist", "directory", sourceDir, "error", err)
+		os.Exit(1)
+	} else if !info.IsDir() {
+		logger.Error("Source path is not a directory", "path", sourceDir)
+		os.Exit(1)
+	}
+
+	// Create backup instance
+	ctx, cancel := commandContext()
+	defer cancel()
+	backup, err := pics.NewS3Backup(ctx, s3ConfigFlags())
+	if err != nil {
+		logger.Error("Failed to initialise backup", "error", err)
+		os.Exit(1)
+	}
+
+	opts := pics.DefaultBackupOptions()
+	opts.Mode = mode
+	opts.MaxConcurrent = maxConcurrent
+	opts.StagingDir = stagingDir
+	opts.ExcludeDirs = excludeDirs
+	opts.SHA256Checksums = useSHA256
+	opts.ObjectTimeout = objectTimeout
+	opts.ObjectTags = objectTags
+	opts.WriteManifests = writeManifest
+	opts.StreamArchives = streamUpload
+	opts.StorageClass = class
+	opts.BandwidthLimit = bandwidthLimitFlag()
+	opts.InventoryCacheDir = inventoryCacheDir()
+	if maxArchive != "" {
+		size, err := parseByteSize(maxArchive)
+		if err != nil {
+			logger.Error("Invalid maximum archive size (expected e.g. 10GB)", "value", maxArchive, "error", err)
+			os.Exit(1)
+		}
+		opts.MaxArchiveSize = size
+	}
+
+	if verifyBackup {
+		runVerifyBackup(ctx, backup, sourceDir, bucket, opts)
+		return
+	}
+
+	if archiveOnly {
+		logger.Info("Starting archiving", "source", sourceDir, "max_concurrent", maxConcurrent, "staging_dir", stagingDir, "exclude_dirs", excludeDirs, "sha256", useSHA256, "max_archive_size", opts.MaxArchiveSize)
+		progress, stopProgress := showProgress()
+		defer stopProgress()
+		opts.ProgressChan = progress
+		report, err := backup.ArchiveDirectories(ctx, sourceDir, opts)
+		stopProgress()
+		if err != nil {
+			logger.Error("Archiving failed", "error", err)
+			exitFailure(report, err)
+		}
+		logger.Info("Archiving completed successfully", "staging_dir", stagingDir)
+		printResult(report, report.Summary())
+		return
+	}
+
+	logger.Info("Starting backup", "source", sourceDir, "bucket", bucket, "mode", mode, "max_concurrent", maxConcurrent, "staging_dir", stagingDir, "exclude_dirs", excludeDirs, "sha256", useSHA256, "max_archive_size", opts.MaxArchiveSize, "tags", objectTags, "stream", streamUpload, "storage_class", storageClass, "bandwidth_limit", bandwidth)
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	report, err := backup.BackupDirectories(ctx, sourceDir, bucket, opts)
+	stopProgress()
+	if len(report.Failed) > 0 {
+		logger.Error("Backup failed for some directories", "failed", len(report.Failed), "error", err)
+		exitPartialFailure(i18n.T("failed.directory"), report.Failed, report, report.Summary())
+	}
+	if err != nil {
+		logger.Error("Backup failed", "error", err)
+		exitFailure(report, err)
+	}
+
+	logger.Info("Backup completed successfully")
+	printResult(report, report.Summary())
+}
+
+// runVerifyBackup compares the directories of sourceDir with their backup in bucket, lists those
+// whose backup is stale or missing and exits with status 1 if there are any
+func runVerifyBackup(ctx context.Context, backup pics.Backup, sourceDir, bucket string, opts pics.BackupOptions) {
+	logger.Info("Starting backup verification", "source", sourceDir, "bucket", bucket, "mode", opts.Mode, "max_concurrent", opts.MaxConcurrent, "staging_dir", opts.StagingDir, "exclude_dirs", opts.ExcludeDirs, "sha256", opts.SHA256Checksums, "max_archive_size", opts.MaxArchiveSize)
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	report, err := backup.VerifyBackups(ctx, sourceDir, bucket, opts)
+	stopProgress()
+	if err != nil {
+		logger.Error("Backup verification failed", "error", err)
+		os.Exit(1)
+	}
+
+	if !report.OK() {
+		printBackupVerifyReport(newRenderer(), report)
+	}
+	fmt.Print(report.Summary())
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// printBackupVerifyReport writes a table of the directories whose backup is stale or missing,
+// followed by a blank line
+func printBackupVerifyReport(out *output.Renderer, report pics.BackupVerifyReport) {
+	table := output.Table{
+		Header: []string{i18n.T("verify_backup.directory"), i18n.T("verify_backup.backup")},
+	}
+	for _, dir := range report.Stale {
+		table.Rows = append(table.Rows, []output.Cell{output.Text(dir), {Text: i18n.T("verify_backup.stale"), Colour: output.Yellow}})
+	}
+	for _, dir := range report.Missing {
+		table.Rows = append(table.Rows, []output.Cell{output.Text(dir), {Text: i18n.T("verify_backup.missing"), Colour: output.Red}})
+	}
+	out.Table(table)
+	out.Line("")
+}
+
+// partialFailureStatus is the exit status of backups, uploads and restores that completed some
+// directories but failed others, and of watches some of whose imports failed, so scheduled runs
+// can tell them from runs that failed outright
+const partialFailureStatus = 2
+
+// exitPartialFailure prints a table of the failures of a run, whose items header names, and the
+// summary of the run, or report as JSON with --json, and exits with partialFailureStatus
+func exitPartialFailure(header string, failures []pics.ItemFailure, report any, summary pics.Summary) {
+	if resultJSON {
+		printResult(report, summary)
+		os.Exit(partialFailureStatus)
+	}
+	out := newRenderer()
+	table := output.Table{Header: []string{header, i18n.T("failed.error")}}
+	for _, failure := range failures {
+		table.Rows = append(table.Rows, []output.Cell{output.Text(failure.Item), {Text: failure.Err.Error(), Colour: output.Red}})
+	}
+	out.Table(table)
+	out.Line("")
+	fmt.Print(summary)
+	os.Exit(partialFailureStatus)
+}
+
+// exitFailure exits with status 1 after a run failed with err. With --json, its report is printed
+// first with the error, so scripts get a result from every run.
+func exitFailure(report any, err error) {
+	if resultJSON {
+		printJSON(failedResult{report: report, err: err})
+	}
+	os.Exit(1)
+}
+
+// failedResult is the report of a failed run, written as JSON with its error
+type failedResult struct {
+	report any
+	err    error
+}
+
+// MarshalJSON writes the fields of the report with "error" set to the error of the run. A
+// report that isn't a JSON object is written as "report" instead.
+func (r failedResult) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(r.report)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		fields = map[string]json.RawMessage{"report": data}
+	}
+	message, err := json.Marshal(r.err.Error())
+	if err != nil {
+		return nil, err
+	}
+	fields["error"] = message
+	return json.Marshal(fields)
+}
+
+// printResult prints the summary of a run, or its report as JSON with --json
+func printResult(report any, summary pics.Summary) {
+	if !resultJSON {
+		fmt.Print(summary)
+		return
+	}
+	printJSON(report)
+}
+
+// printJSON prints the result of a run as indented JSON, exiting if it can't be written
+func printJSON(result any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		logger.Error("Failed to write result", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runUploadOnly uploads the archives kept in the --upload-only staging directory to bucket with
+// storage class class
+func runUploadOnly(bucket string, class pics.StorageClass) {
+	ctx, cancel := commandContext()
+	defer cancel()
+	backup, err := pics.NewS3Backup(ctx, s3ConfigFlags())
+	if err != nil {
+		logger.Error("Failed to initialise backup", "error", err)
+		os.Exit(1)
+	}
+
+	opts := pics.DefaultBackupOptions()
+	opts.MaxConcurrent = maxConcurrent
+	opts.SHA256Checksums = useSHA256
+	opts.ObjectTimeout = objectTimeout
+	opts.ObjectTags = objectTags
+	opts.StorageClass = class
+	opts.BandwidthLimit = bandwidthLimitFlag()
+	opts.InventoryCacheDir = inventoryCacheDir()
+
+	logger.Info("Starting upload", "staging_dir", uploadOnly, "bucket", bucket, "max_concurrent", maxConcurrent, "sha256", useSHA256, "tags", objectTags, "storage_class", class, "bandwidth_limit", bandwidth)
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	report, err := backup.UploadArchives(ctx, uploadOnly, bucket, opts)
+	stopProgress()
+	if len(report.Failed) > 0 {
+		logger.Error("Upload failed for some directories", "failed", len(report.Failed), "error", err)
+		exitPartialFailure(i18n.T("failed.directory"), report.Failed, report, report.Summary())
+	}
+	if err != nil {
+		logger.Error("Upload failed", "error", err)
+		exitFailure(report, err)
+	}
+
+	logger.Info("Upload completed successfully")
+	printResult(report, report.Summary())
+}
+
+// backupArgs requires SOURCE_DIR alone with --archive-only, BUCKET alone with --upload-only,
+// and both otherwise
+func backupArgs(cmd *cobra.Command, args []string) error {
+	if archiveOnly || uploadOnly != "" {
+		return cobra.ExactArgs(1)(cmd, args)
+	}
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
+func runRestore(cmd *cobra.Command, args []string) {
+	warnOrphanedSessions()
+	bucket := args[0]
+	checkBucketPath(bucket)
+	targetDir := args[1]
+
+	filter := restoreFilterFlags()
+
+	// Validate target directory exists
+	if info, err := os.Stat(targetDir); err != nil {
+		logger.Error("Target directory does not exist", "directory", targetDir, "error", err)
+		os.Exit(1)
+	} else if !info.IsDir() {
+		logger.Error("Target path is not a directory", "path", targetDir)
+		os.Exit(1)
+	}
+
+	// Create backup instance
+	ctx, cancel := commandContext()
+	defer cancel()
+	backup, err := pics.NewS3Backup(ctx, s3ConfigFlags())
+	if err != nil {
+		logger.Error("Failed to initialise backup", "error", err)
+		os.Exit(1)
+	}
+
+	opts := pics.DefaultRestoreOptions()
+	opts.Filter = filter
+	opts.MaxConcurrent = maxConcurrent
+	opts.StagingDir = stagingDir
+	opts.VerifySHA256 = useSHA256
+	opts.OnConflict = restorePolicyFlag(cmd)
+	opts.RefreshInventory = refreshList
+	opts.ObjectTimeout = objectTimeout
+	opts.WaitForRestore = waitRestore
+	opts.BandwidthLimit = bandwidthLimitFlag()
+	opts.InventoryCacheDir = inventoryCacheDir()
+	if maxExtract != "" {
+		size, err := parseByteSize(maxExtract)
+		if err != nil {
+			logger.Error("Invalid maximum extracted size (expected e.g. 50GB)", "value", maxExtract, "error", err)
+			os.Exit(1)
+		}
+		opts.MaxExtractSize = size
+	}
+	if owner != "" {
+		fileOwner, err := parseOwner(owner)
+		if err != nil {
+			logger.Error("Invalid owner (expected UID:GID, e.g. 1000:100)", "value", owner, "error", err)
+			os.Exit(1)
+		}
+		opts.Owner = &fileOwner
+	} else if chownToMe {
+		fileOwner, err := pics.InvokingUser()
+		if err != nil {
+			logger.Error("Failed to determine invoking user", "error", err)
+			os.Exit(1)
+		}
+		opts.Owner = &fileOwner
+	}
+
+	if dryRun {
+		listings, err := backup.ListBackups(ctx, bucket, opts)
+		if err != nil {
+			logger.Error("Failed to list backups", "error", err)
+			os.Exit(1)
+		}
+		printRestorePlan(newRenderer(), listings, targetDir, opts.OnConflict)
+		return
+	}
+
+	logger.Info("Starting restore", "bucket", bucket, "target", targetDir, "max_concurrent", maxConcurrent, "filter", filter, "staging_dir", stagingDir, "verify_sha256", useSHA256, "owner", owner, "chown_to_me", chownToMe, "on_conflict", opts.OnConflict, "refresh", refreshList, "wait_for_restore", waitRestore, "bandwidth_limit", bandwidth)
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	report, err := backup.RestoreDirectories(ctx, bucket, targetDir, opts)
+	stopProgress()
+	if len(report.Failed) > 0 {
+		logger.Error("Restore failed for some archives", "failed", len(report.Failed), "error", err)
+		exitPartialFailure(i18n.T("failed.archive"), report.Failed, report, report.Summary())
+	}
+	if err != nil {
+		logger.Error("Restore failed", "error", err)
+		exitFailure(report, err)
+	}
+
+	logger.Info("Restore completed successfully")
+	printResult(report, report.Summary())
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	bucket := args[0]
+	checkBucketPath(bucket)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	backup, err := pics.NewS3Backup(ctx, s3ConfigFlags())
+	if err != nil {
+		logger.Error("Failed to initialise backup", "error", err)
+		os.Exit(1)
+	}
+
+	opts := pics.DefaultRestoreOptions()
+	opts.Filter = restoreFilterFlags()
+	opts.MaxConcurrent = maxConcurrent
+	opts.RefreshInventory = refreshList
+	opts.ObjectTimeout = objectTimeout
+	opts.InventoryCacheDir = inventoryCacheDir()
+
+	listings, err := backup.ListBackups(ctx, bucket, opts)
+	if err != nil {
+		logger.Error("Failed to list backups", "error", err)
+		os.Exit(1)
+	}
+	printBackups(newRenderer(), listings)
+}
+
+// backupTable returns a table with the size, number of images and videos and last upload of
+// each backed up directory
+func backupTable(listings []pics.BackupListing) output.Table {
+	table := output.Table{
+		Header:       []string{i18n.T("list.directory"), i18n.T("list.images"), i18n.T("list.videos"), i18n.T("list.size"), i18n.T("list.last_backup")},
+		RightAligned: []bool{false, true, true, true, false},
+	}
+	for _, listing := range listings {
+		lastBackup := "-"
+		if !listing.LastModified.IsZero() {
+			lastBackup = listing.LastModified.Local().Format("2006-01-02 15:04")
+		}
+		table.Rows = append(table.Rows, []output.Cell{
+			output.Text(listing.Directory),
+			output.Text(strconv.Itoa(listing.Images)),
+			output.Text(strconv.Itoa(listing.Videos)),
+			output.Text(pics.FormatByteSize(listing.Size)),
+			output.Text(lastBackup),
+		})
+	}
+	return table
+}
+
+// printBackups writes the backed up directories followed by their totals
+func printBackups(out *output.Renderer, listings []pics.BackupListing) {
+	if len(listings) == 0 {
+		out.Line(i18n.T("list.none"))
+		return
+	}
+	out.Table(backupTable(listings))
+	out.Line("")
+
+	var images, videos int
+	var size int64
+	for _, listing := range listings {
+		images += listing.Images
+		videos += listing.Videos
+		size += listing.Size
+	}
+	out.Line(i18n.T("list.total", len(listings), images, videos, pics.FormatByteSize(size)))
+}
+
+// printRestorePlan writes the directories a restore to targetDir would download, marking those
+// that already exist there with what policy does to them
+func printRestorePlan(out *output.Renderer, listings []pics.BackupListing, targetDir string, policy pics.RestorePolicy) {
+	if len(listings) == 0 {
+		out.Line(i18n.T("list.none"))
+		return
+	}
+	table := backupTable(listings)
+	table.Header = append(table.Header, i18n.T("restore.plan.action"))
+	var size int64
+	downloads, existing := 0, 0
+	for i, listing := range listings {
+		action := output.Cell{Text: i18n.T("restore.plan.new"), Colour: output.Green}
+		skipped := false
+		if _, err := os.Stat(filepath.Join(targetDir, listing.Directory)); err == nil {
+			existing++
+			switch policy {
+			case pics.RestorePolicyMerge:
+				action = output.Cell{Text: i18n.T("restore.plan.merge"), Colour: output.Yellow}
+			case pics.RestorePolicyOverwrite:
+				action = output.Cell{Text: i18n.T("restore.plan.overwrite"), Colour: output.Yellow}
+			case pics.RestorePolicySkip:
+				action = output.Text(i18n.T("restore.plan.skip"))
+				skipped = true
+			default:
+				action = output.Cell{Text: i18n.T("restore.plan.exists"), Colour: output.Red}
+			}
+		}
+		if !skipped {
+			downloads++
+			size += listing.Size
+		}
+		table.Rows[i] = append(table.Rows[i], action)
+	}
+	out.Table(table)
+	out.Line("")
+	out.Line(i18n.T("restore.plan.total", downloads, pics.FormatByteSize(size)))
+	if existing > 0 && (policy == pics.RestorePolicyFail || policy == "") {
+		out.Line(i18n.T("restore.plan.hint", existing))
+	}
+}
+
+func runSync(cmd *cobra.Command, args []string) {
+	warnOrphanedSessions()
+	bucket := args[0]
+	checkBucketPath(bucket)
+	targetDir := args[1]
+
+	if info, err := os.Stat(targetDir); err == nil && !info.IsDir() {
+		logger.Error("Target path is not a directory", "path", targetDir)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	backup, err := pics.NewS3Backup(ctx, s3ConfigFlags())
+	if err != nil {
+		logger.Error("Failed to initialise backup", "error", err)
+		os.Exit(1)
+	}
+
+	opts := pics.DefaultSyncOptions()
+	opts.MaxConcurrent = maxConcurrent
+	opts.StagingDir = stagingDir
+	opts.MergeConflicts = mergeConflict
+	opts.ObjectTags = objectTags
+	opts.InventoryCacheDir = inventoryCacheDir()
+	if maxArchive != "" {
+		size, err := parseByteSize(maxArchive)
+		if err != nil {
+			logger.Error("Invalid maximum archive size (expected e.g. 10GB)", "value", maxArchive, "error", err)
+			os.Exit(1)
+		}
+		opts.MaxArchiveSize = size
+	}
+
+	logger.Info("Starting sync", "bucket", bucket, "target", targetDir, "max_concurrent", maxConcurrent, "staging_dir", stagingDir, "max_archive_size", opts.MaxArchiveSize, "merge_conflicts", mergeConflict, "tags", objectTags)
+	report, err := backup.SyncDirectories(ctx, bucket, targetDir, opts)
+	if err != nil {
+		logger.Error("Sync failed", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Sync completed successfully")
+	fmt.Print(report.Summary())
+}
+
+func runScrub(cmd *cobra.Command, args []string) {
+	targetDir := args[0]
+
+	ctx := context.Background()
+	var backup pics.Backup
+	if repairBucket != "" {
+		checkBucketPath(repairBucket)
+		var err error
+		backup, err = pics.NewS3Backup(ctx, s3ConfigFlags())
+		if err != nil {
+			logger.Error("Failed to initialise backup", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := pics.DefaultScrubOptions()
+	opts.MaxConcurrent = maxConcurrent
+	opts.Bucket = repairBucket
+	opts.StagingDir = stagingDir
+	opts.Quarantine = quarantine
+	opts.Interval = scrubInterval
+
+	logger.Info("Starting scrub", "target", targetDir, "bucket", repairBucket, "quarantine", quarantine, "interval", scrubInterval, "max_concurrent", maxConcurrent)
+	report, err := pics.NewLibraryScrubber(backup).Scrub(ctx, targetDir, opts)
+	if err != nil {
+		logger.Error("Scrub failed", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(report.Summary())
+	if len(report.Corrupted) > 0 || len(report.Quarantined) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	dirA := args[0]
+	dirB := args[1]
+
+	report, err := pics.NewLibraryDiff().Diff(dirA, dirB)
+	if err != nil {
+		logger.Error("Diff failed", "error", err)
+		os.Exit(1)
+	}
+
+	if report.IsEmpty() {
+		logger.Info("Libraries are identical", "a", dirA, "b", dirB)
+		return
+	}
+
+	printDiffReport(newRenderer(), report)
+	os.Exit(1)
+}
+
+// printDiffReport writes a table with the number of files added, removed and changed in each
+// date directory, followed by the files themselves
+func printDiffReport(out *output.Renderer, report pics.DiffReport) {
+	counts := output.Table{
+		Header:       []string{i18n.T("diff.directory"), i18n.T("diff.added"), i18n.T("diff.removed"), i18n.T("diff.changed")},
+		RightAligned: []bool{false, true, true, true},
+	}
+	var files output.Table
+	for _, dir := range report.Directories {
+		counts.Rows = append(counts.Rows, []output.Cell{
+			output.Text(dir.Directory),
+			output.Text(strconv.Itoa(len(dir.Added))),
+			output.Text(strconv.Itoa(len(dir.Removed))),
+			output.Text(strconv.Itoa(len(dir.Changed))),
+		})
+		for _, change := range []struct {
+			marker string
+			colour output.Colour
+			files  []string
+		}{
+			{"+", output.Green, dir.Added},
+			{"-", output.Red, dir.Removed},
+			{"~", output.Yellow, dir.Changed},
+		} {
+			for _, file := range change.files {
+				files.Rows = append(files.Rows, []output.Cell{{Text: change.marker, Colour: change.colour}, output.Text(dir.Directory), output.Text(file)})
+			}
+		}
+	}
+	out.Table(counts)
+	out.Line("")
+	out.Table(files)
+}
+
+func runVerify(cmd *cobra.Command, args []string) {
+	targetDir := args[0]
+
+	report, err := pics.NewLibraryVerifier().Verify(targetDir)
+	if err != nil {
+		logger.Error("Verify failed", "error", err)
+		os.Exit(1)
+	}
+
+	if verifyJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logger.Error("Failed to write report", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		printVerifyReport(newRenderer(), report)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// printVerifyReport writes a table of the problems found in a library, followed by their total
+func printVerifyReport(out *output.Renderer, report pics.VerifyReport) {
+	if report.OK() {
+		out.Line(out.Paint(output.Green, i18n.T("verify.ok", report.Directories, report.Files)))
+		return
+	}
+	problems := output.Table{
+		Header: []string{i18n.T("verify.problem"), i18n.T("verify.path"), i18n.T("verify.expected")},
+	}
+	for _, problem := range report.Problems {
+		row := []output.Cell{
+			{Text: i18n.T("verify.kind." + string(problem.Kind)), Colour: output.Red},
+			output.Text(problem.Path),
+		}
+		// Rows of problems with nothing expected end at the path, so they aren't padded
+		if problem.Detail != "" {
+			row = append(row, output.Text(problem.Detail))
+		}
+		problems.Rows = append(problems.Rows, row)
+	}
+	out.Table(problems)
+	out.Line("")
+	out.Line(i18n.T("verify.total", len(report.Problems), report.Directories, report.Files))
+}
+
+func runCheckManifest(cmd *cobra.Command, args []string) {
+	dir := args[0]
+
+	report, err := pics.NewManifestChecker().Check(dir)
+	if err != nil {
+		logger.Error("Check failed", "error", err)
+		os.Exit(1)
+	}
+
+	if manifestJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logger.Error("Failed to write report", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		printManifestReport(newRenderer(), report)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// printManifestReport writes a table of the files that differ from their manifests, followed by
+// their total
+func printManifestReport(out *output.Renderer, report pics.ManifestReport) {
+	if len(report.Problems) == 0 {
+		out.Line(out.Paint(output.Green, i18n.T("manifest.ok", report.Directories, report.Files)))
+		return
+	}
+	problems := output.Table{
+		Header: []string{i18n.T("manifest.problem"), i18n.T("manifest.path")},
+	}
+	for _, problem := range report.Problems {
+		// Unlisted files aren't known to be damaged, so they aren't shown as errors
+		colour := output.Red
+		if problem.Kind == pics.ManifestUnlisted {
+			colour = output.Yellow
+		}
+		problems.Rows = append(problems.Rows, []output.Cell{
+			{Text: i18n.T("manifest.kind." + string(problem.Kind)), Colour: colour},
+			output.Text(problem.Path),
+		})
+	}
+	out.Table(problems)
+	out.Line("")
+	out.Line(i18n.T("manifest.total", len(report.Problems), report.Directories, report.Files))
+}
+
+func runStats(cmd *cobra.Command, args []string) {
+	targetDir := args[0]
+
+	stats, err := pics.NewFileStats().GetLibraryStats(targetDir)
+	if err != nil {
+		logger.Error("Stats failed", "error", err)
+		os.Exit(1)
+	}
+
+	if statsJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			logger.Error("Failed to write report", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	printLibraryStats(newRenderer(), stats)
+}
+
+// printLibraryStats prints the media of a library by year and month, its totals and its largest
+// directories
+func printLibraryStats(out *output.Renderer, stats pics.LibraryStats) {
+	if len(stats.Years) == 0 {
+		out.Line(i18n.T("stats.none"))
+		return
+	}
+	periods := output.Table{
+		Header:       []string{i18n.T("stats.period"), i18n.T("stats.directories"), i18n.T("stats.photos"), i18n.T("stats.videos"), i18n.T("stats.size")},
+		RightAligned: []bool{false, true, true, true, true},
+	}
+	for _, year := range stats.Years {
+		periods.Rows = append(periods.Rows, statsRow(output.Cell{Text: strconv.Itoa(year.Year), Colour: output.Bold}, year.Directories, year.Images, year.Videos))
+		for _, month := range year.Months {
+			periods.Rows = append(periods.Rows, statsRow(output.Text(fmt.Sprintf("  %d-%02d", year.Year, int(month.Month))), month.Directories, month.Images, month.Videos))
+		}
+	}
+	out.Table(periods)
+	out.Line("")
+
+	images, videos := stats.Images(), stats.Videos()
+	out.Line(i18n.T("stats.total", images.Files, pics.FormatByteSize(images.Bytes), videos.Files, pics.FormatByteSize(videos.Bytes)))
+	if stats.JPEGs.Files > 0 {
+		out.Line(i18n.T("stats.average_jpeg", pics.FormatByteSize(stats.AverageJPEGSize()), stats.JPEGs.Files))
+	}
+	out.Line(i18n.T("stats.named", stats.Named, stats.Unnamed))
+	out.Line("")
+
+	out.Line(i18n.T("stats.largest", len(stats.Largest)))
+	largest := output.Table{
+		Header:       []string{i18n.T("stats.directory"), i18n.T("stats.photos"), i18n.T("stats.videos"), i18n.T("stats.size")},
+		RightAligned: []bool{false, true, true, true},
+	}
+	for _, dir := range stats.Largest {
+		largest.Rows = append(largest.Rows, []output.Cell{
+			output.Text(dir.Name),
+			output.Text(strconv.Itoa(dir.Images.Files)),
+			output.Text(strconv.Itoa(dir.Videos.Files)),
+			output.Text(pics.FormatByteSize(dir.Bytes())),
+		})
+	}
+	out.Table(largest)
+}
+
+// statsRow returns the row of a year or month of the library stats
+func statsRow(period output.Cell, directories int, images, videos pics.GroupStats) []output.Cell {
+	return []output.Cell{
+		period,
+		output.Text(strconv.Itoa(directories)),
+		output.Text(strconv.Itoa(images.Files)),
+		output.Text(strconv.Itoa(videos.Files)),
+		output.Text(pics.FormatByteSize(images.Bytes + videos.Bytes)),
+	}
+}
+
+func runMerge(cmd *cobra.Command, args []string) {
+	sourceLibrary := args[0]
+	targetLibrary := args[1]
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	logger.Info("Starting merge", "source", sourceLibrary, "target", targetLibrary)
+	opts := pics.DefaultMergeLibrariesOptions()
+	progress, stopProgress := showProgress()
+	defer stopProgress()
+	opts.ProgressChan = progress
+	report, err := pics.NewLibraryMerger().Merge(ctx, sourceLibrary, targetLibrary, opts)
+	stopProgress()
+	if err != nil {
+		logger.Error("Merge failed", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Merge completed successfully")
+	fmt.Print(report.Summary())
+}
+
+func runRestoreNames(cmd *cobra.Command, args []string) {
+	directory := args[0]
+
+	policy, err := pics.ParseNameConflictPolicy(nameConflict)
+	if err != nil {
+		logger.Error("Invalid --on-conflict", "error", err)
+		os.Exit(1)
+	}
+	opts := pics.DefaultRestoreNamesOptions()
+	opts.OnConflict = policy
+	opts.DryRun = dryRun
+
+	// Initialise exiftool for this command
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
+	}
+	defer et.Close()
+
+	report, err := pics.NewNameRestorer(et).RestoreNames(directory, opts)
+	if err != nil {
+		logger.Error("Restoring names failed", "error", err)
+		os.Exit(1)
+	}
+	printRestoreNamesReport(newRenderer(), report, dryRun)
+}
+
+// printRestoreNamesReport prints the names restored, or to restore with dryRun, and the files
+// whose original name is taken
+func printRestoreNamesReport(out *output.Renderer, report pics.RestoreNamesReport, dryRun bool) {
+	if len(report.Restored) > 0 || len(report.Conflicts) > 0 {
+		table := output.Table{
+			Header: []string{i18n.T("restore_names.file"), i18n.T("restore_names.original")},
+		}
+		for _, restored := range report.Restored {
+			table.Rows = append(table.Rows, []output.Cell{output.Text(restored.From), {Text: restored.To, Colour: output.Green}})
+		}
+		for _, conflict := range report.Conflicts {
+			table.Rows = append(table.Rows, []output.Cell{output.Text(conflict.From), {Text: i18n.T("restore_names.taken", conflict.To), Colour: output.Red}})
+		}
+		out.Table(table)
+		out.Line("")
+	}
+	total := "restore_names.total"
+	if dryRun {
+		total = "restore_names.total_dry_run"
+	}
+	out.Line(i18n.T(total, len(report.Restored), len(report.Conflicts), report.WithoutOriginal, report.Unchanged))
+}
+
+func runSearch(cmd *cobra.Command, args []string) {
+	libraryDir := args[0]
+
+	filter := pics.RatingFilter{Op: ">=", Stars: pics.FavouriteRating}
+	if ratingFilter != "" {
+		var err error
+		filter, err = pics.ParseRatingFilter(ratingFilter)
+		if err != nil {
+			logger.Error("Invalid rating (expected e.g. '>=4', 4+ or 5)", "value", ratingFilter, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Initialise exiftool for this command
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
+	}
+	defer et.Close()
+
+	search := pics.NewRatingSearch(pics.NewMetadataReader(et))
+	files, err := search.Search(libraryDir, filter)
+	if err != nil {
+		logger.Error("Search failed", "error", err)
+		os.Exit(1)
+	}
+
+	if exportDir == "" {
+		printRatedFiles(os.Stdout, files)
+		return
+	}
+	if err := search.Export(libraryDir, files, exportDir); err != nil {
+		logger.Error("Export failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Export completed successfully", "files", len(files), "target", exportDir)
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	dir, outDir := args[0], args[1]
+
+	opts, err := pics.ParseExportPreset(exportPreset)
+	if err != nil {
+		logger.Error("Invalid export preset", "value", exportPreset, "error", err)
+		os.Exit(1)
+	}
+	filter := pics.RatingFilter{Op: ">=", Stars: pics.FavouriteRating}
+	if exportAll {
+		// Rejected files have the lowest rating, so every file matches
+		filter = pics.RatingFilter{Op: ">=", Stars: -1}
+	} else if ratingFilter != "" {
+		filter, err = pics.ParseRatingFilter(ratingFilter)
+		if err != nil {
+			logger.Error("Invalid rating (expected e.g. '>=4', 4+ or 5)", "value", ratingFilter, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Initialise exiftool for this command
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
+	}
+	defer et.Close()
+
+	found, err := pics.NewRatingSearch(pics.NewMetadataReader(et)).Search(dir, filter)
+	if err != nil {
+		logger.Error("Search failed", "error", err)
+		os.Exit(1)
+	}
+	files := make([]string, 0, len(found))
+	for _, file := range found {
+		files = append(files, file.Path)
+	}
+
+	logger.Info("Starting export", "source", dir, "target", outDir, "preset", exportPreset, "files", len(files))
+	report, err := pics.NewImageExporter(pics.NewExifWriter(et)).Export(context.Background(), dir, files, outDir, opts)
+	if err != nil {
+		logger.Error("Export failed", "error", err)
+		os.Exit(1)
+	}
+	for _, file := range report.Skipped {
+		logger.Info("Skipped file that can't be exported", "file", file)
+	}
+	logger.Info("Export completed successfully", "exported", report.Exported, "skipped", len(report.Skipped),
+		"size", pics.FormatByteSize(report.ExportedBytes), "target", outDir)
+}
+
+// printRatedFiles writes one line per file: its rating, a tab and its path in the library
+func printRatedFiles(w io.Writer, files []pics.RatedFile) {
+	for _, file := range files {
+		fmt.Fprintf(w, "%d\t%s\n", file.Rating, file.Path)
+	}
+}
+
+// showProgress draws the progress events of a run as a bar at the bottom of the terminal, with
+// the log printed above it. Off a terminal, such as under cron, and with --quiet, the progress of
+// large archives and files is logged instead. It returns the channel to pass as ProgressChan and a function that
+// removes the bar, to call before printing the outcome of the run. The function does nothing once
+// called, so it is also deferred to remove the bar however the run ends.
+func showProgress() (chan<- pics.ProgressEvent, func()) {
+	// Progress is logged at info level, which --quiet leaves out, and --json keeps the bar off stdout
+	if quiet || resultJSON || !output.IsTerminal(os.Stdout) {
+		ch, stop := logTransferProgress()
+		return ch, sync.OnceFunc(stop)
+	}
+	bar := output.NewProgressBar(os.Stdout, output.TerminalWidth())
+	logger.SetConsole(bar)
+	ch := make(chan pics.ProgressEvent, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range ch {
+			bar.Draw(event.Current, event.Total, progressLabel(event))
+		}
+	}()
+	return ch, sync.OnceFunc(func() {
+		close(ch)
+		<-done
+		bar.Clear()
+		logger.SetConsole(os.Stdout)
+	})
+}
+
+// progressLabel describes an event next to the progress bar: its message, the file or directory
+// it is about and, for the bytes of an archive or file, how far its phase has got
+func progressLabel(event pics.ProgressEvent) string {
+	label := event.Message
+	if event.File != "" {
+		label += " · " + filepath.Base(event.File)
+	}
+	if event.Phase != "" && event.TotalBytes > 0 {
+		percent := min(event.Bytes*100/event.TotalBytes, 100)
+		label += " · " + i18n.T("progress.phase", i18n.T("phase."+event.Phase), percent)
+	}
+	return label
+}
+
+// transferLogMinBytes is the size from which the progress of an archive or file is logged;
+// smaller ones are done before it would tell anything
+const transferLogMinBytes = 100 << 20
+
+// transferLogStep is the percentage between two logged progress steps of an archive or file
+const transferLogStep = 10
+
+// logTransferProgress logs how far each large archive or file of a backup or restore has got
+// being archived, uploaded, downloaded or extracted. It returns the channel to pass as
+// ProgressChan and a function that stops logging once the run is over.
+func logTransferProgress() (chan<- pics.ProgressEvent, func()) {
+	ch := make(chan pics.ProgressEvent, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		steps := newTransferSteps()
+		for event := range ch {
+			if percent, ok := steps.next(event); ok {
+				logger.Info("Transfer progress", "item", event.File, "phase", event.Phase, "percent", percent,
+					"bytes", pics.FormatByteSize(event.Bytes), "total", pics.FormatByteSize(event.TotalBytes))
+			}
+		}
+	}()
+	return ch, func() {
+		close(ch)
+		<-done
+	}
+}
+
+// transferSteps tracks the last progress step logged for each phase of each item
+type transferSteps struct {
+	logged map[string]int
+}
+
+// newTransferSteps creates a transferSteps that has logged nothing
+func newTransferSteps() *transferSteps {
+	return &transferSteps{logged: make(map[string]int)}
+}
+
+// next returns the step an event reached, if it is a step of a large item past the last one
+// logged and short of completion, which the backup or restore logs itself
+func (s *transferSteps) next(event pics.ProgressEvent) (int, bool) {
+	if event.Phase == "" || event.TotalBytes < transferLogMinBytes {
+		return 0, false
+	}
+	key := event.File + "\x00" + event.Phase
+	percent := int(min(event.Bytes*100/event.TotalBytes, 100)) / transferLogStep * transferLogStep
+	if percent >= 100 {
+		delete(s.logged, key)
+		return 0, false
+	}
+	if percent <= s.logged[key] {
+		return 0, false
+	}
+	s.logged[key] = percent
+	return percent, true
+}
+
+// commandContext returns the context of a command, which ends after --timeout (0 = never)
+func commandContext() (context.Context, context.CancelFunc) {
+	if runTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	stop := endWhenStuck(runTimeout)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// interruptContext returns a context cancelled by Ctrl-C or SIGTERM, so a command can stop cleanly
+// and remove its temporary files. A second Ctrl-C ends the process at once.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			logger.Warn("Stopping, press Ctrl-C again to quit at once", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// endWhenStuck ends the process if it is still running timeoutGrace after timeout (0 = never),
+// stuck in a call that can't be cancelled, such as a read from a hung network mount or a
+// credential prompt. The returned function stops the timer, and may be called more than once.
+func endWhenStuck(timeout time.Duration) func() {
+	if timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(timeout+timeoutGrace, func() {
+		logger.Error("Still running after the timeout, giving up", "timeout", timeout)
+		os.Exit(1)
+	})
+	return func() { timer.Stop() }
+}
+
+// findOrphanedSessions returns the sessions of interrupted runs in the system temp directory,
+// the --staging-dir and the sessions directories of targetDirs and the --target directories
+func findOrphanedSessions(targetDirs ...string) ([]pics.Session, error) {
+	dirs := []string{os.TempDir(), stagingDir}
+	for _, targetDir := range append(targetDirs, sessionsIn...) {
+		dirs = append(dirs, filepath.Join(targetDir, pics.ParseSessionsDirName))
+	}
+	return pics.FindOrphanedSessions(dirs...)
+}
+
+// warnOrphanedSessions warns about temporary files left by interrupted runs, which take up
+// space until they are recovered, including the parses into targetDirs
+func warnOrphanedSessions(targetDirs ...string) {
+	sessions, err := findOrphanedSessions(targetDirs...)
+	if err != nil {
+		logger.Debug("Failed to look for interrupted runs", "error", err)
+		return
+	}
+	if len(sessions) > 0 {
+		logger.Warn("Found temporary files of interrupted runs, list them with 'pics sessions'", "sessions", len(sessions))
+	}
+}
+
+func runSessions(cmd *cobra.Command, args []string) {
+	sessions, err := findOrphanedSessions()
+	if err != nil {
+		logger.Error("Failed to look for interrupted runs", "error", err)
+		os.Exit(1)
+	}
+	printSessions(newRenderer(), sessions)
+}
+
+// printSessions prints a table of the sessions of interrupted runs
+func printSessions(out *output.Renderer, sessions []pics.Session) {
+	if len(sessions) == 0 {
+		out.Line(i18n.T("sessions.none"))
+		return
+	}
+	table := output.Table{
+		Header: []string{i18n.T("sessions.id"), i18n.T("sessions.kind"), i18n.T("sessions.stage"), i18n.T("sessions.started"), i18n.T("sessions.command")},
+	}
+	for _, session := range sessions {
+		table.Rows = append(table.Rows, []output.Cell{
+			output.Text(session.ID),
+			output.Text(session.Kind),
+			output.Text(session.Stage),
+			output.Text(session.Started.Format("2006-01-02 15:04")),
+			output.Text("pics " + strings.Join(session.Args, " ")),
+		})
+	}
+	out.Table(table)
+	out.Line("")
+	out.Line(i18n.T("sessions.hint"))
+}
+
+func runSessionsRecover(cmd *cobra.Command, args []string) {
+	id := args[0]
+	all, err := findOrphanedSessions()
+	if err != nil {
+		logger.Error("Failed to look for interrupted runs", "error", err)
+		os.Exit(1)
+	}
+	var sessions []pics.Session
+	for _, session := range all {
+		if session.ID == id {
+			sessions = append(sessions, session)
+		}
+	}
+	if len(sessions) == 0 {
+		logger.Error("No interrupted run with this ID, list them with 'pics sessions'", "id", id)
+		os.Exit(1)
+	}
+
+	adoptable := len(sessions) == 1 && sessions[0].Adoptable()
+	switch {
+	case adoptSession && !adoptable:
+		logger.Error("Only a parse interrupted after copying every file can be adopted, use --resume or --clean", "id", id)
+		os.Exit(1)
+	case adoptSession || resumeSession && adoptable:
+		recoverByAdopting(sessions[0])
+	case cleanSession:
+		cleanSessions(sessions)
+	case resumeSession && len(sessions) == 1 && sessions[0].Resumable():
+		rerunSession(sessions[0], "--resume")
+	case resumeSession:
+		cleanSessions(sessions)
+		rerunSession(sessions[0])
+	}
+}
+
+// recoverByAdopting organises the files of an interrupted parse into its target directory
+func recoverByAdopting(session pics.Session) {
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		logger.Error("Failed to initialise exiftool", "error", err)
+		os.Exit(1)
+	}
+	defer et.Close()
+
+	parser := pics.NewMediaParser("", "", pics.NewFileOrganiser(et), pics.NewExifWriter(et), pics.NewMetadataReader(et))
+	ctx, stop := interruptContext()
+	defer stop()
+	report, err := parser.Adopt(ctx, session, nil)
+	if err != nil {
+		logger.Error("Adopting the interrupted parse failed", "id", session.ID, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Interrupted parse adopted", "id", session.ID, "target", session.Target, "files", report.Imported)
+	logReviewFiles(report, session.Target)
+	fmt.Print(report.Summary())
+}
+
+// cleanSessions removes the temporary files of the sessions
+func cleanSessions(sessions []pics.Session) {
+	for _, session := range sessions {
+		if err := pics.CleanSession(session); err != nil {
+			logger.Error("Failed to remove temporary files", "id", session.ID, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Removed temporary files of interrupted run", "id", session.ID, "dir", session.Dir)
+	}
+}
+
+// rerunSession runs the command of an interrupted session again with extraArgs, exiting with its
+// status. Backups skip archives already in the bucket and restores resume from their progress
+// files; parses resume from their temporary files when given --resume.
+func rerunSession(session pics.Session, extraArgs ...string) {
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Error("Failed to find the pics executable", "error", err)
+		os.Exit(1)
+	}
+	args := append(slices.Clone(session.Args), extraArgs...)
+	logger.Info("Running the interrupted command again", "command", "pics "+strings.Join(args, " "))
+	rerun := exec.Command(executable, args...)
+	rerun.Stdin, rerun.Stdout, rerun.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := rerun.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		logger.Error("Failed to run the interrupted command again", "error", err)
+		os.Exit(1)
+	}
+}
+
+func runTrash(cmd *cobra.Command, args []string) {
+	runs, err := pics.ListTrash(args[0])
+	if err != nil {
+		logger.Error("Failed to read the trash", "error", err)
+		os.Exit(1)
+	}
+	printTrash(newRenderer(), runs)
+}
+
+// printTrash prints a table of the runs in a trash
+func printTrash(out *output.Renderer, runs []pics.TrashRun) {
+	if len(runs) == 0 {
+		out.Line(i18n.T("trash.none"))
+		return
+	}
+	table := output.Table{
+		Header: []string{i18n.T("trash.run"), i18n.T("trash.files"), i18n.T("trash.size")},
+	}
+	for _, run := range runs {
+		table.Rows = append(table.Rows, []output.Cell{
+			output.Text(run.Name),
+			output.Text(strconv.Itoa(run.Files)),
+			output.Text(pics.FormatByteSize(run.Bytes)),
+		})
+	}
+	out.Table(table)
+	out.Line("")
+	out.Line(i18n.T("trash.hint"))
+}
+
+func runTrashEmpty(cmd *cobra.Command, args []string) {
+	if trashAge < 0 {
+		logger.Error("Invalid age (expected a duration, e.g. 720h, or 0)", "value", trashAge)
+		os.Exit(1)
+	}
+	var cutoff time.Time
+	if trashAge > 0 {
+		cutoff = time.Now().Add(-trashAge)
+	}
+	emptied, err := pics.EmptyTrash(args[0], cutoff)
+	if err != nil {
+		logger.Error("Failed to empty the trash", "error", err)
+		os.Exit(1)
+	}
+	var files int
+	var size int64
+	for _, run := range emptied {
+		files += run.Files
+		size += run.Bytes
+	}
+	logger.Info("Trash emptied", "dir", args[0], "runs", len(emptied), "files", files, "size", pics.FormatByteSize(size))
+}
+
+func runTrashRestore(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	var run string
+	if len(args) > 1 {
+		run = args[1]
+	} else {
+		runs, err := pics.ListTrash(dir)
+		if err != nil {
+			logger.Error("Failed to read the trash", "error", err)
+			os.Exit(1)
+		}
+		if len(runs) == 0 {
+			logger.Error("Nothing to restore, the trash is empty", "dir", dir)
+			os.Exit(1)
+		}
+		run = runs[len(runs)-1].Name
+	}
+	restored, kept, err := pics.RestoreTrash(dir, run)
+	if err != nil {
+		logger.Error("Failed to restore from the trash", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Files restored from the trash", "run", run, "restored", len(restored), "kept", len(kept))
+	if len(kept) > 0 {
+		os.Exit(1)
+	}
+}
+
+// applyOfflineMode disables network access for the rest of the command when --offline is set
+func applyOfflineMode() {
+	if offlineMode {
+		pics.SetOffline(true)
+		logger.Info("Offline mode enabled, network access is disabled")
+	}
+}
+
+// parseByteSize parses a size such as "1.5MB", "800KB" or "2000000" into bytes.
+// Units are binary (1KB = 1024 bytes) and case-insensitive; no unit means bytes.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(number * multiplier), nil
+}
+
+// parseBandwidth parses a rate in bytes per second such as "10MB/s" or "500KB", with or without
+// the "/s"
+func parseBandwidth(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(value), "/s") {
+		value = value[:len(value)-2]
+	}
+	rate, err := parseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth: %s", s)
+	}
+	return rate, nil
+}
+
+// parseBitrate parses a bitrate in bits per second such as "4M", "2500k" or "800000". Bitrates
+// use decimal units, as ffmpeg does.
+func parseBitrate(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	if strings.HasSuffix(value, "M") {
+		value, multiplier = strings.TrimSuffix(value, "M"), 1e6
+	} else if strings.HasSuffix(value, "K") {
+		value, multiplier = strings.TrimSuffix(value, "K"), 1e3
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid bitrate: %s", s)
+	}
+	return int64(number * multiplier), nil
+}
+
+// parseOwner parses a numeric owner such as "1000:100" into a user and group ID.
+func parseOwner(s string) (pics.FileOwner, error) {
+	uidPart, gidPart, found := strings.Cut(s, ":")
+	if !found {
+		return pics.FileOwner{}, fmt.Errorf("invalid owner: %s", s)
+	}
+	uid, err := strconv.Atoi(uidPart)
+	if err != nil || uid < 0 {
+		return pics.FileOwner{}, fmt.Errorf("invalid user ID: %s", uidPart)
+	}
+	gid, err := strconv.Atoi(gidPart)
+	if err != nil || gid < 0 {
+		return pics.FileOwner{}, fmt.Errorf("invalid group ID: %s", gidPart)
+	}
+	return pics.FileOwner{UID: uid, GID: gid}, nil
 }
 
 // parseYearMonth parses a date string in format "YYYY" or "MM/YYYY".
@@ -1,7 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/acm19/pics/apps/cli/output"
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/pics"
 )
 
 func TestParseYearMonth(t *testing.T) {
@@ -132,3 +145,878 @@ func TestParseYearMonth(t *testing.T) {
 		})
 	}
 }
+
+func TestPrintDiffReport(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.DiffReport{
+		Directories: []pics.DirectoryDiff{
+			{
+				Directory: "2023 06 June 15",
+				Added:     []string{"videos/new.mov"},
+				Removed:   []string{"old.jpg"},
+				Changed:   []string{"edited.jpg"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	printDiffReport(output.New(&buf, false), report)
+
+	expected := "Directory        Added  Removed  Changed\n" +
+		"2023 06 June 15      1        1        1\n" +
+		"\n" +
+		"+  2023 06 June 15  videos/new.mov\n" +
+		"-  2023 06 June 15  old.jpg\n" +
+		"~  2023 06 June 15  edited.jpg\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestPrintDiffReport_Spanish(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.Spanish)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.DiffReport{
+		Directories: []pics.DirectoryDiff{
+			{Directory: "2023 06 June 15", Added: []string{"new.jpg"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	printDiffReport(output.New(&buf, false), report)
+
+	expected := "Directorio       Añadidos  Eliminados  Modificados\n" +
+		"2023 06 June 15         1           0            0\n" +
+		"\n" +
+		"+  2023 06 June 15  new.jpg\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestPrintVerifyReport(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.VerifyReport{
+		Directories: 2,
+		Files:       5,
+		Problems: []pics.LibraryProblem{
+			{Kind: pics.ProblemSequenceGap, Path: "2023 06 June 15", Detail: "00003"},
+			{Kind: pics.ProblemEmptyFile, Path: "2023 06 June 15/videos/clip.mov"},
+		},
+	}
+
+	var buf bytes.Buffer
+	printVerifyReport(output.New(&buf, false), report)
+
+	expected := "Problem          Path                             Expected\n" +
+		"missing numbers  2023 06 June 15                  00003\n" +
+		"empty file       2023 06 June 15/videos/clip.mov\n" +
+		"\n" +
+		"2 problems in 2 directories and 5 files\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+
+	buf.Reset()
+	printVerifyReport(output.New(&buf, false), pics.VerifyReport{Directories: 2, Files: 5})
+	if buf.String() != "No problems found in 2 directories and 5 files\n" {
+		t.Errorf("Expected no problems reported, got %q", buf.String())
+	}
+}
+
+func TestPrintManifestReport(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.ManifestReport{
+		Directories: 2,
+		Files:       4,
+		Problems: []pics.ManifestProblem{
+			{Kind: pics.ManifestChanged, Path: "2023 06 June 15/2023_06_June_15_00001.jpg"},
+			{Kind: pics.ManifestUnlisted, Path: "2023 07 July 01/notes.txt"},
+		},
+	}
+
+	var buf bytes.Buffer
+	printManifestReport(output.New(&buf, false), report)
+
+	expected := "Problem          Path\n" +
+		"changed          2023 06 June 15/2023_06_June_15_00001.jpg\n" +
+		"not in manifest  2023 07 July 01/notes.txt\n" +
+		"\n" +
+		"2 problems in 2 directories, 4 files match their checksums\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+
+	buf.Reset()
+	printManifestReport(output.New(&buf, false), pics.ManifestReport{Directories: 2, Files: 4})
+	if buf.String() != "All files match their checksums in 2 directories and 4 files\n" {
+		t.Errorf("Expected no problems reported, got %q", buf.String())
+	}
+}
+
+func TestPrintBackupVerifyReport(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.BackupVerifyReport{
+		Directories: 3,
+		Current:     1,
+		Stale:       []string{"2023 06 June 15"},
+		Missing:     []string{"2023 10 October 01"},
+	}
+
+	var buf bytes.Buffer
+	printBackupVerifyReport(output.New(&buf, false), report)
+
+	expected := "Directory           Backup\n" +
+		"2023 06 June 15     stale\n" +
+		"2023 10 October 01  missing\n" +
+		"\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
+
+func TestPrintRestoreNamesReport(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	report := pics.RestoreNamesReport{
+		Restored:        []pics.RestoredName{{From: "2023_06_June_15_00001.jpg", To: "IMG_0001.JPG"}},
+		Conflicts:       []pics.RestoredName{{From: "2023_06_June_15_00002.jpg", To: "img_0001.jpg"}},
+		WithoutOriginal: 3,
+	}
+
+	var buf bytes.Buffer
+	printRestoreNamesReport(output.New(&buf, false), report, true)
+
+	expected := "File                       Original name\n" +
+		"2023_06_June_15_00001.jpg  IMG_0001.JPG\n" +
+		"2023_06_June_15_00002.jpg  img_0001.jpg (taken)\n" +
+		"\n" +
+		"1 names to restore, 1 taken, 3 files without an original name, 0 already named so\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
+
+func TestPrintLibraryStats(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	stats := pics.LibraryStats{
+		Years: []pics.YearStats{{
+			Year: 2023, Directories: 2, Images: pics.GroupStats{Files: 3, Bytes: 3072}, Videos: pics.GroupStats{Files: 1, Bytes: 1024},
+			Months: []pics.MonthStats{
+				{Month: time.June, Directories: 1, Images: pics.GroupStats{Files: 2, Bytes: 2048}, Videos: pics.GroupStats{Files: 1, Bytes: 1024}},
+				{Month: time.July, Directories: 1, Images: pics.GroupStats{Files: 1, Bytes: 1024}},
+			},
+		}},
+		Named:   1,
+		Unnamed: 1,
+		JPEGs:   pics.GroupStats{Files: 2, Bytes: 2048},
+		Largest: []pics.DirectoryStats{
+			{Name: "2023 06 June 15 Beach", Images: pics.GroupStats{Files: 2, Bytes: 2048}, Videos: pics.GroupStats{Files: 1, Bytes: 1024}},
+			{Name: "2023 07 July 01", Images: pics.GroupStats{Files: 1, Bytes: 1024}},
+		},
+	}
+
+	var buf bytes.Buffer
+	printLibraryStats(output.New(&buf, false), stats)
+
+	expected := "Period     Directories  Photos  Videos   Size\n" +
+		"2023                 2       3       1  4.0KB\n" +
+		"  2023-06            1       2       1  3.0KB\n" +
+		"  2023-07            1       1       0  1.0KB\n" +
+		"\n" +
+		"3 photos (3.0KB) and 1 videos (1.0KB)\n" +
+		"Average JPEG size: 1.0KB over 2 JPEGs\n" +
+		"1 directories named after an event, 1 with the date alone\n" +
+		"\n" +
+		"2 largest directories:\n" +
+		"Directory              Photos  Videos   Size\n" +
+		"2023 06 June 15 Beach       2       1  3.0KB\n" +
+		"2023 07 July 01             1       0  1.0KB\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printLibraryStats(output.New(&buf, false), pics.LibraryStats{})
+	if buf.String() != "No date directories found\n" {
+		t.Errorf("Expected an empty library reported, got %q", buf.String())
+	}
+}
+
+func TestPrintSessions(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	sessions := []pics.Session{
+		{
+			ID:      "20231015-143005-9f2c",
+			Kind:    pics.SessionKindParse,
+			Stage:   pics.SessionStageOrganising,
+			Started: time.Date(2023, 10, 15, 14, 30, 5, 0, time.UTC),
+			Args:    []string{"parse", "/media/card", "/pics"},
+		},
+	}
+
+	var buf bytes.Buffer
+	printSessions(output.New(&buf, false), sessions)
+
+	expected := "ID                    Kind   Stage       Started           Command\n" +
+		"20231015-143005-9f2c  parse  organising  2023-10-15 14:30  pics parse /media/card /pics\n" +
+		"\n" +
+		"Recover them with: pics sessions recover ID --resume, --adopt or --clean\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printSessions(output.New(&buf, false), nil)
+	if buf.String() != "No temporary files of interrupted runs\n" {
+		t.Errorf("Expected no sessions, got %q", buf.String())
+	}
+}
+
+func TestPrintTrash(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	runs := []pics.TrashRun{
+		{Name: "2024-01-02 03-04-05", Files: 12, Bytes: 3 * 1024 * 1024},
+	}
+
+	var buf bytes.Buffer
+	printTrash(output.New(&buf, false), runs)
+
+	expected := "Run                  Files  Size\n" +
+		"2024-01-02 03-04-05  12     " + pics.FormatByteSize(3*1024*1024) + "\n" +
+		"\n" +
+		"Restore a run with: pics trash restore DIR RUN, or free the space with: pics trash empty DIR\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printTrash(output.New(&buf, false), nil)
+	if buf.String() != "The trash is empty\n" {
+		t.Errorf("Expected an empty trash, got %q", buf.String())
+	}
+}
+
+func TestPrintDevices(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	devices := []pics.Device{
+		{Name: "EOS_DIGITAL", Path: "/media/me/EOS_DIGITAL", DCIM: "/media/me/EOS_DIGITAL/DCIM"},
+		{Name: "Pixel_7", Path: "/run/user/1000/gvfs/mtp:host=Pixel_7", DCIM: "/run/user/1000/gvfs/mtp:host=Pixel_7/Internal shared storage/DCIM"},
+	}
+
+	var buf bytes.Buffer
+	printDevices(output.New(&buf, false), devices)
+
+	expected := "Device       DCIM directory\n" +
+		"EOS_DIGITAL  /media/me/EOS_DIGITAL/DCIM\n" +
+		"Pixel_7      /run/user/1000/gvfs/mtp:host=Pixel_7/Internal shared storage/DCIM\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printDevices(output.New(&buf, false), nil)
+	if buf.String() != "No cameras, phones or cards with a DCIM directory found\n" {
+		t.Errorf("Expected no devices, got %q", buf.String())
+	}
+}
+
+func TestPrintBackups(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	listings := []pics.BackupListing{
+		{Directory: "2023 06 June 15", Images: 120, Videos: 3, Size: 3 << 20, LastModified: time.Date(2023, 6, 20, 9, 15, 0, 0, time.Local)},
+		{Directory: "review", Images: 4, Size: 512},
+	}
+
+	var buf bytes.Buffer
+	printBackups(output.New(&buf, false), listings)
+
+	expected := "Directory        Images  Videos   Size  Last backup\n" +
+		"2023 06 June 15     120       3  3.0MB  2023-06-20 09:15\n" +
+		"review                4       0   512B  -\n" +
+		"\n" +
+		"2 directories, 124 images, 3 videos (3.0MB)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printBackups(output.New(&buf, false), nil)
+	if buf.String() != "No backed up directories found\n" {
+		t.Errorf("Expected no backups, got %q", buf.String())
+	}
+}
+
+func TestPrintRestorePlan(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	targetDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(targetDir, "2023 06 June 15"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	listings := []pics.BackupListing{
+		{Directory: "2023 06 June 15", Images: 1, Size: 1024},
+		{Directory: "2023 07 July 01", Videos: 1, Size: 1024},
+	}
+
+	var buf bytes.Buffer
+	printRestorePlan(output.New(&buf, false), listings, targetDir, pics.RestorePolicyFail)
+	expected := "Directory        Images  Videos   Size  Last backup  Restore\n" +
+		"2023 06 June 15       1       0  1.0KB  -            exists\n" +
+		"2023 07 July 01       0       1  1.0KB  -            new\n" +
+		"\n" +
+		"Would download 2 directories (2.0KB), nothing was restored\n" +
+		"1 directories already exist, restore them with --on-conflict merge, skip-existing or overwrite\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	printRestorePlan(output.New(&buf, false), listings, targetDir, pics.RestorePolicyMerge)
+	if !strings.Contains(buf.String(), "merge\n") || strings.Contains(buf.String(), "--on-conflict") {
+		t.Errorf("Expected the existing directory merged, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	printRestorePlan(output.New(&buf, false), listings, targetDir, pics.RestorePolicySkip)
+	if !strings.Contains(buf.String(), "skip\n") || !strings.Contains(buf.String(), "Would download 1 directories (1.0KB)") {
+		t.Errorf("Expected the existing directory skipped and not downloaded, got:\n%s", buf.String())
+	}
+}
+
+func TestTransferSteps(t *testing.T) {
+	steps := newTransferSteps()
+	total := int64(transferLogMinBytes)
+	event := func(file, phase string, bytes int64) pics.ProgressEvent {
+		return pics.ProgressEvent{Stage: "backing up", File: file, Phase: phase, Bytes: bytes, TotalBytes: total}
+	}
+
+	var logged []int
+	for _, bytes := range []int64{total / 20, total / 10, total / 8, total / 2, total / 4, total} {
+		if percent, ok := steps.next(event("2023 06 June 15", pics.PhaseUploading, bytes)); ok {
+			logged = append(logged, percent)
+		}
+	}
+	if !reflect.DeepEqual(logged, []int{10, 50}) {
+		t.Errorf("Expected the steps passed logged once and completion left out, got %v", logged)
+	}
+
+	// Each phase of each item has its own steps, and small items are never logged
+	if percent, ok := steps.next(event("2023 06 June 15", pics.PhaseArchiving, total/2)); !ok || percent != 50 {
+		t.Errorf("Expected 50%% archived logged, got %d (%v)", percent, ok)
+	}
+	small := pics.ProgressEvent{File: "2023 07 July 01", Phase: pics.PhaseUploading, Bytes: 50, TotalBytes: 100}
+	if _, ok := steps.next(small); ok {
+		t.Error("Expected the progress of a small item not logged")
+	}
+	if _, ok := steps.next(pics.ProgressEvent{Stage: "backing up", Current: 1, Total: 2}); ok {
+		t.Error("Expected events without bytes not logged")
+	}
+}
+
+func TestProgressLabel(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	tests := []struct {
+		name     string
+		event    pics.ProgressEvent
+		expected string
+	}{
+		{"message only", pics.ProgressEvent{Message: "Scanning"}, "Scanning"},
+		{"with file", pics.ProgressEvent{Message: "Copying 2 of 5", File: "/photos/IMG_0001.jpg"}, "Copying 2 of 5 · IMG_0001.jpg"},
+		{"with phase", pics.ProgressEvent{Message: "Backing up 1 of 3", File: "2023 06 June 15", Phase: pics.PhaseUploading, Bytes: 25, TotalBytes: 100},
+			"Backing up 1 of 3 · 2023 06 June 15 · uploading 25%"},
+		{"unknown total", pics.ProgressEvent{Message: "Restoring 1 of 3", Phase: pics.PhaseDownloading, Bytes: 25}, "Restoring 1 of 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressLabel(tt.event); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCommandContext(t *testing.T) {
+	t.Cleanup(func() { runTimeout = 0 })
+
+	ctx, cancel := commandContext()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline without --timeout")
+	}
+	cancel()
+
+	runTimeout = time.Hour
+	ctx, cancel = commandContext()
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("Expected a deadline within an hour, got %v", deadline)
+	}
+}
+
+func TestPrintRatedFiles(t *testing.T) {
+	files := []pics.RatedFile{
+		{Path: "2023 06 June 15/2023_06_June_15_00001.jpg", Rating: 5},
+		{Path: "2023 06 June 15/videos/2023_06_June_15_00001.mov", Rating: 4},
+	}
+
+	var buf bytes.Buffer
+	printRatedFiles(&buf, files)
+
+	expected := "5\t2023 06 June 15/2023_06_June_15_00001.jpg\n" +
+		"4\t2023 06 June 15/videos/2023_06_June_15_00001.mov\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestParseImageExtensions(t *testing.T) {
+	exts, err := parseImageExtensions([]string{"CR2", ".nef", "cr2", "jpg", ".webp"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if expected := []string{".cr2", ".nef"}; !reflect.DeepEqual(exts, expected) {
+		t.Errorf("Expected %v, got %v", expected, exts)
+	}
+
+	for _, values := range [][]string{{"mov"}, {"cr2", "tar.gz"}, {""}} {
+		if _, err := parseImageExtensions(values); err == nil {
+			t.Errorf("Expected an error for %v", values)
+		}
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{input: "10MB/s", expected: 10485760},
+		{input: "500kb/S", expected: 512000},
+		{input: "1.5MB", expected: 1572864},
+		{input: "2000", expected: 2000},
+		{input: "/s", expectError: true},
+		{input: "0MB/s", expectError: true},
+		{input: "10Mbit/s", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			rate, err := parseBandwidth(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tt.input, rate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if rate != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, rate)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{input: "1.5MB", expected: 1572864},
+		{input: "800KB", expected: 819200},
+		{input: "800kb", expected: 819200},
+		{input: "2GB", expected: 2147483648},
+		{input: "500 B", expected: 500},
+		{input: "2000000", expected: 2000000},
+		{input: "", expectError: true},
+		{input: "MB", expectError: true},
+		{input: "-1MB", expectError: true},
+		{input: "0", expectError: true},
+		{input: "lots", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := parseByteSize(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tt.input, size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if size != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, size)
+			}
+		})
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{input: "4M", expected: 4000000},
+		{input: "2500k", expected: 2500000},
+		{input: "1.5m", expected: 1500000},
+		{input: "800000", expected: 800000},
+		{input: "", expectError: true},
+		{input: "M", expectError: true},
+		{input: "-1M", expectError: true},
+		{input: "4MB", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			bitrate, err := parseBitrate(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tt.input, bitrate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if bitrate != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, bitrate)
+			}
+		})
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    pics.FileOwner
+		expectError bool
+	}{
+		{input: "1000:100", expected: pics.FileOwner{UID: 1000, GID: 100}},
+		{input: "0:0", expected: pics.FileOwner{UID: 0, GID: 0}},
+		{input: "1000", expectError: true},
+		{input: "alice:users", expectError: true},
+		{input: "1000:", expectError: true},
+		{input: "-1:100", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			owner, err := parseOwner(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %+v", tt.input, owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if owner != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, owner)
+			}
+		})
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    []string
+		expectError bool
+	}{
+		{name: "find output", input: "/media/DCIM/IMG_0001.JPG\n/media/DCIM/IMG_0002.JPG\n", expected: []string{"/media/DCIM/IMG_0001.JPG", "/media/DCIM/IMG_0002.JPG"}},
+		{name: "no trailing newline", input: "a.jpg\nb.jpg", expected: []string{"a.jpg", "b.jpg"}},
+		{name: "windows line endings", input: "a.jpg\r\nb.jpg\r\n", expected: []string{"a.jpg", "b.jpg"}},
+		{name: "blank lines and repeats", input: "a.jpg\n\n./a.jpg\nb.jpg\n", expected: []string{"a.jpg", "b.jpg"}},
+		{name: "spaces in names", input: "Summer trip/IMG 1.jpg\n", expected: []string{"Summer trip/IMG 1.jpg"}},
+		{name: "empty", input: "", expectError: true},
+		{name: "only blank lines", input: "\n\n", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := readFileList(strings.NewReader(tt.input))
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v", files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(files, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, files)
+			}
+		})
+	}
+
+	// A file listed again by its absolute path is a repeat too
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := readFileList(strings.NewReader("a.jpg\n" + filepath.Join(cwd, "a.jpg") + "\n"))
+	if err != nil || !reflect.DeepEqual(files, []string{"a.jpg"}) {
+		t.Errorf("Expected the absolute path dropped as a repeat, got %v (%v)", files, err)
+	}
+}
+
+func TestBackupArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiveOnly bool
+		uploadOnly  string
+		args        []string
+		expectError bool
+	}{
+		{name: "source and bucket", args: []string{"src", "bucket"}},
+		{name: "source only", args: []string{"src"}, expectError: true},
+		{name: "archive only", archiveOnly: true, args: []string{"src"}},
+		{name: "archive only with bucket", archiveOnly: true, args: []string{"src", "bucket"}, expectError: true},
+		{name: "upload only", uploadOnly: "staging", args: []string{"bucket"}},
+		{name: "upload only with source", uploadOnly: "staging", args: []string{"src", "bucket"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archiveOnly, uploadOnly = tt.archiveOnly, tt.uploadOnly
+			t.Cleanup(func() { archiveOnly, uploadOnly = false, "" })
+
+			err := backupArgs(backupCmd, tt.args)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error for args %v", tt.args)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error for args %v, got: %v", tt.args, err)
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		filesFrom   string
+		args        []string
+		expectError bool
+	}{
+		{name: "source and target", args: []string{"src", "target"}},
+		{name: "several sources", args: []string{"phone", "card", "target"}},
+		{name: "target only", args: []string{"target"}, expectError: true},
+		{name: "file list and target", filesFrom: "-", args: []string{"target"}},
+		{name: "file list and source", filesFrom: "-", args: []string{"src", "target"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filesFrom = tt.filesFrom
+			t.Cleanup(func() { filesFrom = "" })
+
+			err := parseArgs(parseCmd, tt.args)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error for args %v", tt.args)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error for args %v, got: %v", tt.args, err)
+			}
+		})
+	}
+}
+
+func TestImportArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		list        bool
+		args        []string
+		expectError bool
+	}{
+		{name: "target", args: []string{"target"}},
+		{name: "no target", args: []string{}, expectError: true},
+		{name: "list", list: true, args: []string{}},
+		{name: "list and target", list: true, args: []string{"target"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listDevices = tt.list
+			t.Cleanup(func() { listDevices = false })
+
+			err := importArgs(importCmd, tt.args)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error for args %v", tt.args)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error for args %v, got: %v", tt.args, err)
+			}
+		})
+	}
+}
+
+func TestLogLevelFromFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		logLevel    string
+		quiet       bool
+		verbose     bool
+		want        slog.Level
+		wantSet     bool
+		expectError bool
+	}{
+		{name: "default"},
+		{name: "quiet", quiet: true, want: slog.LevelError, wantSet: true},
+		{name: "verbose", verbose: true, want: slog.LevelDebug, wantSet: true},
+		{name: "log level", logLevel: "warn", want: slog.LevelWarn, wantSet: true},
+		{name: "quiet and verbose", quiet: true, verbose: true, expectError: true},
+		{name: "log level and quiet", logLevel: "info", quiet: true, expectError: true},
+		{name: "invalid log level", logLevel: "loud", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logLevel, quiet, verbose = tt.logLevel, tt.quiet, tt.verbose
+			t.Cleanup(func() { logLevel, quiet, verbose = "", false, false })
+
+			level, set, err := logLevelFromFlags()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got level %v", level)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if set != tt.wantSet || (set && level != tt.want) {
+				t.Errorf("Expected level %v (set %v), got %v (set %v)", tt.want, tt.wantSet, level, set)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	if jsonOutput() {
+		t.Error("Expected no JSON output without --json")
+	}
+	for _, flag := range []*bool{&resultJSON, &verifyJSON, &statsJSON, &manifestJSON} {
+		*flag = true
+		if !jsonOutput() {
+			t.Error("Expected JSON output with --json on any command")
+		}
+		*flag = false
+	}
+}
+
+func TestFailedResult(t *testing.T) {
+	data, err := json.Marshal(failedResult{report: pics.BackupReport{Directories: 3}, err: errors.New(`bucket "photos" not found`)})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %s: %v", data, err)
+	}
+	if decoded["directories"] != 3.0 || decoded["error"] != `bucket "photos" not found` {
+		t.Errorf("Expected the report with its error, got %v", decoded)
+	}
+
+	// A report without fields is the error alone
+	data, err = json.Marshal(failedResult{report: struct{}{}, err: errors.New("failed")})
+	if err != nil || string(data) != `{"error":"failed"}` {
+		t.Errorf("Expected the error alone, got %s (%v)", data, err)
+	}
+
+	// The error of the run replaces one the report has
+	data, err = json.Marshal(failedResult{report: map[string]string{"error": "partial"}, err: errors.New("failed")})
+	if err != nil || string(data) != `{"error":"failed"}` {
+		t.Errorf("Expected the error of the run once, got %s (%v)", data, err)
+	}
+
+	// A report that isn't an object is kept under its own key
+	for report, want := range map[any]string{
+		"renamed": `{"error":"failed","report":"renamed"}`,
+		nil:       `{"error":"failed","report":null}`,
+	} {
+		data, err = json.Marshal(failedResult{report: report, err: errors.New("failed")})
+		if err != nil || string(data) != want {
+			t.Errorf("Expected %s, got %s (%v)", want, data, err)
+		}
+	}
+	data, err = json.Marshal(failedResult{report: []int{1, 2}, err: errors.New("failed")})
+	if err != nil || string(data) != `{"error":"failed","report":[1,2]}` {
+		t.Errorf("Expected the list under report, got %s (%v)", data, err)
+	}
+}
+
+func TestRenameArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		undo        bool
+		args        []string
+		expectError bool
+	}{
+		{name: "directory and name", args: []string{"dir", "name"}},
+		{name: "directory only", args: []string{"dir"}, expectError: true},
+		{name: "undo directory", undo: true, args: []string{"dir"}},
+		{name: "undo with name", undo: true, args: []string{"dir", "name"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			undoRename = tt.undo
+			t.Cleanup(func() { undoRename = false })
+
+			err := renameArgs(renameCmd, tt.args)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error for args %v", tt.args)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error for args %v, got: %v", tt.args, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package output renders what commands print as their result, such as tables, apart from the
+// log. Colour is only used on terminals, and never with --no-color or NO_COLOR set.
+package output
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Colour is the ANSI SGR code of a text colour or style
+type Colour string
+
+const (
+	// NoColour leaves text as it is
+	NoColour Colour = ""
+	// Bold is used for table headers
+	Bold Colour = "1"
+	// Red marks removed or failed items
+	Red Colour = "31"
+	// Green marks added or healthy items
+	Green Colour = "32"
+	// Yellow marks changed items and warnings
+	Yellow Colour = "33"
+)
+
+// columnGap separates the columns of a table
+const columnGap = "  "
+
+// ColourEnabled reports whether output written to f should be coloured: only when f is a
+// terminal, and neither noColour nor the NO_COLOR environment variable (no-color.org) is set
+func ColourEnabled(f *os.File, noColour bool) bool {
+	if noColour || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+// Renderer writes the output of a command to w
+type Renderer struct {
+	w      io.Writer
+	colour bool
+}
+
+// New creates a Renderer writing to w, in colour if colour is set
+func New(w io.Writer, colour bool) *Renderer {
+	return &Renderer{w: w, colour: colour}
+}
+
+// Paint returns s in colour c, or s itself when colour is off
+func (r *Renderer) Paint(c Colour, s string) string {
+	if !r.colour || c == NoColour || s == "" {
+		return s
+	}
+	return "\x1b[" + string(c) + "m" + s + "\x1b[0m"
+}
+
+// Line writes s followed by a new line
+func (r *Renderer) Line(s string) {
+	io.WriteString(r.w, s+"\n")
+}
+
+// Cell is a value of a table, with the colour it is shown in
+type Cell struct {
+	Text   string
+	Colour Colour
+}
+
+// Text returns a cell without colour
+func Text(s string) Cell {
+	return Cell{Text: s}
+}
+
+// Table is a set of rows printed in aligned columns
+type Table struct {
+	// Header names the columns, shown in bold above the rows (nil = no header)
+	Header []string
+	// RightAligned marks the columns aligned to the right, such as counts and sizes
+	RightAligned []bool
+	// Rows are the cells of each row
+	Rows [][]Cell
+}
+
+// Table writes t with its columns aligned. The last column isn't padded, so lines carry no
+// trailing spaces.
+func (r *Renderer) Table(t Table) {
+	rows := t.Rows
+	if t.Header != nil {
+		header := make([]Cell, len(t.Header))
+		for i, name := range t.Header {
+			header[i] = Cell{Text: name, Colour: Bold}
+		}
+		rows = append([][]Cell{header}, rows...)
+	}
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell.Text))
+		}
+	}
+
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell.Text))
+			text := r.Paint(cell.Colour, cell.Text)
+			switch {
+			case i < len(t.RightAligned) && t.RightAligned[i]:
+				b.WriteString(padding + text)
+			case i == len(row)-1:
+				b.WriteString(text)
+			default:
+				b.WriteString(text + padding)
+			}
+			if i < len(row)-1 {
+				b.WriteString(columnGap)
+			}
+		}
+		r.Line(b.String())
+	}
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderer_Table(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, false).Table(Table{
+		Header:       []string{"Directory", "Files", "Note"},
+		RightAligned: []bool{false, true},
+		Rows: [][]Cell{
+			{Text("2023 06 June 15"), Text("12"), Text("beach")},
+			{Text("2023 12 Diciembre 25"), Text("3"), Text("")},
+		},
+	})
+
+	expected := "Directory             Files  Note\n" +
+		"2023 06 June 15          12  beach\n" +
+		"2023 12 Diciembre 25      3  \n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
+
+func TestRenderer_Table_Colour(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, true).Table(Table{
+		Rows: [][]Cell{
+			{{Text: "+", Colour: Green}, Text("añadido.jpg")},
+			{{Text: "-", Colour: Red}, Text("old.jpg")},
+		},
+	})
+
+	// Colour codes don't count towards the width of a column
+	expected := "\x1b[32m+\x1b[0m  añadido.jpg\n" +
+		"\x1b[31m-\x1b[0m  old.jpg\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
+
+func TestRenderer_Paint(t *testing.T) {
+	if got := New(nil, false).Paint(Red, "x"); got != "x" {
+		t.Errorf("Expected no colour when disabled, got %q", got)
+	}
+	if got := New(nil, true).Paint(NoColour, "x"); got != "x" {
+		t.Errorf("Expected no colour for NoColour, got %q", got)
+	}
+	if got := New(nil, true).Paint(Bold, "x"); got != "\x1b[1mx\x1b[0m" {
+		t.Errorf("Expected bold text, got %q", got)
+	}
+}
+
+func TestColourEnabled(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if ColourEnabled(f, false) {
+		t.Error("Expected no colour when writing to a file")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if ColourEnabled(os.Stdout, false) {
+		t.Error("Expected no colour with NO_COLOR set")
+	}
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// barWidth is the number of cells between the brackets of a progress bar
+	barWidth = 24
+	// barInterval is the least time between two draws of a progress bar, so runs handling many
+	// small files don't spend their time redrawing it
+	barInterval = 100 * time.Millisecond
+	// defaultTerminalWidth is the width of a terminal that doesn't tell its own
+	defaultTerminalWidth = 80
+	// clearLine moves to the start of the line and erases it
+	clearLine = "\r\x1b[K"
+)
+
+// IsTerminal reports whether f is a terminal rather than a file or a pipe
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// TerminalWidth returns the number of columns of the terminal, from the COLUMNS environment
+// variable, or 80 when it isn't set
+func TerminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return defaultTerminalWidth
+}
+
+// ProgressBar draws a line of progress in place at the bottom of a terminal. Other output
+// written through it, such as log lines, is printed above the bar, which is then drawn again.
+// It is safe for concurrent use.
+type ProgressBar struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width int
+	line  string
+	drawn time.Time
+}
+
+// NewProgressBar creates a ProgressBar drawing on w, a terminal width columns wide
+func NewProgressBar(w io.Writer, width int) *ProgressBar {
+	return &ProgressBar{w: w, width: width}
+}
+
+// Draw shows current out of total as a bar followed by label, cut to the width of the terminal.
+// Draws closer together than barInterval only update the line shown by the next one, except the
+// last of a stage.
+func (b *ProgressBar) Draw(current, total int, label string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = progressLine(current, total, label, b.width)
+	if current < total && time.Since(b.drawn) < barInterval {
+		return
+	}
+	b.draw()
+}
+
+// Write prints p above the bar
+func (b *ProgressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line == "" {
+		return b.w.Write(p)
+	}
+	io.WriteString(b.w, clearLine)
+	n, err := b.w.Write(p)
+	b.draw()
+	return n, err
+}
+
+// Clear removes the bar, leaving the cursor at the start of the empty line
+func (b *ProgressBar) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		io.WriteString(b.w, clearLine)
+		b.line = ""
+	}
+}
+
+// draw writes the current line in place of the previous one
+func (b *ProgressBar) draw() {
+	io.WriteString(b.w, clearLine+b.line)
+	b.drawn = time.Now()
+}
+
+// progressLine renders current out of total, e.g. "[======>      ]  50% label", no wider than
+// width columns. The line is one column short of width so the cursor never wraps.
+func progressLine(current, total int, label string, width int) string {
+	percent := 0
+	if total > 0 {
+		percent = min(max(current, 0)*100/total, 100)
+	}
+	filled := percent * barWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	line := fmt.Sprintf("[%s] %3d%%", bar, percent)
+	if label != "" {
+		line += " " + label
+	}
+	if limit := width - 1; limit > 0 && utf8.RuneCountInString(line) > limit {
+		runes := []rune(line)
+		line = string(runes[:limit-1]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,65 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressLine(t *testing.T) {
+	tests := []struct {
+		name           string
+		current, total int
+		label          string
+		width          int
+		expected       string
+	}{
+		{"start", 0, 4, "Copying", 80, "[>                       ]   0% Copying"},
+		{"half", 2, 4, "", 80, "[============>           ]  50%"},
+		{"done", 4, 4, "Done", 80, "[========================] 100% Done"},
+		{"no total", 3, 0, "", 80, "[>                       ]   0%"},
+		{"cut to width", 1, 4, "Backing up directory 1 of 4", 40, "[======>                 ]  25% Backin…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressLine(tt.current, tt.total, tt.label, tt.width); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestProgressBar_WriteAbove(t *testing.T) {
+	var buf bytes.Buffer
+	bar := NewProgressBar(&buf, 80)
+
+	// Without a bar, writes go through as they are
+	bar.Write([]byte("starting\n"))
+	bar.Draw(1, 2, "half")
+	bar.Write([]byte("log line\n"))
+	bar.Clear()
+
+	line := "[============>           ]  50% half"
+	expected := "starting\n" +
+		clearLine + line +
+		clearLine + "log line\n" + clearLine + line +
+		clearLine
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
+
+func TestProgressBar_Throttle(t *testing.T) {
+	var buf bytes.Buffer
+	bar := NewProgressBar(&buf, 80)
+	bar.Draw(1, 10, "first")
+	bar.Draw(2, 10, "skipped")
+	bar.Draw(10, 10, "last")
+
+	if strings.Contains(buf.String(), "skipped") {
+		t.Errorf("Expected a draw right after another skipped, got %q", buf.String())
+	}
+	if !strings.HasSuffix(buf.String(), "100% last") {
+		t.Errorf("Expected the last draw of a stage shown, got %q", buf.String())
+	}
+}
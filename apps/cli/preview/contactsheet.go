@@ -0,0 +1,186 @@
+package preview
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+	"github.com/spf13/cobra"
+)
+
+// Contact sheet layout in points: a grid of sheetColumns x sheetRows cells per A4 page, below
+// the title of the event
+const (
+	sheetMargin      = 36.0
+	sheetColumns     = 4
+	sheetRows        = 5
+	sheetTitleSize   = 14.0
+	sheetTitleHeight = 28.0
+	sheetCaptionSize = 7.0
+	sheetPadding     = 6.0
+	// sheetThumbnailSize is the size thumbnails are scaled to in pixels, about 240 dpi in print
+	sheetThumbnailSize = 400
+)
+
+// sheetSection is an event of a contact sheet: a title and the images shown under it
+type sheetSection struct {
+	title string
+	files []string
+}
+
+// NewContactSheetCmd creates the contact-sheet command
+func NewContactSheetCmd() *cobra.Command {
+	var month string
+
+	cmd := &cobra.Command{
+		Use:   "contact-sheet DIR OUT.pdf",
+		Short: i18n.T("cmd.contact_sheet.short"),
+		Long: `Lay out thumbnails of images with their filenames and dates in a printable A4 PDF.
+
+DIR is a date directory of the library, whose images make up the sheet.
+With --month, DIR is the library and the sheet covers every date directory of
+that month, each event starting on a new page.
+JPEG and PNG images are shown; HEIC images get an empty frame with their name.
+Videos are left out.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sections, err := sheetSections(args[0], month)
+			if err != nil {
+				return err
+			}
+			if err := writeContactSheet(args[1], sections); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), args[1])
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&month, "month", "", "Month to print from the library, as YYYY-MM")
+
+	return cmd
+}
+
+// sheetSections returns the events a contact sheet shows: dir alone, or with month the date
+// directories of the library dir whose names start with that month
+func sheetSections(dir, month string) ([]sheetSection, error) {
+	if month == "" {
+		files, err := imageFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no images found in %s", dir)
+		}
+		return []sheetSection{{title: filepath.Base(filepath.Clean(dir)), files: files}}, nil
+	}
+
+	date, err := time.Parse("2006-01", month)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	var sections []sheetSection
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if year, m, ok := naming.ParseYearMonth(entry.Name()); !ok || year != date.Year() || m != date.Month() {
+			continue
+		}
+		files, err := imageFiles(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			sections = append(sections, sheetSection{title: entry.Name(), files: files})
+		}
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no images found for %s in %s", month, dir)
+	}
+	return sections, nil
+}
+
+// writeContactSheet lays out the sections on A4 pages and writes them as a PDF to path
+func writeContactSheet(path string, sections []sheetSection) error {
+	var doc pdfDocument
+	for _, section := range sections {
+		if err := layoutSection(&doc, section); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := doc.write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// layoutSection adds the pages of a section to doc, starting on a new page
+func layoutSection(doc *pdfDocument, section sheetSection) error {
+	cellWidth := (pageWidth - 2*sheetMargin) / sheetColumns
+	cellHeight := (pageHeight - 2*sheetMargin - sheetTitleHeight) / sheetRows
+	captionHeight := 2.5 * sheetCaptionSize
+	boxWidth := cellWidth - 2*sheetPadding
+	boxHeight := cellHeight - 2*sheetPadding - captionHeight
+	perPage := sheetColumns * sheetRows
+
+	var page *pdfPage
+	for i, file := range section.files {
+		if i%perPage == 0 {
+			page = doc.addPage()
+			page.text(sheetMargin, pageHeight-sheetMargin-sheetTitleSize, sheetTitleSize,
+				fitText(section.title, sheetTitleSize, pageWidth-2*sheetMargin))
+		}
+
+		column, row := i%sheetColumns, (i%perPage)/sheetColumns
+		left := sheetMargin + float64(column)*cellWidth + sheetPadding
+		top := pageHeight - sheetMargin - sheetTitleHeight - float64(row)*cellHeight - sheetPadding
+		boxBottom := top - boxHeight
+
+		if err := drawThumbnail(page, file, left, boxBottom, boxWidth, boxHeight); err != nil {
+			return err
+		}
+
+		name := filepath.Base(file)
+		page.text(left, boxBottom-sheetCaptionSize-2, sheetCaptionSize, fitText(name, sheetCaptionSize, boxWidth))
+		if info, err := os.Stat(file); err == nil {
+			page.text(left, boxBottom-2*sheetCaptionSize-4, sheetCaptionSize, info.ModTime().Format("2006-01-02 15:04"))
+		}
+	}
+	return nil
+}
+
+// drawThumbnail draws the image at path centred in the box, keeping its proportions. Files
+// that can't be decoded, such as HEIC, get an empty frame so they still appear on the sheet.
+func drawThumbnail(page *pdfPage, path string, x, y, width, height float64) error {
+	img, err := decodeImage(path)
+	if err != nil {
+		logger.Debug("No thumbnail for file", "file", path, "error", err)
+		page.strokeRect(x, y, width, height)
+		return nil
+	}
+
+	thumb := thumbnail(img, sheetThumbnailSize)
+	bounds := thumb.Bounds()
+	scale := min(width/float64(bounds.Dx()), height/float64(bounds.Dy()))
+	drawWidth, drawHeight := float64(bounds.Dx())*scale, float64(bounds.Dy())*scale
+	if err := page.drawImage(thumb, x+(width-drawWidth)/2, y+(height-drawHeight)/2, drawWidth, drawHeight); err != nil {
+		return fmt.Errorf("failed to add %s to contact sheet: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package preview
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// createEvent creates a date directory in library with the given files
+func createEvent(t *testing.T, library, name string, files ...string) string {
+	t.Helper()
+	dir := filepath.Join(library, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+	for _, file := range files {
+		if filepath.Ext(file) == ".png" {
+			writePNG(t, dir, file)
+		} else if err := os.WriteFile(filepath.Join(dir, file), []byte("not decodable"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", file, err)
+		}
+	}
+	return dir
+}
+
+// sectionNames returns the titles of sections and the base names of their files
+func sectionNames(sections []sheetSection) map[string][]string {
+	names := make(map[string][]string)
+	for _, section := range sections {
+		for _, file := range section.files {
+			names[section.title] = append(names[section.title], filepath.Base(file))
+		}
+	}
+	return names
+}
+
+func TestNewContactSheetCmd(t *testing.T) {
+	cmd := NewContactSheetCmd()
+	if cmd.Use != "contact-sheet DIR OUT.pdf" {
+		t.Errorf("Unexpected Use: %s", cmd.Use)
+	}
+	if cmd.Flags().Lookup("month") == nil {
+		t.Error("Expected --month flag")
+	}
+}
+
+func TestSheetSections_Month(t *testing.T) {
+	library := t.TempDir()
+	createEvent(t, library, "2023 06 June 15 vacation", "b.png", "a.png", "clip.mov")
+	createEvent(t, library, "2023 06 June 20", "c.heic")
+	createEvent(t, library, "2023 06 June 25 videos", "clip.mp4")
+	createEvent(t, library, "2023 07 July 01 park", "d.png")
+	createEvent(t, library, "2022 06 June 15", "e.png")
+
+	sections, err := sheetSections(library, "2023-06")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := map[string][]string{
+		"2023 06 June 15 vacation": {"a.png", "b.png"},
+		"2023 06 June 20":          {"c.heic"},
+	}
+	if got := sectionNames(sections); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if sections[0].title != "2023 06 June 15 vacation" {
+		t.Errorf("Expected sections in date order, got %s first", sections[0].title)
+	}
+}
+
+func TestSheetSections_Event(t *testing.T) {
+	dir := createEvent(t, t.TempDir(), "2023 06 June 15 vacation", "a.png")
+
+	sections, err := sheetSections(dir+string(filepath.Separator), "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := map[string][]string{"2023 06 June 15 vacation": {"a.png"}}
+	if got := sectionNames(sections); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestSheetSections_Errors(t *testing.T) {
+	library := t.TempDir()
+	createEvent(t, library, "2023 06 June 15", "a.png")
+
+	if _, err := sheetSections(library, "June 2023"); err == nil {
+		t.Error("Expected an error for an invalid month")
+	}
+	if _, err := sheetSections(library, "2023-07"); err == nil {
+		t.Error("Expected an error for a month without images")
+	}
+	if _, err := sheetSections(library, ""); err == nil {
+		t.Error("Expected an error for a directory without images")
+	}
+}
+
+func TestWriteContactSheet(t *testing.T) {
+	library := t.TempDir()
+	var files []string
+	for i := 0; i < 21; i++ {
+		files = append(files, string(rune('a'+i))+".png")
+	}
+	createEvent(t, library, "2023 06 June 15 vacation", files...)
+	createEvent(t, library, "2023 06 June 20", "c.heic")
+
+	sections, err := sheetSections(library, "2023-06")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "june.pdf")
+	if err := writeContactSheet(out, sections); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Failed to read contact sheet: %v", err)
+	}
+	// 21 images fill a page and start a second; the next event starts a third
+	if !bytes.Contains(data, []byte("/Count 3")) {
+		t.Error("Expected three pages")
+	}
+	if got := bytes.Count(data, []byte("/Subtype /Image")); got != 21 {
+		t.Errorf("Expected 21 thumbnails, got %d", got)
+	}
+	for _, caption := range []string{"(2023 06 June 20)", "(c.heic)", "(u.png)", " re S"} {
+		if !bytes.Contains(data, []byte(caption)) {
+			t.Errorf("Expected %q in the contact sheet", caption)
+		}
+	}
+}
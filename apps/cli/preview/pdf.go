@@ -0,0 +1,200 @@
+package preview
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// A4 page size in points (1/72 inch)
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+)
+
+// pdfJPEGQuality is the JPEG quality images are embedded with
+const pdfJPEGQuality = 85
+
+// pdfDocument is a minimal PDF writer: A4 pages of JPEG images, rectangles and Helvetica text.
+// It covers what a contact sheet needs without pulling in a PDF library.
+type pdfDocument struct {
+	pages []*pdfPage
+}
+
+// pdfPage is a page of a pdfDocument. Coordinates are in points from the bottom left corner.
+type pdfPage struct {
+	content bytes.Buffer
+	images  []pdfImage
+}
+
+// pdfImage is a JPEG image embedded in a page
+type pdfImage struct {
+	data          []byte
+	width, height int
+}
+
+// addPage appends a blank page to the document
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// drawImage draws img scaled to a width x height box at x, y
+func (p *pdfPage) drawImage(img image.Image, x, y, width, height float64) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: pdfJPEGQuality}); err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	p.images = append(p.images, pdfImage{data: buf.Bytes(), width: bounds.Dx(), height: bounds.Dy()})
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, x, y, len(p.images))
+	return nil
+}
+
+// strokeRect draws the outline of a width x height rectangle at x, y
+func (p *pdfPage) strokeRect(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "0.5 w 0.6 G %.2f %.2f %.2f %.2f re S\n", x, y, width, height)
+}
+
+// text writes s in Helvetica of the given size with its baseline starting at x, y
+func (p *pdfPage) text(x, y, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F1 %.1f Tf %.2f %.2f Td (%s) Tj ET\n", size, x, y, pdfString(s))
+}
+
+// pdfString escapes s for a PDF literal string. Helvetica is used with WinAnsiEncoding, so
+// Latin-1 characters such as accents are kept and anything else is replaced with "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// textWidth estimates the width of s in Helvetica of the given size. Helvetica averages a
+// little over half an em per character, which is close enough to keep captions in their cell.
+func textWidth(s string, size float64) float64 {
+	return float64(len([]rune(s))) * size * 0.55
+}
+
+// fitText shortens s with "..." until it fits in width
+func fitText(s string, size, width float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// write writes the document to w. Objects 1 to 3 are the catalog, the page tree and the font;
+// each page then takes one object, one for its content and one per image.
+func (d *pdfDocument) write(w io.Writer) error {
+	out := &pdfWriter{w: bufio.NewWriter(w)}
+	out.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Object numbers of each page, allocated up front so the page tree can list them
+	pageIDs := make([]int, len(d.pages))
+	next := 4
+	for i, page := range d.pages {
+		pageIDs[i] = next
+		next += 2 + len(page.images)
+	}
+
+	out.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pageIDs))
+	for i, id := range pageIDs {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	out.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	out.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		id := pageIDs[i]
+		var xobjects strings.Builder
+		for j := range page.images {
+			fmt.Fprintf(&xobjects, " /Im%d %d 0 R", j+1, id+2+j)
+		}
+		out.object(id, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, xobjects.String(), id+1))
+		out.stream(id+1, "", page.content.Bytes())
+		for j, img := range page.images {
+			out.stream(id+2+j, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode ",
+				img.width, img.height), img.data)
+		}
+	}
+
+	xref := out.offset
+	out.printf("xref\n0 %d\n0000000000 65535 f \n", next)
+	for _, offset := range out.offsets[1:next] {
+		out.printf("%010d 00000 n \n", offset)
+	}
+	out.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", next, xref)
+
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// pdfWriter writes PDF objects and records their offsets for the cross-reference table.
+// The first error is kept and later writes are skipped.
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int
+	offsets []int
+	err     error
+}
+
+// printf writes formatted output, tracking the offset
+func (p *pdfWriter) printf(format string, args ...any) {
+	p.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// write writes data, tracking the offset
+func (p *pdfWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(data)
+	p.offset += n
+	p.err = err
+}
+
+// begin records the offset of object id and starts it
+func (p *pdfWriter) begin(id int) {
+	for len(p.offsets) <= id {
+		p.offsets = append(p.offsets, 0)
+	}
+	p.offsets[id] = p.offset
+	p.printf("%d 0 obj\n", id)
+}
+
+// object writes object id with the given body
+func (p *pdfWriter) object(id int, body string) {
+	p.begin(id)
+	p.printf("%s\nendobj\n", body)
+}
+
+// stream writes object id as a stream with data and the extra dictionary entries in dict
+func (p *pdfWriter) stream(id int, dict string, data []byte) {
+	p.begin(id)
+	p.printf("<< %s/Length %d >>\nstream\n", dict, len(data))
+	p.write(data)
+	p.printf("\nendstream\nendobj\n")
+}
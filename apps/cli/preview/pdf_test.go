@@ -0,0 +1,89 @@
+package preview
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPdfString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "IMG_0001.jpg", expected: "IMG_0001.jpg"},
+		{input: "a (copy).jpg", expected: `a \(copy\).jpg`},
+		{input: `back\slash`, expected: `back\\slash`},
+		{input: "año", expected: "a\xf1o"},
+		{input: "日本", expected: "??"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := pdfString(tt.input); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFitText(t *testing.T) {
+	if got := fitText("short.jpg", 7, 100); got != "short.jpg" {
+		t.Errorf("Expected text that fits unchanged, got %q", got)
+	}
+	long := strings.Repeat("x", 100) + ".jpg"
+	got := fitText(long, 7, 100)
+	if !strings.HasSuffix(got, "...") || textWidth(got, 7) > 100 {
+		t.Errorf("Expected text shortened to fit, got %q", got)
+	}
+}
+
+func TestPdfDocument_Write(t *testing.T) {
+	var doc pdfDocument
+	first := doc.addPage()
+	first.text(10, 10, 12, "Title")
+	if err := first.drawImage(testImage(20, 10), 10, 20, 40, 20); err != nil {
+		t.Fatalf("drawImage failed: %v", err)
+	}
+	second := doc.addPage()
+	second.strokeRect(10, 10, 40, 20)
+
+	var buf bytes.Buffer
+	if err := doc.write(&buf); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("Expected a PDF header and trailer")
+	}
+	if !bytes.Contains(data, []byte("/Count 2")) {
+		t.Error("Expected two pages in the page tree")
+	}
+	if !bytes.Contains(data, []byte("/Width 20 /Height 10")) {
+		t.Error("Expected the image with its pixel size")
+	}
+
+	// Every cross-reference entry points at the object it numbers
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("Expected startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("Expected the cross-reference table at offset %d", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("Expected 8 objects, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		prefix := strconv.Itoa(i+1) + " 0 obj\n"
+		if !bytes.HasPrefix(data[offset:], []byte(prefix)) {
+			t.Errorf("Expected object %d at offset %d", i+1, offset)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package preview
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/pics"
+	"github.com/spf13/cobra"
+)
+
+// NewPreviewCmd creates the preview command
+func NewPreviewCmd() *cobra.Command {
+	var protocolFlag string
+	var size int
+
+	cmd := &cobra.Command{
+		Use:   "preview FILE|DIR",
+		Short: i18n.T("cmd.preview.short"),
+		Long: `Show small previews of images directly in the terminal.
+
+Supports the kitty, iTerm2 and sixel image protocols, which also work over SSH.
+The protocol is detected from the terminal unless --protocol is given.
+JPEG, PNG and GIF images are shown inline. Other files, or any file when the
+terminal supports no image protocol, are opened in the default viewer.
+For a directory, every image directly inside it is shown in turn.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			protocol, err := ParseProtocol(protocolFlag)
+			if err != nil {
+				return err
+			}
+			if size <= 0 {
+				return fmt.Errorf("size must be positive: %d", size)
+			}
+			return runPreview(cmd.OutOrStdout(), args[0], protocol, size, openInViewer)
+		},
+	}
+
+	cmd.Flags().StringVarP(&protocolFlag, "protocol", "p", "auto", "Image protocol (auto, kitty, iterm2, sixel, none)")
+	cmd.Flags().IntVarP(&size, "size", "s", 400, "Maximum preview width and height in pixels")
+
+	return cmd
+}
+
+// runPreview shows the file or the images in the directory at path, calling open for
+// anything the terminal can't show
+func runPreview(w io.Writer, path string, protocol Protocol, size int, open func(string) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %w", path, err)
+	}
+	if protocol == None {
+		return open(path)
+	}
+	if !info.IsDir() {
+		return previewFile(w, path, protocol, size, open)
+	}
+
+	files, err := imageFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Fprintln(w, filepath.Base(file))
+		img, err := decodeImage(file)
+		if err != nil {
+			fmt.Fprintln(w, i18n.T("preview.no_inline"))
+			continue
+		}
+		if err := render(w, thumbnail(img, size), protocol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previewFile shows a single file inline, or opens it when it can't be decoded (e.g. HEIC or video)
+func previewFile(w io.Writer, path string, protocol Protocol, size int, open func(string) error) error {
+	img, err := decodeImage(path)
+	if err != nil {
+		return open(path)
+	}
+	return render(w, thumbnail(img, size), protocol)
+}
+
+// imageFiles returns the images directly inside dir, sorted by name
+func imageFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	extensions := pics.NewExtensions()
+	var files []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && extensions.IsImage(path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// openInViewer opens path in the default application of the desktop
+func openInViewer(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to open %s in the default viewer: %w", path, err)
+	}
+	return nil
+}
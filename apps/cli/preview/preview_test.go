@@ -0,0 +1,144 @@
+package preview
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+func writePNG(t *testing.T, dir, filename string) string {
+	t.Helper()
+	path := filepath.Join(dir, filename)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", filename, err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, testImage(20, 10)); err != nil {
+		t.Fatalf("Failed to encode %s: %v", filename, err)
+	}
+	return path
+}
+
+// recordOpen returns an open function that records the paths it was called with
+func recordOpen(opened *[]string) func(string) error {
+	return func(path string) error {
+		*opened = append(*opened, path)
+		return nil
+	}
+}
+
+func TestNewPreviewCmd(t *testing.T) {
+	cmd := NewPreviewCmd()
+	if cmd.Use != "preview FILE|DIR" {
+		t.Errorf("Unexpected Use: %s", cmd.Use)
+	}
+	for _, flag := range []string{"protocol", "size"} {
+		if cmd.Flags().Lookup(flag) == nil {
+			t.Errorf("Expected --%s flag", flag)
+		}
+	}
+}
+
+func TestRunPreview_File(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := writePNG(t, tmpDir, "photo.png")
+
+	var buf bytes.Buffer
+	var opened []string
+	if err := runPreview(&buf, path, ITerm2, 100, recordOpen(&opened)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b]1337;File=") {
+		t.Errorf("Expected inline image, got %q", buf.String())
+	}
+	if len(opened) != 0 {
+		t.Errorf("Expected no viewer to be opened, got %v", opened)
+	}
+}
+
+func TestRunPreview_UndecodableFileOpensViewer(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "photo.heic")
+	if err := os.WriteFile(path, []byte("not decodable"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	var opened []string
+	if err := runPreview(&buf, path, Kitty, 100, recordOpen(&opened)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(opened) != 1 || opened[0] != path {
+		t.Errorf("Expected %s to be opened in the viewer, got %v", path, opened)
+	}
+}
+
+func TestRunPreview_NoProtocolOpensViewer(t *testing.T) {
+	tmpDir := t.TempDir()
+	writePNG(t, tmpDir, "photo.png")
+
+	var buf bytes.Buffer
+	var opened []string
+	if err := runPreview(&buf, tmpDir, None, 100, recordOpen(&opened)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(opened) != 1 || opened[0] != tmpDir {
+		t.Errorf("Expected directory to be opened in the viewer, got %v", opened)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no terminal output, got %q", buf.String())
+	}
+}
+
+func TestRunPreview_Directory(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	tmpDir := t.TempDir()
+	writePNG(t, tmpDir, "b.png")
+	writePNG(t, tmpDir, "a.png")
+	if err := os.WriteFile(filepath.Join(tmpDir, "c.heic"), []byte("not decodable"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("text"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	var opened []string
+	if err := runPreview(&buf, tmpDir, Sixel, 100, recordOpen(&opened)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	output := buf.String()
+	a, b, c := strings.Index(output, "a.png\n"), strings.Index(output, "b.png\n"), strings.Index(output, "c.heic\n")
+	if a == -1 || b == -1 || c == -1 || !(a < b && b < c) {
+		t.Errorf("Expected a.png, b.png and c.heic in order, got %q", output)
+	}
+	if strings.Count(output, "\x1bPq") != 2 {
+		t.Errorf("Expected two inline images, got %d", strings.Count(output, "\x1bPq"))
+	}
+	if !strings.Contains(output, "c.heic\n(no inline preview for this format)") {
+		t.Error("Expected a note for the undecodable image")
+	}
+	if strings.Contains(output, "notes.txt") {
+		t.Error("Expected non-image files to be left out")
+	}
+	if len(opened) != 0 {
+		t.Errorf("Expected no viewer to be opened, got %v", opened)
+	}
+}
+
+func TestRunPreview_MissingPath(t *testing.T) {
+	var opened []string
+	if err := runPreview(&bytes.Buffer{}, filepath.Join(t.TempDir(), "missing.jpg"), Kitty, 100, recordOpen(&opened)); err == nil {
+		t.Error("Expected error for missing path")
+	}
+}
@@ -0,0 +1,60 @@
+package preview
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Protocol represents a terminal image protocol
+type Protocol string
+
+const (
+	Kitty  Protocol = "kitty"
+	ITerm2 Protocol = "iterm2"
+	Sixel  Protocol = "sixel"
+	// None means the terminal can't show images, so files are opened in the default viewer
+	None Protocol = "none"
+)
+
+// sixelTerminals lists TERM values of terminals known to support sixel graphics
+var sixelTerminals = []string{"foot", "mlterm", "yaft-256color", "contour"}
+
+// DetectProtocol detects the image protocol supported by the current terminal from its environment.
+// The environment is forwarded over SSH for the TERM variable, so detection mostly works remotely too.
+func DetectProtocol() Protocol {
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty", term == "xterm-ghostty":
+		return Kitty
+	case termProgram == "iTerm.app", termProgram == "WezTerm", os.Getenv("LC_TERMINAL") == "iTerm2":
+		return ITerm2
+	case strings.Contains(term, "sixel"):
+		return Sixel
+	}
+	for _, sixelTerm := range sixelTerminals {
+		if term == sixelTerm {
+			return Sixel
+		}
+	}
+	return None
+}
+
+// ParseProtocol parses a protocol name, where "auto" detects the protocol of the current terminal
+func ParseProtocol(name string) (Protocol, error) {
+	switch Protocol(strings.ToLower(name)) {
+	case "auto":
+		return DetectProtocol(), nil
+	case Kitty:
+		return Kitty, nil
+	case ITerm2:
+		return ITerm2, nil
+	case Sixel:
+		return Sixel, nil
+	case None:
+		return None, nil
+	}
+	return "", fmt.Errorf("unsupported protocol: %s (supported: auto, kitty, iterm2, sixel, none)", name)
+}
@@ -0,0 +1,46 @@
+package preview
+
+import "testing"
+
+func TestDetectProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected Protocol
+	}{
+		{name: "kitty window", env: map[string]string{"KITTY_WINDOW_ID": "1"}, expected: Kitty},
+		{name: "kitty over ssh", env: map[string]string{"TERM": "xterm-kitty"}, expected: Kitty},
+		{name: "ghostty", env: map[string]string{"TERM": "xterm-ghostty"}, expected: Kitty},
+		{name: "iTerm2", env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, expected: ITerm2},
+		{name: "iTerm2 over ssh", env: map[string]string{"LC_TERMINAL": "iTerm2"}, expected: ITerm2},
+		{name: "WezTerm", env: map[string]string{"TERM_PROGRAM": "WezTerm"}, expected: ITerm2},
+		{name: "foot", env: map[string]string{"TERM": "foot"}, expected: Sixel},
+		{name: "xterm with sixel", env: map[string]string{"TERM": "xterm-sixel"}, expected: Sixel},
+		{name: "plain xterm", env: map[string]string{"TERM": "xterm-256color"}, expected: None},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"TERM", "TERM_PROGRAM", "LC_TERMINAL", "KITTY_WINDOW_ID"} {
+				t.Setenv(key, tt.env[key])
+			}
+			if protocol := DetectProtocol(); protocol != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, protocol)
+			}
+		})
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for _, name := range []string{"kitty", "iterm2", "sixel", "none", "Kitty"} {
+		if _, err := ParseProtocol(name); err != nil {
+			t.Errorf("Expected %q to be valid, got: %v", name, err)
+		}
+	}
+	if _, err := ParseProtocol("auto"); err != nil {
+		t.Errorf("Expected auto to be valid, got: %v", err)
+	}
+	if _, err := ParseProtocol("ascii"); err == nil {
+		t.Error("Expected error for unsupported protocol")
+	}
+}
@@ -0,0 +1,190 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// kittyChunkSize is the largest base64 payload the kitty protocol accepts per escape sequence
+const kittyChunkSize = 4096
+
+// decodeImage decodes a JPEG, PNG or GIF file
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// thumbnail scales img down with nearest-neighbour sampling to fit in a maxSize x maxSize box.
+// Images that already fit are returned unchanged.
+func thumbnail(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+
+	newWidth, newHeight := maxSize, height*maxSize/width
+	if height > width {
+		newWidth, newHeight = width*maxSize/height, maxSize
+	}
+	newWidth, newHeight = max(newWidth, 1), max(newHeight, 1)
+
+	thumb := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			thumb.Set(x, y, img.At(bounds.Min.X+x*width/newWidth, bounds.Min.Y+y*height/newHeight))
+		}
+	}
+	return thumb
+}
+
+// render writes img to w using the given terminal image protocol
+func render(w io.Writer, img image.Image, protocol Protocol) error {
+	switch protocol {
+	case Kitty:
+		return renderKitty(w, img)
+	case ITerm2:
+		return renderITerm2(w, img)
+	case Sixel:
+		return renderSixel(w, img)
+	}
+	return fmt.Errorf("protocol %s cannot render images", protocol)
+}
+
+// encodePNG encodes img as base64 PNG data
+func encodePNG(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// renderKitty writes img using the kitty graphics protocol, which takes PNG data in chunks
+func renderKitty(w io.Writer, img image.Image) error {
+	data, err := encodePNG(img)
+	if err != nil {
+		return err
+	}
+
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data[:min(kittyChunkSize, len(data))]
+		data = data[len(chunk):]
+
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		control := fmt.Sprintf("m=%d", more)
+		if first {
+			control = "f=100,a=T," + control
+		}
+		if _, err := fmt.Fprintf(w, "\x1b_G%s;%s\x1b\\", control, chunk); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// renderITerm2 writes img using the iTerm2 inline images protocol
+func renderITerm2(w io.Writer, img image.Image) error {
+	data, err := encodePNG(img)
+	if err != nil {
+		return err
+	}
+	size := base64.StdEncoding.DecodedLen(len(data))
+	_, err = fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", size, data)
+	return err
+}
+
+// renderSixel writes img as DEC sixel graphics. Colours are reduced to a 256-colour palette
+// with dithering, and the image is drawn in bands six pixels high, one colour at a time.
+func renderSixel(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, bounds.Min)
+
+	var out strings.Builder
+	width, height := paletted.Bounds().Dx(), paletted.Bounds().Dy()
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", width, height)
+
+	for i, c := range paletted.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	for top := 0; top < height; top += 6 {
+		// Colours used in this band, in palette order so output is deterministic
+		used := make([]bool, len(paletted.Palette))
+		for y := top; y < min(top+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+
+		first := true
+		for index, isUsed := range used {
+			if !isUsed {
+				continue
+			}
+			if !first {
+				out.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&out, "#%d", index)
+			writeSixelRow(&out, paletted, uint8(index), top)
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeSixelRow writes the pixels of one colour in the band starting at row top,
+// run-length encoding repeated sixels
+func writeSixelRow(out *strings.Builder, img *image.Paletted, index uint8, top int) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	sixelAt := func(x int) byte {
+		var bits byte
+		for row := 0; row < 6 && top+row < height; row++ {
+			if img.ColorIndexAt(x, top+row) == index {
+				bits |= 1 << row
+			}
+		}
+		return 63 + bits
+	}
+
+	for x := 0; x < width; {
+		sixel := sixelAt(x)
+		run := 1
+		for x+run < width && sixelAt(x+run) == sixel {
+			run++
+		}
+		if run > 3 {
+			fmt.Fprintf(out, "!%d%c", run, sixel)
+		} else {
+			out.WriteString(strings.Repeat(string(sixel), run))
+		}
+		x += run
+	}
+}
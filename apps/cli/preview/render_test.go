@@ -0,0 +1,105 @@
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name           string
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{name: "landscape", width: 800, height: 400, expectedWidth: 200, expectedHeight: 100},
+		{name: "portrait", width: 400, height: 800, expectedWidth: 100, expectedHeight: 200},
+		{name: "already small", width: 50, height: 20, expectedWidth: 50, expectedHeight: 20},
+		{name: "very wide", width: 2000, height: 1, expectedWidth: 200, expectedHeight: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounds := thumbnail(testImage(tt.width, tt.height), 200).Bounds()
+			if bounds.Dx() != tt.expectedWidth || bounds.Dy() != tt.expectedHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.expectedWidth, tt.expectedHeight, bounds.Dx(), bounds.Dy())
+			}
+		})
+	}
+}
+
+func TestRenderKitty(t *testing.T) {
+	// Noise doesn't compress, so the PNG needs several chunks
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+
+	var buf bytes.Buffer
+	if err := renderKitty(&buf, img); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	sequences := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\x1b\\")
+	sequences = sequences[:len(sequences)-1]
+	if len(sequences) < 2 {
+		t.Fatalf("Expected the image to be sent in several chunks, got %d", len(sequences))
+	}
+	if !strings.HasPrefix(sequences[0], "\x1b_Gf=100,a=T,m=1;") {
+		t.Errorf("Unexpected first chunk header: %q", sequences[0][:20])
+	}
+	if !strings.HasPrefix(sequences[len(sequences)-1], "\x1b_Gm=0;") {
+		t.Errorf("Expected last chunk to end the transmission, got %q", sequences[len(sequences)-1][:10])
+	}
+	for _, sequence := range sequences {
+		if _, payload, _ := strings.Cut(sequence, ";"); len(payload) > kittyChunkSize {
+			t.Errorf("Expected chunks of at most %d bytes, got %d", kittyChunkSize, len(payload))
+		}
+	}
+}
+
+func TestRenderITerm2(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderITerm2(&buf, testImage(10, 10)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	output := buf.String()
+	if !strings.HasPrefix(output, "\x1b]1337;File=inline=1;") || !strings.HasSuffix(output, "\a\n") {
+		t.Errorf("Unexpected iTerm2 sequence: %q", output)
+	}
+}
+
+func TestRenderSixel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := renderSixel(&buf, img); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	output := buf.String()
+
+	if !strings.HasPrefix(output, "\x1bPq\"1;1;8;7") || !strings.HasSuffix(output, "\x1b\\\n") {
+		t.Errorf("Unexpected sixel framing: %q", output)
+	}
+	// Two bands: six full rows, then one row, each a single run of black
+	if !strings.Contains(output, "#0!8~-#0!8@-") {
+		t.Errorf("Expected run-length encoded bands, got: %q", output[strings.LastIndex(output, ";"):])
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
 	"github.com/acm19/pics/internal/pics"
 	"github.com/barasher/go-exiftool"
@@ -14,18 +17,24 @@ import (
 
 // App struct
 type App struct {
-	ctx            context.Context
-	exiftoolPath   string
-	jpegoptimPath  string
-	progressChan   chan pics.ProgressEvent
-	exiftool       *exiftool.Exiftool
-	renamer        pics.DirectoryRenamer
+	ctx           context.Context
+	exiftoolPath  string
+	jpegoptimPath string
+	progressChan  chan pics.ProgressEvent
+	exiftool      *exiftool.Exiftool
+	exifWriter    pics.ExifWriter
+	renamer       pics.DirectoryRenamer
+	// cancelParse stops the running parse, if any
+	cancelMu    sync.Mutex
+	cancelParse context.CancelFunc
 }
 
 // NewApp creates a new App application struct
 func NewApp(exiftoolPath, jpegoptimPath string) *App {
 	// Initialise single exiftool instance for reuse
 	et, err := exiftool.NewExiftool(exiftool.SetExiftoolBinaryPath(exiftoolPath))
+	// Write EXIF with the bundled exiftool too, as the system may have none
+	exifWriter := pics.NewExifWriterWithPath(et, exiftoolPath, exiftoolEnv(exiftoolPath))
 	if err != nil {
 		logger.Error("Failed to initialise exiftool", "error", err)
 		// Create app without exiftool - will fail later if needed
@@ -33,6 +42,7 @@ func NewApp(exiftoolPath, jpegoptimPath string) *App {
 			exiftoolPath:  exiftoolPath,
 			jpegoptimPath: jpegoptimPath,
 			progressChan:  make(chan pics.ProgressEvent, 100),
+			exifWriter:    exifWriter,
 		}
 	}
 
@@ -41,7 +51,8 @@ func NewApp(exiftoolPath, jpegoptimPath string) *App {
 		jpegoptimPath: jpegoptimPath,
 		progressChan:  make(chan pics.ProgressEvent, 100),
 		exiftool:      et,
-		renamer:       pics.NewDirectoryRenamer(et),
+		exifWriter:    exifWriter,
+		renamer:       pics.NewDirectoryRenamerWithWriter(et, exifWriter),
 	}
 }
 
@@ -68,98 +79,326 @@ func (a *App) shutdown(ctx context.Context) {
 	}
 }
 
-// listenForProgress listens for progress events and emits them to the frontend
+// operationFinished is the stage of the event that marks the end of an operation in the progress
+// stream, so the operation's last milestones are announced before its outcome
+const operationFinished = "operation finished"
+
+// listenForProgress listens for progress events and emits them to the frontend.
+// Milestones worth announcing to assistive technologies are emitted separately as "milestone" events.
 func (a *App) listenForProgress() {
+	tracker := pics.NewMilestoneTracker(pics.DefaultMilestoneThresholds())
 	for event := range a.progressChan {
+		if event.Stage == operationFinished {
+			a.emitMilestones(tracker.Finish())
+			a.emitOperationFinished(event.Message)
+			continue
+		}
+
 		runtime.EventsEmit(a.ctx, "progress", map[string]any{
-			"stage":   event.Stage,
-			"current": event.Current,
-			"total":   event.Total,
-			"message": event.Message,
-			"file":    event.File,
+			"stage":      event.Stage,
+			"current":    event.Current,
+			"total":      event.Total,
+			"message":    event.Message,
+			"file":       event.File,
+			"phase":      event.Phase,
+			"bytes":      event.Bytes,
+			"totalBytes": event.TotalBytes,
 		})
+		a.emitMilestones(tracker.Track(event))
+	}
+}
+
+// emitMilestones emits each milestone to the frontend
+func (a *App) emitMilestones(milestones []pics.Milestone) {
+	for _, milestone := range milestones {
+		runtime.EventsEmit(a.ctx, "milestone", map[string]any{
+			"stage":   milestone.Stage,
+			"kind":    string(milestone.Kind),
+			"percent": milestone.Percent,
+			"message": milestone.Message,
+		})
+	}
+}
+
+// emitOperationFinished emits the outcome of an operation as a milestone; errMsg is empty on success
+func (a *App) emitOperationFinished(errMsg string) {
+	kind, message := "finished", i18n.T("milestone.operation_finished")
+	if errMsg != "" {
+		kind, message = "failed", i18n.T("milestone.operation_failed", errMsg)
+	}
+	runtime.EventsEmit(a.ctx, "milestone", map[string]any{
+		"stage":   "",
+		"kind":    kind,
+		"percent": 100,
+		"message": message,
+	})
+}
+
+// emitSummary emits the end of run summary of an operation to the frontend
+func (a *App) emitSummary(summary pics.Summary) {
+	runtime.EventsEmit(a.ctx, "summary", map[string]any{
+		"title":     summary.Title,
+		"lines":     summary.Lines,
+		"nextSteps": summary.NextSteps,
+	})
+}
+
+// finishOperation marks the end of an operation in the progress stream.
+// The send blocks so the marker is never dropped and arrives after the operation's events.
+func (a *App) finishOperation(err error) {
+	event := pics.ProgressEvent{Stage: operationFinished}
+	if err != nil {
+		event.Message = err.Error()
 	}
+	a.progressChan <- event
 }
 
 // ParseOptions holds options for the Parse operation
 type ParseOptions struct {
-	SourceDir      string `json:"sourceDir"`
-	TargetDir      string `json:"targetDir"`
-	CompressJPEGs  bool   `json:"compressJPEGs"`
-	JPEGQuality    int    `json:"jpegQuality"`
-	MaxConcurrency int    `json:"maxConcurrency"`
+	SourceDir             string  `json:"sourceDir"`
+	TargetDir             string  `json:"targetDir"`
+	CompressJPEGs         bool    `json:"compressJPEGs"`
+	JPEGQuality           int     `json:"jpegQuality"`
+	QualityPreset         string  `json:"qualityPreset"`
+	JPEGTargetSize        int64   `json:"jpegTargetSize"`
+	ProgressiveJPEGs      bool    `json:"progressiveJPEGs"`
+	MinSizeForCompression int64   `json:"minSizeForCompression"`
+	CompressVideos        bool    `json:"compressVideos"`
+	VideoCRF              int     `json:"videoCRF"`
+	VideoBitrate          int64   `json:"videoBitrate"`
+	MinFileSize           int64   `json:"minFileSize"`
+	VerifyMetadataRate    float64 `json:"verifyMetadataRate"`
+	VerifyCopy            bool    `json:"verifyCopy"`
+	MoveFiles             bool    `json:"moveFiles"`
+	PruneSourceDirs       bool    `json:"pruneSourceDirs"`
+	OriginalNamePolicy    string  `json:"originalNamePolicy"`
+	SequenceOrder         string  `json:"sequenceOrder"`
+	AlbumKeywords         bool    `json:"albumKeywords"`
+	TimeZone              string  `json:"timeZone"`
+	AppendLocation        bool    `json:"appendLocation"`
+	PlacesFile            string  `json:"placesFile"`
+	MaxConcurrency        int     `json:"maxConcurrency"`
 }
 
 // Parse processes media files from source to target directory
-func (a *App) Parse(opts ParseOptions) error {
+func (a *App) Parse(opts ParseOptions) (err error) {
+	defer func() { a.finishOperation(err) }()
+
 	logger.Info("Starting parse operation", "source", opts.SourceDir, "target", opts.TargetDir)
 
-	// Create file organiser with shared exiftool instance
-	organiser := pics.NewFileOrganiser(a.exiftool)
+	// Create file organiser with shared exiftool instance and EXIF writer
+	organiser := pics.NewFileOrganiserWithWriter(a.exiftool, a.exifWriter)
 
-	// Create EXIF writer with shared exiftool instance
-	exifWriter := pics.NewExifWriter(a.exiftool)
+	// Create media parser with custom binary paths, organiser, EXIF writer and metadata reader
+	parser := pics.NewMediaParser(a.jpegoptimPath, "", organiser, a.exifWriter, pics.NewMetadataReader(a.exiftool))
 
-	// Create media parser with custom binary paths, organiser, and EXIF writer
-	parser := pics.NewMediaParser(a.jpegoptimPath, organiser, exifWriter)
+	originalNamePolicy := pics.DefaultParseOptions().OriginalNamePolicy
+	if opts.OriginalNamePolicy != "" {
+		if originalNamePolicy, err = pics.ParseOriginalNamePolicy(opts.OriginalNamePolicy); err != nil {
+			return err
+		}
+	}
+
+	sequenceOrder := pics.DefaultParseOptions().SequenceOrder
+	if opts.SequenceOrder != "" {
+		if sequenceOrder, err = pics.ParseSequenceOrder(opts.SequenceOrder); err != nil {
+			return err
+		}
+	}
+
+	var zone *time.Location
+	if opts.TimeZone != "" {
+		if zone, err = pics.ParseTimeZone(opts.TimeZone); err != nil {
+			return err
+		}
+	}
+
+	// Places are looked up in the places file if one is given, otherwise on Nominatim
+	var geocoder pics.Geocoder
+	if opts.AppendLocation {
+		geocoderOpts := pics.DefaultGeocoderOptions()
+		geocoderOpts.PlacesFile = opts.PlacesFile
+		if geocoder, err = pics.NewGeocoder(geocoderOpts); err != nil {
+			return err
+		}
+	}
+
+	// A preset takes the place of the quality
+	jpegQuality := opts.JPEGQuality
+	if opts.QualityPreset != "" {
+		if jpegQuality, err = pics.ParseJPEGQuality(opts.QualityPreset); err != nil {
+			return err
+		}
+	} else if err = pics.ValidateJPEGQuality(jpegQuality); err != nil {
+		return err
+	}
+
+	// Lossless video is never wanted, so an unset CRF takes the default
+	videoCRF := opts.VideoCRF
+	if videoCRF == 0 {
+		videoCRF = pics.DefaultParseOptions().VideoCRF
+	}
 
 	// Create parse options with progress channel
 	parseOpts := pics.ParseOptions{
-		CompressJPEGs:  opts.CompressJPEGs,
-		JPEGQuality:    opts.JPEGQuality,
-		MaxConcurrency: opts.MaxConcurrency,
-		TempDirName:    ".pics-temp",
-		ProgressChan:   a.progressChan,
+		CompressJPEGs:         opts.CompressJPEGs,
+		JPEGQuality:           jpegQuality,
+		JPEGTargetSize:        opts.JPEGTargetSize,
+		ProgressiveJPEGs:      opts.ProgressiveJPEGs,
+		MinSizeForCompression: opts.MinSizeForCompression,
+		CompressVideos:        opts.CompressVideos,
+		VideoCRF:              videoCRF,
+		VideoBitrate:          opts.VideoBitrate,
+		MinFileSize:           opts.MinFileSize,
+		StallTimeout:          pics.DefaultParseOptions().StallTimeout,
+		VerifyMetadataRate:    opts.VerifyMetadataRate,
+		VerifyCopy:            opts.VerifyCopy,
+		MoveFiles:             opts.MoveFiles,
+		TrashRetention:        pics.DefaultParseOptions().TrashRetention,
+		PruneSourceDirs:       opts.PruneSourceDirs,
+		OriginalNamePolicy:    originalNamePolicy,
+		SequenceOrder:         sequenceOrder,
+		AlbumKeywords:         opts.AlbumKeywords,
+		TimeZone:              zone,
+		AppendLocation:        opts.AppendLocation,
+		Geocoder:              geocoder,
+		MaxConcurrency:        opts.MaxConcurrency,
+		TempDirName:           ".pics-temp",
+		ProgressChan:          a.progressChan,
 	}
 
-	// Execute parse
-	if err := parser.Parse(opts.SourceDir, opts.TargetDir, parseOpts); err != nil {
+	// Execute parse, which CancelParse stops
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.cancelMu.Lock()
+	a.cancelParse = cancel
+	a.cancelMu.Unlock()
+	defer func() {
+		a.cancelMu.Lock()
+		a.cancelParse = nil
+		a.cancelMu.Unlock()
+		cancel()
+	}()
+	report, err := parser.Parse(ctx, []string{opts.SourceDir}, opts.TargetDir, parseOpts)
+	if err != nil {
 		logger.Error("Parse operation failed", "error", err)
 		return err
 	}
+	for _, file := range report.Review {
+		logger.Warn("File moved to review", "file", file.Name, "date", file.Date, "reason", file.Reason)
+	}
+	for _, file := range report.Changing {
+		logger.Warn("File skipped because it was still being written", "file", file)
+	}
+	for _, file := range report.TooSmall {
+		logger.Info("File skipped because it is smaller than the minimum file size", "file", file)
+	}
+	for _, dir := range report.KeptDirs {
+		logger.Info("Source directory left in place", "dir", dir.Dir, "reason", dir.Reason)
+	}
 
 	logger.Info("Parse operation completed successfully")
+	a.emitSummary(report.Summary())
 	return nil
 }
 
+// CancelParse stops the running parse. Cancelled while copying, it removes its temporary files and
+// leaves the library untouched; while organising, it keeps the files not yet moved for adoption.
+func (a *App) CancelParse() {
+	a.cancelMu.Lock()
+	defer a.cancelMu.Unlock()
+	if a.cancelParse != nil {
+		logger.Info("Cancelling parse operation")
+		a.cancelParse()
+	}
+}
+
 // BackupOptions holds options for the Backup operation
 type BackupOptions struct {
-	SourceDir string `json:"sourceDir"`
-	Bucket    string `json:"bucket"`
+	SourceDir       string   `json:"sourceDir"`
+	Bucket          string   `json:"bucket"`
+	StagingDir      string   `json:"stagingDir"`
+	ExcludeDirs     []string `json:"excludeDirs"`
+	SHA256Checksums bool     `json:"sha256Checksums"`
+	MaxArchiveSize  int64    `json:"maxArchiveSize"`
+	Mode            string   `json:"mode"`
+	S3Connection
+}
+
+// S3Connection holds the S3-compatible service to connect to instead of AWS, shared by the
+// backup and restore options
+type S3Connection struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	PathStyle bool   `json:"pathStyle"`
+}
+
+// s3Config converts the connection to the S3 settings of the library
+func (c S3Connection) s3Config() pics.S3Config {
+	cfg := pics.DefaultS3Config()
+	cfg.Endpoint = c.Endpoint
+	cfg.Region = c.Region
+	cfg.PathStyle = c.PathStyle
+	return cfg
 }
 
 // Backup creates tar.gz archives and uploads to S3
-func (a *App) Backup(opts BackupOptions) error {
+func (a *App) Backup(opts BackupOptions) (err error) {
+	defer func() { a.finishOperation(err) }()
+
 	logger.Info("Starting backup operation", "source", opts.SourceDir, "bucket", opts.Bucket)
 
-	backup, err := pics.NewS3Backup(a.ctx)
+	backup, err := pics.NewS3Backup(a.ctx, opts.s3Config())
 	if err != nil {
 		logger.Error("Failed to create S3 backup client", "error", err)
 		return err
 	}
 
-	if err := backup.BackupDirectories(a.ctx, opts.SourceDir, opts.Bucket, 10, a.progressChan); err != nil {
+	backupOpts := pics.DefaultBackupOptions()
+	if opts.Mode != "" {
+		if backupOpts.Mode, err = pics.ParseBackupMode(opts.Mode); err != nil {
+			return err
+		}
+	}
+	backupOpts.MaxConcurrent = 10
+	backupOpts.StagingDir = opts.StagingDir
+	backupOpts.ExcludeDirs = opts.ExcludeDirs
+	backupOpts.SHA256Checksums = opts.SHA256Checksums
+	backupOpts.MaxArchiveSize = opts.MaxArchiveSize
+	backupOpts.ProgressChan = a.progressChan
+
+	report, err := backup.BackupDirectories(a.ctx, opts.SourceDir, opts.Bucket, backupOpts)
+	if err != nil {
 		logger.Error("Backup operation failed", "error", err)
 		return err
 	}
 
 	logger.Info("Backup operation completed successfully")
+	a.emitSummary(report.Summary())
 	return nil
 }
 
 // RestoreOptions holds options for the Restore operation
 type RestoreOptions struct {
-	Bucket     string `json:"bucket"`
-	TargetDir  string `json:"targetDir"`
-	FromFilter string `json:"fromFilter"`
-	ToFilter   string `json:"toFilter"`
+	Bucket         string `json:"bucket"`
+	TargetDir      string `json:"targetDir"`
+	FromFilter     string `json:"fromFilter"`
+	ToFilter       string `json:"toFilter"`
+	StagingDir     string `json:"stagingDir"`
+	VerifySHA256   bool   `json:"verifySha256"`
+	ChownToMe      bool   `json:"chownToMe"`
+	Merge          bool   `json:"merge"`
+	Refresh        bool   `json:"refresh"`
+	IncludeUndated bool   `json:"includeUndated"`
+	S3Connection
 }
 
 // Restore downloads and extracts archives from S3
-func (a *App) Restore(opts RestoreOptions) error {
+func (a *App) Restore(opts RestoreOptions) (err error) {
+	defer func() { a.finishOperation(err) }()
+
 	logger.Info("Starting restore operation", "bucket", opts.Bucket, "target", opts.TargetDir, "from", opts.FromFilter, "to", opts.ToFilter)
 
-	backup, err := pics.NewS3Backup(a.ctx)
+	backup, err := pics.NewS3Backup(a.ctx, opts.s3Config())
 	if err != nil {
 		logger.Error("Failed to create S3 backup client", "error", err)
 		return err
@@ -183,27 +422,63 @@ func (a *App) Restore(opts RestoreOptions) error {
 		filter.ToYear = year
 		filter.ToMonth = month
 	}
+	filter.IncludeUndated = opts.IncludeUndated
+
+	restoreOpts := pics.DefaultRestoreOptions()
+	restoreOpts.Filter = filter
+	restoreOpts.MaxConcurrent = 10
+	restoreOpts.StagingDir = opts.StagingDir
+	restoreOpts.VerifySHA256 = opts.VerifySHA256
+	if opts.Merge {
+		restoreOpts.OnConflict = pics.RestorePolicyMerge
+	}
+	restoreOpts.RefreshInventory = opts.Refresh
+	if cacheDir, err := pics.DefaultInventoryCacheDir(); err != nil {
+		logger.Warn("No cache directory, the bucket will be listed on every run", "error", err)
+	} else {
+		restoreOpts.InventoryCacheDir = cacheDir
+	}
+	if opts.ChownToMe {
+		owner, err := pics.InvokingUser()
+		if err != nil {
+			return fmt.Errorf("failed to determine invoking user: %w", err)
+		}
+		restoreOpts.Owner = &owner
+	}
+	restoreOpts.ProgressChan = a.progressChan
 
-	if err := backup.RestoreDirectories(a.ctx, opts.Bucket, opts.TargetDir, filter, 10, a.progressChan); err != nil {
+	report, err := backup.RestoreDirectories(a.ctx, opts.Bucket, opts.TargetDir, restoreOpts)
+	if err != nil {
 		logger.Error("Restore operation failed", "error", err)
 		return err
 	}
 
 	logger.Info("Restore operation completed successfully")
+	a.emitSummary(report.Summary())
 	return nil
 }
 
 // RenameOptions holds options for the Rename operation
 type RenameOptions struct {
-	Directory string `json:"directory"`
-	NewName   string `json:"newName"`
+	Directory     string `json:"directory"`
+	NewName       string `json:"newName"`
+	SequenceOrder string `json:"sequenceOrder"`
 }
 
 // Rename renames a date-based directory and its images
-func (a *App) Rename(opts RenameOptions) error {
+func (a *App) Rename(opts RenameOptions) (err error) {
+	defer func() { a.finishOperation(err) }()
+
 	logger.Info("Starting rename operation", "directory", opts.Directory, "newName", opts.NewName)
 
-	if err := a.renamer.RenameDirectory(opts.Directory, opts.NewName); err != nil {
+	order := pics.DefaultParseOptions().SequenceOrder
+	if opts.SequenceOrder != "" {
+		if order, err = pics.ParseSequenceOrder(opts.SequenceOrder); err != nil {
+			return err
+		}
+	}
+
+	if _, err := a.renamer.RenameDirectory(opts.Directory, opts.NewName, order); err != nil {
 		logger.Error("Rename operation failed", "error", err)
 		return err
 	}
@@ -212,10 +487,59 @@ func (a *App) Rename(opts RenameOptions) error {
 	return nil
 }
 
+// YearStats holds the counts of a year of the library shown on the dashboard
+type YearStats struct {
+	Year        int   `json:"year"`
+	Directories int   `json:"directories"`
+	Images      int   `json:"images"`
+	Videos      int   `json:"videos"`
+	Bytes       int64 `json:"bytes"`
+}
+
+// LibraryStats holds the dashboard of a library. Times are RFC 3339, or empty if it never happened.
+type LibraryStats struct {
+	Years      []YearStats `json:"years"`
+	LastImport string      `json:"lastImport"`
+	LastBackup string      `json:"lastBackup"`
+}
+
+// GetLibraryStats counts the media of a library by year and reads when it was last imported into and backed up
+func (a *App) GetLibraryStats(targetDir string) (LibraryStats, error) {
+	stats, err := pics.NewFileStats().GetLibraryStats(targetDir)
+	if err != nil {
+		logger.Error("Failed to read library statistics", "error", err)
+		return LibraryStats{}, err
+	}
+
+	result := LibraryStats{
+		Years:      make([]YearStats, 0, len(stats.Years)),
+		LastImport: formatActivityTime(stats.LastImport),
+		LastBackup: formatActivityTime(stats.LastBackup),
+	}
+	for _, year := range stats.Years {
+		result.Years = append(result.Years, YearStats{
+			Year:        year.Year,
+			Directories: year.Directories,
+			Images:      year.Images.Files,
+			Videos:      year.Videos.Files,
+			Bytes:       year.Images.Bytes + year.Videos.Bytes,
+		})
+	}
+	return result, nil
+}
+
+// formatActivityTime formats t as RFC 3339, or returns "" for the zero time
+func formatActivityTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
 // SelectDirectory opens a directory selection dialog
 func (a *App) SelectDirectory() (string, error) {
 	dir, err := runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
-		Title: "Select Directory",
+		Title: i18n.T("ui.select_directory"),
 	})
 	if err != nil {
 		return "", err
@@ -223,6 +547,22 @@ func (a *App) SelectDirectory() (string, error) {
 	return dir, nil
 }
 
+// GetLanguage returns the language of progress messages and dialogs
+func (a *App) GetLanguage() string {
+	return string(i18n.CurrentLanguage())
+}
+
+// SetLanguage changes the language of progress messages and dialogs, e.g. "en" or "es"
+func (a *App) SetLanguage(lang string) error {
+	parsed, ok := i18n.ParseLanguage(lang)
+	if !ok {
+		return fmt.Errorf("unsupported language: %s", lang)
+	}
+	i18n.SetLanguage(parsed)
+	logger.Info("Language changed", "language", parsed)
+	return nil
+}
+
 // GetVersion returns the application version
 func (a *App) GetVersion() string {
 	return version
@@ -56,6 +56,19 @@ func ExtractBinaries() (exiftoolPath, jpegoptimPath string, err error) {
 	return exiftoolPath, jpegoptimPath, nil
 }
 
+// exiftoolEnv returns the environment the exiftool extracted to exiftoolPath runs with: the
+// lib directory extracted next to it, on platforms that have one, is added to PERL5LIB
+func exiftoolEnv(exiftoolPath string) []string {
+	if !hasLib {
+		return nil
+	}
+	libDir := filepath.Join(filepath.Dir(exiftoolPath), "lib")
+	if existing := os.Getenv("PERL5LIB"); existing != "" {
+		libDir += string(os.PathListSeparator) + existing
+	}
+	return []string{"PERL5LIB=" + libDir}
+}
+
 // extractFile extracts a single file from the embedded filesystem to the destination path.
 func extractFile(src, dst string) error {
 	// Read from embedded FS
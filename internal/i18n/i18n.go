@@ -0,0 +1,93 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Language identifies a language of the message catalog by its ISO 639-1 code
+type Language string
+
+const (
+	English Language = "en"
+	Spanish Language = "es"
+)
+
+var (
+	mu      sync.RWMutex
+	current Language
+)
+
+func init() {
+	current = DetectLanguage()
+}
+
+// Languages returns the languages the catalog has messages for
+func Languages() []Language {
+	return []Language{English, Spanish}
+}
+
+// ParseLanguage parses a language code or a POSIX locale such as "es_ES.UTF-8".
+// It reports false for languages the catalog has no messages for.
+func ParseLanguage(s string) (Language, bool) {
+	code, _, _ := strings.Cut(strings.ToLower(s), "_")
+	code, _, _ = strings.Cut(code, "-")
+	code, _, _ = strings.Cut(code, ".")
+	for _, lang := range Languages() {
+		if Language(code) == lang {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// DetectLanguage picks the language from PICS_LANG, falling back to the locale
+// (LC_ALL, LC_MESSAGES, LANG) and then to English
+func DetectLanguage() Language {
+	for _, key := range []string{"PICS_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		if lang, ok := ParseLanguage(value); ok {
+			return lang
+		}
+		// The first locale variable set decides, as in POSIX
+		if key != "PICS_LANG" {
+			break
+		}
+	}
+	return English
+}
+
+// SetLanguage changes the language of messages returned by T
+func SetLanguage(lang Language) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = lang
+}
+
+// CurrentLanguage returns the language of messages returned by T
+func CurrentLanguage() Language {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// T returns the message with the given ID in the current language, formatted with args.
+// Messages missing from a translation fall back to English, and unknown IDs are returned as is.
+func T(id string, args ...any) string {
+	format, ok := catalog[CurrentLanguage()][id]
+	if !ok {
+		format, ok = catalog[English][id]
+	}
+	if !ok {
+		return id
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
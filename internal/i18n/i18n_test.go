@@ -0,0 +1,110 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+)
+
+// verbPattern matches fmt verbs such as %d and %s
+var verbPattern = regexp.MustCompile(`%[a-z]`)
+
+func TestCatalog_Complete(t *testing.T) {
+	for _, lang := range Languages() {
+		for id, english := range catalog[English] {
+			translated, ok := catalog[lang][id]
+			if !ok {
+				t.Errorf("Message %q is missing from %s", id, lang)
+				continue
+			}
+			expected := verbPattern.FindAllString(english, -1)
+			actual := verbPattern.FindAllString(translated, -1)
+			if len(expected) != len(actual) {
+				t.Errorf("Message %q in %s has verbs %v, expected %v", id, lang, actual, expected)
+				continue
+			}
+			for i := range expected {
+				if expected[i] != actual[i] {
+					t.Errorf("Message %q in %s has verbs %v, expected %v", id, lang, actual, expected)
+					break
+				}
+			}
+		}
+		for id := range catalog[lang] {
+			if _, ok := catalog[English][id]; !ok {
+				t.Errorf("Message %q in %s has no English original", id, lang)
+			}
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	original := CurrentLanguage()
+	t.Cleanup(func() { SetLanguage(original) })
+
+	SetLanguage(English)
+	if msg := T("progress.copying", 1, 3); msg != "Copying file 1 of 3" {
+		t.Errorf("Unexpected English message: %q", msg)
+	}
+
+	SetLanguage(Spanish)
+	if msg := T("progress.copying", 1, 3); msg != "Copiando archivo 1 de 3" {
+		t.Errorf("Unexpected Spanish message: %q", msg)
+	}
+	if msg := T("preview.no_inline"); msg != "(sin vista previa para este formato)" {
+		t.Errorf("Unexpected message without arguments: %q", msg)
+	}
+	if msg := T("unknown.message"); msg != "unknown.message" {
+		t.Errorf("Expected unknown ID to be returned as is, got %q", msg)
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Language
+		ok       bool
+	}{
+		{input: "es", expected: Spanish, ok: true},
+		{input: "es_ES.UTF-8", expected: Spanish, ok: true},
+		{input: "es-MX", expected: Spanish, ok: true},
+		{input: "en_GB.UTF-8", expected: English, ok: true},
+		{input: "EN", expected: English, ok: true},
+		{input: "fr_FR.UTF-8", ok: false},
+		{input: "C", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			lang, ok := ParseLanguage(tt.input)
+			if ok != tt.ok || lang != tt.expected {
+				t.Errorf("ParseLanguage(%q) = (%q, %v), expected (%q, %v)", tt.input, lang, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected Language
+	}{
+		{name: "nothing set", env: map[string]string{}, expected: English},
+		{name: "LANG", env: map[string]string{"LANG": "es_ES.UTF-8"}, expected: Spanish},
+		{name: "LC_ALL overrides LANG", env: map[string]string{"LC_ALL": "en_US.UTF-8", "LANG": "es_ES.UTF-8"}, expected: English},
+		{name: "PICS_LANG overrides locale", env: map[string]string{"PICS_LANG": "es", "LANG": "en_US.UTF-8"}, expected: Spanish},
+		{name: "unsupported PICS_LANG falls back to locale", env: map[string]string{"PICS_LANG": "fr", "LANG": "es_ES.UTF-8"}, expected: Spanish},
+		{name: "unsupported locale", env: map[string]string{"LANG": "fr_FR.UTF-8"}, expected: English},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PICS_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+				t.Setenv(key, tt.env[key])
+			}
+			if lang := DetectLanguage(); lang != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, lang)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package i18n
+
+// catalog holds every user-facing message by language and message ID.
+// Message IDs are grouped by where they are shown: progress events and milestones, then CLI output.
+var catalog = map[Language]map[string]string{
+	English: {
+		"progress.backing_up":              "Backing up directory %d of %d",
+		"progress.verifying":               "Verifying the backup of directory %d of %d",
+		"progress.uploading":               "Uploading directory %d of %d",
+		"progress.restoring":               "Restoring directory %d of %d",
+		"progress.merging_directory":       "Merging directory %d of %d",
+		"progress.syncing":                 "Syncing directory %d of %d",
+		"progress.scrubbing":               "Verifying file %d of %d",
+		"progress.preparing":               "Preparing file %d of %d",
+		"progress.copying":                 "Copying file %d of %d",
+		"progress.compressing":             "Compressing file %d of %d",
+		"progress.organising_file":         "Organising file %d of %d",
+		"progress.organising_directory":    "Organising directory %d of %d",
+		"progress.locating_directory":      "Finding the place of directory %d of %d",
+		"stage.backing_up":                 "Backup",
+		"stage.verifying":                  "Backup verification",
+		"stage.uploading":                  "Upload",
+		"stage.restoring":                  "Restore",
+		"stage.merging":                    "Merge",
+		"stage.syncing":                    "Sync",
+		"stage.scrubbing":                  "Scrub",
+		"stage.renaming":                   "Renaming",
+		"stage.copying":                    "Copying",
+		"stage.compressing":                "Compression",
+		"stage.organising":                 "Organising",
+		"stage.locating":                   "Location",
+		"phase.archiving":                  "archiving",
+		"phase.uploading":                  "uploading",
+		"phase.downloading":                "downloading",
+		"phase.extracting":                 "extracting",
+		"progress.phase":                   "%s %d%%",
+		"milestone.started":                "%s started",
+		"milestone.progress":               "%s %d%% complete",
+		"milestone.completed":              "%s complete",
+		"milestone.operation_finished":     "Operation finished",
+		"milestone.operation_failed":       "Operation failed: %s",
+		"summary.parse":                    "Parse finished in %s",
+		"summary.backup":                   "Backup finished in %s",
+		"summary.archive":                  "Archiving finished in %s",
+		"summary.restore":                  "Restore finished in %s",
+		"summary.merge":                    "Merge finished in %s",
+		"summary.sync":                     "Sync finished in %s",
+		"summary.scrub":                    "Scrub finished in %s",
+		"summary.verify_backup":            "Backup verification finished in %s",
+		"summary.imported":                 "Imported %d files (%s)",
+		"summary.compression_saved":        "Compression saved %s",
+		"summary.too_small":                "Skipped %d files smaller than the minimum file size",
+		"summary.moved":                    "Moved %d imported files (%s) to the trash of the source",
+		"summary.pruned":                   "Removed %d emptied source directories, left %d in place",
+		"summary.located":                  "Named %d directories after the place they were taken at",
+		"summary.uploaded":                 "Backed up %d directories, uploaded %d archives (%s)",
+		"summary.existing":                 "%d archives (%s) were already in the bucket and not uploaded",
+		"summary.uploaded_files":           "Backed up %d directories, uploaded %d files (%s)",
+		"summary.existing_files":           "%d files (%s) were unchanged since the last backup and not uploaded",
+		"summary.unchanged_dirs":           "%d directories needed uploading, %d were unchanged",
+		"summary.staged":                   "Archived %d directories, %d archives waiting in %s",
+		"summary.restored":                 "Restored %d directories (%s downloaded)",
+		"summary.merged":                   "Merged %d directories: %d files added, %d duplicates dropped",
+		"summary.replaced":                 "Replaced %d existing directories with their backup",
+		"summary.skipped_existing":         "%d directories were already in the target and skipped",
+		"summary.pushed":                   "Pushed %d directories (%s uploaded)",
+		"summary.pulled":                   "Pulled %d directories (%s downloaded)",
+		"summary.merged_conflicts":         "Merged %d directories changed on both sides",
+		"summary.merged_libraries":         "Merged %d directories into existing ones and copied %d new ones",
+		"summary.merged_files":             "%d files added, %d duplicates skipped",
+		"summary.merge_skipped":            "%d entries of the source library that aren't directories skipped",
+		"summary.in_sync":                  "%d directories were already in sync",
+		"summary.verified":                 "Verified %d files",
+		"summary.checksums_recorded":       "Recorded the checksums of %d new and %d modified files",
+		"summary.recently_verified":        "%d files were verified recently and skipped",
+		"summary.repaired":                 "Repaired %d corrupted files from the backup",
+		"summary.backup_current":           "%d of %d directories match their backup",
+		"summary.backup_stale":             "%d directories changed since they were backed up",
+		"summary.backup_missing":           "%d directories were never backed up",
+		"summary.failed":                   "%d directories failed",
+		"summary.warnings":                 "%d warnings",
+		"summary.next_steps":               "Next steps:",
+		"summary.next.review":              "%d files have implausible dates, review them in %s",
+		"summary.next.changing":            "%d files were still being written, import them again once they are complete",
+		"summary.next.trash":               "The imported files still take up space in the trash, free it once the library is checked with: pics trash empty %s",
+		"summary.next.upload":              "Upload the %d staged archives with --upload-only %s",
+		"summary.next.incomplete":          "%d archive parts have no manifest, back up their directories again",
+		"summary.next.thawing":             "%d directories are being restored from cold storage, which takes hours, restore again later or wait with --wait-for-restore",
+		"summary.next.conflicts":           "%d directories changed on both sides were skipped, sync again with --merge-conflicts to combine them",
+		"summary.next.quarantined":         "%d corrupted files were moved to %s, restore them from another copy",
+		"summary.next.corrupted":           "%d files are corrupted or unreadable, restore them from another copy",
+		"summary.next.backup_outdated":     "Back up the %d directories whose backup is out of date",
+		"summary.next.failed":              "Run again to retry the %d directories that failed",
+		"summary.next.warnings":            "Read the %d warnings in the log",
+		"cmd.root.short":                   "A Go application for organising and compressing photos and videos",
+		"cmd.parse.short":                  "Process and organise media files",
+		"cmd.import_scans.short":           "Import scanned photos under a date you give",
+		"cmd.watch.short":                  "Import new media as it appears in a directory",
+		"cmd.import.short":                 "Import media from attached cameras, phones and cards",
+		"cmd.rename.short":                 "Rename a date-based directory and its images",
+		"cmd.backup.short":                 "Backup directories to S3",
+		"cmd.restore.short":                "Restore directories from S3",
+		"cmd.list.short":                   "List the directories backed up to S3",
+		"cmd.sync.short":                   "Sync a library with S3 in both directions",
+		"cmd.scrub.short":                  "Detect corrupted files in a library",
+		"cmd.diff.short":                   "Compare two organised libraries",
+		"cmd.verify.short":                 "Check that a library is organised the way parse leaves it",
+		"cmd.restore_names.short":          "Rename files back to the original names recorded in their metadata",
+		"cmd.merge.short":                  "Merge a library organised by parse into another",
+		"cmd.stats.short":                  "Report the photos and videos of a library by year and month",
+		"cmd.check_manifest.short":         "Check the files of a directory or library against their manifest.sha256",
+		"cmd.search.short":                 "Find media files by rating",
+		"cmd.export.short":                 "Export phone-sized copies of favourite images",
+		"cmd.sessions.short":               "List temporary files of interrupted runs",
+		"cmd.sessions_recover.short":       "Adopt, clean up or resume an interrupted run",
+		"cmd.trash.short":                  "List the files parse --move put in a trash",
+		"cmd.trash_empty.short":            "Delete the files in a trash for good",
+		"cmd.trash_restore.short":          "Put the files of a run in a trash back where they were",
+		"cmd.preview.short":                "Show media previews in the terminal",
+		"cmd.contact_sheet.short":          "Print thumbnails of a month or event to a PDF",
+		"cmd.install_autocomplete.short":   "Install shell completion for pics",
+		"cmd.uninstall_autocomplete.short": "Uninstall shell completion for pics",
+		"diff.directory":                   "Directory",
+		"diff.added":                       "Added",
+		"diff.removed":                     "Removed",
+		"diff.changed":                     "Changed",
+		"verify.problem":                   "Problem",
+		"verify.path":                      "Path",
+		"verify.expected":                  "Expected",
+		"verify.ok":                        "No problems found in %d directories and %d files",
+		"verify.total":                     "%d problems in %d directories and %d files",
+		"verify.kind.directory_name":       "directory name",
+		"verify.kind.file_name":            "file name",
+		"verify.kind.sequence_gap":         "missing numbers",
+		"verify.kind.duplicate_number":     "duplicate number",
+		"verify.kind.misplaced_video":      "video outside videos",
+		"verify.kind.misplaced_image":      "image in videos",
+		"verify.kind.empty_file":           "empty file",
+		"manifest.problem":                 "Problem",
+		"manifest.path":                    "Path",
+		"manifest.ok":                      "All files match their checksums in %d directories and %d files",
+		"manifest.total":                   "%d problems in %d directories, %d files match their checksums",
+		"manifest.kind.changed":            "changed",
+		"manifest.kind.missing":            "missing",
+		"manifest.kind.unlisted":           "not in manifest",
+		"restore_names.file":               "File",
+		"restore_names.original":           "Original name",
+		"restore_names.taken":              "%s (taken)",
+		"restore_names.total":              "%d names restored, %d taken, %d files without an original name, %d already named so",
+		"restore_names.total_dry_run":      "%d names to restore, %d taken, %d files without an original name, %d already named so",
+		"stats.none":                       "No date directories found",
+		"stats.period":                     "Period",
+		"stats.directories":                "Directories",
+		"stats.photos":                     "Photos",
+		"stats.videos":                     "Videos",
+		"stats.size":                       "Size",
+		"stats.total":                      "%d photos (%s) and %d videos (%s)",
+		"stats.average_jpeg":               "Average JPEG size: %s over %d JPEGs",
+		"stats.named":                      "%d directories named after an event, %d with the date alone",
+		"stats.largest":                    "%d largest directories:",
+		"stats.directory":                  "Directory",
+		"verify_backup.directory":          "Directory",
+		"verify_backup.backup":             "Backup",
+		"verify_backup.stale":              "stale",
+		"verify_backup.missing":            "missing",
+		"failed.directory":                 "Directory",
+		"failed.archive":                   "Archive",
+		"failed.error":                     "Error",
+		"sessions.id":                      "ID",
+		"sessions.kind":                    "Kind",
+		"sessions.stage":                   "Stage",
+		"sessions.started":                 "Started",
+		"sessions.command":                 "Command",
+		"sessions.none":                    "No temporary files of interrupted runs",
+		"sessions.hint":                    "Recover them with: pics sessions recover ID --resume, --adopt or --clean",
+		"trash.run":                        "Run",
+		"trash.files":                      "Files",
+		"trash.size":                       "Size",
+		"trash.none":                       "The trash is empty",
+		"trash.hint":                       "Restore a run with: pics trash restore DIR RUN, or free the space with: pics trash empty DIR",
+		"devices.name":                     "Device",
+		"devices.dcim":                     "DCIM directory",
+		"devices.none":                     "No cameras, phones or cards with a DCIM directory found",
+		"list.directory":                   "Directory",
+		"list.images":                      "Images",
+		"list.videos":                      "Videos",
+		"list.size":                        "Size",
+		"list.last_backup":                 "Last backup",
+		"list.none":                        "No backed up directories found",
+		"list.total":                       "%d directories, %d images, %d videos (%s)",
+		"restore.plan.action":              "Restore",
+		"restore.plan.new":                 "new",
+		"restore.plan.merge":               "merge",
+		"restore.plan.exists":              "exists",
+		"restore.plan.skip":                "skip",
+		"restore.plan.overwrite":           "overwrite",
+		"restore.plan.total":               "Would download %d directories (%s), nothing was restored",
+		"restore.plan.hint":                "%d directories already exist, restore them with --on-conflict merge, skip-existing or overwrite",
+		"preview.no_inline":                "(no inline preview for this format)",
+		"ui.select_directory":              "Select Directory",
+	},
+	Spanish: {
+		"progress.backing_up":              "Haciendo copia de seguridad del directorio %d de %d",
+		"progress.verifying":               "Verificando la copia de seguridad del directorio %d de %d",
+		"progress.uploading":               "Subiendo directorio %d de %d",
+		"progress.restoring":               "Restaurando directorio %d de %d",
+		"progress.merging_directory":       "Fusionando directorio %d de %d",
+		"progress.syncing":                 "Sincronizando directorio %d de %d",
+		"progress.scrubbing":               "Verificando archivo %d de %d",
+		"progress.preparing":               "Preparando archivo %d de %d",
+		"progress.copying":                 "Copiando archivo %d de %d",
+		"progress.compressing":             "Comprimiendo archivo %d de %d",
+		"progress.organising_file":         "Organizando archivo %d de %d",
+		"progress.organising_directory":    "Organizando directorio %d de %d",
+		"progress.locating_directory":      "Buscando el lugar del directorio %d de %d",
+		"stage.backing_up":                 "Copia de seguridad",
+		"stage.verifying":                  "Verificación de la copia",
+		"stage.uploading":                  "Subida",
+		"stage.restoring":                  "Restauración",
+		"stage.merging":                    "Fusión",
+		"stage.syncing":                    "Sincronización",
+		"stage.scrubbing":                  "Verificación",
+		"stage.renaming":                   "Renombrado",
+		"stage.copying":                    "Copia",
+		"stage.compressing":                "Compresión",
+		"stage.organising":                 "Organización",
+		"stage.locating":                   "Ubicación",
+		"phase.archiving":                  "archivando",
+		"phase.uploading":                  "subiendo",
+		"phase.downloading":                "descargando",
+		"phase.extracting":                 "extrayendo",
+		"progress.phase":                   "%s %d%%",
+		"milestone.started":                "%s: inicio",
+		"milestone.progress":               "%s: %d%% completado",
+		"milestone.completed":              "%s: completado",
+		"milestone.operation_finished":     "Operación terminada",
+		"milestone.operation_failed":       "La operación ha fallado: %s",
+		"summary.parse":                    "Procesado terminado en %s",
+		"summary.backup":                   "Copia de seguridad terminada en %s",
+		"summary.archive":                  "Archivado terminado en %s",
+		"summary.restore":                  "Restauración terminada en %s",
+		"summary.merge":                    "Fusión terminada en %s",
+		"summary.sync":                     "Sincronización terminada en %s",
+		"summary.scrub":                    "Verificación terminada en %s",
+		"summary.verify_backup":            "Verificación de la copia de seguridad terminada en %s",
+		"summary.imported":                 "%d archivos importados (%s)",
+		"summary.compression_saved":        "La compresión ha ahorrado %s",
+		"summary.too_small":                "%d archivos omitidos por ser menores que el tamaño mínimo",
+		"summary.moved":                    "%d archivos importados (%s) movidos a la papelera del origen",
+		"summary.pruned":                   "%d directorios de origen vaciados eliminados, %d conservados",
+		"summary.located":                  "%d directorios nombrados según el lugar donde se tomaron",
+		"summary.uploaded":                 "%d directorios copiados, %d archivos comprimidos subidos (%s)",
+		"summary.existing":                 "%d archivos comprimidos (%s) ya estaban en el bucket y no se han subido",
+		"summary.uploaded_files":           "%d directorios copiados, %d archivos subidos (%s)",
+		"summary.existing_files":           "%d archivos (%s) no habían cambiado desde la última copia y no se han subido",
+		"summary.unchanged_dirs":           "%d directorios necesitaban subirse, %d no habían cambiado",
+		"summary.staged":                   "%d directorios archivados, %d archivos comprimidos esperando en %s",
+		"summary.restored":                 "%d directorios restaurados (%s descargados)",
+		"summary.merged":                   "%d directorios fusionados: %d archivos añadidos, %d duplicados descartados",
+		"summary.replaced":                 "%d directorios existentes reemplazados por su copia de seguridad",
+		"summary.skipped_existing":         "%d directorios ya estaban en el destino y se han omitido",
+		"summary.pushed":                   "%d directorios enviados (%s subidos)",
+		"summary.pulled":                   "%d directorios recibidos (%s descargados)",
+		"summary.merged_conflicts":         "%d directorios cambiados en ambos lados fusionados",
+		"summary.merged_libraries":         "%d directorios fusionados con los existentes y %d nuevos copiados",
+		"summary.merged_files":             "%d archivos añadidos, %d duplicados omitidos",
+		"summary.merge_skipped":            "%d entradas de la biblioteca de origen que no son directorios omitidas",
+		"summary.in_sync":                  "%d directorios ya estaban sincronizados",
+		"summary.verified":                 "%d archivos verificados",
+		"summary.checksums_recorded":       "Sumas de verificación registradas de %d archivos nuevos y %d modificados",
+		"summary.recently_verified":        "%d archivos verificados recientemente se han omitido",
+		"summary.repaired":                 "%d archivos dañados reparados desde la copia de seguridad",
+		"summary.backup_current":           "%d de %d directorios coinciden con su copia de seguridad",
+		"summary.backup_stale":             "%d directorios han cambiado desde su copia de seguridad",
+		"summary.backup_missing":           "%d directorios no tienen copia de seguridad",
+		"summary.failed":                   "%d directorios han fallado",
+		"summary.warnings":                 "%d avisos",
+		"summary.next_steps":               "Siguientes pasos:",
+		"summary.next.review":              "%d archivos tienen fechas improbables, revísalos en %s",
+		"summary.next.changing":            "%d archivos aún se estaban escribiendo, impórtalos de nuevo cuando estén completos",
+		"summary.next.trash":               "Los archivos importados aún ocupan espacio en la papelera, libéralo cuando hayas revisado la biblioteca con: pics trash empty %s",
+		"summary.next.upload":              "Sube los %d archivos comprimidos preparados con --upload-only %s",
+		"summary.next.incomplete":          "%d partes de archivo no tienen manifiesto, vuelve a hacer copia de sus directorios",
+		"summary.next.thawing":             "%d directorios se están recuperando del almacenamiento en frío, lo que lleva horas, restaura de nuevo más tarde o espera con --wait-for-restore",
+		"summary.next.conflicts":           "%d directorios cambiados en ambos lados se han omitido, sincroniza de nuevo con --merge-conflicts para combinarlos",
+		"summary.next.quarantined":         "%d archivos dañados se han movido a %s, restáuralos desde otra copia",
+		"summary.next.corrupted":           "%d archivos están dañados o no se pueden leer, restáuralos desde otra copia",
+		"summary.next.backup_outdated":     "Haz una copia de seguridad de los %d directorios cuya copia está desactualizada",
+		"summary.next.failed":              "Vuelve a ejecutar para reintentar los %d directorios que han fallado",
+		"summary.next.warnings":            "Lee los %d avisos en el registro",
+		"cmd.root.short":                   "Una aplicación en Go para organizar y comprimir fotos y vídeos",
+		"cmd.parse.short":                  "Procesar y organizar archivos multimedia",
+		"cmd.import_scans.short":           "Importar fotos escaneadas con la fecha que indiques",
+		"cmd.watch.short":                  "Importar los archivos nuevos según aparecen en un directorio",
+		"cmd.import.short":                 "Importar archivos de cámaras, móviles y tarjetas conectados",
+		"cmd.rename.short":                 "Renombrar un directorio con fecha y sus imágenes",
+		"cmd.backup.short":                 "Hacer copia de seguridad de directorios en S3",
+		"cmd.restore.short":                "Restaurar directorios desde S3",
+		"cmd.list.short":                   "Listar los directorios copiados en S3",
+		"cmd.sync.short":                   "Sincronizar una biblioteca con S3 en ambos sentidos",
+		"cmd.scrub.short":                  "Detectar archivos dañados en una biblioteca",
+		"cmd.diff.short":                   "Comparar dos bibliotecas organizadas",
+		"cmd.verify.short":                 "Comprobar que una biblioteca está organizada como la deja parse",
+		"cmd.restore_names.short":          "Devolver a los archivos los nombres originales guardados en sus metadatos",
+		"cmd.merge.short":                  "Fusionar una biblioteca organizada por parse en otra",
+		"cmd.stats.short":                  "Informar de las fotos y vídeos de una biblioteca por año y mes",
+		"cmd.check_manifest.short":         "Comprobar los archivos de un directorio o biblioteca con su manifest.sha256",
+		"cmd.search.short":                 "Buscar archivos multimedia por valoración",
+		"cmd.export.short":                 "Exportar copias para el móvil de las imágenes favoritas",
+		"cmd.sessions.short":               "Listar los archivos temporales de ejecuciones interrumpidas",
+		"cmd.sessions_recover.short":       "Adoptar, limpiar o reanudar una ejecución interrumpida",
+		"cmd.trash.short":                  "Listar los archivos que parse --move puso en una papelera",
+		"cmd.trash_empty.short":            "Borrar para siempre los archivos de una papelera",
+		"cmd.trash_restore.short":          "Devolver a su sitio los archivos de una ejecución de una papelera",
+		"cmd.preview.short":                "Mostrar vistas previas en el terminal",
+		"cmd.contact_sheet.short":          "Imprimir miniaturas de un mes o evento en un PDF",
+		"cmd.install_autocomplete.short":   "Instalar el autocompletado de pics en la shell",
+		"cmd.uninstall_autocomplete.short": "Desinstalar el autocompletado de pics de la shell",
+		"diff.directory":                   "Directorio",
+		"diff.added":                       "Añadidos",
+		"diff.removed":                     "Eliminados",
+		"diff.changed":                     "Modificados",
+		"verify.problem":                   "Problema",
+		"verify.path":                      "Ruta",
+		"verify.expected":                  "Esperado",
+		"verify.ok":                        "No se encontraron problemas en %d directorios y %d archivos",
+		"verify.total":                     "%d problemas en %d directorios y %d archivos",
+		"verify.kind.directory_name":       "nombre de directorio",
+		"verify.kind.file_name":            "nombre de archivo",
+		"verify.kind.sequence_gap":         "números que faltan",
+		"verify.kind.duplicate_number":     "número duplicado",
+		"verify.kind.misplaced_video":      "vídeo fuera de videos",
+		"verify.kind.misplaced_image":      "imagen en videos",
+		"verify.kind.empty_file":           "archivo vacío",
+		"manifest.problem":                 "Problema",
+		"manifest.path":                    "Ruta",
+		"manifest.ok":                      "Todos los archivos coinciden con sus sumas en %d directorios y %d archivos",
+		"manifest.total":                   "%d problemas en %d directorios, %d archivos coinciden con sus sumas",
+		"manifest.kind.changed":            "modificado",
+		"manifest.kind.missing":            "no existe",
+		"manifest.kind.unlisted":           "fuera del manifiesto",
+		"restore_names.file":               "Archivo",
+		"restore_names.original":           "Nombre original",
+		"restore_names.taken":              "%s (ocupado)",
+		"restore_names.total":              "%d nombres restaurados, %d ocupados, %d archivos sin nombre original, %d ya con ese nombre",
+		"restore_names.total_dry_run":      "%d nombres por restaurar, %d ocupados, %d archivos sin nombre original, %d ya con ese nombre",
+		"stats.none":                       "No se encontraron directorios con fecha",
+		"stats.period":                     "Periodo",
+		"stats.directories":                "Directorios",
+		"stats.photos":                     "Fotos",
+		"stats.videos":                     "Vídeos",
+		"stats.size":                       "Tamaño",
+		"stats.total":                      "%d fotos (%s) y %d vídeos (%s)",
+		"stats.average_jpeg":               "Tamaño medio de JPEG: %s en %d JPEGs",
+		"stats.named":                      "%d directorios con nombre de evento, %d solo con la fecha",
+		"stats.largest":                    "Los %d directorios más grandes:",
+		"stats.directory":                  "Directorio",
+		"verify_backup.directory":          "Directorio",
+		"verify_backup.backup":             "Copia",
+		"verify_backup.stale":              "desactualizada",
+		"verify_backup.missing":            "falta",
+		"failed.directory":                 "Directorio",
+		"failed.archive":                   "Archivo comprimido",
+		"failed.error":                     "Error",
+		"sessions.id":                      "ID",
+		"sessions.kind":                    "Tipo",
+		"sessions.stage":                   "Etapa",
+		"sessions.started":                 "Inicio",
+		"sessions.command":                 "Comando",
+		"sessions.none":                    "No hay archivos temporales de ejecuciones interrumpidas",
+		"sessions.hint":                    "Recupéralos con: pics sessions recover ID --resume, --adopt o --clean",
+		"trash.run":                        "Ejecución",
+		"trash.files":                      "Archivos",
+		"trash.size":                       "Tamaño",
+		"trash.none":                       "La papelera está vacía",
+		"trash.hint":                       "Restaura una ejecución con: pics trash restore DIR EJECUCIÓN, o libera el espacio con: pics trash empty DIR",
+		"devices.name":                     "Dispositivo",
+		"devices.dcim":                     "Directorio DCIM",
+		"devices.none":                     "No se han encontrado cámaras, móviles ni tarjetas con un directorio DCIM",
+		"list.directory":                   "Directorio",
+		"list.images":                      "Imágenes",
+		"list.videos":                      "Vídeos",
+		"list.size":                        "Tamaño",
+		"list.last_backup":                 "Última copia",
+		"list.none":                        "No se encontraron directorios copiados",
+		"list.total":                       "%d directorios, %d imágenes, %d vídeos (%s)",
+		"restore.plan.action":              "Restauración",
+		"restore.plan.new":                 "nuevo",
+		"restore.plan.merge":               "combinar",
+		"restore.plan.exists":              "existe",
+		"restore.plan.skip":                "omitir",
+		"restore.plan.overwrite":           "reemplazar",
+		"restore.plan.total":               "Se descargarían %d directorios (%s), no se ha restaurado nada",
+		"restore.plan.hint":                "%d directorios ya existen, restáuralos con --on-conflict merge, skip-existing u overwrite",
+		"preview.no_inline":                "(sin vista previa para este formato)",
+		"ui.select_directory":              "Seleccionar directorio",
+	},
+}
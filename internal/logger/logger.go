@@ -1,24 +1,171 @@
 package logger
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
-var log *slog.Logger
+// Format is how log lines are written
+type Format string
+
+const (
+	// FormatText writes each line as key=value pairs
+	FormatText Format = "text"
+	// FormatJSON writes each line as a JSON object, for log collectors
+	FormatJSON Format = "json"
+)
+
+var (
+	log       *slog.Logger
+	level     slog.LevelVar
+	format    = FormatText
+	sessionID string
+	warnings  atomic.Int64
+	console   io.Writer = os.Stdout
+	logFile   io.Writer
+)
 
 func init() {
-	level := slog.LevelInfo
 	if os.Getenv("DEBUG") != "" {
-		level = slog.LevelDebug
+		level.Set(slog.LevelDebug)
 	}
 
+	sessionID = newSessionID()
+	log = newLogger(output())
+}
+
+// newSessionID returns a short random ID that tells the lines of one run apart from another's
+func newSessionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// output returns where logs are written: the console, and the log file if there is one
+func output() io.Writer {
+	if logFile == nil {
+		return console
+	}
+	return io.MultiWriter(console, logFile)
+}
+
+// newLogger creates the base logger writing to w, tagging every line with the session ID
+func newLogger(w io.Writer) *slog.Logger {
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level: &level,
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(w, opts)
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(handler).With("session", sessionID)
+}
+
+// SessionID returns the ID attached to every log line of this run.
+func SessionID() string {
+	return sessionID
+}
+
+// ParseFormat parses "text" or "json"
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid log format %q (expected text or json)", s)
+}
+
+// SetFormat writes logs in format f. Like SetLogFile, it must be called before any Logger is
+// created with With.
+func SetFormat(f Format) {
+	format = f
+	log = newLogger(output())
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", s)
+}
+
+// SetLevel only logs lines at level l or above, overriding the DEBUG environment variable.
+// It applies to every Logger, including those already created with With.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// SetLogFile writes logs to the file at path in addition to stdout. The file is appended to.
+// It must be called before logging starts and before any Logger is created with With.
+func SetLogFile(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
 	}
+	logFile = file
+	log = newLogger(output())
+	return nil
+}
+
+// SetConsole shows logs on w instead of stdout, e.g. to print them above a progress bar, and
+// SetConsole(os.Stdout) shows them on stdout again. The log file set with SetLogFile is kept.
+// Like SetLogFile, it must be called before any Logger is created with With.
+func SetConsole(w io.Writer) {
+	console = w
+	log = newLogger(output())
+}
 
-	handler := slog.NewTextHandler(os.Stdout, opts)
-	log = slog.New(handler)
+// Logger logs with a fixed set of attributes, such as a worker ID or the file being processed.
+type Logger struct {
+	log *slog.Logger
+}
+
+// With returns a Logger that adds the given key-value pairs to every line.
+func With(args ...any) *Logger {
+	return &Logger{log: log.With(args...)}
+}
+
+// With returns a Logger that adds the given key-value pairs to those of l.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{log: l.log.With(args...)}
+}
+
+// Info logs at info level.
+func (l *Logger) Info(msg string, args ...any) {
+	l.log.Info(msg, args...)
+}
+
+// Error logs at error level.
+func (l *Logger) Error(msg string, args ...any) {
+	l.log.Error(msg, args...)
+}
+
+// Debug logs at debug level.
+func (l *Logger) Debug(msg string, args ...any) {
+	l.log.Debug(msg, args...)
+}
+
+// Warn logs at warn level.
+func (l *Logger) Warn(msg string, args ...any) {
+	warnings.Add(1)
+	l.log.Warn(msg, args...)
 }
 
 // Info logs at info level.
@@ -38,5 +185,12 @@ func Debug(msg string, args ...any) {
 
 // Warn logs at warn level.
 func Warn(msg string, args ...any) {
+	warnings.Add(1)
 	log.Warn(msg, args...)
 }
+
+// Warnings returns the number of warnings logged so far. Commands report the warnings of
+// a run as the difference between the counts at its start and end.
+func Warnings() int {
+	return int(warnings.Load())
+}
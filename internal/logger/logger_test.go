@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionID(t *testing.T) {
+	if len(SessionID()) != 8 {
+		t.Errorf("Expected 8 character session ID, got %q", SessionID())
+	}
+	if newSessionID() == newSessionID() {
+		t.Error("Expected session IDs to differ between runs")
+	}
+}
+
+func TestSetLogFile(t *testing.T) {
+	original, originalFile := log, logFile
+	t.Cleanup(func() { log, logFile = original, originalFile })
+
+	logPath := filepath.Join(t.TempDir(), "pics.log")
+	if err := SetLogFile(logPath); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	Info("Package-level message", "key", "value")
+	With("worker", 3).With("file", "/photos/a.jpg").Warn("Worker message")
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	content := string(data)
+
+	for _, expected := range []string{
+		"msg=\"Package-level message\"",
+		"key=value",
+		"msg=\"Worker message\"",
+		"worker=3",
+		"file=/photos/a.jpg",
+		"session=" + SessionID(),
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected log file to contain %q, got:\n%s", expected, content)
+		}
+	}
+}
+
+func TestSetConsole(t *testing.T) {
+	original, originalFile, originalConsole := log, logFile, console
+	t.Cleanup(func() { log, logFile, console = original, originalFile, originalConsole })
+
+	logPath := filepath.Join(t.TempDir(), "pics.log")
+	if err := SetLogFile(logPath); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var buf bytes.Buffer
+	SetConsole(&buf)
+	Info("Shown above the progress bar")
+
+	if !strings.Contains(buf.String(), "msg=\"Shown above the progress bar\"") {
+		t.Errorf("Expected the message on the console, got: %q", buf.String())
+	}
+	if data, err := os.ReadFile(logPath); err != nil || !strings.Contains(string(data), "Shown above the progress bar") {
+		t.Errorf("Expected the log file kept, got %q (%v)", data, err)
+	}
+}
+
+func TestSetFormat_JSON(t *testing.T) {
+	original, originalFile, originalConsole, originalFormat := log, logFile, console, format
+	t.Cleanup(func() {
+		log, logFile, console = original, originalFile, originalConsole
+		SetFormat(originalFormat)
+	})
+
+	var buf bytes.Buffer
+	SetConsole(&buf)
+	SetFormat(FormatJSON)
+	With("worker", 3).Warn("Worker message", "file", "/photos/a.jpg")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]any{
+		"level":   "WARN",
+		"msg":     "Worker message",
+		"session": SessionID(),
+		"worker":  float64(3),
+		"file":    "/photos/a.jpg",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s = %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["time"]; !ok {
+		t.Errorf("Expected a time, got %v", line)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"text": FormatText, "json": FormatJSON, "JSON": FormatJSON} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	originalLevel, original, originalConsole := level.Level(), log, console
+	t.Cleanup(func() {
+		level.Set(originalLevel)
+		log, console = original, originalConsole
+	})
+
+	var buf bytes.Buffer
+	SetConsole(&buf)
+	worker := With("worker", 1)
+	SetLevel(slog.LevelWarn)
+	Info("Left out")
+	worker.Info("Left out too")
+	worker.Warn("Kept")
+	if strings.Contains(buf.String(), "Left out") || !strings.Contains(buf.String(), "Kept") {
+		t.Errorf("Expected only the warning logged, got: %q", buf.String())
+	}
+
+	SetLevel(slog.LevelDebug)
+	Debug("Debug message")
+	if !strings.Contains(buf.String(), "Debug message") {
+		t.Errorf("Expected debug lines logged, got: %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for input, want := range tests {
+		if got, err := ParseLevel(input); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
+
+func TestSetLogFile_InvalidPath(t *testing.T) {
+	if err := SetLogFile(filepath.Join(t.TempDir(), "missing", "pics.log")); err == nil {
+		t.Error("Expected error for log file in nonexistent directory")
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	before := Warnings()
+	Warn("Package-level warning")
+	With("worker", 1).Warn("Worker warning")
+	Info("Not a warning")
+	if got := Warnings() - before; got != 2 {
+		t.Errorf("Expected 2 warnings, got %d", got)
+	}
+}
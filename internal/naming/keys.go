@@ -0,0 +1,82 @@
+package naming
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyEscape starts an escaped byte in an S3 key, e.g. "#" is written as "!23"
+const keyEscape = '!'
+
+// isSafeKeyByte reports whether b can appear unescaped in an S3 key.
+// These are the characters S3 documents as safe, plus the space used by date directory names.
+// Parentheses are escaped so they can't be mistaken for the " (N images, M videos)" suffix.
+func isSafeKeyByte(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte(" -_.*'", b) != -1
+}
+
+// EncodeKeyName makes a directory name safe to use in an S3 key.
+// Names made only of safe characters are unchanged; every other byte, including
+// the escape character itself, is written as "!" followed by two hex digits.
+func EncodeKeyName(name string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if b := name[i]; b != keyEscape && isSafeKeyByte(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%c%02X", keyEscape, b)
+		}
+	}
+	return encoded.String()
+}
+
+// DecodeKeyName reverses EncodeKeyName. An escape character not followed by two hex
+// digits is kept as is, so keys written before names were encoded still decode.
+func DecodeKeyName(key string) string {
+	var decoded strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] == keyEscape && i+2 < len(key) {
+			if b, err := strconv.ParseUint(key[i+1:i+3], 16, 8); err == nil {
+				decoded.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		decoded.WriteByte(key[i])
+	}
+	return decoded.String()
+}
+
+// ArchiveKey returns the key of the backup of directory dirName holding the given number of
+// images and videos, e.g. "2023 06 June 15 (3 images, 1 videos)", to which the extension of
+// the object is added
+func ArchiveKey(dirName string, images, videos int) string {
+	return fmt.Sprintf("%s (%d images, %d videos)", EncodeKeyName(dirName), images, videos)
+}
+
+// KeyDirName returns the name of the directory an archive key made by ArchiveKey belongs to,
+// whatever follows the key
+func KeyDirName(key string) string {
+	if idx := strings.Index(key, " ("); idx != -1 {
+		key = key[:idx]
+	}
+	return DecodeKeyName(key)
+}
+
+// KeyCounts returns the number of images and videos recorded in an archive key made by
+// ArchiveKey, whatever follows the key
+func KeyCounts(key string) (images, videos int, ok bool) {
+	idx := strings.Index(key, " (")
+	if idx == -1 {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(key[idx:], " (%d images, %d videos)", &images, &videos); err != nil {
+		return 0, 0, false
+	}
+	return images, videos, true
+}
@@ -0,0 +1,68 @@
+package naming
+
+import "testing"
+
+func TestEncodeKeyName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "2023 06 June 15 vacation", expected: "2023 06 June 15 vacation"},
+		{name: "2023 06 June 15 party #1", expected: "2023 06 June 15 party !231"},
+		{name: "2023 06 June 15 what?", expected: "2023 06 June 15 what!3F"},
+		{name: "2023 06 June 15 100%", expected: "2023 06 June 15 100!25"},
+		{name: "2023 06 June 15 wow!", expected: "2023 06 June 15 wow!21"},
+		{name: "2023 06 June 15 trip (Spain)", expected: "2023 06 June 15 trip !28Spain!29"},
+		{name: "2023 06 June 15 Cádiz", expected: "2023 06 June 15 C!C3!A1diz"},
+		{name: "2023 06 June 15 🎉", expected: "2023 06 June 15 !F0!9F!8E!89"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := EncodeKeyName(tt.name)
+			if encoded != tt.expected {
+				t.Errorf("EncodeKeyName() = %q, expected %q", encoded, tt.expected)
+			}
+			if decoded := DecodeKeyName(encoded); decoded != tt.name {
+				t.Errorf("DecodeKeyName() = %q, expected %q", decoded, tt.name)
+			}
+		})
+	}
+}
+
+func TestDecodeKeyName_LegacyKeys(t *testing.T) {
+	// Keys written before names were encoded may contain a bare escape character
+	tests := []string{"wow!", "wow! party", "a!zz"}
+
+	for _, key := range tests {
+		t.Run(key, func(t *testing.T) {
+			if decoded := DecodeKeyName(key); decoded != key {
+				t.Errorf("DecodeKeyName() = %q, expected %q", decoded, key)
+			}
+		})
+	}
+}
+
+func TestArchiveKey(t *testing.T) {
+	key := ArchiveKey("2023 06 June 15 trip (Spain)", 3, 1)
+	if expected := "2023 06 June 15 trip !28Spain!29 (3 images, 1 videos)"; key != expected {
+		t.Errorf("ArchiveKey() = %q, expected %q", key, expected)
+	}
+	for _, suffix := range []string{"", ".tar.gz", ".part-0001.tar.gz", ".manifest.json"} {
+		if dirName := KeyDirName(key + suffix); dirName != "2023 06 June 15 trip (Spain)" {
+			t.Errorf("KeyDirName(%q) = %q", key+suffix, dirName)
+		}
+	}
+}
+
+func TestKeyCounts(t *testing.T) {
+	key := ArchiveKey("2023 06 June 15 trip (Spain)", 3, 1)
+	for _, suffix := range []string{"", ".tar.gz", " sync-0123456789ab.part-0001.tar.gz", ".manifest.json"} {
+		if images, videos, ok := KeyCounts(key + suffix); !ok || images != 3 || videos != 1 {
+			t.Errorf("KeyCounts(%q) = %d, %d, %v", key+suffix, images, videos, ok)
+		}
+	}
+	if _, _, ok := KeyCounts("sync/2023 06 June 15.json"); ok {
+		t.Error("Expected no counts in a key without them")
+	}
+}
@@ -0,0 +1,123 @@
+// Package naming parses, formats and validates the names pics gives to library directories
+// ("YYYY MM Month DD [name]"), the files inside them and their backup keys, so the formats are
+// defined in one place.
+package naming
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+// DateLayout is the layout of the date a directory name starts with, e.g. "2023 06 June 15".
+// Directories are always named with English month names, whatever the language of messages.
+const DateLayout = "2006 01 January 02"
+
+// yearMonthLayout is the layout of the start of DateLayout, which is enough to place a
+// directory in a year and month
+const yearMonthLayout = "2006 01"
+
+// monthNames are the month names recognised in directory names for each language, so
+// directories renamed by hand in the user's language are still read
+var monthNames = map[i18n.Language][12]string{
+	i18n.English: {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	i18n.Spanish: {"Enero", "Febrero", "Marzo", "Abril", "Mayo", "Junio", "Julio", "Agosto", "Septiembre", "Octubre", "Noviembre", "Diciembre"},
+}
+
+// DirName is the name of a date directory of the library
+type DirName struct {
+	// Date is the day the directory holds media of
+	Date time.Time
+	// Name is the event name following the date, e.g. "Beach" ("" = none)
+	Name string
+}
+
+// Format returns the name of the directory of date, followed by name if it isn't empty
+func Format(date time.Time, name string) string {
+	return DirName{Date: date, Name: name}.String()
+}
+
+// String returns the directory name, e.g. "2023 06 June 15 Beach"
+func (d DirName) String() string {
+	if d.Name == "" {
+		return d.Date.Format(DateLayout)
+	}
+	return d.Date.Format(DateLayout) + " " + d.Name
+}
+
+// FilePrefix returns the prefix of the files in the directory, its name with underscores for
+// spaces, e.g. "2023_06_June_15_Beach"
+func (d DirName) FilePrefix() string {
+	return strings.ReplaceAll(d.String(), " ", "_")
+}
+
+// Parse parses a directory name in the format "YYYY MM Month DD [name]". The month name may be
+// in any language of the messages, but must be the name of the month given by its number.
+func Parse(dirName string) (DirName, error) {
+	parts := strings.SplitN(dirName, " ", 5)
+	if len(parts) < 4 {
+		return DirName{}, fmt.Errorf("directory name does not match expected format (YYYY MM Month DD [name]): %s", dirName)
+	}
+
+	year, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts[0]) != 4 {
+		return DirName{}, fmt.Errorf("invalid year in directory name: %s", parts[0])
+	}
+	month, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 2 || month < 1 || month > 12 {
+		return DirName{}, fmt.Errorf("invalid month in directory name: %s", parts[1])
+	}
+	if !isMonthName(parts[2], time.Month(month)) {
+		return DirName{}, fmt.Errorf("month name %s does not match month %s in directory name", parts[2], parts[1])
+	}
+	day, err := strconv.Atoi(parts[3])
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if err != nil || len(parts[3]) != 2 || day < 1 || date.Day() != day {
+		return DirName{}, fmt.Errorf("invalid day in directory name: %s", parts[3])
+	}
+
+	result := DirName{Date: date}
+	if len(parts) == 5 {
+		result.Name = strings.TrimSpace(parts[4])
+		if result.Name == "" {
+			return DirName{}, fmt.Errorf("directory name has a blank event name: %q", dirName)
+		}
+	}
+	return result, nil
+}
+
+// Validate checks that dirName is in the format "YYYY MM Month DD [name]"
+func Validate(dirName string) error {
+	_, err := Parse(dirName)
+	return err
+}
+
+// ParseYearMonth returns the year and month a name starts with ("YYYY MM ..."), which is
+// enough to place a directory, or the backup key of one, in a date range. Unlike Parse, the
+// rest of the name isn't checked.
+func ParseYearMonth(name string) (year int, month time.Month, ok bool) {
+	if len(name) < len(yearMonthLayout) {
+		return 0, 0, false
+	}
+	if len(name) > len(yearMonthLayout) && name[len(yearMonthLayout)] != ' ' {
+		return 0, 0, false
+	}
+	date, err := time.Parse(yearMonthLayout, name[:len(yearMonthLayout)])
+	if err != nil {
+		return 0, 0, false
+	}
+	return date.Year(), date.Month(), true
+}
+
+// isMonthName reports whether name is the name of month in any language, ignoring case
+func isMonthName(name string, month time.Month) bool {
+	for _, lang := range i18n.Languages() {
+		if strings.EqualFold(name, monthNames[lang][month-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package naming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2023, 6, 5, 10, 30, 0, 0, time.UTC)
+	if got := Format(date, ""); got != "2023 06 June 05" {
+		t.Errorf("Format() = %q", got)
+	}
+	if got := Format(date, "Beach Day"); got != "2023 06 June 05 Beach Day" {
+		t.Errorf("Format() = %q", got)
+	}
+}
+
+func TestDirName_FilePrefix(t *testing.T) {
+	dirName := DirName{Date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), Name: "Beach Day"}
+	if got := dirName.FilePrefix(); got != "2023_06_June_15_Beach_Day" {
+		t.Errorf("FilePrefix() = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		dirName string
+		date    time.Time
+		name    string
+	}{
+		{dirName: "2023 06 June 15", date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{dirName: "2023 06 June 15 Beach", date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), name: "Beach"},
+		{dirName: "2023 06 June 15 Beach  Day", date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), name: "Beach  Day"},
+		{dirName: "2023 06 june 15", date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{dirName: "2023 06 Junio 15 Playa", date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), name: "Playa"},
+		{dirName: "2024 02 February 29", date: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{dirName: "1994 12 Diciembre 31", date: time.Date(1994, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dirName, func(t *testing.T) {
+			dirName, err := Parse(tt.dirName)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !dirName.Date.Equal(tt.date) || dirName.Name != tt.name {
+				t.Errorf("Expected (%v, %q), got (%v, %q)", tt.date, tt.name, dirName.Date, dirName.Name)
+			}
+			if err := Validate(tt.dirName); err != nil {
+				t.Errorf("Expected %q valid, got: %v", tt.dirName, err)
+			}
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"review",
+		"2023",
+		"2023 06",
+		"2023 06 June",
+		"23 06 June 15",
+		"abcd 06 June 15",
+		"2023 6 June 15",
+		"2023 00 January 15",
+		"2023 13 Nope 15",
+		"2023 06 July 15",
+		"2023 06 Jun 15",
+		"2023 06 June 5",
+		"2023 06 June 00",
+		"2023 06 June 31",
+		"2023 02 February 29",
+		"2023 06 June 15 ",
+		"2023  06 June 15",
+	}
+
+	for _, dirName := range tests {
+		t.Run(dirName, func(t *testing.T) {
+			if _, err := Parse(dirName); err == nil {
+				t.Errorf("Expected error for %q", dirName)
+			}
+			if err := Validate(dirName); err == nil {
+				t.Errorf("Expected %q invalid", dirName)
+			}
+		})
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	for _, dirName := range []string{"2023 06 June 15", "2023 06 June 15 Beach Day", "1999 01 January 01 Año nuevo"} {
+		parsed, err := Parse(dirName)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", dirName, err)
+		}
+		if parsed.String() != dirName {
+			t.Errorf("Expected %q, got %q", dirName, parsed.String())
+		}
+	}
+
+	// Month names in other languages are written in English again
+	parsed, err := Parse("2023 06 Junio 15 Playa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != "2023 06 June 15 Playa" {
+		t.Errorf("Expected the English month name, got %q", parsed.String())
+	}
+}
+
+func TestParseYearMonth(t *testing.T) {
+	tests := []struct {
+		name  string
+		year  int
+		month time.Month
+		ok    bool
+	}{
+		{name: "2023 06 June 15", year: 2023, month: time.June, ok: true},
+		{name: "2023 06 June 15 Beach (3 images, 1 videos).tar.gz", year: 2023, month: time.June, ok: true},
+		{name: "2023 06", year: 2023, month: time.June, ok: true},
+		{name: "2023 13 Nope 15"},
+		{name: "2023 061"},
+		{name: "2023 6 June 15"},
+		{name: "review"},
+		{name: "review (1 images, 0 videos).tar.gz"},
+		{name: "2023"},
+		{name: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			year, month, ok := ParseYearMonth(tt.name)
+			if year != tt.year || month != tt.month || ok != tt.ok {
+				t.Errorf("Expected (%d, %d, %v), got (%d, %d, %v)", tt.year, tt.month, tt.ok, year, month, ok)
+			}
+		})
+	}
+}
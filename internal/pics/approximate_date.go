@@ -0,0 +1,44 @@
+package pics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// approximateDateLayouts are the layouts an ApproximateDate is parsed with, from the most
+// precise to the least
+var approximateDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// ApproximateDate is a date known only as precisely as the user remembers it, such as the
+// month a film was developed, assigned to scans that have no capture date of their own
+type ApproximateDate struct {
+	// Date is the first day of the period
+	Date time.Time
+	// Layout is the precision of the date, one of approximateDateLayouts
+	Layout string
+}
+
+// ParseApproximateDate parses a year ("1994"), a month ("1994-07") or a day ("1994-07-15")
+func ParseApproximateDate(s string) (ApproximateDate, error) {
+	value := strings.TrimSpace(s)
+	for _, layout := range approximateDateLayouts {
+		if len(value) != len(layout) {
+			continue
+		}
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			break
+		}
+		if date.Year() < 1800 {
+			return ApproximateDate{}, fmt.Errorf("invalid date %s (expected a year after 1800)", s)
+		}
+		return ApproximateDate{Date: date, Layout: layout}, nil
+	}
+	return ApproximateDate{}, fmt.Errorf("invalid date %s (expected e.g. 1994, 1994-07 or 1994-07-15)", s)
+}
+
+// String returns the date as precisely as it was given, e.g. "1994-07"
+func (d ApproximateDate) String() string {
+	return d.Date.Format(d.Layout)
+}
@@ -0,0 +1,42 @@
+package pics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseApproximateDate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+		str      string
+	}{
+		{input: "1994", expected: time.Date(1994, 1, 1, 0, 0, 0, 0, time.UTC), str: "1994"},
+		{input: "1994-07", expected: time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC), str: "1994-07"},
+		{input: "1994-07-15", expected: time.Date(1994, 7, 15, 0, 0, 0, 0, time.UTC), str: "1994-07-15"},
+		{input: " 1994-07 ", expected: time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC), str: "1994-07"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			date, err := ParseApproximateDate(tt.input)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !date.Date.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, date.Date)
+			}
+			if date.String() != tt.str {
+				t.Errorf("Expected %q, got %q", tt.str, date.String())
+			}
+		})
+	}
+}
+
+func TestParseApproximateDate_Invalid(t *testing.T) {
+	for _, input := range []string{"", "94", "1994-7", "1994-13", "1994-02-30", "July 1994", "1700"} {
+		if _, err := ParseApproximateDate(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
@@ -6,16 +6,24 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -23,11 +31,6 @@ import (
 	"github.com/aws/smithy-go"
 )
 
-const (
-	tempDirPrefix        = "pics_tmp_*"
-	tempRestoreDirPrefix = "pics_restore_*"
-)
-
 // S3ClientInterface defines the S3 operations we use
 // The real *s3.Client naturally satisfies this interface (duck typing)
 type S3ClientInterface interface {
@@ -35,42 +38,202 @@ type S3ClientInterface interface {
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	GetObjectAttributes(ctx context.Context, params *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 }
 
 // Backup defines the interface for backing up and restoring directories
 type Backup interface {
 	// BackupDirectories backs up all subdirectories in the source directory
-	BackupDirectories(ctx context.Context, sourceDir, bucket string, maxConcurrent int, progressChan chan<- ProgressEvent) error
+	BackupDirectories(ctx context.Context, sourceDir, bucket string, opts BackupOptions) (BackupReport, error)
+	// ArchiveDirectories creates the archives of all subdirectories in the source directory and
+	// keeps them in opts.StagingDir, to be uploaded later with UploadArchives
+	ArchiveDirectories(ctx context.Context, sourceDir string, opts BackupOptions) (BackupReport, error)
+	// UploadArchives uploads the archives kept in stagingDir by ArchiveDirectories
+	UploadArchives(ctx context.Context, stagingDir, bucket string, opts BackupOptions) (BackupReport, error)
 	// RestoreDirectories restores directories to target directory
-	RestoreDirectories(ctx context.Context, bucket, targetDir string, filter RestoreFilter, maxConcurrent int, progressChan chan<- ProgressEvent) error
+	RestoreDirectories(ctx context.Context, bucket, targetDir string, opts RestoreOptions) (RestoreReport, error)
+	// ListBackups lists the directories backed up in the bucket that a restore with opts downloads
+	ListBackups(ctx context.Context, bucket string, opts RestoreOptions) ([]BackupListing, error)
+	// VerifyBackups checks that the backups in the bucket match the directories of the source
+	// directory a backup with opts would upload, without uploading anything
+	VerifyBackups(ctx context.Context, sourceDir, bucket string, opts BackupOptions) (BackupVerifyReport, error)
+	// SyncDirectories uploads the directories of libraryDir that changed locally and downloads
+	// those that changed in the bucket since the last sync
+	SyncDirectories(ctx context.Context, bucket, libraryDir string, opts SyncOptions) (SyncReport, error)
+	// RecoverFiles extracts to recoverDir the backed up copies of the files of a library whose
+	// SHA-256 matches expected, keyed by path relative to the library, and returns the paths of
+	// the files recovered
+	RecoverFiles(ctx context.Context, bucket string, expected map[string]string, recoverDir string, opts RestoreOptions) ([]string, error)
+}
+
+// BackupReport describes what a backup, archive or upload run did
+type BackupReport struct {
+	// Mode is how the directories were stored. Incremental backups count files instead of archives.
+	Mode BackupMode
+	// Directories is the number of directories archived or uploaded
+	Directories int
+	// Uploaded is the number of archives uploaded
+	Uploaded int
+	// UploadedBytes is the size of the archives uploaded
+	UploadedBytes int64
+	// Existing is the number of archives not uploaded because the bucket already had them
+	Existing int
+	// ExistingBytes is the size of the archives not uploaded because the bucket already had them
+	ExistingBytes int64
+	// UploadedDirectories is the number of directories at least one archive of which was uploaded
+	UploadedDirectories int
+	// UnchangedDirectories is the number of directories whose archives the bucket already had
+	UnchangedDirectories int
+	// Staged is the number of archives waiting in StagingDir to be uploaded
+	Staged int
+	// StagingDir is the directory archives are staged in for a later upload
+	StagingDir string
+	// Failed lists the directories that failed, when others completed
+	Failed []ItemFailure
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+}
+
+// RestoreReport describes what a restore run did
+type RestoreReport struct {
+	// Restored is the number of directories restored that weren't in the target
+	Restored int
+	// Merged is the number of directories merged into existing ones of the target
+	Merged int
+	// Added is the number of files merged into existing directories
+	Added int
+	// Duplicates is the number of files not merged because the target already had them
+	Duplicates int
+	// Replaced is the number of directories of the target replaced with their backup
+	Replaced int
+	// Skipped is the number of directories not restored because the target already had them
+	Skipped int
+	// DownloadedBytes is the size of the archives downloaded
+	DownloadedBytes int64
+	// Incomplete lists archive parts skipped because their backup has no manifest
+	Incomplete []string
+	// Thawing lists directories skipped because their archives are being restored from an
+	// archived storage class
+	Thawing []string
+	// Failed lists the archives that failed to download or extract, when others completed
+	Failed []ItemFailure
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+}
+
+// SyncReport describes what a sync run did
+type SyncReport struct {
+	// Pushed is the number of directories uploaded because they changed locally
+	Pushed int
+	// Pulled is the number of directories downloaded because they changed in the bucket
+	Pulled int
+	// Merged is the number of directories changed on both sides whose files were combined
+	Merged int
+	// InSync is the number of directories that were the same on both sides
+	InSync int
+	// Conflicts lists the directories changed on both sides that were left alone
+	Conflicts []string
+	// UploadedBytes is the size of the archives uploaded
+	UploadedBytes int64
+	// DownloadedBytes is the size of the archives downloaded
+	DownloadedBytes int64
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
 }
 
 // s3Backup implements the Backup interface for AWS S3
 type s3Backup struct {
-	client     S3ClientInterface
+	client S3ClientInterface
+	// endpoint is the URL of the S3-compatible service client connects to ("" = AWS)
+	endpoint string
+	// storage stores the objects of the destination of a run, which forBucket sets
+	storage    StorageBackend
 	extensions Extensions
+	// storageClass is the class archives and incremental files are uploaded with ("" = the default of the bucket)
+	storageClass StorageClass
 }
 
-// NewS3Backup creates a new S3 Backup instance
-func NewS3Backup(ctx context.Context) (Backup, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// NewS3Backup creates a new S3 Backup instance connecting to AWS, or to the S3-compatible
+// service set in s3Config
+func NewS3Backup(ctx context.Context, s3Config S3Config) (Backup, error) {
+	if IsOffline() {
+		return nil, ErrOffline
+	}
+	var loadOpts []func(*config.LoadOptions) error
+	if s3Config.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(s3Config.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
+	if s3Config.Endpoint != "" {
+		if err := ValidateS3Endpoint(s3Config.Endpoint); err != nil {
+			return nil, err
+		}
+		// Most S3-compatible services have a single region and accept any name, so one
+		// isn't required as it is for AWS
+		if cfg.Region == "" {
+			cfg.Region = defaultEndpointRegion
+		}
+	}
 	return &s3Backup{
-		client:     s3.NewFromConfig(cfg),
+		client:     s3.NewFromConfig(cfg, s3ClientOptions(s3Config)),
+		endpoint:   s3Config.Endpoint,
 		extensions: NewExtensions(),
 	}, nil
 }
 
+// defaultEndpointRegion is the region requests to an S3-compatible service are signed for when
+// none is configured, which MinIO and most others use by default
+const defaultEndpointRegion = "us-east-1"
+
+// ValidateS3Endpoint checks that endpoint is an http or https URL, such as https://s3.wasabisys.com
+// or http://localhost:9000
+func ValidateS3Endpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid S3 endpoint %q (expected a URL such as https://s3.example.com)", endpoint)
+	}
+	return nil
+}
+
+// s3ClientOptions applies s3Config to the options of the S3 client
+func s3ClientOptions(s3Config S3Config) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.UsePathStyle = s3Config.PathStyle
+		if s3Config.Endpoint == "" {
+			return
+		}
+		o.BaseEndpoint = aws.String(s3Config.Endpoint)
+		// Many S3-compatible services reject the checksums the SDK sends by default
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}
+}
+
 // Helper functions
 
-// createTempDir creates a temporary directory with cleanup
-func createTempDir(pattern string) (string, func(), error) {
-	tmpDir, err := os.MkdirTemp("", pattern)
+// createTempDir creates a temporary directory of the current session inside parentDir with
+// cleanup. An empty parentDir uses the system temp directory.
+func createTempDir(parentDir, kind string) (string, func(), error) {
+	session, err := createSessionDir(parentDir, kind, "")
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create temp directory: %w", err)
+		return "", nil, err
 	}
+	tmpDir := session.Dir
 
 	cleanup := func() {
 		logger.Debug("Cleaning up temporary directory", "path", tmpDir)
@@ -82,8 +245,58 @@ func createTempDir(pattern string) (string, func(), error) {
 	return tmpDir, cleanup, nil
 }
 
-// runWorkerPool runs a worker pool and collects results
-func runWorkerPool[T any](jobs []T, maxConcurrent int, workerFunc func(T) error) error {
+// ItemFailure is a directory or object of a run that failed
+type ItemFailure struct {
+	// Item is the directory or object key that failed
+	Item string
+	// Err is why it failed
+	Err error
+}
+
+func (f *ItemFailure) Error() string {
+	return fmt.Sprintf("%s: %v", f.Item, f.Err)
+}
+
+func (f *ItemFailure) Unwrap() error {
+	return f.Err
+}
+
+// PartialFailureError is returned by runs that completed some of their directories or objects but
+// failed others, and lists the failures by item
+type PartialFailureError struct {
+	// Succeeded is the number of items that completed
+	Succeeded int
+	// Failures lists the items that failed, sorted by item
+	Failures []ItemFailure
+}
+
+func (e *PartialFailureError) Error() string {
+	return fmt.Sprintf("completed with %d successes and %d failures", e.Succeeded, len(e.Failures))
+}
+
+// Unwrap returns the error of each failure, so errors.Is finds any of them
+func (e *PartialFailureError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// partialFailures returns the failures of a run whose err is a *PartialFailureError, or nil
+func partialFailures(err error) []ItemFailure {
+	var partial *PartialFailureError
+	if errors.As(err, &partial) {
+		return partial.Failures
+	}
+	return nil
+}
+
+// runWorkerPool runs a worker pool and collects results. Workers that fail return an
+// *ItemFailure naming their job; other errors are attributed to the job as formatted by %v.
+// Some jobs failing is a *PartialFailureError, all of them a plain error, and any failing once
+// ctx is done the error of ctx.
+func runWorkerPool[T any](ctx context.Context, jobs []T, maxConcurrent int, workerFunc func(T) error) error {
 	if len(jobs) == 0 {
 		return nil
 	}
@@ -98,7 +311,12 @@ func runWorkerPool[T any](jobs []T, maxConcurrent int, workerFunc func(T) error)
 		go func(workerID int) {
 			defer wg.Done()
 			for job := range jobsChan {
-				results <- workerFunc(job)
+				err := workerFunc(job)
+				var failure *ItemFailure
+				if err != nil && !errors.As(err, &failure) {
+					err = &ItemFailure{Item: fmt.Sprint(job), Err: err}
+				}
+				results <- err
 			}
 		}(i)
 	}
@@ -114,36 +332,223 @@ func runWorkerPool[T any](jobs []T, maxConcurrent int, workerFunc func(T) error)
 	close(results)
 
 	// Collect errors
-	var errors []error
+	var failures []ItemFailure
 	successCount := 0
 	for err := range results {
-		if err != nil {
-			errors = append(errors, err)
+		var failure *ItemFailure
+		if errors.As(err, &failure) {
+			failures = append(failures, *failure)
 		} else {
 			successCount++
 		}
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("completed with %d successes and %d failures", successCount, len(errors))
+	if len(failures) == 0 {
+		return nil
+	}
+	// Jobs cut short by the end of the run didn't fail on their own
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Item < failures[j].Item })
+	if successCount == 0 {
+		return fmt.Errorf("all %d failed, first %w", len(failures), &failures[0])
+	}
+	return &PartialFailureError{Succeeded: successCount, Failures: failures}
+}
+
+// backupTally counts the directories and archives of a run, shared by its workers
+type backupTally struct {
+	start    time.Time
+	warnings int
+
+	directories   atomic.Int64
+	uploaded      atomic.Int64
+	uploadedBytes atomic.Int64
+	existing      atomic.Int64
+	existingBytes atomic.Int64
+
+	mu sync.Mutex
+	// uploadedDirs records each directory whose archives were stored, and whether any was uploaded
+	uploadedDirs map[string]bool
+}
+
+// newBackupTally starts counting a run
+func newBackupTally() *backupTally {
+	return &backupTally{start: time.Now(), warnings: logger.Warnings(), uploadedDirs: make(map[string]bool)}
+}
+
+// uploadedArchive counts an archive of size bytes of directory dirName, which was uploaded or
+// already in the bucket
+func (t *backupTally) uploadedArchive(dirName string, uploaded bool, size int64) {
+	t.mu.Lock()
+	t.uploadedDirs[dirName] = t.uploadedDirs[dirName] || uploaded
+	t.mu.Unlock()
+
+	if !uploaded {
+		t.existing.Add(1)
+		t.existingBytes.Add(size)
+		return
+	}
+	t.uploaded.Add(1)
+	t.uploadedBytes.Add(size)
+}
+
+// report returns the report of the run so far
+func (t *backupTally) report() BackupReport {
+	report := BackupReport{
+		Directories:   int(t.directories.Load()),
+		Uploaded:      int(t.uploaded.Load()),
+		UploadedBytes: t.uploadedBytes.Load(),
+		Existing:      int(t.existing.Load()),
+		ExistingBytes: t.existingBytes.Load(),
+		Warnings:      logger.Warnings() - t.warnings,
+		Duration:      time.Since(t.start),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, uploaded := range t.uploadedDirs {
+		if uploaded {
+			report.UploadedDirectories++
+		} else {
+			report.UnchangedDirectories++
+		}
 	}
+	return report
+}
+
+// archiveSink receives the archives a backup creates
+type archiveSink interface {
+	// stored returns the size of the archive already stored under key, if any, so it
+	// isn't created again
+	stored(key string) (int64, bool)
+	// store takes the archive at path, which belongs to directory dirName, under key
+	store(ctx context.Context, path, key, dirName string) error
+}
+
+// uploadSink uploads archives to a bucket as they are created
+type uploadSink struct {
+	backup *s3Backup
+	bucket string
+	opts   BackupOptions
+	tally  *backupTally
+}
+
+// stored always reports false, uploads are deduplicated by uploadUnlessExists instead
+func (u *uploadSink) stored(key string) (int64, bool) {
+	return 0, false
+}
 
+// store uploads the archive unless the bucket already has it
+func (u *uploadSink) store(ctx context.Context, path, key, dirName string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	uploaded, err := u.backup.uploadUnlessExists(ctx, path, u.bucket, key, dirName, u.opts)
+	if err != nil {
+		return err
+	}
+	u.tally.uploadedArchive(dirName, uploaded, info.Size())
 	return nil
 }
 
 // BackupDirectories backs up all subdirectories to S3 in parallel
-func (b *s3Backup) BackupDirectories(ctx context.Context, sourceDir, bucket string, maxConcurrent int, progressChan chan<- ProgressEvent) error {
+func (b *s3Backup) BackupDirectories(ctx context.Context, sourceDir, bucket string, opts BackupOptions) (BackupReport, error) {
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return BackupReport{}, err
+	}
+	defer b.storage.Close()
+	// Incremental backups are restored file by file, which can't wait for archived files
+	if opts.Mode == BackupModeIncremental && opts.StorageClass.Archived() {
+		return BackupReport{}, fmt.Errorf("incremental backups can't be stored in archived storage class %s", opts.StorageClass)
+	}
+	if b, err = b.withStorageClass(opts.StorageClass); err != nil {
+		return BackupReport{}, err
+	}
+	logger.Info("Starting S3 backup", "bucket", bucket)
+	b, invalidateInventory := b.withInventoryInvalidation(opts.InventoryCacheDir, bucket)
+	defer invalidateInventory()
+	b = b.withBandwidthLimit(opts.BandwidthLimit).withObjectTimeout(opts.ObjectTimeout)
+	tally := newBackupTally()
+	var sink archiveSink = &uploadSink{backup: b, bucket: bucket, opts: opts, tally: tally}
+	if opts.StreamArchives {
+		sink = &streamingUploadSink{uploadSink: sink.(*uploadSink)}
+	}
+	backupDir := b.archiveTo(sourceDir, opts, sink)
+	if opts.Mode == BackupModeIncremental {
+		backupDir = b.uploadFilesTo(sourceDir, bucket, opts, tally)
+	}
+	err = b.backupDirectories(ctx, "backing up", sourceDir, opts, backupDir, tally)
+	report := tally.report()
+	report.Mode = opts.Mode
+	if err != nil {
+		// The directories that did back up are still reported along with those that failed
+		if report.Failed = partialFailures(err); report.Failed != nil {
+			return report, err
+		}
+		return BackupReport{}, err
+	}
+	logger.Info("Backup completed successfully")
+	recordLibraryActivity(sourceDir, func(a *libraryActivity) { a.LastBackup = time.Now() })
+	return report, nil
+}
+
+// directoryBackup backs up the directory dirName of the source directory, leaving out paths
+// matched by skip
+type directoryBackup func(ctx context.Context, dirName string, space *stagingSpace, skip skipFunc) error
+
+// archiveTo returns the directoryBackup archiving the directories of sourceDir and handing the
+// archives to sink
+func (b *s3Backup) archiveTo(sourceDir string, opts BackupOptions, sink archiveSink) directoryBackup {
+	return func(ctx context.Context, dirName string, space *stagingSpace, skip skipFunc) error {
+		_, err := b.backupDirectory(ctx, sourceDir, dirName, "", opts, space, skip, sink)
+		return err
+	}
+}
+
+// backupDirectories backs up all subdirectories in parallel with backupDir, reporting their
+// progress as stage
+func (b *s3Backup) backupDirectories(ctx context.Context, stage, sourceDir string, opts BackupOptions, backupDir directoryBackup, tally *backupTally) error {
+	if err := validateStagingDir(opts.StagingDir); err != nil {
+		return err
+	}
+	if err := validateExcludePatterns(opts.ExcludeDirs); err != nil {
+		return err
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultBackupOptions().MaxConcurrent
+	}
+
 	// Find all subdirectories
 	entries, err := os.ReadDir(sourceDir)
 	if err != nil {
 		return fmt.Errorf("failed to read source directory: %w", err)
 	}
 
+	ignore, err := newIgnoreMatcher(sourceDir)
+	if err != nil {
+		return fmt.Errorf("failed to load ignore files: %w", err)
+	}
+
+	// Counting, size estimation and archiving must all leave out the same paths
+	excluded := excludeDirsSkip(sourceDir, opts.ExcludeDirs)
+	skip := skipFunc(func(path string, isDir bool) bool {
+		return excluded(path, isDir) || ignore.isIgnored(path, isDir)
+	})
+
 	var directories []string
 	for _, entry := range entries {
-		if entry.IsDir() {
-			directories = append(directories, entry.Name())
+		// Hidden directories hold pics' own files, such as the temporary files of parses
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
 		}
+		if skip.skips(filepath.Join(sourceDir, entry.Name()), true) {
+			logger.Info("Skipping excluded directory", "directory", entry.Name())
+			continue
+		}
+		directories = append(directories, entry.Name())
 	}
 
 	if len(directories) == 0 {
@@ -151,55 +556,74 @@ func (b *s3Backup) BackupDirectories(ctx context.Context, sourceDir, bucket stri
 		return nil
 	}
 
-	logger.Info("Starting S3 backup", "directories", len(directories), "bucket", bucket, "concurrency", maxConcurrent)
+	logger.Info("Archiving directories", "directories", len(directories), "concurrency", opts.MaxConcurrent, "staging_dir", opts.StagingDir)
+	tally.directories.Store(int64(len(directories)))
 
 	// Track progress
 	var processedCount atomic.Int64
 	totalDirs := len(directories)
 
+	// Archives staged concurrently share the staging volume
+	space := newStagingSpace(opts.StagingDir)
+
 	// Run worker pool
-	err = runWorkerPool(directories, maxConcurrent, func(dirName string) error {
+	err = runWorkerPool(ctx, directories, opts.MaxConcurrent, func(dirName string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logger.Debug("Processing directory", "directory", dirName)
 
 		// Increment processed count
 		processedCount.Add(1)
 
+		dirCtx := ctx
 		// Emit progress event
-		if progressChan != nil {
+		if opts.ProgressChan != nil {
 			current := processedCount.Load()
 
-			select {
-			case progressChan <- ProgressEvent{
-				Stage:   "backing up",
+			event := ProgressEvent{
+				Stage:   stage,
 				Current: int(current),
 				Total:   totalDirs,
-				Message: fmt.Sprintf("Backing up directory %d of %d", current, totalDirs),
+				Message: i18n.T("progress."+strings.ReplaceAll(stage, " ", "_"), current, totalDirs),
 				File:    dirName,
-			}:
+			}
+			select {
+			case opts.ProgressChan <- event:
 			default:
-				logger.Debug("Progress event dropped (channel full)", "stage", "backing up")
+				logger.Debug("Progress event dropped (channel full)", "stage", stage)
 			}
+			// Archiving and uploading the directory report their bytes as progress of this event
+			dirCtx = withByteProgress(ctx, opts.ProgressChan, event, &processedCount)
 		}
 
-		if err := b.backupDirectory(ctx, sourceDir, dirName, bucket); err != nil {
+		if opts.WriteManifests {
+			if err := writeMissingChecksumManifest(filepath.Join(sourceDir, dirName)); err != nil {
+				logger.Error("Failed to write manifest", "directory", dirName, "error", err)
+				return &ItemFailure{Item: dirName, Err: err}
+			}
+		}
+
+		if err := backupDir(dirCtx, dirName, space, skip); err != nil {
 			logger.Error("Failed to backup directory", "directory", dirName, "error", err)
-			return fmt.Errorf("directory %s: %w", dirName, err)
+			return &ItemFailure{Item: dirName, Err: err}
 		}
 
 		return nil
 	})
 
 	if err != nil {
+		err = runTimeoutError(ctx, err)
 		logger.Error("Backup completed with errors", "error", err)
 		return err
 	}
 
-	logger.Info("Backup completed successfully", "directories_backed_up", len(directories))
+	logger.Info("Archived all directories", "directories", len(directories))
 	return nil
 }
 
-// countMediaFiles counts images and videos in a directory
-func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err error) {
+// countMediaFiles counts images and videos in a directory, leaving out paths matched by skip
+func (b *s3Backup) countMediaFiles(dirPath string, skip skipFunc) (images int, videos int, err error) {
 	// Count images
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
@@ -211,6 +635,9 @@ func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err
 			continue
 		}
 		filePath := filepath.Join(dirPath, entry.Name())
+		if skip.skips(filePath, false) {
+			continue
+		}
 		if b.extensions.IsImage(filePath) {
 			images++
 		}
@@ -218,7 +645,7 @@ func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err
 
 	// Count videos in videos subdirectory
 	videosDir := filepath.Join(dirPath, "videos")
-	if info, err := os.Stat(videosDir); err == nil && info.IsDir() {
+	if info, err := os.Stat(videosDir); err == nil && info.IsDir() && !skip.skips(videosDir, true) {
 		videoEntries, err := os.ReadDir(videosDir)
 		if err != nil {
 			return 0, 0, err
@@ -229,6 +656,9 @@ func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err
 				continue
 			}
 			filePath := filepath.Join(videosDir, entry.Name())
+			if skip.skips(filePath, false) {
+				continue
+			}
 			if b.extensions.IsVideo(filePath) {
 				videos++
 			}
@@ -238,71 +668,310 @@ func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err
 	return images, videos, nil
 }
 
-// backupDirectory backs up a single directory to S3
-func (b *s3Backup) backupDirectory(ctx context.Context, sourceDir, dirName, bucket string) error {
+// backupDirectory archives a single directory and hands the archive to sink, leaving out paths
+// matched by skip. keySuffix is appended to the key after the media counts. It returns the key
+// of the archive, or of the manifest of a split directory.
+func (b *s3Backup) backupDirectory(ctx context.Context, sourceDir, dirName, keySuffix string, opts BackupOptions, space *stagingSpace, skip skipFunc, sink archiveSink) (string, error) {
 	dirPath := filepath.Join(sourceDir, dirName)
 
 	// Count media files
-	imageCount, videoCount, err := b.countMediaFiles(dirPath)
+	imageCount, videoCount, err := b.countMediaFiles(dirPath, skip)
 	if err != nil {
-		return fmt.Errorf("failed to count media files: %w", err)
+		return "", fmt.Errorf("failed to count media files: %w", err)
 	}
 
 	// Build S3 key with counts
-	s3Key := fmt.Sprintf("%s (%d images, %d videos).tar.gz", dirName, imageCount, videoCount)
+	baseKey := naming.ArchiveKey(dirName, imageCount, videoCount) + keySuffix
+	s3Key := baseKey + archiveExtension
+
+	// Media barely compresses, so the archive is roughly as large as the directory
+	dirSize, err := directorySize(dirPath, skip)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate directory size: %w", err)
+	}
+
+	if opts.MaxArchiveSize > 0 && dirSize > opts.MaxArchiveSize {
+		return baseKey + manifestExtension, b.backupDirectoryParts(ctx, dirPath, dirName, baseKey, opts, space, skip, sink)
+	}
+
+	if _, ok := sink.stored(s3Key); ok {
+		logger.Info("Archive already staged, skipping", "directory", dirName, "key", s3Key)
+		return s3Key, nil
+	}
+
+	if stream, ok := sink.(streamingSink); ok {
+		logger.Info("Streaming archive", "directory", dirName, "images", imageCount, "videos", videoCount)
+		if _, err := stream.storeStream(ctx, s3Key, dirName, func(w io.Writer) error {
+			return b.writeTarGz(ctx, dirPath, w, skip)
+		}); err != nil {
+			return "", err
+		}
+		logger.Info("Successfully backed up directory", "directory", dirName, "key", s3Key)
+		return s3Key, nil
+	}
+
+	release, err := space.reserve(dirSize)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
 	// Create temporary directory
-	tmpDir, cleanup, err := createTempDir(tempDirPrefix)
+	tmpDir, cleanup, err := createTempDir(opts.StagingDir, SessionKindBackup)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer cleanup()
 
 	archivePath := filepath.Join(tmpDir, filepath.Base(s3Key))
 	logger.Info("Creating archive", "directory", dirName, "images", imageCount, "videos", videoCount)
 
-	if err := b.createTarGz(dirPath, archivePath); err != nil {
-		return fmt.Errorf("failed to create tar.gz: %w", err)
+	if err := b.createTarGz(ctx, dirPath, archivePath, skip); err != nil {
+		return "", fmt.Errorf("failed to create tar.gz: %w", err)
+	}
+
+	if err := sink.store(ctx, archivePath, s3Key, dirName); err != nil {
+		return "", err
+	}
+
+	logger.Info("Successfully backed up directory", "directory", dirName, "key", s3Key)
+	return s3Key, nil
+}
+
+// backupDirectoryParts backs up a directory larger than opts.MaxArchiveSize as several archives,
+// one at a time, followed by a manifest listing them
+func (b *s3Backup) backupDirectoryParts(ctx context.Context, dirPath, dirName, baseKey string, opts BackupOptions, space *stagingSpace, skip skipFunc, sink archiveSink) error {
+	manifestKey := baseKey + manifestExtension
+	if _, ok := sink.stored(manifestKey); ok {
+		logger.Info("Archives already staged, skipping", "directory", dirName, "key", manifestKey)
+		return nil
 	}
 
-	// Calculate MD5 hash of the archive
-	localHash, err := b.calculateMD5(archivePath)
+	parts, err := planArchiveParts(dirPath, opts.MaxArchiveSize, skip)
 	if err != nil {
-		return fmt.Errorf("failed to calculate MD5: %w", err)
+		return fmt.Errorf("failed to split directory: %w", err)
 	}
+	logger.Info("Splitting directory into archives", "directory", dirName, "parts", len(parts), "max_archive_size", opts.MaxArchiveSize)
 
-	// Check if object already exists in S3 with same hash
-	headOutput, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(s3Key),
-	})
+	// Create temporary directory
+	tmpDir, cleanup, err := createTempDir(opts.StagingDir, SessionKindBackup)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-	if err == nil {
-		// Object exists, check if hash matches
-		remoteETag := b.extractETag(headOutput.ETag)
-		if remoteETag == "" {
-			return fmt.Errorf("S3 object exists but ETag is missing")
+	manifest := archiveManifest{Directory: dirName}
+	for i, part := range parts {
+		key := archivePartKey(baseKey, i+1)
+		size, err := b.backupArchivePart(ctx, dirPath, tmpDir, key, part, space, skip, sink)
+		if err != nil {
+			return fmt.Errorf("part %d of %d: %w", i+1, len(parts), err)
 		}
+		manifest.Parts = append(manifest.Parts, archivePart{Key: key, Size: size})
+	}
 
-		if remoteETag == localHash {
-			logger.Info("Object already exists in S3 with matching hash, skipping", "directory", dirName, "key", s3Key, "hash", localHash)
-			return nil
-		}
+	// The manifest goes last so an interrupted backup never looks complete
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	manifestPath := filepath.Join(tmpDir, filepath.Base(manifestKey))
+	if err := os.WriteFile(manifestPath, manifestData, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := sink.store(ctx, manifestPath, manifestKey, dirName); err != nil {
+		return err
+	}
+
+	logger.Info("Successfully backed up directory", "directory", dirName, "key", manifestKey, "parts", len(parts))
+	return nil
+}
+
+// backupArchivePart archives the files of a single part and hands the archive to sink, returning
+// the archive size. The archive is removed once stored so only one part at a time takes up
+// staging space.
+func (b *s3Backup) backupArchivePart(ctx context.Context, dirPath, tmpDir, key string, part filePart, space *stagingSpace, skip skipFunc, sink archiveSink) (int64, error) {
+	if size, ok := sink.stored(key); ok {
+		logger.Info("Archive part already staged, skipping", "directory", filepath.Base(dirPath), "key", key)
+		return size, nil
+	}
+
+	if stream, ok := sink.(streamingSink); ok {
+		logger.Info("Streaming archive part", "directory", filepath.Base(dirPath), "key", key, "files", len(part.paths))
+		return stream.storeStream(ctx, key, filepath.Base(dirPath), func(w io.Writer) error {
+			return b.writeTarGz(ctx, dirPath, w, part.skipOutside(skip))
+		})
+	}
+
+	release, err := space.reserve(part.size)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	archivePath := filepath.Join(tmpDir, filepath.Base(key))
+	defer os.Remove(archivePath)
+
+	logger.Info("Creating archive part", "directory", filepath.Base(dirPath), "key", key, "files", len(part.paths))
+	if err := b.createTarGz(ctx, dirPath, archivePath, part.skipOutside(skip)); err != nil {
+		return 0, fmt.Errorf("failed to create tar.gz: %w", err)
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := sink.store(ctx, archivePath, key, filepath.Base(dirPath)); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
 
-		// Hash mismatch - fail with clear error
-		return fmt.Errorf("hash mismatch for '%s': S3 object exists with different content (local: %s, remote: %s). Manual intervention required", s3Key, localHash, remoteETag)
-	} else if !isNotFoundError(err) {
-		return fmt.Errorf("failed to check S3 object existence: %w", err)
+// uploadUnlessExists uploads a file to S3 unless an object with the same contents is already
+// stored under key, and returns whether it was uploaded. An existing object with different
+// contents is an error.
+func (b *s3Backup) uploadUnlessExists(ctx context.Context, filePath, bucket, key, dirName string, opts BackupOptions) (bool, error) {
+	hashes, err := b.archiveHashes(filePath, opts.SHA256Checksums)
+	if err != nil {
+		return false, err
+	}
+	return b.uploadHashedUnlessExists(ctx, filePath, bucket, key, dirName, hashes, opts.ObjectTags)
+}
+
+// archiveHashes calculates the MD5 hash of an archive, the ETag of the archive streamed, and the
+// SHA-256 checksum S3 verifies on upload when withSHA256 is set
+func (b *s3Backup) archiveHashes(filePath string, withSHA256 bool) (archiveHashes, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return archiveHashes{}, fmt.Errorf("failed to calculate hashes: %w", err)
+	}
+	defer file.Close()
+
+	hasher := newArchiveHasher(withSHA256)
+	if _, err := io.Copy(hasher, file); err != nil {
+		return archiveHashes{}, fmt.Errorf("failed to calculate hashes: %w", err)
+	}
+	return hasher.hashes(), nil
+}
+
+// uploadHashedUnlessExists is uploadUnlessExists for an archive whose hashes are known, tagged
+// with archiveTags if tagged is set
+func (b *s3Backup) uploadHashedUnlessExists(ctx context.Context, filePath, bucket, key, dirName string, hashes archiveHashes, tagged bool) (bool, error) {
+	// Check if object already exists in S3 with same hash
+	existingKey, remoteETag, exists, err := b.existingArchive(ctx, bucket, key, dirName)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, b.matchExisting(ctx, bucket, existingKey, dirName, remoteETag, hashes)
 	}
 
 	// Upload to S3
-	logger.Info("Uploading to S3", "directory", dirName, "bucket", bucket, "key", s3Key, "hash", localHash)
-	if err := b.uploadToS3(ctx, archivePath, bucket, s3Key); err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+	logger.Info("Uploading to S3", "directory", dirName, "bucket", bucket, "key", key, "hash", hashes.MD5)
+	var tagging string
+	if tagged {
+		tagging = archiveTags(key)
 	}
+	if err := b.uploadToS3(ctx, filePath, bucket, key, hashes.SHA256, tagging); err != nil {
+		return false, fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return true, nil
+}
 
-	logger.Info("Successfully backed up directory", "directory", dirName, "key", s3Key)
-	return nil
+// existingETag returns the ETag, without its quotes, of the object stored under key, and
+// whether there is one
+func (b *s3Backup) existingETag(ctx context.Context, bucket, key string) (string, bool, error) {
+	obj, err := b.storage.Head(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check S3 object existence: %w", err)
+	}
+	return obj.ETag, true, nil
+}
+
+// existingArchive returns the key and ETag of the object already holding the archive of dirName
+// stored under key, and whether there is one. Archives uploaded before directory names were
+// encoded in keys are found under their legacy key.
+func (b *s3Backup) existingArchive(ctx context.Context, bucket, key, dirName string) (string, string, bool, error) {
+	etag, exists, err := b.existingETag(ctx, bucket, key)
+	if exists || err != nil {
+		return key, etag, exists, err
+	}
+	legacy := legacyArchiveKey(key, dirName)
+	if legacy == key {
+		return key, "", false, nil
+	}
+	etag, exists, err = b.existingETag(ctx, bucket, legacy)
+	if !exists || err != nil {
+		return key, "", false, err
+	}
+	return legacy, etag, true, nil
+}
+
+// legacyArchiveKey returns key with the name of dirName written as it was before names were
+// encoded, or key itself if the name needs no encoding
+func legacyArchiveKey(key, dirName string) string {
+	rest, ok := strings.CutPrefix(key, naming.EncodeKeyName(dirName))
+	if !ok {
+		return key
+	}
+	return dirName + rest
+}
+
+// matchExisting checks that the object stored under key, whose ETag is remoteETag, holds the
+// archive with the given hashes. An object with different contents is an error.
+func (b *s3Backup) matchExisting(ctx context.Context, bucket, key, dirName, remoteETag string, hashes archiveHashes) error {
+	local, remote, err := b.hashesToCompare(ctx, bucket, key, remoteETag, hashes)
+	if err != nil {
+		return err
+	}
+
+	if remote == local {
+		logger.Info("Object already exists in S3 with matching hash, skipping", "directory", dirName, "key", key, "hash", local)
+		return nil
+	}
+
+	// Hash mismatch - fail with clear error
+	return fmt.Errorf("hash mismatch for '%s': S3 object exists with different content (local: %s, remote: %s). Manual intervention required", key, local, remote)
+}
+
+// hashesToCompare returns the local and remote hashes that tell whether an existing S3 object
+// holds the same archive. The SHA-256 checksum stored by S3 is preferred when both sides have
+// one, because ETags are not MD5 hashes for SSE-KMS encrypted or multipart objects. remoteETag
+// is the ETag of the object without its quotes. The ETag of a multipart object, which ends with
+// its number of parts, is compared with the one the archive gets when streamed.
+func (b *s3Backup) hashesToCompare(ctx context.Context, bucket, key, remoteETag string, hashes archiveHashes) (string, string, error) {
+	if localSHA256 := hashes.SHA256; localSHA256 != "" {
+		remoteSHA256, err := b.remoteSHA256(ctx, bucket, key)
+		if err != nil {
+			return "", "", err
+		}
+		if remoteSHA256 != "" {
+			return localSHA256, remoteSHA256, nil
+		}
+		logger.Debug("S3 object has no SHA-256 checksum, comparing ETag", "key", key)
+	}
+
+	if remoteETag == "" {
+		return "", "", fmt.Errorf("S3 object exists but ETag is missing")
+	}
+	if strings.Contains(remoteETag, "-") {
+		return hashes.MultipartETag, remoteETag, nil
+	}
+	return hashes.MD5, remoteETag, nil
+}
+
+// remoteSHA256 returns the full-object SHA-256 checksum stored for an object,
+// or "" if the object was uploaded without one
+func (b *s3Backup) remoteSHA256(ctx context.Context, bucket, key string) (string, error) {
+	checksum, err := b.storage.Checksum(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to get S3 object attributes: %w", err)
+	}
+	return checksum, nil
 }
 
 // extractETag safely extracts ETag value, removing quotes
@@ -363,36 +1032,79 @@ func isNotFoundError(err error) bool {
 	return false
 }
 
-// createTarGz creates a tar.gz archive of a directory
-func (b *s3Backup) createTarGz(sourceDir, targetFile string) error {
+// isPreconditionFailedError checks if the error is S3 refusing a request whose conditions don't hold
+func isPreconditionFailedError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "PreconditionFailed"
+	}
+	return false
+}
+
+// describeGetError explains download failures caused by a bucket listing that is out of date
+func describeGetError(key string, err error) error {
+	switch {
+	case errors.Is(err, ErrObjectChanged):
+		return fmt.Errorf("%s changed since the bucket was listed, refresh the listing: %w", key, err)
+	case errors.Is(err, ErrObjectNotFound):
+		return fmt.Errorf("%s no longer exists, the bucket listing may be out of date: %w", key, err)
+	case errors.Is(err, ErrObjectArchived):
+		return fmt.Errorf("%s is in an archived storage class, restore it from the bucket first: %w", key, err)
+	}
+	return err
+}
+
+// createTarGz creates a tar.gz archive of a directory, leaving out paths matched by skip
+func (b *s3Backup) createTarGz(ctx context.Context, sourceDir, targetFile string, skip skipFunc) error {
 	file, err := os.Create(targetFile)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if err := b.writeTarGz(ctx, sourceDir, file, skip); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	gzWriter := gzip.NewWriter(file)
-	defer gzWriter.Close()
+// writeTarGz writes a tar.gz archive of a directory to w, leaving out paths matched by skip
+func (b *s3Backup) writeTarGz(ctx context.Context, sourceDir string, w io.Writer, skip skipFunc) error {
+	meter := newByteMeter(ctx, PhaseArchiving, 0)
+	if meter != nil {
+		if state, err := readDirectoryState(sourceDir, skip); err == nil {
+			for _, f := range state.Files {
+				meter.total += f.Size
+			}
+		}
+	}
 
+	gzWriter := gzip.NewWriter(w)
 	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
 
 	// Get the base directory name to include in archive paths
 	baseName := filepath.Base(sourceDir)
 
-	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+		// Update header name to include base directory name
+		relPath, err := filepath.Rel(sourceDir, path)
 		if err != nil {
 			return err
 		}
 
-		// Update header name to include base directory name
-		relPath, err := filepath.Rel(sourceDir, path)
+		if relPath != "." && skip.skips(path, info.IsDir()) {
+			if info.IsDir() {
+				logger.Info("Skipping excluded directory", "directory", filepath.Join(baseName, relPath))
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Create tar header
+		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
 			return err
 		}
@@ -421,7 +1133,7 @@ func (b *s3Backup) createTarGz(sourceDir, targetFile string) error {
 		}
 
 		// Copy file content and close immediately (not defer in loop)
-		_, copyErr := io.Copy(tarWriter, f)
+		_, copyErr := io.Copy(tarWriter, meter.reader(f))
 		f.Close()
 
 		if copyErr != nil {
@@ -430,107 +1142,314 @@ func (b *s3Backup) createTarGz(sourceDir, targetFile string) error {
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return err
+	}
+	meter.done()
+	return nil
 }
 
-// uploadToS3 uploads a file to S3
-func (b *s3Backup) uploadToS3(ctx context.Context, filePath, bucket, key string) error {
+// uploadToS3 uploads a file to S3. A non-empty checksumSHA256 (base64) is verified by S3
+// on upload and stored with the object, as are non-empty tags (URL query encoded).
+func (b *s3Backup) uploadToS3(ctx context.Context, filePath, bucket, key, checksumSHA256, tags string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	meter := newByteMeter(ctx, PhaseUploading, info.Size())
 
-	_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   file,
-	})
-
-	return err
+	if _, err := b.storage.Put(ctx, key, meter.readSeeker(file), PutOptions{
+		Tags:         tags,
+		StorageClass: b.objectStorageClass(key),
+		SHA256:       checksumSHA256,
+	}); err != nil {
+		return err
+	}
+	meter.done()
+	return nil
 }
 
 // RestoreDirectories restores directories from S3 to target directory
-func (b *s3Backup) RestoreDirectories(ctx context.Context, bucket, targetDir string, filter RestoreFilter, maxConcurrent int, progressChan chan<- ProgressEvent) error {
-	// List all objects in bucket
-	logger.Info("Listing objects in S3 bucket", "bucket", bucket)
-	var allObjects []types.Object
-	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-	})
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list objects: %w", err)
-		}
-		allObjects = append(allObjects, page.Contents...)
+func (b *s3Backup) RestoreDirectories(ctx context.Context, bucket, targetDir string, opts RestoreOptions) (RestoreReport, error) {
+	start, warnings := time.Now(), logger.Warnings()
+	if err := validateStagingDir(opts.StagingDir); err != nil {
+		return RestoreReport{}, err
+	}
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return RestoreReport{}, err
 	}
+	defer b.storage.Close()
+	b = b.withBandwidthLimit(opts.BandwidthLimit).withObjectTimeout(opts.ObjectTimeout)
 
-	// Filter objects based on date range
-	var objectsToRestore []types.Object
-	for _, obj := range allObjects {
-		if obj.Key == nil {
-			continue
-		}
-		if b.matchesFilter(*obj.Key, filter) {
-			objectsToRestore = append(objectsToRestore, obj)
-		}
+	inv, err := b.listBucket(ctx, bucket, opts)
+	if err != nil {
+		return RestoreReport{}, err
+	}
+	objectsToRestore, incomplete := b.selectRestoreObjects(inv.objects(), opts.Filter)
+	tally := &restoreTally{incomplete: incomplete}
+	report := func() RestoreReport {
+		return tally.report(logger.Warnings()-warnings, time.Since(start))
 	}
 
 	if len(objectsToRestore) == 0 {
 		logger.Info("No objects found matching filter")
-		return nil
+		return report(), nil
 	}
 
-	logger.Info("Starting restore", "objects", len(objectsToRestore), "target", targetDir, "concurrency", maxConcurrent)
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultRestoreOptions().MaxConcurrent
+	}
+
+	// Archives in cold storage are copied back into the bucket before anything is downloaded
+	objectsToRestore, err = b.thawArchives(ctx, bucket, inv, objectsToRestore, opts, tally)
+	if err != nil {
+		err = runTimeoutError(ctx, err)
+		logger.Error("Restore completed with errors", "error", err)
+		return RestoreReport{}, err
+	}
+	if len(objectsToRestore) == 0 {
+		logger.Info("No objects can be downloaded until their restore completes")
+		return report(), nil
+	}
+
+	logger.Info("Starting restore", "objects", len(objectsToRestore), "target", targetDir, "concurrency", opts.MaxConcurrent, "staging_dir", opts.StagingDir)
 
 	// Track progress
 	var processedCount atomic.Int64
 	totalObjects := len(objectsToRestore)
 
+	// Downloads staged concurrently share the staging volume
+	space := newStagingSpace(opts.StagingDir)
+
+	// Keep the manifests read during the restore for the next run
+	if opts.InventoryCacheDir != "" {
+		defer b.saveInventory(inv, opts.InventoryCacheDir)
+	}
+
 	// Run worker pool
-	err := runWorkerPool(objectsToRestore, maxConcurrent, func(obj types.Object) error {
-		logger.Debug("Processing object", "key", *obj.Key)
+	err = runWorkerPool(ctx, objectsToRestore, opts.MaxConcurrent, func(obj StoredObject) error {
+		if err := ctx.Err(); err != nil {
+			return &ItemFailure{Item: obj.Key, Err: err}
+		}
+		logger.Debug("Processing object", "key", obj.Key)
 
 		// Increment processed count
 		processedCount.Add(1)
 
+		objectCtx := ctx
 		// Emit progress event
-		if progressChan != nil {
+		if opts.ProgressChan != nil {
 			current := processedCount.Load()
 
-			select {
-			case progressChan <- ProgressEvent{
+			event := ProgressEvent{
 				Stage:   "restoring",
 				Current: int(current),
 				Total:   totalObjects,
-				Message: fmt.Sprintf("Restoring directory %d of %d", current, totalObjects),
-				File:    *obj.Key,
-			}:
+				Message: i18n.T("progress.restoring", current, totalObjects),
+				File:    obj.Key,
+			}
+			select {
+			case opts.ProgressChan <- event:
 			default:
 				logger.Debug("Progress event dropped (channel full)", "stage", "restoring")
 			}
+			// Downloading and extracting the object report their bytes as progress of this event
+			objectCtx = withByteProgress(ctx, opts.ProgressChan, event, &processedCount)
 		}
 
-		if err := b.restoreObject(ctx, bucket, targetDir, *obj.Key); err != nil {
-			logger.Error("Failed to restore object", "key", *obj.Key, "error", err)
-			return fmt.Errorf("object %s: %w", *obj.Key, err)
+		if err := b.restoreObject(objectCtx, bucket, targetDir, opts, space, inv, obj, tally); err != nil {
+			logger.Error("Failed to restore object", "key", obj.Key, "error", err)
+			return &ItemFailure{Item: obj.Key, Err: err}
 		}
 
 		return nil
 	})
 
 	if err != nil {
+		err = runTimeoutError(ctx, err)
 		logger.Error("Restore completed with errors", "error", err)
-		return err
+		if failures := partialFailures(err); failures != nil {
+			r := report()
+			r.Failed = failures
+			return r, err
+		}
+		return RestoreReport{}, err
 	}
 
 	logger.Info("Restore completed successfully", "directories_restored", len(objectsToRestore))
-	return nil
+	return report(), nil
+}
+
+// restoreTally counts the directories and files of a restore run, shared by its workers
+type restoreTally struct {
+	restored        atomic.Int64
+	merged          atomic.Int64
+	added           atomic.Int64
+	duplicates      atomic.Int64
+	replaced        atomic.Int64
+	skipped         atomic.Int64
+	downloadedBytes atomic.Int64
+	// incomplete and thawing are only written before the workers start
+	incomplete []string
+	thawing    []string
+}
+
+// report returns the report of a run that logged warnings and took duration
+func (t *restoreTally) report(warnings int, duration time.Duration) RestoreReport {
+	return RestoreReport{
+		Restored:        int(t.restored.Load()),
+		Merged:          int(t.merged.Load()),
+		Added:           int(t.added.Load()),
+		Duplicates:      int(t.duplicates.Load()),
+		Replaced:        int(t.replaced.Load()),
+		Skipped:         int(t.skipped.Load()),
+		DownloadedBytes: t.downloadedBytes.Load(),
+		Incomplete:      t.incomplete,
+		Thawing:         t.thawing,
+		Warnings:        warnings,
+		Duration:        duration,
+	}
+}
+
+// listBucket returns the inventory of bucket. A cached listing younger than opts.InventoryMaxAge
+// is used as it is; otherwise the bucket is listed and the cache updated.
+func (b *s3Backup) listBucket(ctx context.Context, bucket string, opts RestoreOptions) (*bucketInventory, error) {
+	var cached *bucketInventory
+	if opts.InventoryCacheDir != "" {
+		var err error
+		cached, err = loadInventory(opts.InventoryCacheDir, b.endpoint, bucket)
+		if err != nil {
+			logger.Warn("Ignoring unreadable bucket listing cache", "bucket", bucket, "error", err)
+			cached = nil
+		}
+		if cached != nil && !opts.RefreshInventory && time.Since(cached.ListedAt) < opts.InventoryMaxAge {
+			logger.Info("Using cached bucket listing", "bucket", bucket, "listed_at", cached.ListedAt.Format(time.RFC3339), "objects", len(cached.Objects))
+			return cached, nil
+		}
+	}
+
+	// List all objects in bucket
+	logger.Info("Listing objects in S3 bucket", "bucket", bucket)
+	// Keys of libraries under a prefix of the bucket aren't directly under it and are left out
+	allObjects, prefixes, err := b.storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	// Directories backed up file by file are listed by their manifests
+	if slices.Contains(prefixes, incrementalPrefix) {
+		manifests, err := b.listIncrementalManifests(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		allObjects = append(allObjects, manifests...)
+	}
+
+	inv := newBucketInventory(bucket, allObjects, cached)
+	inv.Endpoint = b.endpoint
+	if opts.InventoryCacheDir != "" {
+		b.saveInventory(inv, opts.InventoryCacheDir)
+	}
+	return inv, nil
+}
+
+// selectRestoreObjects returns the objects of a listing a restore with filter downloads: the
+// archives, split directory manifests and incremental manifests whose directory matches it. Parts
+// of split directories are restored through their manifest, and the keys of those without one are
+// returned as incomplete.
+func (b *s3Backup) selectRestoreObjects(objects []StoredObject, filter RestoreFilter) (selected []StoredObject, incomplete []string) {
+	manifestBases := make(map[string]bool)
+	var partKeys []string
+	for _, obj := range objects {
+		if isSyncStateKey(obj.Key) {
+			continue
+		}
+		if isArchivePartKey(obj.Key) {
+			partKeys = append(partKeys, obj.Key)
+			continue
+		}
+		if isManifestKey(obj.Key) {
+			manifestBases[strings.TrimSuffix(obj.Key, manifestExtension)] = true
+		}
+		if b.matchesFilter(obj.Key, filter) {
+			selected = append(selected, obj)
+		} else if isUndatedKey(obj.Key) {
+			logger.Info("Skipping directory without a date outside the date range, include undated directories to restore it", "key", obj.Key)
+		}
+	}
+	for _, key := range partKeys {
+		if !manifestBases[archivePartKeyPattern.ReplaceAllString(key, "")] {
+			logger.Warn("Skipping archive part without a manifest, its backup may be incomplete", "key", key)
+			incomplete = append(incomplete, key)
+		}
+	}
+	return b.latestBackupModes(selected), incomplete
+}
+
+// restoreDirName returns the name of the directory a selected object restores, or "" if its key
+// holds no valid name
+func (b *s3Backup) restoreDirName(key string) string {
+	if isIncrementalManifestKey(key) {
+		return naming.DecodeKeyName(strings.TrimSuffix(strings.TrimPrefix(key, incrementalPrefix), incrementalManifestExtension))
+	}
+	return b.extractDirNameFromKey(key)
+}
+
+// latestBackupModes keeps only the latest backup of each directory backed up both as an archive
+// and file by file, so the two aren't restored over each other. The files win a tie.
+func (b *s3Backup) latestBackupModes(objects []StoredObject) []StoredObject {
+	manifests := make(map[string]time.Time)
+	archives := make(map[string]time.Time)
+	dirNames := make([]string, len(objects))
+	for i, obj := range objects {
+		dirNames[i] = b.restoreDirName(obj.Key)
+		uploads := archives
+		if isIncrementalManifestKey(obj.Key) {
+			uploads = manifests
+		}
+		if latest, ok := uploads[dirNames[i]]; !ok || obj.LastModified.After(latest) {
+			uploads[dirNames[i]] = obj.LastModified
+		}
+	}
+
+	var kept []StoredObject
+	for i, obj := range objects {
+		manifestTime, incremental := manifests[dirNames[i]]
+		archiveTime, archived := archives[dirNames[i]]
+		if dirNames[i] != "" && incremental && archived && isIncrementalManifestKey(obj.Key) == archiveTime.After(manifestTime) {
+			logger.Warn("Skipping older backup of a directory backed up both as an archive and file by file", "directory", dirNames[i], "key", obj.Key)
+			continue
+		}
+		kept = append(kept, obj)
+	}
+	return kept
 }
 
-// restoreObject downloads and extracts a single object from S3
-func (b *s3Backup) restoreObject(ctx context.Context, bucket, targetDir, key string) error {
+// saveInventory caches the inventory of a bucket. Failing to do so only costs a listing next time.
+func (b *s3Backup) saveInventory(inv *bucketInventory, cacheDir string) {
+	if err := inv.save(cacheDir); err != nil {
+		logger.Warn("Failed to cache bucket listing", "bucket", inv.Bucket, "error", err)
+	}
+}
+
+// restoreObject downloads and extracts a single archive from S3, or every part listed in a manifest
+func (b *s3Backup) restoreObject(ctx context.Context, bucket, targetDir string, opts RestoreOptions, space *stagingSpace, inv *bucketInventory, obj StoredObject, tally *restoreTally) error {
+	key := obj.Key
+	if isIncrementalManifestKey(key) {
+		return b.restoreDirectoryFiles(ctx, bucket, targetDir, key, opts, tally)
+	}
+
 	// Extract directory name from key (remove " (X images, Y videos).tar.gz" suffix)
 	dirName := b.extractDirNameFromKey(key)
 	if dirName == "" {
@@ -538,66 +1457,179 @@ func (b *s3Backup) restoreObject(ctx context.Context, bucket, targetDir, key str
 	}
 	targetPath := filepath.Join(targetDir, dirName)
 
+	// An interrupted restore of the directory continues where it stopped
+	etag := obj.ETag
+	progress, err := loadRestoreProgress(targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to read restore progress: %w", err)
+	}
+	if progress != nil {
+		if err := progress.resumes(key, etag); err != nil {
+			return err
+		}
+		logger.Info("Resuming interrupted restore", "directory", dirName, "key", key)
+	}
+
 	// Check if directory already exists
-	if _, err := os.Stat(targetPath); err == nil {
-		return fmt.Errorf("directory already exists: %s", targetPath)
+	extractDir := targetDir
+	if _, err := os.Stat(targetPath); err == nil && progress == nil {
+		// Extract next to the library so the files are moved into place rather than copied
+		var cleanup func()
+		if extractDir, cleanup, err = existingDirectoryExtractDir(targetDir, targetPath, opts.OnConflict); err != nil {
+			return err
+		}
+		if extractDir == "" {
+			logger.Info("Skipping directory that already exists", "directory", dirName)
+			tally.skipped.Add(1)
+			return nil
+		}
+		defer cleanup()
+	} else if progress == nil {
+		if progress, err = newRestoreProgress(targetPath, key, etag); err != nil {
+			return err
+		}
 	}
 
-	// Create temporary directory for download
-	tmpDir, cleanup, err := createTempDir(tempRestoreDirPrefix)
+	var downloaded int64
+	if isManifestKey(key) {
+		downloaded, err = b.restoreParts(ctx, bucket, key, extractDir, opts, space, inv, progress)
+	} else {
+		downloaded, err = b.downloadAndExtract(ctx, bucket, key, obj.Size, etag, extractDir, opts, space, progress)
+	}
 	if err != nil {
 		return err
 	}
+	tally.downloadedBytes.Add(downloaded)
+
+	if extractDir != targetDir {
+		return settleExistingDirectory(filepath.Join(extractDir, dirName), targetPath, opts.OnConflict, tally)
+	}
+
+	if err := progress.finish(); err != nil {
+		return fmt.Errorf("failed to remove restore progress: %w", err)
+	}
+	logger.Info("Successfully restored directory", "directory", dirName)
+	tally.restored.Add(1)
+	return nil
+}
+
+// restoreParts restores every archive listed in the manifest of a split directory and returns
+// the number of bytes downloaded. Parts progress records as extracted are skipped.
+func (b *s3Backup) restoreParts(ctx context.Context, bucket, manifestKey, targetDir string, opts RestoreOptions, space *stagingSpace, inv *bucketInventory, progress *restoreProgress) (int64, error) {
+	manifest, err := b.readManifest(ctx, bucket, manifestKey, inv)
+	if err != nil {
+		return 0, err
+	}
+
+	baseKey := strings.TrimSuffix(manifestKey, manifestExtension)
+	var downloaded int64
+	for i, part := range manifest.Parts {
+		// Only restore the parts written alongside this manifest
+		if !isArchivePartKey(part.Key) || !strings.HasPrefix(part.Key, baseKey+".part-") {
+			return 0, fmt.Errorf("manifest lists unexpected archive: %s", part.Key)
+		}
+		logger.Info("Restoring archive part", "key", part.Key, "part", i+1, "parts", len(manifest.Parts))
+		n, err := b.downloadAndExtract(ctx, bucket, part.Key, part.Size, inv.etag(part.Key), targetDir, opts, space, progress)
+		if err != nil {
+			return 0, fmt.Errorf("part %d of %d: %w", i+1, len(manifest.Parts), err)
+		}
+		downloaded += n
+	}
+	return downloaded, nil
+}
+
+// readManifest returns the manifest of a split directory, downloading it unless the inventory
+// already holds it for the listed ETag
+func (b *s3Backup) readManifest(ctx context.Context, bucket, manifestKey string, inv *bucketInventory) (archiveManifest, error) {
+	if manifest, ok := inv.manifest(manifestKey); ok {
+		logger.Debug("Using cached manifest", "key", manifestKey)
+		return manifest, nil
+	}
+
+	body, _, err := b.storage.Get(ctx, manifestKey, inv.etag(manifestKey))
+	if err != nil {
+		return archiveManifest{}, fmt.Errorf("failed to download manifest: %w", describeGetError(manifestKey, err))
+	}
+	defer body.Close()
+
+	var manifest archiveManifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		return archiveManifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	inv.storeManifest(manifestKey, manifest)
+	return manifest, nil
+}
+
+// downloadAndExtract downloads a single archive of the given size to the staging directory
+// and extracts it to targetDir, returning the number of bytes downloaded. When etag is set,
+// the download fails if the object changed since the bucket was listed. Extraction is recorded
+// in progress, and skips the entries it records as extracted.
+func (b *s3Backup) downloadAndExtract(ctx context.Context, bucket, key string, size int64, etag, targetDir string, opts RestoreOptions, space *stagingSpace, progress *restoreProgress) (int64, error) {
+	if progress.completed(key) {
+		logger.Info("Archive already extracted, skipping", "key", key)
+		return 0, nil
+	}
+
+	// Make sure the downloaded archive fits in the staging directory
+	release, err := space.reserve(size)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	// Create temporary directory for download
+	tmpDir, cleanup, err := createTempDir(opts.StagingDir, SessionKindRestore)
+	if err != nil {
+		return 0, err
+	}
 	defer cleanup()
 
 	// Download from S3
 	archivePath := filepath.Join(tmpDir, filepath.Base(key))
 	logger.Info("Downloading from S3", "key", key, "target", archivePath)
 
-	result, err := b.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	body, _, err := b.storage.Get(ctx, key, etag)
 	if err != nil {
-		return fmt.Errorf("failed to download from S3: %w", err)
+		return 0, fmt.Errorf("failed to download from S3: %w", describeGetError(key, err))
 	}
-	defer result.Body.Close()
+	defer body.Close()
 
 	file, err := os.Create(archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to create archive file: %w", err)
+		return 0, fmt.Errorf("failed to create archive file: %w", err)
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(file, result.Body); err != nil {
-		return fmt.Errorf("failed to write archive: %w", err)
+	meter := newByteMeter(ctx, PhaseDownloading, size)
+	downloaded, err := io.Copy(file, meter.reader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	meter.done()
+
+	if opts.VerifySHA256 {
+		if err := b.verifySHA256(ctx, bucket, key, archivePath); err != nil {
+			return 0, err
+		}
 	}
 
 	// Extract tar.gz
 	logger.Info("Extracting archive", "archive", archivePath, "target", targetDir)
-	if err := b.extractTarGz(archivePath, targetDir); err != nil {
-		return fmt.Errorf("failed to extract archive: %w", err)
+	if err := b.extractTarGz(ctx, archivePath, targetDir, opts.Owner, opts.MaxExtractSize, progress, key); err != nil {
+		return 0, fmt.Errorf("failed to extract archive: %w", err)
 	}
-
-	logger.Info("Successfully restored directory", "directory", dirName)
-	return nil
+	if err := progress.recordComplete(key); err != nil {
+		return 0, fmt.Errorf("failed to record restore progress: %w", err)
+	}
+	return downloaded, nil
 }
 
-// matchesFilter checks if an S3 key matches the date filter
+// matchesFilter checks if an S3 key matches the date filter. Archives of directories without a
+// date only match when the filter has no date range or includes them explicitly.
 func (b *s3Backup) matchesFilter(key string, filter RestoreFilter) bool {
-	// Parse year and month from key (format: "YYYY MM Month DD ...")
-	parts := strings.Fields(key)
-	if len(parts) < 2 {
-		return false
-	}
-
-	year := 0
-	month := 0
-	fmt.Sscanf(parts[0], "%d", &year)
-	fmt.Sscanf(parts[1], "%d", &month)
-
-	if year == 0 || month == 0 {
-		return false
+	year, month, ok := keyDate(strings.TrimPrefix(key, incrementalPrefix))
+	if !ok {
+		return isUndatedKey(key) && (!filter.hasDateRange() || filter.IncludeUndated)
 	}
 
 	// Check lower bound
@@ -625,14 +1657,43 @@ func (b *s3Backup) matchesFilter(key string, filter RestoreFilter) bool {
 	return true
 }
 
+// keyDate parses the year and month of the directory of a key (format: "YYYY MM Month DD ...")
+func keyDate(key string) (year, month int, ok bool) {
+	year, m, ok := naming.ParseYearMonth(key)
+	return year, int(m), ok
+}
+
+// archiveTags returns the S3 tags of the archive, part or manifest stored under key, URL query
+// encoded: the image and video counts of its key, and the year and month of its directory if it
+// has a date, e.g. "images=42&month=12&videos=3&year=2025"
+func archiveTags(key string) string {
+	tags := url.Values{}
+	if images, videos, ok := naming.KeyCounts(key); ok {
+		tags.Set("images", strconv.Itoa(images))
+		tags.Set("videos", strconv.Itoa(videos))
+	}
+	if year, month, ok := keyDate(key); ok {
+		tags.Set("year", strconv.Itoa(year))
+		tags.Set("month", fmt.Sprintf("%02d", month))
+	}
+	return tags.Encode()
+}
+
+// isUndatedKey reports whether key is the archive, or a manifest, of a directory whose name
+// doesn't start with a date, such as review
+func isUndatedKey(key string) bool {
+	if !strings.HasSuffix(key, archiveExtension) && !isManifestKey(key) && !isIncrementalManifestKey(key) {
+		return false
+	}
+	_, _, dated := keyDate(strings.TrimPrefix(key, incrementalPrefix))
+	return !dated
+}
+
 // extractDirNameFromKey extracts directory name from S3 key
 func (b *s3Backup) extractDirNameFromKey(key string) string {
-	// Remove ".tar.gz" extension
-	name := strings.TrimSuffix(key, ".tar.gz")
-	// Remove " (X images, Y videos)" suffix
-	if idx := strings.Index(name, " ("); idx != -1 {
-		name = name[:idx]
-	}
+	// Remove ".tar.gz" or ".manifest.json" extension
+	name := strings.TrimSuffix(strings.TrimSuffix(key, archiveExtension), manifestExtension)
+	name = naming.KeyDirName(name)
 
 	// Validate to prevent path traversal attacks
 	if name == "" || strings.Contains(name, "..") || strings.Contains(name, string(filepath.Separator)) {
@@ -643,15 +1704,48 @@ func (b *s3Backup) extractDirNameFromKey(key string) string {
 	return name
 }
 
-// extractTarGz extracts a tar.gz archive to a target directory
-func (b *s3Backup) extractTarGz(archivePath, targetDir string) error {
+// verifySHA256 checks a downloaded archive against the SHA-256 checksum S3 stored for it.
+// Objects uploaded without a checksum can't be verified and are only logged.
+func (b *s3Backup) verifySHA256(ctx context.Context, bucket, key, archivePath string) error {
+	remote, err := b.remoteSHA256(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	if remote == "" {
+		logger.Warn("S3 object has no SHA-256 checksum, skipping verification", "key", key)
+		return nil
+	}
+
+	local, err := fileSHA256Base64(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate SHA-256: %w", err)
+	}
+	if local != remote {
+		return fmt.Errorf("SHA-256 mismatch for '%s': downloaded archive is corrupted (local: %s, remote: %s)", key, local, remote)
+	}
+	logger.Debug("Verified SHA-256 checksum", "key", key)
+	return nil
+}
+
+// extractTarGz extracts a tar.gz archive to a target directory.
+// Files and directories keep their modification time from the archive and get the library
+// permissions.
+// When owner is set, every extracted file and directory is assigned to it. Each extracted
+// entry is recorded in progress under archiveKey, and entries it already records are skipped.
+// Entries outside targetDir or written through a symbolic link fail the extraction, as do files
+// taking the archive over maxSize bytes (0 = no limit). Links aren't extracted.
+func (b *s3Backup) extractTarGz(ctx context.Context, archivePath, targetDir string, owner *FileOwner, maxSize int64, progress *restoreProgress, archiveKey string) error {
 	file, err := os.Open(archivePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	var meter *byteMeter
+	if info, err := file.Stat(); err == nil {
+		meter = newByteMeter(ctx, PhaseExtracting, info.Size())
+	}
+	gzReader, err := gzip.NewReader(meter.reader(file))
 	if err != nil {
 		return err
 	}
@@ -659,7 +1753,15 @@ func (b *s3Backup) extractTarGz(archivePath, targetDir string) error {
 
 	tarReader := tar.NewReader(gzReader)
 
-	for {
+	limit := &extractLimit{limit: maxSize}
+	extracted := progress.extracted(archiveKey)
+	if extracted > 0 {
+		logger.Info("Skipping entries extracted before the restore was interrupted", "archive", archiveKey, "entries", extracted)
+	}
+	// Creating the files of a directory changes its time, so directories get theirs at the end.
+	// Those extracted before an interruption are included, as their files were still to come.
+	dirTimes := make(map[string]time.Time)
+	for entry := 0; ; entry++ {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -668,20 +1770,40 @@ func (b *s3Backup) extractTarGz(archivePath, targetDir string) error {
 			return err
 		}
 
-		targetPath := filepath.Join(targetDir, header.Name)
+		targetPath, err := archiveEntryPath(targetDir, header)
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeDir && !header.ModTime.IsZero() {
+			dirTimes[targetPath] = header.ModTime
+		}
+		if entry < extracted {
+			continue
+		}
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			// A link could point outside the target for later entries to write through
+			logger.Warn("Skipping link in archive", "archive", archiveKey, "entry", header.Name, "link", header.Linkname)
+			continue
+		}
+		if err := checkNoSymlinks(targetDir, targetPath); err != nil {
+			return err
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, 0755); err != nil {
+			if err := os.MkdirAll(targetPath, libraryDirMode); err != nil {
 				return err
 			}
 		case tar.TypeReg:
+			if err := limit.reserve(header.Name, header.Size); err != nil {
+				return err
+			}
 			// Ensure parent directory exists
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			if err := os.MkdirAll(filepath.Dir(targetPath), libraryDirMode); err != nil {
 				return err
 			}
 
-			outFile, err := os.Create(targetPath)
+			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, libraryFileMode)
 			if err != nil {
 				return err
 			}
@@ -691,12 +1813,31 @@ func (b *s3Backup) extractTarGz(archivePath, targetDir string) error {
 			}
 			outFile.Close()
 
-			// Restore file permissions
-			if err := os.Chmod(targetPath, os.FileMode(header.Mode)); err != nil {
-				return err
+			if !header.ModTime.IsZero() {
+				if err := os.Chtimes(targetPath, time.Now(), header.ModTime); err != nil {
+					return fmt.Errorf("failed to restore modification time of %s: %w", targetPath, err)
+				}
+			}
+		default:
+			continue
+		}
+
+		if owner != nil {
+			if err := chownPath(targetPath, *owner); err != nil {
+				return fmt.Errorf("failed to change owner of %s: %w", targetPath, err)
 			}
 		}
+		if err := progress.recordEntries(archiveKey, entry+1); err != nil {
+			return fmt.Errorf("failed to record restore progress: %w", err)
+		}
+	}
+
+	for path, modTime := range dirTimes {
+		if err := os.Chtimes(path, time.Now(), modTime); err != nil {
+			return fmt.Errorf("failed to restore modification time of %s: %w", path, err)
+		}
 	}
 
+	meter.done()
 	return nil
 }
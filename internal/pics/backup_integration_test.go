@@ -4,16 +4,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 // testCtx is a shared context for all integration tests
@@ -23,17 +30,40 @@ var testCtx = context.Background()
 type InMemoryS3Client struct {
 	mu      sync.RWMutex
 	buckets map[string]map[string]*s3Object
+	uploads map[string]*multipartUpload
+}
+
+// multipartUpload is a multipart upload that is neither completed nor aborted
+type multipartUpload struct {
+	bucket       string
+	key          string
+	tagging      string
+	storageClass types.StorageClass
+	parts        map[int32][]byte
 }
 
 type s3Object struct {
-	data []byte
-	etag string
+	data           []byte
+	etag           string
+	checksumSHA256 string
+	tagging        string
+	lastModified   time.Time
+	storageClass   types.StorageClass
+	// restore is the Restore header of an archived object whose restore was requested
+	restore string
+}
+
+// readable reports whether the object can be downloaded, which archived objects can't until
+// they are restored
+func (o *s3Object) readable() bool {
+	return !StorageClass(o.storageClass).Archived() || restoreCompleted(aws.String(o.restore))
 }
 
 // NewInMemoryS3Client creates a new in-memory S3 client
 func NewInMemoryS3Client() *InMemoryS3Client {
 	return &InMemoryS3Client{
 		buckets: make(map[string]map[string]*s3Object),
+		uploads: make(map[string]*multipartUpload),
 	}
 }
 
@@ -56,6 +86,16 @@ func (c *InMemoryS3Client) PutObject(ctx context.Context, params *s3.PutObjectIn
 	hash := md5.Sum(data)
 	etag := hex.EncodeToString(hash[:])
 
+	// Verify the SHA-256 checksum like S3 does
+	var checksumSHA256 string
+	if params.ChecksumSHA256 != nil {
+		sum := sha256.Sum256(data)
+		checksumSHA256 = base64.StdEncoding.EncodeToString(sum[:])
+		if checksumSHA256 != *params.ChecksumSHA256 {
+			return nil, fmt.Errorf("BadDigest: SHA-256 checksum does not match uploaded data")
+		}
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -64,10 +104,24 @@ func (c *InMemoryS3Client) PutObject(ctx context.Context, params *s3.PutObjectIn
 		c.buckets[bucket] = make(map[string]*s3Object)
 	}
 
+	// Refuse conditional writes whose conditions don't hold, like S3 does
+	existing, exists := c.buckets[bucket][key]
+	if (params.IfNoneMatch != nil && exists) ||
+		(params.IfMatch != nil && (!exists || *params.IfMatch != fmt.Sprintf("\"%s\"", existing.etag))) {
+		return nil, &smithy.GenericAPIError{
+			Code:    "PreconditionFailed",
+			Message: "At least one of the pre-conditions you specified did not hold",
+		}
+	}
+
 	// Store object
 	c.buckets[bucket][key] = &s3Object{
-		data: data,
-		etag: etag,
+		data:           data,
+		etag:           etag,
+		checksumSHA256: checksumSHA256,
+		tagging:        aws.ToString(params.Tagging),
+		lastModified:   time.Now().UTC().Truncate(time.Second),
+		storageClass:   params.StorageClass,
 	}
 
 	etagWithQuotes := fmt.Sprintf("\"%s\"", etag)
@@ -102,11 +156,26 @@ func (c *InMemoryS3Client) GetObject(ctx context.Context, params *s3.GetObjectIn
 		}
 	}
 
+	if !obj.readable() {
+		return nil, &types.InvalidObjectState{
+			Message:      stringPtr("The operation is not valid for the object's storage class"),
+			StorageClass: obj.storageClass,
+		}
+	}
+
+	// Refuse the download if the object changed, like S3 does
+	etagWithQuotes := fmt.Sprintf("\"%s\"", obj.etag)
+	if params.IfMatch != nil && *params.IfMatch != etagWithQuotes {
+		return nil, &smithy.GenericAPIError{
+			Code:    "PreconditionFailed",
+			Message: "At least one of the pre-conditions you specified did not hold",
+		}
+	}
+
 	// Return a copy of the data
 	dataCopy := make([]byte, len(obj.data))
 	copy(dataCopy, obj.data)
 
-	etagWithQuotes := fmt.Sprintf("\"%s\"", obj.etag)
 	return &s3.GetObjectOutput{
 		Body: io.NopCloser(bytes.NewReader(dataCopy)),
 		ETag: &etagWithQuotes,
@@ -142,10 +211,15 @@ func (c *InMemoryS3Client) HeadObject(ctx context.Context, params *s3.HeadObject
 
 	contentLength := int64(len(obj.data))
 	etagWithQuotes := fmt.Sprintf("\"%s\"", obj.etag)
-	return &s3.HeadObjectOutput{
+	output := &s3.HeadObjectOutput{
 		ContentLength: &contentLength,
 		ETag:          &etagWithQuotes,
-	}, nil
+		StorageClass:  obj.storageClass,
+	}
+	if obj.restore != "" {
+		output.Restore = aws.String(obj.restore)
+	}
+	return output, nil
 }
 
 // ListObjectsV2 lists objects in a bucket
@@ -166,28 +240,267 @@ func (c *InMemoryS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObj
 		}
 	}
 
-	// Collect all objects
+	// Collect all objects, grouping those past the delimiter into common prefixes
 	var objects []types.Object
+	var commonPrefixes []types.CommonPrefix
+	seenPrefixes := make(map[string]bool)
 	for key, obj := range bucketData {
+		prefix := aws.ToString(params.Prefix)
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter := aws.ToString(params.Delimiter); delimiter != "" {
+			if idx := strings.Index(key[len(prefix):], delimiter); idx != -1 {
+				common := key[:len(prefix)+idx+len(delimiter)]
+				if !seenPrefixes[common] {
+					seenPrefixes[common] = true
+					commonPrefixes = append(commonPrefixes, types.CommonPrefix{Prefix: aws.String(common)})
+				}
+				continue
+			}
+		}
 		keyCopy := key
 		etagWithQuotes := fmt.Sprintf("\"%s\"", obj.etag)
 		size := int64(len(obj.data))
 		objects = append(objects, types.Object{
-			Key:  &keyCopy,
-			ETag: &etagWithQuotes,
-			Size: &size,
+			Key:          &keyCopy,
+			ETag:         &etagWithQuotes,
+			Size:         &size,
+			LastModified: aws.Time(obj.lastModified),
+			StorageClass: types.ObjectStorageClass(obj.storageClass),
 		})
 	}
 
 	keyCount := int32(len(objects))
 	return &s3.ListObjectsV2Output{
-		Contents: objects,
-		KeyCount: &keyCount,
+		Contents:       objects,
+		CommonPrefixes: commonPrefixes,
+		KeyCount:       &keyCount,
 	}, nil
 }
 
+// GetObjectAttributes retrieves the stored checksum of an object
+func (c *InMemoryS3Client) GetObjectAttributes(ctx context.Context, params *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	if params.Bucket == nil || params.Key == nil {
+		return nil, fmt.Errorf("bucket and key are required")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	obj, exists := c.buckets[*params.Bucket][*params.Key]
+	if !exists {
+		return nil, &types.NoSuchKey{
+			Message: stringPtr("key does not exist"),
+		}
+	}
+
+	size := int64(len(obj.data))
+	output := &s3.GetObjectAttributesOutput{
+		ETag:       aws.String(obj.etag),
+		ObjectSize: &size,
+	}
+	if obj.checksumSHA256 != "" {
+		output.Checksum = &types.Checksum{
+			ChecksumSHA256: aws.String(obj.checksumSHA256),
+			ChecksumType:   types.ChecksumTypeFullObject,
+		}
+	}
+	return output, nil
+}
+
+// CreateMultipartUpload starts a multipart upload
+func (c *InMemoryS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	if params.Bucket == nil || params.Key == nil {
+		return nil, fmt.Errorf("bucket and key are required")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uploadID := fmt.Sprintf("upload-%d", len(c.uploads)+1)
+	c.uploads[uploadID] = &multipartUpload{
+		bucket:       *params.Bucket,
+		key:          *params.Key,
+		tagging:      aws.ToString(params.Tagging),
+		storageClass: params.StorageClass,
+		parts:        make(map[int32][]byte),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil
+}
+
+// UploadPart stores a part of a multipart upload, verifying its SHA-256 checksum like S3 does
+func (c *InMemoryS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if params.ChecksumSHA256 != nil {
+		sum := sha256.Sum256(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != *params.ChecksumSHA256 {
+			return nil, fmt.Errorf("BadDigest: SHA-256 checksum does not match uploaded data")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	upload, exists := c.uploads[aws.ToString(params.UploadId)]
+	if !exists {
+		return nil, &types.NoSuchUpload{Message: stringPtr("upload does not exist")}
+	}
+	upload.parts[aws.ToInt32(params.PartNumber)] = data
+	hash := md5.Sum(data)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("\"%s\"", hex.EncodeToString(hash[:])))}, nil
+}
+
+// CompleteMultipartUpload joins the parts of a multipart upload into an object whose ETag is
+// the MD5 hash of the MD5 hashes of its parts followed by their count, like S3 does
+func (c *InMemoryS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uploadID := aws.ToString(params.UploadId)
+	upload, exists := c.uploads[uploadID]
+	if !exists {
+		return nil, &types.NoSuchUpload{Message: stringPtr("upload does not exist")}
+	}
+	if params.MultipartUpload == nil || len(params.MultipartUpload.Parts) == 0 {
+		return nil, fmt.Errorf("MalformedXML: no parts")
+	}
+
+	var data, partHashes []byte
+	for _, part := range params.MultipartUpload.Parts {
+		partData, ok := upload.parts[aws.ToInt32(part.PartNumber)]
+		hash := md5.Sum(partData)
+		if !ok || aws.ToString(part.ETag) != fmt.Sprintf("\"%s\"", hex.EncodeToString(hash[:])) {
+			return nil, fmt.Errorf("InvalidPart: part %d", aws.ToInt32(part.PartNumber))
+		}
+		data = append(data, partData...)
+		partHashes = append(partHashes, hash[:]...)
+	}
+	hash := md5.Sum(partHashes)
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(hash[:]), len(params.MultipartUpload.Parts))
+
+	if c.buckets[upload.bucket] == nil {
+		c.buckets[upload.bucket] = make(map[string]*s3Object)
+	}
+	c.buckets[upload.bucket][upload.key] = &s3Object{
+		data:         data,
+		etag:         etag,
+		tagging:      upload.tagging,
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		storageClass: upload.storageClass,
+	}
+	delete(c.uploads, uploadID)
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String(fmt.Sprintf("\"%s\"", etag))}, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (c *InMemoryS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uploadID := aws.ToString(params.UploadId)
+	if _, exists := c.uploads[uploadID]; !exists {
+		return nil, &types.NoSuchUpload{Message: stringPtr("upload does not exist")}
+	}
+	delete(c.uploads, uploadID)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// DeleteObject removes an object, succeeding when there is none as S3 does
+func (c *InMemoryS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.buckets[aws.ToString(params.Bucket)], aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// RestoreObject starts the restore of an archived object, which completes on CompleteRestores
+func (c *InMemoryS3Client) RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, exists := c.buckets[aws.ToString(params.Bucket)][aws.ToString(params.Key)]
+	if !exists {
+		return nil, &types.NoSuchKey{Message: stringPtr("key does not exist")}
+	}
+	if !StorageClass(obj.storageClass).Archived() {
+		return nil, &types.InvalidObjectState{Message: stringPtr("Restore is not allowed for the object's current storage class")}
+	}
+	if obj.restore == `ongoing-request="true"` {
+		return nil, &smithy.GenericAPIError{Code: "RestoreAlreadyInProgress", Message: "Object restore is already in progress"}
+	}
+	obj.restore = `ongoing-request="true"`
+	return &s3.RestoreObjectOutput{}, nil
+}
+
+// RestoresRequested returns the number of archived objects whose restore is in progress
+func (c *InMemoryS3Client) RestoresRequested() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	count := 0
+	for _, bucketData := range c.buckets {
+		for _, obj := range bucketData {
+			if obj.restore == `ongoing-request="true"` {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// CompleteRestores completes the restores in progress, so the objects can be downloaded
+func (c *InMemoryS3Client) CompleteRestores() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, bucketData := range c.buckets {
+		for _, obj := range bucketData {
+			if obj.restore == `ongoing-request="true"` {
+				obj.restore = `ongoing-request="false", expiry-date="Fri, 23 Dec 2050 00:00:00 GMT"`
+			}
+		}
+	}
+}
+
+// PendingUploads returns the number of multipart uploads neither completed nor aborted
+func (c *InMemoryS3Client) PendingUploads() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.uploads)
+}
+
+// CorruptObject replaces an object's data while keeping its stored checksum
+func (c *InMemoryS3Client) CorruptObject(bucket, key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets[bucket][key].data = data
+}
+
 // Helper methods for tests
 
+// GetObjectTagging returns the tags an object was uploaded with, URL query encoded
+func (c *InMemoryS3Client) GetObjectTagging(bucket, key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if obj, exists := c.buckets[bucket][key]; exists {
+		return obj.tagging
+	}
+	return ""
+}
+
+// CreateBucket creates an empty bucket
+func (c *InMemoryS3Client) CreateBucket(bucket string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.buckets[bucket] == nil {
+		c.buckets[bucket] = make(map[string]*s3Object)
+	}
+}
+
 // GetObjectCount returns number of objects in a bucket
 func (c *InMemoryS3Client) GetObjectCount(bucket string) int {
 	c.mu.RLock()
@@ -258,7 +571,7 @@ func TestBackup_BackupDirectories(t *testing.T) {
 
 	// Backup all directories
 	bucket := "test-bucket"
-	err := backup.BackupDirectories(testCtx, sourceDir, bucket, 2, nil)
+	_, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 2})
 
 	if err != nil {
 		t.Fatalf("BackupDirectories failed: %v", err)
@@ -310,12 +623,12 @@ func TestBackup_RestoreDirectories(t *testing.T) {
 	createTempTestFile(t, dir1, "photo2.heic")
 
 	// Backup the directory
-	if err := backup.BackupDirectories(testCtx, sourceDir, bucket, 1, nil); err != nil {
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
 		t.Fatalf("BackupDirectories failed: %v", err)
 	}
 
 	// Restore directories
-	err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreFilter{}, 1, nil)
+	_, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1})
 
 	if err != nil {
 		t.Fatalf("RestoreDirectories failed: %v", err)
@@ -337,6 +650,188 @@ func TestBackup_RestoreDirectories(t *testing.T) {
 	}
 }
 
+func TestBackup_RestoreDirectories_SpecialCharacters(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	dirName := "2023 06 June 15 party #1 (100%?) 🎉"
+	dir := filepath.Join(sourceDir, dirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	createTempTestFile(t, dir, "photo1.jpg")
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	expectedKey := "2023 06 June 15 party !231 !28100!25!3F!29 !F0!9F!8E!89 (1 images, 0 videos).tar.gz"
+	if _, err := client.GetObjectData(bucket, expectedKey); err != nil {
+		t.Fatalf("Expected to find %s in bucket", expectedKey)
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, dirName, "photo1.jpg")); err != nil {
+		t.Errorf("Expected photo1.jpg to be restored to %q: %v", dirName, err)
+	}
+
+	// An archive uploaded before names were encoded isn't uploaded again
+	legacyKey := dirName + " (1 images, 0 videos).tar.gz"
+	data, _ := client.GetObjectData(bucket, expectedKey)
+	client.PutObject(testCtx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(legacyKey), Body: bytes.NewReader(data)})
+	client.DeleteObject(testCtx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(expectedKey)})
+	report, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if report.Existing != 1 || client.GetObjectCount(bucket) != 1 {
+		t.Errorf("Expected the archive found under its legacy key, got %+v and %d objects", report, client.GetObjectCount(bucket))
+	}
+}
+
+func TestBackup_SplitArchives(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	dirName := "2023 06 June 15 vacation"
+	dir := filepath.Join(sourceDir, dirName)
+	writeSizedFile(t, dir, "photo1.jpg", 600)
+	writeSizedFile(t, dir, "photo2.jpg", 600)
+	writeSizedFile(t, filepath.Join(dir, "videos"), "video1.mov", 900)
+	// Small directories are archived whole
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01 park"), "photo.jpg", 10)
+
+	opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	baseKey := "2023 06 June 15 vacation (2 images, 1 videos)"
+	for _, key := range []string{
+		archivePartKey(baseKey, 1),
+		archivePartKey(baseKey, 2),
+		archivePartKey(baseKey, 3),
+		baseKey + manifestExtension,
+		"2023 07 July 01 park (1 images, 0 videos).tar.gz",
+	} {
+		if _, err := client.GetObjectData(bucket, key); err != nil {
+			t.Errorf("Expected to find %s in bucket", key)
+		}
+	}
+	if client.GetObjectCount(bucket) != 5 {
+		t.Errorf("Expected 5 objects in bucket, got: %d", client.GetObjectCount(bucket))
+	}
+
+	// Backing up again finds every part already uploaded
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("Second BackupDirectories failed: %v", err)
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 2}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	for _, path := range []string{
+		filepath.Join(targetDir, dirName, "photo1.jpg"),
+		filepath.Join(targetDir, dirName, "photo2.jpg"),
+		filepath.Join(targetDir, dirName, "videos", "video1.mov"),
+		filepath.Join(targetDir, "2023 07 July 01 park", "photo.jpg"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be restored: %v", path, err)
+		}
+	}
+}
+
+func TestBackup_RestoreDirectories_PartsWithoutManifest(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	dir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	writeSizedFile(t, dir, "photo1.jpg", 600)
+	writeSizedFile(t, dir, "photo2.jpg", 600)
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	// Simulate a backup interrupted before the manifest was uploaded
+	client.mu.Lock()
+	delete(client.buckets[bucket], "2023 06 June 15 vacation (2 images, 0 videos)"+manifestExtension)
+	client.mu.Unlock()
+
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15 vacation")); !os.IsNotExist(err) {
+		t.Error("Expected incomplete split backup not to be restored")
+	}
+	if len(report.Incomplete) != 2 || report.Restored != 0 {
+		t.Errorf("Expected 2 incomplete parts and nothing restored, got %+v", report)
+	}
+}
+
+func TestBackup_RestoreDirectories_ManifestWithForeignPart(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	targetDir := t.TempDir()
+	manifestKey := "2023 06 June 15 vacation (1 images, 0 videos)" + manifestExtension
+	manifest := `{"directory": "2023 06 June 15 vacation", "parts": [{"key": "2023 07 July 01 park (1 images, 0 videos).part-0001.tar.gz", "size": 10}]}`
+	if _, err := client.PutObject(testCtx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(manifestKey),
+		Body:   strings.NewReader(manifest),
+	}); err != nil {
+		t.Fatalf("Failed to put manifest: %v", err)
+	}
+
+	stored, _, _ := backup.forBucket(bucket)
+	_, err := stored.restoreParts(testCtx, bucket, manifestKey, targetDir, RestoreOptions{}, newStagingSpace(""), newBucketInventory(bucket, nil, nil), nil)
+	if err == nil || !strings.Contains(err.Error(), "unexpected archive") {
+		t.Errorf("Expected error for manifest listing another directory's part, got: %v", err)
+	}
+}
+
 func TestBackup_RestoreDirectories_WithFilter(t *testing.T) {
 	// Create backup with in-memory client
 	client := NewInMemoryS3Client()
@@ -367,7 +862,7 @@ func TestBackup_RestoreDirectories_WithFilter(t *testing.T) {
 	}
 
 	// Backup all directories
-	if err := backup.BackupDirectories(testCtx, sourceDir, bucket, 2, nil); err != nil {
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 2}); err != nil {
 		t.Fatalf("BackupDirectories failed: %v", err)
 	}
 
@@ -376,7 +871,7 @@ func TestBackup_RestoreDirectories_WithFilter(t *testing.T) {
 		FromYear: 2023,
 		ToYear:   2023,
 	}
-	err := backup.RestoreDirectories(testCtx, bucket, targetDir, filter, 1, nil)
+	_, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{Filter: filter, MaxConcurrent: 1})
 
 	if err != nil {
 		t.Fatalf("RestoreDirectories failed: %v", err)
@@ -396,6 +891,46 @@ func TestBackup_RestoreDirectories_WithFilter(t *testing.T) {
 	}
 }
 
+func TestBackup_RestoreDirectories_Undated(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, ReviewDirName), "IMG_0001.jpg", "undated")
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filter   RestoreFilter
+		expected []string
+	}{
+		{name: "no filter", filter: RestoreFilter{}, expected: []string{"2023 06 June 15", ReviewDirName}},
+		{name: "date range", filter: RestoreFilter{FromYear: 2023}, expected: []string{"2023 06 June 15"}},
+		{name: "date range with undated", filter: RestoreFilter{FromYear: 2023, IncludeUndated: true}, expected: []string{"2023 06 June 15", ReviewDirName}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetDir := t.TempDir()
+			report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{Filter: tt.filter, MaxConcurrent: 1})
+			if err != nil {
+				t.Fatalf("RestoreDirectories failed: %v", err)
+			}
+			if report.Restored != len(tt.expected) {
+				t.Errorf("Expected %d directories restored, got %+v", len(tt.expected), report)
+			}
+			if got := listDir(t, targetDir); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestBackup_RoundTrip(t *testing.T) {
 	// Full integration test: backup and restore
 	client := NewInMemoryS3Client()
@@ -429,7 +964,7 @@ func TestBackup_RoundTrip(t *testing.T) {
 	createTempTestFile(t, videosDir, "video1.mov")
 
 	// Backup
-	if err := backup.BackupDirectories(testCtx, sourceDir, bucket, 1, nil); err != nil {
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
 		t.Fatalf("BackupDirectories failed: %v", err)
 	}
 
@@ -439,7 +974,7 @@ func TestBackup_RoundTrip(t *testing.T) {
 	}
 
 	// Restore
-	if err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreFilter{}, 1, nil); err != nil {
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
 		t.Fatalf("RestoreDirectories failed: %v", err)
 	}
 
@@ -479,17 +1014,750 @@ func TestBackup_Deduplication(t *testing.T) {
 	createTempTestFile(t, testDir, "photo1.jpg")
 
 	// First backup
-	if err := backup.BackupDirectories(testCtx, sourceDir, bucket, 1, nil); err != nil {
+	report, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1})
+	if err != nil {
 		t.Fatalf("First backup failed: %v", err)
 	}
+	if report.Directories != 1 || report.Uploaded != 1 || report.Existing != 0 || report.UploadedBytes == 0 || report.UploadedDirectories != 1 {
+		t.Errorf("Expected the first backup to upload 1 archive, got %+v", report)
+	}
 
 	// Second backup (should skip due to matching hash)
-	if err := backup.BackupDirectories(testCtx, sourceDir, bucket, 1, nil); err != nil {
+	report, err = backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1})
+	if err != nil {
 		t.Fatalf("Second backup failed: %v", err)
 	}
+	if report.Uploaded != 0 || report.Existing != 1 || report.UploadedBytes != 0 || report.ExistingBytes == 0 {
+		t.Errorf("Expected the second backup to find the archive in the bucket, got %+v", report)
+	}
+	if report.UploadedDirectories != 0 || report.UnchangedDirectories != 1 {
+		t.Errorf("Expected the directory reported unchanged, got %+v", report)
+	}
 
 	// Should still have only 1 object
 	if client.GetObjectCount(bucket) != 1 {
 		t.Errorf("Expected 1 object after deduplication, got: %d", client.GetObjectCount(bucket))
 	}
 }
+
+// stagingObserverClient records where archives live while they are transferred
+type stagingObserverClient struct {
+	*InMemoryS3Client
+	stagingDir      string
+	uploadedFrom    []string
+	stagedAtGetTime []string
+}
+
+// PutObject records the path of the archive being uploaded
+func (c *stagingObserverClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if file, ok := params.Body.(*os.File); ok {
+		c.uploadedFrom = append(c.uploadedFrom, file.Name())
+	}
+	return c.InMemoryS3Client.PutObject(ctx, params, optFns...)
+}
+
+// GetObject records the contents of the staging directory when a download starts
+func (c *stagingObserverClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	entries, err := os.ReadDir(c.stagingDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		c.stagedAtGetTime = append(c.stagedAtGetTime, entry.Name())
+	}
+	return c.InMemoryS3Client.GetObject(ctx, params, optFns...)
+}
+
+func TestBackup_StagingDir(t *testing.T) {
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	stagingDir := filepath.Join(tmpDir, "staging")
+
+	for _, dir := range []string{targetDir, stagingDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	client := &stagingObserverClient{
+		InMemoryS3Client: NewInMemoryS3Client(),
+		stagingDir:       stagingDir,
+	}
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	testDir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	createTempTestFile(t, testDir, "photo1.jpg")
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, StagingDir: stagingDir}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	// The archive was uploaded from inside the staging directory
+	if len(client.uploadedFrom) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(client.uploadedFrom))
+	}
+	if !strings.HasPrefix(client.uploadedFrom[0], stagingDir+string(filepath.Separator)) {
+		t.Errorf("Expected archive under %s, got %s", stagingDir, client.uploadedFrom[0])
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1, StagingDir: stagingDir}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+
+	// The download directory was created in the staging directory
+	restorePrefix := sessionDirPrefix(SessionKindRestore)
+	if len(client.stagedAtGetTime) != 1 || !strings.HasPrefix(client.stagedAtGetTime[0], restorePrefix) {
+		t.Errorf("Expected a single %s directory in staging during download, got %v", restorePrefix, client.stagedAtGetTime)
+	}
+
+	if _, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15 vacation", "photo1.jpg")); err != nil {
+		t.Errorf("Expected photo1.jpg to be restored: %v", err)
+	}
+
+	// Staging directories are cleaned up after each archive
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatalf("Failed to read staging directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected staging directory to be empty, found %d entries", len(entries))
+	}
+}
+
+func TestBackup_StagingDir_Nonexistent(t *testing.T) {
+	backup := &s3Backup{
+		client:     NewInMemoryS3Client(),
+		extensions: NewExtensions(),
+	}
+
+	tmpDir := t.TempDir()
+	missing := filepath.Join(tmpDir, "missing")
+
+	if _, err := backup.BackupDirectories(testCtx, tmpDir, "test-bucket", BackupOptions{MaxConcurrent: 1, StagingDir: missing}); err == nil {
+		t.Error("Expected backup to fail with nonexistent staging directory")
+	}
+	if _, err := backup.RestoreDirectories(testCtx, "test-bucket", tmpDir, RestoreOptions{MaxConcurrent: 1, StagingDir: missing}); err == nil {
+		t.Error("Expected restore to fail with nonexistent staging directory")
+	}
+}
+
+func TestBackup_ZeroValueOptions(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	testDir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	createTempTestFile(t, testDir, "photo1.jpg")
+
+	// A zero MaxConcurrent falls back to the default instead of starting no workers
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if client.GetObjectCount(bucket) != 1 {
+		t.Fatalf("Expected 1 object in bucket, got: %d", client.GetObjectCount(bucket))
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15 vacation", "photo1.jpg")); err != nil {
+		t.Errorf("Expected photo1.jpg to be restored: %v", err)
+	}
+}
+
+func TestBackup_ExcludeDirs(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	publicDir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	privateDir := filepath.Join(sourceDir, "2023 07 July 01 Private")
+	nestedPrivateDir := filepath.Join(publicDir, "private")
+	for _, dir := range []string{publicDir, privateDir, nestedPrivateDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		createTempTestFile(t, dir, "photo.jpg")
+	}
+
+	opts := BackupOptions{
+		MaxConcurrent: 1,
+		ExcludeDirs:   []string{"*Private*", "*/private*"},
+	}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	if client.GetObjectCount(bucket) != 1 {
+		t.Fatalf("Expected 1 object in bucket, got: %d", client.GetObjectCount(bucket))
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+
+	restoredDir := filepath.Join(targetDir, "2023 06 June 15 vacation")
+	if _, err := os.Stat(filepath.Join(restoredDir, "photo.jpg")); err != nil {
+		t.Errorf("Expected photo.jpg to be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(restoredDir, "private")); !os.IsNotExist(err) {
+		t.Error("Expected nested private directory to be excluded from the archive")
+	}
+}
+
+func TestBackup_ExcludeDirs_KeyCounts(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	testDir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	videosDir := filepath.Join(testDir, "videos")
+	if err := os.MkdirAll(videosDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	createTempTestFile(t, testDir, "photo.jpg")
+	createTempTestFile(t, videosDir, "video.mov")
+
+	opts := BackupOptions{MaxConcurrent: 1, ExcludeDirs: []string{"*/videos"}}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	// The key only counts what the archive contains
+	expectedKey := "2023 06 June 15 vacation (1 images, 0 videos).tar.gz"
+	if _, err := client.GetObjectData(bucket, expectedKey); err != nil {
+		t.Errorf("Expected to find %s in bucket", expectedKey)
+	}
+}
+
+func TestBackup_ExcludeDirs_InvalidPattern(t *testing.T) {
+	backup := &s3Backup{
+		client:     NewInMemoryS3Client(),
+		extensions: NewExtensions(),
+	}
+
+	opts := BackupOptions{MaxConcurrent: 1, ExcludeDirs: []string{"[unclosed"}}
+	if _, err := backup.BackupDirectories(testCtx, t.TempDir(), "test-bucket", opts); err == nil {
+		t.Error("Expected error for invalid exclude pattern")
+	}
+}
+
+func TestBackup_HonoursIgnoreFile(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	keptDir := filepath.Join(sourceDir, "2023 06 June 15 vacation")
+	ignoredDir := filepath.Join(sourceDir, "2023 07 July 01 drafts")
+	for _, dir := range []string{keptDir, ignoredDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		createTempTestFile(t, dir, "photo1.jpg")
+	}
+	createTempTestFile(t, keptDir, "photo2.jpg")
+	writeIgnoreFile(t, sourceDir, "*drafts/\n")
+	writeIgnoreFile(t, keptDir, "photo2.jpg\n")
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	// The ignored file is not counted in the key either
+	expectedKey := "2023 06 June 15 vacation (1 images, 0 videos).tar.gz"
+	if client.GetObjectCount(bucket) != 1 {
+		t.Fatalf("Expected 1 object in bucket, got: %d", client.GetObjectCount(bucket))
+	}
+	if _, err := client.GetObjectData(bucket, expectedKey); err != nil {
+		t.Fatalf("Expected to find %s in bucket", expectedKey)
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+
+	restoredDir := filepath.Join(targetDir, "2023 06 June 15 vacation")
+	if _, err := os.Stat(filepath.Join(restoredDir, "photo1.jpg")); err != nil {
+		t.Errorf("Expected photo1.jpg to be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(restoredDir, "photo2.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected ignored photo2.jpg to be left out of the archive")
+	}
+	// Ignore files are kept so that a restored library keeps its rules
+	if _, err := os.Stat(filepath.Join(restoredDir, IgnoreFileName)); err != nil {
+		t.Errorf("Expected %s to be restored: %v", IgnoreFileName, err)
+	}
+}
+
+func TestBackup_InvalidIgnoreFile(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	sourceDir := t.TempDir()
+	testDir := createTestDir(t, sourceDir, "2023 06 June 15 vacation")
+	createTempTestFile(t, testDir, "photo1.jpg")
+	writeIgnoreFile(t, testDir, "IMG_[z-a].jpg\n")
+
+	// Nothing is uploaded when the rules can't be trusted
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "test-bucket", BackupOptions{MaxConcurrent: 1}); err == nil {
+		t.Error("Expected error for invalid ignore file")
+	}
+	if client.GetObjectCount("test-bucket") != 0 {
+		t.Errorf("Expected no objects in bucket, got: %d", client.GetObjectCount("test-bucket"))
+	}
+}
+
+func TestBackup_SHA256Checksums(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	sourceDir := t.TempDir()
+	testDir := createTestDir(t, sourceDir, "2023 06 June 15 vacation")
+	createTempTestFile(t, testDir, "photo1.jpg")
+
+	opts := BackupOptions{MaxConcurrent: 1, SHA256Checksums: true}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	key := "2023 06 June 15 vacation (1 images, 0 videos).tar.gz"
+	obj := client.buckets[bucket][key]
+	if obj == nil || obj.checksumSHA256 == "" {
+		t.Fatal("Expected archive to be stored with a SHA-256 checksum")
+	}
+
+	// SSE-KMS ETags are not MD5 hashes; the checksum still identifies the archive
+	obj.etag = "0123456789abcdef0123456789abcdef"
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Errorf("Expected unchanged archive to be skipped by checksum, got: %v", err)
+	}
+
+	// Without checksums the ETag is all there is to compare
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err == nil {
+		t.Error("Expected ETag mismatch without SHA-256 checksums")
+	}
+}
+
+func TestBackup_ObjectTags(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	sourceDir := t.TempDir()
+	createTempTestFile(t, createTestDir(t, sourceDir, "2023 06 June 15 vacation"), "photo1.jpg")
+	key := "2023 06 June 15 vacation (1 images, 0 videos).tar.gz"
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if tags := client.GetObjectTagging(bucket, key); tags != "" {
+		t.Errorf("Expected no tags by default, got %q", tags)
+	}
+
+	client = NewInMemoryS3Client()
+	backup.client = client
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, ObjectTags: true}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if tags := client.GetObjectTagging(bucket, key); tags != "images=1&month=06&videos=0&year=2023" {
+		t.Errorf("Expected the archive tagged with its counts and date, got %q", tags)
+	}
+}
+
+func TestBackup_SHA256Checksums_LegacyObject(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	sourceDir := t.TempDir()
+	testDir := createTestDir(t, sourceDir, "2023 06 June 15 vacation")
+	createTempTestFile(t, testDir, "photo1.jpg")
+
+	// Objects uploaded before checksums were enabled fall back to the ETag
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, SHA256Checksums: true}); err != nil {
+		t.Errorf("Expected legacy object to match by ETag, got: %v", err)
+	}
+}
+
+func TestBackup_RestoreVerifySHA256(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := createTestDir(t, tmpDir, "source")
+	testDir := createTestDir(t, sourceDir, "2023 06 June 15 vacation")
+	createTempTestFile(t, testDir, "photo1.jpg")
+
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, SHA256Checksums: true}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	restoreOpts := RestoreOptions{MaxConcurrent: 1, VerifySHA256: true}
+	if _, err := backup.RestoreDirectories(testCtx, bucket, createTestDir(t, tmpDir, "verified"), restoreOpts); err != nil {
+		t.Fatalf("Expected verified restore to succeed, got: %v", err)
+	}
+
+	// Replace the archive with other valid content, keeping the stored checksum
+	key := "2023 06 June 15 vacation (1 images, 0 videos).tar.gz"
+	otherDir := createTestDir(t, tmpDir, "other")
+	otherArchive := filepath.Join(tmpDir, "other.tar.gz")
+	createTempTestFile(t, otherDir, "photo2.jpg")
+	if err := backup.createTarGz(testCtx, otherDir, otherArchive, nil); err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	data, err := os.ReadFile(otherArchive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	client.CorruptObject(bucket, key, data)
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, createTestDir(t, tmpDir, "corrupted"), restoreOpts); err == nil {
+		t.Error("Expected restore to fail on SHA-256 mismatch")
+	}
+}
+
+func TestBackup_RestoreDirectories_Merge(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "library")
+
+	dirName := "2023 06 June 15"
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00002.jpg", "dinner")
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	// The library already has the beach photo and one of its own
+	libraryDir := filepath.Join(targetDir, dirName)
+	writeContentFile(t, libraryDir, "2023_06_June_15_00001.jpg", "sunrise")
+	writeContentFile(t, libraryDir, "2023_06_June_15_00002.jpg", "beach")
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err == nil {
+		t.Fatal("Expected restore without merge to fail on the existing directory")
+	}
+
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1, OnConflict: RestorePolicyMerge})
+	if err != nil {
+		t.Fatalf("RestoreDirectories with merge failed: %v", err)
+	}
+	if report.Merged != 1 || report.Added != 1 || report.Duplicates != 1 || report.Restored != 0 {
+		t.Errorf("Expected 1 directory merged with 1 file added and 1 duplicate, got %+v", report)
+	}
+
+	expected := []string{"2023_06_June_15_00001.jpg", "2023_06_June_15_00002.jpg", "2023_06_June_15_00003.jpg"}
+	if got := listDir(t, libraryDir); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	data, err := os.ReadFile(filepath.Join(libraryDir, "2023_06_June_15_00003.jpg"))
+	if err != nil || string(data) != "dinner" {
+		t.Errorf("Expected the new photo to be numbered after the existing ones, got %q (%v)", data, err)
+	}
+
+	// The temporary merge directory is gone
+	if got := listDir(t, targetDir); !reflect.DeepEqual(got, []string{dirName}) {
+		t.Errorf("Expected only %q in the library, got %v", dirName, got)
+	}
+}
+
+func TestBackup_RestoreDirectories_DirectoryModTimes(t *testing.T) {
+	backup := &s3Backup{
+		client:     NewInMemoryS3Client(),
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "library")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dirPath := filepath.Join(sourceDir, "2023 06 June 15")
+	writeContentFile(t, dirPath, "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(dirPath, "videos"), "2023_06_June_15_00001.mov", "waves")
+	dirTime := time.Date(2023, 6, 15, 18, 0, 0, 0, time.UTC)
+	videosTime := time.Date(2023, 6, 16, 9, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dirPath, "videos"), videosTime, videosTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dirPath, dirTime, dirTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	// The restore progress kept in the directory while it was extracted doesn't change its time
+	assertFileModTime(t, filepath.Join(targetDir, "2023 06 June 15"), dirTime)
+	assertFileModTime(t, filepath.Join(targetDir, "2023 06 June 15", "videos"), videosTime)
+}
+
+func TestBackup_RestoreDirectories_SkipAndOverwrite(t *testing.T) {
+	backup := &s3Backup{
+		client:     NewInMemoryS3Client(),
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "library")
+
+	dirName := "2023 06 June 15"
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "2023_07_July_01_00001.jpg", "party")
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	libraryDir := filepath.Join(targetDir, dirName)
+	writeContentFile(t, libraryDir, "2023_06_June_15_00001.jpg", "sunrise")
+
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1, OnConflict: RestorePolicySkip})
+	if err != nil {
+		t.Fatalf("RestoreDirectories with skip-existing failed: %v", err)
+	}
+	if report.Skipped != 1 || report.Restored != 1 {
+		t.Errorf("Expected 1 directory skipped and 1 restored, got %+v", report)
+	}
+	if got := listDir(t, libraryDir); !reflect.DeepEqual(got, []string{"2023_06_June_15_00001.jpg"}) {
+		t.Errorf("Expected the existing directory left alone, got %v", got)
+	}
+
+	// Running the restore again replaces both directories
+	writeContentFile(t, libraryDir, "2023_06_June_15_00002.jpg", "extra")
+	report, err = backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1, OnConflict: RestorePolicyOverwrite})
+	if err != nil {
+		t.Fatalf("RestoreDirectories with overwrite failed: %v", err)
+	}
+	if report.Replaced != 2 || report.Restored != 0 {
+		t.Errorf("Expected 2 directories replaced, got %+v", report)
+	}
+	if got := listDir(t, libraryDir); !reflect.DeepEqual(got, []string{"2023_06_June_15_00001.jpg"}) {
+		t.Errorf("Expected only the files of the backup, got %v", got)
+	}
+	data, err := os.ReadFile(filepath.Join(libraryDir, "2023_06_June_15_00001.jpg"))
+	if err != nil || string(data) != "beach" {
+		t.Errorf("Expected the backed up photo, got %q (%v)", data, err)
+	}
+
+	// Neither the extraction nor the replaced directories are left behind
+	if got := listDir(t, targetDir); !reflect.DeepEqual(got, []string{dirName, "2023 07 July 01"}) {
+		t.Errorf("Expected only the restored directories in the library, got %v", got)
+	}
+}
+
+// listCountingClient counts bucket listings and manifest downloads
+type listCountingClient struct {
+	*InMemoryS3Client
+	listings          int
+	manifestDownloads int
+}
+
+// ListObjectsV2 counts the listing
+func (c *listCountingClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.listings++
+	return c.InMemoryS3Client.ListObjectsV2(ctx, params, optFns...)
+}
+
+// GetObject counts manifest downloads
+func (c *listCountingClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if isManifestKey(aws.ToString(params.Key)) {
+		c.manifestDownloads++
+	}
+	return c.InMemoryS3Client.GetObject(ctx, params, optFns...)
+}
+
+func TestBackup_RestoreDirectories_InventoryCache(t *testing.T) {
+	client := &listCountingClient{InMemoryS3Client: NewInMemoryS3Client()}
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	cacheDir := filepath.Join(tmpDir, "cache")
+
+	dirName := "2023 06 June 15 vacation"
+	writeSizedFile(t, filepath.Join(sourceDir, dirName), "photo1.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, dirName), "photo2.jpg", 600)
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	restore := func(refresh bool) error {
+		opts := DefaultRestoreOptions()
+		opts.MaxConcurrent = 1
+		opts.InventoryCacheDir = cacheDir
+		opts.RefreshInventory = refresh
+		targetDir := t.TempDir()
+		_, err := backup.RestoreDirectories(testCtx, bucket, targetDir, opts)
+		return err
+	}
+
+	if err := restore(false); err != nil {
+		t.Fatalf("First restore failed: %v", err)
+	}
+	if err := restore(false); err != nil {
+		t.Fatalf("Second restore failed: %v", err)
+	}
+	if client.listings != 1 {
+		t.Errorf("Expected the bucket to be listed once, got %d listings", client.listings)
+	}
+	if client.manifestDownloads != 1 {
+		t.Errorf("Expected the manifest to be downloaded once, got %d downloads", client.manifestDownloads)
+	}
+
+	// Replacing a part behind the cache's back is caught instead of restoring the new contents
+	partKey := archivePartKey(dirName+" (2 images, 0 videos)", 1)
+	inv, err := loadInventory(cacheDir, "", bucket)
+	if err != nil || inv == nil {
+		t.Fatalf("Expected a cached inventory, got %v (%v)", inv, err)
+	}
+	staleETag := inv.etag(partKey)
+	if staleETag == "" {
+		t.Fatalf("Expected %s in the cached inventory", partKey)
+	}
+	if _, err := client.PutObject(testCtx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(partKey),
+		Body:   bytes.NewReader([]byte("replaced")),
+	}); err != nil {
+		t.Fatalf("Failed to replace part: %v", err)
+	}
+	stored, _, _ := backup.forBucket(bucket)
+	_, err = stored.downloadAndExtract(testCtx, bucket, partKey, 8, staleETag, t.TempDir(), RestoreOptions{}, newStagingSpace(""), nil)
+	if err == nil || !strings.Contains(err.Error(), "changed since the bucket was listed") {
+		t.Errorf("Expected download of a replaced object to fail, got: %v", err)
+	}
+
+	if err := restore(true); err == nil {
+		t.Error("Expected restore of the replaced part to fail")
+	}
+	if client.listings != 2 {
+		t.Errorf("Expected --refresh to list the bucket again, got %d listings", client.listings)
+	}
+}
+
+func TestBackup_BackupDirectories_InvalidatesInventoryCache(t *testing.T) {
+	client := &listCountingClient{InMemoryS3Client: NewInMemoryS3Client()}
+	backup := &s3Backup{
+		client:     client,
+		extensions: NewExtensions(),
+	}
+
+	bucket := "test-bucket"
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	cacheDir := filepath.Join(tmpDir, "cache")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15 vacation"), "photo1.jpg", 600)
+
+	backupOpts := BackupOptions{MaxConcurrent: 1, InventoryCacheDir: cacheDir}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, backupOpts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	restoreOpts := DefaultRestoreOptions()
+	restoreOpts.InventoryCacheDir = cacheDir
+	if _, err := backup.ListBackups(testCtx, bucket, restoreOpts); err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+
+	// A backup uploading nothing keeps the cached listing
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, backupOpts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if inv, err := loadInventory(cacheDir, "", bucket); err != nil || inv == nil {
+		t.Fatalf("Expected the cached listing kept, got %v (%v)", inv, err)
+	}
+
+	// A new directory is listed at once instead of when the cache expires
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "photo2.jpg", 600)
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, backupOpts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	listings, err := backup.ListBackups(testCtx, bucket, restoreOpts)
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(listings) != 2 {
+		t.Errorf("Expected both directories listed, got %v", listings)
+	}
+	if client.listings != 2 {
+		t.Errorf("Expected the bucket listed again after the upload, got %d listings", client.listings)
+	}
+}
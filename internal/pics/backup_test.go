@@ -2,6 +2,7 @@ package pics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 )
@@ -25,7 +28,7 @@ func createTempTestFile(t *testing.T, dir, filename string) {
 }
 
 func TestCreateTempDir(t *testing.T) {
-	tmpDir, cleanup, err := createTempDir(tempDirPrefix)
+	tmpDir, cleanup, err := createTempDir("", SessionKindBackup)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -49,12 +52,26 @@ func TestCreateTempDir(t *testing.T) {
 	}
 }
 
+func TestCreateTempDir_InParentDir(t *testing.T) {
+	parentDir := t.TempDir()
+
+	tmpDir, cleanup, err := createTempDir(parentDir, SessionKindBackup)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer cleanup()
+
+	if filepath.Dir(tmpDir) != parentDir {
+		t.Errorf("Expected temp directory inside %s, got %s", parentDir, tmpDir)
+	}
+}
+
 func TestRunWorkerPool(t *testing.T) {
 	jobs := []int{1, 2, 3, 4, 5}
 	results := make([]int, 0)
 	var mu sync.Mutex
 
-	err := runWorkerPool(jobs, 2, func(job int) error {
+	err := runWorkerPool(testCtx, jobs, 2, func(job int) error {
 		mu.Lock()
 		results = append(results, job*2)
 		mu.Unlock()
@@ -85,7 +102,7 @@ func TestRunWorkerPool(t *testing.T) {
 func TestRunWorkerPool_WithErrors(t *testing.T) {
 	jobs := []int{1, 2, 3, 4, 5}
 
-	err := runWorkerPool(jobs, 2, func(job int) error {
+	err := runWorkerPool(testCtx, jobs, 2, func(job int) error {
 		if job == 2 || job == 4 {
 			return fmt.Errorf("job %d failed", job)
 		}
@@ -100,12 +117,77 @@ func TestRunWorkerPool_WithErrors(t *testing.T) {
 	if !strings.Contains(err.Error(), "failures") {
 		t.Errorf("Expected error message to mention failures, got: %v", err)
 	}
+
+	// Should list which jobs failed, in order
+	var partial *PartialFailureError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Expected a *PartialFailureError, got %T", err)
+	}
+	if partial.Succeeded != 3 || len(partial.Failures) != 2 {
+		t.Fatalf("Expected 3 successes and 2 failures, got %d and %v", partial.Succeeded, partial.Failures)
+	}
+	if partial.Failures[0].Item != "2" || partial.Failures[1].Item != "4" {
+		t.Errorf("Expected jobs 2 and 4 to fail, got %v", partial.Failures)
+	}
+}
+
+func TestRunWorkerPool_ItemFailures(t *testing.T) {
+	denied := errors.New("access denied")
+	err := runWorkerPool(testCtx, []string{"b", "c", "a"}, 2, func(job string) error {
+		if job == "c" {
+			return nil
+		}
+		return &ItemFailure{Item: "dir " + job, Err: fmt.Errorf("upload: %w", denied)}
+	})
+
+	failures := partialFailures(err)
+	if len(failures) != 2 || failures[0].Item != "dir a" || failures[1].Item != "dir b" {
+		t.Fatalf("Expected the items the workers named, got %v", failures)
+	}
+	if !errors.Is(err, denied) {
+		t.Error("Expected the errors of the failures to be wrapped")
+	}
+	if partialFailures(denied) != nil {
+		t.Error("Expected no failures of other errors")
+	}
+}
+
+func TestRunWorkerPool_AllFailed(t *testing.T) {
+	denied := errors.New("access denied")
+	err := runWorkerPool(testCtx, []string{"b", "a"}, 2, func(job string) error {
+		return &ItemFailure{Item: "dir " + job, Err: denied}
+	})
+	if err == nil || partialFailures(err) != nil {
+		t.Fatalf("Expected a plain error when every job fails, got %v", err)
+	}
+	if !errors.Is(err, denied) || !strings.Contains(err.Error(), "all 2 failed") {
+		t.Errorf("Expected the error of the first failure, got %v", err)
+	}
+}
+
+func TestRunWorkerPool_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(testCtx)
+	err := runWorkerPool(ctx, []int{1, 2, 3}, 1, func(job int) error {
+		if job == 1 {
+			cancel()
+			return nil
+		}
+		return ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected the run cancelled, got %v", err)
+	}
+
+	// A run whose jobs all completed succeeds, even if it ends as they do
+	if err := runWorkerPool(ctx, []int{1}, 1, func(job int) error { return nil }); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
 }
 
 func TestRunWorkerPool_EmptyJobs(t *testing.T) {
 	jobs := []int{}
 
-	err := runWorkerPool(jobs, 2, func(job int) error {
+	err := runWorkerPool(testCtx, jobs, 2, func(job int) error {
 		return nil
 	})
 
@@ -249,7 +331,7 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 				extensions: NewExtensions(),
 			}
 
-			images, videos, err := backup.countMediaFiles(tmpDir)
+			images, videos, err := backup.countMediaFiles(tmpDir, nil)
 
 			if err != nil {
 				t.Errorf("Expected no error, got: %v", err)
@@ -329,6 +411,36 @@ func TestS3Backup_MatchesFilter(t *testing.T) {
 			filter:   RestoreFilter{},
 			expected: false,
 		},
+		{
+			name:     "undated directory without filter",
+			key:      "review (3 images, 0 videos).tar.gz",
+			filter:   RestoreFilter{},
+			expected: true,
+		},
+		{
+			name:     "undated directory with date range",
+			key:      "review (3 images, 0 videos).tar.gz",
+			filter:   RestoreFilter{FromYear: 2023},
+			expected: false,
+		},
+		{
+			name:     "undated directory included in date range",
+			key:      "review (3 images, 0 videos).tar.gz",
+			filter:   RestoreFilter{FromYear: 2023, IncludeUndated: true},
+			expected: true,
+		},
+		{
+			name:     "undated split directory included in date range",
+			key:      "review (3 images, 0 videos).manifest.json",
+			filter:   RestoreFilter{ToYear: 2023, IncludeUndated: true},
+			expected: true,
+		},
+		{
+			name:     "dated directory outside range with undated included",
+			key:      "2022 06 June 15 vacation (10 images, 5 videos).tar.gz",
+			filter:   RestoreFilter{FromYear: 2023, IncludeUndated: true},
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -364,6 +476,16 @@ func TestS3Backup_ExtractDirNameFromKey(t *testing.T) {
 			key:      "vacation.tar.gz",
 			expected: "vacation",
 		},
+		{
+			name:     "encoded name",
+			key:      "2023 06 June 15 trip !28Spain!29 !231 (10 images, 5 videos).tar.gz",
+			expected: "2023 06 June 15 trip (Spain) #1",
+		},
+		{
+			name:     "encoded path separator",
+			key:      "2023 06 June 15 !2E!2E!2Fescape (1 images, 0 videos).tar.gz",
+			expected: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -376,6 +498,76 @@ func TestS3Backup_ExtractDirNameFromKey(t *testing.T) {
 	}
 }
 
+func TestArchiveTags(t *testing.T) {
+	tests := map[string]string{
+		"2025 12 December 15 Vacation (42 images, 3 videos).tar.gz":        "images=42&month=12&videos=3&year=2025",
+		"2025 01 January 02 (1 images, 0 videos).part-0001.tar.gz":         "images=1&month=01&videos=0&year=2025",
+		"2025 01 January 02 (1 images, 0 videos) sync-0123456789ab.tar.gz": "images=1&month=01&videos=0&year=2025",
+		"review (2 images, 0 videos).manifest.json":                        "images=2&videos=0",
+		"2025 01 January 02.tar.gz":                                        "month=01&year=2025",
+	}
+	for key, expected := range tests {
+		if got := archiveTags(key); got != expected {
+			t.Errorf("archiveTags(%q) = %q, expected %q", key, got, expected)
+		}
+	}
+}
+
+func TestValidateS3Endpoint(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"https://s3.us-west-004.backblazeb2.com": true,
+		"http://localhost:9000":                  true,
+		"localhost:9000":                         false,
+		"s3.wasabisys.com":                       false,
+		"ftp://example.com":                      false,
+	} {
+		if err := ValidateS3Endpoint(endpoint); (err == nil) != valid {
+			t.Errorf("ValidateS3Endpoint(%q) = %v, expected valid %v", endpoint, err, valid)
+		}
+	}
+}
+
+func TestS3ClientOptions(t *testing.T) {
+	var defaults s3.Options
+	s3ClientOptions(DefaultS3Config())(&defaults)
+	if defaults.BaseEndpoint != nil || defaults.UsePathStyle {
+		t.Errorf("Expected the AWS defaults kept, got endpoint %v, path style %v", defaults.BaseEndpoint, defaults.UsePathStyle)
+	}
+
+	var minio s3.Options
+	s3ClientOptions(S3Config{Endpoint: "http://localhost:9000", PathStyle: true})(&minio)
+	if minio.BaseEndpoint == nil || *minio.BaseEndpoint != "http://localhost:9000" || !minio.UsePathStyle {
+		t.Errorf("Expected the endpoint set with path style, got endpoint %v, path style %v", minio.BaseEndpoint, minio.UsePathStyle)
+	}
+	if minio.RequestChecksumCalculation != aws.RequestChecksumCalculationWhenRequired {
+		t.Error("Expected request checksums only when required by a custom endpoint")
+	}
+}
+
+func TestNewS3Backup_EndpointRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	backup, err := NewS3Backup(testCtx, S3Config{Endpoint: "http://localhost:9000"})
+	if err != nil {
+		t.Fatalf("NewS3Backup failed: %v", err)
+	}
+	if region := backup.(*s3Backup).client.(*s3.Client).Options().Region; region != defaultEndpointRegion {
+		t.Errorf("Expected an endpoint without a region to default to %s, got %q", defaultEndpointRegion, region)
+	}
+
+	backup, err = NewS3Backup(testCtx, S3Config{Endpoint: "http://localhost:9000", Region: "eu-central-003"})
+	if err != nil {
+		t.Fatalf("NewS3Backup failed: %v", err)
+	}
+	if region := backup.(*s3Backup).client.(*s3.Client).Options().Region; region != "eu-central-003" {
+		t.Errorf("Expected the region set kept, got %q", region)
+	}
+}
+
 func TestIsNotFoundError(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -524,7 +716,8 @@ func TestS3Backup_BackupWithProgressChannel(t *testing.T) {
 	// Run backup in goroutine
 	done := make(chan error)
 	go func() {
-		done <- backup.BackupDirectories(context.Background(), sourceDir, "test-bucket", 2, progressChan)
+		_, err := backup.BackupDirectories(context.Background(), sourceDir, "test-bucket", BackupOptions{MaxConcurrent: 2, ProgressChan: progressChan})
+		done <- err
 	}()
 
 	// Collect progress events
@@ -0,0 +1,244 @@
+package pics
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// BackupVerifyReport describes how the backups in a bucket compare with the directories they
+// were made from
+type BackupVerifyReport struct {
+	// Directories is the number of directories checked
+	Directories int
+	// Current is the number of directories whose backup matches them
+	Current int
+	// Stale lists the directories backed up before they last changed, sorted by name
+	Stale []string
+	// Missing lists the directories never backed up, sorted by name
+	Missing []string
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+}
+
+// OK reports whether every directory checked is backed up as it is
+func (r BackupVerifyReport) OK() bool {
+	return len(r.Stale) == 0 && len(r.Missing) == 0
+}
+
+// verifyTally records which directories of a verification differ from their backup, shared by
+// its workers
+type verifyTally struct {
+	mu sync.Mutex
+	// checked records each directory checked, and whether it differs from its backup
+	checked map[string]bool
+}
+
+// newVerifyTally starts recording a verification
+func newVerifyTally() *verifyTally {
+	return &verifyTally{checked: make(map[string]bool)}
+}
+
+// matched records that an archive or file of dirName matches its backup
+func (t *verifyTally) matched(dirName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.checked[dirName]; !ok {
+		t.checked[dirName] = false
+	}
+}
+
+// differs records that dirName differs from its backup
+func (t *verifyTally) differs(dirName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checked[dirName] = true
+}
+
+// verifySink compares the archives a backup creates with those in a bucket instead of
+// uploading them
+type verifySink struct {
+	backup *s3Backup
+	bucket string
+	opts   BackupOptions
+	inv    *bucketInventory
+	tally  *verifyTally
+}
+
+// stored always reports false, so every archive is created to be compared
+func (v *verifySink) stored(key string) (int64, bool) {
+	return 0, false
+}
+
+// store compares the archive at path with the object stored under key
+func (v *verifySink) store(ctx context.Context, path, key, dirName string) error {
+	remoteETag := v.backup.extractETag(aws.String(v.inv.etag(key)))
+	if remoteETag == "" {
+		logger.Info("Archive not in the bucket", "directory", dirName, "key", key)
+		v.tally.differs(dirName)
+		return nil
+	}
+	hashes, err := v.backup.archiveHashes(path, v.opts.SHA256Checksums)
+	if err != nil {
+		return err
+	}
+	local, remote, err := v.backup.hashesToCompare(ctx, v.bucket, key, remoteETag, hashes)
+	if err != nil {
+		return err
+	}
+	if local != remote {
+		logger.Info("Archive in the bucket differs from the directory", "directory", dirName, "key", key, "local", local, "remote", remote)
+		v.tally.differs(dirName)
+		return nil
+	}
+	v.tally.matched(dirName)
+	return nil
+}
+
+// VerifyBackups checks that the backups in bucket match the directories of sourceDir, by
+// creating each archive a backup with opts would upload and comparing its hash with the one of
+// the object in the bucket, or for incremental backups the SHA-256 of each file with its
+// manifest. Nothing is uploaded.
+func (b *s3Backup) VerifyBackups(ctx context.Context, sourceDir, bucket string, opts BackupOptions) (BackupVerifyReport, error) {
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return BackupVerifyReport{}, err
+	}
+	defer b.storage.Close()
+	b = b.withObjectTimeout(opts.ObjectTimeout)
+	// Verifying leaves the library as it is, so a missing manifest isn't written
+	opts.WriteManifests = false
+	logger.Info("Starting backup verification", "bucket", bucket)
+
+	inv, err := b.listBucket(ctx, bucket, RestoreOptions{})
+	if err != nil {
+		return BackupVerifyReport{}, err
+	}
+	backedUp := backedUpDirectories(inv)
+
+	progress := newBackupTally()
+	tally := newVerifyTally()
+	verifyDir := b.archiveTo(sourceDir, opts, &verifySink{backup: b, bucket: bucket, opts: opts, inv: inv, tally: tally})
+	if opts.Mode == BackupModeIncremental {
+		verifyDir = b.verifyFilesOf(sourceDir, bucket, tally)
+	}
+	if err := b.backupDirectories(ctx, "verifying", sourceDir, opts, verifyDir, progress); err != nil {
+		return BackupVerifyReport{}, err
+	}
+
+	report := BackupVerifyReport{Directories: int(progress.directories.Load())}
+	for dirName, differs := range tally.checked {
+		switch {
+		case !differs:
+			report.Current++
+		case backedUp[dirName]:
+			report.Stale = append(report.Stale, dirName)
+		default:
+			report.Missing = append(report.Missing, dirName)
+		}
+	}
+	sort.Strings(report.Stale)
+	sort.Strings(report.Missing)
+	report.Warnings = logger.Warnings() - progress.warnings
+	report.Duration = time.Since(progress.start)
+	logger.Info("Backup verification completed", "current", report.Current, "stale", len(report.Stale), "missing", len(report.Missing))
+	return report, nil
+}
+
+// backedUpDirectories returns the names of the directories with any archive, part or manifest
+// in a bucket listing
+func backedUpDirectories(inv *bucketInventory) map[string]bool {
+	dirs := make(map[string]bool)
+	for _, obj := range inv.Objects {
+		if name, ok := strings.CutPrefix(obj.Key, incrementalPrefix); ok {
+			dirs[naming.DecodeKeyName(strings.TrimSuffix(name, incrementalManifestExtension))] = true
+			continue
+		}
+		if isSyncStateKey(obj.Key) {
+			continue
+		}
+		dirs[naming.KeyDirName(obj.Key)] = true
+	}
+	return dirs
+}
+
+// verifyFilesOf returns the directoryBackup comparing the files of the directories of sourceDir
+// with the manifests of their incremental backups in bucket
+func (b *s3Backup) verifyFilesOf(sourceDir, bucket string, tally *verifyTally) directoryBackup {
+	return func(ctx context.Context, dirName string, space *stagingSpace, skip skipFunc) error {
+		differs, err := b.directoryFilesDiffer(ctx, sourceDir, dirName, bucket, skip)
+		if err != nil {
+			return err
+		}
+		if differs {
+			tally.differs(dirName)
+		} else {
+			tally.matched(dirName)
+		}
+		return nil
+	}
+}
+
+// directoryFilesDiffer reports whether the files of a directory differ from the manifest of its
+// incremental backup. As when backing up, files whose size and modification time match the
+// manifest aren't read.
+func (b *s3Backup) directoryFilesDiffer(ctx context.Context, sourceDir, dirName, bucket string, skip skipFunc) (bool, error) {
+	dirPath := filepath.Join(sourceDir, dirName)
+	state, err := readDirectoryState(dirPath, skip)
+	if err != nil {
+		return false, fmt.Errorf("failed to read directory: %w", err)
+	}
+	manifestKey := incrementalManifestKey(dirName)
+	manifest, err := b.readIncrementalManifest(ctx, bucket, manifestKey)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("Directory not in the bucket", "directory", dirName, "key", manifestKey)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	backedUp := make(map[string]incrementalFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		backedUp[file.Path] = file
+	}
+	if len(backedUp) != len(state.Files) {
+		logger.Info("Files added to or removed from the directory since its backup", "directory", dirName, "files", len(state.Files), "backed_up", len(backedUp))
+		return true, nil
+	}
+	for _, file := range state.Files {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		last, known := backedUp[file.Path]
+		if !known {
+			logger.Info("File not in the backup", "directory", dirName, "file", file.Path)
+			return true, nil
+		}
+		if last.stateFile == file {
+			continue
+		}
+		sum, err := fileSHA256(filepath.Join(dirPath, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return false, fmt.Errorf("failed to calculate SHA-256 of %s: %w", file.Path, err)
+		}
+		if hex.EncodeToString(sum) != last.SHA256 {
+			logger.Info("File in the bucket differs from the directory", "directory", dirName, "file", file.Path)
+			return true, nil
+		}
+	}
+	return false, nil
+}
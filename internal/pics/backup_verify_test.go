@@ -0,0 +1,94 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBackup_VerifyBackups(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "party.jpg", "party")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 08 August 01"), "hike.jpg", "hike")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 09 September 01"), "photo1.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 09 September 01"), "photo2.jpg", 600)
+	opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	report, err := backup.VerifyBackups(testCtx, sourceDir, bucket, opts)
+	if err != nil {
+		t.Fatalf("VerifyBackups failed: %v", err)
+	}
+	if !report.OK() || report.Current != 4 || report.Directories != 4 {
+		t.Fatalf("Expected every directory to match its backup, got %+v", report)
+	}
+
+	// Edited contents keep the key but change the archive, a new file changes the key
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "edited beach")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "cake.jpg", "cake")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 10 October 01"), "new.jpg", "new")
+	objects := client.GetObjectCount(bucket)
+
+	report, err = backup.VerifyBackups(testCtx, sourceDir, bucket, opts)
+	if err != nil {
+		t.Fatalf("VerifyBackups failed: %v", err)
+	}
+	if report.OK() || report.Directories != 5 || report.Current != 2 {
+		t.Errorf("Expected 2 of 5 directories to match their backup, got %+v", report)
+	}
+	if want := []string{"2023 06 June 15", "2023 07 July 01"}; !reflect.DeepEqual(report.Stale, want) {
+		t.Errorf("Expected stale %v, got %v", want, report.Stale)
+	}
+	if want := []string{"2023 10 October 01"}; !reflect.DeepEqual(report.Missing, want) {
+		t.Errorf("Expected missing %v, got %v", want, report.Missing)
+	}
+	if client.GetObjectCount(bucket) != objects {
+		t.Error("Expected nothing uploaded while verifying")
+	}
+}
+
+func TestBackup_VerifyBackups_Incremental(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "party.jpg", "party")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 08 August 01"), "hike.jpg", "hike")
+	opts := DefaultBackupOptions()
+	opts.Mode = BackupModeIncremental
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "edited beach")
+	if err := os.Remove(filepath.Join(sourceDir, "2023 07 July 01", "party.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	writeContentFile(t, filepath.Join(sourceDir, "2023 10 October 01"), "new.jpg", "new")
+
+	report, err := backup.VerifyBackups(testCtx, sourceDir, bucket, opts)
+	if err != nil {
+		t.Fatalf("VerifyBackups failed: %v", err)
+	}
+	if report.Current != 1 {
+		t.Errorf("Expected the unchanged directory to match its backup, got %+v", report)
+	}
+	if want := []string{"2023 06 June 15", "2023 07 July 01"}; !reflect.DeepEqual(report.Stale, want) {
+		t.Errorf("Expected stale %v, got %v", want, report.Stale)
+	}
+	if want := []string{"2023 10 October 01"}; !reflect.DeepEqual(report.Missing, want) {
+		t.Errorf("Expected missing %v, got %v", want, report.Missing)
+	}
+}
@@ -0,0 +1,61 @@
+package pics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyDelimiter separates the prefix of a library sharing a bucket from its keys. Archive keys
+// never contain it, since EncodeKeyName escapes it.
+const keyDelimiter = "/"
+
+// ParseBucketPath splits a bucket path such as "photos" or "s3://photos/family/" into the bucket
+// and the prefix of the keys of the library, which is "" or ends with "/", so several libraries
+// can share a bucket
+func ParseBucketPath(path string) (bucket, prefix string, err error) {
+	bucket, prefix, _ = strings.Cut(strings.TrimPrefix(path, s3Scheme), keyDelimiter)
+	if bucket == "" {
+		return "", "", fmt.Errorf("invalid bucket path %q: no bucket name", path)
+	}
+	prefix = strings.TrimSuffix(prefix, keyDelimiter)
+	if prefix == "" {
+		return bucket, "", nil
+	}
+	for _, segment := range strings.Split(prefix, keyDelimiter) {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", fmt.Errorf("invalid bucket path %q: empty or relative prefix segment", path)
+		}
+	}
+	if isSyncStateKey(prefix + keyDelimiter) {
+		return "", "", fmt.Errorf("invalid bucket path %q: %s holds the sync state of the library at the root of the bucket", path, syncStatePrefix)
+	}
+	if strings.HasPrefix(prefix+keyDelimiter, incrementalPrefix) {
+		return "", "", fmt.Errorf("invalid bucket path %q: %s holds the incremental backups of the library at the root of the bucket", path, incrementalPrefix)
+	}
+	return bucket, prefix + keyDelimiter, nil
+}
+
+// forBucket returns a copy of b storing its objects in the bucket and under the prefix of path,
+// and path written the same way whatever its form. The objects of a copy for a file:// or
+// sftp:// URI are stored by the StorageBackend the URI names.
+func (b *s3Backup) forBucket(path string) (*s3Backup, string, error) {
+	backend, err := OpenStorageBackend(path)
+	if err != nil {
+		return nil, "", err
+	}
+	stored := *b
+	if backend != nil {
+		stored.storage = backend
+		return &stored, path, nil
+	}
+
+	bucket, prefix, err := ParseBucketPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	stored.storage = &s3Storage{client: b.client, bucket: bucket, prefix: prefix}
+	if prefix == "" {
+		return &stored, bucket, nil
+	}
+	return &stored, bucket + keyDelimiter + prefix, nil
+}
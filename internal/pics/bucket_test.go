@@ -0,0 +1,89 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBucketPath(t *testing.T) {
+	tests := []struct {
+		path           string
+		expectedBucket string
+		expectedPrefix string
+	}{
+		{"photos", "photos", ""},
+		{"photos/", "photos", ""},
+		{"photos/family", "photos", "family/"},
+		{"photos/family/", "photos", "family/"},
+		{"photos/users/ana/", "photos", "users/ana/"},
+		{"photos/syncs/", "photos", "syncs/"},
+	}
+	for _, tt := range tests {
+		bucket, prefix, err := ParseBucketPath(tt.path)
+		if err != nil || bucket != tt.expectedBucket || prefix != tt.expectedPrefix {
+			t.Errorf("ParseBucketPath(%q) = %q, %q, %v, expected %q, %q", tt.path, bucket, prefix, err, tt.expectedBucket, tt.expectedPrefix)
+		}
+	}
+
+	for _, path := range []string{"", "/family", "photos//family", "photos/../other", "photos/./family", "photos/sync", "photos/sync/", "photos/incremental/"} {
+		if _, _, err := ParseBucketPath(path); err == nil {
+			t.Errorf("Expected an error for %q", path)
+		}
+	}
+}
+
+func TestBackup_BucketPrefix(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	client.CreateBucket("photos")
+
+	// Two libraries, one at the root of the bucket and one under a prefix
+	rootLibrary := t.TempDir()
+	writeContentFile(t, filepath.Join(rootLibrary, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	familyLibrary := t.TempDir()
+	writeContentFile(t, filepath.Join(familyLibrary, "2024 01 January 01"), "2024_01_January_01_00001.jpg", "fireworks")
+	if _, err := backup.BackupDirectories(testCtx, rootLibrary, "photos", BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if _, err := backup.BackupDirectories(testCtx, familyLibrary, "photos/family", BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories with a prefix failed: %v", err)
+	}
+
+	data, err := client.GetObjectData("photos", "family/2024 01 January 01 (1 images, 0 videos).tar.gz")
+	if err != nil || len(data) == 0 {
+		t.Errorf("Expected the archive under the prefix: %v", err)
+	}
+
+	// Each restore only sees its own library
+	for path, expected := range map[string][]string{
+		"photos":         {"2023 06 June 15"},
+		"photos/family/": {"2024 01 January 01"},
+	} {
+		targetDir := t.TempDir()
+		if _, err := backup.RestoreDirectories(testCtx, path, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+			t.Fatalf("RestoreDirectories of %s failed: %v", path, err)
+		}
+		if got := listDir(t, targetDir); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Restore of %s: expected %v, got %v", path, expected, got)
+		}
+	}
+}
+
+func TestBackup_BucketPrefix_InventoryCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	path := inventoryPath(cacheDir, "", "photos/family/")
+	if filepath.Dir(path) != cacheDir || !strings.HasPrefix(filepath.Base(path), "photos") {
+		t.Errorf("Expected the cache of a prefix in the cache directory, got %s", path)
+	}
+	if inventoryPath(cacheDir, "", "photos") == path {
+		t.Error("Expected the root of the bucket and the prefix cached apart")
+	}
+
+	// A bucket of the same name at another endpoint is another bucket
+	minio := inventoryPath(cacheDir, "http://localhost:9000", "photos/family/")
+	if minio == path || filepath.Dir(minio) != cacheDir || strings.ContainsAny(filepath.Base(minio), `:/\`) {
+		t.Errorf("Expected the cache of another endpoint apart in the cache directory, got %s", minio)
+	}
+}
@@ -0,0 +1,162 @@
+package pics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// Phases of a backup or restore reported with the bytes of the current item
+const (
+	// PhaseArchiving counts the bytes of the files read into an archive
+	PhaseArchiving = "archiving"
+	// PhaseUploading counts the bytes of an archive or file uploaded
+	PhaseUploading = "uploading"
+	// PhaseDownloading counts the bytes of an archive or file downloaded
+	PhaseDownloading = "downloading"
+	// PhaseExtracting counts the bytes of an archive read while extracting it
+	PhaseExtracting = "extracting"
+)
+
+// byteProgressInterval is the least time between two byte progress events of a phase, so large
+// archives don't flood the progress channel
+const byteProgressInterval = 250 * time.Millisecond
+
+// byteProgressKey is the context key of the item whose bytes are reported
+type byteProgressKey struct{}
+
+// byteProgressItem is the item being processed, reported to ch
+type byteProgressItem struct {
+	ch    chan<- ProgressEvent
+	event ProgressEvent
+	// current counts the items the run has started, so byte events never report fewer than
+	// the item events sent since this one
+	current *atomic.Int64
+}
+
+// withByteProgress returns a context under which archiving, uploads, downloads and extraction
+// report their bytes to ch as events of the item event describes, with the Current of the run's
+// counter. Without a channel, ctx is returned as it is.
+func withByteProgress(ctx context.Context, ch chan<- ProgressEvent, event ProgressEvent, current *atomic.Int64) context.Context {
+	if ch == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, byteProgressKey{}, byteProgressItem{ch: ch, event: event, current: current})
+}
+
+// byteMeter counts the bytes of a phase of an item and reports them as progress events.
+// A nil byteMeter counts nothing.
+type byteMeter struct {
+	item  byteProgressItem
+	phase string
+	total int64
+
+	mu    sync.Mutex
+	bytes int64
+	last  time.Time
+}
+
+// newByteMeter returns a meter for total bytes (0 if unknown) of phase of the item of ctx, or nil
+// when ctx reports no progress
+func newByteMeter(ctx context.Context, phase string, total int64) *byteMeter {
+	item, ok := ctx.Value(byteProgressKey{}).(byteProgressItem)
+	if !ok {
+		return nil
+	}
+	return &byteMeter{item: item, phase: phase, total: total}
+}
+
+// add counts n more bytes, reporting them unless the last report was too recent
+func (m *byteMeter) add(n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += n
+	if time.Since(m.last) >= byteProgressInterval {
+		m.report()
+	}
+}
+
+// set moves the count to n without reporting it, for bodies the S3 client rewinds
+func (m *byteMeter) set(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes = n
+}
+
+// done reports the final count of the phase, however recent the last report was
+func (m *byteMeter) done() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report()
+}
+
+// report sends the count without waiting, dropping it when the channel is full
+func (m *byteMeter) report() {
+	m.last = time.Now()
+	event := m.item.event
+	if m.item.current != nil {
+		event.Current = int(m.item.current.Load())
+	}
+	event.Phase, event.Bytes, event.TotalBytes = m.phase, m.bytes, m.total
+	select {
+	case m.item.ch <- event:
+	default:
+		logger.Debug("Progress event dropped (channel full)", "stage", event.Stage, "phase", m.phase)
+	}
+}
+
+// reader returns r counting the bytes read from it
+func (m *byteMeter) reader(r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+	return &meteredReader{r: r, meter: m}
+}
+
+// readSeeker returns r counting the bytes read from it. Seeking moves the count to the new
+// offset, so a body read again from the start after a retry isn't counted twice.
+func (m *byteMeter) readSeeker(r io.ReadSeeker) io.ReadSeeker {
+	if m == nil {
+		return r
+	}
+	return &meteredReadSeeker{meteredReader: meteredReader{r: r, meter: m}, seeker: r}
+}
+
+// meteredReader counts the bytes read through it
+type meteredReader struct {
+	r     io.Reader
+	meter *byteMeter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.meter.add(int64(n))
+	return n, err
+}
+
+// meteredReadSeeker counts the bytes read through it up to its offset
+type meteredReadSeeker struct {
+	meteredReader
+	seeker io.Seeker
+}
+
+func (r *meteredReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.seeker.Seek(offset, whence)
+	if err == nil {
+		r.meter.set(pos)
+	}
+	return pos, err
+}
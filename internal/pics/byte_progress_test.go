@@ -0,0 +1,141 @@
+package pics
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// drainProgress returns the events sent to ch so far
+func drainProgress(ch chan ProgressEvent) []ProgressEvent {
+	var events []ProgressEvent
+	for {
+		select {
+		case event := <-ch:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// finalBytes returns the last byte count reported for each phase
+func finalBytes(events []ProgressEvent) map[string]ProgressEvent {
+	final := make(map[string]ProgressEvent)
+	for _, event := range events {
+		if event.Phase != "" {
+			final[event.Phase] = event
+		}
+	}
+	return final
+}
+
+func TestByteMeter_WithoutProgress(t *testing.T) {
+	meter := newByteMeter(context.Background(), PhaseUploading, 10)
+	if meter != nil {
+		t.Fatal("Expected no meter for a context without progress")
+	}
+	r := strings.NewReader("contents")
+	if meter.reader(r) != r {
+		t.Error("Expected a nil meter to return the reader as it is")
+	}
+	meter.add(5)
+	meter.done()
+
+	if ctx := withByteProgress(context.Background(), nil, ProgressEvent{}, nil); newByteMeter(ctx, PhaseUploading, 10) != nil {
+		t.Error("Expected no meter without a progress channel")
+	}
+}
+
+func TestByteMeter_ReadSeeker(t *testing.T) {
+	ch := make(chan ProgressEvent, 10)
+	item := ProgressEvent{Stage: "backing up", Current: 2, Total: 5, File: "2023 06 June 15"}
+	meter := newByteMeter(withByteProgress(context.Background(), ch, item, nil), PhaseUploading, 8)
+
+	body := meter.readSeeker(strings.NewReader("contents"))
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+	// A retry reads the body again from the start
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+	meter.done()
+
+	events := drainProgress(ch)
+	if len(events) == 0 {
+		t.Fatal("Expected byte progress events")
+	}
+	last := events[len(events)-1]
+	want := item
+	want.Phase, want.Bytes, want.TotalBytes = PhaseUploading, 8, 8
+	if last != want {
+		t.Errorf("Expected the last event %+v, got %+v", want, last)
+	}
+}
+
+func TestByteMeter_CurrentOfRun(t *testing.T) {
+	ch := make(chan ProgressEvent, 10)
+	var started atomic.Int64
+	started.Store(2)
+	item := ProgressEvent{Stage: "backing up", Current: 2, Total: 5, File: "2023 06 June 15"}
+	meter := newByteMeter(withByteProgress(context.Background(), ch, item, &started), PhaseUploading, 8)
+
+	// Other workers start the next items while this one uploads
+	started.Store(4)
+	meter.add(8)
+	meter.done()
+
+	for _, event := range drainProgress(ch) {
+		if event.Current != 4 {
+			t.Errorf("Expected byte progress to report the 4 items started, got %d", event.Current)
+		}
+		if event.File != item.File {
+			t.Errorf("Expected byte progress of %s, got %s", item.File, event.File)
+		}
+	}
+}
+
+func TestBackup_ByteProgress(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "photo.jpg", 3000)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15", "videos"), "clip.mov", 5000)
+
+	ch := make(chan ProgressEvent, 100)
+	opts := DefaultBackupOptions()
+	opts.ProgressChan = ch
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	final := finalBytes(drainProgress(ch))
+	if archiving := final[PhaseArchiving]; archiving.Bytes != 8000 || archiving.TotalBytes != 8000 || archiving.Stage != "backing up" || archiving.File != "2023 06 June 15" {
+		t.Errorf("Expected every byte of the files archived, got %+v", archiving)
+	}
+	if uploading := final[PhaseUploading]; uploading.TotalBytes == 0 || uploading.Bytes != uploading.TotalBytes {
+		t.Errorf("Expected the whole archive uploaded, got %+v", uploading)
+	}
+
+	restoreOpts := DefaultRestoreOptions()
+	restoreOpts.ProgressChan = ch
+	if _, err := backup.RestoreDirectories(testCtx, bucket, filepath.Join(tmpDir, "restored"), restoreOpts); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	final = finalBytes(drainProgress(ch))
+	for _, phase := range []string{PhaseDownloading, PhaseExtracting} {
+		if event := final[phase]; event.Stage != "restoring" || event.TotalBytes == 0 || event.Bytes != event.TotalBytes {
+			t.Errorf("Expected the whole archive counted while %s, got %+v", phase, event)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package pics
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+)
+
+// fileSHA256 returns the SHA-256 hash of a file's contents
+func fileSHA256(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// fileSHA256Base64 returns the SHA-256 hash of a file's contents in the base64 form S3 uses for checksums
+func fileSHA256Base64(filePath string) (string, error) {
+	sum, err := fileSHA256(filePath)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
+}
@@ -0,0 +1,378 @@
+package pics
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// ChecksumManifestName is the file of a date directory listing the SHA-256 checksums of its files,
+// in the format of sha256sum, so "sha256sum -c manifest.sha256" run in the directory checks it too
+const ChecksumManifestName = "manifest.sha256"
+
+// ManifestProblemKind is the kind of difference check-manifest finds between a directory and
+// its manifest
+type ManifestProblemKind string
+
+const (
+	// ManifestChanged is a file whose contents no longer match its checksum
+	ManifestChanged ManifestProblemKind = "changed"
+	// ManifestMissing is a file listed in the manifest that isn't in the directory
+	ManifestMissing ManifestProblemKind = "missing"
+	// ManifestUnlisted is a file of the directory the manifest doesn't list, such as one added
+	// without updating it
+	ManifestUnlisted ManifestProblemKind = "unlisted"
+)
+
+// ManifestChecker checks directories against the checksums of their manifests
+type ManifestChecker interface {
+	// Check checks the manifest of dir if it has one, and those of the directories directly
+	// inside it, so both a date directory and a library can be checked
+	Check(dir string) (ManifestReport, error)
+}
+
+// ManifestReport lists the differences found between directories and their manifests
+type ManifestReport struct {
+	// Directories is the number of manifests checked
+	Directories int `json:"directories"`
+	// Files is the number of files whose checksums match
+	Files int `json:"files"`
+	// Problems lists the differences found, sorted by path
+	Problems []ManifestProblem `json:"problems"`
+}
+
+// ManifestProblem is a difference found between a directory and its manifest
+type ManifestProblem struct {
+	// Kind is the kind of difference
+	Kind ManifestProblemKind `json:"kind"`
+	// Path is the file, relative to the checked directory using "/" as separator
+	Path string `json:"path"`
+}
+
+// OK reports whether every file listed in the manifests is unchanged. Unlisted files are
+// reported but don't count, as they have nothing to be checked against.
+func (r ManifestReport) OK() bool {
+	for _, problem := range r.Problems {
+		if problem.Kind != ManifestUnlisted {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestChecker implements the ManifestChecker interface
+type manifestChecker struct{}
+
+// NewManifestChecker creates a new ManifestChecker instance
+func NewManifestChecker() ManifestChecker {
+	return &manifestChecker{}
+}
+
+// Check reads every file listed in the manifests found and compares its checksum
+func (c *manifestChecker) Check(dir string) (ManifestReport, error) {
+	var report ManifestReport
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return report, fmt.Errorf("not a valid directory: %s", dir)
+	}
+
+	dirs := []string{}
+	if _, err := os.Stat(filepath.Join(dir, ChecksumManifestName)); err == nil {
+		dirs = append(dirs, dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), ChecksumManifestName)); err == nil {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(dirs) == 0 {
+		return report, fmt.Errorf("no %s found in %s or the directories inside it", ChecksumManifestName, dir)
+	}
+
+	for _, manifestDir := range dirs {
+		if err := c.checkDirectory(dir, manifestDir, &report); err != nil {
+			return report, err
+		}
+		report.Directories++
+	}
+	sort.Slice(report.Problems, func(i, j int) bool { return report.Problems[i].Path < report.Problems[j].Path })
+	return report, nil
+}
+
+// checkDirectory compares the files of manifestDir with its manifest, adding the differences to
+// report with paths relative to dir
+func (c *manifestChecker) checkDirectory(dir, manifestDir string, report *ManifestReport) error {
+	sums, err := readChecksumManifest(manifestDir)
+	if err != nil {
+		return err
+	}
+	files, err := checksumManifestFiles(manifestDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", manifestDir, err)
+	}
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file] = true
+	}
+
+	for file, sum := range sums {
+		path := filepath.Join(manifestDir, filepath.FromSlash(file))
+		if !present[file] {
+			report.Problems = append(report.Problems, ManifestProblem{Kind: ManifestMissing, Path: libraryPath(dir, path)})
+			continue
+		}
+		actual, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(actual) != sum {
+			logger.Warn("File doesn't match its checksum", "file", path)
+			report.Problems = append(report.Problems, ManifestProblem{Kind: ManifestChanged, Path: libraryPath(dir, path)})
+			continue
+		}
+		report.Files++
+	}
+	for _, file := range files {
+		if _, ok := sums[file]; !ok {
+			report.Problems = append(report.Problems, ManifestProblem{Kind: ManifestUnlisted, Path: libraryPath(dir, filepath.Join(manifestDir, filepath.FromSlash(file)))})
+		}
+	}
+	return nil
+}
+
+// checksumManifestFiles returns the files of dir a manifest lists, relative to it using "/" as
+// separator: every regular file but dot files, the files of dot directories and the manifest itself
+func checksumManifestFiles(dir string) ([]string, error) {
+	state, err := readDirectoryState(dir, checksumManifestSkip(dir))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(state.Files))
+	for i, file := range state.Files {
+		files[i] = file.Path
+	}
+	return files, nil
+}
+
+// readChecksumManifest reads the checksums of the manifest of dir by path
+func readChecksumManifest(dir string) (map[string]string, error) {
+	path := filepath.Join(dir, ChecksumManifestName)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		// sha256sum escapes names holding a backslash or a newline, and marks their lines with
+		// a leading backslash
+		escaped := strings.HasPrefix(text, `\`)
+		text = strings.TrimPrefix(text, `\`)
+		sum, name, ok := strings.Cut(text, " ")
+		if !ok || len(sum) != 64 || len(name) < 2 {
+			return nil, fmt.Errorf("invalid line %d of %s", line, path)
+		}
+		// The character after the separator is " " for text mode or "*" for binary mode
+		name = name[1:]
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		sums[name] = strings.ToLower(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// writeChecksumManifest writes the checksums of the files of dir to its manifest, sorted by path
+func writeChecksumManifest(dir string, sums map[string]string) error {
+	paths := make([]string, 0, len(sums))
+	for path := range sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		name := path
+		if strings.ContainsAny(name, "\\\n") {
+			b.WriteString(`\`)
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sums[path], name)
+	}
+	return writeFileAtomically(dir, ChecksumManifestName, []byte(b.String()))
+}
+
+// updateChecksumManifest adds the files of dir its manifest doesn't list yet, keeping the entries
+// it has. Listed files renamed since, as numbering does, are matched by their contents and listed
+// under their new names. Listed files that changed or are gone keep their entries, so
+// check-manifest still catches them, and are returned as problems with paths relative to dir.
+func updateChecksumManifest(dir string) ([]ManifestProblem, error) {
+	previous, err := readChecksumManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("Replacing unreadable manifest", "directory", dir, "error", err)
+	}
+	files, err := checksumManifestFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]string, len(files))
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		current[file] = hex.EncodeToString(sum)
+		present[current[file]] = true
+	}
+
+	sums := make(map[string]string, len(current))
+	var problems []ManifestProblem
+	for file, sum := range current {
+		listed, ok := previous[file]
+		// A listed name holding other contents was given to another file, unless the contents
+		// it listed are nowhere to be found
+		if ok && listed != sum && !present[listed] {
+			logger.Warn("File doesn't match its checksum, keeping the listed one", "directory", dir, "file", file)
+			problems = append(problems, ManifestProblem{Kind: ManifestChanged, Path: file})
+			sum = listed
+		}
+		sums[file] = sum
+	}
+	for file, listed := range previous {
+		if _, ok := current[file]; !ok && !present[listed] {
+			logger.Warn("File listed in the manifest was removed", "directory", dir, "file", file)
+			problems = append(problems, ManifestProblem{Kind: ManifestMissing, Path: file})
+			sums[file] = listed
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+
+	if err := writeChecksumManifest(dir, sums); err != nil {
+		return nil, fmt.Errorf("failed to write manifest of %s: %w", dir, err)
+	}
+	logger.Debug("Manifest updated", "directory", dir, "files", len(sums), "problems", len(problems))
+	return problems, nil
+}
+
+// renameChecksumManifestEntries renames the files of the manifest of dir as renames did, whose
+// paths are inside dir, keeping their checksums. A directory without a manifest is left alone.
+func renameChecksumManifestEntries(dir string, renames []fileRename) error {
+	sums, err := readChecksumManifest(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	moved := make(map[string]string, len(renames))
+	for _, rename := range renames {
+		from, to := libraryPath(dir, rename.from), libraryPath(dir, rename.to)
+		if sum, ok := sums[from]; ok {
+			moved[to] = sum
+			delete(sums, from)
+		}
+	}
+	for path, sum := range moved {
+		sums[path] = sum
+	}
+	if err := writeChecksumManifest(dir, sums); err != nil {
+		return fmt.Errorf("failed to update manifest of %s: %w", dir, err)
+	}
+	return nil
+}
+
+// writeMissingChecksumManifest writes the manifest of dir unless it has one already
+func writeMissingChecksumManifest(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ChecksumManifestName)); err == nil {
+		return nil
+	}
+	_, err := updateChecksumManifest(dir)
+	return err
+}
+
+// checksumManifestVersions returns the version of the files each directory of libraryDir lists in
+// a manifest, by name, so updateChecksumManifests can tell the directories changed since
+func checksumManifestVersions(libraryDir string) (map[string]string, error) {
+	entries, err := os.ReadDir(libraryDir)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(libraryDir, entry.Name())
+		state, err := readDirectoryState(dir, checksumManifestSkip(dir))
+		if err != nil {
+			return nil, err
+		}
+		versions[entry.Name()] = state.version()
+	}
+	return versions, nil
+}
+
+// updateChecksumManifests updates the manifests of the directories of libraryDir that are new or
+// whose files changed since before was read by checksumManifestVersions. It returns the number
+// updated and the problems found in them, with paths relative to libraryDir.
+func updateChecksumManifests(libraryDir string, before map[string]string) (int, []ManifestProblem, error) {
+	after, err := checksumManifestVersions(libraryDir)
+	if err != nil {
+		return 0, nil, err
+	}
+	names := make([]string, 0, len(after))
+	for name, version := range after {
+		if before[name] != version {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var problems []ManifestProblem
+	for _, name := range names {
+		found, err := updateChecksumManifest(filepath.Join(libraryDir, name))
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, problem := range found {
+			problem.Path = name + "/" + problem.Path
+			problems = append(problems, problem)
+		}
+	}
+	return len(names), problems, nil
+}
+
+// checksumManifestSkip returns a skipFunc leaving out the files of dir a manifest doesn't list
+func checksumManifestSkip(dir string) skipFunc {
+	manifest := filepath.Join(dir, ChecksumManifestName)
+	return func(path string, isDir bool) bool {
+		return strings.HasPrefix(filepath.Base(path), ".") || path == manifest
+	}
+}
@@ -0,0 +1,270 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestChecker_Check(t *testing.T) {
+	library := t.TempDir()
+	june := filepath.Join(library, "2023 06 June 15")
+	july := filepath.Join(library, "2023 07 July 01")
+	writeContentFile(t, june, "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, june, "2023_06_June_15_00002.jpg", "dinner")
+	writeContentFile(t, filepath.Join(june, "videos"), "2023_06_June_15_00001.mov", "waves")
+	writeContentFile(t, july, "2023_07_July_01_00001.jpg", "fireworks")
+	// Directories without a manifest are left out
+	writeContentFile(t, filepath.Join(library, "2023 08 August 02"), "2023_08_August_02_00001.jpg", "hike")
+	for _, dir := range []string{june, july} {
+		if _, err := updateChecksumManifest(dir); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+	}
+
+	checker := NewManifestChecker()
+	report, err := checker.Check(library)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Directories != 2 || report.Files != 4 || len(report.Problems) != 0 {
+		t.Errorf("Expected 2 directories and 4 files without problems, got %+v", report)
+	}
+
+	writeContentFile(t, june, "2023_06_June_15_00001.jpg", "beach, bit rotted")
+	if err := os.Remove(filepath.Join(june, "videos", "2023_06_June_15_00001.mov")); err != nil {
+		t.Fatalf("Failed to remove video: %v", err)
+	}
+	writeContentFile(t, july, "2023_07_July_01_00002.jpg", "parade")
+	// Hidden files, such as the rename journal, aren't listed
+	writeContentFile(t, july, renameJournalName, "{}")
+
+	report, err = checker.Check(library)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	expected := []ManifestProblem{
+		{Kind: ManifestChanged, Path: "2023 06 June 15/2023_06_June_15_00001.jpg"},
+		{Kind: ManifestMissing, Path: "2023 06 June 15/videos/2023_06_June_15_00001.mov"},
+		{Kind: ManifestUnlisted, Path: "2023 07 July 01/2023_07_July_01_00002.jpg"},
+	}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("Expected problems %+v, got %+v", expected, report.Problems)
+	}
+	if report.OK() || report.Files != 2 {
+		t.Errorf("Expected a failed check with 2 files matching, got %+v", report)
+	}
+
+	// A date directory is checked on its own, with paths relative to it
+	report, err = checker.Check(july)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Directories != 1 || !reflect.DeepEqual(report.Problems, []ManifestProblem{{Kind: ManifestUnlisted, Path: "2023_07_July_01_00002.jpg"}}) {
+		t.Errorf("Expected the unlisted file alone, got %+v", report)
+	}
+}
+
+func TestManifestChecker_Check_WithoutManifest(t *testing.T) {
+	library := t.TempDir()
+	writeContentFile(t, filepath.Join(library, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+
+	if _, err := NewManifestChecker().Check(library); err == nil {
+		t.Error("Expected an error for a library without manifests")
+	}
+	if _, err := NewManifestChecker().Check(filepath.Join(library, "missing")); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
+
+func TestReadChecksumManifest_Sha256sumFormat(t *testing.T) {
+	dir := t.TempDir()
+	sum := strings.Repeat("ab", 32)
+	// Lines as sha256sum writes them in text and binary mode, and with an escaped name
+	writeContentFile(t, dir, ChecksumManifestName, sum+"  beach.jpg\n"+
+		strings.ToUpper(sum)+" *videos/waves.mov\n"+
+		`\`+sum+`  back\\slash\nnewline.jpg`+"\n")
+
+	sums, err := readChecksumManifest(dir)
+	if err != nil {
+		t.Fatalf("readChecksumManifest failed: %v", err)
+	}
+	expected := map[string]string{
+		"beach.jpg":                sum,
+		"videos/waves.mov":         sum,
+		"back\\slash\nnewline.jpg": sum,
+	}
+	if !reflect.DeepEqual(sums, expected) {
+		t.Errorf("Expected %q, got %q", expected, sums)
+	}
+
+	// Written back, the manifest reads the same
+	if err := writeChecksumManifest(dir, sums); err != nil {
+		t.Fatalf("writeChecksumManifest failed: %v", err)
+	}
+	if sums, err = readChecksumManifest(dir); err != nil || !reflect.DeepEqual(sums, expected) {
+		t.Errorf("Expected %q after writing, got %q (%v)", expected, sums, err)
+	}
+
+	writeContentFile(t, dir, ChecksumManifestName, "not a checksum\n")
+	if _, err := readChecksumManifest(dir); err == nil {
+		t.Error("Expected an error for an invalid manifest")
+	}
+}
+
+func TestUpdateChecksumManifests(t *testing.T) {
+	library := t.TempDir()
+	june := filepath.Join(library, "2023 06 June 15")
+	july := filepath.Join(library, "2023 07 July 01")
+	writeContentFile(t, june, "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, july, "2023_07_July_01_00001.jpg", "fireworks")
+	if _, err := updateChecksumManifest(july); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	versions, err := checksumManifestVersions(library)
+	if err != nil {
+		t.Fatalf("checksumManifestVersions failed: %v", err)
+	}
+	// July gains a file numbered before its first, as parse does, and August is new
+	if err := os.Rename(filepath.Join(july, "2023_07_July_01_00001.jpg"), filepath.Join(july, "2023_07_July_01_00002.jpg")); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	writeContentFile(t, july, "2023_07_July_01_00001.jpg", "parade")
+	august := filepath.Join(library, "2023 08 August 02")
+	writeContentFile(t, august, "2023_08_August_02_00001.jpg", "hike")
+
+	updated, problems, err := updateChecksumManifests(library, versions)
+	if err != nil {
+		t.Fatalf("updateChecksumManifests failed: %v", err)
+	}
+	if updated != 2 || len(problems) != 0 {
+		t.Errorf("Expected 2 manifests updated without problems, got %d, %+v", updated, problems)
+	}
+	if _, err := os.Stat(filepath.Join(june, ChecksumManifestName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest in the unchanged directory, got %v", err)
+	}
+	report, err := NewManifestChecker().Check(library)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Directories != 2 || report.Files != 3 || len(report.Problems) != 0 {
+		t.Errorf("Expected 2 directories and 3 files without problems, got %+v", report)
+	}
+}
+
+func TestUpdateChecksumManifest_KeepsEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeContentFile(t, dir, "beach.jpg", "beach")
+	writeContentFile(t, dir, "dinner.jpg", "dinner")
+	writeContentFile(t, dir, "hike.jpg", "hike")
+	if _, err := updateChecksumManifest(dir); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	listed, err := readChecksumManifest(dir)
+	if err != nil {
+		t.Fatalf("readChecksumManifest failed: %v", err)
+	}
+
+	// A file rots, another is lost, a third is renamed and a new one is added
+	writeContentFile(t, dir, "beach.jpg", "beach, bit rotted")
+	if err := os.Remove(filepath.Join(dir, "dinner.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "hike.jpg"), filepath.Join(dir, "mountain.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	writeContentFile(t, dir, "parade.jpg", "parade")
+
+	problems, err := updateChecksumManifest(dir)
+	if err != nil {
+		t.Fatalf("updateChecksumManifest failed: %v", err)
+	}
+	expected := []ManifestProblem{
+		{Kind: ManifestChanged, Path: "beach.jpg"},
+		{Kind: ManifestMissing, Path: "dinner.jpg"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %+v, got %+v", expected, problems)
+	}
+	sums, err := readChecksumManifest(dir)
+	if err != nil {
+		t.Fatalf("readChecksumManifest failed: %v", err)
+	}
+	if sums["beach.jpg"] != listed["beach.jpg"] || sums["dinner.jpg"] != listed["dinner.jpg"] {
+		t.Errorf("Expected the checksums of the changed and removed files kept, got %v", sums)
+	}
+	if _, ok := sums["hike.jpg"]; ok || sums["mountain.jpg"] != listed["hike.jpg"] {
+		t.Errorf("Expected the renamed file listed under its new name, got %v", sums)
+	}
+	if _, ok := sums["parade.jpg"]; !ok || len(sums) != 4 {
+		t.Errorf("Expected the new file added, got %v", sums)
+	}
+}
+
+func TestChecksumManifest_FollowsRenames(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	videosDir := createTestDirectory(t, testDir, "videos")
+	createTestFileWithTime(t, testDir, "beach.jpg", parseTime(t, "2023-06-15T10:00:00Z"))
+	createTestFileWithTime(t, testDir, "dinner.jpg", parseTime(t, "2023-06-15T20:00:00Z"))
+	createTestFileWithTime(t, videosDir, "waves.mov", parseTime(t, "2023-06-15T11:00:00Z"))
+	if _, err := updateChecksumManifest(testDir); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	renamer := &directoryRenamer{extensions: NewExtensions(), fileRenamer: newModTimeRenamer()}
+
+	if _, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate); err != nil {
+		t.Fatalf("RenameDirectory failed: %v", err)
+	}
+	renamedDir := filepath.Join(tmpDir, "2023 06 June 15 vacation")
+	report, err := NewManifestChecker().Check(renamedDir)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Files != 3 || len(report.Problems) != 0 {
+		t.Errorf("Expected the renamed files to match their manifest, got %+v", report)
+	}
+
+	if _, err := renamer.UndoRename(renamedDir); err != nil {
+		t.Fatalf("UndoRename failed: %v", err)
+	}
+	sums, err := readChecksumManifest(testDir)
+	if err != nil {
+		t.Fatalf("readChecksumManifest failed: %v", err)
+	}
+	if _, ok := sums["videos/waves.mov"]; !ok || len(sums) != 3 {
+		t.Errorf("Expected the manifest to list the restored names, got %v", sums)
+	}
+}
+
+func TestMergeDirectory_UpdatesChecksumManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "incoming")
+	dst := filepath.Join(tmpDir, "library")
+	prefix := "2023_06_June_15"
+	writeContentFile(t, dst, prefix+"_00001.jpg", "beach")
+	writeContentFile(t, src, prefix+"_00001.jpg", "dinner")
+	for _, dir := range []string{src, dst} {
+		if _, err := updateChecksumManifest(dir); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+	}
+
+	result, err := mergeDirectory(src, dst)
+	if err != nil {
+		t.Fatalf("mergeDirectory failed: %v", err)
+	}
+	if result.added != 1 {
+		t.Errorf("Expected 1 file added, got %+v", result)
+	}
+	report, err := NewManifestChecker().Check(dst)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Files != 2 || len(report.Problems) != 0 {
+		t.Errorf("Expected the merged files to match the manifest, got %+v", report)
+	}
+}
@@ -0,0 +1,38 @@
+package pics
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSHA256(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(filePath, []byte("abc"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	sum, err := fileSHA256(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if hex.EncodeToString(sum) != expected {
+		t.Errorf("Expected %s, got %s", expected, hex.EncodeToString(sum))
+	}
+
+	encoded, err := fileSHA256Base64(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if encoded != "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=" {
+		t.Errorf("Unexpected base64 checksum: %s", encoded)
+	}
+}
+
+func TestFileSHA256_NonexistentFile(t *testing.T) {
+	if _, err := fileSHA256(filepath.Join(t.TempDir(), "missing.jpg")); err == nil {
+		t.Error("Expected error for nonexistent file")
+	}
+}
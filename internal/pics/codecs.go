@@ -0,0 +1,238 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ImageFormat is a format images are written in when imported
+type ImageFormat string
+
+const (
+	// ImageFormatJPEG keeps JPEGs as JPEGs, compressed with jpegoptim to the chosen quality
+	ImageFormatJPEG ImageFormat = "jpeg"
+	// ImageFormatJPEGLossless keeps JPEGs as JPEGs, optimised with jpegoptim without losing quality
+	ImageFormatJPEGLossless ImageFormat = "jpeg-lossless"
+	// ImageFormatWebP converts images to WebP with cwebp
+	ImageFormatWebP ImageFormat = "webp"
+	// ImageFormatAVIF converts images to AVIF with avifenc
+	ImageFormatAVIF ImageFormat = "avif"
+)
+
+// ParseImageFormat parses "jpeg", "jpeg-lossless", "webp" or "avif"
+func ParseImageFormat(s string) (ImageFormat, error) {
+	switch format := ImageFormat(strings.ToLower(strings.TrimSpace(s))); format {
+	case ImageFormatJPEG, ImageFormatJPEGLossless, ImageFormatWebP, ImageFormatAVIF:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid image format %q (expected jpeg, jpeg-lossless, webp or avif)", s)
+}
+
+// jpegExtensions are the extensions of JPEGs, which a format given for either applies to
+var jpegExtensions = []string{".jpg", ".jpeg"}
+
+// encodingInfix is added before the extension of an image while it is encoded again in its own
+// format
+const encodingInfix = ".encoding"
+
+// ParseOutputFormats parses the output format of each type of image, as ParseOptions.OutputFormats
+// holds them. Each value is either a format for JPEGs, such as "webp", or the extension of a type
+// of image and its format, such as "png=avif".
+func ParseOutputFormats(values []string) (map[string]ImageFormat, error) {
+	formats := make(map[string]ImageFormat)
+	for _, value := range values {
+		extValue, formatValue, found := strings.Cut(value, "=")
+		if !found {
+			extValue, formatValue = "jpg", value
+		}
+		ext, err := ParseExtension(extValue)
+		if err != nil {
+			return nil, err
+		}
+		format, err := ParseImageFormat(formatValue)
+		if err != nil {
+			return nil, err
+		}
+		exts := []string{ext}
+		if slices.Contains(jpegExtensions, ext) {
+			exts = jpegExtensions
+		}
+		for _, ext := range exts {
+			formats[ext] = format
+		}
+	}
+	return formats, nil
+}
+
+// ImageCodec encodes images into one output format
+type ImageCodec interface {
+	// Extension is the extension of the files the codec writes, such as ".webp"
+	Extension() string
+	// Reads reports whether the codec can encode the image at path, by its extension
+	Reads(path string) bool
+	// Encode encodes the image at path and returns the path of the result: path itself for
+	// images encoded in their own format, or path with its extension replaced by Extension for
+	// those converted, which leave path as it is. Cancelling ctx kills the encoder.
+	Encode(ctx context.Context, path string, opts CompressOptions) (string, error)
+}
+
+// ImageCodecs is a registry of the codec writing each output format
+type ImageCodecs map[ImageFormat]ImageCodec
+
+// EncoderPaths holds the paths of the encoders the codecs run, each looked up on the PATH
+// when empty
+type EncoderPaths struct {
+	// Jpegoptim is the path of jpegoptim, compressing JPEGs
+	Jpegoptim string
+	// Cwebp is the path of cwebp, converting images to WebP
+	Cwebp string
+	// Avifenc is the path of avifenc, converting images to AVIF
+	Avifenc string
+}
+
+// NewImageCodecs creates the codecs of every output format, running the encoders at paths
+func NewImageCodecs(paths EncoderPaths) ImageCodecs {
+	jpegoptim := NewImageCompressorWithPath(paths.Jpegoptim)
+	return ImageCodecs{
+		ImageFormatJPEG:         NewJPEGCodec(jpegoptim, false),
+		ImageFormatJPEGLossless: NewJPEGCodec(jpegoptim, true),
+		ImageFormatWebP: &encoderCodec{
+			name:      "cwebp",
+			path:      paths.Cwebp,
+			extension: ".webp",
+			reads:     []string{".jpg", ".jpeg", ".png", ".tif", ".tiff", ".webp"},
+			args:      cwebpArgs,
+		},
+		ImageFormatAVIF: &encoderCodec{
+			name:      "avifenc",
+			path:      paths.Avifenc,
+			extension: ".avif",
+			reads:     []string{".jpg", ".jpeg", ".png", ".y4m"},
+			args:      avifencArgs,
+		},
+	}
+}
+
+// jpegCodec implements the ImageCodec interface by compressing JPEGs in place
+type jpegCodec struct {
+	compressor ImageCompressor
+	lossless   bool
+}
+
+// NewJPEGCodec creates an ImageCodec compressing JPEGs in place with compressor, only
+// optimising them without losing quality if lossless is set
+func NewJPEGCodec(compressor ImageCompressor, lossless bool) ImageCodec {
+	return &jpegCodec{
+		compressor: compressor,
+		lossless:   lossless,
+	}
+}
+
+// Extension returns ".jpg"
+func (c *jpegCodec) Extension() string {
+	return ".jpg"
+}
+
+// Reads reports whether path is a JPEG
+func (c *jpegCodec) Reads(path string) bool {
+	return slices.Contains(jpegExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Encode compresses the JPEG at path in place
+func (c *jpegCodec) Encode(ctx context.Context, path string, opts CompressOptions) (string, error) {
+	opts.Lossless = c.lossless
+	return path, c.compressor.CompressFile(ctx, path, opts)
+}
+
+// encoderCodec implements the ImageCodec interface by converting images with an external encoder
+type encoderCodec struct {
+	// name is the name of the encoder binary, run from the PATH when path is empty
+	name      string
+	path      string
+	extension string
+	// reads are the extensions of the images the encoder reads
+	reads []string
+	// args builds the encoder arguments for encoding srcPath into destPath
+	args func(srcPath, destPath string, opts CompressOptions) []string
+}
+
+// Extension returns the extension of the format the encoder writes
+func (c *encoderCodec) Extension() string {
+	return c.extension
+}
+
+// Reads reports whether the encoder reads images with the extension of path
+func (c *encoderCodec) Reads(path string) bool {
+	return slices.Contains(c.reads, strings.ToLower(filepath.Ext(path)))
+}
+
+// Encode converts the image at path into a new file next to it, named after it with the
+// extension of the format, and with the same modification time. An image already in the format
+// is encoded again in its place. Encoding fails if the name of the new file is taken, e.g. by
+// the conversion of another type of image with the same name.
+func (c *encoderCodec) Encode(ctx context.Context, path string, opts CompressOptions) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("file does not exist: %w", err)
+	}
+
+	encoder := c.path
+	if encoder == "" {
+		encoder = c.name // Use system PATH
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	inPlace := strings.EqualFold(filepath.Ext(path), c.extension)
+	destPath := base + c.extension
+	if inPlace {
+		destPath = base + encodingInfix + c.extension
+	}
+	// Claim the name before encoding, so concurrent conversions can't write the same file
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, libraryFileMode)
+	if err != nil {
+		return "", fmt.Errorf("%s can't convert %s: %w", c.name, path, err)
+	}
+	out.Close()
+
+	cmd := exec.CommandContext(ctx, encoder, c.args(path, destPath, opts)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(destPath)
+		return "", fmt.Errorf("%s failed for %s: %w, output: %s", c.name, path, err, output)
+	}
+	// The organiser dates images without metadata by their modification time
+	if err := os.Chtimes(destPath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(destPath)
+		return "", err
+	}
+	if inPlace {
+		if err := os.Rename(destPath, path); err != nil {
+			os.Remove(destPath)
+			return "", err
+		}
+		return path, nil
+	}
+	return destPath, nil
+}
+
+// cwebpArgs builds the cwebp arguments for converting srcPath to WebP. A target size is passed
+// as is, since cwebp searches for the quality that fits it on its own.
+func cwebpArgs(srcPath, destPath string, opts CompressOptions) []string {
+	args := []string{"-quiet", "-metadata", "all"}
+	if opts.TargetSize > 0 {
+		args = append(args, "-size", strconv.FormatInt(opts.TargetSize, 10))
+	} else {
+		args = append(args, "-q", strconv.Itoa(opts.Quality))
+	}
+	return append(args, srcPath, "-o", destPath)
+}
+
+// avifencArgs builds the avifenc arguments for converting srcPath to AVIF. avifenc can't aim
+// for a size, so images are always encoded to the quality.
+func avifencArgs(srcPath, destPath string, opts CompressOptions) []string {
+	return []string{"-q", strconv.Itoa(opts.Quality), srcPath, destPath}
+}
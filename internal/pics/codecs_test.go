@@ -0,0 +1,310 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// writeFakeEncoder writes a shell script standing in for an image encoder, which writes output
+// to the file given last and exits with status
+func writeFakeEncoder(t *testing.T, output string, status int) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available, skipping test")
+	}
+	path := filepath.Join(t.TempDir(), "encoder")
+	script := "#!/bin/sh\nfor last; do :; done\nprintf '" + output + "' > \"$last\"\nexit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake encoder: %v", err)
+	}
+	return path
+}
+
+// copyingExifWriter stands in for an ExifWriter, recording the original names, the dimensions
+// written and the metadata copies, and failing the copies with err
+type copyingExifWriter struct {
+	ExifWriter
+	originalNames []string
+	dimensions    [][2]int
+	copies        [][2]string
+	err           error
+}
+
+func (w *copyingExifWriter) WriteImportMetadata(ctx context.Context, filePath string, metadata ImportMetadata) (bool, error) {
+	w.originalNames = append(w.originalNames, metadata.OriginalFileName)
+	return true, nil
+}
+
+func (w *copyingExifWriter) WriteDimensions(ctx context.Context, filePath string, width, height int) (bool, error) {
+	w.dimensions = append(w.dimensions, [2]int{width, height})
+	return true, nil
+}
+
+func (w *copyingExifWriter) CopyMetadata(ctx context.Context, srcPath, dstPath string, stripGPS bool) error {
+	w.copies = append(w.copies, [2]string{srcPath, dstPath})
+	return w.err
+}
+
+func TestParseImageFormat(t *testing.T) {
+	for value, expected := range map[string]ImageFormat{
+		"jpeg":          ImageFormatJPEG,
+		"jpeg-lossless": ImageFormatJPEGLossless,
+		"WebP":          ImageFormatWebP,
+		" avif ":        ImageFormatAVIF,
+	} {
+		if format, err := ParseImageFormat(value); err != nil || format != expected {
+			t.Errorf("ParseImageFormat(%q) = %q, %v, expected %q", value, format, err, expected)
+		}
+	}
+	for _, value := range []string{"", "jpg", "heic"} {
+		if _, err := ParseImageFormat(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseOutputFormats(t *testing.T) {
+	formats, err := ParseOutputFormats([]string{"webp", "PNG=avif", ".tif=webp"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := map[string]ImageFormat{
+		".jpg":  ImageFormatWebP,
+		".jpeg": ImageFormatWebP,
+		".png":  ImageFormatAVIF,
+		".tif":  ImageFormatWebP,
+	}
+	if !reflect.DeepEqual(formats, expected) {
+		t.Errorf("Expected %v, got %v", expected, formats)
+	}
+
+	// A format given for either JPEG extension applies to both
+	formats, err = ParseOutputFormats([]string{"jpeg=jpeg-lossless"})
+	if err != nil || formats[".jpg"] != ImageFormatJPEGLossless {
+		t.Errorf("Expected .jpg optimised losslessly, got %v (%v)", formats, err)
+	}
+
+	for _, values := range [][]string{{"gif"}, {"png="}, {"=webp"}, {"tar.gz=webp"}} {
+		if _, err := ParseOutputFormats(values); err == nil {
+			t.Errorf("Expected an error for %v", values)
+		}
+	}
+}
+
+func TestEncoderArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     func(srcPath, destPath string, opts CompressOptions) []string
+		opts     CompressOptions
+		expected []string
+	}{
+		{
+			name:     "cwebp quality",
+			args:     cwebpArgs,
+			opts:     CompressOptions{Quality: 75},
+			expected: []string{"-quiet", "-metadata", "all", "-q", "75", "photo.jpg", "-o", "photo.jpg.webp"},
+		},
+		{
+			name:     "cwebp target size",
+			args:     cwebpArgs,
+			opts:     CompressOptions{Quality: 75, TargetSize: 1500},
+			expected: []string{"-quiet", "-metadata", "all", "-size", "1500", "photo.jpg", "-o", "photo.jpg.webp"},
+		},
+		{
+			name:     "avifenc ignores target size",
+			args:     avifencArgs,
+			opts:     CompressOptions{Quality: 60, TargetSize: 1500},
+			expected: []string{"-q", "60", "photo.jpg", "photo.jpg.webp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if args := tt.args("photo.jpg", "photo.jpg.webp", tt.opts); !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestNewImageCodecs(t *testing.T) {
+	codecs := NewImageCodecs(EncoderPaths{})
+	tests := []struct {
+		format    ImageFormat
+		extension string
+		reads     string
+		notReads  string
+	}{
+		{ImageFormatJPEG, ".jpg", "IMG_0001.JPEG", "photo.png"},
+		{ImageFormatJPEGLossless, ".jpg", "IMG_0001.jpg", "photo.png"},
+		{ImageFormatWebP, ".webp", "photo.PNG", "IMG_0001.heic"},
+		{ImageFormatAVIF, ".avif", "IMG_0001.jpg", "photo.gif"},
+	}
+	for _, tt := range tests {
+		codec, ok := codecs[tt.format]
+		if !ok {
+			t.Fatalf("Expected a codec for %s", tt.format)
+		}
+		if codec.Extension() != tt.extension {
+			t.Errorf("Expected %s written as %s, got %s", tt.format, tt.extension, codec.Extension())
+		}
+		if !codec.Reads(tt.reads) || codec.Reads(tt.notReads) {
+			t.Errorf("Expected %s to read %s but not %s", tt.format, tt.reads, tt.notReads)
+		}
+	}
+}
+
+func TestEncoderCodec_Encode(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	src := createMediaFile(t, tmpDir, "photo.jpg", modTime)
+
+	codec := NewImageCodecs(EncoderPaths{Cwebp: writeFakeEncoder(t, "webp", 0)})[ImageFormatWebP]
+	encoded, err := codec.Encode(context.Background(), src, CompressOptions{Quality: 75})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if expected := filepath.Join(tmpDir, "photo.webp"); encoded != expected {
+		t.Errorf("Expected the image converted next to the original as %s, got %s", expected, encoded)
+	}
+	info, err := os.Stat(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected the modification time kept, got %v", info.ModTime())
+	}
+	if data, err := os.ReadFile(src); err != nil || string(data) != "test media content" {
+		t.Errorf("Expected the original left as it is, got %q (%v)", data, err)
+	}
+
+	// The name of the conversion is taken, e.g. by that of a PNG with the same name
+	if _, err := codec.Encode(context.Background(), src, CompressOptions{Quality: 75}); err == nil {
+		t.Error("Expected an error for a conversion whose name is taken")
+	}
+	if data, err := os.ReadFile(encoded); err != nil || string(data) != "webp" {
+		t.Errorf("Expected the first conversion kept, got %q (%v)", data, err)
+	}
+
+	// An image in the format is encoded again in its place
+	webp := createMediaFile(t, tmpDir, "sticker.webp", modTime)
+	codec = NewImageCodecs(EncoderPaths{Cwebp: writeFakeEncoder(t, "smaller", 0)})[ImageFormatWebP]
+	if encoded, err := codec.Encode(context.Background(), webp, CompressOptions{Quality: 75}); err != nil || encoded != webp {
+		t.Errorf("Expected %s encoded in place, got %s (%v)", webp, encoded, err)
+	}
+	if data, err := os.ReadFile(webp); err != nil || string(data) != "smaller" {
+		t.Errorf("Expected the image replaced by its encoding, got %q (%v)", data, err)
+	}
+
+	// A failed encoder leaves nothing behind
+	codec = NewImageCodecs(EncoderPaths{Avifenc: writeFakeEncoder(t, "partial", 1)})[ImageFormatAVIF]
+	if _, err := codec.Encode(context.Background(), src, CompressOptions{Quality: 75}); err == nil {
+		t.Error("Expected an error from the failed encoder")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "photo.avif")); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial output removed, got %v", err)
+	}
+}
+
+func TestProcessFile_ConvertsImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		copyErr   error
+		converted bool
+	}{
+		{name: "converted", output: "webp", converted: true},
+		{name: "conversion grows the file", output: "a WebP larger than the original", converted: false},
+		{name: "metadata not copied", output: "webp", copyErr: errors.New("exiftool failed"), converted: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+			dest := filepath.Join(tmpDir, "copy.jpg")
+			converted := filepath.Join(tmpDir, "copy.webp")
+			exifWriter := &copyingExifWriter{err: tt.copyErr}
+			parser := &mediaParser{
+				codecs:     NewImageCodecs(EncoderPaths{Cwebp: writeFakeEncoder(t, tt.output, 0)}),
+				extensions: NewExtensions(),
+				exifWriter: exifWriter,
+			}
+
+			opts := testParseOptions
+			opts.CompressJPEGs = true
+			opts.OutputFormats = map[string]ImageFormat{".jpg": ImageFormatWebP}
+			file := discoveredFile(t, src, dest)
+			file.isJPEG = true
+			if err := parser.processFile(context.Background(), &file, opts, logger.With("file", src), nil); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			// The original name has the extension of the format the image is in
+			originalName := "IMG_0001.jpg"
+			if tt.converted {
+				originalName = "IMG_0001.webp"
+			}
+			if expected := []string{originalName}; !reflect.DeepEqual(exifWriter.originalNames, expected) {
+				t.Errorf("Expected original name %v, got %v", expected, exifWriter.originalNames)
+			}
+
+			if !tt.converted {
+				if file.destPath != dest {
+					t.Errorf("Expected the copy kept, got %s", file.destPath)
+				}
+				if _, err := os.Stat(converted); !os.IsNotExist(err) {
+					t.Errorf("Expected the conversion removed, got %v", err)
+				}
+				return
+			}
+			if file.destPath != converted {
+				t.Errorf("Expected the file pointed at its conversion, got %s", file.destPath)
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Errorf("Expected the JPEG copy replaced, got %v", err)
+			}
+			if expected := [][2]string{{dest, converted}}; !reflect.DeepEqual(exifWriter.copies, expected) {
+				t.Errorf("Expected metadata copied %v, got %v", expected, exifWriter.copies)
+			}
+		})
+	}
+}
+
+func TestValidateOutputFormats(t *testing.T) {
+	parser := &mediaParser{
+		codecs:     NewImageCodecs(EncoderPaths{}),
+		extensions: NewExtensionsWithConfig([]string{".jpg", ".jpeg", ".heic", ".png", ".webp"}, DefaultVideoExtensions()),
+	}
+	tests := []struct {
+		name    string
+		formats map[string]ImageFormat
+		wantErr bool
+	}{
+		{name: "none", formats: nil},
+		{name: "supported", formats: map[string]ImageFormat{".jpg": ImageFormatWebP, ".png": ImageFormatWebP}},
+		{name: "codec can't read the images", formats: map[string]ImageFormat{".heic": ImageFormatWebP}, wantErr: true},
+		{name: "jpegoptim only reads JPEGs", formats: map[string]ImageFormat{".png": ImageFormatJPEGLossless}, wantErr: true},
+		{name: "unsupported result", formats: map[string]ImageFormat{".jpg": ImageFormatAVIF}, wantErr: true},
+		{name: "unknown format", formats: map[string]ImageFormat{".jpg": "jxl"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testParseOptions
+			opts.OutputFormats = tt.formats
+			if err := parser.validateOutputFormats(opts); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -1,15 +1,78 @@
 package pics
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"image/jpeg"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// jpegQualityPresets are named JPEG qualities for users who don't know what suits their use
+var jpegQualityPresets = map[string]int{
+	// archive keeps images close to the original for long-term storage
+	"archive": 90,
+	// web is for images shown on websites and galleries
+	"web": 75,
+	// share keeps images small enough for messaging and email
+	"share": 60,
+}
+
+// ValidateJPEGQuality checks that quality is a JPEG quality between 0 and 100
+func ValidateJPEGQuality(quality int) error {
+	if quality < 0 || quality > 100 {
+		return fmt.Errorf("invalid JPEG quality %d (expected 0-100)", quality)
+	}
+	return nil
+}
+
+// ParseJPEGQuality parses a JPEG quality between 0 and 100, or the name of a preset:
+// "archive" (90), "web" (75) or "share" (60)
+func ParseJPEGQuality(s string) (int, error) {
+	if quality, ok := jpegQualityPresets[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return quality, nil
+	}
+	quality, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid JPEG quality %q (expected 0-100 or one of %s)", s, strings.Join(JPEGQualityPresets(), ", "))
+	}
+	return quality, ValidateJPEGQuality(quality)
+}
+
+// JPEGQualityPresets returns the names of the JPEG quality presets, from highest quality to lowest
+func JPEGQualityPresets() []string {
+	names := make([]string, 0, len(jpegQualityPresets))
+	for name := range jpegQualityPresets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return jpegQualityPresets[names[i]] > jpegQualityPresets[names[j]] })
+	return names
+}
+
 // ImageCompressor defines the interface for compressing images
 type ImageCompressor interface {
-	// CompressFile compresses a single JPEG file
-	CompressFile(path string, quality int) error
+	// CompressFile compresses a single JPEG file. Cancelling ctx kills the compressor.
+	CompressFile(ctx context.Context, path string, opts CompressOptions) error
+}
+
+// CompressOptions holds settings for compressing a single image.
+type CompressOptions struct {
+	// Quality is the maximum JPEG quality (0-100). Ignored when TargetSize is set.
+	Quality int
+	// TargetSize is the size budget per image in bytes (0 = compress to Quality instead).
+	TargetSize int64
+	// Progressive makes the compressed file a progressive JPEG.
+	Progressive bool
+	// Lossless only optimises the encoding of the JPEG, keeping its quality. Quality and
+	// TargetSize are ignored.
+	Lossless bool
 }
 
 // jpegCompressor implements the ImageCompressor interface
@@ -30,7 +93,7 @@ func NewImageCompressorWithPath(jpegoptimPath string) ImageCompressor {
 }
 
 // CompressFile compresses a single JPEG file using jpegoptim (preserves EXIF)
-func (c *jpegCompressor) CompressFile(path string, quality int) error {
+func (c *jpegCompressor) CompressFile(ctx context.Context, path string, opts CompressOptions) error {
 	// Check if file exists first
 	if _, err := os.Stat(path); err != nil {
 		return fmt.Errorf("file does not exist: %w", err)
@@ -42,11 +105,164 @@ func (c *jpegCompressor) CompressFile(path string, quality int) error {
 		jpegoptim = "jpegoptim" // Use system PATH
 	}
 
-	// jpegoptim preserves EXIF data and file modification time by default with -p flag
-	cmd := exec.Command(jpegoptim, fmt.Sprintf("-m%d", quality), "-p", path)
+	cmd := exec.CommandContext(ctx, jpegoptim, jpegoptimArgs(path, opts)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("jpegoptim failed for %s: %w, output: %s", path, err, output)
 	}
 	return nil
 }
+
+// jpegoptimArgs builds the jpegoptim arguments for compressing path.
+// With a target size, jpegoptim searches for the highest quality that fits the budget
+// on its own, so the fixed quality is not passed; without either, it only optimises the
+// Huffman tables losslessly. The -p flag preserves the file modification time; EXIF data is
+// kept by default.
+func jpegoptimArgs(path string, opts CompressOptions) []string {
+	var args []string
+	switch {
+	case opts.Lossless:
+		// Neither a quality nor a size: jpegoptim optimises without re-encoding
+	case opts.TargetSize > 0:
+		// jpegoptim takes the size in kilobytes
+		kilobytes := (opts.TargetSize + 1023) / 1024
+		args = append(args, fmt.Sprintf("--size=%d", kilobytes))
+	default:
+		args = append(args, fmt.Sprintf("-m%d", opts.Quality))
+	}
+	if opts.Progressive {
+		args = append(args, "--all-progressive")
+	}
+	return append(args, "-p", path)
+}
+
+// standardLuminanceTable is the luminance quantisation table of the JPEG standard (Annex K), which
+// libjpeg and most cameras scale to the chosen quality
+var standardLuminanceTable = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// errNoQuantisationTable is returned for JPEGs without a luminance quantisation table
+var errNoQuantisationTable = errors.New("no luminance quantisation table")
+
+// jpegBitsPerPixel returns how many bits of the file at path, of size bytes, each pixel of the
+// JPEG takes. Photos already saved compactly have few, and barely shrink when compressed again.
+func jpegBitsPerPixel(path string, size int64) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	config, err := jpeg.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dimensions of %s: %w", path, err)
+	}
+	pixels := int64(config.Width) * int64(config.Height)
+	if pixels == 0 {
+		return 0, fmt.Errorf("%s has no pixels", path)
+	}
+	return float64(size*8) / float64(pixels), nil
+}
+
+// estimateJPEGQuality estimates the quality a JPEG was saved with from its luminance
+// quantisation table, assuming it is the standard table scaled the way libjpeg does.
+// Encoders using their own tables get the closest libjpeg quality.
+func estimateJPEGQuality(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	table, err := readLuminanceTable(bufio.NewReader(file))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read quantisation table of %s: %w", path, err)
+	}
+
+	// libjpeg multiplies the standard table by scale percent: 5000/quality below 50 and
+	// 200-2*quality from 50 up
+	var sum, standard int
+	for i := range table {
+		sum += table[i]
+		standard += standardLuminanceTable[i]
+	}
+	scale := float64(sum) * 100 / float64(standard)
+	var quality float64
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	} else {
+		quality = 5000 / scale
+	}
+	return max(1, min(100, int(quality+0.5))), nil
+}
+
+// readLuminanceTable returns the luminance quantisation table (table 0) of the JPEG read from r,
+// in the order it is stored
+func readLuminanceTable(r *bufio.Reader) ([64]int, error) {
+	var table [64]int
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return table, errors.New("not a JPEG file")
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return table, err
+		}
+		if marker[0] != 0xFF {
+			return table, fmt.Errorf("invalid marker %#x", marker)
+		}
+		// Start of scan and end of image: the tables all come before
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return table, errNoQuantisationTable
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return table, err
+		}
+		if length < 2 {
+			return table, fmt.Errorf("invalid segment length %d", length)
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return table, err
+		}
+		if marker[1] != 0xDB {
+			continue
+		}
+
+		// A DQT segment holds one or more tables, each with 8 or 16 bit values
+		for len(segment) > 0 {
+			precision, id := segment[0]>>4, segment[0]&0x0F
+			size := 64
+			if precision == 1 {
+				size = 128
+			}
+			if len(segment) < 1+size {
+				return table, errors.New("truncated quantisation table")
+			}
+			values := segment[1 : 1+size]
+			segment = segment[1+size:]
+			if id != 0 {
+				continue
+			}
+			for i := range table {
+				if precision == 1 {
+					table[i] = int(binary.BigEndian.Uint16(values[2*i:]))
+				} else {
+					table[i] = int(values[i])
+				}
+			}
+			return table, nil
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package pics
 
 import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -68,7 +74,7 @@ func TestJpegCompressor_CompressFile(t *testing.T) {
 	}
 
 	compressor := NewImageCompressor()
-	err = compressor.CompressFile(testFile, 50)
+	err = compressor.CompressFile(context.Background(), testFile, CompressOptions{Quality: 50})
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -89,15 +95,190 @@ func TestJpegCompressor_CompressFile(t *testing.T) {
 	t.Logf("Original size: %d bytes, Compressed size: %d bytes", originalInfo.Size(), len(data))
 }
 
+func TestJpegCompressor_CompressFile_KeepsMetadata(t *testing.T) {
+	if _, err := exec.LookPath("jpegoptim"); err != nil {
+		t.Skip("jpegoptim not installed, skipping test")
+	}
+
+	// A photo worth re-encoding, with the fields losing which would misplace or rotate it
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := range 64 {
+		for y := range 48 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: uint8(x * y), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	et := createTestExiftool(t)
+	metadata := NewMetadataReader(et)
+
+	for name, opts := range map[string]CompressOptions{
+		"quality":     {Quality: 50},
+		"target size": {TargetSize: 1024},
+		"progressive": {Quality: 50, Progressive: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "photo.jpg")
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			output, err := exec.Command("exiftool", "-overwrite_original", "-n", "-Orientation=6",
+				"-DateTimeOriginal=2023:06:15 10:30:00", "-GPSLatitude=41.3874", "-GPSLatitudeRef=N",
+				"-GPSLongitude=2.1686", "-GPSLongitudeRef=E", path).CombinedOutput()
+			if err != nil {
+				t.Fatalf("Failed to write EXIF: %v (output: %s)", err, output)
+			}
+			before, err := metadata.ReadFields(path, keyMetadataFields)
+			if err != nil || len(before) != len(keyMetadataFields) {
+				t.Fatalf("Expected all key fields written, got %v and %v", before, err)
+			}
+
+			if err := NewImageCompressor().CompressFile(context.Background(), path, opts); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			after, err := metadata.ReadFields(path, keyMetadataFields)
+			if err != nil {
+				t.Fatalf("Failed to read EXIF after compression: %v", err)
+			}
+			if lost := lostMetadataFields(keyMetadataFields, before, after); len(lost) > 0 {
+				t.Errorf("Expected EXIF kept, lost %v (before %v, after %v)", lost, before, after)
+			}
+		})
+	}
+}
+
 func TestJpegCompressor_CompressFile_NonexistentFile(t *testing.T) {
 	if _, err := exec.LookPath("jpegoptim"); err != nil {
 		t.Skip("jpegoptim not installed, skipping test")
 	}
 
 	compressor := NewImageCompressor()
-	err := compressor.CompressFile("/nonexistent/file.jpg", 50)
+	err := compressor.CompressFile(context.Background(), "/nonexistent/file.jpg", CompressOptions{Quality: 50})
 
 	if err == nil {
 		t.Error("Expected error for nonexistent file, got nil")
 	}
 }
+
+func TestJpegoptimArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     CompressOptions
+		expected []string
+	}{
+		{
+			name:     "fixed quality",
+			opts:     CompressOptions{Quality: 50},
+			expected: []string{"-m50", "-p", "photo.jpg"},
+		},
+		{
+			name:     "target size replaces quality",
+			opts:     CompressOptions{Quality: 50, TargetSize: 1536 * 1024},
+			expected: []string{"--size=1536", "-p", "photo.jpg"},
+		},
+		{
+			name:     "target size rounds up to whole kilobytes",
+			opts:     CompressOptions{TargetSize: 1500},
+			expected: []string{"--size=2", "-p", "photo.jpg"},
+		},
+		{
+			name:     "progressive",
+			opts:     CompressOptions{Quality: 80, Progressive: true},
+			expected: []string{"-m80", "--all-progressive", "-p", "photo.jpg"},
+		},
+		{
+			name:     "lossless ignores quality and target size",
+			opts:     CompressOptions{Quality: 50, TargetSize: 1500, Lossless: true},
+			expected: []string{"-p", "photo.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if args := jpegoptimArgs("photo.jpg", tt.opts); !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestParseJPEGQuality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+		wantErr  bool
+	}{
+		{input: "50", expected: 50},
+		{input: "0", expected: 0},
+		{input: "100", expected: 100},
+		{input: "archive", expected: 90},
+		{input: "Web", expected: 75},
+		{input: "share", expected: 60},
+		{input: "101", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "best", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseJPEGQuality(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %d", got)
+				}
+				return
+			}
+			if err != nil || got != tt.expected {
+				t.Errorf("Expected %d, got %d (%v)", tt.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestJPEGQualityPresets(t *testing.T) {
+	expected := []string{"archive", "web", "share"}
+	if got := JPEGQualityPresets(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := range 16 {
+		for y := range 16 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+
+	for _, quality := range []int{20, 50, 75, 90, 100} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "image.jpg")
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := estimateJPEGQuality(path)
+		if err != nil {
+			t.Fatalf("estimateJPEGQuality failed: %v", err)
+		}
+		if got < quality-1 || got > quality+1 {
+			t.Errorf("Expected about %d, got %d", quality, got)
+		}
+	}
+}
+
+func TestEstimateJPEGQuality_NotJPEG(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(path, []byte("not a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := estimateJPEGQuality(path); err == nil {
+		t.Error("Expected an error for a file that isn't a JPEG")
+	}
+}
@@ -4,18 +4,53 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/acm19/pics/internal/logger"
 	"github.com/barasher/go-exiftool"
 )
 
+// offsetFields are the metadata fields holding the UTC offset of the clock a file was taken by,
+// in order of preference
+var offsetFields = []string{"OffsetTimeOriginal", "OffsetTimeDigitized", "OffsetTime", "TimeZone"}
+
 // fileDateExtractor defines the interface for extracting file dates
 type fileDateExtractor interface {
-	getFileDate(filePath string) (time.Time, error)
+	getFileDate(filePath string) (captureTime, error)
 	name() string
 }
 
+// batchDateExtractor is a fileDateExtractor that reads the dates of several files at once,
+// returning a date or an error for each
+type batchDateExtractor interface {
+	getFileDates(filePaths []string) ([]captureTime, []error)
+}
+
+// captureTime is the time a file was taken, as the file records it
+type captureTime struct {
+	// time is the capture time, in the offset the file records, or in UTC for a wall clock
+	// without one
+	time time.Time
+	// wallClock is set when the file records no offset, so time is the clock of wherever the
+	// file was taken rather than a known instant
+	wallClock bool
+}
+
+// in returns the capture time in zone: known instants are converted to it and wall clocks are
+// taken to be in it. Without a zone, instants keep the offset they were recorded in.
+func (c captureTime) in(zone *time.Location) time.Time {
+	switch {
+	case zone == nil:
+		return c.time
+	case c.wallClock:
+		t := c.time
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone)
+	default:
+		return c.time.In(zone)
+	}
+}
+
 // modTimeExtractor extracts date from file modification time
 type modTimeExtractor struct{}
 
@@ -27,13 +62,14 @@ func (e *modTimeExtractor) name() string {
 	return "ModTime"
 }
 
-func (e *modTimeExtractor) getFileDate(filePath string) (time.Time, error) {
+// getFileDate returns the modification time in the system timezone
+func (e *modTimeExtractor) getFileDate(filePath string) (captureTime, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return time.Time{}, err
+		return captureTime{}, err
 	}
 	logger.Debug("Using file modification time", "file", filepath.Base(filePath), "modTime", info.ModTime())
-	return info.ModTime(), nil
+	return captureTime{time: info.ModTime()}, nil
 }
 
 // exifDateExtractor extracts date from EXIF metadata
@@ -51,39 +87,154 @@ func (e *exifDateExtractor) name() string {
 	return "EXIF"
 }
 
-func (e *exifDateExtractor) getFileDate(filePath string) (time.Time, error) {
+// exifDateFields are the date fields the EXIF extractor tries, in order of preference
+var exifDateFields = []string{"CreationDate", "CreateDate"}
+
+func (e *exifDateExtractor) getFileDate(filePath string) (captureTime, error) {
+	// QuickTime records CreateDate in UTC, while other formats record the wall clock of the camera
+	return readExifDate(e.et, filePath, exifDateFields, isQuickTime(filePath))
+}
+
+// getFileDates reads the metadata of all the files in a single request to exiftool
+func (e *exifDateExtractor) getFileDates(filePaths []string) ([]captureTime, []error) {
+	dates := make([]captureTime, len(filePaths))
+	errs := make([]error, len(filePaths))
 	if e.et == nil {
-		return time.Time{}, fmt.Errorf("exiftool not initialised")
+		for i := range errs {
+			errs[i] = fmt.Errorf("exiftool not initialised")
+		}
+		return dates, errs
+	}
+	for i, fileInfo := range e.et.ExtractMetadata(filePaths...) {
+		dates[i], errs[i] = exifDateOf(fileInfo, exifDateFields, isQuickTime(filePaths[i]))
+	}
+	return dates, errs
+}
+
+// isQuickTime reports whether a file is a QuickTime or MPEG-4 video, whose dates without an
+// offset are in UTC
+func isQuickTime(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mov", ".mp4", ".m4v", ".3gp":
+		return true
+	}
+	return false
+}
+
+// readExifDate reads the first of the date fields a file has in its EXIF metadata, with the
+// offset of the first of offsetFields it has. Dates without an offset are in UTC if utc is set,
+// and wall clocks otherwise.
+func readExifDate(et *exiftool.Exiftool, filePath string, dateFields []string, utc bool) (captureTime, error) {
+	if et == nil {
+		return captureTime{}, fmt.Errorf("exiftool not initialised")
 	}
 
-	fileInfos := e.et.ExtractMetadata(filePath)
+	fileInfos := et.ExtractMetadata(filePath)
 	if len(fileInfos) == 0 {
-		return time.Time{}, fmt.Errorf("no metadata found")
+		return captureTime{}, fmt.Errorf("no metadata found")
 	}
+	return exifDateOf(fileInfos[0], dateFields, utc)
+}
 
-	fileInfo := fileInfos[0]
+// exifDateOf reads the first of the date fields in the metadata exiftool extracted from a file,
+// as readExifDate does
+func exifDateOf(fileInfo exiftool.FileMetadata, dateFields []string, utc bool) (captureTime, error) {
+	filePath := fileInfo.File
 	if fileInfo.Err != nil {
-		return time.Time{}, fileInfo.Err
+		return captureTime{}, fileInfo.Err
 	}
 
-	// Try date fields in order of preference: CreationDate first, then CreateDate
-	dateFields := []string{"CreationDate", "CreateDate"}
 	for _, field := range dateFields {
 		if val, err := fileInfo.GetString(field); err == nil {
-			logger.Debug("Using EXIF date field", "file", filepath.Base(filePath), "field", field, "date", val)
+			var offset string
+			for _, offsetField := range offsetFields {
+				if offset, err = fileInfo.GetString(offsetField); err == nil {
+					break
+				}
+			}
+			logger.Debug("Using EXIF date field", "file", filepath.Base(filePath), "field", field, "date", val, "offset", offset)
 
-			// Parse the EXIF date string (format: "2006:01:02 15:04:05")
-			parsedTime, err := time.Parse("2006:01:02 15:04:05", val)
+			date, err := parseExifDate(val, offset, utc)
 			if err != nil {
 				logger.Debug("Failed to parse EXIF date", "file", filePath, "date", val, "error", err)
-				return time.Time{}, err
+				return captureTime{}, err
 			}
-			return parsedTime, nil
+			return date, nil
 		}
 	}
 
 	// No valid EXIF date found
-	return time.Time{}, fmt.Errorf("no EXIF date field found")
+	return captureTime{}, fmt.Errorf("no EXIF date field found")
+}
+
+// parseExifDate parses an EXIF date ("2006:01:02 15:04:05", optionally with fractional seconds
+// and an offset such as "+02:00"). A date without an offset takes offset if it is set, such as
+// the value of OffsetTimeOriginal, is in UTC if utc is set, and is a wall clock otherwise.
+func parseExifDate(value, offset string, utc bool) (captureTime, error) {
+	for _, layout := range []string{"2006:01:02 15:04:05Z07:00", "2006:01:02 15:04:05-0700"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return captureTime{time: date}, nil
+		}
+	}
+	date, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return captureTime{}, err
+	}
+	if offset != "" {
+		zone, err := parseUTCOffset(offset)
+		if err == nil {
+			return captureTime{time: time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), zone)}, nil
+		}
+		logger.Debug("Ignoring invalid UTC offset", "offset", offset, "error", err)
+	}
+	if utc {
+		return captureTime{time: date.In(time.Local)}, nil
+	}
+	return captureTime{time: date, wallClock: true}, nil
+}
+
+// sidecarDateExtractor extracts the date of videos from the sidecar files camcorders write
+// next to them, looking next to the video and in the sidecar directory where the parser
+// copies them
+type sidecarDateExtractor struct {
+	et         *exiftool.Exiftool
+	extensions Extensions
+}
+
+func newSidecarDateExtractor(et *exiftool.Exiftool) *sidecarDateExtractor {
+	return &sidecarDateExtractor{
+		et:         et,
+		extensions: NewExtensions(),
+	}
+}
+
+func (e *sidecarDateExtractor) name() string {
+	return "Sidecar"
+}
+
+func (e *sidecarDateExtractor) getFileDate(filePath string) (captureTime, error) {
+	if !e.extensions.IsVideo(filePath) {
+		return captureTime{}, fmt.Errorf("not a video")
+	}
+
+	sidecars := findSidecars(filePath)
+	sidecars = append(sidecars, findSidecars(filepath.Join(filepath.Dir(filePath), sidecarDirName, filepath.Base(filePath)))...)
+	for _, sidecar := range sidecars {
+		var date captureTime
+		var err error
+		if strings.EqualFold(filepath.Ext(sidecar), ".xml") {
+			date.time, err = readSidecarXMLDate(sidecar)
+		} else {
+			date, err = readExifDate(e.et, sidecar, []string{"DateTimeOriginal", "CreateDate"}, false)
+		}
+		if err != nil {
+			logger.Debug("Failed to read sidecar date", "file", filepath.Base(filePath), "sidecar", sidecar, "error", err)
+			continue
+		}
+		logger.Debug("Using sidecar date", "file", filepath.Base(filePath), "sidecar", filepath.Base(sidecar), "date", date.time)
+		return date, nil
+	}
+	return captureTime{}, fmt.Errorf("no sidecar date found")
 }
 
 // AggregatedFileDateExtractor iterates through multiple extractors until one succeeds
@@ -91,7 +242,7 @@ type AggregatedFileDateExtractor struct {
 	extractors []fileDateExtractor
 }
 
-// NewFileDateExtractor creates a new AggregatedFileDateExtractor with EXIF and ModTime extractors
+// NewFileDateExtractor creates a new AggregatedFileDateExtractor with EXIF, Sidecar and ModTime extractors
 //
 // Prioritises extracting the dates from the EXIF metadata in the following
 // order:
@@ -99,11 +250,14 @@ type AggregatedFileDateExtractor struct {
 //   - CreationDate: because modified iPhone videos keep the original date in
 //     this field.
 //   - CreateDate: holds the date when the image/video was created.
+//   - Sidecar: the THM, MOI or XML file camcorders write the date of a video to
+//     when its container has none, such as AVCHD and older footage.
 //   - ModTime: if nothing else works falls back to modification time.
 func NewFileDateExtractor(et *exiftool.Exiftool) *AggregatedFileDateExtractor {
 	return &AggregatedFileDateExtractor{
 		extractors: []fileDateExtractor{
 			newExifDateExtractor(et),
+			newSidecarDateExtractor(et),
 			newModTimeExtractor(),
 		},
 	}
@@ -112,15 +266,70 @@ func NewFileDateExtractor(et *exiftool.Exiftool) *AggregatedFileDateExtractor {
 // GetFileDate extracts the creation date by trying each extractor in order
 // Works for both images (JPG, HEIC) and videos (MOV)
 func (e *AggregatedFileDateExtractor) GetFileDate(filePath string) (time.Time, error) {
+	return e.GetFileDateIn(filePath, nil)
+}
+
+// GetFileDateIn extracts the creation date as GetFileDate does, in zone: dates the file records
+// with an offset, or in UTC, are converted to it, and those recorded as the wall clock of the
+// camera are taken to be in it. Without a zone, dates keep the offset they were recorded in,
+// UTC dates and modification times are in the system timezone, and wall clocks are in UTC.
+func (e *AggregatedFileDateExtractor) GetFileDateIn(filePath string, zone *time.Location) (time.Time, error) {
+	dates, err := e.GetFileDatesIn([]string{filePath}, zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return dates[0], nil
+}
+
+// GetFileDatesIn extracts the creation dates of several files as GetFileDateIn does. Each
+// extractor is given the files the previous ones had no date for, and reads the EXIF metadata
+// of all of them in a single request to exiftool.
+func (e *AggregatedFileDateExtractor) GetFileDatesIn(filePaths []string, zone *time.Location) ([]time.Time, error) {
+	dates := make([]time.Time, len(filePaths))
+	pending := make([]int, len(filePaths))
+	for i := range pending {
+		pending[i] = i
+	}
+
 	for _, extractor := range e.extractors {
-		date, err := extractor.getFileDate(filePath)
-		if err == nil && !date.IsZero() {
-			return date, nil
+		if len(pending) == 0 {
+			break
 		}
-		if err != nil {
-			logger.Debug("Extractor failed, trying next", "extractor", extractor.name(), "file", filepath.Base(filePath), "error", err)
+		paths := make([]string, len(pending))
+		for j, i := range pending {
+			paths[j] = filePaths[i]
+		}
+		found, errs := extractDates(extractor, paths)
+
+		var left []int
+		for j, i := range pending {
+			if errs[j] == nil && !found[j].time.IsZero() {
+				dates[i] = found[j].in(zone)
+				continue
+			}
+			if errs[j] != nil {
+				logger.Debug("Extractor failed, trying next", "extractor", extractor.name(), "file", filepath.Base(paths[j]), "error", errs[j])
+			}
+			left = append(left, i)
 		}
+		pending = left
+	}
+
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("all extractors failed for file: %s", filePaths[pending[0]])
 	}
+	return dates, nil
+}
 
-	return time.Time{}, fmt.Errorf("all extractors failed for file: %s", filePath)
+// extractDates reads the dates of files with extractor, at once if it supports it
+func extractDates(extractor fileDateExtractor, filePaths []string) ([]captureTime, []error) {
+	if batch, ok := extractor.(batchDateExtractor); ok {
+		return batch.getFileDates(filePaths)
+	}
+	dates := make([]captureTime, len(filePaths))
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		dates[i], errs[i] = extractor.getFileDate(filePath)
+	}
+	return dates, errs
 }
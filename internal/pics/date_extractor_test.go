@@ -3,6 +3,7 @@ package pics
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -45,7 +46,7 @@ func TestModTimeExtractor_GetFileDate(t *testing.T) {
 	}
 
 	// Verify the modification time is correct
-	assertTimeEqual(t, testTime, result)
+	assertTimeEqual(t, testTime, result.time)
 }
 
 func TestModTimeExtractor_GetFileDate_NonexistentFile(t *testing.T) {
@@ -166,13 +167,181 @@ type mockExtractor struct {
 	nameStr    string
 }
 
-func (m *mockExtractor) getFileDate(filePath string) (time.Time, error) {
+func (m *mockExtractor) getFileDate(filePath string) (captureTime, error) {
 	if m.returnErr != nil {
-		return time.Time{}, m.returnErr
+		return captureTime{}, m.returnErr
 	}
-	return m.returnDate, nil
+	return captureTime{time: m.returnDate}, nil
 }
 
 func (m *mockExtractor) name() string {
 	return m.nameStr
 }
+
+// batchMockExtractor dates the files it has a date for, recording the batches it is given
+type batchMockExtractor struct {
+	dates   map[string]time.Time
+	batches [][]string
+}
+
+func (m *batchMockExtractor) getFileDate(filePath string) (captureTime, error) {
+	dates, errs := m.getFileDates([]string{filePath})
+	return dates[0], errs[0]
+}
+
+func (m *batchMockExtractor) getFileDates(filePaths []string) ([]captureTime, []error) {
+	m.batches = append(m.batches, filePaths)
+	dates := make([]captureTime, len(filePaths))
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		if date, ok := m.dates[filePath]; ok {
+			dates[i] = captureTime{time: date}
+		} else {
+			errs[i] = os.ErrNotExist
+		}
+	}
+	return dates, errs
+}
+
+func (m *batchMockExtractor) name() string {
+	return "Batch"
+}
+
+func TestAggregatedFileDateExtractor_GetFileDatesIn(t *testing.T) {
+	exifDate := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+	fallbackDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	batch := &batchMockExtractor{dates: map[string]time.Time{"a.jpg": exifDate, "c.jpg": exifDate}}
+	second := &batchMockExtractor{dates: map[string]time.Time{"b.jpg": fallbackDate}}
+	extractor := &AggregatedFileDateExtractor{extractors: []fileDateExtractor{batch, second}}
+
+	dates, err := extractor.GetFileDatesIn([]string{"a.jpg", "b.jpg", "c.jpg"}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []time.Time{exifDate, fallbackDate, exifDate}
+	for i := range expected {
+		assertTimeEqual(t, expected[i], dates[i])
+	}
+	// Each extractor reads its files at once, and the next only gets those left without a date
+	if len(batch.batches) != 1 || len(batch.batches[0]) != 3 {
+		t.Errorf("Expected the first extractor to read all files at once, got %v", batch.batches)
+	}
+	if len(second.batches) != 1 || len(second.batches[0]) != 1 || second.batches[0][0] != "b.jpg" {
+		t.Errorf("Expected the second extractor to read b.jpg alone, got %v", second.batches)
+	}
+
+	if _, err := extractor.GetFileDatesIn([]string{"a.jpg", "missing.jpg"}, nil); err == nil || !strings.Contains(err.Error(), "missing.jpg") {
+		t.Errorf("Expected an error naming the file without a date, got: %v", err)
+	}
+}
+
+func TestSidecarDateExtractor_Name(t *testing.T) {
+	extractor := newSidecarDateExtractor(nil)
+	if extractor.name() != "Sidecar" {
+		t.Errorf("Expected name 'Sidecar', got '%s'", extractor.name())
+	}
+}
+
+func TestSidecarDateExtractor_GetFileDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	expected := time.Date(2023, 6, 15, 10, 0, 0, 0, time.FixedZone("", 2*60*60))
+	copyTime := time.Date(2024, 1, 20, 9, 0, 0, 0, time.UTC)
+	extractor := newSidecarDateExtractor(nil)
+
+	// Sidecar next to the video
+	video := createTestFileWithTime(t, tmpDir, "C0001.MP4", copyTime)
+	writeSidecar(t, tmpDir, "C0001M01.XML", sonyXMLSidecar)
+	result, err := extractor.getFileDate(video)
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	assertTimeEqual(t, expected, result.time)
+
+	// Sidecar copied by the parser
+	copied := createTestFileWithTime(t, tmpDir, "root-C0002.MP4", copyTime)
+	if err := os.Mkdir(filepath.Join(tmpDir, sidecarDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSidecar(t, filepath.Join(tmpDir, sidecarDirName), "root-C0002M01.XML", sonyXMLSidecar)
+	result, err = extractor.getFileDate(copied)
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	assertTimeEqual(t, expected, result.time)
+}
+
+func TestSidecarDateExtractor_GetFileDate_NoSidecar(t *testing.T) {
+	tmpDir := t.TempDir()
+	extractor := newSidecarDateExtractor(nil)
+
+	video := createTestFileWithTime(t, tmpDir, "C0001.MP4", time.Now())
+	if _, err := extractor.getFileDate(video); err == nil {
+		t.Error("Expected error for a video without sidecar, got nil")
+	}
+
+	// Only videos are dated by sidecars
+	image := createTestFileWithTime(t, tmpDir, "C0002.JPG", time.Now())
+	writeSidecar(t, tmpDir, "C0002M01.XML", sonyXMLSidecar)
+	if _, err := extractor.getFileDate(image); err == nil {
+		t.Error("Expected error for an image, got nil")
+	}
+}
+
+func TestParseExifDate(t *testing.T) {
+	madrid := time.FixedZone("+02:00", 2*60*60)
+	tests := []struct {
+		name      string
+		value     string
+		offset    string
+		utc       bool
+		expected  time.Time
+		wallClock bool
+	}{
+		{"wall clock", "2023:06:15 23:30:00", "", false, time.Date(2023, 6, 15, 23, 30, 0, 0, time.UTC), true},
+		{"subseconds", "2023:06:15 23:30:00.123", "", false, time.Date(2023, 6, 15, 23, 30, 0, 123000000, time.UTC), true},
+		{"inline offset", "2023:06:15 23:30:00+02:00", "", false, time.Date(2023, 6, 15, 23, 30, 0, 0, madrid), false},
+		{"OffsetTimeOriginal", "2023:06:15 23:30:00", "+02:00", false, time.Date(2023, 6, 15, 23, 30, 0, 0, madrid), false},
+		{"invalid offset ignored", "2023:06:15 23:30:00", "unknown", false, time.Date(2023, 6, 15, 23, 30, 0, 0, time.UTC), true},
+		{"QuickTime UTC", "2023:06:15 21:30:00", "", true, time.Date(2023, 6, 15, 21, 30, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExifDate(tt.value, tt.offset, tt.utc)
+			if err != nil {
+				t.Fatalf("parseExifDate failed: %v", err)
+			}
+			if !got.time.Equal(tt.expected) || got.wallClock != tt.wallClock {
+				t.Errorf("Expected %v (wall clock %v), got %v (wall clock %v)", tt.expected, tt.wallClock, got.time, got.wallClock)
+			}
+		})
+	}
+
+	if _, err := parseExifDate("0000:00:00 00:00:00", "", false); err == nil {
+		t.Error("Expected an error for an empty EXIF date")
+	}
+}
+
+func TestCaptureTime_In(t *testing.T) {
+	tokyo := time.FixedZone("+09:00", 9*60*60)
+	// Taken at 23:30 in Madrid, which is already the next day in Tokyo
+	instant := captureTime{time: time.Date(2023, 6, 15, 23, 30, 0, 0, time.FixedZone("+02:00", 2*60*60))}
+	wallClock := captureTime{time: time.Date(2023, 6, 15, 23, 30, 0, 0, time.UTC), wallClock: true}
+
+	if got := instant.in(nil); got.Day() != 15 {
+		t.Errorf("Expected the day of the recorded offset, got %v", got)
+	}
+	if got := instant.in(tokyo); got.Day() != 16 || got.Hour() != 6 {
+		t.Errorf("Expected the instant converted to Tokyo, got %v", got)
+	}
+	if got := wallClock.in(tokyo); got.Day() != 15 || got.Hour() != 23 || got.Location() != tokyo {
+		t.Errorf("Expected the wall clock taken to be in Tokyo, got %v", got)
+	}
+}
+
+func TestIsQuickTime(t *testing.T) {
+	for file, expected := range map[string]bool{"clip.MOV": true, "clip.mp4": true, "clip.avi": false, "photo.jpg": false} {
+		if got := isQuickTime(file); got != expected {
+			t.Errorf("isQuickTime(%q) = %v, expected %v", file, got, expected)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package pics
+
+import (
+	"time"
+)
+
+// ReviewDirName is the directory of the target where files with implausible dates are
+// moved instead of a date-based directory
+const ReviewDirName = "review"
+
+// futureDateTolerance allows dates slightly ahead of the clock, since EXIF dates carry
+// no time zone and a camera set to a zone east of ours records times ahead of ours
+const futureDateTolerance = 24 * time.Hour
+
+// ReviewFile is a file routed to review because its date is implausible
+type ReviewFile struct {
+	// Name is the name of the file in the review directory
+	Name string `json:"name"`
+	// Date is the date extracted from the file
+	Date time.Time `json:"date"`
+	// Reason explains why the date was rejected
+	Reason string `json:"reason"`
+}
+
+// implausibleDateReason returns why date can't be trusted as the capture date of a file,
+// or an empty string if it looks right. now is the time of the check.
+func implausibleDateReason(date, now time.Time) string {
+	switch {
+	case date.Year() < 1970:
+		return "before 1970"
+	case isDay(date, 1970, time.January, 1):
+		return "Unix epoch default"
+	case isDay(date, 1980, time.January, 1):
+		return "camera default date"
+	case date.After(now.Add(futureDateTolerance)):
+		return "in the future"
+	}
+	return ""
+}
+
+// isDay reports whether t falls on the given day
+func isDay(t time.Time, year int, month time.Month, day int) bool {
+	y, m, d := t.Date()
+	return y == year && m == month && d == day
+}
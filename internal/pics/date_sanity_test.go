@@ -0,0 +1,37 @@
+package pics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImplausibleDateReason(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		date time.Time
+		want string
+	}{
+		{"recent date", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC), ""},
+		{"old film scan", time.Date(1975, 8, 2, 0, 0, 0, 0, time.UTC), ""},
+		{"today", now, ""},
+		{"camera slightly ahead", now.Add(10 * time.Hour), ""},
+		{"before 1970", time.Date(1969, 12, 31, 23, 0, 0, 0, time.UTC), "before 1970"},
+		{"year 1", time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), "before 1970"},
+		{"epoch", time.Unix(0, 0).UTC(), "Unix epoch default"},
+		{"camera default", time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), "camera default date"},
+		{"camera default after a few minutes", time.Date(1980, 1, 1, 0, 12, 0, 0, time.UTC), "camera default date"},
+		{"day after camera default", time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC), ""},
+		{"tomorrow", now.Add(48 * time.Hour), "in the future"},
+		{"far future", time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC), "in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := implausibleDateReason(tt.date, now); got != tt.want {
+				t.Errorf("implausibleDateReason(%v) = %q, want %q", tt.date, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package pics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// deviceStateName is the file of a library recording what was last imported from each device
+const deviceStateName = ".pics-devices.json"
+
+// gvfsHostPrefixes are the prefixes GVFS gives the mount points of cameras and phones it mounts
+// over PTP (gphoto2) and MTP, before the name of the device
+var gvfsHostPrefixes = []string{"gphoto2:host=", "mtp:host="}
+
+// Device is a mounted camera card, camera or phone holding a DCIM directory
+type Device struct {
+	// Name is the name of the volume, e.g. EOS_DIGITAL, or of the device for cameras and phones
+	// mounted over PTP or MTP
+	Name string
+	// Path is where the volume is mounted
+	Path string
+	// DCIM is the DCIM directory of the volume, holding the camera files
+	DCIM string
+}
+
+// DeviceImportOptions holds configuration options for importing from a device
+type DeviceImportOptions struct {
+	// Parse are the options the files of the device are parsed with
+	Parse ParseOptions
+	// OnlyNew leaves out the files imported from the device before: those modified before the
+	// newest file of the previous import, or at the same time and imported with it
+	OnlyNew bool
+}
+
+// DeviceImportReport holds what an import from a device did
+type DeviceImportReport struct {
+	// Parse is the report of the parse of the files of the device, empty if there was none to import
+	Parse ParseReport
+	// Found is the number of media files found on the device
+	Found int
+	// AlreadyImported is the number of files left out as imported before, with OnlyNew
+	AlreadyImported int
+}
+
+// DeviceImporter imports the media files on mounted cameras and phones
+type DeviceImporter interface {
+	// Import parses the media files in the DCIM directory of device into targetDir and records
+	// the newest of them in targetDir, so a later import with OnlyNew can leave them out
+	Import(ctx context.Context, device Device, targetDir string, opts DeviceImportOptions) (DeviceImportReport, error)
+}
+
+// deviceImporter implements the DeviceImporter interface
+type deviceImporter struct {
+	parser     MediaParser
+	extensions Extensions
+}
+
+// NewDeviceImporter creates a DeviceImporter that imports files with parser
+func NewDeviceImporter(parser MediaParser) DeviceImporter {
+	return &deviceImporter{
+		parser:     parser,
+		extensions: NewExtensions(),
+	}
+}
+
+// FindDevices lists the volumes holding a DCIM directory: the mount roots themselves, or the
+// volumes mounted in them, such as /media/$USER/EOS_DIGITAL. Roots that don't exist or can't be
+// read are skipped.
+func FindDevices(mountRoots []string) []Device {
+	var devices []Device
+	seen := make(map[string]bool)
+	add := func(volume string) {
+		dcim := findDCIM(volume)
+		if dcim == "" || seen[dcim] {
+			return
+		}
+		seen[dcim] = true
+		devices = append(devices, Device{Name: deviceName(volume), Path: volume, DCIM: dcim})
+	}
+
+	for _, root := range mountRoots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			logger.Debug("Skipping mount root", "root", root, "error", err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				add(filepath.Join(root, entry.Name()))
+			}
+		}
+		// The root only counts as a device if none of its volumes is: findDCIM would otherwise
+		// take the DCIM of a card mounted in it for the root's own
+		add(root)
+	}
+	return devices
+}
+
+// findDCIM returns the DCIM directory of volume, or of a storage of it as phones mounted over MTP
+// have (e.g. "Internal shared storage/DCIM"), or "" if it has none. The name is matched ignoring
+// case, as FAT cards may be mounted with either.
+func findDCIM(volume string) string {
+	entries, err := os.ReadDir(volume)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.EqualFold(entry.Name(), "DCIM") {
+			return filepath.Join(volume, entry.Name())
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		storage := filepath.Join(volume, entry.Name())
+		storageEntries, err := os.ReadDir(storage)
+		if err != nil {
+			continue
+		}
+		for _, storageEntry := range storageEntries {
+			if storageEntry.IsDir() && strings.EqualFold(storageEntry.Name(), "DCIM") {
+				return filepath.Join(storage, storageEntry.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// deviceName returns the name of the device mounted at volume: its volume label, or the name
+// GVFS gives cameras and phones
+func deviceName(volume string) string {
+	name := filepath.Base(volume)
+	for _, prefix := range gvfsHostPrefixes {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if name == "" || name == string(filepath.Separator) || name == "." {
+		// A drive root such as D:\
+		name = strings.TrimRight(filepath.VolumeName(volume), ":")
+	}
+	return name
+}
+
+// deviceState records the newest files imported from a device
+type deviceState struct {
+	// LastImport is when the device was last imported from
+	LastImport time.Time `json:"lastImport"`
+	// Newest is the modification time of the newest file imported from the device
+	Newest time.Time `json:"newest"`
+	// AtNewest lists the files imported with that modification time, relative to the DCIM
+	// directory, so files taken in the same second after the import aren't left out
+	AtNewest []string `json:"atNewest,omitempty"`
+}
+
+// deviceStates records what was last imported into a library from each device, by device name
+type deviceStates struct {
+	// Devices maps the name of each device to its state
+	Devices map[string]deviceState `json:"devices"`
+}
+
+// loadDeviceStates reads the device states of a library, or returns empty ones if nothing was
+// ever imported from a device into it
+func loadDeviceStates(libraryDir string) (*deviceStates, error) {
+	states := &deviceStates{Devices: make(map[string]deviceState)}
+	data, err := os.ReadFile(filepath.Join(libraryDir, deviceStateName))
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, states); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", deviceStateName, err)
+	}
+	if states.Devices == nil {
+		states.Devices = make(map[string]deviceState)
+	}
+	return states, nil
+}
+
+// save writes the device states to the library, replacing the previous ones at once
+func (s *deviceStates) save(libraryDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(libraryDir, deviceStateName, data)
+}
+
+// imported reports whether the file of the DCIM directory at relPath, modified at modTime, was
+// imported before
+func (s deviceState) imported(relPath string, modTime time.Time) bool {
+	if s.Newest.IsZero() || modTime.After(s.Newest) {
+		return false
+	}
+	if modTime.Before(s.Newest) {
+		return true
+	}
+	return slices.Contains(s.AtNewest, relPath)
+}
+
+// deviceFile is a media file in the DCIM directory of a device
+type deviceFile struct {
+	path    string
+	relPath string
+	modTime time.Time
+}
+
+// listFiles lists the supported media files of dcim by path, skipping hidden files and directories
+func (d *deviceImporter) listFiles(dcim string) ([]deviceFile, error) {
+	var files []deviceFile
+	err := filepath.Walk(dcim, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dcim && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !d.extensions.IsSupported(path) {
+			return nil
+		}
+		relPath, err := filepath.Rel(dcim, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, deviceFile{path: path, relPath: filepath.ToSlash(relPath), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].relPath < files[j].relPath })
+	return files, nil
+}
+
+// Import parses the media files of device into targetDir
+func (d *deviceImporter) Import(ctx context.Context, device Device, targetDir string, opts DeviceImportOptions) (DeviceImportReport, error) {
+	states, err := loadDeviceStates(targetDir)
+	if err != nil {
+		return DeviceImportReport{}, err
+	}
+	state := states.Devices[device.Name]
+	found, err := d.listFiles(device.DCIM)
+	if err != nil {
+		return DeviceImportReport{}, fmt.Errorf("failed to list files of %s: %w", device.Name, err)
+	}
+
+	report := DeviceImportReport{Found: len(found)}
+	var files []deviceFile
+	for _, file := range found {
+		if opts.OnlyNew && state.imported(file.relPath, file.modTime) {
+			report.AlreadyImported++
+			continue
+		}
+		files = append(files, file)
+	}
+	logger.Info("Importing from device", "device", device.Name, "dcim", device.DCIM, "found", report.Found, "already_imported", report.AlreadyImported)
+	if len(files) == 0 {
+		logger.Info("Nothing new to import", "device", device.Name)
+		return report, nil
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.path
+	}
+	report.Parse, err = d.parser.ParseFiles(ctx, paths, targetDir, opts.Parse)
+	if err != nil {
+		return report, err
+	}
+
+	// Files skipped because they kept changing aren't recorded as imported
+	changing := make(map[string]bool, len(report.Parse.Changing))
+	for _, path := range report.Parse.Changing {
+		changing[path] = true
+	}
+	for _, file := range files {
+		if changing[file.path] || file.modTime.Before(state.Newest) {
+			continue
+		}
+		if file.modTime.After(state.Newest) {
+			state.Newest, state.AtNewest = file.modTime, nil
+		}
+		if !slices.Contains(state.AtNewest, file.relPath) {
+			state.AtNewest = append(state.AtNewest, file.relPath)
+		}
+	}
+	state.LastImport = time.Now()
+	states.Devices[device.Name] = state
+	if err := states.save(targetDir); err != nil {
+		return report, fmt.Errorf("failed to record import from %s: %w", device.Name, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,105 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindDevices(t *testing.T) {
+	root := t.TempDir()
+	writeContentFile(t, filepath.Join(root, "EOS_DIGITAL", "DCIM", "100CANON"), "IMG_0001.JPG", "photo")
+	writeContentFile(t, filepath.Join(root, "Pixel 7", "Internal shared storage", "DCIM", "Camera"), "PXL_0001.jpg", "photo")
+	writeContentFile(t, filepath.Join(root, "USB_STICK", "Documents"), "notes.txt", "notes")
+	writeContentFile(t, filepath.Join(root, "LOWER", "dcim"), "img.jpg", "photo")
+
+	devices := FindDevices([]string{filepath.Join(root, "missing"), root})
+	want := []Device{
+		{Name: "EOS_DIGITAL", Path: filepath.Join(root, "EOS_DIGITAL"), DCIM: filepath.Join(root, "EOS_DIGITAL", "DCIM")},
+		{Name: "LOWER", Path: filepath.Join(root, "LOWER"), DCIM: filepath.Join(root, "LOWER", "dcim")},
+		{Name: "Pixel 7", Path: filepath.Join(root, "Pixel 7"), DCIM: filepath.Join(root, "Pixel 7", "Internal shared storage", "DCIM")},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("Expected devices %+v, got %+v", want, devices)
+	}
+
+	// A card given as the mount root itself is found too
+	devices = FindDevices([]string{filepath.Join(root, "EOS_DIGITAL")})
+	if len(devices) != 1 || devices[0].Name != "EOS_DIGITAL" {
+		t.Errorf("Expected the card found as a root, got %+v", devices)
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		volume string
+		want   string
+	}{
+		{filepath.Join("media", "EOS_DIGITAL"), "EOS_DIGITAL"},
+		{filepath.Join("gvfs", "mtp:host=Google_Pixel_7_2A111FDH"), "Google_Pixel_7_2A111FDH"},
+		{filepath.Join("gvfs", "gphoto2:host=Canon_Inc._Canon_Digital_Camera"), "Canon_Inc._Canon_Digital_Camera"},
+	}
+	for _, tt := range tests {
+		if got := deviceName(tt.volume); got != tt.want {
+			t.Errorf("deviceName(%q) = %q, want %q", tt.volume, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceImporter_ImportOnlyNew(t *testing.T) {
+	card := t.TempDir()
+	targetDir := t.TempDir()
+	dcim := filepath.Join(card, "DCIM", "100CANON")
+	taken := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	setModTime := func(path string, modTime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setModTime(writeContentFile(t, dcim, "IMG_0001.JPG", "photo 1"), taken)
+	setModTime(writeContentFile(t, dcim, "IMG_0002.JPG", "photo 2"), taken.Add(time.Minute))
+	writeContentFile(t, dcim, ".thumbnail.jpg", "thumbnail")
+	device := Device{Name: "EOS_DIGITAL", Path: card, DCIM: filepath.Join(card, "DCIM")}
+
+	parser := &fakeBatchParser{}
+	importer := NewDeviceImporter(parser)
+	opts := DeviceImportOptions{Parse: DefaultParseOptions(), OnlyNew: true}
+	report, err := importer.Import(testCtx, device, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.Found != 2 || report.AlreadyImported != 0 || report.Parse.Imported != 2 {
+		t.Errorf("Expected both photos imported, got %+v", report)
+	}
+
+	// Photos taken since, including one modified at the same time as the newest imported, are new
+	setModTime(writeContentFile(t, dcim, "IMG_0003.JPG", "photo 3"), taken.Add(time.Minute))
+	setModTime(writeContentFile(t, dcim, "IMG_0004.JPG", "photo 4"), taken.Add(time.Hour))
+	report, err = importer.Import(testCtx, device, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := []string{filepath.Join(dcim, "IMG_0003.JPG"), filepath.Join(dcim, "IMG_0004.JPG")}
+	if len(parser.batches) != 2 || !reflect.DeepEqual(parser.batches[1], want) {
+		t.Fatalf("Expected only the new photos imported, got %v", parser.batches)
+	}
+	if report.AlreadyImported != 2 {
+		t.Errorf("Expected 2 photos left out, got %+v", report)
+	}
+
+	// Nothing is left once all were imported, and without OnlyNew everything is imported again
+	report, err = importer.Import(testCtx, device, targetDir, opts)
+	if err != nil || report.AlreadyImported != 4 || len(parser.batches) != 2 {
+		t.Errorf("Expected nothing imported, got %+v and %v", report, err)
+	}
+	opts.OnlyNew = false
+	if _, err := importer.Import(testCtx, device, targetDir, opts); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(parser.batches) != 3 || len(parser.batches[2]) != 4 {
+		t.Errorf("Expected all photos imported, got %v", parser.batches)
+	}
+}
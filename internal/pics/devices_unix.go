@@ -0,0 +1,27 @@
+//go:build !windows
+
+package pics
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// DefaultMountRoots returns where removable volumes are mounted: by udisks on Linux, under
+// /media/$USER or /run/media/$USER, by GVFS for cameras and phones over PTP and MTP, and on macOS
+// under /Volumes. Roots that don't exist are skipped by FindDevices.
+func DefaultMountRoots() []string {
+	var roots []string
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if u, err := user.Current(); err == nil {
+		roots = append(roots, filepath.Join("/media", u.Username), filepath.Join("/run/media", u.Username))
+		if runtimeDir == "" {
+			runtimeDir = filepath.Join("/run/user", u.Uid)
+		}
+	}
+	if runtimeDir != "" {
+		roots = append(roots, filepath.Join(runtimeDir, "gvfs"))
+	}
+	return append(roots, "/Volumes", "/media", "/mnt")
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package pics
+
+import "os"
+
+// DefaultMountRoots returns the drives D: to Z:, where Windows gives removable volumes a letter.
+// Drives that don't exist are skipped by FindDevices. Cameras and phones connected over PTP or
+// MTP have no drive letter, so their files have to be copied off them, or imported from a card reader.
+func DefaultMountRoots() []string {
+	var roots []string
+	for letter := 'D'; letter <= 'Z'; letter++ {
+		root := string(letter) + `:\`
+		if _, err := os.Stat(root); err == nil {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,160 @@
+package pics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LibraryDiff compares two organised libraries
+type LibraryDiff interface {
+	// Diff reports files added, removed and changed in dirB relative to dirA, grouped by date directory
+	Diff(dirA, dirB string) (DiffReport, error)
+}
+
+// DiffReport holds the differences between two libraries
+type DiffReport struct {
+	// Directories lists the date directories that differ, sorted by name
+	Directories []DirectoryDiff
+}
+
+// DirectoryDiff holds the differences within a single date directory.
+// File paths are relative to the date directory.
+type DirectoryDiff struct {
+	// Directory is the date directory name ("." for files at the library root)
+	Directory string
+	// Added lists files only present in the second library
+	Added []string
+	// Removed lists files only present in the first library
+	Removed []string
+	// Changed lists files present in both libraries with different contents
+	Changed []string
+}
+
+// IsEmpty reports whether both libraries hold the same files with the same contents
+func (r DiffReport) IsEmpty() bool {
+	return len(r.Directories) == 0
+}
+
+// libraryDiff implements the LibraryDiff interface
+type libraryDiff struct{}
+
+// NewLibraryDiff creates a new LibraryDiff instance
+func NewLibraryDiff() LibraryDiff {
+	return &libraryDiff{}
+}
+
+// Diff reports files added, removed and changed in dirB relative to dirA
+func (d *libraryDiff) Diff(dirA, dirB string) (DiffReport, error) {
+	filesA, err := d.listFiles(dirA)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to list files in %s: %w", dirA, err)
+	}
+	filesB, err := d.listFiles(dirB)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to list files in %s: %w", dirB, err)
+	}
+
+	byDirectory := make(map[string]*DirectoryDiff)
+	entry := func(relPath string) (*DirectoryDiff, string) {
+		dir, file := splitDateDirectory(relPath)
+		if byDirectory[dir] == nil {
+			byDirectory[dir] = &DirectoryDiff{Directory: dir}
+		}
+		return byDirectory[dir], file
+	}
+
+	for relPath, sizeA := range filesA {
+		sizeB, ok := filesB[relPath]
+		if !ok {
+			diff, file := entry(relPath)
+			diff.Removed = append(diff.Removed, file)
+			continue
+		}
+		same, err := d.sameContents(filepath.Join(dirA, relPath), filepath.Join(dirB, relPath), sizeA, sizeB)
+		if err != nil {
+			return DiffReport{}, err
+		}
+		if !same {
+			diff, file := entry(relPath)
+			diff.Changed = append(diff.Changed, file)
+		}
+	}
+	for relPath := range filesB {
+		if _, ok := filesA[relPath]; !ok {
+			diff, file := entry(relPath)
+			diff.Added = append(diff.Added, file)
+		}
+	}
+
+	var report DiffReport
+	for _, diff := range byDirectory {
+		sort.Strings(diff.Added)
+		sort.Strings(diff.Removed)
+		sort.Strings(diff.Changed)
+		report.Directories = append(report.Directories, *diff)
+	}
+	sort.Slice(report.Directories, func(i, j int) bool {
+		return report.Directories[i].Directory < report.Directories[j].Directory
+	})
+	return report, nil
+}
+
+// listFiles returns the size of every regular file in a library, keyed by slash-separated relative path.
+// Dot files and dot directories are skipped, as everywhere else in the library.
+func (d *libraryDiff) listFiles(root string) (map[string]int64, error) {
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a valid directory: %s", root)
+	}
+
+	files := make(map[string]int64)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// sameContents compares two files by size first and by SHA-256 hash when the sizes match
+func (d *libraryDiff) sameContents(pathA, pathB string, sizeA, sizeB int64) (bool, error) {
+	if sizeA != sizeB {
+		return false, nil
+	}
+	hashA, err := fileSHA256(pathA)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash %s: %w", pathA, err)
+	}
+	hashB, err := fileSHA256(pathB)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash %s: %w", pathB, err)
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
+
+// splitDateDirectory splits a slash-separated library path into its top-level directory and the rest
+func splitDateDirectory(relPath string) (string, string) {
+	dir, file, found := strings.Cut(relPath, "/")
+	if !found {
+		return ".", relPath
+	}
+	return dir, file
+}
@@ -0,0 +1,103 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeDiffFile(t *testing.T, dir, filename, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create directory %s: %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, filename), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create file %s: %v", filename, err)
+	}
+}
+
+func TestLibraryDiff_Identical(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirA := filepath.Join(tmpDir, "a")
+	dirB := filepath.Join(tmpDir, "b")
+	for _, dir := range []string{dirA, dirB} {
+		writeDiffFile(t, filepath.Join(dir, "2023 06 June 15"), "photo.jpg", "same")
+		writeDiffFile(t, filepath.Join(dir, "2023 06 June 15", "videos"), "video.mov", "same")
+	}
+
+	report, err := NewLibraryDiff().Diff(dirA, dirB)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !report.IsEmpty() {
+		t.Errorf("Expected no differences, got: %+v", report.Directories)
+	}
+}
+
+func TestLibraryDiff_Differences(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirA := filepath.Join(tmpDir, "a")
+	dirB := filepath.Join(tmpDir, "b")
+
+	june := "2023 06 June 15"
+	writeDiffFile(t, filepath.Join(dirA, june), "kept.jpg", "same")
+	writeDiffFile(t, filepath.Join(dirB, june), "kept.jpg", "same")
+	writeDiffFile(t, filepath.Join(dirA, june), "removed.jpg", "gone")
+	writeDiffFile(t, filepath.Join(dirB, june, "videos"), "added.mov", "new")
+	// Same size, different contents
+	writeDiffFile(t, filepath.Join(dirA, june), "edited.jpg", "aaaa")
+	writeDiffFile(t, filepath.Join(dirB, june), "edited.jpg", "bbbb")
+	// Different size
+	writeDiffFile(t, filepath.Join(dirA, june), "resized.jpg", "small")
+	writeDiffFile(t, filepath.Join(dirB, june), "resized.jpg", "much larger")
+	writeDiffFile(t, filepath.Join(dirB, "2023 07 July 01"), "new.jpg", "new")
+	writeDiffFile(t, dirA, "loose.jpg", "root")
+	// Dot files are not part of the library
+	writeDiffFile(t, dirB, IgnoreFileName, "*.tmp\n")
+
+	report, err := NewLibraryDiff().Diff(dirA, dirB)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []DirectoryDiff{
+		{Directory: ".", Removed: []string{"loose.jpg"}},
+		{Directory: june, Added: []string{"videos/added.mov"}, Removed: []string{"removed.jpg"}, Changed: []string{"edited.jpg", "resized.jpg"}},
+		{Directory: "2023 07 July 01", Added: []string{"new.jpg"}},
+	}
+	if !reflect.DeepEqual(report.Directories, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, report.Directories)
+	}
+}
+
+func TestLibraryDiff_InvalidDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	if _, err := NewLibraryDiff().Diff(filepath.Join(tmpDir, "missing"), tmpDir); err == nil {
+		t.Error("Expected error for nonexistent first directory")
+	}
+	if _, err := NewLibraryDiff().Diff(tmpDir, filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("Expected error for nonexistent second directory")
+	}
+}
+
+func TestSplitDateDirectory(t *testing.T) {
+	tests := []struct {
+		relPath      string
+		expectedDir  string
+		expectedFile string
+	}{
+		{relPath: "photo.jpg", expectedDir: ".", expectedFile: "photo.jpg"},
+		{relPath: "2023 06 June 15/photo.jpg", expectedDir: "2023 06 June 15", expectedFile: "photo.jpg"},
+		{relPath: "2023 06 June 15/videos/a.mov", expectedDir: "2023 06 June 15", expectedFile: "videos/a.mov"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.relPath, func(t *testing.T) {
+			dir, file := splitDateDirectory(tt.relPath)
+			if dir != tt.expectedDir || file != tt.expectedFile {
+				t.Errorf("Expected (%q, %q), got (%q, %q)", tt.expectedDir, tt.expectedFile, dir, file)
+			}
+		})
+	}
+}
@@ -4,78 +4,81 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 
 	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
 	"github.com/barasher/go-exiftool"
 )
 
 // DirectoryRenamer defines the interface for renaming date-based directories
 type DirectoryRenamer interface {
-	// RenameDirectory renames a date-based directory and all images inside it
-	RenameDirectory(directory, newName string) error
+	// RenameDirectory renames a date-based directory and all images inside it, numbering files
+	// of the same date in the given order. The renames are recorded in the directory so
+	// UndoRename can restore the previous names.
+	RenameDirectory(directory, newName string, order SequenceOrder) (RenameReport, error)
+	// UndoRename restores the directory and file names before the latest rename of directory,
+	// returning the path of the restored directory
+	UndoRename(directory string) (string, error)
+}
+
+// RenameReport describes what a rename did
+type RenameReport struct {
+	// Previous is the path of the directory before the rename
+	Previous string `json:"previous"`
+	// Directory is the path of the directory after the rename
+	Directory string `json:"directory"`
+	// Images is the number of images renamed
+	Images int `json:"images"`
+	// Videos is the number of videos renamed
+	Videos int `json:"videos"`
 }
 
 // directoryRenamer implements the DirectoryRenamer interface
 type directoryRenamer struct {
 	extensions  Extensions
-	fileRenamer FileRenamer
+	fileRenamer *fileRenamer
 }
 
 // NewDirectoryRenamer creates a new DirectoryRenamer instance
 func NewDirectoryRenamer(et *exiftool.Exiftool) DirectoryRenamer {
+	return NewDirectoryRenamerWithWriter(et, NewExifWriter(et))
+}
+
+// NewDirectoryRenamerWithWriter creates a new DirectoryRenamer writing OriginalFileName with exifWriter
+func NewDirectoryRenamerWithWriter(et *exiftool.Exiftool, exifWriter ExifWriter) DirectoryRenamer {
 	return &directoryRenamer{
 		extensions:  NewExtensions(),
-		fileRenamer: NewFileRenamer(et),
+		fileRenamer: newFileRenamer(et, exifWriter),
 	}
 }
 
 // RenameDirectory renames a date-based directory and all images inside it
-func (r *directoryRenamer) RenameDirectory(directory, newName string) error {
+func (r *directoryRenamer) RenameDirectory(directory, newName string, order SequenceOrder) (RenameReport, error) {
 	// Clean the path to remove trailing slashes and normalize
 	directory = filepath.Clean(directory)
 
 	// Check if directory exists
 	info, err := os.Stat(directory)
 	if err != nil {
-		return fmt.Errorf("directory does not exist: %w", err)
+		return RenameReport{}, fmt.Errorf("directory does not exist: %w", err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("%s is not a directory", directory)
+		return RenameReport{}, fmt.Errorf("%s is not a directory", directory)
 	}
 
 	// Convert to absolute path to ensure correct parent directory
 	absDir, err := filepath.Abs(directory)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
-	}
-
-	// Extract base name and parse date
-	baseName := filepath.Base(absDir)
-	parts := strings.Fields(baseName)
-
-	// Expect at least 4 parts: YYYY MM Month DD
-	if len(parts) < 4 {
-		return fmt.Errorf("directory name does not match expected format (YYYY MM Month DD [name]): %s", baseName)
+		return RenameReport{}, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	// Validate year and month are numeric
-	year, err := strconv.Atoi(parts[0])
-	if err != nil || year < 1000 || year > 9999 {
-		return fmt.Errorf("invalid year in directory name: %s", parts[0])
-	}
-	month, err := strconv.Atoi(parts[1])
-	if err != nil || month < 1 || month > 12 {
-		return fmt.Errorf("invalid month in directory name: %s", parts[1])
-	}
-
-	// Build new directory name: date + new name
-	dateParts := parts[:4]
-	newDirName := strings.Join(dateParts, " ")
-	if newName != "" {
-		newDirName = newDirName + " " + newName
+	// Parse the date of the directory, keeping it and replacing the name after it
+	parsed, err := naming.Parse(filepath.Base(absDir))
+	if err != nil {
+		return RenameReport{}, err
 	}
+	renamed := naming.DirName{Date: parsed.Date, Name: newName}
+	newDirName := renamed.String()
 
 	// Build full path for new directory
 	parentDir := filepath.Dir(absDir)
@@ -89,65 +92,158 @@ func (r *directoryRenamer) RenameDirectory(directory, newName string) error {
 	} else {
 		// Check if target directory already exists
 		if _, err := os.Stat(newDirPath); err == nil {
-			return fmt.Errorf("target directory already exists: %s", newDirPath)
+			return RenameReport{}, fmt.Errorf("target directory already exists: %s", newDirPath)
 		}
 
 		logger.Info("Renaming directory", "from", absDir, "to", newDirPath)
 	}
 
 	// Convert directory name to base name for file renaming
-	newBaseName := strings.ReplaceAll(newDirName, " ", "_")
+	newBaseName := renamed.FilePrefix()
+
+	journal, err := loadRenameJournal(absDir)
+	if err != nil {
+		return RenameReport{}, err
+	}
 
 	// Rename image files first (before moving directory)
-	if err := r.renameImages(absDir, newBaseName); err != nil {
-		return err
+	images, err := r.renameImages(absDir, newBaseName, order)
+	if err != nil {
+		return RenameReport{}, err
 	}
 
 	// Rename videos in videos subdirectory if it exists
-	if err := r.renameVideos(absDir, newBaseName); err != nil {
-		return err
+	videos, err := r.renameVideos(absDir, newBaseName, order)
+	if err != nil {
+		return RenameReport{}, err
+	}
+
+	renames := append(images, videos...)
+	if err := renameChecksumManifestEntries(absDir, renames); err != nil {
+		return RenameReport{}, err
 	}
 
 	// Rename the directory if needed
 	if err := r.renameDir(absDir, newDirPath); err != nil {
-		return err
+		return RenameReport{}, err
 	}
 
-	return nil
+	if err := journal.record(absDir, newDirPath, renames); err != nil {
+		return RenameReport{}, err
+	}
+	return RenameReport{Previous: absDir, Directory: newDirPath, Images: len(images), Videos: len(videos)}, nil
+}
+
+// UndoRename restores the names of the latest rename recorded in the journal of directory. The
+// files are checked first, so a directory changed since the rename is left alone, and are renamed
+// through temporary names, so files that swapped names are restored too.
+func (r *directoryRenamer) UndoRename(directory string) (string, error) {
+	absDir, err := filepath.Abs(filepath.Clean(directory))
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return "", fmt.Errorf("directory does not exist: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", directory)
+	}
+
+	journal, err := loadRenameJournal(absDir)
+	if err != nil {
+		return "", err
+	}
+	rename := journal.last()
+	if rename == nil {
+		return "", fmt.Errorf("no rename of %s to undo", directory)
+	}
+	if rename.To != filepath.Base(absDir) {
+		return "", fmt.Errorf("%s was renamed to %q, not to its current name, since moved or renamed by hand", directory, rename.To)
+	}
+	restoredPath := filepath.Join(filepath.Dir(absDir), rename.From)
+	if restoredPath != absDir {
+		if _, err := os.Stat(restoredPath); err == nil {
+			return "", fmt.Errorf("target directory already exists: %s", restoredPath)
+		}
+	}
+
+	renamed := make(map[string]bool, len(rename.Files))
+	for _, file := range rename.Files {
+		renamed[file.To] = true
+	}
+	for _, file := range rename.Files {
+		if _, err := os.Lstat(filepath.Join(absDir, filepath.FromSlash(file.To))); err != nil {
+			return "", fmt.Errorf("renamed file %s is missing, not undoing: %w", file.To, err)
+		}
+		if renamed[file.From] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(absDir, filepath.FromSlash(file.From))); err == nil {
+			return "", fmt.Errorf("%s exists already, not undoing", file.From)
+		}
+	}
+
+	logger.Info("Undoing rename", "directory", absDir, "files", len(rename.Files), "restored_name", rename.From)
+
+	restores := make([]fileRename, len(rename.Files))
+	for i, file := range rename.Files {
+		restores[i] = fileRename{
+			from: filepath.Join(absDir, filepath.FromSlash(file.To)),
+			to:   filepath.Join(absDir, filepath.FromSlash(file.From)),
+		}
+	}
+	if err := renameThroughTemp(restores, "undo"); err != nil {
+		return "", err
+	}
+	if err := renameChecksumManifestEntries(absDir, restores); err != nil {
+		return "", err
+	}
+
+	if err := r.renameDir(absDir, restoredPath); err != nil {
+		return "", err
+	}
+
+	journal.dirPath = restoredPath
+	journal.Renames = journal.Renames[:len(journal.Renames)-1]
+	if err := journal.save(); err != nil {
+		return "", fmt.Errorf("rename undone but the journal couldn't be updated: %w", err)
+	}
+	return restoredPath, nil
 }
 
-// renameImages renames all image files in the directory
-func (r *directoryRenamer) renameImages(absDir, newBaseName string) error {
-	imageCount, err := r.fileRenamer.RenameFilesWithPattern(absDir, newBaseName, r.extensions.IsImage, nil)
+// renameImages renames all image files in the directory, returning the files renamed
+func (r *directoryRenamer) renameImages(absDir, newBaseName string, order SequenceOrder) ([]fileRename, error) {
+	renames, err := r.fileRenamer.renameFilesWithPatternInDir(absDir, absDir, newBaseName, r.extensions.IsImage, order, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if imageCount > 0 {
-		logger.Info("Renaming images", "count", imageCount, "pattern", newBaseName)
+	if len(renames) > 0 {
+		logger.Info("Renaming images", "count", len(renames), "pattern", newBaseName)
 	}
 
-	return nil
+	return renames, nil
 }
 
-// renameVideos renames all video files in the videos subdirectory
-func (r *directoryRenamer) renameVideos(absDir, newBaseName string) error {
+// renameVideos renames all video files in the videos subdirectory, returning the files renamed
+func (r *directoryRenamer) renameVideos(absDir, newBaseName string, order SequenceOrder) ([]fileRename, error) {
 	videosDir := filepath.Join(absDir, "videos")
 	info, err := os.Stat(videosDir)
 	if err != nil || !info.IsDir() {
-		return nil
+		return nil, nil
 	}
 
-	videoCount, err := r.fileRenamer.MoveAndRenameFilesWithPattern(videosDir, videosDir, newBaseName, r.extensions.IsVideo, nil)
+	renames, err := r.fileRenamer.renameFilesWithPatternInDir(videosDir, videosDir, newBaseName, r.extensions.IsVideo, order, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if videoCount > 0 {
-		logger.Info("Renaming videos", "count", videoCount, "pattern", newBaseName)
+	if len(renames) > 0 {
+		logger.Info("Renaming videos", "count", len(renames), "pattern", newBaseName)
 	}
 
-	return nil
+	return renames, nil
 }
 
 // renameDir renames the directory itself
@@ -72,7 +72,7 @@ func TestDirectoryRenamer_RenameDirectory_WithImages(t *testing.T) {
 
 	// Rename directory
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "vacation")
+	report, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -81,6 +81,9 @@ func TestDirectoryRenamer_RenameDirectory_WithImages(t *testing.T) {
 	// Check that directory was renamed
 	newDirPath := filepath.Join(tmpDir, "2023 06 June 15 vacation")
 	assertDirExists(t, newDirPath)
+	if report.Directory != newDirPath || report.Images != 3 || report.Videos != 0 {
+		t.Errorf("Expected 3 images renamed into %s, got %+v", newDirPath, report)
+	}
 
 	// Check that images were renamed
 	expectedFiles := []string{
@@ -104,7 +107,7 @@ func TestDirectoryRenamer_RenameDirectory_WithVideos(t *testing.T) {
 
 	// Rename directory
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "trip")
+	_, err := renamer.RenameDirectory(testDir, "trip", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -139,7 +142,7 @@ func TestDirectoryRenamer_RenameDirectory_WithImagesAndVideos(t *testing.T) {
 
 	// Rename directory
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "christmas")
+	_, err := renamer.RenameDirectory(testDir, "christmas", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -171,7 +174,7 @@ func TestDirectoryRenamer_RenameDirectory_EmptyName(t *testing.T) {
 	createTestImage(t, testDir, "img1.jpg")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "")
+	_, err := renamer.RenameDirectory(testDir, "", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -200,7 +203,7 @@ func TestDirectoryRenamer_RenameDirectory_NoChange(t *testing.T) {
 	createTestImage(t, testDir, "img1.jpg")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "vacation")
+	_, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -220,7 +223,7 @@ func TestDirectoryRenamer_RenameDirectory_NoChange(t *testing.T) {
 
 func TestDirectoryRenamer_RenameDirectory_NonexistentDirectory(t *testing.T) {
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory("/nonexistent/directory", "newname")
+	_, err := renamer.RenameDirectory("/nonexistent/directory", "newname", SequenceByDate)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent directory, got nil")
@@ -237,7 +240,7 @@ func TestDirectoryRenamer_RenameDirectory_NotADirectory(t *testing.T) {
 	}
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(filePath, "newname")
+	_, err := renamer.RenameDirectory(filePath, "newname", SequenceByDate)
 
 	if err == nil {
 		t.Error("Expected error for file instead of directory, got nil")
@@ -251,7 +254,7 @@ func TestDirectoryRenamer_RenameDirectory_InvalidFormat(t *testing.T) {
 	testDir := createTestDirectory(t, tmpDir, "2023 06 June")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "newname")
+	_, err := renamer.RenameDirectory(testDir, "newname", SequenceByDate)
 
 	if err == nil {
 		t.Error("Expected error for invalid directory name format, got nil")
@@ -268,7 +271,7 @@ func TestDirectoryRenamer_RenameDirectory_TargetExists(t *testing.T) {
 	createTestDirectory(t, tmpDir, "2023 06 June 15 vacation")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "vacation")
+	_, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate)
 
 	if err == nil {
 		t.Error("Expected error when target directory already exists, got nil")
@@ -282,7 +285,7 @@ func TestDirectoryRenamer_RenameDirectory_NoFiles(t *testing.T) {
 	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "empty")
+	_, err := renamer.RenameDirectory(testDir, "empty", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -307,7 +310,7 @@ func TestDirectoryRenamer_RenameDirectory_PreservesExtensionCase(t *testing.T) {
 	createTestImage(t, testDir, "img3.HEIC")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "test")
+	_, err := renamer.RenameDirectory(testDir, "test", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -342,7 +345,7 @@ func TestDirectoryRenamer_RenameDirectory_SortsFilesAlphabetically(t *testing.T)
 	createTestImage(t, testDir, "mmm.jpg")
 
 	renamer := NewDirectoryRenamer(createTestExiftool(t))
-	err := renamer.RenameDirectory(testDir, "sorted")
+	_, err := renamer.RenameDirectory(testDir, "sorted", SequenceByDate)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -365,3 +368,73 @@ func TestDirectoryRenamer_RenameDirectory_SortsFilesAlphabetically(t *testing.T)
 		}
 	}
 }
+
+func TestDirectoryRenamer_UndoRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	videosDir := createTestDirectory(t, testDir, "videos")
+	createTestFileWithTime(t, testDir, "beach.jpg", parseTime(t, "2023-06-15T10:00:00Z"))
+	createTestFileWithTime(t, testDir, "dinner.jpg", parseTime(t, "2023-06-15T20:00:00Z"))
+	createTestFileWithTime(t, videosDir, "waves.mov", parseTime(t, "2023-06-15T11:00:00Z"))
+	renamer := &directoryRenamer{extensions: NewExtensions(), fileRenamer: newModTimeRenamer()}
+
+	if _, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate); err != nil {
+		t.Fatalf("RenameDirectory failed: %v", err)
+	}
+	renamedDir := filepath.Join(tmpDir, "2023 06 June 15 vacation")
+	if _, err := renamer.RenameDirectory(renamedDir, "holiday", SequenceByDate); err != nil {
+		t.Fatalf("RenameDirectory failed: %v", err)
+	}
+
+	// Each undo restores the names before the latest rename
+	restored, err := renamer.UndoRename(filepath.Join(tmpDir, "2023 06 June 15 holiday"))
+	if err != nil {
+		t.Fatalf("UndoRename failed: %v", err)
+	}
+	if restored != renamedDir {
+		t.Errorf("Expected %s restored, got %s", renamedDir, restored)
+	}
+	assertFilesExist(t, renamedDir, []string{"2023_06_June_15_vacation_00001.jpg", "2023_06_June_15_vacation_00002.jpg", "videos/2023_06_June_15_vacation_00001.mov"})
+
+	if _, err := renamer.UndoRename(renamedDir); err != nil {
+		t.Fatalf("UndoRename failed: %v", err)
+	}
+	assertFilesExist(t, testDir, []string{"beach.jpg", "dinner.jpg", "videos/waves.mov"})
+	// The journal goes once no rename is left to undo
+	if _, err := os.Stat(filepath.Join(testDir, renameJournalName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal removed, got %v", err)
+	}
+	if _, err := renamer.UndoRename(testDir); err == nil {
+		t.Error("Expected an error without a rename to undo")
+	}
+}
+
+func TestDirectoryRenamer_UndoRename_ChangedSinceRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	createTestFileWithTime(t, testDir, "beach.jpg", parseTime(t, "2023-06-15T10:00:00Z"))
+	createTestFileWithTime(t, testDir, "dinner.jpg", parseTime(t, "2023-06-15T20:00:00Z"))
+	renamer := &directoryRenamer{extensions: NewExtensions(), fileRenamer: newModTimeRenamer()}
+	if _, err := renamer.RenameDirectory(testDir, "vacation", SequenceByDate); err != nil {
+		t.Fatalf("RenameDirectory failed: %v", err)
+	}
+	renamedDir := filepath.Join(tmpDir, "2023 06 June 15 vacation")
+	if err := os.Remove(filepath.Join(renamedDir, "2023_06_June_15_vacation_00002.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := renamer.UndoRename(renamedDir); err == nil {
+		t.Error("Expected an error for a renamed file that is missing")
+	}
+	// Nothing is undone
+	assertFilesExist(t, renamedDir, []string{"2023_06_June_15_vacation_00001.jpg"})
+
+	// Renamed by hand since
+	movedDir := filepath.Join(tmpDir, "2023 06 June 15 beach")
+	if err := os.Rename(renamedDir, movedDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renamer.UndoRename(movedDir); err == nil {
+		t.Error("Expected an error for a directory renamed since")
+	}
+}
@@ -0,0 +1,88 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// validateStagingDir checks that a staging directory exists and is a directory.
+// An empty path means the system temp directory and is always valid.
+func validateStagingDir(stagingDir string) error {
+	if stagingDir == "" {
+		return nil
+	}
+	info, err := os.Stat(stagingDir)
+	if err != nil {
+		return fmt.Errorf("staging directory is not accessible: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("staging path is not a directory: %s", stagingDir)
+	}
+	return nil
+}
+
+// stagingSpace tracks the bytes reserved by in-flight archives in a staging directory,
+// so that concurrent workers don't each pass the free space check and then fill the volume together.
+type stagingSpace struct {
+	dir      string
+	mu       sync.Mutex
+	reserved int64
+}
+
+// newStagingSpace creates a stagingSpace for dir. An empty dir means the system temp directory.
+func newStagingSpace(dir string) *stagingSpace {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &stagingSpace{dir: dir}
+}
+
+// reserve claims size bytes of the staging volume, failing if they don't fit next to the
+// bytes already reserved. The returned function releases the reservation.
+func (s *stagingSpace) reserve(size int64) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	available, err := availableDiskSpace(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check free space in %s: %w", s.dir, err)
+	}
+	if size > 0 && uint64(s.reserved)+uint64(size) > available {
+		return nil, fmt.Errorf("not enough free space in %s: %d bytes required, %d bytes available, %d bytes reserved by other archives",
+			s.dir, size, available, s.reserved)
+	}
+	s.reserved += size
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.reserved -= size
+			s.mu.Unlock()
+		})
+	}, nil
+}
+
+// directorySize returns the total size in bytes of all regular files in a directory tree,
+// leaving out paths matched by skip.
+func directorySize(dir string, skip skipFunc) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && skip.skips(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
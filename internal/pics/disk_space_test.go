@@ -0,0 +1,146 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateStagingDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(filePath, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		stagingDir  string
+		expectError bool
+	}{
+		{name: "empty uses system temp", stagingDir: "", expectError: false},
+		{name: "existing directory", stagingDir: tmpDir, expectError: false},
+		{name: "nonexistent directory", stagingDir: filepath.Join(tmpDir, "missing"), expectError: true},
+		{name: "file instead of directory", stagingDir: filePath, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStagingDir(tt.stagingDir)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestAvailableDiskSpace(t *testing.T) {
+	available, err := availableDiskSpace(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if available == 0 {
+		t.Error("Expected some available space in temp directory")
+	}
+}
+
+func TestStagingSpace_Reserve(t *testing.T) {
+	space := newStagingSpace(t.TempDir())
+
+	release, err := space.reserve(1)
+	if err != nil {
+		t.Fatalf("Expected 1 byte to fit, got: %v", err)
+	}
+	release()
+	release()
+	if space.reserved != 0 {
+		t.Errorf("Expected reservation to be released once, got %d bytes reserved", space.reserved)
+	}
+
+	// No filesystem has 8 EiB free
+	_, err = space.reserve(1<<63 - 1)
+	if err == nil {
+		t.Fatal("Expected error for impossible space requirement")
+	}
+	if !strings.Contains(err.Error(), "not enough free space") {
+		t.Errorf("Expected free space error, got: %v", err)
+	}
+}
+
+func TestStagingSpace_ReserveAccountsForOtherReservations(t *testing.T) {
+	space := newStagingSpace(t.TempDir())
+	available, err := availableDiskSpace(space.dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Two archives that each fit on their own but not together
+	half := int64(available/2) + 1
+	release, err := space.reserve(half)
+	if err != nil {
+		t.Fatalf("Expected first reservation to fit, got: %v", err)
+	}
+	if _, err := space.reserve(half); err == nil {
+		t.Error("Expected second reservation to fail while the first is held")
+	}
+
+	release()
+	release, err = space.reserve(half)
+	if err != nil {
+		t.Errorf("Expected reservation to fit after release, got: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestStagingSpace_EmptyDirUsesSystemTemp(t *testing.T) {
+	space := newStagingSpace("")
+	if space.dir != os.TempDir() {
+		t.Errorf("Expected %s, got %s", os.TempDir(), space.dir)
+	}
+	if _, err := space.reserve(0); err != nil {
+		t.Errorf("Expected system temp directory to be checked without error, got: %v", err)
+	}
+}
+
+func TestStagingSpace_NonexistentDirectory(t *testing.T) {
+	space := newStagingSpace(filepath.Join(t.TempDir(), "missing"))
+	if _, err := space.reserve(1); err == nil {
+		t.Error("Expected error for nonexistent directory")
+	}
+}
+
+func TestDirectorySize(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.jpg"), make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	subDir := filepath.Join(tmpDir, "videos")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "b.mov"), make([]byte, 50), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	size, err := directorySize(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if size != 150 {
+		t.Errorf("Expected size 150, got %d", size)
+	}
+
+	// Skipped directories don't count towards the size
+	size, err = directorySize(tmpDir, excludeDirsSkip(tmpDir, []string{"videos"}))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if size != 100 {
+		t.Errorf("Expected size 100 with videos skipped, got %d", size)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package pics
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// availableDiskSpace returns the number of bytes available to the current user on the volume containing path.
+func availableDiskSpace(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	getDiskFreeSpaceEx := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+	var freeBytesAvailable uint64
+	ret, _, callErr := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if ret == 0 {
+		return 0, callErr
+	}
+	return freeBytesAvailable, nil
+}
@@ -0,0 +1,56 @@
+package pics
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// validateExcludePatterns checks that all exclude patterns are valid glob patterns.
+func validateExcludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isExcludedDir reports whether a directory matches any of the exclude patterns.
+//
+// relPath is the directory path relative to the backup source directory. Patterns use
+// glob syntax with "/" as separator and are matched against the whole relative path,
+// so "*Private*" excludes top-level directories and "*/private*" excludes
+// subdirectories inside any date directory.
+func isExcludedDir(relPath string, patterns []string) bool {
+	slashPath := filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, slashPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// skipFunc reports whether a path is left out of a backup. Archiving, counting and
+// size estimation share one skipFunc so that the S3 key describes the archive.
+type skipFunc func(path string, isDir bool) bool
+
+// skips is like calling s, but a nil skipFunc skips nothing.
+func (s skipFunc) skips(path string, isDir bool) bool {
+	return s != nil && s(path, isDir)
+}
+
+// excludeDirsSkip returns a skipFunc that skips directories under sourceDir matching patterns.
+func excludeDirsSkip(sourceDir string, patterns []string) skipFunc {
+	return func(path string, isDir bool) bool {
+		if !isDir {
+			return false
+		}
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return false
+		}
+		return isExcludedDir(relPath, patterns)
+	}
+}
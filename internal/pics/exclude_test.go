@@ -0,0 +1,88 @@
+package pics
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateExcludePatterns(t *testing.T) {
+	tests := []struct {
+		name        string
+		patterns    []string
+		expectError bool
+	}{
+		{name: "no patterns", patterns: nil, expectError: false},
+		{name: "valid patterns", patterns: []string{"*private*", "*/private*", "2023 06 June 15 Wedding"}, expectError: false},
+		{name: "invalid pattern", patterns: []string{"[unclosed"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExcludePatterns(tt.patterns)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsExcludedDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		relPath  string
+		patterns []string
+		expected bool
+	}{
+		{name: "no patterns", relPath: "2023 06 June 15", patterns: nil, expected: false},
+		{name: "exact top-level match", relPath: "2023 06 June 15 Wedding", patterns: []string{"2023 06 June 15 Wedding"}, expected: true},
+		{name: "wildcard top-level match", relPath: "2023 06 June 15 Private", patterns: []string{"*Private*"}, expected: true},
+		{name: "wildcard does not cross separator", relPath: "2023 06 June 15/private", patterns: []string{"private*"}, expected: false},
+		{name: "nested match", relPath: "2023 06 June 15/private stuff", patterns: []string{"*/private*"}, expected: true},
+		{name: "nested pattern ignores top level", relPath: "private", patterns: []string{"*/private*"}, expected: false},
+		{name: "case sensitive", relPath: "2023 06 June 15 private", patterns: []string{"*Private*"}, expected: false},
+		{name: "one of several patterns", relPath: "2024 01 January 01", patterns: []string{"*Private*", "2024 *"}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isExcludedDir(tt.relPath, tt.patterns); result != tt.expected {
+				t.Errorf("isExcludedDir(%q, %v) = %v, expected %v", tt.relPath, tt.patterns, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExcludeDirsSkip(t *testing.T) {
+	sourceDir := filepath.Join("photos")
+	skip := excludeDirsSkip(sourceDir, []string{"*/videos", "*Private*"})
+
+	tests := []struct {
+		name     string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{name: "nested excluded directory", path: filepath.Join(sourceDir, "2023 06 June 15", "videos"), isDir: true, expected: true},
+		{name: "top-level excluded directory", path: filepath.Join(sourceDir, "2023 07 July 01 Private"), isDir: true, expected: true},
+		{name: "file with matching name", path: filepath.Join(sourceDir, "2023 06 June 15", "videos"), isDir: false, expected: false},
+		{name: "kept directory", path: filepath.Join(sourceDir, "2023 06 June 15"), isDir: true, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skip.skips(tt.path, tt.isDir); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSkipFunc_Nil(t *testing.T) {
+	var skip skipFunc
+	if skip.skips("anything", true) {
+		t.Error("Expected nil skipFunc to skip nothing")
+	}
+}
@@ -1,9 +1,17 @@
 package pics
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/acm19/pics/internal/logger"
 	"github.com/barasher/go-exiftool"
@@ -12,23 +20,175 @@ import (
 const (
 	// ExifOriginalFileName is the EXIF field name for storing the original filename
 	ExifOriginalFileName = "OriginalFileName"
+	// ExifOriginalFileNameHistory is the custom XMP list the append-history policy keeps
+	// replaced OriginalFileName values in, oldest first
+	ExifOriginalFileNameHistory = "XMP-pics:OriginalFileNameHistory"
+	// ExifKeywords is the XMP list keywords are added to, read by Lightroom, digiKam and most
+	// photo managers
+	ExifKeywords = "XMP-dc:Subject"
+	// ExifDateCirca is the custom XMP tag holding the approximate date assigned to a scan, as
+	// precisely as it was given (e.g. "1994-07"), since its EXIF dates look exact
+	ExifDateCirca = "XMP-pics:DateCirca"
+	// ExifSourcePath is the custom XMP tag holding the path an image was imported from
+	ExifSourcePath = "XMP-pics:SourcePath"
+	// ExifImportDate is the custom XMP tag holding when an image was imported
+	ExifImportDate = "XMP-pics:ImportDate"
+	// ExifPicsVersion is the custom XMP tag holding the version of pics that imported an image
+	ExifPicsVersion = "XMP-pics:PicsVersion"
+	// ExifCompressionQuality is the custom XMP tag holding the JPEG quality an image was
+	// compressed to on import, absent if it wasn't compressed
+	ExifCompressionQuality = "XMP-pics:CompressionQuality"
 )
 
+// Provenance records how an image was imported, for ImportMetadata
+type Provenance struct {
+	// SourcePath is the path the image was imported from
+	SourcePath string
+	// ImportDate is when the image was imported
+	ImportDate time.Time
+	// Version is the version of pics that imported the image, left out if empty
+	Version string
+	// CompressionQuality is the JPEG quality the image was compressed to (0 = not compressed)
+	CompressionQuality int
+}
+
+// ImportMetadata is the metadata an import adds to an image, for WriteImportMetadata
+type ImportMetadata struct {
+	// OriginalFileName is written as WriteOriginalFileName does, with OriginalNamePolicy, if set
+	OriginalFileName   string
+	OriginalNamePolicy OriginalNamePolicy
+	// Keywords are added as AddKeywords does
+	Keywords []string
+	// Date, if set, is written as WriteApproximateDate does
+	Date *ApproximateDate
+	// Provenance, if set, records how the image was imported in its XMP metadata
+	Provenance *Provenance
+}
+
+// OriginalNamePolicy decides what happens when a file already carries an OriginalFileName
+// that differs from its name, e.g. one written by another tool
+type OriginalNamePolicy string
+
+const (
+	// OriginalNameKeep leaves the existing OriginalFileName untouched
+	OriginalNameKeep OriginalNamePolicy = "keep"
+	// OriginalNameOverwrite replaces the existing OriginalFileName with the file's name
+	OriginalNameOverwrite OriginalNamePolicy = "overwrite"
+	// OriginalNameAppendHistory replaces the existing OriginalFileName with the file's name
+	// and appends the replaced value to ExifOriginalFileNameHistory
+	OriginalNameAppendHistory OriginalNamePolicy = "append-history"
+)
+
+// ParseOriginalNamePolicy parses "keep", "overwrite" or "append-history"
+func ParseOriginalNamePolicy(s string) (OriginalNamePolicy, error) {
+	switch policy := OriginalNamePolicy(s); policy {
+	case OriginalNameKeep, OriginalNameOverwrite, OriginalNameAppendHistory:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid original name policy %q (expected keep, overwrite or append-history)", s)
+}
+
+// exiftoolConfig defines the pics XMP namespace holding ExifOriginalFileNameHistory,
+// ExifDateCirca and the provenance tags, which exiftool can't write without it
+const exiftoolConfig = `%Image::ExifTool::UserDefined = (
+    'Image::ExifTool::XMP::Main' => {
+        pics => { SubDirectory => { TagTable => 'Image::ExifTool::UserDefined::pics' } },
+    },
+);
+%Image::ExifTool::UserDefined::pics = (
+    GROUPS => { 0 => 'XMP', 1 => 'XMP-pics', 2 => 'Image' },
+    NAMESPACE => { 'pics' => 'https://github.com/acm19/pics/ns/1.0/' },
+    WRITABLE => 'string',
+    OriginalFileNameHistory => { List => 'Seq' },
+    DateCirca => { },
+    SourcePath => { },
+    ImportDate => { Writable => 'date' },
+    PicsVersion => { },
+    CompressionQuality => { Writable => 'integer' },
+);
+1;
+`
+
+// exiftoolUpdatedPattern matches the count of files exiftool reports it wrote
+var exiftoolUpdatedPattern = regexp.MustCompile(`(\d+) image files? updated`)
+
+// exiftoolConditionFailedStatus is the exit status of exiftool when every file failed its -if
+// condition, so none was written
+const exiftoolConditionFailedStatus = 2
+
+var (
+	exiftoolConfigOnce sync.Once
+	exiftoolConfigFile string
+	exiftoolConfigErr  error
+)
+
+// exiftoolConfigPath writes exiftoolConfig to a file of its own in the temp directory once per
+// process and returns its path, so concurrent runs never share or replace each other's file
+func exiftoolConfigPath() (string, error) {
+	exiftoolConfigOnce.Do(func() {
+		file, err := os.CreateTemp("", "pics-exiftool-*.config")
+		if err != nil {
+			exiftoolConfigErr = err
+			return
+		}
+		_, err = file.WriteString(exiftoolConfig)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			exiftoolConfigErr = err
+			return
+		}
+		exiftoolConfigFile = file.Name()
+	})
+	return exiftoolConfigFile, exiftoolConfigErr
+}
+
 // ExifWriter defines the interface for writing EXIF metadata
 type ExifWriter interface {
 	// WriteOriginalFileNameIfMissing writes the original filename to EXIF metadata
 	// if it doesn't already exist. Only processes image files (JPG, JPEG, HEIC, PNG).
 	// Returns true if the field was written, false if it already exists or file is not an image.
-	WriteOriginalFileNameIfMissing(filePath string, originalFileName string) (bool, error)
+	// Cancelling ctx kills the exiftool process writing the field.
+	WriteOriginalFileNameIfMissing(ctx context.Context, filePath string, originalFileName string) (bool, error)
+	// WriteOriginalFileNamesIfMissing writes the current name of each file to its EXIF metadata
+	// as OriginalFileName if it doesn't already exist, as WriteOriginalFileNameIfMissing does,
+	// with a single exiftool run for all of them. Only processes image files. Returns the number
+	// of files written; a file that can't be written is reported in the error without stopping
+	// the others.
+	WriteOriginalFileNamesIfMissing(ctx context.Context, filePaths []string) (int, error)
+	// WriteOriginalFileName writes the original filename to EXIF metadata, resolving an
+	// existing, different OriginalFileName with policy. Only processes image files.
+	// Returns true if the field was written.
+	WriteOriginalFileName(ctx context.Context, filePath string, originalFileName string, policy OriginalNamePolicy) (bool, error)
+	// AddKeywords adds keywords to the XMP metadata of an image, skipping those it already has.
+	// Only processes image files. Returns true if the file was written.
+	AddKeywords(ctx context.Context, filePath string, keywords []string) (bool, error)
+	// WriteApproximateDate writes the first day of date as the capture date of an image, and
+	// date itself to ExifDateCirca. Only processes image files. Returns true if the file was written.
+	WriteApproximateDate(ctx context.Context, filePath string, date ApproximateDate) (bool, error)
+	// WriteImportMetadata writes the metadata an import adds to an image, with a single exiftool
+	// run. Only processes image files. Returns true if the file was written.
+	WriteImportMetadata(ctx context.Context, filePath string, metadata ImportMetadata) (bool, error)
+	// WriteDimensions writes width and height to the EXIF pixel dimensions of an image, and to
+	// the XMP ones if it has them, such as after resizing it. Only processes image files.
+	// Returns true if the file was written.
+	WriteDimensions(ctx context.Context, filePath string, width, height int) (bool, error)
+	// CopyMetadata copies the metadata of the image at srcPath to dstPath, such as an exported
+	// copy, leaving out embedded thumbnails and, if stripGPS is set, the GPS position.
+	CopyMetadata(ctx context.Context, srcPath, dstPath string, stripGPS bool) error
 }
 
 // exifWriter implements the ExifWriter interface
 type exifWriter struct {
-	et         *exiftool.Exiftool
-	extensions Extensions
+	et           *exiftool.Exiftool
+	extensions   Extensions
+	exiftoolPath string
+	env          []string
 }
 
-// NewExifWriter creates a new ExifWriter instance
+// NewExifWriter creates a new ExifWriter instance writing with the system exiftool
 func NewExifWriter(et *exiftool.Exiftool) ExifWriter {
 	return &exifWriter{
 		et:         et,
@@ -36,8 +196,96 @@ func NewExifWriter(et *exiftool.Exiftool) ExifWriter {
 	}
 }
 
+// NewExifWriterWithPath creates a new ExifWriter writing with the exiftool at exiftoolPath, run
+// with env added to the environment, e.g. PERL5LIB for the lib directory of a bundled exiftool
+func NewExifWriterWithPath(et *exiftool.Exiftool, exiftoolPath string, env []string) ExifWriter {
+	return &exifWriter{
+		et:           et,
+		extensions:   NewExtensions(),
+		exiftoolPath: exiftoolPath,
+		env:          env,
+	}
+}
+
+// command returns the exiftool command writing with args
+func (w *exifWriter) command(ctx context.Context, args ...string) *exec.Cmd {
+	exiftoolPath := w.exiftoolPath
+	if exiftoolPath == "" {
+		exiftoolPath = "exiftool" // Use system PATH
+	}
+	cmd := exec.CommandContext(ctx, exiftoolPath, args...)
+	if len(w.env) > 0 {
+		cmd.Env = append(os.Environ(), w.env...)
+	}
+	return cmd
+}
+
 // WriteOriginalFileNameIfMissing writes the original filename to EXIF metadata if it doesn't already exist
-func (w *exifWriter) WriteOriginalFileNameIfMissing(filePath string, originalFileName string) (bool, error) {
+func (w *exifWriter) WriteOriginalFileNameIfMissing(ctx context.Context, filePath string, originalFileName string) (bool, error) {
+	return w.WriteOriginalFileName(ctx, filePath, originalFileName, OriginalNameKeep)
+}
+
+// WriteOriginalFileNamesIfMissing writes the name of each file to EXIF metadata if it doesn't
+// already exist, in one exiftool run
+func (w *exifWriter) WriteOriginalFileNamesIfMissing(ctx context.Context, filePaths []string) (int, error) {
+	var batch []string
+	written := 0
+	var errs []error
+	for _, filePath := range filePaths {
+		if !w.extensions.IsImage(filePath) {
+			continue
+		}
+		// An argument file holds one argument per line, trimmed of surrounding white space, and
+		// skips lines starting with #, so the few paths it can't hold are written one by one
+		if strings.ContainsAny(filePath, "\r\n") || strings.TrimSpace(filePath) != filePath || strings.HasPrefix(filePath, "#") {
+			ok, err := w.WriteOriginalFileNameIfMissing(ctx, filePath, filepath.Base(filePath))
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				written++
+			}
+			continue
+		}
+		batch = append(batch, filePath)
+	}
+	if len(batch) == 0 {
+		return written, errors.Join(errs...)
+	}
+
+	argFile, err := os.CreateTemp("", ".pics-exiftool-args-*")
+	if err != nil {
+		return written, fmt.Errorf("failed to create exiftool argument file: %w", err)
+	}
+	defer os.Remove(argFile.Name())
+	_, err = argFile.WriteString(strings.Join(batch, "\n") + "\n")
+	if closeErr := argFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, fmt.Errorf("failed to write exiftool argument file: %w", err)
+	}
+
+	// The file name is copied into the field of the files that don't have it, so exiftool
+	// decides which to write without reading their metadata first
+	args := []string{"-m", "-if", "not defined $" + ExifOriginalFileName, "-" + ExifOriginalFileName + "<FileName",
+		"-overwrite_original", "-P", "-@", argFile.Name()}
+	output, err := w.command(ctx, args...).CombinedOutput()
+	if match := exiftoolUpdatedPattern.FindSubmatch(output); match != nil {
+		n, _ := strconv.Atoi(string(match[1]))
+		written += n
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == exiftoolConditionFailedStatus) {
+		errs = append(errs, fmt.Errorf("failed to write %s: %w (output: %s)", ExifOriginalFileName, err, string(output)))
+	}
+
+	logger.Debug("Wrote OriginalFileName to EXIF", "files", len(batch), "written", written)
+	return written, errors.Join(errs...)
+}
+
+// WriteOriginalFileName writes the original filename to EXIF metadata, resolving an existing,
+// different OriginalFileName with policy (an empty policy keeps it)
+func (w *exifWriter) WriteOriginalFileName(ctx context.Context, filePath string, originalFileName string, policy OriginalNamePolicy) (bool, error) {
 	if w.et == nil {
 		return false, fmt.Errorf("exiftool not initialised")
 	}
@@ -48,31 +296,198 @@ func (w *exifWriter) WriteOriginalFileNameIfMissing(filePath string, originalFil
 		return false, nil
 	}
 
+	args, withConfig := w.originalFileNameArgs(filePath, originalFileName, policy)
+	if len(args) == 0 {
+		return false, nil
+	}
+	if err := w.write(ctx, filePath, ExifOriginalFileName, withConfig, args); err != nil {
+		return false, err
+	}
+
+	logger.Debug("Wrote OriginalFileName to EXIF", "file", originalFileName)
+	return true, nil
+}
+
+// originalFileNameArgs returns the exiftool arguments writing originalFileName to the image at
+// filePath as policy resolves the name it has, if any, and whether they need exiftoolConfig.
+// There are none when the image keeps its name.
+func (w *exifWriter) originalFileNameArgs(filePath, originalFileName string, policy OriginalNamePolicy) ([]string, bool) {
+	args := []string{"-" + ExifOriginalFileName + "=" + originalFileName}
+
 	// Check if the field already exists
 	fileInfos := w.et.ExtractMetadata(filePath)
 	if len(fileInfos) > 0 && fileInfos[0].Err == nil {
-		if _, err := fileInfos[0].GetString(ExifOriginalFileName); err == nil {
-			logger.Debug("OriginalFileName already exists, skipping", "file", filepath.Base(filePath))
-			return false, nil
+		if existing, err := fileInfos[0].GetString(ExifOriginalFileName); err == nil {
+			if existing == originalFileName || policy == OriginalNameKeep || policy == "" {
+				logger.Debug("OriginalFileName already exists, skipping", "file", filepath.Base(filePath), "existing", existing)
+				return nil, false
+			}
+			logger.Debug("Replacing existing OriginalFileName", "file", filepath.Base(filePath), "existing", existing, "policy", policy)
+			if policy == OriginalNameAppendHistory {
+				return append(args, "-"+ExifOriginalFileNameHistory+"+="+existing), true
+			}
+		}
+	}
+	return args, false
+}
+
+// AddKeywords adds keywords to the XMP metadata of an image, skipping those it already has
+func (w *exifWriter) AddKeywords(ctx context.Context, filePath string, keywords []string) (bool, error) {
+	if !w.extensions.IsImage(filePath) || len(keywords) == 0 {
+		return false, nil
+	}
+
+	if err := w.write(ctx, filePath, ExifKeywords, false, keywordArgs(keywords)); err != nil {
+		return false, err
+	}
+
+	logger.Debug("Wrote keywords to XMP", "file", filepath.Base(filePath), "keywords", keywords)
+	return true, nil
+}
+
+// keywordArgs returns the exiftool arguments adding keywords. Removing each keyword before
+// adding it keeps keywords the file already has from repeating.
+func keywordArgs(keywords []string) []string {
+	var args []string
+	for _, keyword := range keywords {
+		args = append(args, "-"+ExifKeywords+"-="+keyword, "-"+ExifKeywords+"+="+keyword)
+	}
+	return args
+}
+
+// WriteApproximateDate writes the first day of date as the capture date of an image, and date
+// itself to ExifDateCirca
+func (w *exifWriter) WriteApproximateDate(ctx context.Context, filePath string, date ApproximateDate) (bool, error) {
+	if !w.extensions.IsImage(filePath) {
+		return false, nil
+	}
+
+	if err := w.write(ctx, filePath, "approximate date", true, approximateDateArgs(date)); err != nil {
+		return false, err
+	}
+
+	logger.Debug("Wrote approximate date to EXIF", "file", filepath.Base(filePath), "date", date)
+	return true, nil
+}
+
+// approximateDateArgs returns the exiftool arguments writing date, which need exiftoolConfig
+func approximateDateArgs(date ApproximateDate) []string {
+	exifDate := date.Date.Format("2006:01:02 15:04:05")
+	return []string{"-DateTimeOriginal=" + exifDate, "-CreateDate=" + exifDate, "-" + ExifDateCirca + "=" + date.String()}
+}
+
+// provenanceArgs returns the exiftool arguments writing provenance, which need exiftoolConfig
+func provenanceArgs(provenance Provenance) []string {
+	args := []string{
+		"-" + ExifSourcePath + "=" + provenance.SourcePath,
+		"-" + ExifImportDate + "=" + provenance.ImportDate.Format("2006:01:02 15:04:05-07:00")}
+	if provenance.Version != "" {
+		args = append(args, "-"+ExifPicsVersion+"="+provenance.Version)
+	}
+	if provenance.CompressionQuality > 0 {
+		args = append(args, "-"+ExifCompressionQuality+"="+strconv.Itoa(provenance.CompressionQuality))
+	}
+	return args
+}
+
+// WriteImportMetadata writes the metadata an import adds to an image with a single exiftool run
+func (w *exifWriter) WriteImportMetadata(ctx context.Context, filePath string, metadata ImportMetadata) (bool, error) {
+	if !w.extensions.IsImage(filePath) {
+		return false, nil
+	}
+
+	var args []string
+	withConfig := false
+	if metadata.OriginalFileName != "" {
+		if w.et == nil {
+			return false, fmt.Errorf("exiftool not initialised")
 		}
+		args, withConfig = w.originalFileNameArgs(filePath, metadata.OriginalFileName, metadata.OriginalNamePolicy)
+	}
+	args = append(args, keywordArgs(metadata.Keywords)...)
+	if metadata.Date != nil {
+		args = append(args, approximateDateArgs(*metadata.Date)...)
+		withConfig = true
+	}
+	if metadata.Provenance != nil {
+		args = append(args, provenanceArgs(*metadata.Provenance)...)
+		withConfig = true
+	}
+	if len(args) == 0 {
+		return false, nil
+	}
+	if err := w.write(ctx, filePath, "import metadata", withConfig, args); err != nil {
+		return false, err
 	}
 
-	// Use exiftool command-line to write the OriginalFileName tag
+	logger.Debug("Wrote import metadata", "file", filepath.Base(filePath), "original", metadata.OriginalFileName, "keywords", metadata.Keywords)
+	return true, nil
+}
+
+// write runs exiftool writing args to filePath in place, with exiftoolConfig if withConfig is
+// set, reporting a failure to write what
+func (w *exifWriter) write(ctx context.Context, filePath, what string, withConfig bool, args []string) error {
 	// -overwrite_original prevents creating backup files
 	// -P preserves the file modification date/time
 	// -m ignores minor errors (e.g., truncated IFD directories in older files)
-	cmd := exec.Command("exiftool",
-		"-m",
-		"-"+ExifOriginalFileName+"="+originalFileName,
-		"-overwrite_original",
-		"-P",
-		filePath)
+	args = append(append([]string{"-m"}, args...), "-overwrite_original", "-P", filePath)
+	if withConfig {
+		config, err := exiftoolConfigPath()
+		if err != nil {
+			return fmt.Errorf("failed to write exiftool config: %w", err)
+		}
+		// -config must come before any other argument
+		args = append([]string{"-config", config}, args...)
+	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := w.command(ctx, args...).CombinedOutput()
 	if err != nil {
-		return false, fmt.Errorf("failed to write %s: %w (output: %s)", ExifOriginalFileName, err, string(output))
+		return fmt.Errorf("failed to write %s: %w (output: %s)", what, err, string(output))
 	}
+	return nil
+}
 
-	logger.Debug("Wrote OriginalFileName to EXIF", "file", originalFileName)
+// WriteDimensions writes width and height to the pixel dimensions the metadata of an image records
+func (w *exifWriter) WriteDimensions(ctx context.Context, filePath string, width, height int) (bool, error) {
+	if w.et == nil {
+		return false, fmt.Errorf("exiftool not initialised")
+	}
+	if !w.extensions.IsImage(filePath) {
+		return false, nil
+	}
+
+	// ExifImageWidth and ExifImageHeight are exiftool's names for PixelXDimension and
+	// PixelYDimension of the EXIF IFD. The XMP copies are only updated, not added.
+	args := []string{"-ExifIFD:ExifImageWidth=" + strconv.Itoa(width), "-ExifIFD:ExifImageHeight=" + strconv.Itoa(height)}
+	fileInfos := w.et.ExtractMetadata(filePath)
+	if len(fileInfos) > 0 && fileInfos[0].Err == nil {
+		if _, err := fileInfos[0].GetString("PixelXDimension"); err == nil {
+			args = append(args, "-XMP-exif:PixelXDimension="+strconv.Itoa(width), "-XMP-exif:PixelYDimension="+strconv.Itoa(height))
+		}
+	}
+	if err := w.write(ctx, filePath, "dimensions", false, args); err != nil {
+		return false, err
+	}
+
+	logger.Debug("Wrote dimensions to EXIF", "file", filepath.Base(filePath), "width", width, "height", height)
 	return true, nil
 }
+
+// CopyMetadata copies the metadata of srcPath to dstPath
+func (w *exifWriter) CopyMetadata(ctx context.Context, srcPath, dstPath string, stripGPS bool) error {
+	// Embedded previews would undo the size savings of the copy, and its pixel size is its own
+	args := []string{"-m", "-TagsFromFile", srcPath, "-all:all",
+		"--ThumbnailImage", "--PreviewImage", "--ExifImageWidth", "--ExifImageHeight"}
+	if stripGPS {
+		args = append(args, "--GPS:all", "--XMP-exif:GPS*")
+	}
+	cmd := w.command(ctx, append(args, "-overwrite_original", "-P", dstPath)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to copy metadata: %w (output: %s)", err, string(output))
+	}
+
+	logger.Debug("Copied metadata", "file", filepath.Base(srcPath), "dest", dstPath, "strip_gps", stripGPS)
+	return nil
+}
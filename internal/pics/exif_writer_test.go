@@ -1,8 +1,13 @@
 package pics
 
 import (
+	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -19,7 +24,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_FirstTime(t *testing.T) {
 	testFile := createValidJPEG(t, tmpDir, "test_image.jpg")
 
 	writer := NewExifWriter(createTestExiftool(t))
-	written, err := writer.WriteOriginalFileNameIfMissing(testFile, "test_image.jpg")
+	written, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "test_image.jpg")
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -37,7 +42,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_AlreadyExists(t *testing.T) {
 	writer := NewExifWriter(createTestExiftool(t))
 
 	// First write
-	written1, err := writer.WriteOriginalFileNameIfMissing(testFile, "test_image.jpg")
+	written1, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "test_image.jpg")
 	if err != nil {
 		t.Fatalf("First write failed: %v", err)
 	}
@@ -46,7 +51,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_AlreadyExists(t *testing.T) {
 	}
 
 	// Second write (should skip)
-	written2, err := writer.WriteOriginalFileNameIfMissing(testFile, "test_image.jpg")
+	written2, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "test_image.jpg")
 	if err != nil {
 		t.Errorf("Second write failed: %v", err)
 	}
@@ -63,7 +68,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_PreservesOriginal(t *testing.
 	writer := NewExifWriter(createTestExiftool(t))
 
 	// Write the original filename
-	_, err := writer.WriteOriginalFileNameIfMissing(testFile, originalName)
+	_, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, originalName)
 	if err != nil {
 		t.Fatalf("Failed to write EXIF: %v", err)
 	}
@@ -76,7 +81,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_PreservesOriginal(t *testing.
 	}
 
 	// Try to write again with new filename (should not overwrite)
-	written, err := writer.WriteOriginalFileNameIfMissing(newPath, newName)
+	written, err := writer.WriteOriginalFileNameIfMissing(context.Background(), newPath, newName)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -109,7 +114,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_MultipleCalls(t *testing.T) {
 
 	// Multiple writes should all succeed but only first should write
 	for i := 0; i < 3; i++ {
-		written, err := writer.WriteOriginalFileNameIfMissing(testFile, "photo.jpg")
+		written, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "photo.jpg")
 		if err != nil {
 			t.Errorf("Call %d failed: %v", i+1, err)
 		}
@@ -139,7 +144,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_DifferentExtensions(t *testin
 			testFile := createValidJPEG(t, tmpDir, tc.filename)
 			writer := NewExifWriter(createTestExiftool(t))
 
-			written, err := writer.WriteOriginalFileNameIfMissing(testFile, tc.filename)
+			written, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, tc.filename)
 			if err != nil {
 				t.Errorf("Failed for %s: %v", tc.filename, err)
 			}
@@ -155,7 +160,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_NonexistentFile(t *testing.T)
 	nonexistentFile := filepath.Join(tmpDir, "nonexistent.jpg")
 
 	writer := NewExifWriter(createTestExiftool(t))
-	_, err := writer.WriteOriginalFileNameIfMissing(nonexistentFile, "nonexistent.jpg")
+	_, err := writer.WriteOriginalFileNameIfMissing(context.Background(), nonexistentFile, "nonexistent.jpg")
 
 	if err == nil {
 		t.Error("Expected error for nonexistent file")
@@ -172,7 +177,7 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_SkipsVideoFiles(t *testing.T)
 			testFile := createFile(t, tmpDir, "video"+ext)
 			writer := NewExifWriter(createTestExiftool(t))
 
-			written, err := writer.WriteOriginalFileNameIfMissing(testFile, "video"+ext)
+			written, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "video"+ext)
 
 			if err != nil {
 				t.Errorf("Expected no error for video file, got: %v", err)
@@ -190,10 +195,306 @@ func TestExifWriter_WriteOriginalFileNameIfMissing_InvalidJPEG(t *testing.T) {
 	testFile := createFile(t, tmpDir, "invalid.jpg")
 
 	writer := NewExifWriter(createTestExiftool(t))
-	_, err := writer.WriteOriginalFileNameIfMissing(testFile, "invalid.jpg")
+	_, err := writer.WriteOriginalFileNameIfMissing(context.Background(), testFile, "invalid.jpg")
 
 	// Should return an error because the file is not a valid JPEG
 	if err == nil {
 		t.Error("Expected error for invalid JPEG file")
 	}
 }
+
+func TestExifWriter_WriteOriginalFileNamesIfMissing(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := createValidJPEG(t, tmpDir, "IMG_0001.jpg")
+	second := createValidJPEG(t, tmpDir, "IMG 0002.JPG")
+	video := createFile(t, tmpDir, "MOV_0003.mp4")
+
+	et := createTestExiftool(t)
+	writer := NewExifWriter(et)
+	if _, err := writer.WriteOriginalFileNameIfMissing(context.Background(), second, "DSC_0002.jpg"); err != nil {
+		t.Fatalf("Failed to write EXIF: %v", err)
+	}
+
+	// Only the image without an OriginalFileName is written, and videos are skipped
+	written, err := writer.WriteOriginalFileNamesIfMissing(context.Background(), []string{first, second, video})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if written != 1 {
+		t.Errorf("Expected 1 file written, got %d", written)
+	}
+	for path, want := range map[string]string{first: "IMG_0001.jpg", second: "DSC_0002.jpg"} {
+		fileInfos := et.ExtractMetadata(path)
+		if len(fileInfos) == 0 {
+			t.Fatalf("No metadata found for %s", path)
+		}
+		if got, _ := fileInfos[0].GetString(ExifOriginalFileName); got != want {
+			t.Errorf("Expected %s of %s to be %s, got %s", ExifOriginalFileName, filepath.Base(path), want, got)
+		}
+	}
+
+	// Nothing is left to write the second time
+	written, err = writer.WriteOriginalFileNamesIfMissing(context.Background(), []string{first, second})
+	if err != nil || written != 0 {
+		t.Errorf("Expected nothing written, got %d and %v", written, err)
+	}
+}
+
+func TestExifWriter_UsesExiftoolPathAndEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available, skipping test")
+	}
+	tmpDir := t.TempDir()
+	photo := createFile(t, tmpDir, "photo.jpg")
+
+	// A stand-in for a bundled exiftool recording its lib directory and the file it wrote
+	record := filepath.Join(tmpDir, "record.txt")
+	exiftoolPath := filepath.Join(tmpDir, "bin", "exiftool")
+	if err := os.MkdirAll(filepath.Dir(exiftoolPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\nfor last; do :; done\necho \"$PERL5LIB $last\" > '" + record + "'\n"
+	if err := os.WriteFile(exiftoolPath, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake exiftool: %v", err)
+	}
+
+	writer := NewExifWriterWithPath(nil, exiftoolPath, []string{"PERL5LIB=/opt/pics/lib"})
+	written, err := writer.AddKeywords(context.Background(), photo, []string{"Wedding"})
+	if err != nil || !written {
+		t.Fatalf("Expected keywords written, got %v and %v", written, err)
+	}
+	got, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatalf("Expected the given exiftool to run: %v", err)
+	}
+	if want := "/opt/pics/lib " + photo; strings.TrimSpace(string(got)) != want {
+		t.Errorf("Expected exiftool run with %q, got %q", want, got)
+	}
+}
+
+// readOriginalFileNameHistory reads the replaced OriginalFileName values of a file
+func readOriginalFileNameHistory(t *testing.T, filePath string) string {
+	t.Helper()
+	config, err := exiftoolConfigPath()
+	if err != nil {
+		t.Fatalf("Failed to write exiftool config: %v", err)
+	}
+	output, err := exec.Command("exiftool", "-config", config, "-s3", "-sep", ",", "-"+ExifOriginalFileNameHistory, filePath).Output()
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", ExifOriginalFileNameHistory, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestExifWriter_WriteOriginalFileName_Policies(t *testing.T) {
+	tests := []struct {
+		policy          OriginalNamePolicy
+		expectedWritten bool
+		expectedName    string
+		expectedHistory string
+	}{
+		{policy: OriginalNameKeep, expectedWritten: false, expectedName: "DSC_0001.jpg"},
+		{policy: "", expectedWritten: false, expectedName: "DSC_0001.jpg"},
+		{policy: OriginalNameOverwrite, expectedWritten: true, expectedName: "IMG_0001.jpg"},
+		{policy: OriginalNameAppendHistory, expectedWritten: true, expectedName: "IMG_0001.jpg", expectedHistory: "DSC_0001.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			testFile := createValidJPEG(t, t.TempDir(), "IMG_0001.jpg")
+			writer := NewExifWriter(createTestExiftool(t))
+
+			// Another tool already stored a different name
+			if _, err := writer.WriteOriginalFileName(context.Background(), testFile, "DSC_0001.jpg", OriginalNameKeep); err != nil {
+				t.Fatalf("Failed to write existing name: %v", err)
+			}
+
+			written, err := writer.WriteOriginalFileName(context.Background(), testFile, "IMG_0001.jpg", tt.policy)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if written != tt.expectedWritten {
+				t.Errorf("Expected written %v, got %v", tt.expectedWritten, written)
+			}
+
+			fileInfos := createTestExiftool(t).ExtractMetadata(testFile)
+			if name, _ := fileInfos[0].GetString(ExifOriginalFileName); name != tt.expectedName {
+				t.Errorf("Expected %s to be %s, got %s", ExifOriginalFileName, tt.expectedName, name)
+			}
+			if history := readOriginalFileNameHistory(t, testFile); history != tt.expectedHistory {
+				t.Errorf("Expected history %q, got %q", tt.expectedHistory, history)
+			}
+		})
+	}
+}
+
+func TestExifWriter_WriteOriginalFileName_AppendHistoryKeepsOrder(t *testing.T) {
+	testFile := createValidJPEG(t, t.TempDir(), "IMG_0001.jpg")
+	writer := NewExifWriter(createTestExiftool(t))
+
+	for _, name := range []string{"first.jpg", "second.jpg", "IMG_0001.jpg", "IMG_0001.jpg"} {
+		if _, err := writer.WriteOriginalFileName(context.Background(), testFile, name, OriginalNameAppendHistory); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// Writing the name already stored leaves the history alone
+	if history := readOriginalFileNameHistory(t, testFile); history != "first.jpg,second.jpg" {
+		t.Errorf("Expected history first.jpg,second.jpg, got %q", history)
+	}
+}
+
+func TestParseOriginalNamePolicy(t *testing.T) {
+	for _, policy := range []OriginalNamePolicy{OriginalNameKeep, OriginalNameOverwrite, OriginalNameAppendHistory} {
+		got, err := ParseOriginalNamePolicy(string(policy))
+		if err != nil || got != policy {
+			t.Errorf("Expected %s, got %s (error: %v)", policy, got, err)
+		}
+	}
+	for _, invalid := range []string{"", "Keep", "append"} {
+		if _, err := ParseOriginalNamePolicy(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestExiftoolConfigPath(t *testing.T) {
+	path, err := exiftoolConfigPath()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if string(data) != exiftoolConfig {
+		t.Error("Expected the config file to hold exiftoolConfig")
+	}
+	if again, err := exiftoolConfigPath(); err != nil || again != path {
+		t.Errorf("Expected the same config file for the whole process, got %s (%v)", again, err)
+	}
+}
+
+func TestExifWriter_AddKeywords(t *testing.T) {
+	testFile := createValidJPEG(t, t.TempDir(), "photo.jpg")
+	writer := NewExifWriter(createTestExiftool(t))
+
+	for _, keywords := range [][]string{{"Wedding", "Ceremony"}, {"Wedding", "Family"}} {
+		written, err := writer.AddKeywords(context.Background(), testFile, keywords)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !written {
+			t.Errorf("Expected keywords %v to be written", keywords)
+		}
+	}
+
+	// Keywords the file already has are not repeated
+	output, err := exec.Command("exiftool", "-s3", "-sep", ",", "-"+ExifKeywords, testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", ExifKeywords, err)
+	}
+	keywords := strings.Split(strings.TrimSpace(string(output)), ",")
+	sort.Strings(keywords)
+	if expected := []string{"Ceremony", "Family", "Wedding"}; !reflect.DeepEqual(keywords, expected) {
+		t.Errorf("Expected keywords %v, got %v", expected, keywords)
+	}
+}
+
+func TestExifWriter_AddKeywords_SkipsVideoFiles(t *testing.T) {
+	testFile := createFile(t, t.TempDir(), "video.mov")
+	written, err := NewExifWriter(nil).AddKeywords(context.Background(), testFile, []string{"Wedding"})
+	if err != nil || written {
+		t.Errorf("Expected video files to be skipped, got written %v, error %v", written, err)
+	}
+}
+
+func TestExifWriter_WriteApproximateDate(t *testing.T) {
+	testFile := createValidJPEG(t, t.TempDir(), "scan.jpg")
+	date, err := ParseApproximateDate("1994-07")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := NewExifWriter(createTestExiftool(t)).WriteApproximateDate(context.Background(), testFile, date)
+	if err != nil || !written {
+		t.Fatalf("Expected the date written, got written %v, error %v", written, err)
+	}
+
+	config, err := exiftoolConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("exiftool", "-config", config, "-s3", "-DateTimeOriginal", "-"+ExifDateCirca, testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to read the dates: %v", err)
+	}
+	if expected := "1994:07:01 00:00:00\n1994-07\n"; string(output) != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestExifWriter_WriteDimensions(t *testing.T) {
+	testFile := createValidJPEG(t, t.TempDir(), "photo.jpg")
+
+	written, err := NewExifWriter(createTestExiftool(t)).WriteDimensions(context.Background(), testFile, 80, 40)
+	if err != nil || !written {
+		t.Fatalf("Expected the dimensions written, got written %v, error %v", written, err)
+	}
+
+	output, err := exec.Command("exiftool", "-s3", "-ExifIFD:ExifImageWidth", "-ExifIFD:ExifImageHeight", testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to read the dimensions: %v", err)
+	}
+	if expected := "80\n40\n"; string(output) != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestExifWriter_WriteApproximateDate_SkipsVideoFiles(t *testing.T) {
+	testFile := createFile(t, t.TempDir(), "video.mov")
+	written, err := NewExifWriter(nil).WriteApproximateDate(context.Background(), testFile, ApproximateDate{})
+	if err != nil || written {
+		t.Errorf("Expected video files to be skipped, got written %v, error %v", written, err)
+	}
+}
+
+func TestExifWriter_WriteImportMetadata(t *testing.T) {
+	testFile := createValidJPEG(t, t.TempDir(), "IMG_0001.jpg")
+	metadata := ImportMetadata{
+		OriginalFileName: "IMG_0001.jpg",
+		Keywords:         []string{"Wedding"},
+		Provenance: &Provenance{
+			SourcePath:         "/media/card/DCIM/100CANON/IMG_0001.JPG",
+			ImportDate:         time.Date(2024, 6, 15, 10, 30, 0, 0, time.FixedZone("CEST", 2*60*60)),
+			Version:            "1.4.0",
+			CompressionQuality: 50,
+		},
+	}
+
+	written, err := NewExifWriter(createTestExiftool(t)).WriteImportMetadata(context.Background(), testFile, metadata)
+	if err != nil || !written {
+		t.Fatalf("Expected the metadata written, got written %v, error %v", written, err)
+	}
+
+	config, err := exiftoolConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("exiftool", "-config", config, "-s3", "-"+ExifOriginalFileName, "-"+ExifKeywords,
+		"-"+ExifSourcePath, "-"+ExifImportDate, "-"+ExifPicsVersion, "-"+ExifCompressionQuality, testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to read the metadata: %v", err)
+	}
+	if expected := "IMG_0001.jpg\nWedding\n/media/card/DCIM/100CANON/IMG_0001.JPG\n2024:06:15 10:30:00+02:00\n1.4.0\n50\n"; string(output) != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestExifWriter_WriteImportMetadata_SkipsVideoFiles(t *testing.T) {
+	testFile := createFile(t, t.TempDir(), "video.mov")
+	written, err := NewExifWriter(nil).WriteImportMetadata(context.Background(), testFile, ImportMetadata{OriginalFileName: "video.mov"})
+	if err != nil || written {
+		t.Errorf("Expected video files to be skipped, got written %v, error %v", written, err)
+	}
+}
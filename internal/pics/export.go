@@ -0,0 +1,218 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// ExportOptions holds settings for exporting copies of images.
+type ExportOptions struct {
+	// MaxDimension is the size in pixels of the longest side of the copies (0 = keep the size).
+	// Smaller images are never enlarged.
+	MaxDimension int
+	// Quality is the JPEG quality the copies are saved with (0-100).
+	Quality int
+	// StripGPS leaves the GPS position out of the metadata of the copies.
+	StripGPS bool
+	// Flatten writes every copy straight into the output directory instead of keeping the
+	// directories of the images.
+	Flatten bool
+}
+
+// exportPresets are named ExportOptions for the uses copies are made for
+var exportPresets = map[string]ExportOptions{
+	// phone makes copies small enough to sync back to a phone or send through messaging apps,
+	// without giving away where they were taken
+	"phone": {MaxDimension: 2048, Quality: 80, StripGPS: true, Flatten: true},
+}
+
+// ParseExportPreset returns the options of an export preset: "phone"
+func ParseExportPreset(s string) (ExportOptions, error) {
+	opts, ok := exportPresets[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return ExportOptions{}, fmt.Errorf("invalid export preset %q (expected one of %s)", s, strings.Join(ExportPresets(), ", "))
+	}
+	return opts, nil
+}
+
+// ExportPresets returns the names of the export presets, sorted
+func ExportPresets() []string {
+	names := make([]string, 0, len(exportPresets))
+	for name := range exportPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportReport holds what an export made
+type ExportReport struct {
+	// Exported is the number of copies written
+	Exported int
+	// ExportedBytes is the size of the copies
+	ExportedBytes int64
+	// Skipped lists the files that couldn't be exported, such as videos and HEIC or RAW images,
+	// which can't be decoded
+	Skipped []string
+}
+
+// ImageExporter writes resized copies of images for sharing outside the library
+type ImageExporter interface {
+	// Export writes a JPEG copy of each of files, paths relative to dir, to outDir as opts says.
+	// Copies keep the metadata of their image. Cancelling ctx stops the export.
+	Export(ctx context.Context, dir string, files []string, outDir string, opts ExportOptions) (ExportReport, error)
+}
+
+// imageExporter implements the ImageExporter interface
+type imageExporter struct {
+	exifWriter ExifWriter
+	extensions Extensions
+}
+
+// NewImageExporter creates a new ImageExporter copying metadata with exifWriter
+func NewImageExporter(exifWriter ExifWriter) ImageExporter {
+	return &imageExporter{
+		exifWriter: exifWriter,
+		extensions: NewExtensions(),
+	}
+}
+
+// Export writes the copies of files to outDir. outDir may not be inside dir, where the copies
+// would be taken for images of the library.
+func (e *imageExporter) Export(ctx context.Context, dir string, files []string, outDir string, opts ExportOptions) (ExportReport, error) {
+	if isSameOrNested(outDir, dir) {
+		return ExportReport{}, fmt.Errorf("export directory %s is inside %s", outDir, dir)
+	}
+	if err := ValidateJPEGQuality(opts.Quality); err != nil {
+		return ExportReport{}, err
+	}
+
+	var report ExportReport
+	names := make(map[string]bool)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		srcPath := filepath.Join(dir, file)
+		if !e.extensions.IsImage(srcPath) {
+			logger.Debug("Skipping file that isn't an image", "file", file)
+			report.Skipped = append(report.Skipped, file)
+			continue
+		}
+
+		dstPath := filepath.Join(outDir, exportName(file, opts.Flatten, names))
+		size, err := e.exportImage(ctx, srcPath, dstPath, opts)
+		if err != nil {
+			logger.Warn("Skipping image that can't be exported", "file", file, "error", err)
+			report.Skipped = append(report.Skipped, file)
+			continue
+		}
+		logger.Debug("Exported image", "file", file, "dest", dstPath)
+		report.Exported++
+		report.ExportedBytes += size
+	}
+	return report, nil
+}
+
+// exportImage writes the resized copy of the image at srcPath to dstPath, returning its size
+func (e *imageExporter) exportImage(ctx context.Context, srcPath, dstPath string, opts ExportOptions) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode %s: %w", srcPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), libraryDirMode); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, libraryFileMode)
+	if err != nil {
+		return 0, err
+	}
+	if err := jpeg.Encode(dst, downscale(img, opts.MaxDimension), &jpeg.Options{Quality: opts.Quality}); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return 0, fmt.Errorf("failed to encode %s: %w", dstPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dstPath)
+		return 0, err
+	}
+
+	// The encoder writes no metadata, so a copy that didn't get it has no GPS position either
+	if err := e.exifWriter.CopyMetadata(ctx, srcPath, dstPath, opts.StripGPS); err != nil {
+		logger.Warn("Failed to copy metadata, the copy has no date or orientation", "file", srcPath, "error", err)
+	}
+	if info, err := os.Stat(srcPath); err == nil {
+		os.Chtimes(dstPath, info.ModTime(), info.ModTime())
+	}
+
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// exportName returns the path of the copy of file within the output directory, always with a
+// .jpg extension. Flattened copies take the name of their image, or their whole path joined
+// with dashes when another copy took that name already, which names records.
+func exportName(file string, flatten bool, names map[string]bool) string {
+	name := strings.TrimSuffix(file, filepath.Ext(file)) + ".jpg"
+	if !flatten {
+		return name
+	}
+	flat := filepath.Base(name)
+	if names[flat] {
+		flat = strings.ReplaceAll(name, string(filepath.Separator), "-")
+	}
+	names[flat] = true
+	return flat
+}
+
+// downscale returns img shrunk to fit within maxDimension pixels on its longest side, each new
+// pixel the average of the pixels it covers. Images that fit already are returned as they are.
+func downscale(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return img
+	}
+
+	newWidth, newHeight := maxDimension, height*maxDimension/width
+	if height > width {
+		newWidth, newHeight = width*maxDimension/height, maxDimension
+	}
+	newWidth, newHeight = max(newWidth, 1), max(newHeight, 1)
+
+	scaled := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/newHeight, bounds.Min.Y+(y+1)*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/newWidth, bounds.Min.X+(x+1)*width/newWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			scaled.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,153 @@
+package pics
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestImage writes a width x height image to path, as a PNG if its extension says so,
+// otherwise as a JPEG
+func writeTestImage(t *testing.T, path string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func imageSize(t *testing.T, path string) (int, int) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("Expected a JPEG at %s: %v", path, err)
+	}
+	return config.Width, config.Height
+}
+
+func TestParseExportPreset(t *testing.T) {
+	opts, err := ParseExportPreset("Phone")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := ExportOptions{MaxDimension: 2048, Quality: 80, StripGPS: true, Flatten: true}
+	if opts != expected {
+		t.Errorf("Expected %+v, got %+v", expected, opts)
+	}
+	if _, err := ParseExportPreset("tablet"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	tests := []struct {
+		width, height, maxDimension   int
+		expectedWidth, expectedHeight int
+	}{
+		{400, 300, 200, 200, 150},
+		{300, 400, 200, 150, 200},
+		{100, 50, 200, 100, 50},
+		{400, 300, 0, 400, 300},
+		{1000, 2, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		img := downscale(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.maxDimension)
+		if got := img.Bounds(); got.Dx() != tt.expectedWidth || got.Dy() != tt.expectedHeight {
+			t.Errorf("downscale(%dx%d, %d) = %dx%d, expected %dx%d", tt.width, tt.height, tt.maxDimension, got.Dx(), got.Dy(), tt.expectedWidth, tt.expectedHeight)
+		}
+	}
+
+	// Each pixel is the average of those it covers
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(1, 0, color.Gray{Y: 200})
+	if r, _, _, _ := downscale(src, 1).At(0, 0).RGBA(); r>>8 != 100 {
+		t.Errorf("Expected the average of 0 and 200, got %d", r>>8)
+	}
+}
+
+func TestExportName(t *testing.T) {
+	names := make(map[string]bool)
+	got := []string{
+		exportName(filepath.Join("2023 06 June 15", "2023_06_June_15_00001.JPG"), true, names),
+		exportName(filepath.Join("2023 06 June 15", "2023_06_June_15_00002.png"), true, names),
+		exportName(filepath.Join("2023 06 June 15 Beach", "2023_06_June_15_00001.jpg"), true, names),
+		exportName(filepath.Join("2023 06 June 15", "2023_06_June_15_00003.jpeg"), false, names),
+	}
+	expected := []string{
+		"2023_06_June_15_00001.jpg",
+		"2023_06_June_15_00002.jpg",
+		"2023 06 June 15 Beach-2023_06_June_15_00001.jpg",
+		filepath.Join("2023 06 June 15", "2023_06_June_15_00003.jpg"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestImageExporter_Export(t *testing.T) {
+	libraryDir, outDir := t.TempDir(), t.TempDir()
+	writeTestImage(t, filepath.Join(libraryDir, "2023 06 June 15", "2023_06_June_15_00001.jpg"), 300, 200)
+	writeTestImage(t, filepath.Join(libraryDir, "2023 06 June 15", "2023_06_June_15_00002.png"), 50, 100)
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15", "videos"), "2023_06_June_15_00001.mov", "video")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), "2023_06_June_15_00003.heic", "heic")
+	files := []string{
+		filepath.Join("2023 06 June 15", "2023_06_June_15_00001.jpg"),
+		filepath.Join("2023 06 June 15", "2023_06_June_15_00002.png"),
+		filepath.Join("2023 06 June 15", "2023_06_June_15_00003.heic"),
+		filepath.Join("2023 06 June 15", "videos", "2023_06_June_15_00001.mov"),
+	}
+
+	// Without exiftool the copies are exported without metadata
+	exporter := NewImageExporter(NewExifWriter(nil))
+	opts := ExportOptions{MaxDimension: 100, Quality: 80, StripGPS: true, Flatten: true}
+	report, err := exporter.Export(testCtx, libraryDir, files, outDir, opts)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if width, height := imageSize(t, filepath.Join(outDir, "2023_06_June_15_00001.jpg")); width != 100 || height != 66 {
+		t.Errorf("Expected the JPEG shrunk to 100x66, got %dx%d", width, height)
+	}
+	if width, height := imageSize(t, filepath.Join(outDir, "2023_06_June_15_00002.jpg")); width != 50 || height != 100 {
+		t.Errorf("Expected the PNG kept at 50x100, got %dx%d", width, height)
+	}
+	if report.Exported != 2 || report.ExportedBytes == 0 || !reflect.DeepEqual(report.Skipped, files[2:]) {
+		t.Errorf("Expected 2 images exported and the HEIC and video skipped, got %+v", report)
+	}
+}
+
+func TestImageExporter_Export_InsideLibrary(t *testing.T) {
+	libraryDir := t.TempDir()
+	exporter := NewImageExporter(NewExifWriter(nil))
+	if _, err := exporter.Export(testCtx, libraryDir, nil, filepath.Join(libraryDir, "phone"), ExportOptions{Quality: 80}); err == nil {
+		t.Error("Expected an error for an export directory inside the library")
+	}
+}
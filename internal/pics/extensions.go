@@ -1,9 +1,12 @@
 package pics
 
 import (
+	"fmt"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
+	"sync"
 )
 
 // Extensions defines the interface for file extension operations.
@@ -24,26 +27,106 @@ type extensions struct {
 	videoExts []string
 }
 
-// NewExtensions creates a new Extensions instance.
+// defaultImageExts are the image formats supported unless SetExtensions says otherwise,
+// including WebP and AVIF, which ParseOptions.OutputFormats converts images to, so every
+// command supports a library holding them.
+var defaultImageExts = []string{".jpg", ".jpeg", ".heic", ".png", ".webp", ".avif"}
+
+// defaultVideoExts are the video formats supported unless SetExtensions says otherwise.
+var defaultVideoExts = []string{
+	".mov",   // QuickTime
+	".mp4",   // MPEG-4
+	".avi",   // Audio Video Interleave
+	".mkv",   // Matroska Video Container
+	".webm",  // WebM Video
+	".flv",   // Flash Video
+	".wmv",   // Windows Media Video
+	".m4v",   // MPEG-4 Video (Apple)
+	".3gp",   // 3GPP (mobile)
+	".m2ts",  // MPEG-2 Transport Stream
+	".mts",   // MPEG-2 Transport Stream
+	".ogv",   // Ogg Theora Video
+	".ts",    // MPEG-2 Transport Stream
+	".mod",   // JVC Everio MPEG-2 Video
+	".tod",   // JVC Everio MPEG-2 Transport Stream
+}
+
+// OptionalImageExtensions are image formats that aren't supported by default but can be added
+// with SetExtensions: GIF and the RAW formats of Canon (.cr2), Nikon (.nef), Sony (.arw), Adobe
+// (.dng) and Fujifilm (.raf). They are imported as they are.
+var OptionalImageExtensions = []string{".gif", ".cr2", ".nef", ".arw", ".dng", ".raf"}
+
+var (
+	configMu sync.RWMutex
+	// configuredImageExts and configuredVideoExts are the formats set by SetExtensions (nil = defaults)
+	configuredImageExts []string
+	configuredVideoExts []string
+)
+
+// NewExtensions creates a new Extensions instance with the formats set by SetExtensions, or the
+// default ones.
 func NewExtensions() Extensions {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	imageExts, videoExts := defaultImageExts, defaultVideoExts
+	if configuredImageExts != nil {
+		imageExts, videoExts = configuredImageExts, configuredVideoExts
+	}
+	return NewExtensionsWithConfig(imageExts, videoExts)
+}
+
+// NewExtensionsWithConfig creates a new Extensions instance supporting the given image and video
+// extensions, such as ".cr2" or "MTS", matched regardless of case.
+func NewExtensionsWithConfig(imageExts, videoExts []string) Extensions {
 	return &extensions{
-		imageExts: []string{".jpg", ".jpeg", ".heic", ".png"},
-		videoExts: []string{
-			".mov",   // QuickTime
-			".mp4",   // MPEG-4
-			".avi",   // Audio Video Interleave
-			".mkv",   // Matroska Video Container
-			".webm",  // WebM Video
-			".flv",   // Flash Video
-			".wmv",   // Windows Media Video
-			".m4v",   // MPEG-4 Video (Apple)
-			".3gp",   // 3GPP (mobile)
-			".m2ts",  // MPEG-2 Transport Stream
-			".mts",   // MPEG-2 Transport Stream
-			".ogv",   // Ogg Theora Video
-			".ts",    // MPEG-2 Transport Stream
-		},
+		imageExts: normaliseExtensions(imageExts),
+		videoExts: normaliseExtensions(videoExts),
+	}
+}
+
+// SetExtensions makes NewExtensions support the given image and video extensions instead of the
+// default ones, so every operation agrees on which files are media. Call it before starting any.
+func SetExtensions(imageExts, videoExts []string) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	configuredImageExts = normaliseExtensions(imageExts)
+	configuredVideoExts = normaliseExtensions(videoExts)
+}
+
+// DefaultImageExtensions returns the image formats supported by default.
+func DefaultImageExtensions() []string {
+	return slices.Clone(defaultImageExts)
+}
+
+// DefaultVideoExtensions returns the video formats supported by default.
+func DefaultVideoExtensions() []string {
+	return slices.Clone(defaultVideoExts)
+}
+
+// extensionPattern matches a file extension without its leading dot, such as "cr2"
+var extensionPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// ParseExtension parses a file extension given with or without its leading dot, such as "CR2",
+// into the form Extensions uses, ".cr2".
+func ParseExtension(value string) (string, error) {
+	ext := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), ".")
+	if !extensionPattern.MatchString(ext) {
+		return "", fmt.Errorf("invalid file extension %q: expected letters and digits, e.g. cr2", value)
+	}
+	return "." + ext, nil
+}
+
+// normaliseExtensions returns exts in lower case with their leading dot
+func normaliseExtensions(exts []string) []string {
+	normalised := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalised = append(normalised, ext)
 	}
+	return normalised
 }
 
 // IsImage returns true if the file extension is a supported image format.
@@ -19,6 +19,8 @@ func TestExtensions_IsImage(t *testing.T) {
 		{"photo.HEIC", true},
 		{"photo.png", true},
 		{"photo.PNG", true},
+		{"photo.webp", true},
+		{"photo.AVIF", true},
 		{"video.mov", false},
 		{"video.mp4", false},
 		{"document.txt", false},
@@ -184,6 +186,8 @@ func TestExtensions_IsVideo_AllFormats(t *testing.T) {
 		"test.mts", "test.MTS",
 		"test.ogv", "test.OGV",
 		"test.ts", "test.TS",
+		"test.mod", "test.MOD",
+		"test.tod", "test.TOD",
 	}
 
 	for _, format := range videoFormats {
@@ -198,3 +202,55 @@ func TestExtensions_IsVideo_AllFormats(t *testing.T) {
 		}
 	}
 }
+
+func TestNewExtensionsWithConfig(t *testing.T) {
+	ext := NewExtensionsWithConfig([]string{".jpg", "CR2", ".NEF"}, []string{"mov"})
+
+	for _, image := range []string{"IMG_0001.CR2", "DSC_0001.nef", "photo.jpg"} {
+		if !ext.IsImage(image) || !ext.IsSupported(image) {
+			t.Errorf("Expected %s to be recognised as image", image)
+		}
+	}
+	if !ext.IsVideo("clip.MOV") {
+		t.Error("Expected clip.MOV to be recognised as video")
+	}
+	for _, unsupported := range []string{"photo.heic", "clip.mp4"} {
+		if ext.IsSupported(unsupported) {
+			t.Errorf("Expected %s to NOT be supported outside the configured formats", unsupported)
+		}
+	}
+	if ext.IsJPEG("IMG_0001.CR2") {
+		t.Error("Expected RAW files to NOT be recognised as JPEG")
+	}
+}
+
+func TestSetExtensions(t *testing.T) {
+	t.Cleanup(func() { SetExtensions(DefaultImageExtensions(), DefaultVideoExtensions()) })
+
+	if NewExtensions().IsSupported("IMG_0001.dng") {
+		t.Error("Expected RAW files to NOT be supported by default")
+	}
+	SetExtensions(append(DefaultImageExtensions(), OptionalImageExtensions...), DefaultVideoExtensions())
+	ext := NewExtensions()
+	for _, image := range []string{"anim.gif", "IMG_0001.CR2", "DSC_0001.NEF", "DSC00001.ARW", "IMG_0001.DNG", "DSCF0001.RAF", "photo.jpg"} {
+		if !ext.IsImage(image) {
+			t.Errorf("Expected %s to be recognised as image once configured", image)
+		}
+	}
+	if !ext.IsVideo("clip.mov") {
+		t.Error("Expected videos still supported")
+	}
+}
+
+func TestParseExtension(t *testing.T) {
+	for value, expected := range map[string]string{"cr2": ".cr2", ".NEF": ".nef", " webp ": ".webp"} {
+		if ext, err := ParseExtension(value); err != nil || ext != expected {
+			t.Errorf("ParseExtension(%q) = %q, %v, expected %q", value, ext, err, expected)
+		}
+	}
+	for _, value := range []string{"", ".", "tar.gz", "../jpg", "*"} {
+		if _, err := ParseExtension(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
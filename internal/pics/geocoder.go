@@ -0,0 +1,298 @@
+package pics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// DefaultGeocoderURL is the reverse geocoding endpoint of OpenStreetMap's Nominatim
+const DefaultGeocoderURL = "https://nominatim.openstreetmap.org/reverse"
+
+// earthRadiusKm is the mean radius of the Earth, for distances between coordinates
+const earthRadiusKm = 6371.0
+
+// GeocoderOptions holds configuration options for naming places after GPS coordinates.
+type GeocoderOptions struct {
+	// PlacesFile is a reverse geocoding database to look places up in offline instead of asking
+	// URL: a GeoNames dump such as cities1000.txt, or a CSV file of name,latitude,longitude
+	// ("" = ask URL).
+	PlacesFile string
+	// MaxDistanceKm is how far from a place of PlacesFile a position may be to be named after it.
+	MaxDistanceKm float64
+	// URL is the address of a reverse geocoding API compatible with Nominatim's /reverse.
+	URL string
+	// Interval is the least time between two requests to URL, as the public Nominatim allows
+	// one request a second.
+	Interval time.Duration
+	// Timeout is how long to wait for URL to answer a request.
+	Timeout time.Duration
+}
+
+// DefaultGeocoderOptions returns the default geocoder options.
+func DefaultGeocoderOptions() GeocoderOptions {
+	return GeocoderOptions{
+		PlacesFile:    "",
+		MaxDistanceKm: 50,
+		URL:           DefaultGeocoderURL,
+		Interval:      time.Second,
+		Timeout:       30 * time.Second,
+	}
+}
+
+// Geocoder defines the interface for naming the place at GPS coordinates
+type Geocoder interface {
+	// PlaceName returns the name of the town or city at the given latitude and longitude, in
+	// decimal degrees, or "" if it knows none
+	PlaceName(ctx context.Context, latitude, longitude float64) (string, error)
+}
+
+// NewGeocoder creates the Geocoder described by opts: an offline one over PlacesFile if it is
+// set, otherwise one asking URL
+func NewGeocoder(opts GeocoderOptions) (Geocoder, error) {
+	if opts.PlacesFile != "" {
+		return loadOfflineGeocoder(opts.PlacesFile, opts.MaxDistanceKm)
+	}
+	if _, err := url.ParseRequestURI(opts.URL); err != nil {
+		return nil, fmt.Errorf("invalid geocoder URL %q: %w", opts.URL, err)
+	}
+	return &httpGeocoder{
+		url:      opts.URL,
+		interval: opts.Interval,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// place is a named position of an offline reverse geocoding database
+type place struct {
+	name      string
+	latitude  float64
+	longitude float64
+}
+
+// offlineGeocoder implements the Geocoder interface by picking the nearest place of a database
+type offlineGeocoder struct {
+	places        []place
+	maxDistanceKm float64
+}
+
+// loadOfflineGeocoder reads the places of a GeoNames dump (tab separated, name in the second
+// column and coordinates in the fifth and sixth) or of a CSV file of name,latitude,longitude.
+// Lines that are neither, such as a CSV header, are skipped.
+func loadOfflineGeocoder(path string, maxDistanceKm float64) (Geocoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open places file: %w", err)
+	}
+	defer f.Close()
+
+	var places []place
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if p, ok := parsePlace(scanner.Text()); ok {
+			places = append(places, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read places file: %w", err)
+	}
+	if len(places) == 0 {
+		return nil, fmt.Errorf("no places found in %s", filepath.Base(path))
+	}
+	logger.Info("Loaded places", "file", path, "places", len(places))
+	return &offlineGeocoder{places: places, maxDistanceKm: maxDistanceKm}, nil
+}
+
+// parsePlace parses a line of a GeoNames dump or of a CSV file of name,latitude,longitude
+func parsePlace(line string) (place, bool) {
+	var name, lat, lon string
+	if fields := strings.Split(line, "\t"); len(fields) >= 6 {
+		name, lat, lon = fields[1], fields[4], fields[5]
+	} else if fields := strings.Split(line, ","); len(fields) == 3 {
+		name, lat, lon = fields[0], fields[1], fields[2]
+	} else {
+		return place{}, false
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || math.Abs(latitude) > 90 {
+		return place{}, false
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || math.Abs(longitude) > 180 {
+		return place{}, false
+	}
+	name = strings.Trim(strings.TrimSpace(name), `"`)
+	if name == "" {
+		return place{}, false
+	}
+	return place{name: name, latitude: latitude, longitude: longitude}, true
+}
+
+// PlaceName returns the nearest place within the maximum distance
+func (g *offlineGeocoder) PlaceName(ctx context.Context, latitude, longitude float64) (string, error) {
+	nearest, nearestKm := "", math.Inf(1)
+	for _, p := range g.places {
+		if km := distanceKm(latitude, longitude, p.latitude, p.longitude); km < nearestKm {
+			nearest, nearestKm = p.name, km
+		}
+	}
+	if nearestKm > g.maxDistanceKm {
+		return "", nil
+	}
+	return nearest, nil
+}
+
+// distanceKm returns the great-circle distance between two positions in decimal degrees
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := toRadians(lat2-lat1), toRadians(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// httpGeocoder implements the Geocoder interface over a Nominatim compatible reverse geocoding API
+type httpGeocoder struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// nominatimPlace is the part of a Nominatim reverse geocoding response naming the place
+type nominatimPlace struct {
+	Error   string `json:"error"`
+	Name    string `json:"name"`
+	Address struct {
+		City         string `json:"city"`
+		Town         string `json:"town"`
+		Village      string `json:"village"`
+		Municipality string `json:"municipality"`
+	} `json:"address"`
+}
+
+// PlaceName asks the API for the place at the coordinates, waiting for the interval since the
+// previous request first
+func (g *httpGeocoder) PlaceName(ctx context.Context, latitude, longitude float64) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("format", "jsonv2")
+	query.Set("lat", strconv.FormatFloat(latitude, 'f', 6, 64))
+	query.Set("lon", strconv.FormatFloat(longitude, 'f', 6, 64))
+	// Zoom 10 asks for the city rather than the street
+	query.Set("zoom", "10")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create geocoder request: %w", err)
+	}
+	req.Header.Set("User-Agent", "pics (https://github.com/acm19/pics)")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to ask geocoder: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("geocoder refused the request: %s %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var result nominatimPlace
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode geocoder response: %w", err)
+	}
+	// Positions at sea or otherwise unknown come back as an error with a 200 status
+	if result.Error != "" {
+		logger.Debug("No place at position", "latitude", latitude, "longitude", longitude, "reason", result.Error)
+		return "", nil
+	}
+	for _, name := range []string{result.Address.City, result.Address.Town, result.Address.Village, result.Address.Municipality, result.Name} {
+		if name != "" {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// wait blocks until the interval since the previous request has passed
+func (g *httpGeocoder) wait(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if delay := time.Until(g.last.Add(g.interval)); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	g.last = time.Now()
+	return nil
+}
+
+// gpsCoordinatePattern matches a coordinate as exiftool prints it, e.g. 41 deg 23' 24.00" N
+var gpsCoordinatePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?) deg (\d+(?:\.\d+)?)' (\d+(?:\.\d+)?)"\s*([NSEW])?$`)
+
+// parseGPSCoordinate parses a GPS latitude or longitude read by exiftool, in degrees, minutes
+// and seconds or in decimal degrees, optionally followed by its reference (N, S, E or W)
+func parseGPSCoordinate(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if match := gpsCoordinatePattern.FindStringSubmatch(value); match != nil {
+		degrees, _ := strconv.ParseFloat(match[1], 64)
+		minutes, _ := strconv.ParseFloat(match[2], 64)
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		return gpsSign(match[4]) * (degrees + minutes/60 + seconds/3600), nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && (len(fields[1]) != 1 || !strings.Contains("NSEW", fields[1]))) {
+		return 0, fmt.Errorf("invalid GPS coordinate %q", value)
+	}
+	coordinate, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GPS coordinate %q", value)
+	}
+	if len(fields) == 2 {
+		coordinate = gpsSign(fields[1]) * coordinate
+	}
+	return coordinate, nil
+}
+
+// gpsSign returns -1 for the references of southern latitudes and western longitudes, 1 otherwise
+func gpsSign(ref string) float64 {
+	if ref == "S" || ref == "W" {
+		return -1
+	}
+	return 1
+}
+
+// placeDirName turns a place name into the name of a directory: without path separators or
+// control characters, and with single spaces
+func placeDirName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
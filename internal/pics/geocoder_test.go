@@ -0,0 +1,167 @@
+package pics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGPSCoordinate(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+	}{
+		{`41 deg 23' 24.00" N`, 41.39},
+		{`2 deg 10' 12.00" E`, 2.17},
+		{`33 deg 52' 12.00" S`, -33.87},
+		{`0 deg 7' 48.00" W`, -0.13},
+		{"41.39", 41.39},
+		{"-0.13", -0.13},
+		{"33.87 S", -33.87},
+	}
+	for _, tt := range tests {
+		got, err := parseGPSCoordinate(tt.value)
+		if err != nil {
+			t.Errorf("parseGPSCoordinate(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if math.Abs(got-tt.expected) > 0.0001 {
+			t.Errorf("parseGPSCoordinate(%q) = %f, expected %f", tt.value, got, tt.expected)
+		}
+	}
+
+	for _, value := range []string{"", "north", "41.39 X", `41 deg N`} {
+		if _, err := parseGPSCoordinate(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestOfflineGeocoder(t *testing.T) {
+	dir := t.TempDir()
+	geonames := filepath.Join(dir, "cities1000.txt")
+	data := "3128760\tBarcelona\tBarcelona\tBCN\t41.38879\t2.15899\tP\tPPLA\tES\n" +
+		"2643743\tLondon\tLondon\t\t51.50853\t-0.12574\tP\tPPLC\tGB\n"
+	if err := os.WriteFile(geonames, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	csv := filepath.Join(dir, "places.csv")
+	if err := os.WriteFile(csv, []byte("name,latitude,longitude\n\"Sydney\",-33.86785,151.20732\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultGeocoderOptions()
+	opts.PlacesFile = geonames
+	geocoder, err := NewGeocoder(opts)
+	if err != nil {
+		t.Fatalf("NewGeocoder failed: %v", err)
+	}
+	if place, _ := geocoder.PlaceName(testCtx, 41.40, 2.17); place != "Barcelona" {
+		t.Errorf("Expected Barcelona, got %q", place)
+	}
+	if place, _ := geocoder.PlaceName(testCtx, 51.45, -0.10); place != "London" {
+		t.Errorf("Expected London, got %q", place)
+	}
+	// Far from every place, such as in the middle of the Atlantic
+	if place, _ := geocoder.PlaceName(testCtx, 40, -30); place != "" {
+		t.Errorf("Expected no place, got %q", place)
+	}
+
+	opts.PlacesFile = csv
+	geocoder, err = NewGeocoder(opts)
+	if err != nil {
+		t.Fatalf("NewGeocoder failed: %v", err)
+	}
+	if place, _ := geocoder.PlaceName(testCtx, -33.87, 151.21); place != "Sydney" {
+		t.Errorf("Expected Sydney, got %q", place)
+	}
+
+	empty := filepath.Join(dir, "empty.csv")
+	if err := os.WriteFile(empty, []byte("name,latitude,longitude\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.PlacesFile = empty
+	if _, err := NewGeocoder(opts); err == nil {
+		t.Error("Expected an error for a file without places")
+	}
+}
+
+func TestHTTPGeocoder(t *testing.T) {
+	var query, userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, userAgent = r.URL.RawQuery, r.Header.Get("User-Agent")
+		switch r.URL.Query().Get("lat") {
+		case "41.390000":
+			w.Write([]byte(`{"name":"Barcelona","address":{"city":"Barcelona","country":"España"}}`))
+		case "42.100000":
+			w.Write([]byte(`{"name":"Sant Pere","address":{"village":"Sant Pere de Ribes"}}`))
+		default:
+			w.Write([]byte(`{"error":"Unable to geocode"}`))
+		}
+	}))
+	defer server.Close()
+
+	opts := DefaultGeocoderOptions()
+	opts.URL = server.URL + "/reverse"
+	opts.Interval = 0
+	geocoder, err := NewGeocoder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	place, err := geocoder.PlaceName(testCtx, 41.39, 2.17)
+	if err != nil {
+		t.Fatalf("PlaceName failed: %v", err)
+	}
+	if place != "Barcelona" {
+		t.Errorf("Expected Barcelona, got %q", place)
+	}
+	if query != "format=jsonv2&lat=41.390000&lon=2.170000&zoom=10" || userAgent == "" {
+		t.Errorf("Unexpected request %q with user agent %q", query, userAgent)
+	}
+	if place, _ := geocoder.PlaceName(testCtx, 42.1, 1.8); place != "Sant Pere de Ribes" {
+		t.Errorf("Expected the village, got %q", place)
+	}
+	if place, err := geocoder.PlaceName(testCtx, 40, -30); err != nil || place != "" {
+		t.Errorf("Expected no place and no error at sea, got %q and %v", place, err)
+	}
+}
+
+func TestHTTPGeocoder_Refused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	opts := DefaultGeocoderOptions()
+	opts.URL = server.URL
+	geocoder, err := NewGeocoder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := geocoder.PlaceName(testCtx, 41.39, 2.17); err == nil {
+		t.Error("Expected an error when the geocoder refuses the request")
+	}
+
+	opts.URL = "not a url"
+	if _, err := NewGeocoder(opts); err == nil {
+		t.Error("Expected an error for an invalid URL")
+	}
+}
+
+func TestPlaceDirName(t *testing.T) {
+	tests := map[string]string{
+		"Barcelona":            "Barcelona",
+		"  Santa   Cruz ":      "Santa Cruz",
+		"Bielsko/Biała":        "Bielsko Biała",
+		"Line\nbreak\\slashed": "Line break slashed",
+	}
+	for name, expected := range tests {
+		if got := placeDirName(name); got != expected {
+			t.Errorf("placeDirName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package pics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// IgnoreFileName is the name of the file holding exclusion rules (gitignore syntax)
+	IgnoreFileName = ".picsignore"
+)
+
+// ignoreRule is a single compiled line of an ignore file
+type ignoreRule struct {
+	// base is the slash-separated directory of the ignore file, relative to the matcher root ("" for the root)
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher evaluates .picsignore files found below a root directory.
+//
+// Rules in a subdirectory only apply to paths inside it. As in gitignore, the
+// last matching rule wins and a "!" prefix re-includes a previously ignored path.
+type ignoreMatcher struct {
+	root  string
+	rules []ignoreRule
+}
+
+// newIgnoreMatcher loads every ignore file in the directory tree at root.
+// Directories that are already ignored are not searched. An ignore file that
+// can't be read fails the whole matcher, so that rules are never half applied.
+func newIgnoreMatcher(root string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{root: filepath.Clean(root)}
+
+	err := filepath.WalkDir(m.root, func(dirPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if m.isIgnored(dirPath, true) {
+			return filepath.SkipDir
+		}
+		return m.loadRules(dirPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// isIgnored reports whether a path inside the root is excluded by the applicable ignore files.
+// A nil matcher ignores nothing.
+func (m *ignoreMatcher) isIgnored(filePath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	relPath, err := filepath.Rel(m.root, filepath.Clean(filePath))
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		candidate := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			candidate = strings.TrimPrefix(relPath, rule.base+"/")
+		}
+		if rule.pattern.MatchString(candidate) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// loadRules reads the ignore file in dirPath, if there is one
+func (m *ignoreMatcher) loadRules(dirPath string) error {
+	ignoreFile := filepath.Join(dirPath, IgnoreFileName)
+	file, err := os.Open(ignoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open ignore file: %w", err)
+	}
+	defer file.Close()
+
+	base, err := filepath.Rel(m.root, dirPath)
+	if err != nil {
+		return err
+	}
+	base = filepath.ToSlash(base)
+	if base == "." {
+		base = ""
+	}
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rule, ok, err := parseIgnoreLine(scanner.Text(), base)
+		if err != nil {
+			return fmt.Errorf("invalid rule in ignore file %s: %w", ignoreFile, err)
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ignore file %s: %w", ignoreFile, err)
+	}
+
+	m.rules = append(m.rules, rules...)
+	logger.Debug("Loaded ignore file", "file", ignoreFile, "rules", len(rules))
+	return nil
+}
+
+// parseIgnoreLine compiles a single gitignore-style line. Returns false for blank lines and comments.
+func parseIgnoreLine(line, base string) (ignoreRule, bool, error) {
+	// Trailing spaces are ignored unless escaped
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	// A slash anywhere but the end anchors the pattern to the ignore file's directory
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	pattern, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false, fmt.Errorf("pattern %q: %w", line, err)
+	}
+	rule.pattern = pattern
+	return rule, true, nil
+}
+
+// globToRegexp converts a gitignore glob (with "**" support) to a regular expression
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// Leading or middle "**/" matches zero or more directories
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,222 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeIgnoreFile(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, IgnoreFileName), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write ignore file: %v", err)
+	}
+}
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		expectRule  bool
+		expectNeg   bool
+		expectDir   bool
+		matches     []string
+		doesntMatch []string
+	}{
+		{name: "blank line", line: "   ", expectRule: false},
+		{name: "comment", line: "# thumbnails", expectRule: false},
+		{
+			name:        "name at any depth",
+			line:        "*.tmp",
+			expectRule:  true,
+			matches:     []string{"a.tmp", "dir/b.tmp", "dir/sub/c.tmp"},
+			doesntMatch: []string{"a.tmp.jpg", "tmp"},
+		},
+		{
+			name:        "anchored with leading slash",
+			line:        "/private",
+			expectRule:  true,
+			matches:     []string{"private"},
+			doesntMatch: []string{"dir/private"},
+		},
+		{
+			name:        "anchored by middle slash",
+			line:        "trip/raw",
+			expectRule:  true,
+			matches:     []string{"trip/raw"},
+			doesntMatch: []string{"other/trip/raw"},
+		},
+		{
+			name:       "directory only",
+			line:       "cache/",
+			expectRule: true,
+			expectDir:  true,
+			matches:    []string{"cache", "dir/cache"},
+		},
+		{
+			name:       "negation",
+			line:       "!keep.jpg",
+			expectRule: true,
+			expectNeg:  true,
+			matches:    []string{"keep.jpg"},
+		},
+		{
+			name:        "double star in the middle",
+			line:        "a/**/b",
+			expectRule:  true,
+			matches:     []string{"a/b", "a/x/b", "a/x/y/b"},
+			doesntMatch: []string{"b", "x/a/b"},
+		},
+		{
+			name:        "trailing double star",
+			line:        "exports/**",
+			expectRule:  true,
+			matches:     []string{"exports/a.jpg", "exports/x/b.jpg"},
+			doesntMatch: []string{"exports"},
+		},
+		{
+			name:        "character class and question mark",
+			line:        "IMG_[0-9]?.jpg",
+			expectRule:  true,
+			matches:     []string{"IMG_12.jpg", "dir/IMG_3a.jpg"},
+			doesntMatch: []string{"IMG_a1.jpg"},
+		},
+		{
+			name:       "escaped hash",
+			line:       "\\#1.jpg",
+			expectRule: true,
+			matches:    []string{"#1.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok, err := parseIgnoreLine(tt.line, "")
+			if err != nil {
+				t.Fatalf("parseIgnoreLine(%q) returned error: %v", tt.line, err)
+			}
+			if ok != tt.expectRule {
+				t.Fatalf("parseIgnoreLine(%q) ok = %v, expected %v", tt.line, ok, tt.expectRule)
+			}
+			if !ok {
+				return
+			}
+			if rule.negate != tt.expectNeg {
+				t.Errorf("Expected negate=%v, got %v", tt.expectNeg, rule.negate)
+			}
+			if rule.dirOnly != tt.expectDir {
+				t.Errorf("Expected dirOnly=%v, got %v", tt.expectDir, rule.dirOnly)
+			}
+			for _, path := range tt.matches {
+				if !rule.pattern.MatchString(path) {
+					t.Errorf("Expected %q to match %q", tt.line, path)
+				}
+			}
+			for _, path := range tt.doesntMatch {
+				if rule.pattern.MatchString(path) {
+					t.Errorf("Expected %q not to match %q", tt.line, path)
+				}
+			}
+		})
+	}
+}
+
+func TestIgnoreMatcher_IsIgnored(t *testing.T) {
+	root := t.TempDir()
+	album := createTestDir(t, root, "Wedding")
+	writeIgnoreFile(t, root, "*.tmp\nprivate/\n*.png\n!cover.png\n")
+	writeIgnoreFile(t, album, "drafts\n")
+
+	matcher, err := newIgnoreMatcher(root)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{name: "root itself", path: root, isDir: true, expected: false},
+		{name: "regular file", path: filepath.Join(root, "photo.jpg"), expected: false},
+		{name: "root rule", path: filepath.Join(root, "a.tmp"), expected: true},
+		{name: "root rule applies in subdirectories", path: filepath.Join(album, "b.tmp"), expected: true},
+		{name: "directory-only rule on directory", path: filepath.Join(root, "private"), isDir: true, expected: true},
+		{name: "directory-only rule on file", path: filepath.Join(root, "private"), isDir: false, expected: false},
+		{name: "negated rule", path: filepath.Join(root, "cover.png"), expected: false},
+		{name: "rule before negation", path: filepath.Join(root, "other.png"), expected: true},
+		{name: "nested ignore file", path: filepath.Join(album, "drafts"), isDir: true, expected: true},
+		{name: "nested rule does not apply outside", path: filepath.Join(root, "drafts"), isDir: true, expected: false},
+		{name: "outside root", path: filepath.Join(filepath.Dir(root), "a.tmp"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := matcher.isIgnored(tt.path, tt.isDir); result != tt.expected {
+				t.Errorf("isIgnored(%q) = %v, expected %v", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIgnoreMatcher_Nil(t *testing.T) {
+	var matcher *ignoreMatcher
+	if matcher.isIgnored("/any/path", false) {
+		t.Error("Expected nil matcher to ignore nothing")
+	}
+}
+
+func TestIgnoreMatcher_NoIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	matcher, err := newIgnoreMatcher(root)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if matcher.isIgnored(filepath.Join(root, "photo.jpg"), false) {
+		t.Error("Expected nothing to be ignored without ignore files")
+	}
+}
+
+func TestParseIgnoreLine_InvalidPattern(t *testing.T) {
+	if _, _, err := parseIgnoreLine("IMG_[z-a].jpg", ""); err == nil {
+		t.Error("Expected error for invalid character range")
+	}
+}
+
+func TestIgnoreMatcher_InvalidRuleFails(t *testing.T) {
+	root := t.TempDir()
+	album := createTestDir(t, root, "Wedding")
+	writeIgnoreFile(t, album, "*.tmp\nIMG_[z-a].jpg\n")
+
+	// A broken ignore file must not leave its earlier rules half applied
+	if _, err := newIgnoreMatcher(root); err == nil {
+		t.Error("Expected error for ignore file with an invalid rule")
+	}
+}
+
+func TestIgnoreMatcher_UnreadableIgnoreFileFails(t *testing.T) {
+	root := t.TempDir()
+	// A directory in place of the ignore file can be opened but not read
+	createTestDir(t, root, IgnoreFileName)
+
+	if _, err := newIgnoreMatcher(root); err == nil {
+		t.Error("Expected error for unreadable ignore file")
+	}
+}
+
+func TestIgnoreMatcher_SkipsIgnoredDirectories(t *testing.T) {
+	root := t.TempDir()
+	private := createTestDir(t, root, "private")
+	writeIgnoreFile(t, root, "private/\n")
+	// Never read, because its directory is ignored
+	createTestDir(t, private, IgnoreFileName)
+
+	matcher, err := newIgnoreMatcher(root)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !matcher.isIgnored(private, true) {
+		t.Error("Expected private directory to be ignored")
+	}
+}
@@ -0,0 +1,309 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+const (
+	// incrementalPrefix is the prefix of the files and manifests of directories backed up file
+	// by file. The files of a directory are kept under incrementalPrefix + its encoded name + "/".
+	incrementalPrefix = "incremental/"
+	// incrementalManifestExtension is the suffix of the manifest of a directory backed up file by file
+	incrementalManifestExtension = ".json"
+)
+
+// BackupMode is how a backup stores each directory in the bucket
+type BackupMode string
+
+const (
+	// BackupModeArchive uploads each directory as a tar.gz archive, which is uploaded again as a
+	// whole when any of its files changes
+	BackupModeArchive BackupMode = "archive"
+	// BackupModeIncremental uploads the files of each directory as objects of their own, with a
+	// manifest per directory recording their SHA-256, so only the files that changed are uploaded
+	BackupModeIncremental BackupMode = "incremental"
+)
+
+// ParseBackupMode parses "archive" or "incremental"
+func ParseBackupMode(s string) (BackupMode, error) {
+	switch mode := BackupMode(s); mode {
+	case BackupModeArchive, BackupModeIncremental:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid backup mode %q (expected archive or incremental)", s)
+}
+
+// incrementalManifest lists the files of a directory backed up file by file
+type incrementalManifest struct {
+	// Directory is the name of the directory
+	Directory string `json:"directory"`
+	// Files lists the files of the directory, sorted by path
+	Files []incrementalFile `json:"files"`
+}
+
+// incrementalFile is a file of a directory backed up file by file
+type incrementalFile struct {
+	stateFile
+	// SHA256 is the hex encoded SHA-256 of the file
+	SHA256 string `json:"sha256"`
+}
+
+// incrementalManifestKey returns the key of the manifest of a directory backed up file by file
+func incrementalManifestKey(dirName string) string {
+	return incrementalPrefix + naming.EncodeKeyName(dirName) + incrementalManifestExtension
+}
+
+// incrementalFileKey returns the key of the file at path, using "/" as separator, of a directory
+// backed up file by file
+func incrementalFileKey(dirName, path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = naming.EncodeKeyName(segment)
+	}
+	return incrementalPrefix + naming.EncodeKeyName(dirName) + keyDelimiter + strings.Join(segments, keyDelimiter)
+}
+
+// isIncrementalManifestKey reports whether key is the manifest of a directory backed up file by
+// file rather than one of its files
+func isIncrementalManifestKey(key string) bool {
+	name, ok := strings.CutPrefix(key, incrementalPrefix)
+	return ok && strings.HasSuffix(name, incrementalManifestExtension) && !strings.Contains(name, keyDelimiter)
+}
+
+// uploadFilesTo returns the directoryBackup uploading the files of the directories of sourceDir
+// that changed since their last incremental backup to bucket
+func (b *s3Backup) uploadFilesTo(sourceDir, bucket string, opts BackupOptions, tally *backupTally) directoryBackup {
+	return func(ctx context.Context, dirName string, space *stagingSpace, skip skipFunc) error {
+		return b.backupDirectoryFiles(ctx, sourceDir, dirName, bucket, opts, skip, tally)
+	}
+}
+
+// backupDirectoryFiles uploads the files of a directory whose SHA-256 differs from its last
+// incremental backup, then its manifest. Files whose size and modification time match the last
+// manifest aren't read again. Files since removed from the directory are left in the bucket,
+// only the manifest no longer lists them.
+func (b *s3Backup) backupDirectoryFiles(ctx context.Context, sourceDir, dirName, bucket string, opts BackupOptions, skip skipFunc, tally *backupTally) error {
+	dirPath := filepath.Join(sourceDir, dirName)
+	state, err := readDirectoryState(dirPath, skip)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	manifestKey := incrementalManifestKey(dirName)
+	previous, err := b.readIncrementalManifest(ctx, bucket, manifestKey)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	backedUp := make(map[string]incrementalFile, len(previous.Files))
+	for _, file := range previous.Files {
+		backedUp[file.Path] = file
+	}
+
+	tags := ""
+	if opts.ObjectTags {
+		tags = archiveTags(naming.EncodeKeyName(dirName))
+	}
+	manifest := incrementalManifest{Directory: dirName, Files: make([]incrementalFile, 0, len(state.Files))}
+	uploaded := 0
+	for _, file := range state.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last, known := backedUp[file.Path]
+		if known && last.stateFile == file {
+			manifest.Files = append(manifest.Files, last)
+			tally.uploadedArchive(dirName, false, file.Size)
+			continue
+		}
+
+		path := filepath.Join(dirPath, filepath.FromSlash(file.Path))
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return fmt.Errorf("failed to calculate SHA-256 of %s: %w", file.Path, err)
+		}
+		entry := incrementalFile{stateFile: file, SHA256: hex.EncodeToString(sum)}
+		manifest.Files = append(manifest.Files, entry)
+		if known && last.SHA256 == entry.SHA256 {
+			// Only the modification time changed
+			tally.uploadedArchive(dirName, false, file.Size)
+			continue
+		}
+
+		checksum := ""
+		if opts.SHA256Checksums {
+			checksum = base64.StdEncoding.EncodeToString(sum)
+		}
+		logger.Debug("Uploading file", "directory", dirName, "file", file.Path, "size", file.Size)
+		if err := b.uploadToS3(ctx, path, bucket, incrementalFileKey(dirName, file.Path), checksum, tags); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file.Path, err)
+		}
+		tally.uploadedArchive(dirName, true, file.Size)
+		uploaded++
+	}
+
+	if reflect.DeepEqual(manifest.Files, previous.Files) {
+		logger.Info("Directory unchanged since its last backup, skipping", "directory", dirName)
+		return nil
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	// The manifest goes last, so it only lists files that were uploaded
+	if _, err := b.storage.Put(ctx, manifestKey, bytes.NewReader(data), PutOptions{}); err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	logger.Info("Successfully backed up directory", "directory", dirName, "files", len(manifest.Files), "uploaded", uploaded)
+	return nil
+}
+
+// readIncrementalManifest downloads the manifest at key. A missing manifest is reported as
+// os.ErrNotExist.
+func (b *s3Backup) readIncrementalManifest(ctx context.Context, bucket, key string) (incrementalManifest, error) {
+	body, _, err := b.storage.Get(ctx, key, "")
+	if errors.Is(err, ErrObjectNotFound) {
+		return incrementalManifest{}, fmt.Errorf("no manifest %s: %w", key, os.ErrNotExist)
+	}
+	if err != nil {
+		return incrementalManifest{}, fmt.Errorf("failed to download manifest %s: %w", key, err)
+	}
+	defer body.Close()
+
+	var manifest incrementalManifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		return incrementalManifest{}, fmt.Errorf("failed to read manifest %s: %w", key, err)
+	}
+	if incrementalManifestKey(manifest.Directory) != key || !isSafeDirectoryName(manifest.Directory) {
+		return incrementalManifest{}, fmt.Errorf("manifest %s names another directory: %q", key, manifest.Directory)
+	}
+	return manifest, nil
+}
+
+// listIncrementalManifests returns the manifests of the directories backed up file by file
+func (b *s3Backup) listIncrementalManifests(ctx context.Context, bucket string) ([]StoredObject, error) {
+	// The files of each directory are under its own prefix and left out
+	objects, _, err := b.storage.List(ctx, incrementalPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list incremental backups: %w", err)
+	}
+	var manifests []StoredObject
+	for _, obj := range objects {
+		if isIncrementalManifestKey(obj.Key) {
+			manifests = append(manifests, obj)
+		}
+	}
+	return manifests, nil
+}
+
+// restoreDirectoryFiles downloads the files listed in the manifest of a directory backed up file
+// by file. A directory already in targetDir is handled as opts.OnConflict says.
+func (b *s3Backup) restoreDirectoryFiles(ctx context.Context, bucket, targetDir, manifestKey string, opts RestoreOptions, tally *restoreTally) error {
+	manifest, err := b.readIncrementalManifest(ctx, bucket, manifestKey)
+	if err != nil {
+		return err
+	}
+	dirName := manifest.Directory
+	targetPath := filepath.Join(targetDir, dirName)
+
+	extractDir := targetDir
+	if _, err := os.Stat(targetPath); err == nil {
+		// Download next to the library so the files are moved into place rather than copied
+		var cleanup func()
+		if extractDir, cleanup, err = existingDirectoryExtractDir(targetDir, targetPath, opts.OnConflict); err != nil {
+			return err
+		}
+		if extractDir == "" {
+			logger.Info("Skipping directory that already exists", "directory", dirName)
+			tally.skipped.Add(1)
+			return nil
+		}
+		defer cleanup()
+	}
+
+	for _, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return fmt.Errorf("manifest %s lists a file outside its directory: %s", manifestKey, file.Path)
+		}
+		path := filepath.Join(extractDir, dirName, filepath.FromSlash(file.Path))
+		if err := b.downloadFile(ctx, bucket, incrementalFileKey(dirName, file.Path), path, file); err != nil {
+			return err
+		}
+		tally.downloadedBytes.Add(file.Size)
+	}
+	if err := os.MkdirAll(filepath.Join(extractDir, dirName), libraryDirMode); err != nil {
+		return err
+	}
+	if opts.Owner != nil {
+		err := filepath.WalkDir(filepath.Join(extractDir, dirName), func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return chownPath(path, *opts.Owner)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", dirName, err)
+		}
+	}
+
+	if extractDir != targetDir {
+		return settleExistingDirectory(filepath.Join(extractDir, dirName), targetPath, opts.OnConflict, tally)
+	}
+	logger.Info("Successfully restored directory", "directory", dirName, "files", len(manifest.Files))
+	tally.restored.Add(1)
+	return nil
+}
+
+// downloadFile downloads the file stored under key to path, checking it against the SHA-256 of
+// the manifest and giving it the modification time of the manifest
+func (b *s3Backup) downloadFile(ctx context.Context, bucket, key, path string, file incrementalFile) error {
+	body, _, err := b.storage.Get(ctx, key, "")
+	if err != nil {
+		return describeGetError(key, err)
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), libraryDirMode); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, libraryFileMode)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	meter := newByteMeter(ctx, PhaseDownloading, file.Size)
+	_, err = io.Copy(io.MultiWriter(out, hash), meter.reader(body))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		err = fmt.Errorf("SHA-256 mismatch for '%s': downloaded file is corrupted", key)
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	meter.done()
+	modTime := time.Unix(file.ModTime, 0)
+	if err := os.Chtimes(path, time.Now(), modTime); err != nil {
+		return fmt.Errorf("failed to restore modification time of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBackupMode(t *testing.T) {
+	for _, s := range []string{"archive", "incremental"} {
+		if mode, err := ParseBackupMode(s); err != nil || string(mode) != s {
+			t.Errorf("ParseBackupMode(%q) = %q, %v", s, mode, err)
+		}
+	}
+	if _, err := ParseBackupMode("files"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestIncrementalKeys(t *testing.T) {
+	if key := incrementalFileKey("2023 06 June 15 #1", "videos/clip 1.mov"); key != "incremental/2023 06 June 15 !231/videos/clip 1.mov" {
+		t.Errorf("Unexpected file key %q", key)
+	}
+	manifestKey := incrementalManifestKey("2023 06 June 15 #1")
+	if manifestKey != "incremental/2023 06 June 15 !231.json" || !isIncrementalManifestKey(manifestKey) {
+		t.Errorf("Unexpected manifest key %q", manifestKey)
+	}
+	for _, key := range []string{"incremental/2023 06 June 15/notes.json", "2023 06 June 15 (1 images, 0 videos).tar.gz", "sync/2023 06 June 15.json"} {
+		if isIncrementalManifestKey(key) {
+			t.Errorf("Expected %q not to be an incremental manifest", key)
+		}
+	}
+}
+
+func TestBackup_Incremental(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	dirName := "2023 06 June 15"
+	dirPath := filepath.Join(sourceDir, dirName)
+	writeContentFile(t, dirPath, "beach.jpg", "beach")
+	writeContentFile(t, dirPath, "dinner.jpg", "dinner")
+	writeContentFile(t, filepath.Join(dirPath, "videos"), "waves.mov", "waves")
+
+	opts := DefaultBackupOptions()
+	opts.Mode = BackupModeIncremental
+	opts.MaxConcurrent = 1
+	backupOnce := func() BackupReport {
+		t.Helper()
+		report, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts)
+		if err != nil {
+			t.Fatalf("BackupDirectories failed: %v", err)
+		}
+		return report
+	}
+
+	report := backupOnce()
+	if report.Uploaded != 3 || report.Existing != 0 || client.GetObjectCount(bucket) != 4 {
+		t.Fatalf("Expected 3 files and a manifest uploaded, got %+v and %d objects", report, client.GetObjectCount(bucket))
+	}
+	if data, err := client.GetObjectData(bucket, "incremental/2023 06 June 15/videos/waves.mov"); err != nil || string(data) != "waves" {
+		t.Errorf("Expected the video uploaded as it is, got %q (%v)", data, err)
+	}
+
+	// Changing one photo only uploads that photo
+	writeContentFile(t, dirPath, "dinner.jpg", "dessert")
+	report = backupOnce()
+	if report.Uploaded != 1 || report.Existing != 2 || report.UploadedDirectories != 1 {
+		t.Errorf("Expected 1 file uploaded and 2 unchanged, got %+v", report)
+	}
+
+	// A file only touched isn't uploaded, and an unchanged directory not even its manifest
+	touched := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dirPath, "beach.jpg"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	if report = backupOnce(); report.Uploaded != 0 || report.UnchangedDirectories != 1 {
+		t.Errorf("Expected nothing uploaded for a touched file, got %+v", report)
+	}
+	stored, _, _ := backup.forBucket(bucket)
+	manifest, err := stored.readIncrementalManifest(testCtx, bucket, incrementalManifestKey(dirName))
+	if err != nil || len(manifest.Files) != 3 || manifest.Files[0].ModTime != touched.Round(time.Second).Unix() {
+		t.Errorf("Expected the manifest to record the new modification time, got %+v (%v)", manifest, err)
+	}
+}
+
+func TestBackup_Incremental_RoundTrip(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	for dirName, files := range map[string][]string{
+		"2023 06 June 15":    {"beach.jpg", "videos/waves.mov"},
+		"2024 01 January 02": {"snow.jpg"},
+	} {
+		for _, file := range files {
+			path := writeContentFile(t, filepath.Join(sourceDir, dirName, filepath.Dir(file)), filepath.Base(file), file+" contents")
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	opts := DefaultBackupOptions()
+	opts.Mode = BackupModeIncremental
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	targetDir := filepath.Join(tmpDir, "restored")
+	restoreOpts := DefaultRestoreOptions()
+	restoreOpts.Filter = RestoreFilter{ToYear: 2023}
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, restoreOpts)
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 1 {
+		t.Errorf("Expected 1 directory restored, got %+v", report)
+	}
+	if got := listDir(t, targetDir); !reflect.DeepEqual(got, []string{"2023 06 June 15"}) {
+		t.Errorf("Expected only the directory of 2023 restored, got %v", got)
+	}
+	restored := filepath.Join(targetDir, "2023 06 June 15", "videos", "waves.mov")
+	if data, err := os.ReadFile(restored); err != nil || string(data) != "videos/waves.mov contents" {
+		t.Errorf("Expected the video restored, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(restored); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected the modification time restored, got %v (%v)", info.ModTime(), err)
+	}
+
+	// An existing directory needs a merge
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, restoreOpts); err == nil {
+		t.Error("Expected restore without merge to fail on the existing directory")
+	}
+	restoreOpts.OnConflict = RestorePolicyMerge
+	if report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, restoreOpts); err != nil || report.Merged != 1 || report.Duplicates != 2 {
+		t.Errorf("Expected the restored files found as duplicates, got %+v (%v)", report, err)
+	}
+}
+
+func TestSelectRestoreObjects_MixedModes(t *testing.T) {
+	backup := &s3Backup{extensions: NewExtensions()}
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+	objects := []StoredObject{
+		// Backed up as an archive, then file by file
+		{Key: "2023 06 June 15 (2 images, 0 videos).tar.gz", LastModified: earlier},
+		{Key: incrementalManifestKey("2023 06 June 15"), LastModified: later},
+		// Backed up file by file, then as an archive
+		{Key: incrementalManifestKey("2023 07 July 01 #1"), LastModified: earlier},
+		{Key: "2023 07 July 01 !231 (1 images, 0 videos).tar.gz", LastModified: later},
+		// Only backed up file by file
+		{Key: incrementalManifestKey("2023 08 August 02"), LastModified: earlier},
+	}
+
+	selected, _ := backup.selectRestoreObjects(objects, RestoreFilter{})
+	var keys []string
+	for _, obj := range selected {
+		keys = append(keys, obj.Key)
+	}
+	expected := []string{objects[1].Key, objects[3].Key, objects[4].Key}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected only the latest backup of each directory, got %v", keys)
+	}
+}
+
+func TestBackup_Incremental_RestoreCorruptedFile(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	opts := DefaultBackupOptions()
+	opts.Mode = BackupModeIncremental
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	client.CorruptObject(bucket, "incremental/2023 06 June 15/beach.jpg", []byte("bench"))
+
+	targetDir := t.TempDir()
+	stored, _, _ := backup.forBucket(bucket)
+	err := stored.restoreDirectoryFiles(testCtx, bucket, targetDir, incrementalManifestKey("2023 06 June 15"), DefaultRestoreOptions(), &restoreTally{})
+	if err == nil || !strings.Contains(err.Error(), "SHA-256 mismatch") {
+		t.Errorf("Expected a SHA-256 mismatch, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15", "beach.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the corrupted file removed")
+	}
+}
@@ -0,0 +1,247 @@
+package pics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// DefaultInventoryCacheDir returns the directory where bucket listings are cached between runs
+func DefaultInventoryCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pics", "inventory"), nil
+}
+
+// bucketInventory is the listing of a bucket together with the manifests read from it.
+// Manifests are kept as long as the ETag of their object doesn't change.
+type bucketInventory struct {
+	// Bucket is the name of the listed bucket
+	Bucket string `json:"bucket"`
+	// Endpoint is the URL of the S3-compatible service holding the bucket ("" = AWS)
+	Endpoint string `json:"endpoint,omitempty"`
+	// ListedAt is when the bucket was listed
+	ListedAt time.Time `json:"listedAt"`
+	// Objects are the objects of the bucket
+	Objects []inventoryObject `json:"objects"`
+	// Manifests are the manifests of split directories read so far, by key
+	Manifests map[string]cachedManifest `json:"manifests"`
+
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+// inventoryObject is an object of a bucket listing
+type inventoryObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	StorageClass string    `json:"storageClass,omitempty"`
+}
+
+// cachedManifest is a manifest read from the object with the given ETag
+type cachedManifest struct {
+	ETag     string          `json:"etag"`
+	Manifest archiveManifest `json:"manifest"`
+}
+
+// newBucketInventory builds the inventory of a fresh listing, keeping the manifests of
+// previous whose objects are unchanged
+func newBucketInventory(bucket string, objects []StoredObject, previous *bucketInventory) *bucketInventory {
+	inv := &bucketInventory{
+		Bucket:    bucket,
+		ListedAt:  time.Now(),
+		Objects:   make([]inventoryObject, 0, len(objects)),
+		Manifests: make(map[string]cachedManifest),
+	}
+	for _, obj := range objects {
+		inv.Objects = append(inv.Objects, inventoryObject{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+			StorageClass: string(obj.StorageClass),
+		})
+	}
+	inv.index()
+
+	if previous != nil {
+		for key, cached := range previous.Manifests {
+			if cached.ETag != "" && inv.etags[key] == cached.ETag {
+				inv.Manifests[key] = cached
+			}
+		}
+	}
+	return inv
+}
+
+// loadInventory reads the cached inventory of bucket at endpoint ("" = AWS), or returns nil if
+// there is none
+func loadInventory(cacheDir, endpoint, bucket string) (*bucketInventory, error) {
+	data, err := os.ReadFile(inventoryPath(cacheDir, endpoint, bucket))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var inv bucketInventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("failed to read inventory of %s: %w", bucket, err)
+	}
+	if inv.Bucket != bucket || inv.Endpoint != endpoint {
+		return nil, fmt.Errorf("inventory cache holds bucket %q of %q instead of %q of %q", inv.Bucket, inv.Endpoint, bucket, endpoint)
+	}
+	if inv.Manifests == nil {
+		inv.Manifests = make(map[string]cachedManifest)
+	}
+	inv.index()
+	return &inv, nil
+}
+
+// save writes the inventory to cacheDir, replacing the previous one at once
+func (inv *bucketInventory) save(cacheDir string) error {
+	inv.mu.Lock()
+	data, err := json.Marshal(inv)
+	inv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(cacheDir, ".inventory-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), inventoryPath(cacheDir, inv.Endpoint, inv.Bucket))
+}
+
+// objects returns the objects of the listing
+func (inv *bucketInventory) objects() []StoredObject {
+	objects := make([]StoredObject, 0, len(inv.Objects))
+	for _, obj := range inv.Objects {
+		objects = append(objects, StoredObject{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+			StorageClass: StorageClass(obj.StorageClass),
+		})
+	}
+	return objects
+}
+
+// etag returns the ETag key had when the bucket was listed ("" if it wasn't listed)
+func (inv *bucketInventory) etag(key string) string {
+	return inv.etags[key]
+}
+
+// manifest returns the manifest read earlier from key, if the object hasn't changed since
+func (inv *bucketInventory) manifest(key string) (archiveManifest, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	cached, ok := inv.Manifests[key]
+	if !ok || cached.ETag == "" || cached.ETag != inv.etags[key] {
+		return archiveManifest{}, false
+	}
+	return cached.Manifest, true
+}
+
+// storeManifest remembers the manifest read from key
+func (inv *bucketInventory) storeManifest(key string, manifest archiveManifest) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.Manifests[key] = cachedManifest{ETag: inv.etags[key], Manifest: manifest}
+}
+
+// index maps every key of the listing to its ETag
+func (inv *bucketInventory) index() {
+	inv.etags = make(map[string]string, len(inv.Objects))
+	for _, obj := range inv.Objects {
+		inv.etags[obj.Key] = obj.ETag
+	}
+}
+
+// inventoryPath returns the file holding the inventory of bucket at endpoint ("" = AWS).
+// Bucket names can't contain path separators, so only the "/" of a bucket path with a prefix
+// is escaped; the names of caches of whole buckets of AWS are unchanged. Buckets of the same
+// name at other endpoints are cached apart, named after their endpoint too.
+func inventoryPath(cacheDir, endpoint, bucket string) string {
+	name := url.PathEscape(bucket)
+	if endpoint != "" {
+		name = url.QueryEscape(endpoint) + "@" + name
+	}
+	return filepath.Join(cacheDir, name+".json")
+}
+
+// forgetInventory removes the cached inventory of bucket at endpoint, so the next run lists it
+// again. Failing to do so only leaves the cache stale until it expires.
+func forgetInventory(cacheDir, endpoint, bucket string) {
+	if err := os.Remove(inventoryPath(cacheDir, endpoint, bucket)); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to drop cached bucket listing", "bucket", bucket, "error", err)
+	}
+}
+
+// withInventoryInvalidation returns a copy of b that records whether any object was written,
+// and a function dropping the cached inventory of bucket from cacheDir if one was. Uploads
+// change the listing, which would otherwise be trusted until it expires. An empty cacheDir
+// returns b itself and a function doing nothing.
+func (b *s3Backup) withInventoryInvalidation(cacheDir, bucket string) (*s3Backup, func()) {
+	if cacheDir == "" {
+		return b, func() {}
+	}
+	storage := &writeTrackingStorage{StorageBackend: b.storage}
+	tracked := *b
+	tracked.storage = storage
+	return &tracked, func() {
+		if storage.written.Load() {
+			forgetInventory(cacheDir, b.endpoint, bucket)
+		}
+	}
+}
+
+// writeTrackingStorage records whether an object was stored or deleted through the
+// StorageBackend it wraps
+type writeTrackingStorage struct {
+	StorageBackend
+	written atomic.Bool
+}
+
+func (s *writeTrackingStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error) {
+	obj, err := s.StorageBackend.Put(ctx, key, body, opts)
+	if err == nil {
+		s.written.Store(true)
+	}
+	return obj, err
+}
+
+func (s *writeTrackingStorage) Delete(ctx context.Context, key string) error {
+	err := s.StorageBackend.Delete(ctx, key)
+	if err == nil {
+		s.written.Store(true)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func listedObject(key, etag string, size int64) StoredObject {
+	return StoredObject{Key: key, ETag: etag, Size: size}
+}
+
+func TestBucketInventory_SaveAndLoad(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "inventory")
+	manifest := archiveManifest{Directory: "2023 06 June 15", Parts: []archivePart{{Key: "a.part-0001.tar.gz", Size: 10}}}
+
+	inv := newBucketInventory("photos", []StoredObject{
+		listedObject("a.manifest.json", `"etag-1"`, 100),
+		listedObject("a.part-0001.tar.gz", `"etag-2"`, 10),
+	}, nil)
+	inv.storeManifest("a.manifest.json", manifest)
+	if err := inv.save(cacheDir); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	loaded, err := loadInventory(cacheDir, "", "photos")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(loaded.objects()) != 2 || loaded.etag("a.part-0001.tar.gz") != `"etag-2"` {
+		t.Errorf("Expected the listing to survive a round trip, got %+v", loaded.Objects)
+	}
+	if got, ok := loaded.manifest("a.manifest.json"); !ok || got.Directory != manifest.Directory || len(got.Parts) != 1 {
+		t.Errorf("Expected the cached manifest, got %+v (%v)", got, ok)
+	}
+
+	// Only the inventory file is left behind
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected a single file in the cache directory, got %v (%v)", entries, err)
+	}
+}
+
+func TestLoadInventory_Missing(t *testing.T) {
+	inv, err := loadInventory(t.TempDir(), "", "photos")
+	if err != nil || inv != nil {
+		t.Errorf("Expected no inventory and no error, got %v (%v)", inv, err)
+	}
+}
+
+func TestLoadInventory_Invalid(t *testing.T) {
+	cacheDir := t.TempDir()
+	if err := os.WriteFile(inventoryPath(cacheDir, "", "photos"), []byte("{not json"), 0600); err != nil {
+		t.Fatalf("Failed to write inventory: %v", err)
+	}
+	if _, err := loadInventory(cacheDir, "", "photos"); err == nil {
+		t.Error("Expected error for an unreadable inventory")
+	}
+}
+
+func TestNewBucketInventory_InvalidatesChangedManifests(t *testing.T) {
+	previous := newBucketInventory("photos", []StoredObject{
+		listedObject("a.manifest.json", `"etag-a"`, 100),
+		listedObject("b.manifest.json", `"etag-b"`, 100),
+	}, nil)
+	previous.storeManifest("a.manifest.json", archiveManifest{Directory: "a"})
+	previous.storeManifest("b.manifest.json", archiveManifest{Directory: "b"})
+
+	// b was backed up again, so its manifest has to be read again
+	inv := newBucketInventory("photos", []StoredObject{
+		listedObject("a.manifest.json", `"etag-a"`, 100),
+		listedObject("b.manifest.json", `"etag-b2"`, 120),
+	}, previous)
+
+	if _, ok := inv.manifest("a.manifest.json"); !ok {
+		t.Error("Expected the unchanged manifest to be kept")
+	}
+	if _, ok := inv.manifest("b.manifest.json"); ok {
+		t.Error("Expected the changed manifest to be dropped")
+	}
+}
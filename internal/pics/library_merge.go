@@ -0,0 +1,271 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+// MergeLibrariesOptions holds configuration options for merging a library into another.
+type MergeLibrariesOptions struct {
+	// ProgressChan receives a progress event for each directory merged (nil = no progress).
+	ProgressChan chan<- ProgressEvent
+}
+
+// DefaultMergeLibrariesOptions returns the default options for merging libraries.
+func DefaultMergeLibrariesOptions() MergeLibrariesOptions {
+	return MergeLibrariesOptions{
+		ProgressChan: nil,
+	}
+}
+
+// MergeLibrariesReport holds what merging a library into another did
+type MergeLibrariesReport struct {
+	// Merged is the number of directories combined with the directory of the same name
+	Merged int
+	// Created is the number of directories the target library didn't have
+	Created int
+	// Added is the number of files copied into the target library
+	Added int
+	// Duplicates is the number of files skipped because their directory already had their contents
+	Duplicates int
+	// Skipped lists the entries at the top of the source library that aren't directories
+	Skipped []string
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+}
+
+// LibraryMerger combines a library organised by parse into another
+type LibraryMerger interface {
+	// Merge copies the directories of sourceLibrary into targetLibrary. A directory the target
+	// has already, by name or as the only one of its date, is combined with it: files whose
+	// contents it has are skipped, and sequentially named files continue its numbering, in its
+	// videos directory too. Other directories are copied whole. sourceLibrary is left as it is.
+	// Cancelling ctx stops the merge between files.
+	Merge(ctx context.Context, sourceLibrary, targetLibrary string, opts MergeLibrariesOptions) (MergeLibrariesReport, error)
+}
+
+// libraryMerger implements the LibraryMerger interface
+type libraryMerger struct{}
+
+// NewLibraryMerger creates a new LibraryMerger instance
+func NewLibraryMerger() LibraryMerger {
+	return &libraryMerger{}
+}
+
+// Merge merges the directories of sourceLibrary one at a time, sorted by name. Hidden files and
+// directories, such as journals and sidecars, belong to their library and aren't copied.
+func (m *libraryMerger) Merge(ctx context.Context, sourceLibrary, targetLibrary string, opts MergeLibrariesOptions) (MergeLibrariesReport, error) {
+	start := time.Now()
+	warnings := logger.Warnings()
+	var report MergeLibrariesReport
+
+	if isSameOrNested(sourceLibrary, targetLibrary) || isSameOrNested(targetLibrary, sourceLibrary) {
+		return report, fmt.Errorf("cannot merge %s into %s: one library is inside the other", sourceLibrary, targetLibrary)
+	}
+	if info, err := os.Stat(targetLibrary); err != nil || !info.IsDir() {
+		return report, fmt.Errorf("target library %s is not a directory", targetLibrary)
+	}
+	entries, err := os.ReadDir(sourceLibrary)
+	if err != nil {
+		return report, fmt.Errorf("failed to read source library: %w", err)
+	}
+
+	var dirNames []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if !entry.IsDir() {
+			logger.Warn("Skipping entry of the source library that isn't a directory", "entry", entry.Name())
+			report.Skipped = append(report.Skipped, entry.Name())
+			continue
+		}
+		dirNames = append(dirNames, entry.Name())
+	}
+	matches, err := matchLibraryDirectories(dirNames, targetLibrary)
+	if err != nil {
+		return report, err
+	}
+
+	logger.Info("Merging libraries", "source", sourceLibrary, "target", targetLibrary, "directories", len(dirNames))
+	// Copies are placed through ctx, so cancelling stops the merge before the next file
+	place := func(src, dst string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return copyIntoLibrary(src, dst)
+	}
+	for i, dirName := range dirNames {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if opts.ProgressChan != nil {
+			select {
+			case opts.ProgressChan <- ProgressEvent{
+				Stage:   "merging",
+				Current: i + 1,
+				Total:   len(dirNames),
+				Message: i18n.T("progress.merging_directory", i+1, len(dirNames)),
+				File:    dirName,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "merging")
+			}
+		}
+
+		srcDir := filepath.Join(sourceLibrary, dirName)
+		if match, ok := matches[dirName]; ok {
+			rename := prefixRename{from: filePrefix(dirName), to: filePrefix(match)}
+			result, err := mergeDirectoryWith(srcDir, filepath.Join(targetLibrary, match), true, rename, place)
+			report.Added += result.added
+			report.Duplicates += result.duplicates
+			if err != nil {
+				return report, fmt.Errorf("failed to merge %s: %w", dirName, err)
+			}
+			logger.Info("Merged directory", "directory", dirName, "into", match, "added", result.added, "duplicates", result.duplicates)
+			report.Merged++
+			continue
+		}
+
+		added, err := copyNewDirectory(srcDir, targetLibrary, dirName, place)
+		if err != nil {
+			return report, fmt.Errorf("failed to copy %s: %w", dirName, err)
+		}
+		logger.Info("Copied directory", "directory", dirName, "files", added)
+		report.Added += added
+		report.Created++
+	}
+
+	report.Warnings = logger.Warnings() - warnings
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// matchLibraryDirectories returns the directory of targetLibrary each of dirNames is merged
+// into: the one of the same name, or else the one of the same date. Directories named apart on
+// each machine, e.g. "2023 06 June 15" and "2023 06 June 15 Beach", hold the same day. A date
+// with several directories on either side is ambiguous, and its directories are only merged by
+// name.
+func matchLibraryDirectories(dirNames []string, targetLibrary string) (map[string]string, error) {
+	entries, err := os.ReadDir(targetLibrary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target library: %w", err)
+	}
+	targetNames := make(map[string]bool)
+	targetDates := make(map[time.Time][]string)
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		targetNames[entry.Name()] = true
+		if parsed, err := naming.Parse(entry.Name()); err == nil {
+			targetDates[parsed.Date] = append(targetDates[parsed.Date], entry.Name())
+		}
+	}
+	sourceDates := make(map[time.Time][]string)
+	for _, dirName := range dirNames {
+		if parsed, err := naming.Parse(dirName); err == nil {
+			sourceDates[parsed.Date] = append(sourceDates[parsed.Date], dirName)
+		}
+	}
+
+	matches := make(map[string]string)
+	for _, dirName := range dirNames {
+		if targetNames[dirName] {
+			matches[dirName] = dirName
+			continue
+		}
+		parsed, err := naming.Parse(dirName)
+		if err != nil {
+			continue
+		}
+		sources, targets := sourceDates[parsed.Date], targetDates[parsed.Date]
+		switch {
+		case len(targets) == 0:
+		case len(sources) == 1 && len(targets) == 1:
+			matches[dirName] = targets[0]
+		default:
+			logger.Warn("Copying directory apart, as several directories of its date could match", "directory", dirName, "target_directories", targets)
+		}
+	}
+	return matches, nil
+}
+
+// filePrefix returns the prefix of the sequentially named files of the directory dirName, e.g.
+// "2023_06_June_15_Beach"
+func filePrefix(dirName string) string {
+	return strings.ReplaceAll(dirName, " ", "_")
+}
+
+// copyNewDirectory copies srcDir, less its hidden files, to the directory dirName of
+// targetLibrary, returning the number of files copied. The copy is made in a temporary
+// directory of targetLibrary and renamed into place once complete, so an interrupted merge
+// doesn't leave half a directory behind.
+func copyNewDirectory(srcDir, targetLibrary, dirName string, place placeFunc) (int, error) {
+	tempDir, err := os.MkdirTemp(targetLibrary, mergeDirPattern)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	copied := 0
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != srcDir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(tempDir, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(dst, libraryDirMode)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := place(path, dst); err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := renameNoReplace(tempDir, filepath.Join(targetLibrary, dirName)); err != nil {
+		return 0, err
+	}
+	return copied, nil
+}
+
+// copyIntoLibrary copies src to dst, verifying the copy, through a temporary file next to dst
+// so an interrupted copy never takes the name of a complete one
+func copyIntoLibrary(src, dst string) error {
+	tempPath := filepath.Join(filepath.Dir(dst), ".tmp_merge_"+filepath.Base(dst))
+	if err := copyFileVerified(src, tempPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := renameNoReplace(tempPath, dst); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
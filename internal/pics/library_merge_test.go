@@ -0,0 +1,127 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibraryMerger_Merge(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "laptop")
+	target := filepath.Join(tmpDir, "desktop")
+	june := "2023 06 June 15"
+	prefix := "2023_06_June_15"
+
+	writeContentFile(t, filepath.Join(target, june), prefix+"_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(target, june), prefix+"_00002.jpg", "sunset")
+	writeContentFile(t, filepath.Join(target, june, videosDirName), prefix+"_00001.mov", "waves")
+
+	// The same day imported on the other machine, partly the same photos
+	writeContentFile(t, filepath.Join(source, june), prefix+"_00001.jpg", "dinner")
+	writeContentFile(t, filepath.Join(source, june), prefix+"_00002.jpg", "beach")
+	writeContentFile(t, filepath.Join(source, june, videosDirName), prefix+"_00001.mov", "fireworks")
+	writeContentFile(t, filepath.Join(source, june), renameJournalName, "{}")
+	// A day only the source has, with a gap in its numbering
+	writeContentFile(t, filepath.Join(source, "2023 07 July 01 Hike"), "2023_07_July_01_Hike_00001.jpg", "summit")
+	writeContentFile(t, filepath.Join(source, "2023 07 July 01 Hike"), "2023_07_July_01_Hike_00003.jpg", "lake")
+	writeContentFile(t, source, "notes.txt", "to do")
+
+	report, err := NewLibraryMerger().Merge(testCtx, source, target, DefaultMergeLibrariesOptions())
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if report.Merged != 1 || report.Created != 1 || report.Added != 4 || report.Duplicates != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if !reflect.DeepEqual(report.Skipped, []string{"notes.txt"}) {
+		t.Errorf("Expected notes.txt skipped, got %v", report.Skipped)
+	}
+
+	expected := []string{prefix + "_00001.jpg", prefix + "_00002.jpg", prefix + "_00003.jpg", videosDirName}
+	if got := listDir(t, filepath.Join(target, june)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, june, prefix+"_00003.jpg")); string(data) != "dinner" {
+		t.Errorf("Expected dinner numbered after the target's photos, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, june, videosDirName, prefix+"_00002.mov")); string(data) != "fireworks" {
+		t.Errorf("Expected fireworks numbered after the target's videos, got %q", data)
+	}
+	// New directories keep their names, and no temporary directory is left
+	expected = []string{"2023 06 June 15", "2023 07 July 01 Hike"}
+	if got := listDir(t, target); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	assertFilesExist(t, filepath.Join(target, "2023 07 July 01 Hike"), []string{"2023_07_July_01_Hike_00001.jpg", "2023_07_July_01_Hike_00003.jpg"})
+	// The source library is left as it was
+	assertFilesExist(t, filepath.Join(source, june), []string{prefix + "_00001.jpg", prefix + "_00002.jpg", renameJournalName})
+}
+
+func TestLibraryMerger_Merge_SameDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "laptop")
+	target := filepath.Join(tmpDir, "desktop")
+
+	// The day was named on the desktop only
+	writeContentFile(t, filepath.Join(target, "2023 06 June 15 Beach"), "2023_06_June_15_Beach_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(source, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(source, "2023 06 June 15"), "2023_06_June_15_00002.jpg", "dinner")
+	// A day with two events on the desktop can't tell which one the laptop's photos belong to
+	writeContentFile(t, filepath.Join(target, "2023 07 July 01 Hike"), "2023_07_July_01_Hike_00001.jpg", "summit")
+	writeContentFile(t, filepath.Join(target, "2023 07 July 01 Party"), "2023_07_July_01_Party_00001.jpg", "cake")
+	writeContentFile(t, filepath.Join(source, "2023 07 July 01"), "2023_07_July_01_00001.jpg", "lake")
+
+	report, err := NewLibraryMerger().Merge(testCtx, source, target, DefaultMergeLibrariesOptions())
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if report.Merged != 1 || report.Created != 1 || report.Added != 2 || report.Duplicates != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	// Merged files are numbered after the directory they join
+	expected := []string{"2023_06_June_15_Beach_00001.jpg", "2023_06_June_15_Beach_00002.jpg"}
+	if got := listDir(t, filepath.Join(target, "2023 06 June 15 Beach")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	expected = []string{"2023 06 June 15 Beach", "2023 07 July 01", "2023 07 July 01 Hike", "2023 07 July 01 Party"}
+	if got := listDir(t, target); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestLibraryMerger_Merge_Nested(t *testing.T) {
+	library := t.TempDir()
+	nested := filepath.Join(library, "2023 06 June 15")
+	if err := os.Mkdir(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLibraryMerger().Merge(testCtx, library, library, DefaultMergeLibrariesOptions()); err == nil {
+		t.Error("Expected an error merging a library into itself")
+	}
+	if _, err := NewLibraryMerger().Merge(testCtx, nested, library, DefaultMergeLibrariesOptions()); err == nil {
+		t.Error("Expected an error merging a library inside the target")
+	}
+}
+
+func TestLibraryMerger_Merge_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "source")
+	target := filepath.Join(tmpDir, "target")
+	writeContentFile(t, filepath.Join(source, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	if _, err := NewLibraryMerger().Merge(ctx, source, target, DefaultMergeLibrariesOptions()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the merge cancelled, got %v", err)
+	}
+	if got := listDir(t, target); len(got) != 0 {
+		t.Errorf("Expected nothing copied, got %v", got)
+	}
+}
@@ -0,0 +1,244 @@
+package pics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+// libraryActivityName is the file of a library recording when it was last imported into and
+// backed up
+const libraryActivityName = ".pics-activity.json"
+
+// libraryActivity records when a library was last imported into and backed up
+type libraryActivity struct {
+	// LastImport is when a parse last finished importing into the library
+	LastImport time.Time `json:"lastImport,omitzero"`
+	// LastBackup is when a backup or sync of the library last finished
+	LastBackup time.Time `json:"lastBackup,omitzero"`
+}
+
+// loadLibraryActivity reads the activity of a library, or returns an empty one if none was recorded
+func loadLibraryActivity(libraryDir string) (libraryActivity, error) {
+	var activity libraryActivity
+	data, err := os.ReadFile(filepath.Join(libraryDir, libraryActivityName))
+	if os.IsNotExist(err) {
+		return activity, nil
+	}
+	if err != nil {
+		return activity, err
+	}
+	if err := json.Unmarshal(data, &activity); err != nil {
+		return libraryActivity{}, fmt.Errorf("failed to read %s: %w", libraryActivityName, err)
+	}
+	return activity, nil
+}
+
+// recordLibraryActivity updates the activity of a library. The activity is only shown to the
+// user, so failing to record it is logged rather than failing the run.
+func recordLibraryActivity(libraryDir string, update func(*libraryActivity)) {
+	activity, err := loadLibraryActivity(libraryDir)
+	if err != nil {
+		logger.Warn("Replacing unreadable library activity", "library", libraryDir, "error", err)
+	}
+	update(&activity)
+
+	data, err := json.MarshalIndent(activity, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(libraryDir, libraryActivityName), data, libraryFileMode)
+	}
+	if err != nil {
+		logger.Warn("Failed to record library activity", "library", libraryDir, "error", err)
+	}
+}
+
+// largestDirectoriesCount is the number of largest date directories LibraryStats lists
+const largestDirectoriesCount = 10
+
+// YearStats counts the date directories and media files of a year of the library
+type YearStats struct {
+	// Year is the year of the date directories
+	Year int `json:"year"`
+	// Directories is the number of date directories of the year
+	Directories int `json:"directories"`
+	// Images counts the images of the year and their size
+	Images GroupStats `json:"images"`
+	// Videos counts the videos of the year and their size
+	Videos GroupStats `json:"videos"`
+	// Months breaks the year down by month, oldest first
+	Months []MonthStats `json:"months"`
+}
+
+// MonthStats counts the date directories and media files of a month of the library
+type MonthStats struct {
+	// Month is the month of the date directories
+	Month time.Month `json:"month"`
+	// Directories is the number of date directories of the month
+	Directories int `json:"directories"`
+	// Images counts the images of the month and their size
+	Images GroupStats `json:"images"`
+	// Videos counts the videos of the month and their size
+	Videos GroupStats `json:"videos"`
+}
+
+// DirectoryStats counts the media files of a date directory
+type DirectoryStats struct {
+	// Name is the name of the directory
+	Name string `json:"name"`
+	// Images counts the images of the directory and their size
+	Images GroupStats `json:"images"`
+	// Videos counts the videos of the directory, in its videos directory too, and their size
+	Videos GroupStats `json:"videos"`
+}
+
+// Bytes returns the size of the media files of the directory
+func (d DirectoryStats) Bytes() int64 {
+	return d.Images.Bytes + d.Videos.Bytes
+}
+
+// LibraryStats summarises a library for the dashboard of the desktop app and the stats command
+type LibraryStats struct {
+	// Years breaks down the date directories by year, oldest first
+	Years []YearStats `json:"years"`
+	// Named is the number of date directories named after an event, e.g. "2023 06 June 15 Beach"
+	Named int `json:"named"`
+	// Unnamed is the number of date directories with the date alone
+	Unnamed int `json:"unnamed"`
+	// JPEGs counts the JPEG images and their size, for their average size
+	JPEGs GroupStats `json:"jpegs"`
+	// Largest lists the date directories with the most bytes of media, largest first, at most
+	// largestDirectoriesCount
+	Largest []DirectoryStats `json:"largest"`
+	// LastImport is when a parse last finished importing into the library (zero = never)
+	LastImport time.Time `json:"lastImport,omitzero"`
+	// LastBackup is when a backup or sync of the library last finished (zero = never)
+	LastBackup time.Time `json:"lastBackup,omitzero"`
+}
+
+// Images returns the images of all years and their size
+func (s LibraryStats) Images() GroupStats {
+	var total GroupStats
+	for _, year := range s.Years {
+		total = GroupStats{Files: total.Files + year.Images.Files, Bytes: total.Bytes + year.Images.Bytes}
+	}
+	return total
+}
+
+// Videos returns the videos of all years and their size
+func (s LibraryStats) Videos() GroupStats {
+	var total GroupStats
+	for _, year := range s.Years {
+		total = GroupStats{Files: total.Files + year.Videos.Files, Bytes: total.Bytes + year.Videos.Bytes}
+	}
+	return total
+}
+
+// AverageJPEGSize returns the average size of the JPEG images, or 0 if there are none
+func (s LibraryStats) AverageJPEGSize() int64 {
+	if s.JPEGs.Files == 0 {
+		return 0
+	}
+	return s.JPEGs.Bytes / int64(s.JPEGs.Files)
+}
+
+// GetLibraryStats counts the media files of the date directories of libraryDir by year, month
+// and directory, and reads when the library was last imported into and backed up. Other
+// directories, dot files and ignored paths are left out.
+func (f *fileStats) GetLibraryStats(libraryDir string) (LibraryStats, error) {
+	if info, err := os.Stat(libraryDir); err != nil || !info.IsDir() {
+		return LibraryStats{}, fmt.Errorf("not a valid directory: %s", libraryDir)
+	}
+
+	var result LibraryStats
+	years := make(map[int]*YearStats)
+	months := make(map[int]map[time.Month]*MonthStats)
+	dirs := make(map[string]*DirectoryStats)
+	err := f.walk(libraryDir, func(path string, info os.FileInfo) {
+		relPath, err := filepath.Rel(libraryDir, path)
+		if err != nil {
+			return
+		}
+		dirName, _, nested := strings.Cut(relPath, string(filepath.Separator))
+		year, month, ok := naming.ParseYearMonth(dirName)
+		if !nested || !ok {
+			return
+		}
+
+		yearStats := years[year]
+		if yearStats == nil {
+			yearStats = &YearStats{Year: year}
+			years[year] = yearStats
+			months[year] = make(map[time.Month]*MonthStats)
+		}
+		monthStats := months[year][month]
+		if monthStats == nil {
+			monthStats = &MonthStats{Month: month}
+			months[year][month] = monthStats
+		}
+		dirStats := dirs[dirName]
+		if dirStats == nil {
+			dirStats = &DirectoryStats{Name: dirName}
+			dirs[dirName] = dirStats
+			yearStats.Directories++
+			monthStats.Directories++
+			if parsed, err := naming.Parse(dirName); err == nil && parsed.Name != "" {
+				result.Named++
+			} else {
+				result.Unnamed++
+			}
+		}
+		switch f.mediaClass(path) {
+		case MediaClassImage:
+			yearStats.Images = yearStats.Images.add(info.Size())
+			monthStats.Images = monthStats.Images.add(info.Size())
+			dirStats.Images = dirStats.Images.add(info.Size())
+			if f.extensions.IsJPEG(path) {
+				result.JPEGs = result.JPEGs.add(info.Size())
+			}
+		case MediaClassVideo:
+			yearStats.Videos = yearStats.Videos.add(info.Size())
+			monthStats.Videos = monthStats.Videos.add(info.Size())
+			dirStats.Videos = dirStats.Videos.add(info.Size())
+		}
+	})
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("failed to read %s: %w", libraryDir, err)
+	}
+
+	for year, stats := range years {
+		for _, monthStats := range months[year] {
+			stats.Months = append(stats.Months, *monthStats)
+		}
+		sort.Slice(stats.Months, func(i, j int) bool { return stats.Months[i].Month < stats.Months[j].Month })
+		result.Years = append(result.Years, *stats)
+	}
+	sort.Slice(result.Years, func(i, j int) bool { return result.Years[i].Year < result.Years[j].Year })
+
+	for _, stats := range dirs {
+		result.Largest = append(result.Largest, *stats)
+	}
+	// Directories of the same size are sorted by name, so the list doesn't change between runs
+	sort.Slice(result.Largest, func(i, j int) bool {
+		if result.Largest[i].Bytes() != result.Largest[j].Bytes() {
+			return result.Largest[i].Bytes() > result.Largest[j].Bytes()
+		}
+		return result.Largest[i].Name < result.Largest[j].Name
+	})
+	if len(result.Largest) > largestDirectoriesCount {
+		result.Largest = result.Largest[:largestDirectoriesCount]
+	}
+
+	activity, err := loadLibraryActivity(libraryDir)
+	if err != nil {
+		logger.Warn("Ignoring unreadable library activity", "library", libraryDir, "error", err)
+	}
+	result.LastImport, result.LastBackup = activity.LastImport, activity.LastBackup
+	return result, nil
+}
@@ -0,0 +1,146 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStats_GetLibraryStats(t *testing.T) {
+	libraryDir := t.TempDir()
+	writeContentFile(t, filepath.Join(libraryDir, "2022 12 December 25"), "a.jpg", "tree")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), "a.jpg", "beach")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15", "videos"), "a.mov", "waves")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), ".DS_Store", "x")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 07 July 01"), "a.jpg", "cake")
+	writeContentFile(t, filepath.Join(libraryDir, "review"), "a.jpg", "blurry")
+	writeContentFile(t, libraryDir, "notes.txt", "todo")
+
+	stats, err := NewFileStats().GetLibraryStats(libraryDir)
+	if err != nil {
+		t.Fatalf("GetLibraryStats failed: %v", err)
+	}
+	expected := []YearStats{
+		{Year: 2022, Directories: 1, Images: GroupStats{Files: 1, Bytes: 4}, Months: []MonthStats{
+			{Month: time.December, Directories: 1, Images: GroupStats{Files: 1, Bytes: 4}},
+		}},
+		{Year: 2023, Directories: 2, Images: GroupStats{Files: 2, Bytes: 9}, Videos: GroupStats{Files: 1, Bytes: 5}, Months: []MonthStats{
+			{Month: time.June, Directories: 1, Images: GroupStats{Files: 1, Bytes: 5}, Videos: GroupStats{Files: 1, Bytes: 5}},
+			{Month: time.July, Directories: 1, Images: GroupStats{Files: 1, Bytes: 4}},
+		}},
+	}
+	if !reflect.DeepEqual(stats.Years, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, stats.Years)
+	}
+	if !stats.LastImport.IsZero() || !stats.LastBackup.IsZero() {
+		t.Errorf("Expected no activity in a new library, got %+v", stats)
+	}
+}
+
+func TestFileStats_GetLibraryStats_Directories(t *testing.T) {
+	libraryDir := t.TempDir()
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15 Beach"), "a.jpg", "sandcastle")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15 Beach"), "b.JPEG", "waves")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15 Beach", "videos"), "a.mov", "surfing on the big waves")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 16"), "a.heic", "dinner")
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 17"), "a.jpg", "bus")
+	for day := 1; day <= largestDirectoriesCount; day++ {
+		writeContentFile(t, filepath.Join(libraryDir, fmt.Sprintf("2022 01 January %02d", day)), "a.jpg", "x")
+	}
+
+	stats, err := NewFileStats().GetLibraryStats(libraryDir)
+	if err != nil {
+		t.Fatalf("GetLibraryStats failed: %v", err)
+	}
+	if stats.Named != 1 || stats.Unnamed != 12 {
+		t.Errorf("Expected 1 named and 12 unnamed directories, got %d and %d", stats.Named, stats.Unnamed)
+	}
+	if stats.Images() != (GroupStats{Files: 14, Bytes: 34}) || stats.Videos() != (GroupStats{Files: 1, Bytes: 24}) {
+		t.Errorf("Unexpected totals %+v and %+v", stats.Images(), stats.Videos())
+	}
+	// HEIC images aren't JPEGs
+	if stats.JPEGs != (GroupStats{Files: 13, Bytes: 28}) || stats.AverageJPEGSize() != 2 {
+		t.Errorf("Unexpected JPEGs %+v averaging %d", stats.JPEGs, stats.AverageJPEGSize())
+	}
+	if len(stats.Largest) != largestDirectoriesCount {
+		t.Fatalf("Expected the %d largest directories, got %d", largestDirectoriesCount, len(stats.Largest))
+	}
+	expected := []string{"2023 06 June 15 Beach", "2023 06 June 16", "2023 06 June 17", "2022 01 January 01"}
+	for i, name := range expected {
+		if stats.Largest[i].Name != name {
+			t.Errorf("Expected %s largest at %d, got %s", name, i, stats.Largest[i].Name)
+		}
+	}
+	if stats.Largest[0].Bytes() != 39 {
+		t.Errorf("Expected 39 bytes in the largest directory, got %d", stats.Largest[0].Bytes())
+	}
+}
+
+func TestFileStats_GetLibraryStats_NonexistentDirectory(t *testing.T) {
+	if _, err := NewFileStats().GetLibraryStats(filepath.Join(t.TempDir(), "nonexistent")); err == nil {
+		t.Error("Expected error for nonexistent directory")
+	}
+}
+
+func TestRecordLibraryActivity(t *testing.T) {
+	libraryDir := t.TempDir()
+	imported := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+	backedUp := time.Date(2023, 6, 16, 10, 0, 0, 0, time.UTC)
+
+	recordLibraryActivity(libraryDir, func(a *libraryActivity) { a.LastImport = imported })
+	recordLibraryActivity(libraryDir, func(a *libraryActivity) { a.LastBackup = backedUp })
+
+	stats, err := NewFileStats().GetLibraryStats(libraryDir)
+	if err != nil {
+		t.Fatalf("GetLibraryStats failed: %v", err)
+	}
+	if !stats.LastImport.Equal(imported) || !stats.LastBackup.Equal(backedUp) {
+		t.Errorf("Expected both times kept, got %+v", stats)
+	}
+	if len(stats.Years) != 0 {
+		t.Errorf("Expected the activity file left out of the years, got %+v", stats.Years)
+	}
+}
+
+func TestRecordLibraryActivity_ReplacesUnreadableFile(t *testing.T) {
+	libraryDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(libraryDir, libraryActivityName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	imported := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+
+	recordLibraryActivity(libraryDir, func(a *libraryActivity) { a.LastImport = imported })
+
+	activity, err := loadLibraryActivity(libraryDir)
+	if err != nil {
+		t.Fatalf("loadLibraryActivity failed: %v", err)
+	}
+	if !activity.LastImport.Equal(imported) {
+		t.Errorf("Expected the import time recorded, got %+v", activity)
+	}
+}
+
+func TestBackup_SyncDirectories_RecordsLastBackup(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	libraryDir := t.TempDir()
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+
+	before := time.Now()
+	if _, err := backup.SyncDirectories(testCtx, bucket, libraryDir, SyncOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	activity, err := loadLibraryActivity(libraryDir)
+	if err != nil {
+		t.Fatalf("loadLibraryActivity failed: %v", err)
+	}
+	if activity.LastBackup.Before(before.Truncate(time.Second)) {
+		t.Errorf("Expected the sync recorded as a backup, got %+v", activity)
+	}
+}
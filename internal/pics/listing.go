@@ -0,0 +1,130 @@
+package pics
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+// BackupListing describes a directory backed up in a bucket
+type BackupListing struct {
+	// Directory is the name of the backed up directory
+	Directory string
+	// Key is the key of its archive, of the manifest of its parts, or of its incremental manifest
+	Key string
+	// Mode is how the directory is stored
+	Mode BackupMode
+	// Size is the number of bytes a restore of the directory downloads
+	Size int64
+	// Images is the number of images backed up
+	Images int
+	// Videos is the number of videos backed up
+	Videos int
+	// Parts is the number of archives of a split directory (0 if it isn't split)
+	Parts int
+	// LastModified is when the backup was last uploaded (zero if the cached listing predates it)
+	LastModified time.Time
+}
+
+// ListBackups lists the directories backed up in the bucket that a restore with opts downloads,
+// sorted by directory. Archives and split directories are described by the listing alone, while
+// the manifests of incremental backups are downloaded to count their files.
+func (b *s3Backup) ListBackups(ctx context.Context, bucket string, opts RestoreOptions) ([]BackupListing, error) {
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer b.storage.Close()
+	b = b.withObjectTimeout(opts.ObjectTimeout)
+
+	inv, err := b.listBucket(ctx, bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	objects := inv.objects()
+	selected, _ := b.selectRestoreObjects(objects, opts.Filter)
+
+	// Parts are grouped by the key of their manifest without its extension
+	partSizes := make(map[string]int64)
+	partCounts := make(map[string]int)
+	for _, obj := range objects {
+		if isArchivePartKey(obj.Key) {
+			base := archivePartKeyPattern.ReplaceAllString(obj.Key, "")
+			partSizes[base] += obj.Size
+			partCounts[base]++
+		}
+	}
+
+	listings := make([]BackupListing, 0, len(selected))
+	var incremental []StoredObject
+	for _, obj := range selected {
+		key := obj.Key
+		if isIncrementalManifestKey(key) {
+			incremental = append(incremental, obj)
+			continue
+		}
+		listing := BackupListing{
+			Directory:    b.extractDirNameFromKey(key),
+			Key:          key,
+			Mode:         BackupModeArchive,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		}
+		listing.Images, listing.Videos, _ = naming.KeyCounts(key)
+		if isManifestKey(key) {
+			base := strings.TrimSuffix(key, manifestExtension)
+			listing.Size, listing.Parts = partSizes[base], partCounts[base]
+		}
+		listings = append(listings, listing)
+	}
+
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultRestoreOptions().MaxConcurrent
+	}
+	var mu sync.Mutex
+	err = runWorkerPool(ctx, incremental, opts.MaxConcurrent, func(obj StoredObject) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		manifest, err := b.readIncrementalManifest(ctx, bucket, obj.Key)
+		if err != nil {
+			logger.Error("Failed to read incremental manifest", "key", obj.Key, "error", err)
+			return err
+		}
+		listing := BackupListing{
+			Directory:    manifest.Directory,
+			Key:          obj.Key,
+			Mode:         BackupModeIncremental,
+			LastModified: obj.LastModified,
+		}
+		for _, file := range manifest.Files {
+			listing.Size += file.Size
+			if b.extensions.IsImage(file.Path) {
+				listing.Images++
+			} else if b.extensions.IsVideo(file.Path) {
+				listing.Videos++
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		listings = append(listings, listing)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Directory != listings[j].Directory {
+			return listings[i].Directory < listings[j].Directory
+		}
+		return listings[i].Key < listings[j].Key
+	})
+	return listings, nil
+}
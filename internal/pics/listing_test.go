@@ -0,0 +1,78 @@
+package pics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackup_ListBackups(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15 vacation"), "photo1.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15 vacation"), "photo2.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15 vacation", "videos"), "video1.mov", 900)
+	writeSizedFile(t, filepath.Join(sourceDir, "2024 01 January 02"), "snow.jpg", 10)
+	writeSizedFile(t, filepath.Join(sourceDir, "review"), "blurry.jpg", 10)
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	incrementalDir := filepath.Join(t.TempDir(), "incremental")
+	writeContentFile(t, filepath.Join(incrementalDir, "2023 08 August 01"), "beach.jpg", "beach")
+	writeContentFile(t, filepath.Join(incrementalDir, "2023 08 August 01", "videos"), "waves.mov", "waves")
+	opts := DefaultBackupOptions()
+	opts.Mode = BackupModeIncremental
+	if _, err := backup.BackupDirectories(testCtx, incrementalDir, bucket, opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	restoreOpts := DefaultRestoreOptions()
+	restoreOpts.Filter = RestoreFilter{FromYear: 2023, ToYear: 2023}
+	listings, err := backup.ListBackups(testCtx, bucket, restoreOpts)
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(listings) != 2 {
+		t.Fatalf("Expected the 2 directories of 2023, got %+v", listings)
+	}
+
+	split := listings[0]
+	if split.Directory != "2023 06 June 15 vacation" || split.Mode != BackupModeArchive || split.Parts != 3 ||
+		split.Images != 2 || split.Videos != 1 || split.Key != "2023 06 June 15 vacation (2 images, 1 videos)"+manifestExtension {
+		t.Errorf("Unexpected listing of the split directory: %+v", split)
+	}
+	if split.Size <= 0 || time.Since(split.LastModified) > time.Minute {
+		t.Errorf("Expected the size and upload time of the parts, got %+v", split)
+	}
+
+	incremental := listings[1]
+	if incremental.Directory != "2023 08 August 01" || incremental.Mode != BackupModeIncremental ||
+		incremental.Images != 1 || incremental.Videos != 1 || incremental.Size != int64(len("beach")+len("waves")) {
+		t.Errorf("Unexpected listing of the incremental directory: %+v", incremental)
+	}
+
+	// Without a date range every directory is listed, including undated ones
+	listings, err = backup.ListBackups(testCtx, bucket, DefaultRestoreOptions())
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	var dirs []string
+	for _, listing := range listings {
+		dirs = append(dirs, listing.Directory)
+	}
+	want := []string{"2023 06 June 15 vacation", "2023 08 August 01", "2024 01 January 02", "review"}
+	if len(dirs) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, dirs)
+	}
+	for i := range want {
+		if dirs[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, dirs)
+			break
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+// locationSampleSize is the number of files with a GPS position read to find the place of a
+// directory, so large directories aren't read whole
+const locationSampleSize = 25
+
+// gpsFields are the metadata fields holding the GPS position of a file
+var gpsFields = []string{"GPSLatitude", "GPSLongitude"}
+
+// unnamedDateDirectories returns the names of the date directories of targetDir without an
+// event name
+func unnamedDateDirectories(targetDir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if parsed, err := naming.Parse(entry.Name()); err == nil && parsed.Name == "" {
+			dirs[entry.Name()] = true
+		}
+	}
+	return dirs, nil
+}
+
+// appendLocations appends the place most of their files were taken at to the names of the
+// unnamed date directories of targetDir that aren't in existing, returning how many were
+// renamed. A directory whose named version exists already keeps its name, as do those whose
+// place can't be found: failing to name a place is logged and doesn't stop the parse.
+func (p *mediaParser) appendLocations(ctx context.Context, targetDir string, existing map[string]bool, opts ParseOptions) (int, error) {
+	dirs, err := unnamedDateDirectories(targetDir)
+	if err != nil {
+		return 0, err
+	}
+	var created []string
+	for dirName := range dirs {
+		if !existing[dirName] {
+			created = append(created, dirName)
+		}
+	}
+	sort.Strings(created)
+
+	places := make(map[[2]float64]string)
+	located := 0
+	for i, dirName := range created {
+		if err := ctx.Err(); err != nil {
+			return located, err
+		}
+		dirPath := filepath.Join(targetDir, dirName)
+		if opts.ProgressChan != nil {
+			select {
+			case opts.ProgressChan <- ProgressEvent{
+				Stage:   "locating",
+				Current: i + 1,
+				Total:   len(created),
+				Message: i18n.T("progress.locating_directory", i+1, len(created)),
+				File:    dirPath,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "locating")
+			}
+		}
+
+		place, err := p.directoryPlace(ctx, dirPath, opts.Geocoder, places)
+		if err != nil {
+			if ctx.Err() != nil {
+				return located, ctx.Err()
+			}
+			logger.Warn("Failed to find the place of directory", "directory", dirName, "error", err)
+			continue
+		}
+		if place == "" {
+			logger.Info("No place found for directory", "directory", dirName)
+			continue
+		}
+
+		parsed, err := naming.Parse(dirName)
+		if err != nil {
+			return located, err
+		}
+		newName := naming.Format(parsed.Date, place)
+		newPath := filepath.Join(targetDir, newName)
+		if _, err := os.Stat(newPath); err == nil {
+			logger.Warn("Directory named after the place exists already, keeping the date alone", "directory", dirName, "place", place)
+			continue
+		}
+		if err := os.Rename(dirPath, newPath); err != nil {
+			return located, fmt.Errorf("failed to rename %s: %w", dirName, err)
+		}
+		logger.Info("Directory named after place", "directory", dirName, "new_name", newName)
+		located++
+	}
+	return located, nil
+}
+
+// directoryPlace returns the place most of the sampled files of dir were taken at, or "" if
+// none has a GPS position the geocoder knows. Ties go to the place found first. Places are
+// cached by position rounded to about a kilometre, so nearby files don't ask the geocoder again.
+func (p *mediaParser) directoryPlace(ctx context.Context, dir string, geocoder Geocoder, places map[[2]float64]string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	counts := make(map[string]int)
+	var best string
+	sampled := 0
+	for _, entry := range entries {
+		if sampled == locationSampleSize {
+			break
+		}
+		filePath := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !p.extensions.IsSupported(filePath) {
+			continue
+		}
+		latitude, longitude, ok := p.gpsPosition(filePath)
+		if !ok {
+			continue
+		}
+		sampled++
+
+		key := [2]float64{math.Round(latitude*100) / 100, math.Round(longitude*100) / 100}
+		place, cached := places[key]
+		if !cached {
+			if place, err = geocoder.PlaceName(ctx, latitude, longitude); err != nil {
+				return "", err
+			}
+			places[key] = placeDirName(place)
+			place = places[key]
+		}
+		if place == "" {
+			continue
+		}
+		counts[place]++
+		if counts[place] > counts[best] {
+			best = place
+		}
+	}
+	return best, nil
+}
+
+// gpsPosition returns the GPS position of a file in decimal degrees, if it has one
+func (p *mediaParser) gpsPosition(filePath string) (float64, float64, bool) {
+	fields, err := p.metadata.ReadFields(filePath, gpsFields)
+	if err != nil {
+		logger.Debug("Failed to read GPS position", "file", filePath, "error", err)
+		return 0, 0, false
+	}
+	if fields["GPSLatitude"] == "" || fields["GPSLongitude"] == "" {
+		return 0, 0, false
+	}
+	latitude, err := parseGPSCoordinate(fields["GPSLatitude"])
+	if err != nil {
+		logger.Debug("Invalid GPS latitude", "file", filePath, "error", err)
+		return 0, 0, false
+	}
+	longitude, err := parseGPSCoordinate(fields["GPSLongitude"])
+	if err != nil {
+		logger.Debug("Invalid GPS longitude", "file", filePath, "error", err)
+		return 0, 0, false
+	}
+	return latitude, longitude, true
+}
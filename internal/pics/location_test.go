@@ -0,0 +1,115 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeGeocoder names places after the latitude of a position, counting the requests
+type fakeGeocoder struct {
+	places   map[float64]string
+	requests int
+	err      error
+}
+
+func (g *fakeGeocoder) PlaceName(ctx context.Context, latitude, longitude float64) (string, error) {
+	g.requests++
+	return g.places[latitude], g.err
+}
+
+func TestAppendLocations(t *testing.T) {
+	targetDir := t.TempDir()
+	june := filepath.Join(targetDir, "2023 06 June 15")
+	july := filepath.Join(targetDir, "2023 07 July 01")
+	august := filepath.Join(targetDir, "2023 08 August 01")
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		writeContentFile(t, june, "a.jpg", "a"): {"GPSLatitude": `41 deg 23' 24.00" N`, "GPSLongitude": `2 deg 10' 12.00" E`},
+		writeContentFile(t, june, "b.jpg", "b"): {"GPSLatitude": `41 deg 23' 24.00" N`, "GPSLongitude": `2 deg 10' 12.00" E`},
+		writeContentFile(t, june, "c.mov", "c"): {"GPSLatitude": "42", "GPSLongitude": "3"},
+		// Without a GPS position
+		writeContentFile(t, july, "d.jpg", "d"):   {},
+		writeContentFile(t, august, "e.jpg", "e"): {"GPSLatitude": "51.5", "GPSLongitude": "-0.12"},
+	}}
+	geocoder := &fakeGeocoder{places: map[float64]string{41.39: "Barcelona", 42: "Girona", 51.5: "London"}}
+	parser := &mediaParser{extensions: NewExtensions(), metadata: metadata}
+
+	opts := DefaultParseOptions()
+	opts.AppendLocation = true
+	opts.Geocoder = geocoder
+	// August existed before the run, so it keeps its name
+	located, err := parser.appendLocations(testCtx, targetDir, map[string]bool{"2023 08 August 01": true}, opts)
+	if err != nil {
+		t.Fatalf("appendLocations failed: %v", err)
+	}
+	if located != 1 {
+		t.Errorf("Expected 1 directory named after a place, got %d", located)
+	}
+	for _, dir := range []string{"2023 06 June 15 Barcelona", "2023 07 July 01", "2023 08 August 01"} {
+		if _, err := os.Stat(filepath.Join(targetDir, dir)); err != nil {
+			t.Errorf("Expected directory %s: %v", dir, err)
+		}
+	}
+	// Files at the same position ask the geocoder once
+	if geocoder.requests != 2 {
+		t.Errorf("Expected 2 geocoder requests, got %d", geocoder.requests)
+	}
+}
+
+func TestAppendLocations_Conflict(t *testing.T) {
+	targetDir := t.TempDir()
+	june := filepath.Join(targetDir, "2023 06 June 15")
+	writeContentFile(t, filepath.Join(targetDir, "2023 06 June 15 Barcelona"), "2023_06_June_15_Barcelona_00001.jpg", "old")
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		writeContentFile(t, june, "a.jpg", "a"): {"GPSLatitude": "41.39", "GPSLongitude": "2.17"},
+	}}
+	parser := &mediaParser{extensions: NewExtensions(), metadata: metadata}
+
+	opts := DefaultParseOptions()
+	opts.AppendLocation = true
+	opts.Geocoder = &fakeGeocoder{places: map[float64]string{41.39: "Barcelona"}}
+	located, err := parser.appendLocations(testCtx, targetDir, map[string]bool{}, opts)
+	if err != nil {
+		t.Fatalf("appendLocations failed: %v", err)
+	}
+	if located != 0 {
+		t.Errorf("Expected no directory renamed onto an existing one, got %d", located)
+	}
+	if _, err := os.Stat(filepath.Join(june, "a.jpg")); err != nil {
+		t.Errorf("Expected the new directory to keep its name: %v", err)
+	}
+}
+
+func TestAppendLocations_GeocoderFails(t *testing.T) {
+	targetDir := t.TempDir()
+	june := filepath.Join(targetDir, "2023 06 June 15")
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		writeContentFile(t, june, "a.jpg", "a"): {"GPSLatitude": "41.39", "GPSLongitude": "2.17"},
+	}}
+	parser := &mediaParser{extensions: NewExtensions(), metadata: metadata}
+
+	opts := DefaultParseOptions()
+	opts.AppendLocation = true
+	opts.Geocoder = &fakeGeocoder{err: errors.New("network down")}
+	located, err := parser.appendLocations(testCtx, targetDir, map[string]bool{}, opts)
+	if err != nil {
+		t.Fatalf("Expected a failed lookup not to stop the parse, got %v", err)
+	}
+	if located != 0 {
+		t.Errorf("Expected no directory named, got %d", located)
+	}
+	if _, err := os.Stat(june); err != nil {
+		t.Errorf("Expected the directory to keep its name: %v", err)
+	}
+}
+
+func TestParse_AppendLocationNeedsGeocoder(t *testing.T) {
+	parser := &mediaParser{extensions: NewExtensions(), metadata: &fakeMetadata{}}
+	opts := DefaultParseOptions()
+	opts.AppendLocation = true
+	if _, err := parser.Parse(testCtx, []string{t.TempDir()}, t.TempDir(), opts); err == nil {
+		t.Error("Expected an error without a geocoder")
+	}
+}
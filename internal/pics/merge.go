@@ -0,0 +1,247 @@
+package pics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// mergeDirPattern is the pattern of the temporary directories restored archives are extracted to
+// before being merged. They are created in the restore target so files can be moved with a rename.
+const mergeDirPattern = ".pics-merge-*"
+
+// sequencedNamePattern matches the names given by the renamer, e.g. "2023_06_June_15_00012.jpg"
+var sequencedNamePattern = regexp.MustCompile(`^(.+)_(\d{5,})(\.[^.]+)$`)
+
+// mergeResult counts what merging a directory into the library did
+type mergeResult struct {
+	// added is the number of files moved into the library
+	added int
+	// duplicates is the number of files dropped because the library already had their contents
+	duplicates int
+}
+
+// placeFunc puts the file at src into the library at dst, which doesn't exist
+type placeFunc func(src, dst string) error
+
+// mergeDirectory moves the files of srcDir into dstDir, an existing directory of the library.
+// Files whose contents are already in the same subdirectory of dstDir are dropped. Sequentially
+// named files continue the numbering of their subdirectory, other files keep their names.
+func mergeDirectory(srcDir, dstDir string) (mergeResult, error) {
+	return mergeDirectoryWith(srcDir, dstDir, false, prefixRename{}, os.Rename)
+}
+
+// prefixRename renames the sequentially named files of a directory merged into one of another
+// name: files named with from are numbered on under to. The zero value keeps the names.
+type prefixRename struct {
+	from, to string
+}
+
+// apply returns the prefix a sequentially named file with prefix is numbered under
+func (r prefixRename) apply(prefix string) string {
+	if r.from != "" && prefix == r.from {
+		return r.to
+	}
+	return prefix
+}
+
+// mergeDirectoryWith merges the files of srcDir into dstDir as mergeDirectory does, putting each
+// file in place with place and numbering sequentially named files as rename says. If skipHidden
+// is set, hidden files and directories are left out. The manifest of srcDir isn't merged: the
+// manifest dstDir has, if any, is updated with the files added.
+func mergeDirectoryWith(srcDir, dstDir string, skipHidden bool, rename prefixRename, place placeFunc) (mergeResult, error) {
+	// Group incoming files by subdirectory, e.g. "" and "videos"
+	incoming := make(map[string][]string)
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if skipHidden && path != srcDir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Each directory lists its own checksums, updated below once the files are merged
+		if !info.Mode().IsRegular() || path == filepath.Join(srcDir, ChecksumManifestName) {
+			return nil
+		}
+		relDir, err := filepath.Rel(srcDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		incoming[relDir] = append(incoming[relDir], path)
+		return nil
+	})
+	if err != nil {
+		return mergeResult{}, err
+	}
+
+	relDirs := make([]string, 0, len(incoming))
+	for relDir := range incoming {
+		relDirs = append(relDirs, relDir)
+	}
+	sort.Strings(relDirs)
+
+	var result mergeResult
+	for _, relDir := range relDirs {
+		files := incoming[relDir]
+		sort.Strings(files)
+		if err := mergeFiles(files, filepath.Join(dstDir, relDir), rename, place, &result); err != nil {
+			return result, err
+		}
+	}
+	if result.added > 0 {
+		if _, err := os.Stat(filepath.Join(dstDir, ChecksumManifestName)); err == nil {
+			if _, err := updateChecksumManifest(dstDir); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// mergeFiles puts files into dstDir with place, dropping duplicates and renumbering sequentially
+// named files, under the prefix rename gives them
+func mergeFiles(files []string, dstDir string, rename prefixRename, place placeFunc, result *mergeResult) error {
+	if err := os.MkdirAll(dstDir, libraryDirMode); err != nil {
+		return err
+	}
+	existing, err := newDirContents(dstDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dstDir, err)
+	}
+
+	for _, path := range files {
+		duplicate, err := existing.contains(path)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			logger.Debug("Skipping file already in library", "file", filepath.Base(path), "directory", dstDir)
+			result.duplicates++
+			continue
+		}
+
+		name := filepath.Base(path)
+		if match := sequencedNamePattern.FindStringSubmatch(name); match != nil {
+			name = existing.nextName(rename.apply(match[1]), match[3])
+		} else if existing.hasName(name) {
+			return fmt.Errorf("cannot merge %s: a different file with the same name exists in %s", name, dstDir)
+		}
+
+		target := filepath.Join(dstDir, name)
+		if err := place(path, target); err != nil {
+			return err
+		}
+		if err := existing.add(target); err != nil {
+			return err
+		}
+		result.added++
+	}
+	return nil
+}
+
+// dirContents indexes the files of a directory by name, size and sequence number
+type dirContents struct {
+	names    map[string]bool
+	bySize   map[int64][]string
+	hashes   map[string][]byte
+	sequence map[string]int
+}
+
+// newDirContents indexes the regular files directly in dir
+func newDirContents(dir string) (*dirContents, error) {
+	c := &dirContents{
+		names:    make(map[string]bool),
+		bySize:   make(map[int64][]string),
+		hashes:   make(map[string][]byte),
+		sequence: make(map[string]int),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		c.names[entry.Name()] = true
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := c.add(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// add indexes a file of the directory
+func (c *dirContents) add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	c.names[name] = true
+	c.bySize[info.Size()] = append(c.bySize[info.Size()], path)
+	if match := sequencedNamePattern.FindStringSubmatch(name); match != nil {
+		if n, err := strconv.Atoi(match[2]); err == nil && n > c.sequence[match[1]] {
+			c.sequence[match[1]] = n
+		}
+	}
+	return nil
+}
+
+// contains reports whether the directory has a file with the same contents as path.
+// Only files of the same size are hashed.
+func (c *dirContents) contains(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	candidates := c.bySize[info.Size()]
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range candidates {
+		candidateSum, ok := c.hashes[candidate]
+		if !ok {
+			if candidateSum, err = fileSHA256(candidate); err != nil {
+				return false, err
+			}
+			c.hashes[candidate] = candidateSum
+		}
+		if bytes.Equal(sum, candidateSum) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasName reports whether the directory has an entry called name
+func (c *dirContents) hasName(name string) bool {
+	return c.names[name]
+}
+
+// nextName returns the first free name after the highest sequence number used with prefix
+func (c *dirContents) nextName(prefix, ext string) string {
+	n := c.sequence[prefix]
+	for {
+		n++
+		name := fmt.Sprintf("%s_%05d%s", prefix, n, ext)
+		if !c.names[name] {
+			return name
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeContentFile(t *testing.T, dir, filename, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create directory %s: %v", dir, err)
+	}
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create file %s: %v", filename, err)
+	}
+	return path
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMergeDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "incoming")
+	dst := filepath.Join(tmpDir, "library")
+
+	prefix := "2023_06_June_15"
+	writeContentFile(t, dst, prefix+"_00001.jpg", "beach")
+	writeContentFile(t, dst, prefix+"_00002.heic", "sunset")
+	writeContentFile(t, filepath.Join(dst, "videos"), prefix+"_00001.mov", "waves")
+
+	// The incoming album overlaps with the library: beach and waves are already there
+	writeContentFile(t, src, prefix+"_00001.jpg", "dinner")
+	writeContentFile(t, src, prefix+"_00002.jpg", "beach")
+	writeContentFile(t, src, prefix+"_00003.heic", "stars")
+	writeContentFile(t, src, "notes.txt", "packing list")
+	writeContentFile(t, filepath.Join(src, "videos"), prefix+"_00001.mov", "waves")
+	writeContentFile(t, filepath.Join(src, "videos"), prefix+"_00002.mov", "fireworks")
+
+	result, err := mergeDirectory(src, dst)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.added != 4 || result.duplicates != 2 {
+		t.Errorf("Expected 4 added and 2 duplicates, got %+v", result)
+	}
+
+	expected := []string{
+		prefix + "_00001.jpg",
+		prefix + "_00002.heic",
+		prefix + "_00003.jpg",
+		prefix + "_00004.heic",
+		"notes.txt",
+		"videos",
+	}
+	if got := listDir(t, dst); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	expectedVideos := []string{prefix + "_00001.mov", prefix + "_00002.mov"}
+	if got := listDir(t, filepath.Join(dst, "videos")); !reflect.DeepEqual(got, expectedVideos) {
+		t.Errorf("Expected videos %v, got %v", expectedVideos, got)
+	}
+
+	// Existing files are untouched, incoming files continue the numbering in name order
+	contents := map[string]string{
+		prefix + "_00001.jpg":  "beach",
+		prefix + "_00003.jpg":  "dinner",
+		prefix + "_00004.heic": "stars",
+	}
+	for name, want := range contents {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("Expected %s to hold %q, got %q", name, want, data)
+		}
+	}
+}
+
+func TestMergeDirectory_NewSubdirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "incoming")
+	dst := filepath.Join(tmpDir, "library")
+
+	writeContentFile(t, dst, "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(src, "videos"), "2023_06_June_15_00001.mov", "waves")
+
+	result, err := mergeDirectory(src, dst)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.added != 1 {
+		t.Errorf("Expected 1 file added, got %+v", result)
+	}
+	assertFileExists(t, filepath.Join(dst, "videos", "2023_06_June_15_00001.mov"))
+}
+
+func TestMergeDirectory_NameConflict(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "incoming")
+	dst := filepath.Join(tmpDir, "library")
+
+	writeContentFile(t, dst, "notes.txt", "old notes")
+	writeContentFile(t, src, "notes.txt", "new notes")
+
+	if _, err := mergeDirectory(src, dst); err == nil {
+		t.Error("Expected error when a different file with the same name exists")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "notes.txt"))
+	if err != nil || string(data) != "old notes" {
+		t.Errorf("Expected existing file to be kept, got %q (%v)", data, err)
+	}
+}
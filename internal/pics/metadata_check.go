@@ -0,0 +1,81 @@
+package pics
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// keyMetadataFields are the EXIF fields compression must keep: the capture date files are
+// organised by, how the image is displayed and where it was taken
+var keyMetadataFields = []string{"DateTimeOriginal", "Orientation", "GPSLatitude", "GPSLongitude"}
+
+// MetadataReader defines the interface for reading EXIF metadata
+type MetadataReader interface {
+	// ReadFields returns the values of the given fields in a file. Fields the file
+	// doesn't have are left out.
+	ReadFields(filePath string, fields []string) (map[string]string, error)
+}
+
+// exifMetadataReader implements the MetadataReader interface
+type exifMetadataReader struct {
+	et *exiftool.Exiftool
+}
+
+// NewMetadataReader creates a new MetadataReader instance
+func NewMetadataReader(et *exiftool.Exiftool) MetadataReader {
+	return &exifMetadataReader{
+		et: et,
+	}
+}
+
+// ReadFields reads the given fields of a file with exiftool
+func (r *exifMetadataReader) ReadFields(filePath string, fields []string) (map[string]string, error) {
+	if r.et == nil {
+		return nil, fmt.Errorf("exiftool not initialised")
+	}
+
+	fileInfos := r.et.ExtractMetadata(filePath)
+	if len(fileInfos) == 0 {
+		return nil, fmt.Errorf("no metadata found")
+	}
+	if fileInfos[0].Err != nil {
+		return nil, fileInfos[0].Err
+	}
+
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if val, err := fileInfos[0].GetString(field); err == nil {
+			values[field] = val
+		}
+	}
+	return values, nil
+}
+
+// lostMetadataFields returns the fields of before that are missing or different in after, in
+// the order of fields
+func lostMetadataFields(fields []string, before, after map[string]string) []string {
+	var lost []string
+	for _, field := range fields {
+		val, ok := before[field]
+		if !ok {
+			continue
+		}
+		if after[field] != val {
+			lost = append(lost, field)
+		}
+	}
+	return lost
+}
+
+// shouldVerifyMetadata reports whether a compressed file is picked for the metadata check
+func shouldVerifyMetadata(opts ParseOptions) bool {
+	switch {
+	case opts.VerifyMetadataRate <= 0:
+		return false
+	case opts.VerifyMetadataRate >= 1:
+		return true
+	}
+	return rand.Float64() < opts.VerifyMetadataRate
+}
@@ -0,0 +1,164 @@
+package pics
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// fakeMetadata stands in for exiftool: it holds the EXIF fields of each file
+type fakeMetadata struct {
+	fields map[string]map[string]string
+}
+
+func (m *fakeMetadata) ReadFields(filePath string, fields []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, field := range fields {
+		if val, ok := m.fields[filePath][field]; ok {
+			values[field] = val
+		}
+	}
+	return values, nil
+}
+
+// strippingCompressor stands in for a compressor that drops the given EXIF fields
+type strippingCompressor struct {
+	metadata *fakeMetadata
+	drop     []string
+}
+
+func (c *strippingCompressor) CompressFile(ctx context.Context, path string, opts CompressOptions) error {
+	for _, field := range c.drop {
+		delete(c.metadata.fields[path], field)
+	}
+	return nil
+}
+
+func TestLostMetadataFields(t *testing.T) {
+	before := map[string]string{
+		"DateTimeOriginal": "2023:06:15 10:30:00",
+		"Orientation":      "Rotate 90 CW",
+		"GPSLatitude":      "51 deg 30' 0.00\" N",
+	}
+
+	tests := []struct {
+		name     string
+		after    map[string]string
+		expected []string
+	}{
+		{name: "all kept", after: before, expected: nil},
+		{
+			name:     "GPS dropped",
+			after:    map[string]string{"DateTimeOriginal": "2023:06:15 10:30:00", "Orientation": "Rotate 90 CW"},
+			expected: []string{"GPSLatitude"},
+		},
+		{
+			name:     "orientation reset",
+			after:    map[string]string{"DateTimeOriginal": "2023:06:15 10:30:00", "Orientation": "Horizontal (normal)", "GPSLatitude": "51 deg 30' 0.00\" N"},
+			expected: []string{"Orientation"},
+		},
+		{name: "everything dropped", after: map[string]string{}, expected: []string{"DateTimeOriginal", "Orientation", "GPSLatitude"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lost := lostMetadataFields(keyMetadataFields, before, tt.after)
+			if !reflect.DeepEqual(lost, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, lost)
+			}
+		})
+	}
+}
+
+func TestLostMetadataFields_IgnoresFieldsMissingBefore(t *testing.T) {
+	// A screenshot has no GPS position to lose
+	before := map[string]string{"DateTimeOriginal": "2023:06:15 10:30:00"}
+	after := map[string]string{"DateTimeOriginal": "2023:06:15 10:30:00"}
+	if lost := lostMetadataFields(keyMetadataFields, before, after); len(lost) != 0 {
+		t.Errorf("Expected no lost fields, got %v", lost)
+	}
+}
+
+func TestShouldVerifyMetadata(t *testing.T) {
+	tests := []struct {
+		rate     float64
+		expected bool
+	}{
+		{rate: 0, expected: false},
+		{rate: -1, expected: false},
+		{rate: 1, expected: true},
+		{rate: 2, expected: true},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultParseOptions()
+		opts.VerifyMetadataRate = tt.rate
+		if got := shouldVerifyMetadata(opts); got != tt.expected {
+			t.Errorf("Rate %v: expected %v, got %v", tt.rate, tt.expected, got)
+		}
+	}
+}
+
+func TestMetadataReader_NilExiftool(t *testing.T) {
+	reader := NewMetadataReader(nil)
+	if _, err := reader.ReadFields("photo.jpg", keyMetadataFields); err == nil {
+		t.Error("Expected error when exiftool is not initialised")
+	}
+}
+
+func TestMediaParser_ProcessFile_VerifiesMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		drop        []string
+		expectError bool
+	}{
+		{name: "metadata kept", rate: 1},
+		{name: "GPS dropped", rate: 1, drop: []string{"GPSLatitude", "GPSLongitude"}, expectError: true},
+		{name: "check disabled", rate: 0, drop: []string{"DateTimeOriginal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+			dest := filepath.Join(tmpDir, "copy.jpg")
+
+			metadata := &fakeMetadata{fields: map[string]map[string]string{
+				dest: {
+					"DateTimeOriginal": "2023:06:15 10:30:00",
+					"GPSLatitude":      "51 deg 30' 0.00\" N",
+					"GPSLongitude":     "0 deg 7' 0.00\" W",
+				},
+			}}
+			parser := &mediaParser{
+				codecs:     ImageCodecs{ImageFormatJPEG: NewJPEGCodec(&strippingCompressor{metadata: metadata, drop: tt.drop}, false)},
+				extensions: NewExtensions(),
+				exifWriter: NewExifWriter(nil),
+				metadata:   metadata,
+			}
+
+			opts := testParseOptions
+			opts.CompressJPEGs = true
+			opts.VerifyMetadataRate = tt.rate
+			file := discoveredFile(t, src, dest)
+			file.isJPEG = true
+
+			err := parser.processFile(context.Background(), &file, opts, logger.With("file", src), nil)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), "GPSLatitude, GPSLongitude") {
+					t.Errorf("Expected error naming the dropped fields, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package pics
+
+import (
+	"strings"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+// MilestoneKind identifies what a Milestone announces
+type MilestoneKind string
+
+const (
+	MilestoneStarted   MilestoneKind = "started"
+	MilestoneProgress  MilestoneKind = "progress"
+	MilestoneCompleted MilestoneKind = "completed"
+)
+
+// Milestone is a progress update worth announcing to the user, such as a stage starting or
+// reaching half way, as opposed to the per-file ProgressEvent stream
+type Milestone struct {
+	// Stage is the processing stage the milestone belongs to
+	Stage string
+	// Kind tells whether the stage started, passed a percentage threshold or completed
+	Kind MilestoneKind
+	// Percent is how much of the stage is done
+	Percent int
+	// Message is a short, localised announcement of the milestone
+	Message string
+}
+
+// MilestoneTracker turns a stream of progress events into milestones
+type MilestoneTracker interface {
+	// Track returns the milestones reached by an event, if any
+	Track(event ProgressEvent) []Milestone
+	// Finish returns the completion of the stages not already announced, for streams that end
+	// before their final events arrive
+	Finish() []Milestone
+}
+
+// milestoneTracker implements the MilestoneTracker interface. It is not safe for concurrent use.
+type milestoneTracker struct {
+	thresholds []int
+	stages     map[string]*stageMilestones
+	// order lists the stages in the order they started, to complete them in that order
+	order []string
+}
+
+// stageMilestones is how far the milestones of a stage have got
+type stageMilestones struct {
+	total     int
+	percent   int
+	completed bool
+}
+
+// DefaultMilestoneThresholds returns the percentages announced by default
+func DefaultMilestoneThresholds() []int {
+	return []int{25, 50, 75}
+}
+
+// NewMilestoneTracker creates a MilestoneTracker announcing the given percentages, in ascending order
+func NewMilestoneTracker(thresholds []int) MilestoneTracker {
+	return &milestoneTracker{thresholds: thresholds, stages: make(map[string]*stageMilestones)}
+}
+
+// Track returns the milestones reached by an event. Stages are tracked apart, as Parse alternates
+// between them file by file; a new total for a stage completes it and starts it again.
+// Events from concurrent workers may arrive out of order, so progress never goes backwards.
+func (t *milestoneTracker) Track(event ProgressEvent) []Milestone {
+	var milestones []Milestone
+	stage, ok := t.stages[event.Stage]
+	if !ok || event.Total != stage.total {
+		if ok {
+			milestones = append(milestones, t.complete(event.Stage, stage)...)
+		} else {
+			t.order = append(t.order, event.Stage)
+		}
+		stage = &stageMilestones{total: event.Total}
+		t.stages[event.Stage] = stage
+		milestones = append(milestones, t.milestone(event.Stage, MilestoneStarted, 0))
+	}
+	if stage.completed || event.Total <= 0 {
+		return milestones
+	}
+
+	percent := min(event.Current*100/event.Total, 100)
+	if percent >= 100 {
+		stage.completed = true
+		return append(milestones, t.milestone(event.Stage, MilestoneCompleted, 100))
+	}
+
+	// Only the highest threshold passed is announced, so a jump doesn't flood the user
+	passed := 0
+	for _, threshold := range t.thresholds {
+		if percent >= threshold && threshold > stage.percent {
+			passed = threshold
+		}
+	}
+	if passed > 0 {
+		stage.percent = passed
+		milestones = append(milestones, t.milestone(event.Stage, MilestoneProgress, passed))
+	}
+	return milestones
+}
+
+// Finish returns the completion of every stage not already announced, in the order the stages
+// started, and forgets them
+func (t *milestoneTracker) Finish() []Milestone {
+	var milestones []Milestone
+	for _, name := range t.order {
+		milestones = append(milestones, t.complete(name, t.stages[name])...)
+	}
+	t.stages, t.order = make(map[string]*stageMilestones), nil
+	return milestones
+}
+
+// complete returns the completion of a stage unless it was already announced
+func (t *milestoneTracker) complete(name string, stage *stageMilestones) []Milestone {
+	if stage.completed {
+		return nil
+	}
+	stage.completed = true
+	return []Milestone{t.milestone(name, MilestoneCompleted, 100)}
+}
+
+// milestone builds a milestone of stage with its localised message
+func (t *milestoneTracker) milestone(stage string, kind MilestoneKind, percent int) Milestone {
+	stageName := i18n.T("stage." + strings.ReplaceAll(stage, " ", "_"))
+	var message string
+	switch kind {
+	case MilestoneStarted:
+		message = i18n.T("milestone.started", stageName)
+	case MilestoneProgress:
+		message = i18n.T("milestone.progress", stageName, percent)
+	case MilestoneCompleted:
+		message = i18n.T("milestone.completed", stageName)
+	}
+	return Milestone{Stage: stage, Kind: kind, Percent: percent, Message: message}
+}
@@ -0,0 +1,137 @@
+package pics
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+// trackAll feeds events to a tracker and returns every milestone reached
+func trackAll(tracker MilestoneTracker, events []ProgressEvent) []Milestone {
+	var milestones []Milestone
+	for _, event := range events {
+		milestones = append(milestones, tracker.Track(event)...)
+	}
+	return milestones
+}
+
+func TestMilestoneTracker_Thresholds(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.English)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	var events []ProgressEvent
+	for i := 1; i <= 8; i++ {
+		events = append(events, ProgressEvent{Stage: "copying", Current: i, Total: 8})
+	}
+
+	milestones := trackAll(NewMilestoneTracker(DefaultMilestoneThresholds()), events)
+	expected := []Milestone{
+		{Stage: "copying", Kind: MilestoneStarted, Percent: 0, Message: "Copying started"},
+		{Stage: "copying", Kind: MilestoneProgress, Percent: 25, Message: "Copying 25% complete"},
+		{Stage: "copying", Kind: MilestoneProgress, Percent: 50, Message: "Copying 50% complete"},
+		{Stage: "copying", Kind: MilestoneProgress, Percent: 75, Message: "Copying 75% complete"},
+		{Stage: "copying", Kind: MilestoneCompleted, Percent: 100, Message: "Copying complete"},
+	}
+	if !reflect.DeepEqual(milestones, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, milestones)
+	}
+}
+
+func TestMilestoneTracker_JumpsAnnounceHighestThreshold(t *testing.T) {
+	tracker := NewMilestoneTracker(DefaultMilestoneThresholds())
+	milestones := trackAll(tracker, []ProgressEvent{
+		{Stage: "restoring", Current: 1, Total: 100},
+		{Stage: "restoring", Current: 80, Total: 100},
+		// Out of order event from a concurrent worker
+		{Stage: "restoring", Current: 60, Total: 100},
+	})
+
+	if len(milestones) != 2 || milestones[1].Kind != MilestoneProgress || milestones[1].Percent != 75 {
+		t.Errorf("Expected start and a single 75%% milestone, got %+v", milestones)
+	}
+}
+
+func TestMilestoneTracker_StageChanges(t *testing.T) {
+	tracker := NewMilestoneTracker(nil)
+	milestones := trackAll(tracker, []ProgressEvent{
+		// The last copying events are dropped, so finishing completes it
+		{Stage: "copying", Current: 1, Total: 4},
+		{Stage: "compressing", Current: 1, Total: 2},
+		{Stage: "compressing", Current: 2, Total: 2},
+		// Same stage with a new total starts a new phase
+		{Stage: "organising", Current: 1, Total: 10},
+		{Stage: "organising", Current: 1, Total: 3},
+	})
+	milestones = append(milestones, tracker.Finish()...)
+
+	var kinds []string
+	for _, milestone := range milestones {
+		kinds = append(kinds, milestone.Stage+" "+string(milestone.Kind))
+	}
+	expected := []string{
+		"copying started",
+		"compressing started",
+		"compressing completed",
+		"organising started",
+		"organising completed",
+		"organising started",
+		"copying completed",
+		"organising completed",
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected %v, got %v", expected, kinds)
+	}
+
+	if finished := tracker.Finish(); finished != nil {
+		t.Errorf("Expected nothing after finishing twice, got %+v", finished)
+	}
+}
+
+func TestMilestoneTracker_InterleavedStages(t *testing.T) {
+	// Parse copies each file and then compresses it before moving on to the next
+	var events []ProgressEvent
+	for i := 1; i <= 10; i++ {
+		events = append(events,
+			ProgressEvent{Stage: "copying", Current: i, Total: 10},
+			ProgressEvent{Stage: "compressing", Current: i, Total: 10})
+	}
+	tracker := NewMilestoneTracker(DefaultMilestoneThresholds())
+	milestones := append(trackAll(tracker, events), tracker.Finish()...)
+
+	var kinds []string
+	for _, milestone := range milestones {
+		kinds = append(kinds, fmt.Sprintf("%s %s %d", milestone.Stage, milestone.Kind, milestone.Percent))
+	}
+	expected := []string{
+		"copying started 0",
+		"compressing started 0",
+		"copying progress 25",
+		"compressing progress 25",
+		"copying progress 50",
+		"compressing progress 50",
+		"copying progress 75",
+		"compressing progress 75",
+		"copying completed 100",
+		"compressing completed 100",
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected %v, got %v", expected, kinds)
+	}
+}
+
+func TestMilestoneTracker_Spanish(t *testing.T) {
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(i18n.Spanish)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+
+	milestones := trackAll(NewMilestoneTracker([]int{50}), []ProgressEvent{
+		{Stage: "backing up", Current: 1, Total: 2},
+	})
+	expected := []string{"Copia de seguridad: inicio", "Copia de seguridad: 50% completado"}
+	if len(milestones) != 2 || milestones[0].Message != expected[0] || milestones[1].Message != expected[1] {
+		t.Errorf("Expected %v, got %+v", expected, milestones)
+	}
+}
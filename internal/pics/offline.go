@@ -0,0 +1,58 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrOffline is returned for network access attempted while offline mode is enabled
+var ErrOffline = errors.New("network access is disabled in offline mode")
+
+var (
+	offlineMu       sync.Mutex
+	offline         bool
+	onlineTransport = http.DefaultTransport
+	onlineResolver  = net.DefaultResolver
+)
+
+// offlineTransport is an http.RoundTripper that refuses every request
+type offlineTransport struct{}
+
+// RoundTrip refuses the request
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, ErrOffline
+}
+
+// SetOffline enables or disables offline mode. While enabled, HTTP requests through
+// http.DefaultTransport, DNS lookups through net.DefaultResolver and NewS3Backup all fail
+// with ErrOffline. External tools such as exiftool and jpegoptim run as local processes
+// and are not affected.
+func SetOffline(enabled bool) {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+
+	offline = enabled
+	if !enabled {
+		http.DefaultTransport = onlineTransport
+		net.DefaultResolver = onlineResolver
+		return
+	}
+
+	http.DefaultTransport = offlineTransport{}
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, ErrOffline
+		},
+	}
+}
+
+// IsOffline reports whether offline mode is enabled
+func IsOffline() bool {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	return offline
+}
@@ -0,0 +1,54 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetOffline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	SetOffline(true)
+	t.Cleanup(func() { SetOffline(false) })
+
+	if !IsOffline() {
+		t.Error("Expected offline mode to be enabled")
+	}
+	if _, err := http.Get(server.URL); !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected HTTP request to fail with ErrOffline, got: %v", err)
+	}
+	if _, err := (&http.Client{}).Get(server.URL); !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected client without transport to fail with ErrOffline, got: %v", err)
+	}
+	if _, err := NewS3Backup(context.Background(), DefaultS3Config()); !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected NewS3Backup to fail with ErrOffline, got: %v", err)
+	}
+
+	SetOffline(false)
+	if IsOffline() {
+		t.Error("Expected offline mode to be disabled")
+	}
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected HTTP request to succeed once back online, got: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestSetOffline_DNS(t *testing.T) {
+	SetOffline(true)
+	t.Cleanup(func() { SetOffline(false) })
+
+	// net.DNSError keeps only the message of the dial error
+	if _, err := net.DefaultResolver.LookupHost(context.Background(), "example.com"); err == nil || !strings.Contains(err.Error(), ErrOffline.Error()) {
+		t.Errorf("Expected DNS lookup to fail with ErrOffline, got: %v", err)
+	}
+}
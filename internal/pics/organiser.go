@@ -1,22 +1,68 @@
 package pics
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
 	"github.com/barasher/go-exiftool"
 )
 
+// organiseBatchSize is the number of files dated with a single request to exiftool and moved
+// by one worker at a time
+const organiseBatchSize = 64
+
+// OrganiseOptions holds configuration options for organising files into date directories.
+type OrganiseOptions struct {
+	// TimeZone is the time zone files are dated in (nil = in the offset each file was taken in,
+	// see AggregatedFileDateExtractor.GetFileDateIn).
+	TimeZone *time.Location
+	// MaxConcurrency is the maximum number of batches of files dated and moved concurrently
+	// (0 = unlimited).
+	MaxConcurrency int
+	// ProgressChan is an optional channel for receiving progress events.
+	ProgressChan chan<- ProgressEvent
+	// OnMoved, if set, is called with the name of the directory of targetDir each file is moved
+	// to. It is called from several goroutines at once when MaxConcurrency allows it.
+	OnMoved func(dirName string)
+}
+
+// DefaultOrganiseOptions returns the default options for organising files.
+func DefaultOrganiseOptions() OrganiseOptions {
+	return OrganiseOptions{
+		TimeZone:       nil,
+		MaxConcurrency: 0,
+		ProgressChan:   nil,
+		OnMoved:        nil,
+	}
+}
+
 // FileOrganiser defines the interface for organising files
 type FileOrganiser interface {
-	// OrganiseByDate moves files to date-based directories.
-	OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) error
+	// OrganiseByDate moves files to date-based directories, dated in opts.TimeZone.
+	// Files with implausible dates go to the review directory instead and are returned.
+	// Cancelling ctx stops it before the next file, leaving the rest in sourceDir.
+	OrganiseByDate(ctx context.Context, sourceDir, targetDir string, opts OrganiseOptions) ([]ReviewFile, error)
+	// OrganiseIntoDate moves all files to the directory of the given date, such as the date
+	// assigned to scans, without checking its plausibility. It is cancelled as OrganiseByDate is.
+	OrganiseIntoDate(ctx context.Context, sourceDir, targetDir string, date time.Time, opts OrganiseOptions) error
 	// OrganiseVideosAndRenameImages organises videos into subdirectories and renames images sequentially.
-	// Uses FileRenamer which also stores original filenames in EXIF before renaming.
-	OrganiseVideosAndRenameImages(targetDir string, progressChan chan<- ProgressEvent) error
+	// Uses FileRenamer which also stores original filenames in EXIF before renaming. Files of the same date
+	// are numbered in the given order.
+	OrganiseVideosAndRenameImages(targetDir string, order SequenceOrder, progressChan chan<- ProgressEvent) error
+	// OrganiseDirectories organises videos and renames images as OrganiseVideosAndRenameImages does,
+	// in the given directories of targetDir only.
+	OrganiseDirectories(targetDir string, dirNames []string, order SequenceOrder, progressChan chan<- ProgressEvent) error
 }
 
 // fileOrganiser implements the FileOrganiser interface
@@ -24,59 +70,185 @@ type fileOrganiser struct {
 	dateExtractor *AggregatedFileDateExtractor
 	extensions    Extensions
 	fileRenamer   FileRenamer
+	now           func() time.Time
 }
 
 // NewFileOrganiser creates a new FileOrganiser instance
 func NewFileOrganiser(et *exiftool.Exiftool) FileOrganiser {
+	return NewFileOrganiserWithWriter(et, NewExifWriter(et))
+}
+
+// NewFileOrganiserWithWriter creates a new FileOrganiser writing OriginalFileName with exifWriter
+func NewFileOrganiserWithWriter(et *exiftool.Exiftool, exifWriter ExifWriter) FileOrganiser {
 	return &fileOrganiser{
 		dateExtractor: NewFileDateExtractor(et),
 		extensions:    NewExtensions(),
-		fileRenamer:   NewFileRenamer(et),
+		fileRenamer:   NewFileRenamerWithWriter(et, exifWriter),
+		now:           time.Now,
 	}
 }
 
-// OrganiseByDate moves files to date-based directories
-func (o *fileOrganiser) OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) error {
-	logger.Info("OrganiseByDate started", "sourceDir", sourceDir, "targetDir", targetDir)
+// OrganiseByDate moves files to date-based directories, and files with implausible dates
+// to the review directory
+func (o *fileOrganiser) OrganiseByDate(ctx context.Context, sourceDir, targetDir string, opts OrganiseOptions) ([]ReviewFile, error) {
+	logger.Info("OrganiseByDate started", "sourceDir", sourceDir, "targetDir", targetDir, "zone", opts.TimeZone)
+	return o.organise(ctx, sourceDir, targetDir, time.Time{}, opts)
+}
+
+// OrganiseIntoDate moves all files to the directory of date
+func (o *fileOrganiser) OrganiseIntoDate(ctx context.Context, sourceDir, targetDir string, date time.Time, opts OrganiseOptions) error {
+	logger.Info("OrganiseIntoDate started", "sourceDir", sourceDir, "targetDir", targetDir, "date", date)
+	opts.TimeZone = nil
+	_, err := o.organise(ctx, sourceDir, targetDir, date, opts)
+	return err
+}
+
+// organiseRun holds the state the workers organising a directory share
+type organiseRun struct {
+	targetDir string
+	assigned  time.Time
+	now       time.Time
+	total     int
+	moved     atomic.Int64
+	// named maps the names of dates whose directory is named after a place to that directory
+	named map[string]string
+
+	mu     sync.Mutex
+	review []ReviewFile
+}
 
+// organise moves files to date-based directories. Files take the assigned date if it is set,
+// otherwise their own in opts.TimeZone, and go to the review directory when it is implausible.
+// Files are dated and moved in batches by up to opts.MaxConcurrency workers, each batch dated
+// with a single request to exiftool. Cancelling ctx, or a batch failing, stops the workers
+// before their next file.
+func (o *fileOrganiser) organise(ctx context.Context, sourceDir, targetDir string, assigned time.Time, opts OrganiseOptions) ([]ReviewFile, error) {
 	entries, err := os.ReadDir(sourceDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.Info("Directory read complete", "entries", len(entries))
 
-	// Count total files
-	totalFiles := 0
+	var files []string
 	for _, entry := range entries {
 		if !entry.IsDir() {
-			totalFiles++
+			files = append(files, filepath.Join(sourceDir, entry.Name()))
 		}
 	}
-	logger.Debug("Counted files", "totalFiles", totalFiles)
+	logger.Debug("Counted files", "totalFiles", len(files))
 
-	current := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+	var batches [][]string
+	for start := 0; start < len(files); start += organiseBatchSize {
+		batches = append(batches, files[start:min(start+organiseBatchSize, len(files))])
+	}
+	numWorkers := opts.MaxConcurrency
+	if numWorkers <= 0 || numWorkers > len(batches) {
+		numWorkers = len(batches)
+	}
+
+	named, err := namedDateDirectories(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target directory: %w", err)
+	}
+	run := &organiseRun{targetDir: targetDir, assigned: assigned, now: o.now(), total: len(files), named: named}
+	// The first batch to fail stops the others
+	workCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	jobs := make(chan []string)
+	var wg sync.WaitGroup
+	var failed collectedErrors
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				if err := o.organiseBatch(workCtx, batch, run, opts); err != nil {
+					failed.add(err)
+					stop()
+				}
+			}
+		}()
+	}
+feed:
+	for _, batch := range batches {
+		select {
+		case jobs <- batch:
+		case <-workCtx.Done():
+			break feed
 		}
-		filePath := filepath.Join(sourceDir, entry.Name())
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Skip invalid/corrupted files
+	if err := ctx.Err(); err != nil {
+		moved := int(run.moved.Load())
+		logger.Info("Organising stopped", "moved", moved, "left", len(files)-moved)
+		return nil, err
+	}
+	if len(failed.errs) > 0 {
+		return nil, failed.errs[0]
+	}
+	sort.Slice(run.review, func(i, j int) bool { return run.review[i].Name < run.review[j].Name })
+	return run.review, nil
+}
+
+// organiseBatch dates the files of a batch and moves them to their directories
+func (o *fileOrganiser) organiseBatch(ctx context.Context, batch []string, run *organiseRun, opts OrganiseOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Skip invalid/corrupted files
+	var valid []string
+	for _, filePath := range batch {
 		if err := isValidFile(filePath); err != nil {
-			logger.Warn("Skipping file", "file", entry.Name(), "reason", err)
+			logger.Warn("Skipping file", "file", filepath.Base(filePath), "reason", err)
 			continue
 		}
+		valid = append(valid, filePath)
+	}
+
+	dirNames := make([]string, len(valid))
+	if !run.assigned.IsZero() {
+		for i := range valid {
+			dirNames[i] = naming.Format(run.assigned, "")
+		}
+	} else if len(valid) > 0 {
+		// Get file dates from EXIF if available, otherwise use ModTime
+		logger.Debug("Extracting dates", "files", len(valid))
+		dates, err := o.dateExtractor.GetFileDatesIn(valid, opts.TimeZone)
+		if err != nil {
+			logger.Error("Failed to get file date", "error", err)
+			return err
+		}
+		for i, filePath := range valid {
+			name := filepath.Base(filePath)
+			logger.Debug("Date extracted", "file", name, "date", dates[i])
+			dirNames[i] = naming.Format(dates[i], "")
+			if reason := implausibleDateReason(dates[i], run.now); reason != "" {
+				logger.Warn("Implausible file date, moving file to review", "file", name, "date", dates[i], "reason", reason)
+				run.mu.Lock()
+				run.review = append(run.review, ReviewFile{Name: name, Date: dates[i], Reason: reason})
+				run.mu.Unlock()
+				dirNames[i] = ReviewDirName
+			}
+		}
+	}
 
-		current++
+	for i, filePath := range valid {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		current := int(run.moved.Add(1))
 
 		// Emit progress event
-		if progressChan != nil {
+		if opts.ProgressChan != nil {
 			select {
-			case progressChan <- ProgressEvent{
+			case opts.ProgressChan <- ProgressEvent{
 				Stage:   "organising",
 				Current: current,
-				Total:   totalFiles,
-				Message: fmt.Sprintf("Organising file %d of %d", current, totalFiles),
+				Total:   run.total,
+				Message: i18n.T("progress.organising_file", current, run.total),
 				File:    filePath,
 			}:
 			default:
@@ -84,49 +256,86 @@ func (o *fileOrganiser) OrganiseByDate(sourceDir, targetDir string, progressChan
 			}
 		}
 
-		// Get file date from EXIF if available, otherwise use ModTime
-		logger.Debug("Extracting date", "file", entry.Name(), "current", current, "total", totalFiles)
-		fileDate, err := o.dateExtractor.GetFileDate(filePath)
-		if err != nil {
-			logger.Error("Failed to get file date", "file", entry.Name(), "error", err)
-			return err
+		dirName := dirNames[i]
+		if named, ok := run.named[dirName]; ok {
+			dirName = named
 		}
-		logger.Debug("Date extracted", "file", entry.Name(), "date", fileDate)
-
-		dirName := fileDate.Format("2006 01 January 02")
-		destDir := filepath.Join(targetDir, dirName)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
+		if err := moveToDir(filePath, filepath.Join(run.targetDir, dirName)); err != nil {
 			return err
 		}
-		if err := os.Rename(filePath, filepath.Join(destDir, entry.Name())); err != nil {
-			return err
+		if opts.OnMoved != nil {
+			opts.OnMoved(dirName)
 		}
 	}
 	return nil
 }
 
-// OrganiseVideosAndRenameImages organises videos into subdirectories and renames images sequentially
-func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progressChan chan<- ProgressEvent) error {
+// namedDateDirectories maps the names of the dates of targetDir that have a single directory,
+// named after a place, to that directory, so new files of those dates join it rather than
+// starting an unnamed directory beside it. A target that doesn't exist yet has none.
+func namedDateDirectories(targetDir string) (map[string]string, error) {
 	entries, err := os.ReadDir(targetDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+	byDate := make(map[string][]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		parsed, err := naming.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+		dateName := naming.Format(parsed.Date, "")
+		byDate[dateName] = append(byDate[dateName], entry.Name())
+	}
+	named := make(map[string]string)
+	for dateName, dirNames := range byDate {
+		if len(dirNames) == 1 && dirNames[0] != dateName {
+			named[dateName] = dirNames[0]
+		}
+	}
+	return named, nil
+}
+
+// moveToDir moves a file into destDir, creating it if needed
+func moveToDir(filePath, destDir string) error {
+	if err := os.MkdirAll(destDir, libraryDirMode); err != nil {
 		return err
 	}
+	return os.Rename(filePath, filepath.Join(destDir, filepath.Base(filePath)))
+}
 
-	// Count total directories
-	totalDirs := 0
+// OrganiseVideosAndRenameImages organises videos into subdirectories and renames images sequentially
+func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, order SequenceOrder, progressChan chan<- ProgressEvent) error {
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		return err
+	}
+	var dirNames []string
 	for _, entry := range entries {
 		if entry.IsDir() {
-			totalDirs++
+			dirNames = append(dirNames, entry.Name())
 		}
 	}
+	return o.OrganiseDirectories(targetDir, dirNames, order, progressChan)
+}
 
-	current := 0
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		dirPath := filepath.Join(targetDir, entry.Name())
-		current++
+// OrganiseDirectories organises videos and renames images in the given directories of targetDir
+func (o *fileOrganiser) OrganiseDirectories(targetDir string, dirNames []string, order SequenceOrder, progressChan chan<- ProgressEvent) error {
+	// Leave out the review directory, whose files keep their names, and hidden ones such as the
+	// temporary directories of parses
+	dirNames = slices.DeleteFunc(slices.Clone(dirNames), func(name string) bool {
+		return name == ReviewDirName || strings.HasPrefix(name, ".")
+	})
+
+	for i, dirName := range dirNames {
+		dirPath := filepath.Join(targetDir, dirName)
+		current := i + 1
 
 		// Emit progress event
 		if progressChan != nil {
@@ -134,8 +343,8 @@ func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progress
 			case progressChan <- ProgressEvent{
 				Stage:   "organising",
 				Current: current,
-				Total:   totalDirs,
-				Message: fmt.Sprintf("Organising directory %d of %d", current, totalDirs),
+				Total:   len(dirNames),
+				Message: i18n.T("progress.organising_directory", current, len(dirNames)),
 				File:    dirPath,
 			}:
 			default:
@@ -143,11 +352,11 @@ func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progress
 			}
 		}
 
-		logger.Debug("Organising file %s/%s", dirPath, entry.Name())
-		if err := o.organiseVideos(dirPath, entry.Name(), progressChan); err != nil {
+		logger.Debug("Organising file %s/%s", dirPath, dirName)
+		if err := o.organiseVideos(dirPath, dirName, order, progressChan); err != nil {
 			return err
 		}
-		if err := o.renameImages(dirPath, entry.Name(), progressChan); err != nil {
+		if err := o.renameImages(dirPath, dirName, order, progressChan); err != nil {
 			return err
 		}
 	}
@@ -155,24 +364,32 @@ func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progress
 }
 
 // organiseVideos moves video files to a videos subdirectory and renames them sequentially
-func (o *fileOrganiser) organiseVideos(dir string, dirName string, progressChan chan<- ProgressEvent) error {
-	parts := strings.Fields(dirName)
-	if len(parts) != 4 {
-		return fmt.Errorf("unexpected directory name format: %s", dirName)
+func (o *fileOrganiser) organiseVideos(dir string, dirName string, order SequenceOrder, progressChan chan<- ProgressEvent) error {
+	prefix, err := organisedFilePrefix(dirName)
+	if err != nil {
+		return err
 	}
-	videosName := strings.Join(parts, "_")
 	videosDir := filepath.Join(dir, "videos")
-	_, err := o.fileRenamer.MoveAndRenameFilesWithPattern(dir, videosDir, videosName, o.extensions.IsVideo, progressChan)
+	_, err = o.fileRenamer.MoveAndRenameFilesWithPattern(dir, videosDir, prefix, o.extensions.IsVideo, order, progressChan)
 	return err
 }
 
 // renameImages renames image files with a sequential pattern
-func (o *fileOrganiser) renameImages(dir, dirName string, progressChan chan<- ProgressEvent) error {
-	parts := strings.Fields(dirName)
-	if len(parts) != 4 {
-		return fmt.Errorf("unexpected directory name format: %s", dirName)
+func (o *fileOrganiser) renameImages(dir, dirName string, order SequenceOrder, progressChan chan<- ProgressEvent) error {
+	prefix, err := organisedFilePrefix(dirName)
+	if err != nil {
+		return err
 	}
-	picsName := strings.Join(parts, "_")
-	_, err := o.fileRenamer.RenameFilesWithPattern(dir, picsName, o.extensions.IsImage, progressChan)
+	_, err = o.fileRenamer.RenameFilesWithPattern(dir, prefix, o.extensions.IsImage, order, progressChan)
 	return err
 }
+
+// organisedFilePrefix returns the prefix of the files of a directory OrganiseByDate created,
+// which is named after a date, and a place if ParseOptions.AppendLocation named it
+func organisedFilePrefix(dirName string) (string, error) {
+	parsed, err := naming.Parse(dirName)
+	if err != nil {
+		return "", fmt.Errorf("unexpected directory name format: %s", dirName)
+	}
+	return parsed.FilePrefix(), nil
+}
@@ -1,8 +1,12 @@
 package pics
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -74,7 +78,7 @@ func TestFileOrganiser_OrganiseByDate(t *testing.T) {
 
 	// Organise files by date
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(testCtx, sourceDir, targetDir, DefaultOrganiseOptions())
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -91,6 +95,89 @@ func TestFileOrganiser_OrganiseByDate(t *testing.T) {
 	assertFileNotExists(t, file2)
 }
 
+func TestFileOrganiser_OrganiseByDate_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	file := createFileWithDate(t, sourceDir, "image1.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	// Cancelled before the first file, which stays where it is
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	organiser := NewFileOrganiser(nil)
+	if _, err := organiser.OrganiseByDate(ctx, sourceDir, targetDir, DefaultOrganiseOptions()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation reported, got: %v", err)
+	}
+	assertFileExists(t, file)
+	assertFileNotExists(t, targetDir)
+}
+
+func TestFileOrganiser_OrganiseByDate_Concurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	// Enough files for several batches, over several dates
+	total := 3*organiseBatchSize + 5
+	for i := range total {
+		createFileWithDate(t, sourceDir, fmt.Sprintf("image%03d.jpg", i), time.Date(2023, 6, 15+i%3, 10, 30, 0, 0, time.UTC))
+	}
+
+	progress := make(chan ProgressEvent, total)
+	var mu sync.Mutex
+	movedTo := make(map[string]int)
+	opts := DefaultOrganiseOptions()
+	opts.MaxConcurrency = 2
+	opts.ProgressChan = progress
+	opts.OnMoved = func(dirName string) {
+		mu.Lock()
+		movedTo[dirName]++
+		mu.Unlock()
+	}
+	if _, err := NewFileOrganiser(nil).OrganiseByDate(testCtx, sourceDir, targetDir, opts); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	close(progress)
+
+	moved := 0
+	for _, dir := range []string{"2023 06 June 15", "2023 06 June 16", "2023 06 June 17"} {
+		moved += len(listDir(t, filepath.Join(targetDir, dir)))
+	}
+	if moved != total || len(listDir(t, sourceDir)) != 0 {
+		t.Errorf("Expected all %d files moved, got %d", total, moved)
+	}
+	if len(movedTo) != 3 || movedTo["2023 06 June 15"]+movedTo["2023 06 June 16"]+movedTo["2023 06 June 17"] != total {
+		t.Errorf("Expected every move reported with its directory, got %v", movedTo)
+	}
+	// Every file is counted once, whichever worker moves it
+	seen := make(map[int]bool)
+	for event := range progress {
+		if event.Total != total || seen[event.Current] {
+			t.Errorf("Unexpected progress event %+v", event)
+		}
+		seen[event.Current] = true
+	}
+	if len(seen) != total {
+		t.Errorf("Expected %d progress events, got %d", total, len(seen))
+	}
+}
+
+func TestFileOrganiser_OrganiseByDate_JoinsNamedDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	named := createDateDir(t, targetDir, "2023 06 June 15 Barcelona")
+	createDateDir(t, targetDir, "2023 06 June 16 Girona")
+	createDateDir(t, targetDir, "2023 06 June 16 Sitges")
+	createFileWithDate(t, sourceDir, "june15.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	createFileWithDate(t, sourceDir, "june16.jpg", time.Date(2023, 6, 16, 10, 30, 0, 0, time.UTC))
+
+	if _, err := NewFileOrganiser(nil).OrganiseByDate(testCtx, sourceDir, targetDir, DefaultOrganiseOptions()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The date named after a single place gets its files, the date of two places its own directory
+	assertFileExists(t, filepath.Join(named, "june15.jpg"))
+	assertFileNotExists(t, filepath.Join(targetDir, "2023 06 June 15"))
+	assertFileExists(t, filepath.Join(targetDir, "2023 06 June 16", "june16.jpg"))
+}
+
 func TestFileOrganiser_OrganiseByDate_MultipleDates(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir, targetDir := createDirs(t, tmpDir)
@@ -103,7 +190,7 @@ func TestFileOrganiser_OrganiseByDate_MultipleDates(t *testing.T) {
 	createFileWithDate(t, sourceDir, "july.jpg", date2)
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(testCtx, sourceDir, targetDir, DefaultOrganiseOptions())
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -129,7 +216,7 @@ func TestFileOrganiser_OrganiseByDate_SkipsDirectories(t *testing.T) {
 	createFileWithDate(t, sourceDir, "image1.jpg", testDate)
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(testCtx, sourceDir, targetDir, DefaultOrganiseOptions())
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -147,7 +234,7 @@ func TestFileOrganiser_OrganiseByDate_NonexistentSource(t *testing.T) {
 	targetDir := filepath.Join(tmpDir, "target")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseByDate("/nonexistent/source", targetDir, nil)
+	_, err := organiser.OrganiseByDate(testCtx, "/nonexistent/source", targetDir, DefaultOrganiseOptions())
 
 	if err == nil {
 		t.Error("Expected error for nonexistent source directory")
@@ -167,7 +254,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages(t *testing.T) {
 
 	// Organise videos and rename images
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -198,7 +285,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyImages(t *testing.T) {
 	createFile(t, dateDir, "img2.jpeg")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -221,7 +308,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyVideos(t *testing.T) {
 	createFile(t, dateDir, "vid1.mov")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -233,6 +320,24 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyVideos(t *testing.T) {
 	assertFileExists(t, filepath.Join(videosDir, "2023_06_June_15_00001.mov"))
 }
 
+func TestFileOrganiser_OrganiseDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createDirs(t, tmpDir)
+	changedDir := createDateDir(t, targetDir, "2023 06 June 15")
+	otherDir := createDateDir(t, targetDir, "2023 06 June 16")
+	createFile(t, changedDir, "img1.jpg")
+	createFile(t, otherDir, "img2.jpg")
+
+	organiser := NewFileOrganiser(createTestExiftool(t))
+	if err := organiser.OrganiseDirectories(targetDir, []string{"2023 06 June 15"}, SequenceByDate, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Only the directory given is numbered
+	assertFileExists(t, filepath.Join(changedDir, "2023_06_June_15_00001.jpg"))
+	assertFileExists(t, filepath.Join(otherDir, "img2.jpg"))
+}
+
 func TestFileOrganiser_OrganiseVideosAndRenameImages_EmptyDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 	_, targetDir := createDirs(t, tmpDir)
@@ -241,7 +346,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_EmptyDirectory(t *testing.T
 	dateDir := createDateDir(t, targetDir, "2023 06 June 15")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -262,7 +367,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_InvalidDirectoryFormat(t *t
 	createFile(t, invalidDir, "img.jpg")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err == nil {
 		t.Error("Expected error for invalid directory format")
@@ -281,7 +386,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_SkipsFiles(t *testing.T) {
 	createFile(t, dateDir, "img1.jpg")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -296,7 +401,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_SkipsFiles(t *testing.T) {
 
 func TestFileOrganiser_OrganiseVideosAndRenameImages_NonexistentTarget(t *testing.T) {
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages("/nonexistent/target", nil)
+	err := organiser.OrganiseVideosAndRenameImages("/nonexistent/target", SequenceByDate, nil)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent target directory")
@@ -316,7 +421,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_NormalisesExtensions(t *tes
 	createFile(t, dateDir, "vid1.MOV")
 
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -343,7 +448,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_MP4Videos(t *testing.T) {
 
 	// Organise videos and rename images
 	organiser := NewFileOrganiser(createTestExiftool(t))
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -362,3 +467,73 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_MP4Videos(t *testing.T) {
 	assertFileNotExists(t, filepath.Join(dateDir, "vid1.mp4"))
 	assertFileNotExists(t, filepath.Join(dateDir, "vid2.MP4"))
 }
+
+func TestFileOrganiser_OrganiseByDate_RoutesImplausibleDatesToReview(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+
+	// Without exiftool dates come from the modification time
+	organiser := NewFileOrganiser(nil).(*fileOrganiser)
+	organiser.now = func() time.Time { return time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC) }
+
+	createFileWithDate(t, sourceDir, "good.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	createFileWithDate(t, sourceDir, "default.jpg", time.Date(1980, 1, 1, 12, 0, 0, 0, time.UTC))
+	createFileWithDate(t, sourceDir, "future.jpg", time.Date(2060, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	review, err := organiser.OrganiseByDate(testCtx, sourceDir, targetDir, DefaultOrganiseOptions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertFileExists(t, filepath.Join(targetDir, "2023 06 June 15", "good.jpg"))
+	assertFileExists(t, filepath.Join(targetDir, ReviewDirName, "default.jpg"))
+	assertFileExists(t, filepath.Join(targetDir, ReviewDirName, "future.jpg"))
+	assertFileNotExists(t, filepath.Join(targetDir, "2060 01 January 01"))
+	assertFileNotExists(t, filepath.Join(targetDir, "1980 01 January 01"))
+
+	reasons := make(map[string]string)
+	for _, file := range review {
+		reasons[file.Name] = file.Reason
+	}
+	if len(reasons) != 2 {
+		t.Fatalf("Expected 2 files in review, got %d: %v", len(review), review)
+	}
+	if reasons["default.jpg"] != "camera default date" {
+		t.Errorf("Expected default.jpg to be flagged as camera default date, got %q", reasons["default.jpg"])
+	}
+	if reasons["future.jpg"] != "in the future" {
+		t.Errorf("Expected future.jpg to be flagged as in the future, got %q", reasons["future.jpg"])
+	}
+}
+
+func TestFileOrganiser_OrganiseIntoDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	createFileWithDate(t, sourceDir, "scan1.jpg", time.Date(2024, 3, 10, 10, 30, 0, 0, time.UTC))
+	createFileWithDate(t, sourceDir, "scan2.jpg", time.Date(1980, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	err := NewFileOrganiser(nil).OrganiseIntoDate(testCtx, sourceDir, targetDir, time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC), DefaultOrganiseOptions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The assigned date is used even where the files' own date is implausible
+	assertFileExists(t, filepath.Join(targetDir, "1994 07 July 01", "scan1.jpg"))
+	assertFileExists(t, filepath.Join(targetDir, "1994 07 July 01", "scan2.jpg"))
+	assertFileNotExists(t, filepath.Join(targetDir, ReviewDirName))
+}
+
+func TestFileOrganiser_OrganiseVideosAndRenameImages_SkipsReviewDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createDirs(t, tmpDir)
+	reviewDir := createDateDir(t, targetDir, ReviewDirName)
+	createFile(t, reviewDir, "root-IMG_0001.jpg")
+
+	organiser := NewFileOrganiser(nil)
+	if err := organiser.OrganiseVideosAndRenameImages(targetDir, SequenceByDate, nil); err != nil {
+		t.Fatalf("Expected review directory to be skipped, got: %v", err)
+	}
+
+	// Files under review keep their names
+	assertFileExists(t, filepath.Join(reviewDir, "root-IMG_0001.jpg"))
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package pics
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// chownPath assigns path to owner without following symlinks.
+func chownPath(path string, owner FileOwner) error {
+	return os.Lchown(path, owner.UID, owner.GID)
+}
+
+// InvokingUser returns the user who started pics. When running under sudo this is the
+// user who ran sudo (SUDO_UID/SUDO_GID) rather than root.
+func InvokingUser() (FileOwner, error) {
+	sudoUID, sudoGID := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if sudoUID == "" || sudoGID == "" {
+		return FileOwner{UID: os.Getuid(), GID: os.Getgid()}, nil
+	}
+
+	uid, err := strconv.Atoi(sudoUID)
+	if err != nil {
+		return FileOwner{}, fmt.Errorf("invalid SUDO_UID %q: %w", sudoUID, err)
+	}
+	gid, err := strconv.Atoi(sudoGID)
+	if err != nil {
+		return FileOwner{}, fmt.Errorf("invalid SUDO_GID %q: %w", sudoGID, err)
+	}
+	return FileOwner{UID: uid, GID: gid}, nil
+}
@@ -0,0 +1,135 @@
+//go:build !windows
+
+package pics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testArchiveModTime is the modification time of the entries written by writeTestArchive
+var testArchiveModTime = time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+// writeTestArchive writes a tar.gz holding a directory and a file inside it with the given modes
+func writeTestArchive(t *testing.T, path string, dirMode, fileMode int64) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+	gzWriter := gzip.NewWriter(file)
+	defer gzWriter.Close()
+	tarWriter := tar.NewWriter(gzWriter)
+	defer tarWriter.Close()
+
+	content := []byte("photo")
+	headers := []*tar.Header{
+		{Name: "album/", Typeflag: tar.TypeDir, Mode: dirMode, ModTime: testArchiveModTime},
+		{Name: "album/photo.jpg", Typeflag: tar.TypeReg, Mode: fileMode, Size: int64(len(content)), ModTime: testArchiveModTime},
+	}
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		t.Fatalf("Failed to write content: %v", err)
+	}
+}
+
+func TestExtractTarGz_AppliesUmask(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	writeTestArchive(t, archivePath, 0777, 0666)
+
+	oldMask := syscall.Umask(0027)
+	defer syscall.Umask(oldMask)
+
+	targetDir := filepath.Join(tmpDir, "restored")
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if err := backup.extractTarGz(testCtx, archivePath, targetDir, nil, 0, nil, ""); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	for path, expected := range map[string]os.FileMode{
+		filepath.Join(targetDir, "album"):              0750,
+		filepath.Join(targetDir, "album", "photo.jpg"): 0640,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("Expected %s to have mode %v, got %v", path, expected, info.Mode().Perm())
+		}
+	}
+}
+
+func TestExtractTarGz_Owner(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	writeTestArchive(t, archivePath, 0755, 0644)
+
+	// Unprivileged users may only assign files to themselves
+	owner := FileOwner{UID: os.Getuid(), GID: os.Getgid()}
+	if os.Getuid() == 0 {
+		owner = FileOwner{UID: 1234, GID: 5678}
+	}
+
+	targetDir := filepath.Join(tmpDir, "restored")
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if err := backup.extractTarGz(testCtx, archivePath, targetDir, &owner, 0, nil, ""); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(targetDir, "album"), filepath.Join(targetDir, "album", "photo.jpg")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if int(stat.Uid) != owner.UID || int(stat.Gid) != owner.GID {
+			t.Errorf("Expected %s to be owned by %d:%d, got %d:%d", path, owner.UID, owner.GID, stat.Uid, stat.Gid)
+		}
+	}
+}
+
+func TestInvokingUser(t *testing.T) {
+	t.Run("without sudo", func(t *testing.T) {
+		t.Setenv("SUDO_UID", "")
+		t.Setenv("SUDO_GID", "")
+		owner, err := InvokingUser()
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if owner.UID != os.Getuid() || owner.GID != os.Getgid() {
+			t.Errorf("Expected current user, got %+v", owner)
+		}
+	})
+
+	t.Run("under sudo", func(t *testing.T) {
+		t.Setenv("SUDO_UID", "1000")
+		t.Setenv("SUDO_GID", "100")
+		owner, err := InvokingUser()
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if owner != (FileOwner{UID: 1000, GID: 100}) {
+			t.Errorf("Expected 1000:100, got %+v", owner)
+		}
+	})
+
+	t.Run("invalid sudo uid", func(t *testing.T) {
+		t.Setenv("SUDO_UID", "alice")
+		t.Setenv("SUDO_GID", "100")
+		if _, err := InvokingUser(); err == nil {
+			t.Error("Expected error for non-numeric SUDO_UID")
+		}
+	})
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package pics
+
+import "errors"
+
+var errOwnershipUnsupported = errors.New("changing file ownership is not supported on Windows")
+
+// chownPath is not supported on Windows, which has no numeric user and group IDs.
+func chownPath(path string, owner FileOwner) error {
+	return errOwnershipUnsupported
+}
+
+// InvokingUser is not supported on Windows, which has no numeric user and group IDs.
+func InvokingUser() (FileOwner, error) {
+	return FileOwner{}, errOwnershipUnsupported
+}
@@ -1,100 +1,583 @@
 package pics
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
 )
 
 // MediaParser defines the interface for parsing and organising media files
 type MediaParser interface {
-	// Parse processes media files from source to target directory
-	Parse(sourceDir, targetDir string, opts ParseOptions) error
+	// Parse processes media files from one or more source directories to the target directory
+	// as a single run, numbering files of the same date in one sequence. Cancelling ctx stops
+	// the parse: while copying, its temporary files are removed and the library is left
+	// untouched; while organising, the files not yet moved are kept for Adopt.
+	Parse(ctx context.Context, sourceDirs []string, targetDir string, opts ParseOptions) (ParseReport, error)
+	// ParseFiles processes the listed media files instead of walking directories, and is
+	// cancelled as Parse is. Files of the same date are numbered in the order of the list, in
+	// which each file must appear once.
+	ParseFiles(ctx context.Context, files []string, targetDir string, opts ParseOptions) (ParseReport, error)
+	// Adopt organises into its target the files of a parse that was interrupted after copying
+	// them all, as the parse would have, and removes what is left of the session. Cancelling
+	// ctx stops it, keeping the session.
+	Adopt(ctx context.Context, session Session, progressChan chan<- ProgressEvent) (ParseReport, error)
+}
+
+// ParseReport holds what a parse run needs the user to look at
+type ParseReport struct {
+	// Review lists files moved to the review directory because their dates are implausible
+	Review []ReviewFile
+	// Changing lists source files skipped because they kept changing while being copied
+	Changing []string
+	// TooSmall lists source files skipped because they are smaller than ParseOptions.MinFileSize
+	TooSmall []string
+	// Moved lists source files moved to the trash of their source (TrashDirName) after being
+	// imported, with ParseOptions.MoveFiles
+	Moved []string
+	// MovedBytes is the size of the moved files, which stays in use until the trash is emptied
+	MovedBytes int64
+	// Trashes are the directories whose trash the moved files went to
+	Trashes []string
+	// Pruned is the number of source directories removed once emptied, with
+	// ParseOptions.PruneSourceDirs
+	Pruned int
+	// KeptDirs lists the source directories pruning left in place, and why
+	KeptDirs []KeptDir
+	// Located is the number of date directories named after a place, with ParseOptions.AppendLocation
+	Located int
+	// ReviewDir is the directory files with implausible dates were moved to
+	ReviewDir string
+	// Imported is the number of media files added to the library
+	Imported int
+	// SourceBytes is the size of the imported files before compression
+	SourceBytes int64
+	// ImportedBytes is the size of the imported files in the library
+	ImportedBytes int64
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+
+	// imported are the files copied into the session, whose sources MoveFiles moves to the trash
+	imported []fileToProcess
 }
 
 // mediaParser implements the MediaParser interface
 type mediaParser struct {
-	compressor ImageCompressor
-	organiser  FileOrganiser
-	extensions Extensions
-	stats      FileStats
-	exifWriter ExifWriter
+	codecs          ImageCodecs
+	videoCompressor VideoCompressor
+	organiser       FileOrganiser
+	extensions      Extensions
+	stats           FileStats
+	exifWriter      ExifWriter
+	metadata        MetadataReader
 }
 
 // NewMediaParser creates a new MediaParser with custom binary paths and shared exiftool instance
-func NewMediaParser(jpegoptimPath string, organiser FileOrganiser, exifWriter ExifWriter) MediaParser {
+func NewMediaParser(jpegoptimPath, ffmpegPath string, organiser FileOrganiser, exifWriter ExifWriter, metadata MetadataReader) MediaParser {
+	return NewMediaParserWithCodecs(NewImageCodecs(EncoderPaths{Jpegoptim: jpegoptimPath}), ffmpegPath, organiser, exifWriter, metadata)
+}
+
+// NewMediaParserWithCodecs creates a new MediaParser writing images with the given codecs, such
+// as those of NewImageCodecs with custom encoder paths
+func NewMediaParserWithCodecs(codecs ImageCodecs, ffmpegPath string, organiser FileOrganiser, exifWriter ExifWriter, metadata MetadataReader) MediaParser {
 	return &mediaParser{
-		compressor: NewImageCompressorWithPath(jpegoptimPath),
-		organiser:  organiser,
-		extensions: NewExtensions(),
-		stats:      NewFileStats(),
-		exifWriter: exifWriter,
+		codecs:          codecs,
+		videoCompressor: NewVideoCompressorWithPath(ffmpegPath),
+		organiser:       organiser,
+		extensions:      NewExtensions(),
+		stats:           NewFileStats(),
+		exifWriter:      exifWriter,
+		metadata:        metadata,
+	}
+}
+
+// Parse processes media files from the source directories to the target directory
+func (p *mediaParser) Parse(ctx context.Context, sourceDirs []string, targetDir string, opts ParseOptions) (ParseReport, error) {
+	sources, err := newParseSources(sourceDirs)
+	if err != nil {
+		return ParseReport{}, err
+	}
+	logger.Info("Parsing source directories", "sources", sourceDirs)
+	return p.parse(ctx, sources, targetDir, opts)
+}
+
+// ParseFiles processes the listed media files to the target directory
+func (p *mediaParser) ParseFiles(ctx context.Context, files []string, targetDir string, opts ParseOptions) (ParseReport, error) {
+	source, err := newFileListSource(files)
+	if err != nil {
+		return ParseReport{}, err
 	}
+	logger.Info("Parsing listed files", "files", len(source.files))
+	return p.parse(ctx, []parseSource{source}, targetDir, opts)
 }
 
-// Parse processes media files from source to target directory
-func (p *mediaParser) Parse(sourceDir, targetDir string, opts ParseOptions) error {
-	sourceDir = strings.TrimSuffix(sourceDir, "/")
+// parse copies the files of the sources to a temporary directory, then organises them into the target
+func (p *mediaParser) parse(ctx context.Context, sources []parseSource, targetDir string, opts ParseOptions) (ParseReport, error) {
+	start, warnings := time.Now(), logger.Warnings()
 	targetDir = strings.TrimSuffix(targetDir, "/")
+	if opts.VerifyMetadataRate > 0 && p.metadata == nil {
+		return ParseReport{}, fmt.Errorf("cannot verify EXIF metadata without a metadata reader")
+	}
+	if opts.AppendLocation && (p.metadata == nil || opts.Geocoder == nil) {
+		return ParseReport{}, fmt.Errorf("cannot append locations without a metadata reader and a geocoder")
+	}
+	if opts.CompressJPEGs && opts.JPEGTargetSize == 0 {
+		if err := ValidateJPEGQuality(opts.JPEGQuality); err != nil {
+			return ParseReport{}, err
+		}
+	}
+	if opts.CompressVideos && opts.VideoBitrate == 0 {
+		if err := ValidateVideoCRF(opts.VideoCRF); err != nil {
+			return ParseReport{}, err
+		}
+	}
+	if err := p.validateOutputFormats(opts); err != nil {
+		return ParseReport{}, err
+	}
 
-	// Create unique temporary directory in system temp with random suffix
-	tmpTarget, err := os.MkdirTemp("", "pics-*")
+	// Copy to a temporary directory of this session in the target, whose journal lets an
+	// interrupted parse be recovered
+	absTarget, err := filepath.Abs(targetDir)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return ParseReport{}, fmt.Errorf("failed to get absolute path of %s: %w", targetDir, err)
+	}
+	var session *Session
+	if opts.Resume {
+		if session, err = findResumableSession(absTarget); err != nil {
+			return ParseReport{}, err
+		}
+		if session == nil {
+			logger.Info("No interrupted parse to resume, starting afresh", "target", absTarget)
+		}
+	}
+	resumed := session != nil
+	sessionsDir := filepath.Join(absTarget, ParseSessionsDirName)
+	if !resumed {
+		if err := os.MkdirAll(sessionsDir, libraryDirMode); err != nil {
+			return ParseReport{}, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		if session, err = createSessionDir(sessionsDir, SessionKindParse, absTarget); err != nil {
+			return ParseReport{}, err
+		}
+	}
+	// Only a parse that completes removes its session. One cancelled, out of time or failed
+	// keeps the files copied so far, for --resume to pick up or to adopt.
+	completed := false
+	defer func() {
+		if completed {
+			os.RemoveAll(session.Dir)
+			// Left for the sessions of other parses into the target, if any
+			os.Remove(sessionsDir)
+		}
+	}()
+	session.AssignedDate = opts.AssignedDate
+	session.SequenceOrder = opts.SequenceOrder
+	if opts.TimeZone != nil {
+		session.TimeZone = opts.TimeZone.String()
+	}
+	if err := session.setStage(SessionStageCopying); err != nil {
+		return ParseReport{}, err
+	}
+	tmpTarget := filepath.Join(session.Dir, sessionFilesDirName)
+	if resumed {
+		logger.Info("Resuming interrupted parse", "path", tmpTarget, "session", session.ID)
+	} else {
+		if err := os.Mkdir(tmpTarget, libraryDirMode); err != nil {
+			return ParseReport{}, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		logger.Info("Created temporary directory", "path", tmpTarget, "session", session.ID)
+	}
+	journal, err := openProcessedJournal(session.Dir, tmpTarget, resumed)
+	if err != nil {
+		return ParseReport{}, err
+	}
+	defer journal.Close()
+
+	// The timeout only bounds copying, while cancelling ctx stops organising too
+	copyCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		copyCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
 	}
-	defer os.RemoveAll(tmpTarget)
-	logger.Info("Created temporary directory", "path", tmpTarget)
 
-	logger.Info("Processing media files (copy and compress)", "source", sourceDir, "target", tmpTarget)
+	logger.Info("Processing media files (copy and compress)", "target", tmpTarget)
 	processStart := time.Now()
-	if err := p.copyAndCompressFiles(sourceDir, tmpTarget, opts); err != nil {
-		return fmt.Errorf("failed to process media files: %w", err)
+	report, err := p.copyAndCompressFiles(copyCtx, sources, tmpTarget, opts, journal)
+	if err != nil {
+		return ParseReport{}, fmt.Errorf("failed to process media files: %w", err)
+	}
+	if resumed {
+		journal.removeUnmatched(tmpTarget)
+		logger.Info("Kept files processed by the interrupted parse", "files", journal.resumedCount())
 	}
 	processDuration := time.Since(processStart)
 	logger.Info("Processing completed", "duration_seconds", processDuration.Seconds())
 
-	logger.Info("Organising files by date")
-	if err := p.organiser.OrganiseByDate(tmpTarget, targetDir, opts.ProgressChan); err != nil {
+	// Past this point files are moved into the library, which the timeout must not leave half
+	// organised
+	if err := copyCtx.Err(); err != nil {
+		return ParseReport{}, parseStoppedError(opts, err)
+	}
+	if err := session.setStage(SessionStageOrganising); err != nil {
+		return ParseReport{}, err
+	}
+	if opts.OnOrganising != nil {
+		opts.OnOrganising()
+	}
+	if err := p.organise(ctx, tmpTarget, targetDir, opts, &report); err != nil {
+		if ctx.Err() != nil {
+			return ParseReport{}, fmt.Errorf("parse cancelled while organising, organise the files left with 'pics sessions recover --adopt %s': %w", session.ID, err)
+		}
+		return ParseReport{}, err
+	}
+	if opts.MoveFiles {
+		report.Moved, report.MovedBytes, report.Trashes = trashImportedSources(sources, report.imported, start, opts.TrashRetention)
+		if opts.PruneSourceDirs {
+			report.Pruned, report.KeptDirs = pruneSourceDirs(sources)
+		}
+	}
+	report.Warnings = logger.Warnings() - warnings
+	report.Duration = time.Since(start)
+	completed = true
+	return report, nil
+}
+
+// Adopt organises the files of an interrupted parse session into its target
+func (p *mediaParser) Adopt(ctx context.Context, session Session, progressChan chan<- ProgressEvent) (ParseReport, error) {
+	if !session.Adoptable() {
+		return ParseReport{}, fmt.Errorf("session %s can't be adopted: only parse sessions that copied every file can", session.ID)
+	}
+	start, warnings := time.Now(), logger.Warnings()
+
+	opts := DefaultParseOptions()
+	opts.AssignedDate = session.AssignedDate
+	if session.SequenceOrder != "" {
+		opts.SequenceOrder = session.SequenceOrder
+	}
+	if session.TimeZone != "" {
+		zone, err := ParseTimeZone(session.TimeZone)
+		if err != nil {
+			return ParseReport{}, fmt.Errorf("session %s has an invalid time zone: %w", session.ID, err)
+		}
+		opts.TimeZone = zone
+	}
+	opts.ProgressChan = progressChan
+
+	logger.Info("Adopting interrupted parse", "session", session.ID, "dir", session.Dir, "target", session.Target)
+	var report ParseReport
+	if err := p.organise(ctx, filepath.Join(session.Dir, sessionFilesDirName), session.Target, opts, &report); err != nil {
+		return ParseReport{}, err
+	}
+	if err := CleanSession(session); err != nil {
+		return ParseReport{}, err
+	}
+	os.Remove(filepath.Dir(session.Dir))
+	report.Warnings = logger.Warnings() - warnings
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// organise moves the processed files in tmpTarget to their date directories in targetDir and
+// numbers them, filling in the report. Cancelling ctx stops moving files; those moved already
+// are numbered the next time the target is organised.
+func (p *mediaParser) organise(ctx context.Context, tmpTarget, targetDir string, opts ParseOptions, report *ParseReport) error {
+	imported, err := p.stats.GetStats(tmpTarget)
+	if err != nil {
+		return fmt.Errorf("failed to count processed files: %w", err)
+	}
+	report.Imported, report.ImportedBytes = imported.Media().Files, imported.Media().Bytes
+
+	// Only directories this run creates are named after places
+	var existing map[string]bool
+	if opts.AppendLocation {
+		if existing, err = unnamedDateDirectories(targetDir); err != nil {
+			return fmt.Errorf("failed to read target directory: %w", err)
+		}
+	}
+
+	// Manifests are updated in the directories whose files the run changes, numbering included
+	var versions map[string]string
+	if opts.WriteManifests {
+		if versions, err = checksumManifestVersions(targetDir); err != nil {
+			return fmt.Errorf("failed to read target directory: %w", err)
+		}
+	}
+
+	// The directories files are moved to, and those there were before, to tell which changed
+	var before map[string]bool
+	var moved sync.Map
+	if opts.OrganiseChangedOnly {
+		if before, err = directoryNames(targetDir); err != nil {
+			return fmt.Errorf("failed to read target directory: %w", err)
+		}
+	}
+
+	organiseOpts := DefaultOrganiseOptions()
+	organiseOpts.TimeZone = opts.TimeZone
+	organiseOpts.MaxConcurrency = opts.MaxConcurrency
+	organiseOpts.ProgressChan = opts.ProgressChan
+	if opts.OrganiseChangedOnly {
+		organiseOpts.OnMoved = func(dirName string) { moved.Store(dirName, true) }
+	}
+	if opts.AssignedDate != nil {
+		logger.Info("Organising files into the assigned date", "date", opts.AssignedDate)
+		err = p.organiser.OrganiseIntoDate(ctx, tmpTarget, targetDir, opts.AssignedDate.Date, organiseOpts)
+	} else {
+		logger.Info("Organising files by date")
+		report.Review, err = p.organiser.OrganiseByDate(ctx, tmpTarget, targetDir, organiseOpts)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to organise by date: %w", err)
 	}
 
+	if opts.AppendLocation {
+		logger.Info("Naming new directories after places")
+		if report.Located, err = p.appendLocations(ctx, targetDir, existing, opts); err != nil {
+			return fmt.Errorf("failed to name directories after places: %w", err)
+		}
+	}
+
 	logger.Info("Organising videos and renaming images")
-	if err := p.organiser.OrganiseVideosAndRenameImages(targetDir, opts.ProgressChan); err != nil {
+	if opts.OrganiseChangedOnly {
+		// Directories named after places since were new, and are told apart as such
+		var after map[string]bool
+		if after, err = directoryNames(targetDir); err != nil {
+			return fmt.Errorf("failed to read target directory: %w", err)
+		}
+		var changed []string
+		for dirName := range after {
+			if _, ok := moved.Load(dirName); ok || !before[dirName] {
+				changed = append(changed, dirName)
+			}
+		}
+		slices.Sort(changed)
+		err = p.organiser.OrganiseDirectories(targetDir, changed, opts.SequenceOrder, opts.ProgressChan)
+	} else {
+		err = p.organiser.OrganiseVideosAndRenameImages(targetDir, opts.SequenceOrder, opts.ProgressChan)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to organise videos and rename images: %w", err)
 	}
 
+	if opts.WriteManifests {
+		updated, problems, err := updateChecksumManifests(targetDir, versions)
+		if err != nil {
+			return fmt.Errorf("failed to write manifests: %w", err)
+		}
+		logger.Info("Manifests written", "directories", updated, "problems", len(problems))
+	}
+
 	logger.Info("Processing complete")
+	recordLibraryActivity(targetDir, func(a *libraryActivity) { a.LastImport = time.Now() })
+	report.ReviewDir = filepath.Join(targetDir, ReviewDirName)
 	return nil
 }
 
+// directoryNames returns the names of the directories of dir
+func directoryNames(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names[entry.Name()] = true
+		}
+	}
+	return names, nil
+}
+
+// parseSource is a source directory of a parse run, or a list of files when files is set
+type parseSource struct {
+	dir   string
+	files []string
+	// prefix tells apart files with the same relative path in different sources,
+	// and keeps the files of each source together when numbering
+	prefix string
+	ignore *ignoreMatcher
+}
+
+// newParseSources loads the .picsignore rules of each source directory. Sources may not
+// overlap, since files in both would be imported twice.
+func newParseSources(sourceDirs []string) ([]parseSource, error) {
+	if len(sourceDirs) == 0 {
+		return nil, fmt.Errorf("no source directories given")
+	}
+
+	sources := make([]parseSource, 0, len(sourceDirs))
+	width := len(strconv.Itoa(len(sourceDirs)))
+	for i, dir := range sourceDirs {
+		dir = strings.TrimSuffix(dir, "/")
+		for _, other := range sources {
+			if isSameOrNested(dir, other.dir) || isSameOrNested(other.dir, dir) {
+				return nil, fmt.Errorf("source directories overlap: %s and %s", other.dir, dir)
+			}
+		}
+
+		ignore, err := newIgnoreMatcher(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ignore files: %w", err)
+		}
+
+		source := parseSource{dir: dir, ignore: ignore}
+		if len(sourceDirs) > 1 {
+			source.prefix = fmt.Sprintf("%0*d-", width, i+1)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// newFileListSource checks that every listed file exists. Repeated entries are the caller's to
+// drop, as reading the list does. .picsignore rules don't apply, since the files were picked
+// explicitly.
+func newFileListSource(files []string) (parseSource, error) {
+	if len(files) == 0 {
+		return parseSource{}, fmt.Errorf("no files given")
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return parseSource{}, fmt.Errorf("cannot access listed file: %w", err)
+		}
+		if info.IsDir() {
+			return parseSource{}, fmt.Errorf("listed path is a directory: %s", file)
+		}
+	}
+	return parseSource{files: files}, nil
+}
+
+// isSameOrNested reports whether path is dir or lies below it
+func isSameOrNested(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
 type fileToProcess struct {
 	srcPath  string
 	destPath string
 	isJPEG   bool
+	// albums are the source subdirectories the file was found in, outermost first
+	albums []string
+	// size and modTime are those of the source file when it was discovered
+	size    int64
+	modTime time.Time
 }
 
-// copyAndCompressFiles copies and optionally compresses files in parallel using a worker pool
-func (p *mediaParser) copyAndCompressFiles(sourceDir, tmpTarget string, opts ParseOptions) error {
+// errFileChanged is returned when a source file changes while it is being imported,
+// usually because a sync client is still writing it
+var errFileChanged = errors.New("file changed while being copied")
+
+// errCopyMismatch is returned when ParseOptions.VerifyCopy finds that the copy of a file
+// doesn't hash the same as the source
+var errCopyMismatch = errors.New("copy doesn't match the source")
+
+// errFileTooSmall is returned for files below ParseOptions.MinFileSize, such as thumbnail
+// caches and junk files left by camera exports
+var errFileTooSmall = errors.New("file smaller than the minimum file size")
+
+// collectedFiles collects the files the workers set aside, such as those to retry at the end
+type collectedFiles struct {
+	mu    sync.Mutex
+	files []fileToProcess
+}
+
+// add sets a file aside
+func (c *collectedFiles) add(file fileToProcess) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = append(c.files, file)
+}
+
+// collectedErrors collects the errors of the workers. Unlike a channel it never blocks, so
+// workers keep draining the jobs however many files fail before the errors are read.
+type collectedErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add records an error
+func (c *collectedErrors) add(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+// parseStoppedError explains err when the parse was stopped while copying, because it ran out
+// of time or was cancelled
+func parseStoppedError(opts ParseOptions, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("parse cancelled, the library was left untouched, pick it up with --resume: %w", err)
+	}
+	return fmt.Errorf("parse did not finish within %s, the library was left untouched, pick it up with --resume: %w", opts.Timeout, err)
+}
+
+// copyAndCompressFiles copies and optionally compresses files in parallel using a worker pool,
+// recording each file processed in journal and keeping those an interrupted run processed. It returns a report of the source files skipped because they kept changing while being
+// copied or are too small, and of the size of those imported.
+func (p *mediaParser) copyAndCompressFiles(ctx context.Context, sources []parseSource, tmpTarget string, opts ParseOptions, journal *processedJournal) (ParseReport, error) {
 	// Count total files upfront for accurate progress reporting
-	logger.Info("Counting files", "source", sourceDir)
-	totalFiles, err := p.stats.GetFileCount(sourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to count files: %w", err)
+	totalFiles := 0
+	var unsupportedFiles []string
+	for _, source := range sources {
+		if source.files != nil {
+			for _, file := range source.files {
+				if p.extensions.IsSupported(file) {
+					totalFiles++
+				} else {
+					unsupportedFiles = append(unsupportedFiles, file)
+				}
+			}
+			continue
+		}
+
+		logger.Info("Counting files", "source", source.dir)
+		count, err := p.stats.GetFileCount(source.dir)
+		if err != nil {
+			return ParseReport{}, fmt.Errorf("failed to count files: %w", err)
+		}
+		totalFiles += count
+
+		unsupported, err := p.stats.GetUnsupportedFiles(source.dir)
+		if err != nil {
+			return ParseReport{}, fmt.Errorf("failed to get unsupported files: %w", err)
+		}
+		unsupportedFiles = append(unsupportedFiles, unsupported...)
 	}
 	logger.Info("File count complete", "total", totalFiles)
 
 	// List unsupported files that will be ignored
-	unsupportedFiles, err := p.stats.GetUnsupportedFiles(sourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to get unsupported files: %w", err)
-	}
 	if len(unsupportedFiles) > 0 {
 		logger.Info("The following files will be ignored (unsupported formats)", "count", len(unsupportedFiles))
 		for _, file := range unsupportedFiles {
@@ -110,52 +593,128 @@ func (p *mediaParser) copyAndCompressFiles(sourceDir, tmpTarget string, opts Par
 
 	jobs := make(chan fileToProcess, numWorkers)
 	var wg sync.WaitGroup
-	errChan := make(chan error, numWorkers)
+	var failed collectedErrors
 
 	// Track progress
-	var processedCount atomic.Int64
+	var processedCount, sourceBytes atomic.Int64
+	var imported, changed, tooSmall collectedFiles
 	var totalCount atomic.Int64
 	totalCount.Store(int64(totalFiles)) // Set total upfront
 
+	// Log a heartbeat and stop helper processes stuck on a file. Videos are compressed by fewer
+	// workers at a time than images.
+	dog := newWatchdog(withVideoSlots(ctx, opts.MaxVideoConcurrency), opts.StallTimeout)
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go dog.run(stopWatchdog, heartbeatInterval, processedCount.Load, totalCount.Load)
+
 	// Start worker pool first
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go p.processFileWorker(jobs, errChan, opts, &wg, &processedCount, &totalCount)
+		go p.processFileWorker(i, jobs, &failed, opts, &wg, &processedCount, &totalCount, &sourceBytes, &imported, &changed, &tooSmall, dog, journal)
 	}
 
 	// Discover files in background (feeds workers as it discovers)
-	go p.discoverFiles(sourceDir, tmpTarget, jobs)
-
-	wg.Wait()
-	close(errChan)
+	go p.discoverFiles(ctx, sources, tmpTarget, jobs)
 
-	// Collect all errors from workers
-	var errors []error
-	for err := range errChan {
-		if err != nil {
-			errors = append(errors, err)
-		}
+	// Give up waiting once the run is out of time, even on a file system call that never returns
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	// Files failed by the deadline killing their helper processes are reported as the timeout
+	if err := ctx.Err(); err != nil {
+		return ParseReport{}, parseStoppedError(opts, err)
 	}
 
 	// Return first error if any occurred
-	if len(errors) > 0 {
-		if len(errors) > 1 {
-			logger.Error("Multiple errors occurred during processing", "error_count", len(errors))
-			for i, err := range errors {
+	errs := failed.errs
+	if len(errs) > 0 {
+		if len(errs) > 1 {
+			logger.Error("Multiple errors occurred during processing", "error_count", len(errs))
+			for i, err := range errs {
 				logger.Error("Processing error", "index", i+1, "error", err)
 			}
 		}
-		return errors[0]
+		return ParseReport{}, errs[0]
+	}
+
+	report := ParseReport{TooSmall: sourcePaths(tooSmall.files), SourceBytes: sourceBytes.Load(), imported: imported.files}
+	if err := p.retryChangedFiles(ctx, changed.files, opts, &report, dog, journal); err != nil {
+		return ParseReport{}, err
 	}
+	return report, nil
+}
+
+// retryChangedFiles processes again, one at a time, the files that changed while being copied.
+// By then a sync client has usually finished writing them; those still changing are skipped
+// and added to the report, as are those that turn out too small.
+func (p *mediaParser) retryChangedFiles(ctx context.Context, files []fileToProcess, opts ParseOptions, report *ParseReport, dog *watchdog, journal *processedJournal) error {
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return parseStoppedError(opts, err)
+		}
+		log := logger.With("file", file.srcPath)
+		info, err := os.Stat(file.srcPath)
+		if err != nil {
+			log.Warn("Skipping file that disappeared while being imported", "error", err)
+			report.Changing = append(report.Changing, file.srcPath)
+			continue
+		}
 
+		log.Info("Retrying file that changed while being copied")
+		file.size, file.modTime = info.Size(), info.ModTime()
+		ctx := dog.begin(retryWorkerID, file.srcPath)
+		err = p.processFile(ctx, &file, opts, log, nil)
+		dog.end(retryWorkerID)
+		if errors.Is(err, errFileChanged) {
+			log.Warn("Skipping file that is still changing, import it again once it is complete")
+			report.Changing = append(report.Changing, file.srcPath)
+			continue
+		}
+		if errors.Is(err, errFileTooSmall) {
+			log.Info("Skipping file smaller than the minimum file size", "size", file.size, "min_size", opts.MinFileSize)
+			report.TooSmall = append(report.TooSmall, file.srcPath)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := journal.record(file); err != nil {
+			log.Warn("Failed to record processed file, a resumed parse will process it again", "error", err)
+		}
+		report.SourceBytes += file.size
+		report.imported = append(report.imported, file)
+	}
 	return nil
 }
 
-// processFileWorker processes files from the jobs channel
-func (p *mediaParser) processFileWorker(jobs <-chan fileToProcess, errChan chan<- error, opts ParseOptions, wg *sync.WaitGroup, processedCount *atomic.Int64, totalCount *atomic.Int64) {
+// sourcePaths returns the source paths of files
+func sourcePaths(files []fileToProcess) []string {
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.srcPath)
+	}
+	return paths
+}
+
+// processFileWorker processes files from the jobs channel.
+// Every line it logs carries the worker ID and the source file, so grepping for a
+// file shows its whole trip through the worker.
+func (p *mediaParser) processFileWorker(workerID int, jobs <-chan fileToProcess, failed *collectedErrors, opts ParseOptions, wg *sync.WaitGroup, processedCount *atomic.Int64, totalCount *atomic.Int64, sourceBytes *atomic.Int64, imported, changed, tooSmall *collectedFiles, dog *watchdog, journal *processedJournal) {
 	defer wg.Done()
+	workerLog := logger.With("worker", workerID)
 	for file := range jobs {
-		logger.Debug("Copying file", "from", file.srcPath, "to", file.destPath)
+		// Drain the remaining files once the run is out of time
+		if dog.ctx.Err() != nil {
+			continue
+		}
+		log := workerLog.With("file", file.srcPath)
 
 		// Increment processed count
 		processedCount.Add(1)
@@ -170,66 +729,542 @@ func (p *mediaParser) processFileWorker(jobs <-chan fileToProcess, errChan chan<
 				Stage:   "copying",
 				Current: int(current),
 				Total:   int(total),
-				Message: fmt.Sprintf("Copying file %d of %d", current, total),
+				Message: i18n.T("progress.copying", current, total),
 				File:    file.srcPath,
 			}:
 			default:
-				logger.Debug("Progress event dropped (channel full)", "stage", "copying")
+				log.Debug("Progress event dropped (channel full)", "stage", "copying")
+			}
+		}
+
+		// Emit compression progress event
+		onCompress := func() {
+			if opts.ProgressChan == nil {
+				return
+			}
+			current := processedCount.Load()
+			total := totalCount.Load()
+
+			select {
+			case opts.ProgressChan <- ProgressEvent{
+				Stage:   "compressing",
+				Current: int(current),
+				Total:   int(total),
+				Message: i18n.T("progress.compressing", current, total),
+				File:    file.destPath,
+			}:
+			default:
+				log.Debug("Progress event dropped (channel full)", "stage", "compressing")
 			}
 		}
 
-		if err := copyFilePreserveTime(file.srcPath, file.destPath); err != nil {
-			errChan <- fmt.Errorf("failed to copy %s: %w", file.srcPath, err)
+		if journal.alreadyProcessed(&file) {
+			log.Debug("Keeping file processed by the interrupted parse", "dest", file.destPath)
+			sourceBytes.Add(file.size)
+			imported.add(file)
 			continue
 		}
 
-		// Store the original filename in EXIF metadata (before prefix was added)
-		originalName := filepath.Base(file.srcPath)
-		if _, err := p.exifWriter.WriteOriginalFileNameIfMissing(file.destPath, originalName); err != nil {
-			logger.Warn("Failed to write original filename to EXIF", "file", file.srcPath, "error", err)
-			// Continue processing even if EXIF write fails
+		ctx := dog.begin(workerID, file.srcPath)
+		err := p.processFile(ctx, &file, opts, log, onCompress)
+		dog.end(workerID)
+		if errors.Is(err, errFileChanged) {
+			log.Warn("File changed while being copied, retrying it at the end", "error", err)
+			changed.add(file)
+			continue
+		}
+		if errors.Is(err, errFileTooSmall) {
+			log.Info("Skipping file smaller than the minimum file size", "size", file.size, "min_size", opts.MinFileSize)
+			tooSmall.add(file)
+			continue
+		}
+		if err != nil {
+			failed.add(err)
+			continue
+		}
+		if err := journal.record(file); err != nil {
+			log.Warn("Failed to record processed file, a resumed parse will process it again", "error", err)
+		}
+		sourceBytes.Add(file.size)
+		imported.add(file)
+	}
+}
+
+// processFile copies a file to its destination, stores its original name in EXIF and
+// compresses it, image or video, if needed, pointing file at its new destination if it was
+// converted to another format. onCompress, if set, is called before compressing. Cancelling
+// ctx kills the helper processes run for the file.
+// It returns errFileChanged if the source file changed since it was discovered, and
+// errFileTooSmall if it is smaller than opts.MinFileSize.
+func (p *mediaParser) processFile(ctx context.Context, file *fileToProcess, opts ParseOptions, log *logger.Logger, onCompress func()) error {
+	if opts.MinFileSize > 0 && file.size < opts.MinFileSize {
+		return errFileTooSmall
+	}
+
+	log.Debug("Copying file", "dest", file.destPath)
+	// Sources of moved files are removed, so their copies must be verified
+	if err := copyUnchangedFile(*file, opts.VerifyCopy || opts.MoveFiles); err != nil {
+		if errors.Is(err, errFileChanged) {
+			return err
+		}
+		return fmt.Errorf("failed to copy %s: %w", file.srcPath, err)
+	}
+
+	// Camcorders may keep the date of a video in sidecar files only, which the organiser reads
+	if p.extensions.IsVideo(file.srcPath) {
+		if err := copySidecars(file.srcPath, file.destPath); err != nil {
+			log.Warn("Failed to copy sidecar files, the video may be dated by its modification time", "error", err)
+		}
+	}
+
+	if err := p.prepareCopy(ctx, *file, opts, log); err != nil {
+		return err
+	}
+
+	// compressedQuality is the quality the file was compressed to, 0 if it wasn't
+	compressedQuality := 0
+	if shouldCompress(*file, opts) {
+		format, _ := outputFormat(*file, opts)
+		codec := p.codecs[format]
+		log.Debug("Compressing file", "dest", file.destPath, "format", format)
+		if onCompress != nil {
+			onCompress()
+		}
+
+		if opts.JPEGTargetSize == 0 && format == ImageFormatJPEG {
+			warnAboveSourceQuality(*file, opts.JPEGQuality, log)
+		}
+		compressOpts := CompressOptions{
+			Quality:     opts.JPEGQuality,
+			TargetSize:  opts.JPEGTargetSize,
+			Progressive: opts.ProgressiveJPEGs,
+		}
+		var before map[string]string
+		if shouldVerifyMetadata(opts) {
+			var err error
+			if before, err = p.metadata.ReadFields(file.destPath, keyMetadataFields); err != nil {
+				return fmt.Errorf("failed to read EXIF metadata of %s before compression: %w", file.destPath, err)
+			}
+		}
+		var uncompressedSize int64
+		if info, err := os.Stat(file.destPath); err == nil {
+			uncompressedSize = info.Size()
+		}
+		encoded, err := codec.Encode(ctx, file.destPath, compressOpts)
+		if err != nil {
+			// Log warning and continue with uncompressed file
+			// This handles files with minor corruption (e.g., extraneous data after JPEG end marker)
+			log.Warn("Failed to compress file, continuing with uncompressed version", "dest", file.destPath, "error", err)
 		} else {
-			logger.Debug("Stored original filename in EXIF", "original", originalName, "dest", file.destPath)
-		}
-
-		if file.isJPEG && opts.CompressJPEGs {
-			logger.Debug("Compressing file", "path", file.destPath)
-
-			// Emit compression progress event
-			if opts.ProgressChan != nil {
-				current := processedCount.Load()
-				total := totalCount.Load()
-
-				select {
-				case opts.ProgressChan <- ProgressEvent{
-					Stage:   "compressing",
-					Current: int(current),
-					Total:   int(total),
-					Message: fmt.Sprintf("Compressing file %d of %d", current, total),
-					File:    file.destPath,
-				}:
-				default:
-					logger.Debug("Progress event dropped (channel full)", "stage", "compressing")
+			var kept bool
+			if encoded != file.destPath {
+				kept, err = p.keepConverted(ctx, file, encoded, uncompressedSize, log)
+			} else {
+				var reverted bool
+				reverted, err = p.keepSmallerCopy(ctx, *file, uncompressedSize, opts, log)
+				kept = !reverted
+			}
+			if err != nil {
+				return fmt.Errorf("failed to keep the original of %s: %w", file.destPath, err)
+			}
+			if kept {
+				compressedQuality = compressionQuality(*file, format, opts, log)
+				if before != nil {
+					if err := p.verifyMetadataKept(*file, before, log); err != nil {
+						return err
+					}
 				}
 			}
+		}
+	}
+
+	// Written once the image is in its final format, whose extension the original name takes
+	if err := p.writeImportMetadata(ctx, *file, compressedQuality, opts, log); err != nil {
+		return err
+	}
+
+	if shouldCompressVideo(*file, opts) {
+		log.Debug("Compressing video", "dest", file.destPath)
+		if onCompress != nil {
+			onCompress()
+		}
+		compressOpts := VideoCompressOptions{
+			CRF:     opts.VideoCRF,
+			Bitrate: opts.VideoBitrate,
+		}
+		release, err := acquireVideoSlot(ctx)
+		if err == nil {
+			err = p.videoCompressor.CompressFile(ctx, file.destPath, compressOpts)
+			release()
+		}
+		if err != nil {
+			log.Warn("Failed to compress video, continuing with uncompressed version", "dest", file.destPath, "error", err)
+		}
+	}
+
+	log.Debug("Finished processing file", "dest", file.destPath)
+	return nil
+}
+
+// writeImportMetadata writes the metadata the import adds to the copy of file, compressed to
+// quality, with a single exiftool run: its original name and, as opts ask, its album keywords,
+// assigned date and provenance. Only failing to write the assigned date fails the file.
+func (p *mediaParser) writeImportMetadata(ctx context.Context, file fileToProcess, quality int, opts ParseOptions, log *logger.Logger) error {
+	// Store the original filename in EXIF metadata (before prefix was added). A converted image
+	// is named with the extension of its format, so restoring the name keeps the file readable.
+	originalName := filepath.Base(file.srcPath)
+	if ext := filepath.Ext(file.destPath); !strings.EqualFold(ext, filepath.Ext(originalName)) {
+		originalName = strings.TrimSuffix(originalName, filepath.Ext(originalName)) + ext
+	}
+	metadata := ImportMetadata{
+		OriginalFileName:   originalName,
+		OriginalNamePolicy: opts.OriginalNamePolicy,
+		Date:               opts.AssignedDate,
+	}
+	if opts.AlbumKeywords {
+		metadata.Keywords = file.albums
+	}
+	if opts.WriteProvenance {
+		metadata.Provenance = &Provenance{
+			SourcePath:         file.srcPath,
+			ImportDate:         time.Now(),
+			Version:            opts.Version,
+			CompressionQuality: quality,
+		}
+	}
+
+	if _, err := p.exifWriter.WriteImportMetadata(ctx, file.destPath, metadata); err != nil {
+		if opts.AssignedDate != nil {
+			return fmt.Errorf("failed to write the assigned date to %s: %w", file.destPath, err)
+		}
+		log.Warn("Failed to write import metadata to EXIF", "error", err)
+		// Continue processing even if EXIF write fails
+	} else {
+		log.Debug("Stored import metadata in EXIF", "original", originalName, "dest", file.destPath)
+	}
+	return nil
+}
+
+// compressionQuality returns the quality file was written in format to: the quality of the
+// options, or the one estimated from the result when compressing to a target size picks it per
+// JPEG. Lossless JPEGs and images converted to a target size get 0, as their quality is unknown.
+func compressionQuality(file fileToProcess, format ImageFormat, opts ParseOptions, log *logger.Logger) int {
+	switch {
+	case format == ImageFormatJPEGLossless:
+		return 0
+	case opts.JPEGTargetSize == 0 || format == ImageFormatAVIF:
+		// avifenc has no target size, so AVIFs are always encoded to the quality
+		return opts.JPEGQuality
+	case format != ImageFormatJPEG:
+		return 0
+	}
+	quality, err := estimateJPEGQuality(file.destPath)
+	if err != nil {
+		log.Debug("Could not estimate JPEG quality", "dest", file.destPath, "error", err)
+		return 0
+	}
+	return quality
+}
+
+// warnAboveSourceQuality warns when quality is above the quality the JPEG was saved with.
+// jpegoptim never raises the quality of an image, so it is only optimised losslessly and
+// stays about the same size.
+func warnAboveSourceQuality(file fileToProcess, quality int, log *logger.Logger) {
+	sourceQuality, err := estimateJPEGQuality(file.destPath)
+	if err != nil {
+		log.Debug("Could not estimate JPEG quality", "dest", file.destPath, "error", err)
+		return
+	}
+	if quality > sourceQuality {
+		log.Warn("JPEG quality is above the estimated quality of the image, it will barely shrink", "quality", quality, "estimated_quality", sourceQuality)
+	}
+}
+
+// verifyMetadataKept checks that the key EXIF fields a file had before compression are unchanged
+func (p *mediaParser) verifyMetadataKept(file fileToProcess, before map[string]string, log *logger.Logger) error {
+	after, err := p.metadata.ReadFields(file.destPath, keyMetadataFields)
+	if err != nil {
+		return fmt.Errorf("failed to read EXIF metadata of %s after compression: %w", file.destPath, err)
+	}
+	if lost := lostMetadataFields(keyMetadataFields, before, after); len(lost) > 0 {
+		log.Error("Compression dropped EXIF metadata", "dest", file.destPath, "fields", lost)
+		return fmt.Errorf("compression dropped EXIF metadata of %s: %s", file.srcPath, strings.Join(lost, ", "))
+	}
+	log.Debug("EXIF metadata survived compression", "dest", file.destPath)
+	return nil
+}
+
+// copyUnchangedFile copies a file as copyFilePreserveTime does, or copyFileVerified if verify
+// is set, checking that its size and modification time still match those seen at discovery
+// both before and after the copy. A file that changed is not left half-copied at the destination.
+func copyUnchangedFile(file fileToProcess, verify bool) error {
+	if err := checkUnchanged(file); err != nil {
+		return err
+	}
+	copyFile := copyFilePreserveTime
+	if verify {
+		copyFile = copyFileVerified
+	}
+	if err := copyFile(file.srcPath, file.destPath); err != nil {
+		return err
+	}
+	if err := checkUnchanged(file); err != nil {
+		os.Remove(file.destPath)
+		return err
+	}
+	return nil
+}
 
-			if err := p.compressor.CompressFile(file.destPath, opts.JPEGQuality); err != nil {
-				// Log warning and continue with uncompressed file
-				// This handles files with minor corruption (e.g., extraneous data after JPEG end marker)
-				logger.Warn("Failed to compress file, continuing with uncompressed version", "file", file.destPath, "error", err)
+// trashImportedSources moves the source files of files to the trash of their source once they
+// are in the library, keeping their path within the source. It returns those moved, their size
+// and the directories whose trash they went to. Files of
+// a file list are moved to the trash of their own directory. A source that changed since it
+// was copied, or can't be moved, is kept and logged, since the library already has a copy of it.
+// Runs older than retention (0 = none) are then emptied from the trashes the files went to.
+func trashImportedSources(sources []parseSource, files []fileToProcess, start time.Time, retention time.Duration) ([]string, int64, []string) {
+	var moved []string
+	var size int64
+	roots := make(map[string]bool)
+	for _, file := range files {
+		if err := checkUnchanged(file); err != nil {
+			logger.Warn("Keeping source file that changed after being imported", "file", file.srcPath, "error", err)
+			continue
+		}
+		root := filepath.Dir(file.srcPath)
+		for _, source := range sources {
+			if source.dir != "" && isSameOrNested(file.srcPath, source.dir) {
+				root = source.dir
+				break
 			}
 		}
+		rel, err := filepath.Rel(root, file.srcPath)
+		if err != nil {
+			logger.Warn("Failed to move imported source file to the trash", "file", file.srcPath, "error", err)
+			continue
+		}
+		dest, err := moveToTrash(root, rel, start)
+		if err != nil {
+			logger.Warn("Failed to move imported source file to the trash", "file", file.srcPath, "error", err)
+			continue
+		}
+		logger.Debug("Moved imported source file to the trash", "file", file.srcPath, "trash", dest)
+		roots[root] = true
+		moved = append(moved, file.srcPath)
+		size += file.size
+	}
+	trashes := slices.Sorted(maps.Keys(roots))
+	logger.Info("Moved imported source files to the trash", "count", len(moved), "kept", len(files)-len(moved), "trash", trashes)
+
+	if retention <= 0 {
+		return moved, size, trashes
+	}
+	for _, root := range trashes {
+		emptied, err := EmptyTrash(root, start.Add(-retention))
+		if err != nil {
+			logger.Warn("Failed to empty expired runs from the trash", "dir", root, "error", err)
+		}
+		for _, run := range emptied {
+			logger.Info("Emptied expired run from the trash", "run", run.Dir, "files", run.Files, "size", FormatByteSize(run.Bytes))
+		}
+	}
+	return moved, size, trashes
+}
+
+// checkUnchanged returns errFileChanged if the source file's size or modification time
+// differ from those seen at discovery
+func checkUnchanged(file fileToProcess) error {
+	info, err := os.Stat(file.srcPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+		return fmt.Errorf("%w: size %d -> %d, modified %s -> %s", errFileChanged,
+			file.size, info.Size(), file.modTime.Format(time.RFC3339Nano), info.ModTime().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// shouldCompress reports whether a copied file gets compressed, or converted to another format.
+// JPEGs compressed as JPEGs that are smaller than MinSizeForCompression or at most MinBitsPerPixel
+// are kept verbatim; converted images are all converted, so the library holds a single format.
+func shouldCompress(file fileToProcess, opts ParseOptions) bool {
+	format, ok := outputFormat(file, opts)
+	if !ok {
+		return false
+	}
+	if format != ImageFormatJPEG && format != ImageFormatJPEGLossless {
+		return true
+	}
+	if opts.MinSizeForCompression <= 0 && opts.MinBitsPerPixel <= 0 {
+		return true
+	}
+
+	info, err := os.Stat(file.destPath)
+	if err != nil {
+		// Let the compressor report the problem
+		return true
+	}
+	if info.Size() < opts.MinSizeForCompression {
+		logger.Debug("Skipping compression of small file", "file", file.srcPath, "dest", file.destPath, "size", info.Size(), "min_size", opts.MinSizeForCompression)
+		return false
+	}
+	if opts.MinBitsPerPixel > 0 {
+		bpp, err := jpegBitsPerPixel(file.destPath, info.Size())
+		if err != nil {
+			logger.Debug("Could not measure bits per pixel", "dest", file.destPath, "error", err)
+			return true
+		}
+		if bpp <= opts.MinBitsPerPixel {
+			logger.Debug("Skipping compression of compact file", "file", file.srcPath, "dest", file.destPath, "bits_per_pixel", bpp, "min_bits_per_pixel", opts.MinBitsPerPixel)
+			return false
+		}
+	}
+	return true
+}
+
+// keepSmallerCopy copies the source of file to its destination again, downscaled as before, if
+// compressing made the copy larger than the uncompressedSize it had, as a
+// compressor may for images saved compactly already. It reports whether it did.
+func (p *mediaParser) keepSmallerCopy(ctx context.Context, file fileToProcess, uncompressedSize int64, opts ParseOptions, log *logger.Logger) (bool, error) {
+	info, err := os.Stat(file.destPath)
+	if err != nil {
+		return false, err
+	}
+	if uncompressedSize == 0 || info.Size() <= uncompressedSize {
+		return false, nil
+	}
+	log.Debug("Compression grew the file, keeping the original", "dest", file.destPath, "size", uncompressedSize, "compressed_size", info.Size())
+	if err := copyUnchangedFile(file, opts.VerifyCopy || opts.MoveFiles); err != nil {
+		return false, err
+	}
+	return true, p.prepareCopy(ctx, file, opts, log)
+}
+
+// outputFormat returns the format the copy of file is written in, or false if it is kept as it is
+func outputFormat(file fileToProcess, opts ParseOptions) (ImageFormat, bool) {
+	if !opts.CompressJPEGs {
+		return "", false
+	}
+	if format, ok := opts.OutputFormats[strings.ToLower(filepath.Ext(file.srcPath))]; ok {
+		return format, true
+	}
+	return ImageFormatJPEG, file.isJPEG
+}
+
+// validateOutputFormats checks that the parser has a codec for each of opts.OutputFormats that
+// reads the images given to it, and writes images the library supports
+func (p *mediaParser) validateOutputFormats(opts ParseOptions) error {
+	for ext, format := range opts.OutputFormats {
+		codec, ok := p.codecs[format]
+		if !ok {
+			return fmt.Errorf("no codec for image format %s", format)
+		}
+		if !codec.Reads("image" + ext) {
+			return fmt.Errorf("image format %s can't be written from %s images", format, ext)
+		}
+		if !p.extensions.IsImage("image" + codec.Extension()) {
+			return fmt.Errorf("image format %s writes %s images, which aren't supported as images", format, codec.Extension())
+		}
+	}
+	return nil
+}
 
-		logger.Debug("Finished processing file", "path", file.destPath)
+// keepConverted replaces the copy of file with encoded, its conversion to another format, once
+// the metadata of the copy is copied to it, and points file at it. The conversion is removed
+// instead, keeping the copy, if it is larger than the uncompressedSize of the copy or its
+// metadata can't be copied. It reports whether it replaced the copy.
+func (p *mediaParser) keepConverted(ctx context.Context, file *fileToProcess, encoded string, uncompressedSize int64, log *logger.Logger) (bool, error) {
+	info, err := os.Stat(encoded)
+	if err != nil {
+		return false, err
+	}
+	if uncompressedSize > 0 && info.Size() > uncompressedSize {
+		log.Debug("Conversion grew the file, keeping the original", "dest", file.destPath, "size", uncompressedSize, "converted_size", info.Size())
+		return false, os.Remove(encoded)
+	}
+	// Encoders keep little of the metadata, if any
+	if err := p.exifWriter.CopyMetadata(ctx, file.destPath, encoded, false); err != nil {
+		log.Warn("Failed to copy metadata to the converted image, keeping the original format", "error", err)
+		return false, os.Remove(encoded)
+	}
+	if err := os.Remove(file.destPath); err != nil {
+		return false, err
 	}
+	log.Debug("Converted image", "dest", encoded, "size", uncompressedSize, "converted_size", info.Size())
+	file.destPath = encoded
+	return true, nil
 }
 
-// discoverFiles walks directories recursively and sends files to the jobs channel
-func (p *mediaParser) discoverFiles(sourceDir, tmpTarget string, jobs chan<- fileToProcess) {
+// shouldCompressVideo reports whether a copied file gets re-encoded. Only MOV and MP4 videos
+// are compressed.
+func shouldCompressVideo(file fileToProcess, opts ParseOptions) bool {
+	return opts.CompressVideos && isCompressibleVideo(file.destPath)
+}
+
+// discoverFiles walks the source directories in order and sends their files to the jobs channel,
+// until ctx is done
+func (p *mediaParser) discoverFiles(ctx context.Context, sources []parseSource, tmpTarget string, jobs chan<- fileToProcess) {
 	defer close(jobs)
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return
+		}
+		if source.files != nil {
+			p.discoverListedFiles(ctx, source.files, tmpTarget, jobs)
+			continue
+		}
+		p.discoverSourceFiles(ctx, source, tmpTarget, jobs)
+	}
+}
+
+// discoverListedFiles sends the supported files of a file list to the jobs channel.
+// Files are prefixed with their position in the list, which keeps files with the same
+// name in different directories apart and numbers them in list order.
+func (p *mediaParser) discoverListedFiles(ctx context.Context, files []string, tmpTarget string, jobs chan<- fileToProcess) {
+	logger.Info("Discovering listed files to process", "files", len(files))
+	width := len(strconv.Itoa(len(files)))
+	for i, path := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if !p.extensions.IsSupported(path) {
+			continue
+		}
+
+		// Skip invalid/corrupted files
+		if err := isValidFile(path); err != nil {
+			logger.Warn("Skipping file", "file", path, "reason", err)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Warn("Skipping file", "file", path, "reason", err)
+			continue
+		}
+
+		destPath := filepath.Join(tmpTarget, fmt.Sprintf("%0*d-%s", width, i+1, filepath.Base(path)))
+		logger.Debug("Discovered file", "file", path, "dest", destPath)
+
+		jobs <- fileToProcess{
+			srcPath:  path,
+			destPath: destPath,
+			isJPEG:   p.extensions.IsJPEG(path),
+			size:     info.Size(),
+			modTime:  info.ModTime(),
+		}
+	}
+}
+
+// discoverSourceFiles walks a source directory recursively and sends files not matched by its
+// ignore rules to the jobs channel
+func (p *mediaParser) discoverSourceFiles(ctx context.Context, source parseSource, tmpTarget string, jobs chan<- fileToProcess) {
+	sourceDir, ignore := source.dir, source.ignore
 	logger.Info("Discovering files to process", "source", sourceDir)
 
 	filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
 		if err != nil {
 			logger.Debug("Error accessing path", "path", path, "error", err)
 			return err
@@ -243,6 +1278,15 @@ func (p *mediaParser) discoverFiles(sourceDir, tmpTarget string, jobs chan<- fil
 			return nil
 		}
 
+		// Skip paths excluded by .picsignore files
+		if ignore.isIgnored(path, info.IsDir()) {
+			logger.Debug("Skipping ignored path", "file", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		if info.IsDir() {
 			return nil
 		}
@@ -267,21 +1311,69 @@ func (p *mediaParser) discoverFiles(sourceDir, tmpTarget string, jobs chan<- fil
 				prefix = "root"
 			}
 
-			destPath := filepath.Join(tmpTarget, fmt.Sprintf("%s-%s", prefix, filepath.Base(path)))
-			logger.Debug("Discovered file", "path", path, "dest", destPath)
+			destPath := filepath.Join(tmpTarget, fmt.Sprintf("%s%s-%s", source.prefix, prefix, filepath.Base(path)))
+			logger.Debug("Discovered file", "file", path, "dest", destPath)
 
 			jobs <- fileToProcess{
 				srcPath:  path,
 				destPath: destPath,
 				isJPEG:   p.extensions.IsJPEG(path),
+				albums:   albumNames(filepath.Dir(relPath)),
+				size:     info.Size(),
+				modTime:  info.ModTime(),
 			}
 		}
 		return nil
 	})
 }
 
+// cameraDirPattern matches the folders cameras create under DCIM, such as 100CANON or 101_PANA
+var cameraDirPattern = regexp.MustCompile(`^\d{3}[0-9A-Za-z_]{5}$`)
+
+// albumNames returns the names of the directories in relDir, a path relative to a source
+// directory, as album names. DCIM and the camera folders below it say nothing about the
+// photos and are left out.
+func albumNames(relDir string) []string {
+	var albums []string
+	for _, name := range strings.Split(filepath.ToSlash(relDir), "/") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "." || strings.EqualFold(name, "DCIM") || cameraDirPattern.MatchString(name) {
+			continue
+		}
+		albums = append(albums, name)
+	}
+	return albums
+}
+
 // copyFilePreserveTime copies a file and preserves its modification time
 func copyFilePreserveTime(src, dst string) error {
+	return copyFileHashing(src, dst, nil)
+}
+
+// copyFileVerified copies a file as copyFilePreserveTime does, hashing the source with SHA-256
+// as it is read, then reads the copy back and compares the hashes. This catches corruption on
+// the way to the destination, such as a bad cable or faulty memory, that size checks miss. A
+// copy that doesn't match is removed.
+func copyFileVerified(src, dst string) error {
+	sourceHash := sha256.New()
+	if err := copyFileHashing(src, dst, sourceHash); err != nil {
+		return err
+	}
+	copySum, err := fileSHA256(dst)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %w", dst, err)
+	}
+	if sourceSum := sourceHash.Sum(nil); !bytes.Equal(sourceSum, copySum) {
+		os.Remove(dst)
+		return fmt.Errorf("%w: SHA-256 of %s is %x, of its copy %x", errCopyMismatch, src, sourceSum, copySum)
+	}
+	logger.Debug("Copy verified", "file", dst, "sha256", fmt.Sprintf("%x", copySum))
+	return nil
+}
+
+// copyFileHashing copies a file and preserves its modification time. If hash is set, the source
+// is written to it as it is read, and the copy is flushed to disk before returning.
+func copyFileHashing(src, dst string, hash io.Writer) error {
 	logger.Debug("Starting file copy", "from", src, "to", dst)
 
 	srcInfo, err := os.Stat(src)
@@ -297,18 +1389,28 @@ func copyFilePreserveTime(src, dst string) error {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, libraryFileMode)
 	if err != nil {
 		logger.Debug("Failed to create destination file", "file", dst, "error", err)
 		return err
 	}
 	defer dstFile.Close()
 
-	bytesWritten, err := io.Copy(dstFile, srcFile)
+	var reader io.Reader = srcFile
+	if hash != nil {
+		reader = io.TeeReader(srcFile, hash)
+	}
+	bytesWritten, err := io.Copy(dstFile, reader)
 	if err != nil {
 		logger.Debug("Failed to copy file contents", "from", src, "to", dst, "error", err)
 		return err
 	}
+	if hash != nil {
+		if err := dstFile.Sync(); err != nil {
+			logger.Debug("Failed to flush copy to disk", "file", dst, "error", err)
+			return err
+		}
+	}
 
 	logger.Debug("File copied successfully", "from", src, "to", dst, "bytes", bytesWritten)
 
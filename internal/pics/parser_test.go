@@ -1,10 +1,21 @@
 package pics
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
+
+	"github.com/acm19/pics/internal/logger"
 )
 
 // Test-level options used across all tests
@@ -20,7 +31,7 @@ func createTestParser(t *testing.T) MediaParser {
 	et := createTestExiftool(t)
 	organiser := NewFileOrganiser(et)
 	exifWriter := NewExifWriter(et)
-	return NewMediaParser("", organiser, exifWriter)
+	return NewMediaParser("", "", organiser, exifWriter, NewMetadataReader(et))
 }
 
 // Helper functions
@@ -97,11 +108,14 @@ func TestMediaParser_Parse(t *testing.T) {
 	createMediaFile(t, sourceDir, "video1.mov", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	report, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
+	if report.Imported != 3 || report.SourceBytes == 0 || report.ImportedBytes != report.SourceBytes {
+		t.Errorf("Expected 3 files imported uncompressed, got %+v", report)
+	}
 
 	// Check that files were organised into date-based directory
 	expectedDir := filepath.Join(targetDir, "2023 06 June 15")
@@ -117,12 +131,92 @@ func TestMediaParser_Parse(t *testing.T) {
 	assertMediaFileExists(t, filepath.Join(videosDir, "2023_06_June_15_00001.mov"))
 }
 
+func TestMediaParser_Parse_AssignedDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// Scans carry the date they were scanned on, or none at all
+	createValidJPEGWithDate(t, sourceDir, "scan1.jpg", time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC))
+	createValidJPEGWithDate(t, sourceDir, "scan2.jpg", time.Date(1980, 1, 1, 12, 0, 0, 0, time.UTC))
+	date, err := ParseApproximateDate("1994-07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testParseOptions
+	opts.AssignedDate = &date
+
+	report, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.Imported != 2 || len(report.Review) != 0 {
+		t.Errorf("Expected 2 files imported and none to review, got %+v", report)
+	}
+
+	expectedDir := filepath.Join(targetDir, "1994 07 July 01")
+	assertMediaFileExists(t, filepath.Join(expectedDir, "1994_07_July_01_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(expectedDir, "1994_07_July_01_00002.jpg"))
+}
+
+func TestMediaParser_Adopt(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// A parse was killed after copying its files, while organising them
+	session, err := createSessionDir(tmpDir, SessionKindParse, targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.setStage(SessionStageOrganising); err != nil {
+		t.Fatal(err)
+	}
+	filesDir := filepath.Join(session.Dir, sessionFilesDirName)
+	if err := os.Mkdir(filesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMediaFile(t, filesDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC))
+	createMediaFile(t, filesDir, "IMG_0002.jpg", time.Date(2023, 6, 15, 11, 0, 0, 0, time.UTC))
+
+	report, err := createTestParser(t).Adopt(testCtx, *session, nil)
+	if err != nil {
+		t.Fatalf("Adopt failed: %v", err)
+	}
+	if report.Imported != 2 {
+		t.Errorf("Expected 2 files imported, got %+v", report)
+	}
+	expectedDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(expectedDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(expectedDir, "2023_06_June_15_00002.jpg"))
+	if _, err := os.Stat(session.Dir); !os.IsNotExist(err) {
+		t.Error("Expected the session removed once adopted")
+	}
+}
+
+func TestMediaParser_Adopt_StillCopying(t *testing.T) {
+	session, err := createSessionDir(t.TempDir(), SessionKindParse, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.setStage(SessionStageCopying); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files of a parse interrupted while copying may be incomplete
+	parser := NewMediaParser("", "", nil, nil, nil)
+	if _, err := parser.Adopt(testCtx, *session, nil); err == nil {
+		t.Error("Expected a session interrupted while copying refused")
+	}
+	if _, err := os.Stat(session.Dir); err != nil {
+		t.Errorf("Expected the session left alone: %v", err)
+	}
+}
+
 func TestMediaParser_Parse_EmptySource(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
 
 	// Parse with no files in source
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error for empty source, got: %v", err)
@@ -141,7 +235,7 @@ func TestMediaParser_Parse_MultipleDates(t *testing.T) {
 	createMediaFile(t, sourceDir, "july.jpg", date2)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -165,7 +259,7 @@ func TestMediaParser_Parse_WithSubdirectories(t *testing.T) {
 	createMediaFile(t, subdir2, "image2.jpeg", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -204,7 +298,7 @@ func TestMediaParser_Parse_SkipsDotFiles(t *testing.T) {
 	createMediaFile(t, sourceDir, ".hidden.jpg", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -229,6 +323,79 @@ func TestMediaParser_Parse_SkipsDotFiles(t *testing.T) {
 	}
 }
 
+func TestMediaParser_Parse_HonoursIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, sourceDir, "skip.jpg", testDate)
+	thumbs := createSubdir(t, sourceDir, "thumbnails")
+	createMediaFile(t, thumbs, "thumb.jpg", testDate)
+	writeIgnoreFile(t, sourceDir, "skip.jpg\nthumbnails/\n")
+
+	if _, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(targetDir, "2023 06 June 15"))
+	if err != nil {
+		t.Fatalf("Failed to read target directory: %v", err)
+	}
+
+	fileCount := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			fileCount++
+		}
+	}
+
+	if fileCount != 1 {
+		t.Errorf("Expected 1 file (ignored files should be skipped), got %d", fileCount)
+	}
+}
+
+func TestMediaParser_DiscoverFiles_HonoursIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, sourceDir, "skip.jpg", testDate)
+	thumbs := createSubdir(t, sourceDir, "thumbnails")
+	createMediaFile(t, thumbs, "thumb.jpg", testDate)
+	album := createSubdir(t, sourceDir, "album")
+	createMediaFile(t, album, "kept.jpg", testDate)
+	createMediaFile(t, album, "draft.jpg", testDate)
+	writeIgnoreFile(t, sourceDir, "skip.jpg\nthumbnails/\n")
+	writeIgnoreFile(t, album, "draft.jpg\n")
+
+	sources, err := newParseSources([]string{sourceDir})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// discoverFiles doesn't need exiftool, so the ignore rules are checked without it
+	parser := &mediaParser{extensions: NewExtensions()}
+	jobs := make(chan fileToProcess, 10)
+	parser.discoverFiles(testCtx, sources, targetDir, jobs)
+
+	var discovered []string
+	for job := range jobs {
+		relPath, err := filepath.Rel(sourceDir, job.srcPath)
+		if err != nil {
+			t.Fatalf("Failed to calculate relative path: %v", err)
+		}
+		discovered = append(discovered, filepath.ToSlash(relPath))
+	}
+	sort.Strings(discovered)
+
+	expected := []string{"album/kept.jpg", "image.jpg"}
+	if !reflect.DeepEqual(discovered, expected) {
+		t.Errorf("Expected %v, got %v", expected, discovered)
+	}
+}
+
 func TestMediaParser_Parse_SkipsDotDirectories(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
@@ -242,7 +409,7 @@ func TestMediaParser_Parse_SkipsDotDirectories(t *testing.T) {
 	createMediaFile(t, dotSubdir, "image2.jpg", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -279,7 +446,7 @@ func TestMediaParser_Parse_MixedFileTypes(t *testing.T) {
 	createMediaFile(t, sourceDir, "video.mov", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -306,7 +473,7 @@ func TestMediaParser_Parse_IgnoresUnsupportedFiles(t *testing.T) {
 	createMediaFile(t, sourceDir, "video.avi", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -332,7 +499,7 @@ func TestMediaParser_Parse_MP4Videos(t *testing.T) {
 	createMediaFile(t, sourceDir, "video2.MP4", testDate)
 
 	// Parse files
-	err := createTestParser(t).Parse(sourceDir, targetDir, testParseOptions)
+	_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -349,6 +516,26 @@ func TestMediaParser_Parse_MP4Videos(t *testing.T) {
 	assertMediaFileExists(t, filepath.Join(videosDir, "2023_06_June_15_00002.mp4"))
 }
 
+func TestMediaParser_Parse_SidecarDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// The camcorder wrote the date to the sidecar only, the video was copied off the card later
+	createMediaFile(t, sourceDir, "C0001.MTS", time.Date(2024, 1, 20, 9, 0, 0, 0, time.UTC))
+	writeSidecar(t, sourceDir, "C0001M01.XML", sonyXMLSidecar)
+
+	if _, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The video takes the date of its sidecar, which stays out of the library
+	expectedDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(expectedDir, "videos", "2023_06_June_15_00001.mts"))
+	if got := listDir(t, expectedDir); !reflect.DeepEqual(got, []string{"videos"}) {
+		t.Errorf("Expected only the videos directory, got %v", got)
+	}
+}
+
 func TestCopyFilePreserveTime(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -437,6 +624,10 @@ func TestDefaultParseOptions(t *testing.T) {
 	if opts.MaxConcurrency != 100 {
 		t.Errorf("Expected MaxConcurrency to be 100, got %d", opts.MaxConcurrency)
 	}
+
+	if opts.MinFileSize != 10*1024 {
+		t.Errorf("Expected MinFileSize to be 10KB, got %d", opts.MinFileSize)
+	}
 }
 
 func TestMediaParser_ParseWithProgressChannel(t *testing.T) {
@@ -467,7 +658,8 @@ func TestMediaParser_ParseWithProgressChannel(t *testing.T) {
 	// Run parse in goroutine so we can read from channel
 	done := make(chan error)
 	go func() {
-		done <- createTestParser(t).Parse(sourceDir, targetDir, opts)
+		_, err := createTestParser(t).Parse(testCtx, []string{sourceDir}, targetDir, opts)
+		done <- err
 	}()
 
 	// Collect progress events
@@ -544,3 +736,688 @@ func TestMediaParser_ParseWithProgressChannel(t *testing.T) {
 			events[0].Stage, events[0].Current, events[0].Total, events[0].Message)
 	}
 }
+
+func TestShouldCompress(t *testing.T) {
+	tmpDir := t.TempDir()
+	small := filepath.Join(tmpDir, "small.jpg")
+	large := filepath.Join(tmpDir, "large.jpg")
+	if err := os.WriteFile(small, make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(large, make([]byte, 1000), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		file     fileToProcess
+		opts     ParseOptions
+		expected bool
+	}{
+		{name: "compression disabled", file: fileToProcess{destPath: large, isJPEG: true}, opts: ParseOptions{}, expected: false},
+		{name: "not a JPEG", file: fileToProcess{destPath: large}, opts: ParseOptions{CompressJPEGs: true}, expected: false},
+		{name: "no threshold", file: fileToProcess{destPath: small, isJPEG: true}, opts: ParseOptions{CompressJPEGs: true}, expected: true},
+		{name: "below threshold", file: fileToProcess{destPath: small, isJPEG: true}, opts: ParseOptions{CompressJPEGs: true, MinSizeForCompression: 500}, expected: false},
+		{name: "above threshold", file: fileToProcess{destPath: large, isJPEG: true}, opts: ParseOptions{CompressJPEGs: true, MinSizeForCompression: 500}, expected: true},
+		{name: "exactly at threshold", file: fileToProcess{destPath: large, isJPEG: true}, opts: ParseOptions{CompressJPEGs: true, MinSizeForCompression: 1000}, expected: true},
+		{name: "converted despite threshold", file: fileToProcess{srcPath: "IMG.jpg", destPath: small, isJPEG: true}, opts: ParseOptions{CompressJPEGs: true, MinSizeForCompression: 500, OutputFormats: map[string]ImageFormat{".jpg": ImageFormatWebP}}, expected: true},
+		{name: "other image converted", file: fileToProcess{srcPath: "scan.PNG", destPath: small}, opts: ParseOptions{CompressJPEGs: true, OutputFormats: map[string]ImageFormat{".png": ImageFormatAVIF}}, expected: true},
+		{name: "conversion without compression", file: fileToProcess{srcPath: "IMG.jpg", destPath: small, isJPEG: true}, opts: ParseOptions{OutputFormats: map[string]ImageFormat{".jpg": ImageFormatWebP}}, expected: false},
+		{name: "other image kept", file: fileToProcess{srcPath: "scan.png", destPath: small}, opts: ParseOptions{CompressJPEGs: true}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := shouldCompress(tt.file, tt.opts); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestShouldCompress_BitsPerPixel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for x := range 100 {
+		for y := range 100 {
+			img.Set(x, y, color.RGBA{R: uint8(x * y), G: uint8(x * 7), B: uint8(y * 13), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bpp := float64(buf.Len()*8) / (100 * 100)
+
+	file := fileToProcess{destPath: path, isJPEG: true}
+	if shouldCompress(file, ParseOptions{CompressJPEGs: true, MinBitsPerPixel: bpp}) {
+		t.Errorf("Expected a JPEG at %.2f bits per pixel kept verbatim", bpp)
+	}
+	if !shouldCompress(file, ParseOptions{CompressJPEGs: true, MinBitsPerPixel: bpp / 2}) {
+		t.Errorf("Expected a JPEG at %.2f bits per pixel compressed", bpp)
+	}
+}
+
+// growingCompressor stands in for a compressor that makes files larger
+type growingCompressor struct{}
+
+func (c *growingCompressor) CompressFile(ctx context.Context, path string, opts CompressOptions) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(" and then some")
+	return err
+}
+
+func TestProcessFile_KeepsOriginalWhenCompressionGrowsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	dest := filepath.Join(tmpDir, "copy.jpg")
+	parser := &mediaParser{
+		codecs:     ImageCodecs{ImageFormatJPEG: NewJPEGCodec(&growingCompressor{}, false)},
+		extensions: NewExtensions(),
+		exifWriter: NewExifWriter(nil),
+	}
+
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	file := discoveredFile(t, src, dest)
+	file.isJPEG = true
+	if err := parser.processFile(context.Background(), &file, opts, logger.With("file", src), nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "test media content" {
+		t.Errorf("Expected the original kept, got %q", data)
+	}
+}
+
+func TestNewParseSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	phone := createSubdir(t, tmpDir, "phone")
+	card := createSubdir(t, tmpDir, "card")
+
+	single, err := newParseSources([]string{phone + "/"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if single[0].dir != phone || single[0].prefix != "" {
+		t.Errorf("Expected a single source without prefix, got %+v", single[0])
+	}
+
+	multiple, err := newParseSources([]string{phone, card})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if multiple[0].prefix != "1-" || multiple[1].prefix != "2-" {
+		t.Errorf("Expected prefixes 1- and 2-, got %q and %q", multiple[0].prefix, multiple[1].prefix)
+	}
+}
+
+func TestNewParseSources_RejectsOverlappingSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	phone := createSubdir(t, tmpDir, "phone")
+	camera := createSubdir(t, phone, "Camera")
+
+	tests := []struct {
+		name    string
+		sources []string
+	}{
+		{"same directory twice", []string{phone, phone}},
+		{"same directory with trailing slash", []string{phone, phone + "/"}},
+		{"nested directory", []string{phone, camera}},
+		{"parent directory", []string{camera, phone}},
+		{"no sources", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newParseSources(tt.sources); err == nil {
+				t.Errorf("Expected error for sources %v", tt.sources)
+			}
+		})
+	}
+}
+
+func TestMediaParser_DiscoverFiles_MultipleSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	phone := createSubdir(t, tmpDir, "phone")
+	card := createSubdir(t, tmpDir, "card")
+	targetDir := createSubdir(t, tmpDir, "target")
+
+	// The same relative path in both sources must not collide in the target
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, phone, "IMG_0001.jpg", testDate)
+	createMediaFile(t, card, "IMG_0001.jpg", testDate)
+	createMediaFile(t, createSubdir(t, card, "DCIM"), "IMG_0002.jpg", testDate)
+	writeIgnoreFile(t, card, "ignored.jpg\n")
+	createMediaFile(t, card, "ignored.jpg", testDate)
+
+	sources, err := newParseSources([]string{phone, card})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	parser := &mediaParser{extensions: NewExtensions()}
+	jobs := make(chan fileToProcess, 10)
+	parser.discoverFiles(testCtx, sources, targetDir, jobs)
+
+	var discovered []string
+	for job := range jobs {
+		discovered = append(discovered, filepath.Base(job.destPath))
+	}
+
+	// Files keep the order of their sources, so they are numbered in that order
+	expected := []string{"1-root-IMG_0001.jpg", "2-DCIM-IMG_0002.jpg", "2-root-IMG_0001.jpg"}
+	if !reflect.DeepEqual(discovered, expected) {
+		t.Errorf("Expected %v, got %v", expected, discovered)
+	}
+}
+
+func TestMediaParser_DiscoverFiles_Albums(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "root.jpg", testDate)
+	wedding := createSubdir(t, sourceDir, "Wedding")
+	createMediaFile(t, createSubdir(t, wedding, "Ceremony"), "vows.jpg", testDate)
+	createMediaFile(t, createSubdir(t, createSubdir(t, sourceDir, "DCIM"), "100CANON"), "IMG_0001.jpg", testDate)
+
+	sources, err := newParseSources([]string{sourceDir})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	parser := &mediaParser{extensions: NewExtensions()}
+	jobs := make(chan fileToProcess, 10)
+	parser.discoverFiles(testCtx, sources, targetDir, jobs)
+
+	albums := make(map[string][]string)
+	for job := range jobs {
+		albums[filepath.Base(job.srcPath)] = job.albums
+	}
+	expected := map[string][]string{
+		"root.jpg":     nil,
+		"vows.jpg":     {"Wedding", "Ceremony"},
+		"IMG_0001.jpg": nil,
+	}
+	if !reflect.DeepEqual(albums, expected) {
+		t.Errorf("Expected %v, got %v", expected, albums)
+	}
+}
+
+func TestAlbumNames(t *testing.T) {
+	tests := []struct {
+		relDir   string
+		expected []string
+	}{
+		{relDir: ".", expected: nil},
+		{relDir: "Ski trip", expected: []string{"Ski trip"}},
+		{relDir: filepath.Join("Wedding", "Ceremony"), expected: []string{"Wedding", "Ceremony"}},
+		{relDir: filepath.Join("DCIM", "100CANON"), expected: nil},
+		{relDir: filepath.Join("Holidays", "dcim", "101_PANA"), expected: []string{"Holidays"}},
+		{relDir: "2023", expected: []string{"2023"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.relDir, func(t *testing.T) {
+			if got := albumNames(tt.relDir); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewFileListSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	first := createMediaFile(t, tmpDir, "first.jpg", testDate)
+	second := createMediaFile(t, tmpDir, "second.jpg", testDate)
+
+	source, err := newFileListSource([]string{second, first})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{second, first}
+	if !reflect.DeepEqual(source.files, expected) {
+		t.Errorf("Expected the files in list order %v, got %v", expected, source.files)
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+	}{
+		{"empty list", nil},
+		{"missing file", []string{first, filepath.Join(tmpDir, "missing.jpg")}},
+		{"directory", []string{tmpDir}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newFileListSource(tt.files); err == nil {
+				t.Errorf("Expected error for files %v", tt.files)
+			}
+		})
+	}
+}
+
+func TestMediaParser_DiscoverFiles_FileList(t *testing.T) {
+	tmpDir := t.TempDir()
+	phone := createSubdir(t, tmpDir, "phone")
+	card := createSubdir(t, tmpDir, "card")
+	targetDir := createSubdir(t, tmpDir, "target")
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	fromCard := createMediaFile(t, card, "IMG_0001.jpg", testDate)
+	fromPhone := createMediaFile(t, phone, "IMG_0001.jpg", testDate)
+	notes := createMediaFile(t, phone, "notes.txt", testDate)
+	// Listed files are imported even where a .picsignore would skip them
+	writeIgnoreFile(t, phone, "IMG_0001.jpg\n")
+
+	source, err := newFileListSource([]string{fromCard, notes, fromPhone})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	parser := &mediaParser{extensions: NewExtensions()}
+	jobs := make(chan fileToProcess, 10)
+	parser.discoverFiles(testCtx, []parseSource{source}, targetDir, jobs)
+
+	var discovered []string
+	for job := range jobs {
+		discovered = append(discovered, filepath.Base(job.destPath))
+	}
+
+	expected := []string{"1-IMG_0001.jpg", "3-IMG_0001.jpg"}
+	if !reflect.DeepEqual(discovered, expected) {
+		t.Errorf("Expected %v, got %v", expected, discovered)
+	}
+}
+
+func discoveredFile(t *testing.T, srcPath, destPath string) fileToProcess {
+	t.Helper()
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", srcPath, err)
+	}
+	return fileToProcess{srcPath: srcPath, destPath: destPath, size: info.Size(), modTime: info.ModTime()}
+}
+
+func TestCopyUnchangedFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	src := createMediaFile(t, tmpDir, "IMG_0001.jpg", testDate)
+	dest := filepath.Join(tmpDir, "copy.jpg")
+
+	file := discoveredFile(t, src, dest)
+	if err := copyUnchangedFile(file, false); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertFileModTime(t, dest, testDate)
+
+	// A sync client appends to the file after discovery
+	if err := os.Remove(dest); err != nil {
+		t.Fatalf("Failed to remove copy: %v", err)
+	}
+	if err := os.WriteFile(src, []byte("test media content, and more"), 0644); err != nil {
+		t.Fatalf("Failed to grow file: %v", err)
+	}
+	if err := copyUnchangedFile(file, false); !errors.Is(err, errFileChanged) {
+		t.Errorf("Expected errFileChanged, got: %v", err)
+	}
+	assertMediaFileNotExists(t, dest)
+
+	// Same size, but written again
+	file = discoveredFile(t, src, dest)
+	if err := os.Chtimes(src, testDate, testDate.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	if err := copyUnchangedFile(file, false); !errors.Is(err, errFileChanged) {
+		t.Errorf("Expected errFileChanged for a new modification time, got: %v", err)
+	}
+}
+
+func TestCopyUnchangedFile_Verified(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	src := createMediaFile(t, tmpDir, "IMG_0001.jpg", testDate)
+	dest := filepath.Join(tmpDir, "copy.jpg")
+
+	if err := copyUnchangedFile(discoveredFile(t, src, dest), true); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertFileModTime(t, dest, testDate)
+	srcSum, _ := fileSHA256(src)
+	destSum, _ := fileSHA256(dest)
+	if !bytes.Equal(srcSum, destSum) {
+		t.Errorf("Expected identical copies, got %x and %x", srcSum, destSum)
+	}
+}
+
+func TestMediaParser_RetryChangedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	// finished.jpg was still being written when discovered, gone.jpg was deleted since
+	finished := createMediaFile(t, sourceDir, "finished.jpg", testDate)
+	gone := createMediaFile(t, sourceDir, "gone.jpg", testDate)
+	files := []fileToProcess{
+		{srcPath: finished, destPath: filepath.Join(targetDir, "root-finished.jpg"), size: 4, modTime: testDate.Add(-time.Minute)},
+		discoveredFile(t, gone, filepath.Join(targetDir, "root-gone.jpg")),
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	// Without exiftool the original name can't be stored, which doesn't stop the import
+	parser := &mediaParser{extensions: NewExtensions(), exifWriter: NewExifWriter(nil)}
+	var report ParseReport
+	if err := parser.retryChangedFiles(testCtx, files, testParseOptions, &report, newWatchdog(testCtx, 0), newTestJournal(t)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertMediaFileExists(t, filepath.Join(targetDir, "root-finished.jpg"))
+	if !reflect.DeepEqual(report.Changing, []string{gone}) {
+		t.Errorf("Expected %v to be skipped, got %v", []string{gone}, report.Changing)
+	}
+}
+
+func TestMediaParser_SkipsFilesBelowMinFileSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	writeSizedFile(t, sourceDir, "IMG_0001.jpg", 2048)
+	thumb := writeSizedFile(t, sourceDir, "thumb.jpg", 100)
+	cacheDir := createSubdir(t, sourceDir, "cache")
+	cached := writeSizedFile(t, cacheDir, "0001.jpg", 512)
+	for _, path := range []string{filepath.Join(sourceDir, "IMG_0001.jpg"), thumb, cached} {
+		if err := os.Chtimes(path, testDate, testDate); err != nil {
+			t.Fatalf("Failed to set file times: %v", err)
+		}
+	}
+
+	opts := testParseOptions
+	opts.MinFileSize = 1024
+	parser := &mediaParser{
+		organiser:  NewFileOrganiser(nil),
+		extensions: NewExtensions(),
+		stats:      NewFileStats(),
+		exifWriter: NewExifWriter(nil),
+	}
+	report, err := parser.Parse(testCtx, []string{sourceDir}, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{cached, thumb}
+	sort.Strings(report.TooSmall)
+	if !reflect.DeepEqual(report.TooSmall, expected) {
+		t.Errorf("Expected %v to be skipped, got %v", expected, report.TooSmall)
+	}
+
+	count, err := NewFileStats().GetFileCount(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to count target files: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 file imported, got %d", count)
+	}
+	if report.Imported != 1 || report.SourceBytes != 2048 || report.ImportedBytes != 2048 {
+		t.Errorf("Expected the report to count the 2048 byte file imported, got %+v", report)
+	}
+}
+
+func TestMediaParser_Parse_MoveFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	image := createMediaFile(t, sourceDir, "IMG_0001.jpg", testDate)
+	video := createMediaFile(t, createSubdir(t, sourceDir, "clips"), "MVI_0002.mp4", testDate)
+	notes := createMediaFile(t, sourceDir, "notes.txt", testDate)
+
+	opts := testParseOptions
+	opts.MoveFiles = true
+	opts.PruneSourceDirs = true
+	parser := &mediaParser{
+		organiser:  NewFileOrganiser(nil),
+		extensions: NewExtensions(),
+		stats:      NewFileStats(),
+		exifWriter: NewExifWriter(nil),
+	}
+	report, err := parser.Parse(testCtx, []string{sourceDir}, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Imported files are in the library and in the trash of the source, other files are left alone
+	expectedDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(expectedDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(expectedDir, "videos", "2023_06_June_15_00001.mp4"))
+	for _, path := range []string{image, video} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s moved out of the source, got %v", path, err)
+		}
+	}
+	assertMediaFileExists(t, notes)
+	trashes, err := filepath.Glob(filepath.Join(sourceDir, TrashDirName, "*"))
+	if err != nil || len(trashes) != 1 {
+		t.Fatalf("Expected a trash for the run, got %v (%v)", trashes, err)
+	}
+	assertMediaFileExists(t, filepath.Join(trashes[0], "IMG_0001.jpg"))
+	assertMediaFileExists(t, filepath.Join(trashes[0], "clips", "MVI_0002.mp4"))
+
+	sort.Strings(report.Moved)
+	if expected := []string{image, video}; !reflect.DeepEqual(report.Moved, expected) {
+		t.Errorf("Expected %v moved, got %v", expected, report.Moved)
+	}
+
+	// The emptied clips directory is pruned, the trash is kept
+	if _, err := os.Stat(filepath.Dir(video)); !os.IsNotExist(err) {
+		t.Errorf("Expected the emptied %s pruned, got %v", filepath.Dir(video), err)
+	}
+	expectedKept := []KeptDir{{Dir: filepath.Join(sourceDir, TrashDirName), Reason: KeptDirHidden}}
+	if report.Pruned != 1 || !reflect.DeepEqual(report.KeptDirs, expectedKept) {
+		t.Errorf("Expected 1 directory pruned and %+v kept, got %d and %+v", expectedKept, report.Pruned, report.KeptDirs)
+	}
+}
+
+func TestTrashImportedSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := createSubdir(t, tmpDir, "source")
+	listedDir := createSubdir(t, tmpDir, "listed")
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	imported := createMediaFile(t, createSubdir(t, sourceDir, "100APPLE"), "IMG_0001.jpg", testDate)
+	edited := createMediaFile(t, sourceDir, "IMG_0002.jpg", testDate)
+	listed := createMediaFile(t, listedDir, "IMG_0003.jpg", testDate)
+	files := []fileToProcess{
+		discoveredFile(t, imported, filepath.Join(tmpDir, "root-IMG_0001.jpg")),
+		discoveredFile(t, edited, filepath.Join(tmpDir, "root-IMG_0002.jpg")),
+		discoveredFile(t, listed, filepath.Join(tmpDir, "IMG_0003.jpg")),
+		{srcPath: filepath.Join(sourceDir, "gone.jpg")},
+	}
+
+	// A file edited since its copy was imported has changes the library doesn't have
+	if err := os.WriteFile(edited, []byte("edited after the copy"), 0644); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+
+	// A run of the trash older than the retention is emptied, a recent one is kept
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	expired := createSubdir(t, createSubdir(t, sourceDir, TrashDirName), "2023-11-01 10-00-00")
+	recent := createSubdir(t, filepath.Join(sourceDir, TrashDirName), "2023-12-20 10-00-00")
+	sources := []parseSource{{dir: sourceDir}, {}}
+	moved, size, trashes := trashImportedSources(sources, files, start, 30*24*time.Hour)
+	if !reflect.DeepEqual(moved, []string{imported, listed}) {
+		t.Errorf("Expected %s and %s moved, got %v", imported, listed, moved)
+	}
+	if want := files[0].size + files[2].size; size != want {
+		t.Errorf("Expected %d bytes moved, got %d", want, size)
+	}
+	if want := []string{listedDir, sourceDir}; !reflect.DeepEqual(trashes, want) {
+		t.Errorf("Expected the trashes of %v, got %v", want, trashes)
+	}
+	assertMediaFileExists(t, edited)
+	// Each file keeps its path within its source, or its name in the trash of its directory
+	assertMediaFileExists(t, filepath.Join(sourceDir, TrashDirName, "2024-01-02 03-04-05", "100APPLE", "IMG_0001.jpg"))
+	assertMediaFileExists(t, filepath.Join(listedDir, TrashDirName, "2024-01-02 03-04-05", "IMG_0003.jpg"))
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired run %s emptied, got %v", expired, err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("Expected the recent run %s kept: %v", recent, err)
+	}
+}
+
+func TestMediaParser_Parse_InvalidJPEGQuality(t *testing.T) {
+	sourceDir, targetDir := createSourceAndTarget(t, t.TempDir())
+	parser := &mediaParser{organiser: NewFileOrganiser(nil), extensions: NewExtensions(), stats: NewFileStats()}
+
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.JPEGQuality = 150
+	if _, err := parser.Parse(testCtx, []string{sourceDir}, targetDir, opts); err == nil {
+		t.Error("Expected an error for a JPEG quality above 100")
+	}
+
+	// The quality is unused when compressing to a target size
+	opts.JPEGTargetSize = 1 << 20
+	if _, err := parser.Parse(testCtx, []string{sourceDir}, targetDir, opts); err != nil {
+		t.Errorf("Expected no error with a target size, got: %v", err)
+	}
+}
+
+func TestMediaParser_CopyAndCompressFiles_Timeout(t *testing.T) {
+	sourceDir, _ := createSourceAndTarget(t, t.TempDir())
+	writeSizedFile(t, sourceDir, "IMG_0001.jpg", 16)
+	sources, err := newParseSources([]string{sourceDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := &mediaParser{extensions: NewExtensions(), stats: NewFileStats()}
+
+	// The run is out of time before any file is copied
+	ctx, cancel := context.WithTimeout(testCtx, 0)
+	defer cancel()
+	opts := testParseOptions
+	opts.Timeout = time.Nanosecond
+	_, err = parser.copyAndCompressFiles(ctx, sources, t.TempDir(), opts, newTestJournal(t))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline reported, got %v", err)
+	}
+}
+
+func TestMediaParser_Parse_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	sessionsDir := filepath.Join(targetDir, ParseSessionsDirName)
+
+	// Cancelled while copying, the session is kept for --resume
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	parser := NewMediaParser("", "", NewFileOrganiser(nil), NewExifWriter(nil), nil)
+	opts := testParseOptions
+	opts.OnOrganising = func() { t.Error("Expected no organising after the cancellation") }
+	if _, err := parser.Parse(ctx, []string{sourceDir}, targetDir, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation reported, got: %v", err)
+	}
+	entries, err := os.ReadDir(sessionsDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the session kept, got %d entries (%v)", len(entries), err)
+	}
+	if session, err := loadSession(filepath.Join(sessionsDir, entries[0].Name())); err != nil || !session.Resumable() {
+		t.Errorf("Expected a resumable session, got %+v, %v", session, err)
+	}
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 1 {
+		t.Errorf("Expected the library left untouched but for the session, got %d entries", len(entries))
+	}
+}
+
+// cancellingOrganiser cancels the parse as it starts organising, moving nothing
+type cancellingOrganiser struct {
+	FileOrganiser
+	cancel context.CancelFunc
+}
+
+func (o *cancellingOrganiser) OrganiseByDate(ctx context.Context, sourceDir, targetDir string, opts OrganiseOptions) ([]ReviewFile, error) {
+	o.cancel()
+	return nil, ctx.Err()
+}
+
+func TestMediaParser_Parse_CancelledWhileOrganising(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	sessionsDir := filepath.Join(targetDir, ParseSessionsDirName)
+
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	parser := &mediaParser{
+		organiser:  &cancellingOrganiser{cancel: cancel},
+		extensions: NewExtensions(),
+		stats:      NewFileStats(),
+		exifWriter: NewExifWriter(nil),
+	}
+	opts := testParseOptions
+	organising := false
+	opts.OnOrganising = func() { organising = true }
+	if _, err := parser.Parse(ctx, []string{sourceDir}, targetDir, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation reported, got: %v", err)
+	}
+	if !organising {
+		t.Error("Expected OnOrganising called once the files were imported")
+	}
+
+	// The files not yet organised are kept for adoption
+	entries, err := os.ReadDir(sessionsDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the session kept, got %d entries (%v)", len(entries), err)
+	}
+	session, err := loadSession(filepath.Join(sessionsDir, entries[0].Name()))
+	if err != nil || !session.Adoptable() {
+		t.Errorf("Expected an adoptable session, got %+v, %v", session, err)
+	}
+	assertMediaFileExists(t, filepath.Join(session.Dir, sessionFilesDirName, "root-IMG_0001.jpg"))
+}
+
+func TestMediaParser_CopyAndCompressFiles_ManyFailures(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := createSubdir(t, tmpDir, "source")
+	for i := range 200 {
+		writeSizedFile(t, sourceDir, fmt.Sprintf("IMG_%04d.jpg", i), 16)
+	}
+	// Every copy fails, since the temporary target is a file rather than a directory
+	tmpTarget := writeSizedFile(t, tmpDir, "target", 0)
+
+	sources, err := newParseSources([]string{sourceDir})
+	if err != nil {
+		t.Fatalf("newParseSources failed: %v", err)
+	}
+	opts := testParseOptions
+	opts.MaxConcurrency = 2
+	parser := &mediaParser{extensions: NewExtensions(), stats: NewFileStats()}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := parser.copyAndCompressFiles(testCtx, sources, tmpTarget, opts, newTestJournal(t))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the failed copies to be reported")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Workers blocked on more failures than workers")
+	}
+}
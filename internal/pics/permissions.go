@@ -0,0 +1,12 @@
+package pics
+
+import "os"
+
+// Files and directories pics creates in a library get these permissions, with the process
+// umask applied as for any new file. Permissions of source files and archive entries are not
+// carried over, so a library looks the same whether it was parsed, renamed or restored.
+// Modification times are always carried over, as they are the fallback capture date.
+const (
+	libraryFileMode os.FileMode = 0644
+	libraryDirMode  os.FileMode = 0755
+)
@@ -0,0 +1,83 @@
+//go:build !windows
+
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func assertMode(t *testing.T, path string, expected os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	if info.Mode().Perm() != expected {
+		t.Errorf("Expected %s to have mode %v, got %v", path, expected, info.Mode().Perm())
+	}
+}
+
+func TestExtractTarGz_NormalisesPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	// Files backed up from a FAT card often come out executable, private directories closed
+	writeTestArchive(t, archivePath, 0700, 0755)
+
+	oldMask := syscall.Umask(0022)
+	defer syscall.Umask(oldMask)
+
+	targetDir := filepath.Join(tmpDir, "restored")
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if err := backup.extractTarGz(testCtx, archivePath, targetDir, nil, 0, nil, ""); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertMode(t, filepath.Join(targetDir, "album"), 0755)
+	assertMode(t, filepath.Join(targetDir, "album", "photo.jpg"), 0644)
+	assertFileModTime(t, filepath.Join(targetDir, "album", "photo.jpg"), testArchiveModTime)
+}
+
+func TestCopyFilePreserveTime_NormalisesPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	oldMask := syscall.Umask(0022)
+	defer syscall.Umask(oldMask)
+
+	for _, mode := range []os.FileMode{0600, 0755, 0444} {
+		src := createMediaFile(t, tmpDir, "IMG_0001.jpg", modTime)
+		if err := os.Chmod(src, mode); err != nil {
+			t.Fatalf("Failed to change mode: %v", err)
+		}
+
+		dst := filepath.Join(tmpDir, "copy.jpg")
+		if err := copyFilePreserveTime(src, dst); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		assertMode(t, dst, 0644)
+		assertFileModTime(t, dst, modTime)
+
+		os.Remove(src)
+		os.Remove(dst)
+	}
+}
+
+func TestMergeDirectory_NewDirectoriesUseLibraryMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "incoming")
+	dst := filepath.Join(tmpDir, "library")
+	writeContentFile(t, dst, "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(src, "videos"), "2023_06_June_15_00001.mov", "waves")
+
+	oldMask := syscall.Umask(0022)
+	defer syscall.Umask(oldMask)
+
+	if _, err := mergeDirectory(src, dst); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertMode(t, filepath.Join(dst, "videos"), 0755)
+}
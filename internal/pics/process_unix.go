@@ -0,0 +1,18 @@
+//go:build !windows
+
+package pics
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process with the given ID exists. Signal 0 checks the
+// process without signalling it; EPERM means it exists but belongs to another user.
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package pics
+
+import "os"
+
+// processRunning reports whether a process with the given ID exists. On Windows FindProcess
+// opens the process, which fails for processes that no longer exist.
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,102 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// Reasons ParseOptions.PruneSourceDirs keeps a source directory
+const (
+	// KeptDirHidden is a hidden directory, such as the trash, which parse never looks into
+	KeptDirHidden = "hidden"
+	// KeptDirIgnored is a directory excluded by a .picsignore file
+	KeptDirIgnored = "ignored"
+	// KeptDirFiles is a directory still holding files parse didn't move, such as skipped,
+	// unsupported, ignored or changed ones
+	KeptDirFiles = "files"
+	// KeptDirHiddenFiles is a directory holding nothing but hidden files, such as .DS_Store or
+	// .picsignore, which parse leaves alone
+	KeptDirHiddenFiles = "hidden files"
+	// KeptDirFailed is a directory that couldn't be read or removed
+	KeptDirFailed = "failed"
+)
+
+// KeptDir is a source directory pruning left in place
+type KeptDir struct {
+	// Dir is the directory
+	Dir string
+	// Reason is why it was kept, one of the KeptDir constants
+	Reason string
+}
+
+// pruneSourceDirs removes the subdirectories of the source directories that hold nothing once
+// their files are moved, deepest first, and returns how many it removed and those it kept.
+// Source directories themselves are never removed, nor are hidden directories and directories
+// excluded by .picsignore, which parse doesn't look into. A directory kept only because of the
+// directories in it isn't listed, as those are.
+func pruneSourceDirs(sources []parseSource) (int, []KeptDir) {
+	var removed int
+	var kept []KeptDir
+	for _, source := range sources {
+		if source.dir == "" {
+			continue
+		}
+		pruneDir(source, source.dir, &removed, &kept)
+	}
+	logger.Info("Pruned emptied source directories", "removed", removed, "kept", len(kept))
+	return removed, kept
+}
+
+// pruneDir prunes the subdirectories of dir, then dir itself unless it is the source directory,
+// and reports whether dir is gone
+func pruneDir(source parseSource, dir string, removed *int, kept *[]KeptDir) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("Failed to read source directory to prune it", "dir", dir, "error", err)
+		*kept = append(*kept, KeptDir{Dir: dir, Reason: KeptDirFailed})
+		return false
+	}
+	var files, hiddenFiles, subdirs bool
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		hidden := strings.HasPrefix(entry.Name(), ".")
+		switch {
+		case !entry.IsDir() && hidden:
+			hiddenFiles = true
+		case !entry.IsDir():
+			files = true
+		case hidden:
+			*kept = append(*kept, KeptDir{Dir: path, Reason: KeptDirHidden})
+			subdirs = true
+		case source.ignore.isIgnored(path, true):
+			*kept = append(*kept, KeptDir{Dir: path, Reason: KeptDirIgnored})
+			subdirs = true
+		case !pruneDir(source, path, removed, kept):
+			subdirs = true
+		}
+	}
+
+	switch {
+	case dir == source.dir:
+		return false
+	case files:
+		*kept = append(*kept, KeptDir{Dir: dir, Reason: KeptDirFiles})
+		return false
+	case hiddenFiles:
+		*kept = append(*kept, KeptDir{Dir: dir, Reason: KeptDirHiddenFiles})
+		return false
+	case subdirs:
+		return false
+	}
+	if err := os.Remove(dir); err != nil {
+		logger.Warn("Failed to remove emptied source directory", "dir", dir, "error", err)
+		*kept = append(*kept, KeptDir{Dir: dir, Reason: KeptDirFailed})
+		return false
+	}
+	logger.Debug("Removed emptied source directory", "dir", dir)
+	*removed++
+	return true
+}
@@ -0,0 +1,48 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPruneSourceDirs(t *testing.T) {
+	sourceDir := t.TempDir()
+	// Emptied by the move, with an emptied subdirectory
+	emptied := createSubdir(t, createSubdir(t, sourceDir, "DCIM"), "100APPLE")
+	// A file parse didn't import, and one only holding hidden files
+	writeContentFile(t, filepath.Join(sourceDir, "MISC"), "notes.txt", "not media")
+	writeContentFile(t, filepath.Join(sourceDir, "101APPLE"), ".DS_Store", "finder")
+	// Directories parse doesn't look into
+	createSubdir(t, filepath.Join(sourceDir, TrashDirName), "2024-01-02 03-04-05")
+	createSubdir(t, sourceDir, "thumbnails")
+	writeContentFile(t, sourceDir, IgnoreFileName, "thumbnails/\n")
+
+	sources, err := newParseSources([]string{sourceDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file list has no directories of its own to prune
+	sources = append(sources, parseSource{files: []string{filepath.Join(sourceDir, "MISC", "notes.txt")}})
+
+	removed, kept := pruneSourceDirs(sources)
+	if removed != 2 {
+		t.Errorf("Expected DCIM and 100APPLE removed, got %d removed", removed)
+	}
+	expected := []KeptDir{
+		{Dir: filepath.Join(sourceDir, TrashDirName), Reason: KeptDirHidden},
+		{Dir: filepath.Join(sourceDir, "101APPLE"), Reason: KeptDirHiddenFiles},
+		{Dir: filepath.Join(sourceDir, "MISC"), Reason: KeptDirFiles},
+		{Dir: filepath.Join(sourceDir, "thumbnails"), Reason: KeptDirIgnored},
+	}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected %+v kept, got %+v", expected, kept)
+	}
+	if _, err := os.Stat(filepath.Dir(emptied)); !os.IsNotExist(err) {
+		t.Errorf("Expected the emptied directories removed, got %v", err)
+	}
+	if got := listDir(t, sourceDir); !reflect.DeepEqual(got, []string{TrashDirName, IgnoreFileName, "101APPLE", "MISC", "thumbnails"}) {
+		t.Errorf("Expected the source directory kept with what it still holds, got %v", got)
+	}
+}
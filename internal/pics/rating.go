@@ -0,0 +1,196 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// ratingFields are the metadata fields a rating is read from. EXIF and XMP store 0 to 5 stars,
+// or -1 for a rejected file, as Rating; Windows also writes RatingPercent.
+var ratingFields = []string{"Rating", "RatingPercent"}
+
+// FavouriteRating is the rating a file needs to count as a favourite. EXIF and XMP have no
+// favourite flag, so tools that mark favourites write them as five stars.
+const FavouriteRating = 5
+
+// RatingFilter selects files by their star rating
+type RatingFilter struct {
+	// Op compares the rating of a file with Stars: "=", ">=", "<=", ">" or "<"
+	Op string
+	// Stars is the rating files are compared with
+	Stars int
+}
+
+// ratingOps are the comparisons of a RatingFilter, longest first so ">=" isn't read as ">"
+var ratingOps = []string{">=", "<=", ">", "<", "="}
+
+// ParseRatingFilter parses a filter such as ">=4", "<=2", "5" (exactly five stars) or "4+",
+// which is the same as ">=4" without characters the shell would take for a redirection
+func ParseRatingFilter(s string) (RatingFilter, error) {
+	value := strings.TrimSpace(s)
+	filter := RatingFilter{Op: "="}
+	if strings.HasSuffix(value, "+") {
+		filter.Op, value = ">=", strings.TrimSuffix(value, "+")
+	} else {
+		for _, op := range ratingOps {
+			if strings.HasPrefix(value, op) {
+				filter.Op, value = op, strings.TrimPrefix(value, op)
+				break
+			}
+		}
+	}
+
+	stars, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || stars < -1 || stars > 5 {
+		return RatingFilter{}, fmt.Errorf("invalid rating filter: %s", s)
+	}
+	filter.Stars = stars
+	return filter, nil
+}
+
+// Matches reports whether a file with the given rating passes the filter
+func (f RatingFilter) Matches(rating int) bool {
+	switch f.Op {
+	case ">=":
+		return rating >= f.Stars
+	case "<=":
+		return rating <= f.Stars
+	case ">":
+		return rating > f.Stars
+	case "<":
+		return rating < f.Stars
+	}
+	return rating == f.Stars
+}
+
+// RatedFile is a file of the library and its rating
+type RatedFile struct {
+	// Path is the path of the file relative to the library
+	Path string
+	// Rating is the star rating of the file: 0 if unrated, -1 if rejected
+	Rating int
+}
+
+// RatingSearch finds the files of a library by the ratings given to them in other tools
+type RatingSearch interface {
+	// Search returns the media files of libraryDir whose rating passes filter, sorted by path
+	Search(libraryDir string, filter RatingFilter) ([]RatedFile, error)
+	// Export copies files found in libraryDir to targetDir, keeping their paths within the library
+	Export(libraryDir string, files []RatedFile, targetDir string) error
+}
+
+// ratingSearch implements the RatingSearch interface
+type ratingSearch struct {
+	metadata   MetadataReader
+	extensions Extensions
+}
+
+// NewRatingSearch creates a new RatingSearch reading ratings with metadata
+func NewRatingSearch(metadata MetadataReader) RatingSearch {
+	return &ratingSearch{
+		metadata:   metadata,
+		extensions: NewExtensions(),
+	}
+}
+
+// Search returns the media files of libraryDir whose rating passes filter. Hidden files and
+// directories are skipped, as are files whose metadata can't be read.
+func (s *ratingSearch) Search(libraryDir string, filter RatingFilter) ([]RatedFile, error) {
+	if info, err := os.Stat(libraryDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a valid directory: %s", libraryDir)
+	}
+
+	var files []RatedFile
+	err := filepath.Walk(libraryDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != libraryDir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !s.extensions.IsSupported(path) {
+			return nil
+		}
+
+		values, err := s.metadata.ReadFields(path, ratingFields)
+		if err != nil {
+			logger.Warn("Skipping file whose rating can't be read", "file", path, "error", err)
+			return nil
+		}
+		rating := ratingFromFields(values)
+		if !filter.Matches(rating) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(libraryDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, RatedFile{Path: relPath, Rating: rating})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", libraryDir, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// ratingFromFields returns the star rating held by the rating fields of a file, preferring
+// Rating over the RatingPercent Windows writes next to it
+func ratingFromFields(values map[string]string) int {
+	if val, ok := values["Rating"]; ok {
+		if rating, err := strconv.ParseFloat(val, 64); err == nil {
+			return max(-1, min(5, int(rating)))
+		}
+	}
+	if val, ok := values["RatingPercent"]; ok {
+		if percent, err := strconv.Atoi(val); err == nil {
+			// Windows stores 1, 2, 3, 4 and 5 stars as 1, 25, 50, 75 and 99 percent
+			switch {
+			case percent <= 0:
+				return 0
+			case percent < 25:
+				return 1
+			case percent < 50:
+				return 2
+			case percent < 75:
+				return 3
+			case percent < 99:
+				return 4
+			}
+			return 5
+		}
+	}
+	return 0
+}
+
+// Export copies files found in libraryDir to targetDir, keeping their paths within the
+// library. targetDir may not be inside the library, where the copies would be backed up too.
+func (s *ratingSearch) Export(libraryDir string, files []RatedFile, targetDir string) error {
+	if isSameOrNested(targetDir, libraryDir) {
+		return fmt.Errorf("export directory %s is inside the library %s", targetDir, libraryDir)
+	}
+
+	for _, file := range files {
+		dst := filepath.Join(targetDir, file.Path)
+		if err := os.MkdirAll(filepath.Dir(dst), libraryDirMode); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := copyFilePreserveTime(filepath.Join(libraryDir, file.Path), dst); err != nil {
+			return fmt.Errorf("failed to export %s: %w", file.Path, err)
+		}
+		logger.Debug("Exported file", "file", file.Path, "rating", file.Rating)
+	}
+	return nil
+}
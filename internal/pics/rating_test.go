@@ -0,0 +1,152 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRatingFilter(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected RatingFilter
+	}{
+		{input: ">=4", expected: RatingFilter{Op: ">=", Stars: 4}},
+		{input: "4+", expected: RatingFilter{Op: ">=", Stars: 4}},
+		{input: "<=2", expected: RatingFilter{Op: "<=", Stars: 2}},
+		{input: ">3", expected: RatingFilter{Op: ">", Stars: 3}},
+		{input: "<1", expected: RatingFilter{Op: "<", Stars: 1}},
+		{input: "=5", expected: RatingFilter{Op: "=", Stars: 5}},
+		{input: " 5 ", expected: RatingFilter{Op: "=", Stars: 5}},
+		{input: "-1", expected: RatingFilter{Op: "=", Stars: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			filter, err := ParseRatingFilter(tt.input)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if filter != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, filter)
+			}
+		})
+	}
+}
+
+func TestParseRatingFilter_Invalid(t *testing.T) {
+	for _, input := range []string{"", ">=", "6", "-2", "four", "=>4", "4++"} {
+		if _, err := ParseRatingFilter(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestRatingFilter_Matches(t *testing.T) {
+	atLeastFour := RatingFilter{Op: ">=", Stars: 4}
+	for rating, expected := range map[int]bool{-1: false, 0: false, 3: false, 4: true, 5: true} {
+		if got := atLeastFour.Matches(rating); got != expected {
+			t.Errorf("Rating %d: expected %v, got %v", rating, expected, got)
+		}
+	}
+	if !(RatingFilter{Op: "=", Stars: -1}).Matches(-1) {
+		t.Error("Expected a rejected file to match a filter for rejected files")
+	}
+}
+
+func TestRatingFromFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		expected int
+	}{
+		{name: "unrated", values: map[string]string{}, expected: 0},
+		{name: "stars", values: map[string]string{"Rating": "4"}, expected: 4},
+		{name: "rejected", values: map[string]string{"Rating": "-1"}, expected: -1},
+		{name: "out of range", values: map[string]string{"Rating": "7"}, expected: 5},
+		{name: "Windows percent", values: map[string]string{"RatingPercent": "75"}, expected: 4},
+		{name: "Windows one star", values: map[string]string{"RatingPercent": "1"}, expected: 1},
+		{name: "rating wins over percent", values: map[string]string{"Rating": "2", "RatingPercent": "99"}, expected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ratingFromFields(tt.values); got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// createRatedLibrary creates a library whose files are rated through fake metadata
+func createRatedLibrary(t *testing.T) (string, *fakeMetadata) {
+	t.Helper()
+	libraryDir := t.TempDir()
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	dayDir := createSubdir(t, libraryDir, "2023 06 June 15")
+	videosDir := createSubdir(t, dayDir, "videos")
+	hiddenDir := createSubdir(t, libraryDir, ".pics-merge-1")
+
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		createMediaFile(t, dayDir, "2023_06_June_15_00001.jpg", testDate):    {"Rating": "5"},
+		createMediaFile(t, dayDir, "2023_06_June_15_00002.jpg", testDate):    {"Rating": "3"},
+		createMediaFile(t, dayDir, "2023_06_June_15_00003.jpg", testDate):    {},
+		createMediaFile(t, videosDir, "2023_06_June_15_00001.mov", testDate): {"RatingPercent": "75"},
+		createMediaFile(t, hiddenDir, "2023_06_June_15_00001.jpg", testDate): {"Rating": "5"},
+		createMediaFile(t, dayDir, "notes.txt", testDate):                    {"Rating": "5"},
+	}}
+	return libraryDir, metadata
+}
+
+func TestRatingSearch_Search(t *testing.T) {
+	libraryDir, metadata := createRatedLibrary(t)
+
+	files, err := NewRatingSearch(metadata).Search(libraryDir, RatingFilter{Op: ">=", Stars: 4})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []RatedFile{
+		{Path: filepath.Join("2023 06 June 15", "2023_06_June_15_00001.jpg"), Rating: 5},
+		{Path: filepath.Join("2023 06 June 15", "videos", "2023_06_June_15_00001.mov"), Rating: 4},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
+
+func TestRatingSearch_Search_InvalidDirectory(t *testing.T) {
+	search := NewRatingSearch(&fakeMetadata{})
+	if _, err := search.Search(filepath.Join(t.TempDir(), "missing"), RatingFilter{Op: "=", Stars: 5}); err == nil {
+		t.Error("Expected error for a missing library")
+	}
+}
+
+func TestRatingSearch_Export(t *testing.T) {
+	libraryDir, metadata := createRatedLibrary(t)
+	search := NewRatingSearch(metadata)
+	files, err := search.Search(libraryDir, RatingFilter{Op: ">=", Stars: FavouriteRating})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	exportDir := filepath.Join(t.TempDir(), "favourites")
+	if err := search.Export(libraryDir, files, exportDir); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	exported := filepath.Join(exportDir, "2023 06 June 15", "2023_06_June_15_00001.jpg")
+	assertMediaFileExists(t, exported)
+	assertFileModTime(t, exported, time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	assertMediaFileNotExists(t, filepath.Join(exportDir, "2023 06 June 15", "2023_06_June_15_00002.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(exportDir, "2023 06 June 15", "videos"))
+}
+
+func TestRatingSearch_Export_InsideLibrary(t *testing.T) {
+	libraryDir := t.TempDir()
+	err := NewRatingSearch(&fakeMetadata{}).Export(libraryDir, nil, filepath.Join(libraryDir, "favourites"))
+	if err == nil {
+		t.Error("Expected error for an export directory inside the library")
+	}
+}
@@ -0,0 +1,112 @@
+package pics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// renameJournalName is the file a renamed directory records its renames in, so they can be undone
+const renameJournalName = ".pics-rename-journal.json"
+
+// renameJournal records the renames of a directory, the latest last. A rename is removed from it
+// once it is undone, and the journal with it when no rename is left.
+type renameJournal struct {
+	Renames []directoryRename `json:"renames"`
+
+	dirPath string
+}
+
+// directoryRename records a rename of a directory and of the files inside it
+type directoryRename struct {
+	// Time is when the directory was renamed
+	Time time.Time `json:"time"`
+	// From is the name of the directory before the rename
+	From string `json:"from"`
+	// To is its name after
+	To string `json:"to"`
+	// Files are the files renamed, relative to the directory and with / separators
+	Files []journaledFile `json:"files,omitempty"`
+}
+
+// journaledFile records the rename of a file of a directory
+type journaledFile struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// loadRenameJournal reads the rename journal of dirPath, empty if the directory has none
+func loadRenameJournal(dirPath string) (*renameJournal, error) {
+	journal := &renameJournal{dirPath: dirPath}
+	data, err := os.ReadFile(filepath.Join(dirPath, renameJournalName))
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dirPath, renameJournalName), err)
+	}
+	return journal, nil
+}
+
+// last returns the latest rename of the directory, or nil if there is none
+func (j *renameJournal) last() *directoryRename {
+	if len(j.Renames) == 0 {
+		return nil
+	}
+	return &j.Renames[len(j.Renames)-1]
+}
+
+// save writes the journal to its directory, replacing the previous one at once, or removes it
+// when no rename is left
+func (j *renameJournal) save() error {
+	path := filepath.Join(j.dirPath, renameJournalName)
+	if len(j.Renames) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(j.dirPath, renameJournalName, data)
+}
+
+// record appends the rename of the directory absDir to newDirPath, and of its files, to the
+// journal, which is saved in the renamed directory. Renames that changed nothing aren't recorded.
+func (j *renameJournal) record(absDir, newDirPath string, renames []fileRename) error {
+	entry := directoryRename{
+		Time: time.Now().UTC(),
+		From: filepath.Base(absDir),
+		To:   filepath.Base(newDirPath),
+	}
+	for _, rename := range renames {
+		from, err := filepath.Rel(absDir, rename.from)
+		if err != nil {
+			return err
+		}
+		to, err := filepath.Rel(absDir, rename.to)
+		if err != nil {
+			return err
+		}
+		if from != to {
+			entry.Files = append(entry.Files, journaledFile{From: filepath.ToSlash(from), To: filepath.ToSlash(to)})
+		}
+	}
+	if entry.From == entry.To && len(entry.Files) == 0 {
+		return nil
+	}
+
+	j.dirPath = newDirPath
+	j.Renames = append(j.Renames, entry)
+	if err := j.save(); err != nil {
+		return fmt.Errorf("files renamed but the renames couldn't be recorded to undo them: %w", err)
+	}
+	return nil
+}
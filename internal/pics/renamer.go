@@ -1,13 +1,17 @@
 package pics
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/acm19/pics/internal/i18n"
 	"github.com/acm19/pics/internal/logger"
 	"github.com/barasher/go-exiftool"
 )
@@ -21,33 +25,41 @@ type FileRenamer interface {
 	//
 	// Files are renamed in place with sequential numbering: {baseName}_00001.ext, {baseName}_00002.ext, etc.
 	// Only files matching the filter are renamed. Files are sorted by date (EXIF or modification time) first,
-	// then by filename if dates are equal, to ensure consistent chronological ordering. File extensions are
-	// normalised to lowercase.
+	// then as order decides if dates are equal, to ensure consistent chronological ordering. File extensions
+	// are normalised to lowercase.
 	//
 	// Before renaming, the original filename is stored in the EXIF OriginalFileName field if it doesn't already
 	// exist. This allows tracking of the original filename through subsequent renames.
 	//
+	// Files are renamed through temporary names first, so renumbering never overwrites a file. If a final name
+	// is taken by a file that isn't renamed, such as an empty file, nothing is renamed and an error is returned.
+	//
 	// Parameters:
 	//   - dir: The directory containing files to rename
 	//   - baseName: The base name to use for renamed files (e.g., "vacation" produces "vacation_00001.jpg")
 	//   - filter: A function that determines which files should be renamed
+	//   - order: The order files of the same date are numbered in
 	//   - progressChan: Optional channel for progress events
 	//
 	// Returns:
 	//   - int: The number of files that were renamed
 	//   - error: An error if the directory cannot be read or files cannot be renamed
-	RenameFilesWithPattern(dir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error)
+	RenameFilesWithPattern(dir, baseName string, filter fileFilter, order SequenceOrder, progressChan chan<- ProgressEvent) (int, error)
 
 	// MoveAndRenameFilesWithPattern moves files to a target directory and renames them with sequential numbering.
 	//
 	// Files matching the filter are moved from sourceDir to targetDir and renamed with the pattern
 	// {baseName}_00001.ext, {baseName}_00002.ext, etc. Files are sorted by date (EXIF or modification time)
-	// first, then by filename if dates are equal, to ensure consistent chronological ordering. File extensions
-	// are normalised to lowercase.
+	// first, then as order decides if dates are equal, to ensure consistent chronological ordering. File
+	// extensions are normalised to lowercase.
 	//
 	// Before renaming, the original filename is stored in the EXIF OriginalFileName field if it doesn't already
 	// exist. This allows tracking of the original filename through subsequent renames.
 	//
+	// Files of the target directory that match the filter are numbered along with the moved ones, so moving
+	// files into a directory numbered already continues its sequence instead of overwriting its files. Final
+	// names taken by other files fail the move as they fail RenameFilesWithPattern.
+	//
 	// The target directory is created only if there are files to move. If no files match the filter,
 	// the target directory is not created and the method returns successfully.
 	//
@@ -56,58 +68,166 @@ type FileRenamer interface {
 	//   - targetDir: The directory where files will be moved (created if needed and files exist)
 	//   - baseName: The base name to use for renamed files
 	//   - filter: A function that determines which files should be moved and renamed
+	//   - order: The order files of the same date are numbered in
 	//   - progressChan: Optional channel for progress events
 	//
 	// Returns:
 	//   - int: The number of files that were moved and renamed
 	//   - error: An error if directories cannot be accessed or files cannot be moved
-	MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error)
+	MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, order SequenceOrder, progressChan chan<- ProgressEvent) (int, error)
 }
 
 // fileRenamer implements the FileRenamer interface
 type fileRenamer struct {
 	dateExtractor *AggregatedFileDateExtractor
 	exifWriter    ExifWriter
+	metadata      MetadataReader
+}
+
+// fileRename is a file renamed by a fileRenamer
+type fileRename struct {
+	// from is the path of the file before it was renamed
+	from string
+	// to is its path after
+	to string
 }
 
 // NewFileRenamer creates a new FileRenamer instance
 func NewFileRenamer(et *exiftool.Exiftool) FileRenamer {
+	return newFileRenamer(et, NewExifWriter(et))
+}
+
+// NewFileRenamerWithWriter creates a new FileRenamer writing OriginalFileName with exifWriter
+func NewFileRenamerWithWriter(et *exiftool.Exiftool, exifWriter ExifWriter) FileRenamer {
+	return newFileRenamer(et, exifWriter)
+}
+
+// newFileRenamer creates a fileRenamer, for callers that need the files it renames
+func newFileRenamer(et *exiftool.Exiftool, exifWriter ExifWriter) *fileRenamer {
 	return &fileRenamer{
 		dateExtractor: NewFileDateExtractor(et),
-		exifWriter:    NewExifWriter(et),
+		exifWriter:    exifWriter,
+		metadata:      NewMetadataReader(et),
 	}
 }
 
 // RenameFilesWithPattern renames files in a directory based on a filter and naming pattern
-func (r *fileRenamer) RenameFilesWithPattern(dir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error) {
-	return r.renameFilesWithPatternInDir(dir, dir, baseName, filter, progressChan)
+func (r *fileRenamer) RenameFilesWithPattern(dir, baseName string, filter fileFilter, order SequenceOrder, progressChan chan<- ProgressEvent) (int, error) {
+	renames, err := r.renameFilesWithPatternInDir(dir, dir, baseName, filter, order, progressChan)
+	return len(renames), err
 }
 
 // MoveAndRenameFilesWithPattern moves files to a target directory and renames them
-func (r *fileRenamer) MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error) {
-	return r.renameFilesWithPatternInDir(sourceDir, targetDir, baseName, filter, progressChan)
+func (r *fileRenamer) MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, order SequenceOrder, progressChan chan<- ProgressEvent) (int, error) {
+	renames, err := r.renameFilesWithPatternInDir(sourceDir, targetDir, baseName, filter, order, progressChan)
+	return len(renames), err
 }
 
-// fileWithDate holds a file path and its extracted date
-type fileWithDate struct {
-	path string
-	date time.Time
+// renameFilesWithPatternInDir is the internal implementation, returning the files renamed. Files of targetDir that match the
+// filter are numbered along with those moved from sourceDir, so moving files into a directory
+// numbered already continues its sequence instead of overwriting its files.
+func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName string, filter fileFilter, order SequenceOrder, progressChan chan<- ProgressEvent) ([]fileRename, error) {
+	filesWithDates, err := r.collectFiles(sourceDir, filter, order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	// Nothing to rename
+	if len(filesWithDates) == 0 {
+		return nil, nil
+	}
+
+	// Create target directory only if there are files to move
+	if sourceDir != targetDir {
+		if err := os.MkdirAll(targetDir, libraryDirMode); err != nil {
+			return nil, fmt.Errorf("failed to create target directory: %w", err)
+		}
+		existing, err := r.collectFiles(targetDir, filter, order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read target directory: %w", err)
+		}
+		filesWithDates = append(filesWithDates, existing...)
+	}
+
+	// Sort files by date (oldest first), then as order decides if dates are equal
+	sortForSequence(filesWithDates, order)
+
+	// Nothing is renamed if a final name is taken by a file left out of the sequence, such as an
+	// empty file or one of another kind
+	totalFiles := len(filesWithDates)
+	finalPaths := make([]string, totalFiles)
+	for i, fileData := range filesWithDates {
+		finalPaths[i] = filepath.Join(targetDir, sequenceFileName(baseName, i+1, fileData.path))
+	}
+	if err := checkRenameCollisions(filesWithDates, finalPaths); err != nil {
+		return nil, err
+	}
+
+	// Two-phase rename to avoid overwrites when reordering files. Temporary names are unique to
+	// the run, so those left by an interrupted run are numbered instead of overwritten.
+	tempPrefix := fmt.Sprintf(".tmp_rename_%s_", strconv.FormatInt(time.Now().UnixNano(), 36))
+	tempPaths := make([]string, totalFiles)
+
+	// Keep the names of the files as their OriginalFileName, in one exiftool run for them all
+	paths := make([]string, totalFiles)
+	for i, fileData := range filesWithDates {
+		paths[i] = fileData.path
+	}
+	if _, err := r.exifWriter.WriteOriginalFileNamesIfMissing(context.Background(), paths); err != nil {
+		logger.Warn("Failed to write OriginalFileName to EXIF", "dir", sourceDir, "error", err)
+	}
+
+	// Phase 1: Rename to temporary names
+	for i, fileData := range filesWithDates {
+		if progressChan != nil {
+			select {
+			case progressChan <- ProgressEvent{
+				Stage:   "renaming",
+				Current: i + 1,
+				Total:   totalFiles,
+				Message: i18n.T("progress.preparing", i+1, totalFiles),
+				File:    fileData.path,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "renaming")
+			}
+		}
+
+		tempName := fmt.Sprintf("%s%05d%s", tempPrefix, i, filepath.Ext(fileData.path))
+		tempPath := filepath.Join(targetDir, tempName)
+
+		if err := renameNoReplace(fileData.path, tempPath); err != nil {
+			return nil, fmt.Errorf("failed to rename %s to temp: %w", fileData.path, err)
+		}
+		tempPaths[i] = tempPath
+	}
+
+	// Phase 2: Rename from temporary to final names
+	renames := make([]fileRename, totalFiles)
+	for i, tempPath := range tempPaths {
+		if err := renameNoReplace(tempPath, finalPaths[i]); err != nil {
+			return nil, fmt.Errorf("failed to rename temp to %s, files not renamed yet are left as %s*: %w", finalPaths[i], tempPrefix, err)
+		}
+		renames[i] = fileRename{from: filesWithDates[i].path, to: finalPaths[i]}
+	}
+
+	return renames, nil
 }
 
-// renameFilesWithPatternInDir is the internal implementation
-func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error) {
-	entries, err := os.ReadDir(sourceDir)
+// collectFiles returns the valid files of dir that match the filter with their dates, and the
+// fields order needs. A missing dir has no files.
+func (r *fileRenamer) collectFiles(dir string, filter fileFilter, order SequenceOrder) ([]sequencedFile, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read directory: %w", err)
+		return nil, err
 	}
 
-	// Collect files matching the filter with their dates
-	var filesWithDates []fileWithDate
+	var files []sequencedFile
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		filePath := filepath.Join(sourceDir, entry.Name())
+		filePath := filepath.Join(dir, entry.Name())
 
 		// Skip invalid/corrupted files
 		if err := isValidFile(filePath); err != nil {
@@ -122,78 +242,97 @@ func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName
 				logger.Warn("Failed to extract date, using zero time", "file", filePath, "error", err)
 				date = time.Time{} // Use zero time as fallback
 			}
-			filesWithDates = append(filesWithDates, fileWithDate{
+			file := sequencedFile{
 				path: filePath,
 				date: date,
-			})
+			}
+			if order == SequenceByCapture {
+				readSequenceFields(r.metadata, &file)
+			}
+			files = append(files, file)
 		}
 	}
+	return files, nil
+}
 
-	// Nothing to rename
-	if len(filesWithDates) == 0 {
-		return 0, nil
-	}
+// sequenceFileName returns the name of the file at filePath numbered n with baseName, with its
+// extension in lowercase, e.g. "2023_06_June_15_00001.jpg"
+func sequenceFileName(baseName string, n int, filePath string) string {
+	return fmt.Sprintf("%s_%05d%s", baseName, n, strings.ToLower(filepath.Ext(filePath)))
+}
 
-	// Create target directory only if there are files to move
-	if sourceDir != targetDir {
-		if err := os.MkdirAll(targetDir, 0755); err != nil {
-			return 0, fmt.Errorf("failed to create target directory: %w", err)
+// checkRenameCollisions returns an error if any of finalPaths is taken by a file other than
+// those being renamed, which renaming would overwrite
+func checkRenameCollisions(files []sequencedFile, finalPaths []string) error {
+	// The files being renamed are read once, by size and modification time, so a taken name is
+	// only compared with the few that can be the same file
+	renamed := make(map[fileSignature][]os.FileInfo, len(files))
+	for _, file := range files {
+		if info, err := os.Lstat(file.path); err == nil {
+			signature := signatureOf(info)
+			renamed[signature] = append(renamed[signature], info)
 		}
 	}
 
-	// Sort files by date (oldest first), then by filename if dates are equal
-	sort.Slice(filesWithDates, func(i, j int) bool {
-		if filesWithDates[i].date.Equal(filesWithDates[j].date) {
-			// If dates are equal, sort by filename
-			return filesWithDates[i].path < filesWithDates[j].path
+	for _, finalPath := range finalPaths {
+		existing, err := os.Lstat(finalPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
 		}
-		return filesWithDates[i].date.Before(filesWithDates[j].date)
-	})
-
-	// Two-phase rename to avoid overwrites when reordering files
-	totalFiles := len(filesWithDates)
-	tempPaths := make([]string, totalFiles)
-
-	// Phase 1: Write EXIF and rename to temporary names
-	for i, fileData := range filesWithDates {
-		if progressChan != nil {
-			select {
-			case progressChan <- ProgressEvent{
-				Stage:   "renaming",
-				Current: i + 1,
-				Total:   totalFiles,
-				Message: fmt.Sprintf("Preparing file %d of %d", i+1, totalFiles),
-				File:    fileData.path,
-			}:
-			default:
-				logger.Debug("Progress event dropped (channel full)", "stage", "renaming")
-			}
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", finalPath, err)
 		}
-
-		originalName := filepath.Base(fileData.path)
-		if _, err := r.exifWriter.WriteOriginalFileNameIfMissing(fileData.path, originalName); err != nil {
-			logger.Warn("Failed to write OriginalFileName to EXIF", "file", fileData.path, "error", err)
+		// The name may differ in case only from a file being renamed, on file systems that
+		// ignore case
+		sameFile := func(info os.FileInfo) bool { return os.SameFile(info, existing) }
+		if !slices.ContainsFunc(renamed[signatureOf(existing)], sameFile) {
+			return fmt.Errorf("cannot number files as %s: %s exists and isn't one of the files being renamed", filepath.Base(finalPath), finalPath)
 		}
+	}
+	return nil
+}
 
-		tempName := fmt.Sprintf(".tmp_rename_%05d%s", i, filepath.Ext(fileData.path))
-		tempPath := filepath.Join(targetDir, tempName)
+// fileSignature is what two names of the same file share
+type fileSignature struct {
+	size    int64
+	modTime int64
+}
 
-		if err := os.Rename(fileData.path, tempPath); err != nil {
-			return 0, fmt.Errorf("failed to rename %s to temp: %w", fileData.path, err)
+// signatureOf returns the signature of the file info describes
+func signatureOf(info os.FileInfo) fileSignature {
+	return fileSignature{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// renameThroughTemp renames files through temporary names, so files that swap names are renamed
+// too, failing instead of overwriting any file. The temporary names start with ".tmp_<purpose>_".
+func renameThroughTemp(renames []fileRename, purpose string) error {
+	tempPrefix := fmt.Sprintf(".tmp_%s_%s_", purpose, strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	// Phase 1: Rename all files to temporary names to avoid conflicts
+	tempPaths := make([]string, len(renames))
+	for i, rename := range renames {
+		tempPath := filepath.Join(filepath.Dir(rename.from), fmt.Sprintf("%s%d", tempPrefix, i))
+		if err := renameNoReplace(rename.from, tempPath); err != nil {
+			return fmt.Errorf("failed to rename %s to temp: %w", rename.from, err)
 		}
 		tempPaths[i] = tempPath
 	}
 
 	// Phase 2: Rename from temporary to final names
 	for i, tempPath := range tempPaths {
-		ext := strings.ToLower(filepath.Ext(tempPath))
-		newFileName := fmt.Sprintf("%s_%05d%s", baseName, i+1, ext)
-		newFilePath := filepath.Join(targetDir, newFileName)
-
-		if err := os.Rename(tempPath, newFilePath); err != nil {
-			return 0, fmt.Errorf("failed to rename temp to %s: %w", newFilePath, err)
+		if err := renameNoReplace(tempPath, renames[i].to); err != nil {
+			return fmt.Errorf("failed to rename temp to %s, files not renamed yet are left as %s*: %w", renames[i].to, tempPrefix, err)
 		}
 	}
+	return nil
+}
 
-	return len(filesWithDates), nil
+// renameNoReplace renames src to dst, failing instead of overwriting a file at dst
+func renameNoReplace(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s exists already", dst)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Rename(src, dst)
 }
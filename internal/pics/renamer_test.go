@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -22,7 +24,7 @@ func TestFileRenamer_RenameFilesWithPattern(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	count, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, nil)
+	count, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -52,7 +54,7 @@ func TestFileRenamer_RenameFilesWithPattern_EmptyDirectory(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	count, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, nil)
+	count, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error for empty directory, got: %v", err)
@@ -76,7 +78,7 @@ func TestFileRenamer_RenameFilesWithPattern_NoMatchingFiles(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, nil)
+	_, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error when no matching files, got: %v", err)
@@ -103,7 +105,7 @@ func TestFileRenamer_RenameFilesWithPattern_SkipsDirectories(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, nil)
+	_, err := renamer.RenameFilesWithPattern(testDir, "test_prefix", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -130,7 +132,7 @@ func TestFileRenamer_RenameFilesWithPattern_SortedOrder(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.RenameFilesWithPattern(testDir, "sorted", ext.IsImage, nil)
+	_, err := renamer.RenameFilesWithPattern(testDir, "sorted", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -155,7 +157,7 @@ func TestFileRenamer_RenameFilesWithPattern_NormalisesExtensions(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.RenameFilesWithPattern(testDir, "normalised", ext.IsImage, nil)
+	_, err := renamer.RenameFilesWithPattern(testDir, "normalised", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -181,7 +183,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	count, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "vid_prefix", ext.IsVideo, nil)
+	count, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "vid_prefix", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -218,7 +220,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_NormalisesExtensions(t *testi
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "video", ext.IsVideo, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "video", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -240,7 +242,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_EmptySource(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "prefix", ext.IsVideo, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "prefix", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error for empty source, got: %v", err)
@@ -264,7 +266,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_NoMatchingFiles(t *testing.T)
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "prefix", ext.IsVideo, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "prefix", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error when no matching files, got: %v", err)
@@ -292,7 +294,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_InPlace(t *testing.T) {
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
 	// Move to same directory (rename in place)
-	_, err := renamer.MoveAndRenameFilesWithPattern(testDir, testDir, "video", ext.IsVideo, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern(testDir, testDir, "video", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -309,7 +311,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_NonexistentSource(t *testing.
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.MoveAndRenameFilesWithPattern("/nonexistent/source", targetDir, "prefix", ext.IsImage, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern("/nonexistent/source", targetDir, "prefix", ext.IsImage, SequenceByDate, nil)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent source directory")
@@ -331,7 +333,7 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_CreatesTargetOnlyWhenNeeded(t
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "vid", ext.IsVideo, nil)
+	_, err := renamer.MoveAndRenameFilesWithPattern(sourceDir, targetDir, "vid", ext.IsVideo, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -360,7 +362,7 @@ func TestFileRenamer_SortByDateThenFilename(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	count, err := renamer.RenameFilesWithPattern(testDir, "sorted", ext.IsImage, nil)
+	count, err := renamer.RenameFilesWithPattern(testDir, "sorted", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -402,7 +404,7 @@ func TestFileRenamer_NoOverwriteOnRename(t *testing.T) {
 
 	renamer := NewFileRenamer(createTestExiftool(t))
 	ext := NewExtensions()
-	count, err := renamer.RenameFilesWithPattern(testDir, "new", ext.IsImage, nil)
+	count, err := renamer.RenameFilesWithPattern(testDir, "new", ext.IsImage, SequenceByDate, nil)
 
 	if err != nil {
 		t.Fatalf("Rename failed: %v", err)
@@ -417,3 +419,108 @@ func TestFileRenamer_NoOverwriteOnRename(t *testing.T) {
 		t.Errorf("CRITICAL: Expected 5 files, got %d - files were overwritten!", len(entries))
 	}
 }
+
+// newModTimeRenamer creates a fileRenamer dating files by modification time, without exiftool
+func newModTimeRenamer() *fileRenamer {
+	return &fileRenamer{
+		dateExtractor: &AggregatedFileDateExtractor{extractors: []fileDateExtractor{newModTimeExtractor()}},
+		exifWriter:    NewExifWriter(nil),
+	}
+}
+
+func TestFileRenamer_MoveAndRenameFilesWithPattern_ContinuesTargetSequence(t *testing.T) {
+	dir := t.TempDir()
+	videosDir := filepath.Join(dir, "videos")
+	if err := os.Mkdir(videosDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createTestFileWithTime(t, videosDir, "clip_00001.mov", parseTime(t, "2023-06-15T10:00:00Z"))
+	createTestFileWithTime(t, videosDir, "clip_00002.mov", parseTime(t, "2023-06-15T12:00:00Z"))
+	createTestFileWithTime(t, dir, "new.MOV", parseTime(t, "2023-06-15T11:00:00Z"))
+
+	count, err := newModTimeRenamer().MoveAndRenameFilesWithPattern(dir, videosDir, "clip", NewExtensions().IsVideo, SequenceByDate, nil)
+	if err != nil {
+		t.Fatalf("MoveAndRenameFilesWithPattern failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 files numbered, got %d", count)
+	}
+	entries, _ := os.ReadDir(videosDir)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 videos, got %d - files were overwritten!", len(entries))
+	}
+	// The moved video was taken between the two already numbered
+	info, err := os.Stat(filepath.Join(videosDir, "clip_00002.mov"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(parseTime(t, "2023-06-15T11:00:00Z")) {
+		t.Errorf("Expected the moved video numbered 2, got one modified at %v", info.ModTime())
+	}
+}
+
+func TestFileRenamer_RenameFilesWithPattern_Collision(t *testing.T) {
+	dir := t.TempDir()
+	createTestFileWithTime(t, dir, "b.jpg", parseTime(t, "2023-06-15T10:00:00Z"))
+	createTestFileWithTime(t, dir, "a.jpg", parseTime(t, "2023-06-15T11:00:00Z"))
+	// An empty file is left out of the sequence, but has the name the second image would take
+	if err := os.WriteFile(filepath.Join(dir, "photo_00002.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newModTimeRenamer().RenameFilesWithPattern(dir, "photo", NewExtensions().IsImage, SequenceByDate, nil); err == nil {
+		t.Fatal("Expected an error for a final name taken by a file not being renamed")
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "photo_00002.jpg"} {
+		assertFileExists(t, filepath.Join(dir, name))
+	}
+}
+
+func TestCheckRenameCollisions(t *testing.T) {
+	dir := t.TempDir()
+	modTime := parseTime(t, "2023-06-15T10:00:00Z")
+	createTestFileWithTime(t, dir, "a.jpg", modTime)
+	createTestFileWithTime(t, dir, "b.jpg", modTime)
+	files := []sequencedFile{{path: filepath.Join(dir, "a.jpg")}, {path: filepath.Join(dir, "b.jpg")}}
+
+	// Names taken by the files being renamed are free, even under another name of the same
+	// file, as on file systems that ignore case
+	if err := os.Link(filepath.Join(dir, "b.jpg"), filepath.Join(dir, "photo_00002.jpg")); err != nil {
+		t.Skipf("Hard links unsupported: %v", err)
+	}
+	finalPaths := []string{filepath.Join(dir, "b.jpg"), filepath.Join(dir, "photo_00002.jpg")}
+	if err := checkRenameCollisions(files, finalPaths); err != nil {
+		t.Errorf("Expected no collision, got %v", err)
+	}
+
+	// A file of the same size and time that isn't being renamed is taken
+	createTestFileWithTime(t, dir, "photo_00003.jpg", modTime)
+	err := checkRenameCollisions(files, append(finalPaths, filepath.Join(dir, "photo_00003.jpg")))
+	if err == nil || !strings.Contains(err.Error(), "photo_00003.jpg exists") {
+		t.Errorf("Expected a collision with photo_00003.jpg, got %v", err)
+	}
+}
+
+func TestFileRenamer_RenameFilesWithPattern_HalfRenamed(t *testing.T) {
+	dir := t.TempDir()
+	// A run interrupted between its phases left a numbered file, a temporary one and an original
+	createTestFileWithTime(t, dir, "photo_00001.jpg", parseTime(t, "2023-06-15T12:00:00Z"))
+	createTestFileWithTime(t, dir, ".tmp_rename_00001.jpg", parseTime(t, "2023-06-15T11:00:00Z"))
+	createTestFileWithTime(t, dir, "IMG_0001.jpg", parseTime(t, "2023-06-15T10:00:00Z"))
+
+	count, err := newModTimeRenamer().RenameFilesWithPattern(dir, "photo", NewExtensions().IsImage, SequenceByDate, nil)
+	if err != nil {
+		t.Fatalf("RenameFilesWithPattern failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 files renamed, got %d", count)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"photo_00001.jpg", "photo_00002.jpg", "photo_00003.jpg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,118 @@
+package pics
+
+import "encoding/json"
+
+// The reports of parse, backup and restore are printed as JSON by --json for scripts. Their JSON
+// form is stable: fields are named in camelCase, sizes are in bytes, durations in seconds, and
+// lists are empty rather than null.
+
+// MarshalJSON writes a failure as its item and error message
+func (f ItemFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Item  string `json:"item"`
+		Error string `json:"error"`
+	}{f.Item, f.Err.Error()})
+}
+
+// MarshalJSON writes the result of a parse, with the bytes compression saved
+func (r ParseReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Imported        int          `json:"imported"`
+		SourceBytes     int64        `json:"sourceBytes"`
+		ImportedBytes   int64        `json:"importedBytes"`
+		SavedBytes      int64        `json:"savedBytes"`
+		Review          []ReviewFile `json:"review"`
+		ReviewDir       string       `json:"reviewDir,omitempty"`
+		Changing        []string     `json:"changing"`
+		TooSmall        []string     `json:"tooSmall"`
+		Moved           []string     `json:"moved"`
+		Located         int          `json:"located"`
+		Warnings        int          `json:"warnings"`
+		DurationSeconds float64      `json:"durationSeconds"`
+	}{
+		Imported:        r.Imported,
+		SourceBytes:     r.SourceBytes,
+		ImportedBytes:   r.ImportedBytes,
+		SavedBytes:      max(r.SourceBytes-r.ImportedBytes, 0),
+		Review:          nonNil(r.Review),
+		ReviewDir:       r.ReviewDir,
+		Changing:        nonNil(r.Changing),
+		TooSmall:        nonNil(r.TooSmall),
+		Moved:           nonNil(r.Moved),
+		Located:         r.Located,
+		Warnings:        r.Warnings,
+		DurationSeconds: r.Duration.Seconds(),
+	})
+}
+
+// MarshalJSON writes the result of a backup, archiving or upload
+func (r BackupReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Mode                 BackupMode    `json:"mode"`
+		Directories          int           `json:"directories"`
+		UploadedDirectories  int           `json:"uploadedDirectories"`
+		UnchangedDirectories int           `json:"unchangedDirectories"`
+		Uploaded             int           `json:"uploaded"`
+		UploadedBytes        int64         `json:"uploadedBytes"`
+		Existing             int           `json:"existing"`
+		ExistingBytes        int64         `json:"existingBytes"`
+		Staged               int           `json:"staged"`
+		StagingDir           string        `json:"stagingDir,omitempty"`
+		Failed               []ItemFailure `json:"failed"`
+		Warnings             int           `json:"warnings"`
+		DurationSeconds      float64       `json:"durationSeconds"`
+	}{
+		Mode:                 r.Mode,
+		Directories:          r.Directories,
+		UploadedDirectories:  r.UploadedDirectories,
+		UnchangedDirectories: r.UnchangedDirectories,
+		Uploaded:             r.Uploaded,
+		UploadedBytes:        r.UploadedBytes,
+		Existing:             r.Existing,
+		ExistingBytes:        r.ExistingBytes,
+		Staged:               r.Staged,
+		StagingDir:           r.StagingDir,
+		Failed:               nonNil(r.Failed),
+		Warnings:             r.Warnings,
+		DurationSeconds:      r.Duration.Seconds(),
+	})
+}
+
+// MarshalJSON writes the result of a restore
+func (r RestoreReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Restored        int           `json:"restored"`
+		Merged          int           `json:"merged"`
+		Added           int           `json:"added"`
+		Duplicates      int           `json:"duplicates"`
+		Replaced        int           `json:"replaced"`
+		Skipped         int           `json:"skipped"`
+		DownloadedBytes int64         `json:"downloadedBytes"`
+		Incomplete      []string      `json:"incomplete"`
+		Thawing         []string      `json:"thawing"`
+		Failed          []ItemFailure `json:"failed"`
+		Warnings        int           `json:"warnings"`
+		DurationSeconds float64       `json:"durationSeconds"`
+	}{
+		Restored:        r.Restored,
+		Merged:          r.Merged,
+		Added:           r.Added,
+		Duplicates:      r.Duplicates,
+		Replaced:        r.Replaced,
+		Skipped:         r.Skipped,
+		DownloadedBytes: r.DownloadedBytes,
+		Incomplete:      nonNil(r.Incomplete),
+		Thawing:         nonNil(r.Thawing),
+		Failed:          nonNil(r.Failed),
+		Warnings:        r.Warnings,
+		DurationSeconds: r.Duration.Seconds(),
+	})
+}
+
+// nonNil returns s, or an empty slice if s is nil, so it is written as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package pics
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decodeReport writes report as JSON and reads it back as a map, as a script would
+func decodeReport(t *testing.T, report any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	return decoded
+}
+
+func TestParseReport_MarshalJSON(t *testing.T) {
+	report := ParseReport{
+		Imported:      120,
+		SourceBytes:   3000,
+		ImportedBytes: 2000,
+		Review:        []ReviewFile{{Name: "IMG_0001.jpg", Reason: "no date"}},
+		Duration:      1500 * time.Millisecond,
+	}
+
+	decoded := decodeReport(t, report)
+	if decoded["imported"] != 120.0 || decoded["savedBytes"] != 1000.0 || decoded["durationSeconds"] != 1.5 {
+		t.Errorf("Unexpected counts: %v", decoded)
+	}
+	review, ok := decoded["review"].([]any)
+	if !ok || len(review) != 1 || review[0].(map[string]any)["name"] != "IMG_0001.jpg" {
+		t.Errorf("Unexpected review files: %v", decoded["review"])
+	}
+	// Empty lists are written as [] so scripts can iterate them
+	if !reflect.DeepEqual(decoded["changing"], []any{}) {
+		t.Errorf("Expected no changing files as [], got %v", decoded["changing"])
+	}
+}
+
+func TestBackupReport_MarshalJSON(t *testing.T) {
+	report := BackupReport{
+		Directories:   3,
+		Uploaded:      2,
+		UploadedBytes: 4096,
+		Failed:        []ItemFailure{{Item: "2023 06 June 15 Vacation", Err: errors.New("access denied")}},
+	}
+
+	decoded := decodeReport(t, report)
+	if decoded["directories"] != 3.0 || decoded["uploadedBytes"] != 4096.0 {
+		t.Errorf("Unexpected counts: %v", decoded)
+	}
+	want := []any{map[string]any{"item": "2023 06 June 15 Vacation", "error": "access denied"}}
+	if !reflect.DeepEqual(decoded["failed"], want) {
+		t.Errorf("Expected failures %v, got %v", want, decoded["failed"])
+	}
+}
+
+func TestRestoreReport_MarshalJSON(t *testing.T) {
+	decoded := decodeReport(t, RestoreReport{Restored: 2, DownloadedBytes: 512})
+	if decoded["restored"] != 2.0 || decoded["downloadedBytes"] != 512.0 {
+		t.Errorf("Unexpected counts: %v", decoded)
+	}
+	if !reflect.DeepEqual(decoded["failed"], []any{}) {
+		t.Errorf("Expected no failures as [], got %v", decoded["failed"])
+	}
+}
@@ -0,0 +1,107 @@
+package pics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// resizeQuality is the quality JPEGs downscaled on import are encoded at, high enough that the
+// compression after it decides how much of the image is kept
+const resizeQuality = 95
+
+// prepareCopy readies the copy of file for compression: it downscales JPEGs larger than
+// opts.MaxDimension, copying their metadata back from the source with their new pixel size
+func (p *mediaParser) prepareCopy(ctx context.Context, file fileToProcess, opts ParseOptions, log *logger.Logger) error {
+	if !file.isJPEG || opts.MaxDimension <= 0 {
+		return nil
+	}
+	size, err := resizeJPEG(file.destPath, opts.MaxDimension)
+	if err != nil {
+		log.Warn("Failed to resize image, continuing with the full size", "error", err)
+		return nil
+	}
+	if size == (image.Point{}) {
+		return nil
+	}
+	if err := p.exifWriter.CopyMetadata(ctx, file.srcPath, file.destPath, false); err != nil {
+		// Without its metadata the image would lose its date and orientation
+		log.Warn("Failed to copy metadata to the resized image, continuing with the full size", "error", err)
+		if err := copyUnchangedFile(file, opts.VerifyCopy || opts.MoveFiles); err != nil {
+			return fmt.Errorf("failed to copy %s again: %w", file.srcPath, err)
+		}
+		return nil
+	}
+	// Viewers sizing the image by its metadata would otherwise take it for the original
+	if _, err := p.exifWriter.WriteDimensions(ctx, file.destPath, size.X, size.Y); err != nil {
+		log.Warn("Failed to write the dimensions of the resized image", "error", err)
+	}
+	log.Debug("Resized image", "dest", file.destPath, "max_dimension", opts.MaxDimension, "width", size.X, "height", size.Y)
+	return nil
+}
+
+// resizeJPEG downscales the JPEG at path to fit within maxDimension pixels on its longest side,
+// keeping its aspect ratio and modification time, and replacing it at once. The encoder writes
+// no metadata, so the caller copies it back. It returns the new size of the image, or the zero
+// size if it fit already.
+func resizeJPEG(path string, maxDimension int) (image.Point, error) {
+	if maxDimension <= 0 {
+		return image.Point{}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return image.Point{}, err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer src.Close()
+
+	// Reading the dimensions alone keeps images that fit from being decoded
+	config, err := jpeg.DecodeConfig(bufio.NewReader(src))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("failed to read dimensions of %s: %w", path, err)
+	}
+	if config.Width <= maxDimension && config.Height <= maxDimension {
+		return image.Point{}, nil
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return image.Point{}, err
+	}
+	img, err := jpeg.Decode(bufio.NewReader(src))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pics-resize-*.jpg")
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer os.Remove(tmp.Name())
+	resized := downscale(img, maxDimension)
+	if err := jpeg.Encode(tmp, resized, &jpeg.Options{Quality: resizeQuality}); err != nil {
+		tmp.Close()
+		return image.Point{}, fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return image.Point{}, err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return image.Point{}, err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return image.Point{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return image.Point{}, err
+	}
+	return resized.Bounds().Size(), nil
+}
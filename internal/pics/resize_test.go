@@ -0,0 +1,127 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// writeTestJPEG writes a width by height JPEG to dir and returns its path
+func writeTestJPEG(t *testing.T, dir, name string, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// jpegDimensions returns the width and height of the JPEG at path
+func jpegDimensions(t *testing.T, path string) (int, int) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Width, config.Height
+}
+
+func TestResizeJPEG(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		width, height int
+		wantResized   bool
+		wantW, wantH  int
+	}{
+		{name: "landscape", width: 200, height: 100, wantResized: true, wantW: 80, wantH: 40},
+		{name: "portrait", width: 100, height: 200, wantResized: true, wantW: 40, wantH: 80},
+		{name: "fits", width: 80, height: 60, wantResized: false, wantW: 80, wantH: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestJPEG(t, dir, tt.name+".jpg", tt.width, tt.height)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			size, err := resizeJPEG(path, 80)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if resized := size != (image.Point{}); resized != tt.wantResized {
+				t.Errorf("Expected resized %v, got %v", tt.wantResized, resized)
+			}
+			if tt.wantResized && size != image.Pt(tt.wantW, tt.wantH) {
+				t.Errorf("Expected the new size %dx%d returned, got %v", tt.wantW, tt.wantH, size)
+			}
+			if w, h := jpegDimensions(t, path); w != tt.wantW || h != tt.wantH {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.wantW, tt.wantH, w, h)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected modification time %v kept, got %v", modTime, info.ModTime())
+			}
+		})
+	}
+
+	// No temporary files are left next to the images
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(tests) {
+		t.Errorf("Expected only the images left, got %d entries", len(entries))
+	}
+}
+
+func TestPrepareCopy_WritesNewDimensions(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestJPEG(t, dir, "IMG_0001.jpg", 200, 100)
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "copy.jpg")
+	if err := os.WriteFile(dest, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exifWriter := &copyingExifWriter{}
+	parser := &mediaParser{exifWriter: exifWriter}
+	opts := testParseOptions
+	opts.MaxDimension = 80
+	file := fileToProcess{srcPath: src, destPath: dest, isJPEG: true}
+	if err := parser.prepareCopy(context.Background(), file, opts, logger.With("file", src)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The metadata of the original is copied back, then given the size of the copy
+	if expected := [][2]string{{src, dest}}; !reflect.DeepEqual(exifWriter.copies, expected) {
+		t.Errorf("Expected metadata copied %v, got %v", expected, exifWriter.copies)
+	}
+	if expected := [][2]int{{80, 40}}; !reflect.DeepEqual(exifWriter.dimensions, expected) {
+		t.Errorf("Expected dimensions %v written, got %v", expected, exifWriter.dimensions)
+	}
+}
@@ -0,0 +1,261 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/barasher/go-exiftool"
+)
+
+// NameConflictPolicy decides what happens to a file whose original name is taken, by a file
+// that keeps its name or by another file with the same original name
+type NameConflictPolicy string
+
+const (
+	// NameConflictSkip leaves the file with its current name
+	NameConflictSkip NameConflictPolicy = "skip"
+	// NameConflictSuffix restores the original name with a number before the extension, e.g.
+	// "IMG_0001 (2).JPG"
+	NameConflictSuffix NameConflictPolicy = "suffix"
+)
+
+// ParseNameConflictPolicy parses "skip" or "suffix"
+func ParseNameConflictPolicy(s string) (NameConflictPolicy, error) {
+	switch policy := NameConflictPolicy(s); policy {
+	case NameConflictSkip, NameConflictSuffix:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid name conflict policy %q (expected skip or suffix)", s)
+}
+
+// RestoreNamesOptions holds configuration options for restoring original file names.
+type RestoreNamesOptions struct {
+	// OnConflict decides what happens to a file whose original name is taken.
+	OnConflict NameConflictPolicy
+	// DryRun reports the names that would be restored without renaming any file.
+	DryRun bool
+}
+
+// DefaultRestoreNamesOptions returns the default options for restoring original file names.
+func DefaultRestoreNamesOptions() RestoreNamesOptions {
+	return RestoreNamesOptions{
+		OnConflict: NameConflictSkip,
+		DryRun:     false,
+	}
+}
+
+// NameRestorer defines the interface for renaming files back to their original names
+type NameRestorer interface {
+	// RestoreNames renames the media files of directory and of its videos directory back to the
+	// name recorded in their OriginalFileName tag. The renames are recorded in the directory so
+	// "rename --undo" can number the files again.
+	RestoreNames(directory string, opts RestoreNamesOptions) (RestoreNamesReport, error)
+}
+
+// RestoreNamesReport lists the names restored in a directory
+type RestoreNamesReport struct {
+	// Restored lists the files renamed back, sorted by path
+	Restored []RestoredName
+	// Conflicts lists the files left with their current name because their original name is
+	// taken, with the name they would have had, sorted by path
+	Conflicts []RestoredName
+	// WithoutOriginal is the number of files without a usable OriginalFileName
+	WithoutOriginal int
+	// Unchanged is the number of files already named as they originally were
+	Unchanged int
+}
+
+// RestoredName is a file renamed back to its original name
+type RestoredName struct {
+	// From is the name of the file before, relative to the directory using "/" as separator
+	From string
+	// To is its original name, relative to the directory
+	To string
+}
+
+// nameRestorer implements the NameRestorer interface
+type nameRestorer struct {
+	extensions Extensions
+	metadata   MetadataReader
+}
+
+// NewNameRestorer creates a new NameRestorer instance
+func NewNameRestorer(et *exiftool.Exiftool) NameRestorer {
+	return &nameRestorer{
+		extensions: NewExtensions(),
+		metadata:   NewMetadataReader(et),
+	}
+}
+
+// RestoreNames renames the files of the directory and of its videos directory back to their
+// original names, through temporary names so files that swap names are restored too
+func (r *nameRestorer) RestoreNames(directory string, opts RestoreNamesOptions) (RestoreNamesReport, error) {
+	var report RestoreNamesReport
+	absDir, err := filepath.Abs(filepath.Clean(directory))
+	if err != nil {
+		return report, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return report, fmt.Errorf("directory does not exist: %w", err)
+	}
+	if !info.IsDir() {
+		return report, fmt.Errorf("%s is not a directory", directory)
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = NameConflictSkip
+	}
+
+	var renames []fileRename
+	for _, dir := range []string{absDir, filepath.Join(absDir, videosDirName)} {
+		planned, err := r.planDirectory(absDir, dir, opts.OnConflict, &report)
+		if err != nil {
+			if dir != absDir && os.IsNotExist(err) {
+				continue
+			}
+			return report, err
+		}
+		renames = append(renames, planned...)
+	}
+	sort.Slice(report.Restored, func(i, j int) bool { return report.Restored[i].From < report.Restored[j].From })
+	sort.Slice(report.Conflicts, func(i, j int) bool { return report.Conflicts[i].From < report.Conflicts[j].From })
+
+	if opts.DryRun || len(renames) == 0 {
+		return report, nil
+	}
+
+	journal, err := loadRenameJournal(absDir)
+	if err != nil {
+		return report, err
+	}
+	logger.Info("Restoring original names", "directory", absDir, "files", len(renames))
+	if err := renameThroughTemp(renames, "restore"); err != nil {
+		return report, err
+	}
+	if err := renameChecksumManifestEntries(absDir, renames); err != nil {
+		return report, err
+	}
+	return report, journal.record(absDir, absDir, renames)
+}
+
+// planDirectory returns the renames restoring the original names of the media files of dir,
+// adding them, the conflicts and the files left alone to report. With NameConflictSkip, a file
+// kept for a conflict keeps its name taken too, so the plan is repeated until no file is added.
+func (r *nameRestorer) planDirectory(absDir, dir string, policy NameConflictPolicy, report *RestoreNamesReport) ([]fileRename, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		name     string
+		original string
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !r.extensions.IsSupported(path) {
+			continue
+		}
+		original, ok := r.originalName(path)
+		switch {
+		case !ok:
+			report.WithoutOriginal++
+		case original == entry.Name():
+			report.Unchanged++
+		default:
+			candidates = append(candidates, candidate{name: entry.Name(), original: original})
+		}
+	}
+
+	// Names are compared case-insensitively, as two names differing only in case are the same
+	// file on macOS and Windows
+	kept := make(map[string]bool)
+	var targets map[string]string
+	for {
+		moving := make(map[string]bool, len(candidates))
+		for _, c := range candidates {
+			if !kept[c.name] {
+				moving[c.name] = true
+			}
+		}
+		taken := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			if !moving[entry.Name()] {
+				taken[strings.ToLower(entry.Name())] = true
+			}
+		}
+
+		targets = make(map[string]string, len(candidates))
+		added := false
+		for _, c := range candidates {
+			if kept[c.name] {
+				continue
+			}
+			target := c.original
+			if taken[strings.ToLower(target)] {
+				if policy == NameConflictSkip {
+					kept[c.name] = true
+					added = true
+					continue
+				}
+				target = suffixedName(target, taken)
+			}
+			taken[strings.ToLower(target)] = true
+			targets[c.name] = target
+		}
+		if !added {
+			break
+		}
+	}
+
+	var renames []fileRename
+	for _, c := range candidates {
+		from := libraryPath(absDir, filepath.Join(dir, c.name))
+		if kept[c.name] {
+			logger.Warn("Original name taken, keeping the current name", "file", from, "original", c.original)
+			report.Conflicts = append(report.Conflicts, RestoredName{From: from, To: libraryPath(absDir, filepath.Join(dir, c.original))})
+			continue
+		}
+		to := filepath.Join(dir, targets[c.name])
+		renames = append(renames, fileRename{from: filepath.Join(dir, c.name), to: to})
+		report.Restored = append(report.Restored, RestoredName{From: from, To: libraryPath(absDir, to)})
+	}
+	return renames, nil
+}
+
+// originalName returns the OriginalFileName of a file, if it has one that is a plain file name
+func (r *nameRestorer) originalName(path string) (string, bool) {
+	fields, err := r.metadata.ReadFields(path, []string{ExifOriginalFileName})
+	if err != nil {
+		logger.Debug("Failed to read original name", "file", path, "error", err)
+		return "", false
+	}
+	original := strings.TrimSpace(fields[ExifOriginalFileName])
+	if original == "" {
+		return "", false
+	}
+	// A name with a path would move the file out of its directory
+	if original == "." || original == ".." || strings.ContainsAny(original, `/\`) {
+		logger.Warn("Ignoring original name that isn't a file name", "file", path, "original", original)
+		return "", false
+	}
+	return original, true
+}
+
+// suffixedName returns name with the first number from 2 before its extension that makes it
+// a name not taken, e.g. "IMG_0001 (2).JPG"
+func suffixedName(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		suffixed := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !taken[strings.ToLower(suffixed)] {
+			return suffixed
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNameConflictPolicy(t *testing.T) {
+	for _, value := range []string{"skip", "suffix"} {
+		if policy, err := ParseNameConflictPolicy(value); err != nil || string(policy) != value {
+			t.Errorf("ParseNameConflictPolicy(%q) = %q, %v", value, policy, err)
+		}
+	}
+	if _, err := ParseNameConflictPolicy("overwrite"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestNameRestorer_RestoreNames(t *testing.T) {
+	dir := t.TempDir()
+	videosDir := filepath.Join(dir, videosDirName)
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		writeContentFile(t, dir, "2023_06_June_15_00001.jpg", "a"): {ExifOriginalFileName: "IMG_0001.JPG"},
+		// Swapped with the first file
+		writeContentFile(t, dir, "IMG_0001.JPG", "b"):                    {ExifOriginalFileName: "2023_06_June_15_00001.jpg"},
+		writeContentFile(t, dir, "2023_06_June_15_00003.jpg", "c"):       {},
+		writeContentFile(t, dir, "DSC_0042.jpg", "d"):                    {ExifOriginalFileName: "DSC_0042.jpg"},
+		writeContentFile(t, dir, "2023_06_June_15_00005.jpg", "e"):       {ExifOriginalFileName: "../escape.jpg"},
+		writeContentFile(t, videosDir, "2023_06_June_15_00001.mov", "f"): {ExifOriginalFileName: "MVI_0001.MOV"},
+	}}
+	restorer := &nameRestorer{extensions: NewExtensions(), metadata: metadata}
+
+	report, err := restorer.RestoreNames(dir, DefaultRestoreNamesOptions())
+	if err != nil {
+		t.Fatalf("RestoreNames failed: %v", err)
+	}
+	expected := []RestoredName{
+		{From: "2023_06_June_15_00001.jpg", To: "IMG_0001.JPG"},
+		{From: "IMG_0001.JPG", To: "2023_06_June_15_00001.jpg"},
+		{From: "videos/2023_06_June_15_00001.mov", To: "videos/MVI_0001.MOV"},
+	}
+	if !reflect.DeepEqual(report.Restored, expected) {
+		t.Errorf("Expected restored %v, got %v", expected, report.Restored)
+	}
+	if report.WithoutOriginal != 2 || report.Unchanged != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	for name, content := range map[string]string{"IMG_0001.JPG": "a", "2023_06_June_15_00001.jpg": "b", "videos/MVI_0001.MOV": "f"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, content, data, err)
+		}
+	}
+
+	// The restore is recorded so it can be undone
+	renamer := &directoryRenamer{extensions: NewExtensions(), fileRenamer: newModTimeRenamer()}
+	if _, err := renamer.UndoRename(dir); err != nil {
+		t.Fatalf("UndoRename failed: %v", err)
+	}
+	assertFilesExist(t, videosDir, []string{"2023_06_June_15_00001.mov"})
+}
+
+func TestNameRestorer_RestoreNames_Conflicts(t *testing.T) {
+	for _, tt := range []struct {
+		policy    NameConflictPolicy
+		restored  []RestoredName
+		conflicts []RestoredName
+	}{
+		{
+			policy:   NameConflictSkip,
+			restored: []RestoredName{{From: "2023_06_June_15_00001.jpg", To: "IMG_0001.JPG"}},
+			conflicts: []RestoredName{
+				{From: "2023_06_June_15_00002.jpg", To: "img_0001.jpg"},
+				{From: "2023_06_June_15_00003.jpg", To: "Notes.jpg"},
+			},
+		},
+		{
+			policy: NameConflictSuffix,
+			restored: []RestoredName{
+				{From: "2023_06_June_15_00001.jpg", To: "IMG_0001.JPG"},
+				{From: "2023_06_June_15_00002.jpg", To: "img_0001 (2).jpg"},
+				{From: "2023_06_June_15_00003.jpg", To: "Notes (2).jpg"},
+			},
+		},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			metadata := &fakeMetadata{fields: map[string]map[string]string{
+				// Two cameras numbering their files alike
+				writeContentFile(t, dir, "2023_06_June_15_00001.jpg", "a"): {ExifOriginalFileName: "IMG_0001.JPG"},
+				writeContentFile(t, dir, "2023_06_June_15_00002.jpg", "b"): {ExifOriginalFileName: "img_0001.jpg"},
+				// Taken by a file that isn't renamed
+				writeContentFile(t, dir, "2023_06_June_15_00003.jpg", "c"): {ExifOriginalFileName: "Notes.jpg"},
+				writeContentFile(t, dir, "notes.jpg", "d"):                 {},
+			}}
+			restorer := &nameRestorer{extensions: NewExtensions(), metadata: metadata}
+
+			opts := DefaultRestoreNamesOptions()
+			opts.OnConflict = tt.policy
+			report, err := restorer.RestoreNames(dir, opts)
+			if err != nil {
+				t.Fatalf("RestoreNames failed: %v", err)
+			}
+			if !reflect.DeepEqual(report.Restored, tt.restored) {
+				t.Errorf("Expected restored %v, got %v", tt.restored, report.Restored)
+			}
+			if !reflect.DeepEqual(report.Conflicts, tt.conflicts) {
+				t.Errorf("Expected conflicts %v, got %v", tt.conflicts, report.Conflicts)
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "notes.jpg")); err != nil || string(data) != "d" {
+				t.Errorf("Expected notes.jpg untouched, got %q (%v)", data, err)
+			}
+		})
+	}
+}
+
+func TestNameRestorer_RestoreNames_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &fakeMetadata{fields: map[string]map[string]string{
+		writeContentFile(t, dir, "2023_06_June_15_00001.jpg", "a"): {ExifOriginalFileName: "IMG_0001.JPG"},
+	}}
+	restorer := &nameRestorer{extensions: NewExtensions(), metadata: metadata}
+
+	opts := DefaultRestoreNamesOptions()
+	opts.DryRun = true
+	report, err := restorer.RestoreNames(dir, opts)
+	if err != nil {
+		t.Fatalf("RestoreNames failed: %v", err)
+	}
+	if len(report.Restored) != 1 {
+		t.Errorf("Expected 1 name to restore, got %v", report.Restored)
+	}
+	assertFilesExist(t, dir, []string{"2023_06_June_15_00001.jpg"})
+	if _, err := os.Stat(filepath.Join(dir, renameJournalName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no journal for a dry run, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// RestorePolicy decides what a restore does with a directory that is already in the target
+type RestorePolicy string
+
+const (
+	// RestorePolicyFail fails the restore of the directory, leaving it alone
+	RestorePolicyFail RestorePolicy = "fail"
+	// RestorePolicySkip leaves the directory alone and carries on with the others
+	RestorePolicySkip RestorePolicy = "skip-existing"
+	// RestorePolicyOverwrite replaces the directory with the one in the backup, once it has been
+	// downloaded and extracted in full
+	RestorePolicyOverwrite RestorePolicy = "overwrite"
+	// RestorePolicyMerge adds the files of the backup the directory doesn't have, numbering them
+	// after its own
+	RestorePolicyMerge RestorePolicy = "merge"
+)
+
+// ParseRestorePolicy parses "fail", "skip-existing", "overwrite" or "merge"
+func ParseRestorePolicy(s string) (RestorePolicy, error) {
+	switch policy := RestorePolicy(s); policy {
+	case RestorePolicyFail, RestorePolicySkip, RestorePolicyOverwrite, RestorePolicyMerge:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid restore conflict policy %q (expected fail, skip-existing, overwrite or merge)", s)
+}
+
+// replacedDirSuffix is added to the hidden name a directory is moved to while it is replaced
+const replacedDirSuffix = ".pics-replaced"
+
+// existingDirectoryExtractDir returns the directory to extract the backup of targetPath, which
+// is already in targetDir, to under policy: a temporary directory inside targetDir, removed by
+// cleanup, so the files can be moved into place with a rename. It returns "" when policy skips the
+// directory, and fails when policy doesn't allow restoring it.
+func existingDirectoryExtractDir(targetDir, targetPath string, policy RestorePolicy) (string, func(), error) {
+	switch policy {
+	case RestorePolicySkip:
+		return "", nil, nil
+	case RestorePolicyOverwrite, RestorePolicyMerge:
+	default:
+		return "", nil, fmt.Errorf("directory already exists: %s", targetPath)
+	}
+	extractDir, err := os.MkdirTemp(targetDir, mergeDirPattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create merge directory: %w", err)
+	}
+	return extractDir, func() { os.RemoveAll(extractDir) }, nil
+}
+
+// settleExistingDirectory puts the directory extracted to extracted in place of targetPath, or
+// merges it into targetPath, as policy says, and counts it in tally. The existing directory is
+// only moved aside once the extracted one is known to be complete, and only removed once the
+// extracted one is in its place.
+func settleExistingDirectory(extracted, targetPath string, policy RestorePolicy, tally *restoreTally) error {
+	dirName := filepath.Base(targetPath)
+	info, err := os.Stat(extracted)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", extracted)
+	}
+	if err != nil {
+		return fmt.Errorf("backup of %s was not extracted, leaving the directory as it is: %w", targetPath, err)
+	}
+	if policy == RestorePolicyOverwrite {
+		replaced := filepath.Join(filepath.Dir(targetPath), "."+dirName+replacedDirSuffix)
+		// A directory left by an interrupted overwrite may be the only copy of the files
+		if _, err := os.Lstat(replaced); err == nil {
+			return fmt.Errorf("failed to replace %s: %s is left from an interrupted restore, check it and remove it", targetPath, replaced)
+		}
+		if err := os.Rename(targetPath, replaced); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", targetPath, err)
+		}
+		if err := os.Rename(extracted, targetPath); err != nil {
+			// Put the directory back rather than leave the library without it
+			if restoreErr := os.Rename(replaced, targetPath); restoreErr != nil {
+				logger.Error("Failed to put back replaced directory", "directory", targetPath, "moved_to", replaced, "error", restoreErr)
+			}
+			return fmt.Errorf("failed to replace %s: %w", targetPath, err)
+		}
+		if err := os.RemoveAll(replaced); err != nil {
+			logger.Warn("Failed to remove replaced directory", "directory", replaced, "error", err)
+		}
+		logger.Info("Successfully replaced directory", "directory", dirName)
+		tally.replaced.Add(1)
+		return nil
+	}
+
+	result, err := mergeDirectory(extracted, targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to merge into %s: %w", targetPath, err)
+	}
+	logger.Info("Successfully merged directory", "directory", dirName, "added", result.added, "duplicates", result.duplicates)
+	tally.merged.Add(1)
+	tally.added.Add(int64(result.added))
+	tally.duplicates.Add(int64(result.duplicates))
+	return nil
+}
@@ -0,0 +1,73 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRestorePolicy(t *testing.T) {
+	for _, value := range []string{"fail", "skip-existing", "overwrite", "merge"} {
+		if policy, err := ParseRestorePolicy(value); err != nil || string(policy) != value {
+			t.Errorf("ParseRestorePolicy(%q) = %q, %v", value, policy, err)
+		}
+	}
+	if _, err := ParseRestorePolicy("skip"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestSettleExistingDirectory_OverwriteFails(t *testing.T) {
+	targetDir := t.TempDir()
+	targetPath := filepath.Join(targetDir, "2023 06 June 15")
+	writeContentFile(t, targetPath, "2023_06_June_15_00001.jpg", "sunrise")
+
+	// Nothing was extracted, so the existing directory must be left in place
+	missing := filepath.Join(targetDir, ".pics-merge-1", "2023 06 June 15")
+	if err := settleExistingDirectory(missing, targetPath, RestorePolicyOverwrite, &restoreTally{}); err == nil {
+		t.Fatal("Expected replacing with a missing directory to fail")
+	}
+	if got := listDir(t, targetPath); !reflect.DeepEqual(got, []string{"2023_06_June_15_00001.jpg"}) {
+		t.Errorf("Expected the existing directory kept, got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, ".2023 06 June 15"+replacedDirSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected no replaced directory left, got %v", err)
+	}
+}
+
+func TestSettleExistingDirectory_OverwriteLeftover(t *testing.T) {
+	targetDir := t.TempDir()
+	targetPath := filepath.Join(targetDir, "2023 06 June 15")
+	writeContentFile(t, targetPath, "2023_06_June_15_00001.jpg", "sunrise")
+	extracted := filepath.Join(targetDir, ".pics-merge-1", "2023 06 June 15")
+	writeContentFile(t, extracted, "2023_06_June_15_00001.jpg", "sunset")
+	// An overwrite interrupted before removing the directory it replaced
+	replaced := filepath.Join(targetDir, ".2023 06 June 15"+replacedDirSuffix)
+	writeContentFile(t, replaced, "2023_06_June_15_00002.jpg", "noon")
+
+	if err := settleExistingDirectory(extracted, targetPath, RestorePolicyOverwrite, &restoreTally{}); err == nil {
+		t.Fatal("Expected the leftover directory to stop the overwrite")
+	}
+	if data, err := os.ReadFile(filepath.Join(targetPath, "2023_06_June_15_00001.jpg")); err != nil || string(data) != "sunrise" {
+		t.Errorf("Expected the existing directory kept, got %q (%v)", data, err)
+	}
+	if got := listDir(t, replaced); !reflect.DeepEqual(got, []string{"2023_06_June_15_00002.jpg"}) {
+		t.Errorf("Expected the leftover directory kept, got %v", got)
+	}
+
+	// Once it is gone, the extracted directory takes the place of the existing one
+	if err := os.RemoveAll(replaced); err != nil {
+		t.Fatal(err)
+	}
+	tally := &restoreTally{}
+	if err := settleExistingDirectory(extracted, targetPath, RestorePolicyOverwrite, tally); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(targetPath, "2023_06_June_15_00001.jpg")); err != nil || string(data) != "sunset" {
+		t.Errorf("Expected the directory replaced, got %q (%v)", data, err)
+	}
+	if tally.replaced.Load() != 1 {
+		t.Errorf("Expected 1 directory replaced, got %d", tally.replaced.Load())
+	}
+}
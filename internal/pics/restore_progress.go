@@ -0,0 +1,149 @@
+package pics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// restoreProgressName is the file a directory being restored records its extraction progress in,
+// so an interrupted restore continues where it stopped. It is removed once the directory is restored.
+const restoreProgressName = ".pics-restore.json"
+
+// restoreProgress records how far the restore of a directory got. A nil restoreProgress records
+// nothing, for extractions that can't be resumed.
+type restoreProgress struct {
+	// Key is the archive or manifest the directory is restored from
+	Key string `json:"key"`
+	// ETag is the ETag of Key when the restore started ("" = unknown)
+	ETag string `json:"etag,omitempty"`
+	// Entries is the number of entries extracted so far from each archive
+	Entries map[string]int `json:"entries"`
+	// Complete holds the archives extracted in full
+	Complete map[string]bool `json:"complete"`
+
+	dirPath string
+}
+
+// newRestoreProgress creates the directory restored from key and starts recording its progress
+func newRestoreProgress(dirPath, key, etag string) (*restoreProgress, error) {
+	if err := os.MkdirAll(dirPath, libraryDirMode); err != nil {
+		return nil, err
+	}
+	progress := &restoreProgress{
+		Key:      key,
+		ETag:     etag,
+		Entries:  make(map[string]int),
+		Complete: make(map[string]bool),
+		dirPath:  dirPath,
+	}
+	if err := progress.save(); err != nil {
+		return nil, fmt.Errorf("failed to record restore progress: %w", err)
+	}
+	return progress, nil
+}
+
+// loadRestoreProgress reads the progress of an interrupted restore of dirPath, or returns nil
+// if the directory isn't being restored
+func loadRestoreProgress(dirPath string) (*restoreProgress, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, restoreProgressName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress := &restoreProgress{dirPath: dirPath}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dirPath, restoreProgressName), err)
+	}
+	if progress.Entries == nil {
+		progress.Entries = make(map[string]int)
+	}
+	if progress.Complete == nil {
+		progress.Complete = make(map[string]bool)
+	}
+	return progress, nil
+}
+
+// resumes checks that the interrupted restore can be continued from key, whose current ETag is
+// etag ("" = unknown). Entries of another archive, or of a newer backup, can't be skipped.
+func (p *restoreProgress) resumes(key, etag string) error {
+	if p.Key != key {
+		return fmt.Errorf("%s holds an interrupted restore of %s, remove it to restore %s", p.dirPath, p.Key, key)
+	}
+	// Progress recorded by earlier versions kept the quotes of the ETag
+	if p.ETag != "" && etag != "" && strings.Trim(p.ETag, `"`) != strings.Trim(etag, `"`) {
+		return fmt.Errorf("%s holds an interrupted restore of an older backup of %s, remove it to restore again", p.dirPath, key)
+	}
+	return nil
+}
+
+// extracted returns the number of entries already extracted from archiveKey
+func (p *restoreProgress) extracted(archiveKey string) int {
+	if p == nil {
+		return 0
+	}
+	return p.Entries[archiveKey]
+}
+
+// completed reports whether archiveKey was already extracted in full
+func (p *restoreProgress) completed(archiveKey string) bool {
+	return p != nil && p.Complete[archiveKey]
+}
+
+// recordEntries records that the first entries of archiveKey are extracted
+func (p *restoreProgress) recordEntries(archiveKey string, entries int) error {
+	if p == nil {
+		return nil
+	}
+	p.Entries[archiveKey] = entries
+	return p.save()
+}
+
+// recordComplete records that archiveKey is extracted in full
+func (p *restoreProgress) recordComplete(archiveKey string) error {
+	if p == nil {
+		return nil
+	}
+	p.Complete[archiveKey] = true
+	return p.save()
+}
+
+// finish removes the progress file once the directory is restored
+func (p *restoreProgress) finish() error {
+	if p == nil {
+		return nil
+	}
+	return keepingModTime(p.dirPath, func() error {
+		return os.Remove(filepath.Join(p.dirPath, restoreProgressName))
+	})
+}
+
+// save writes the progress to the directory, replacing the previous one at once
+func (p *restoreProgress) save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return keepingModTime(p.dirPath, func() error {
+		return writeFileAtomically(p.dirPath, restoreProgressName, data)
+	})
+}
+
+// keepingModTime runs change, which adds or removes a file in dir, and gives dir back the
+// modification time it had before, so the progress file doesn't undo the time restored from the
+// archive
+func keepingModTime(dir string, change func() error) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return change()
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return os.Chtimes(dir, time.Now(), info.ModTime())
+}
@@ -0,0 +1,141 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// backUpForResume backs up a directory of three photos and returns the key of its archive
+func backUpForResume(t *testing.T, backup *s3Backup, bucket, dirName string) string {
+	t.Helper()
+	sourceDir := t.TempDir()
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00002.jpg", "dinner")
+	writeContentFile(t, filepath.Join(sourceDir, dirName), "2023_06_June_15_00003.jpg", "sunset")
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	stored, _, err := backup.forBucket(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := stored.listBucket(testCtx, bucket, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := inv.objects()
+	if len(objects) != 1 {
+		t.Fatalf("Expected a single archive, got %d", len(objects))
+	}
+	return objects[0].Key
+}
+
+func TestBackup_RestoreDirectories_RemovesProgress(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	dirName := "2023 06 June 15"
+	backUpForResume(t, backup, bucket, dirName)
+	targetDir := t.TempDir()
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+
+	expected := []string{"2023_06_June_15_00001.jpg", "2023_06_June_15_00002.jpg", "2023_06_June_15_00003.jpg"}
+	if got := listDir(t, filepath.Join(targetDir, dirName)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v without restore progress, got %v", expected, got)
+	}
+}
+
+func TestBackup_RestoreDirectories_ResumesInterruptedRestore(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	dirName := "2023 06 June 15"
+	key := backUpForResume(t, backup, bucket, dirName)
+	targetDir := t.TempDir()
+
+	// The restore was interrupted after the directory entry and the first photo
+	dirPath := filepath.Join(targetDir, dirName)
+	progress, err := newRestoreProgress(dirPath, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := progress.recordEntries(key, 2); err != nil {
+		t.Fatal(err)
+	}
+	writeContentFile(t, dirPath, "2023_06_June_15_00001.jpg", "kept")
+
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 1 {
+		t.Errorf("Expected the directory restored, got %+v", report)
+	}
+
+	// Entries extracted before the interruption are not extracted again
+	for name, content := range map[string]string{
+		"2023_06_June_15_00001.jpg": "kept",
+		"2023_06_June_15_00002.jpg": "dinner",
+		"2023_06_June_15_00003.jpg": "sunset",
+	} {
+		data, err := os.ReadFile(filepath.Join(dirPath, name))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, content, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dirPath, restoreProgressName)); !os.IsNotExist(err) {
+		t.Error("Expected the restore progress removed once the directory is restored")
+	}
+}
+
+func TestBackup_RestoreDirectories_RefusesProgressOfAnotherArchive(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	dirName := "2023 06 June 15"
+	backUpForResume(t, backup, bucket, dirName)
+	targetDir := t.TempDir()
+
+	dirPath := filepath.Join(targetDir, dirName)
+	if _, err := newRestoreProgress(dirPath, dirName+" (1 images, 0 videos).tar.gz", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1}); err == nil {
+		t.Error("Expected the restore of another archive refused")
+	}
+	if got := listDir(t, dirPath); !reflect.DeepEqual(got, []string{restoreProgressName}) {
+		t.Errorf("Expected the directory left alone, got %v", got)
+	}
+}
+
+func TestRestoreProgress_Resumes(t *testing.T) {
+	progress := &restoreProgress{Key: "a.tar.gz", ETag: "etag-1"}
+	if err := progress.resumes("a.tar.gz", "etag-1"); err != nil {
+		t.Errorf("Expected the same archive resumed, got %v", err)
+	}
+	if err := progress.resumes("a.tar.gz", ""); err != nil {
+		t.Errorf("Expected an archive of unknown ETag resumed, got %v", err)
+	}
+	if err := progress.resumes("a.tar.gz", "etag-2"); err == nil {
+		t.Error("Expected a newer backup of the archive refused")
+	}
+	if err := progress.resumes("b.tar.gz", "etag-1"); err == nil {
+		t.Error("Expected another archive refused")
+	}
+}
+
+func TestLoadRestoreProgress_NotRestoring(t *testing.T) {
+	progress, err := loadRestoreProgress(t.TempDir())
+	if err != nil || progress != nil {
+		t.Errorf("Expected no progress, got %+v (%v)", progress, err)
+	}
+}
@@ -0,0 +1,289 @@
+package pics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// sessionProcessedName is the file in the directory of a parse session listing the files it
+// processed, one JSON object per line, so an interrupted parse can be resumed without copying
+// and compressing them again
+const sessionProcessedName = ".pics-processed.jsonl"
+
+// Resumable reports whether the parse of the session can be resumed: one interrupted while
+// copying files, whose processed files are kept
+func (s Session) Resumable() bool {
+	return s.Kind == SessionKindParse && s.Stage == SessionStageCopying && s.Target != ""
+}
+
+// findResumableSession returns the newest interrupted parse into target, in its
+// ParseSessionsDirName, taken over by the current run, or nil if there is none. A parse
+// interrupted while organising may have moved some files into the library already, so it can
+// only be adopted.
+func findResumableSession(target string) (*Session, error) {
+	sessions, err := FindOrphanedSessions(filepath.Join(target, ParseSessionsDirName))
+	if err != nil {
+		return nil, err
+	}
+	for i := len(sessions) - 1; i >= 0; i-- {
+		session := sessions[i]
+		if session.Kind != SessionKindParse || session.Target != target {
+			continue
+		}
+		if session.Adoptable() {
+			return nil, fmt.Errorf("the interrupted parse %s into %s was organising files, adopt it with 'pics sessions recover --adopt %s'", session.ID, target, session.ID)
+		}
+		if !session.Resumable() {
+			continue
+		}
+
+		host, _ := os.Hostname()
+		session.ID, session.PID, session.Host, session.Args = sessionID, os.Getpid(), host, os.Args[1:]
+		if err := session.save(); err != nil {
+			return nil, err
+		}
+		return &session, nil
+	}
+	return nil, nil
+}
+
+// processedEntry is a line of the processed files journal
+type processedEntry struct {
+	// Source is the absolute path of the source file
+	Source string `json:"source"`
+	// Size and ModTime are those of the source file when it was processed
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Dest is the name of the processed copy in the files directory of the session
+	Dest string `json:"dest"`
+	// DestSize is the size of the copy once processed, which tells a complete copy apart from
+	// one cut short by a power loss
+	DestSize int64 `json:"destSize"`
+}
+
+// processedJournal records the files a parse session processed, and tells which of them an
+// interrupted run of the session already processed
+type processedJournal struct {
+	mu   sync.Mutex
+	file *os.File
+	// resumed are the files the interrupted run processed, by source path
+	resumed map[string]processedEntry
+	// matched are the sources of resumed found again by this run
+	matched map[string]bool
+}
+
+// openProcessedJournal opens the processed files journal of the session in sessionDir. When
+// resuming, the files it lists are kept if neither their source nor their copy in filesDir
+// changed since; other files in filesDir, such as copies cut short, are removed.
+func openProcessedJournal(sessionDir, filesDir string, resume bool) (*processedJournal, error) {
+	journal := &processedJournal{resumed: make(map[string]processedEntry), matched: make(map[string]bool)}
+	path := filepath.Join(sessionDir, sessionProcessedName)
+	if resume {
+		entries, err := readProcessedEntries(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if err := checkProcessedEntry(entry, filesDir); err != nil {
+				logger.Debug("Processing file again", "file", entry.Source, "reason", err)
+				continue
+			}
+			journal.resumed[entry.Source] = entry
+		}
+		if err := journal.pruneUnrecorded(filesDir); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, libraryFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open processed files journal: %w", err)
+	}
+	journal.file = file
+	return journal, nil
+}
+
+// readProcessedEntries reads the processed files journal at path. A missing journal has no
+// entries, and a last line cut short by the interruption is skipped.
+func readProcessedEntries(path string) ([]processedEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processed files journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []processedEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry processedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Debug("Skipping unreadable line of processed files journal", "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// checkProcessedEntry checks that the source of entry and its copy in filesDir are as the
+// interrupted run left them
+func checkProcessedEntry(entry processedEntry, filesDir string) error {
+	info, err := os.Stat(entry.Source)
+	if err != nil {
+		return err
+	}
+	if info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+		return errFileChanged
+	}
+	dest, err := os.Stat(filepath.Join(filesDir, entry.Dest))
+	if err != nil {
+		return err
+	}
+	if dest.Size() != entry.DestSize {
+		return fmt.Errorf("copy is %d bytes, expected %d", dest.Size(), entry.DestSize)
+	}
+	return nil
+}
+
+// pruneUnrecorded removes the files in filesDir that aren't the copy of a resumed file, or one
+// of its sidecars
+func (j *processedJournal) pruneUnrecorded(filesDir string) error {
+	kept := make(map[string]bool, len(j.resumed))
+	var stems []string
+	for _, entry := range j.resumed {
+		kept[entry.Dest] = true
+		stems = append(stems, strings.TrimSuffix(entry.Dest, filepath.Ext(entry.Dest)))
+	}
+
+	entries, err := os.ReadDir(filesDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filesDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || kept[entry.Name()] {
+			continue
+		}
+		logger.Debug("Removing file the interrupted run didn't finish", "file", entry.Name())
+		if err := os.Remove(filepath.Join(filesDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove unfinished file: %w", err)
+		}
+	}
+
+	sidecarDir := filepath.Join(filesDir, sidecarDirName)
+	sidecars, err := os.ReadDir(sidecarDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", sidecarDir, err)
+	}
+	for _, sidecar := range sidecars {
+		if !hasAnyPrefix(sidecar.Name(), stems) {
+			os.Remove(filepath.Join(sidecarDir, sidecar.Name()))
+		}
+	}
+	return nil
+}
+
+// hasAnyPrefix reports whether s starts with any of prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// alreadyProcessed reports whether the interrupted run processed file to the same copy, which
+// is then kept instead of processed again. file is pointed at the copy, which the run may have
+// converted to another format.
+func (j *processedJournal) alreadyProcessed(file *fileToProcess) bool {
+	source, err := filepath.Abs(file.srcPath)
+	if err != nil {
+		return false
+	}
+	entry, ok := j.resumed[source]
+	if !ok || entry.Size != file.size || !entry.ModTime.Equal(file.modTime) {
+		return false
+	}
+	// Converted copies have the extension of their format instead of their own
+	dest := filepath.Base(file.destPath)
+	if entry.Dest != dest && strings.TrimSuffix(entry.Dest, filepath.Ext(entry.Dest)) != strings.TrimSuffix(dest, filepath.Ext(dest)) {
+		return false
+	}
+	file.destPath = filepath.Join(filepath.Dir(file.destPath), entry.Dest)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.matched[source] = true
+	return true
+}
+
+// record adds file, just processed, to the journal
+func (j *processedJournal) record(file fileToProcess) error {
+	source, err := filepath.Abs(file.srcPath)
+	if err != nil {
+		return err
+	}
+	dest, err := os.Stat(file.destPath)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(processedEntry{
+		Source:   source,
+		Size:     file.size,
+		ModTime:  file.modTime,
+		Dest:     filepath.Base(file.destPath),
+		DestSize: dest.Size(),
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+// resumedCount returns the number of files kept from the interrupted run
+func (j *processedJournal) resumedCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.matched)
+}
+
+// removeUnmatched removes the copies of resumed files this run didn't find again, such as
+// files since removed from the source or now ignored, so they aren't imported
+func (j *processedJournal) removeUnmatched(filesDir string) {
+	for source, entry := range j.resumed {
+		if j.matched[source] {
+			continue
+		}
+		logger.Debug("Removing copy of file no longer in the sources", "file", source)
+		os.Remove(filepath.Join(filesDir, entry.Dest))
+		stem := strings.TrimSuffix(entry.Dest, filepath.Ext(entry.Dest))
+		for _, suffix := range sidecarSuffixes {
+			for _, name := range []string{stem + suffix, stem + strings.ToLower(suffix)} {
+				os.Remove(filepath.Join(filesDir, sidecarDirName, name))
+			}
+		}
+	}
+}
+
+// Close closes the journal
+func (j *processedJournal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,182 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestJournal returns a processed files journal of a new session directory
+func newTestJournal(t *testing.T) *processedJournal {
+	t.Helper()
+	sessionDir := t.TempDir()
+	journal, err := openProcessedJournal(sessionDir, sessionDir, false)
+	if err != nil {
+		t.Fatalf("openProcessedJournal failed: %v", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+func TestSession_Resumable(t *testing.T) {
+	tests := []struct {
+		session  Session
+		expected bool
+	}{
+		{Session{Kind: SessionKindParse, Stage: SessionStageCopying, Target: "/pics"}, true},
+		{Session{Kind: SessionKindParse, Stage: SessionStageOrganising, Target: "/pics"}, false},
+		{Session{Kind: SessionKindBackup, Stage: SessionStageCopying, Target: "/pics"}, false},
+		{Session{Kind: SessionKindParse, Stage: SessionStageCopying}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.session.Resumable(); got != tt.expected {
+			t.Errorf("Resumable() of %+v = %v, expected %v", tt.session, got, tt.expected)
+		}
+	}
+}
+
+// createParseSession creates the directory of a parse session in the sessions directory of target
+func createParseSession(t *testing.T, target string) *Session {
+	t.Helper()
+	sessionsDir := filepath.Join(target, ParseSessionsDirName)
+	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	session, err := createSessionDir(sessionsDir, SessionKindParse, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestFindResumableSession(t *testing.T) {
+	pics, other := t.TempDir(), t.TempDir()
+
+	if session, err := findResumableSession(pics); err != nil || session != nil {
+		t.Fatalf("Expected no session to resume, got %+v, %v", session, err)
+	}
+
+	interrupted := createParseSession(t, pics)
+	if err := interrupted.setStage(SessionStageCopying); err != nil {
+		t.Fatal(err)
+	}
+	orphanSession(t, interrupted, exitedPID(t))
+	otherSession := createParseSession(t, other)
+	orphanSession(t, otherSession, exitedPID(t))
+
+	session, err := findResumableSession(pics)
+	if err != nil || session == nil || session.Dir != interrupted.Dir {
+		t.Fatalf("Expected the interrupted parse into %s, got %+v, %v", pics, session, err)
+	}
+	// The session is taken over by this run
+	if loaded, err := loadSession(session.Dir); err != nil || loaded.PID != os.Getpid() || loaded.Orphaned() {
+		t.Errorf("Expected the session taken over, got %+v, %v", loaded, err)
+	}
+
+	// A parse interrupted while organising can only be adopted
+	if err := otherSession.setStage(SessionStageOrganising); err != nil {
+		t.Fatal(err)
+	}
+	orphanSession(t, otherSession, exitedPID(t))
+	if _, err := findResumableSession(other); err == nil {
+		t.Error("Expected an error for a parse interrupted while organising")
+	}
+}
+
+func TestProcessedJournal_Resume(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, _ := createSourceAndTarget(t, tmpDir)
+	sessionDir := filepath.Join(tmpDir, "session")
+	filesDir := filepath.Join(sessionDir, sessionFilesDirName)
+	if err := os.MkdirAll(filesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	for _, name := range []string{"kept.jpg", "changed.jpg", "removed.jpg", "cut.jpg"} {
+		createMediaFile(t, sourceDir, name, testDate)
+	}
+
+	// The interrupted run processed three files and was cutting the fourth short
+	parser := &mediaParser{extensions: NewExtensions(), stats: NewFileStats(), exifWriter: NewExifWriter(nil)}
+	journal, err := openProcessedJournal(sessionDir, filesDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"kept.jpg", "changed.jpg", "removed.jpg"} {
+		file := discoveredFile(t, filepath.Join(sourceDir, name), filepath.Join(filesDir, "root-"+name))
+		if err := copyFilePreserveTime(file.srcPath, file.destPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.record(file); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+	journal.Close()
+	if err := os.WriteFile(filepath.Join(filesDir, "root-cut.jpg"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Mark the kept copy, which a resumed run must not copy again
+	if err := os.WriteFile(filepath.Join(filesDir, "root-kept.jpg"), []byte("TEST MEDIA CONTENT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sourceDir, "changed.jpg"), []byte("changed media content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err = openProcessedJournal(sessionDir, filesDir, true)
+	if err != nil {
+		t.Fatalf("openProcessedJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if _, err := os.Stat(filepath.Join(filesDir, "root-cut.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the copy cut short removed")
+	}
+
+	// removed.jpg went from the source since
+	if err := os.Remove(filepath.Join(sourceDir, "removed.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := parser.copyAndCompressFiles(testCtx, []parseSource{{dir: sourceDir, ignore: &ignoreMatcher{}}}, filesDir, testParseOptions, journal)
+	if err != nil {
+		t.Fatalf("copyAndCompressFiles failed: %v", err)
+	}
+	journal.removeUnmatched(filesDir)
+
+	if journal.resumedCount() != 1 || len(report.imported) != 3 {
+		t.Errorf("Expected 1 file kept of 3 imported, got %d of %d", journal.resumedCount(), len(report.imported))
+	}
+	if data, _ := os.ReadFile(filepath.Join(filesDir, "root-kept.jpg")); string(data) != "TEST MEDIA CONTENT" {
+		t.Errorf("Expected the kept copy left alone, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(filesDir, "root-changed.jpg")); string(data) != "changed media content" {
+		t.Errorf("Expected the changed file copied again, got %q", data)
+	}
+	var names []string
+	entries, _ := os.ReadDir(filesDir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if expected := []string{"root-changed.jpg", "root-cut.jpg", "root-kept.jpg"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestReadProcessedEntries_CutShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), sessionProcessedName)
+	if entries, err := readProcessedEntries(path); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries without a journal, got %v, %v", entries, err)
+	}
+
+	data := `{"source":"/card/IMG_0001.jpg","size":4,"dest":"root-IMG_0001.jpg","destSize":4}` + "\n" + `{"source":"/card/IMG_00`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readProcessedEntries(path)
+	if err != nil || len(entries) != 1 || entries[0].Source != "/card/IMG_0001.jpg" {
+		t.Errorf("Expected the complete entry only, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,60 @@
+package pics
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveEntryPath returns where the entry of header is extracted to inside targetDir, failing for
+// entries whose name, once cleaned, leaves targetDir, such as "../../.bashrc" or "/etc/passwd"
+func archiveEntryPath(targetDir string, header *tar.Header) (string, error) {
+	name := filepath.FromSlash(header.Name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("archive entry %q is outside the target directory", header.Name)
+	}
+	return filepath.Join(targetDir, name), nil
+}
+
+// checkNoSymlinks fails if path, or any directory between root and path, is a symbolic link, so
+// an extracted file can't be written through a link to outside root. Paths that don't exist yet
+// are fine, as extraction only creates real directories.
+func checkNoSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through symbolic link %s", path, current)
+		}
+	}
+	return nil
+}
+
+// extractLimit counts the bytes extracted from an archive against a limit (0 = no limit)
+type extractLimit struct {
+	limit   int64
+	written int64
+}
+
+// reserve counts size more bytes, failing if they would take the archive over the limit
+func (l *extractLimit) reserve(name string, size int64) error {
+	if l.limit > 0 && l.written+size > l.limit {
+		return fmt.Errorf("archive entry %s takes the extracted size over the limit of %s", name, FormatByteSize(l.limit))
+	}
+	l.written += size
+	return nil
+}
@@ -0,0 +1,136 @@
+package pics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArchiveEntry is an entry written by writeArchiveEntries, with content for regular files
+type testArchiveEntry struct {
+	header  tar.Header
+	content string
+}
+
+// writeArchiveEntries writes a tar.gz holding entries to path
+func writeArchiveEntries(t *testing.T, path string, entries []testArchiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+	gzWriter := gzip.NewWriter(file)
+	defer gzWriter.Close()
+	tarWriter := tar.NewWriter(gzWriter)
+	defer tarWriter.Close()
+
+	for _, entry := range entries {
+		header := entry.header
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.content))
+			header.Mode = 0644
+		}
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("Failed to write content: %v", err)
+		}
+	}
+}
+
+func TestExtractTarGz_RejectsPathTraversal(t *testing.T) {
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+
+	for _, name := range []string{"../escaped.jpg", "album/../../escaped.jpg", "/tmp/escaped.jpg"} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+			writeArchiveEntries(t, archivePath, []testArchiveEntry{
+				{header: tar.Header{Name: name, Typeflag: tar.TypeReg}, content: "evil"},
+			})
+
+			targetDir := filepath.Join(tmpDir, "restored")
+			err := backup.extractTarGz(testCtx, archivePath, targetDir, nil, 0, nil, "")
+			if err == nil || !strings.Contains(err.Error(), "outside the target directory") {
+				t.Fatalf("Expected the entry rejected, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(tmpDir, "escaped.jpg")); !os.IsNotExist(err) {
+				t.Errorf("Expected nothing written outside the target, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractTarGz_SkipsLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	writeArchiveEntries(t, archivePath, []testArchiveEntry{
+		{header: tar.Header{Name: "album/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "album/outside", Typeflag: tar.TypeSymlink, Linkname: tmpDir}},
+		{header: tar.Header{Name: "album/passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}},
+		{header: tar.Header{Name: "album/photo.jpg", Typeflag: tar.TypeReg}, content: "photo"},
+	})
+
+	targetDir := filepath.Join(tmpDir, "restored")
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if err := backup.extractTarGz(testCtx, archivePath, targetDir, nil, 0, nil, ""); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got := listDir(t, filepath.Join(targetDir, "album")); len(got) != 1 || got[0] != "photo.jpg" {
+		t.Errorf("Expected only the photo extracted, got %v", got)
+	}
+}
+
+func TestExtractTarGz_RefusesExistingSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	outside := filepath.Join(tmpDir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	targetDir := filepath.Join(tmpDir, "restored")
+	if err := os.Mkdir(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(targetDir, "album")); err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	writeArchiveEntries(t, archivePath, []testArchiveEntry{
+		{header: tar.Header{Name: "album/photo.jpg", Typeflag: tar.TypeReg}, content: "photo"},
+	})
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if err := backup.extractTarGz(testCtx, archivePath, targetDir, nil, 0, nil, ""); err == nil {
+		t.Fatal("Expected extracting through a symbolic link to fail")
+	}
+	if got := listDir(t, outside); len(got) != 0 {
+		t.Errorf("Expected nothing written through the link, got %v", got)
+	}
+}
+
+func TestExtractTarGz_MaxSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	writeArchiveEntries(t, archivePath, []testArchiveEntry{
+		{header: tar.Header{Name: "album/photo1.jpg", Typeflag: tar.TypeReg}, content: strings.Repeat("a", 600)},
+		{header: tar.Header{Name: "album/photo2.jpg", Typeflag: tar.TypeReg}, content: strings.Repeat("b", 600)},
+	})
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+
+	err := backup.extractTarGz(testCtx, archivePath, filepath.Join(tmpDir, "limited"), nil, 1000, nil, "")
+	if err == nil || !strings.Contains(err.Error(), "album/photo2.jpg") {
+		t.Fatalf("Expected the second file to take the archive over the limit, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "limited", "album", "photo2.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the file over the limit not written, got %v", err)
+	}
+
+	if err := backup.extractTarGz(testCtx, archivePath, filepath.Join(tmpDir, "unlimited"), nil, 1200, nil, ""); err != nil {
+		t.Errorf("Expected an archive at the limit extracted, got %v", err)
+	}
+}
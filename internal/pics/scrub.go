@@ -0,0 +1,507 @@
+package pics
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// checksumDatabaseName is the file of a library recording the checksum of each of its files
+	checksumDatabaseName = ".pics-checksums.json"
+	// QuarantineDirName is the directory of a library corrupted files are moved to. It starts with
+	// a dot so backups, syncs and statistics leave it out.
+	QuarantineDirName = ".pics-quarantine"
+	// recoverDirPattern names the directories of a library copies recovered from a backup are
+	// extracted to, next to the files they replace
+	recoverDirPattern = ".pics-recover-*"
+)
+
+// LibraryScrubber detects files of a library whose contents changed on disk without being
+// modified (bit rot)
+type LibraryScrubber interface {
+	// Scrub checks the files of libraryDir against the checksums recorded the first time they were
+	// seen, records the checksums of new and modified files, and reports the corrupted ones
+	Scrub(ctx context.Context, libraryDir string, opts ScrubOptions) (ScrubReport, error)
+}
+
+// ScrubReport describes what a scrub run did. File paths are relative to the library and use
+// "/" as separator.
+type ScrubReport struct {
+	// Verified is the number of files that still match their checksum
+	Verified int
+	// Added is the number of files seen for the first time
+	Added int
+	// Updated is the number of files modified since the last scrub, whose checksum was recorded again
+	Updated int
+	// Skipped is the number of files not read because they were verified within ScrubOptions.Interval
+	Skipped int
+	// Removed is the number of files deleted since the last scrub
+	Removed int
+	// Repaired lists the corrupted files replaced with a good copy from the backup
+	Repaired []string
+	// Quarantined lists the corrupted files moved to QuarantineDir
+	Quarantined []string
+	// Corrupted lists the corrupted or unreadable files left in place
+	Corrupted []string
+	// QuarantineDir is the directory corrupted files are moved to
+	QuarantineDir string
+	// Warnings is the number of warnings logged during the run
+	Warnings int
+	// Duration is how long the run took
+	Duration time.Duration
+}
+
+// checksumEntry is the checksum of a file of the library, and what the file looked like then
+type checksumEntry struct {
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+	// ModTime is the modification time of the file in Unix nanoseconds
+	ModTime int64 `json:"modTime"`
+	// SHA256 is the hex encoded SHA-256 hash of the file's contents
+	SHA256 string `json:"sha256"`
+	// Verified is when the contents last matched the hash
+	Verified time.Time `json:"verified"`
+}
+
+// checksumDatabase records the checksum of each file of a library, by path relative to the
+// library using "/" as separator
+type checksumDatabase struct {
+	Files map[string]checksumEntry `json:"files"`
+
+	mu sync.Mutex
+}
+
+// loadChecksumDatabase reads the checksums of a library, or returns an empty database if it
+// was never scrubbed
+func loadChecksumDatabase(libraryDir string) (*checksumDatabase, error) {
+	db := &checksumDatabase{Files: make(map[string]checksumEntry)}
+	data, err := os.ReadFile(filepath.Join(libraryDir, checksumDatabaseName))
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", checksumDatabaseName, err)
+	}
+	if db.Files == nil {
+		db.Files = make(map[string]checksumEntry)
+	}
+	return db, nil
+}
+
+// get returns the checksum recorded for a file
+func (d *checksumDatabase) get(relPath string) (checksumEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.Files[relPath]
+	return entry, ok
+}
+
+// set records the checksum of a file
+func (d *checksumDatabase) set(relPath string, entry checksumEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Files[relPath] = entry
+}
+
+// remove forgets a file
+func (d *checksumDatabase) remove(relPath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.Files, relPath)
+}
+
+// save writes the database to the library, replacing the previous one at once
+func (d *checksumDatabase) save(libraryDir string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(libraryDir, checksumDatabaseName, data)
+}
+
+// scrubFile is a file of the library to scrub
+type scrubFile struct {
+	// relPath is the path of the file relative to the library, using "/" as separator
+	relPath string
+	info    os.FileInfo
+}
+
+// scrubTally counts the files of a scrub run, shared by its workers
+type scrubTally struct {
+	verified atomic.Int64
+	added    atomic.Int64
+	updated  atomic.Int64
+	skipped  atomic.Int64
+
+	mu        sync.Mutex
+	corrupted []string
+}
+
+// corrupt records a file whose contents don't match its checksum or can't be read
+func (t *scrubTally) corrupt(relPath string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.corrupted = append(t.corrupted, relPath)
+}
+
+// libraryScrubber implements the LibraryScrubber interface
+type libraryScrubber struct {
+	backup Backup
+	stats  *fileStats
+}
+
+// NewLibraryScrubber creates a new LibraryScrubber instance. Corrupted files are replaced with
+// good copies from backup when ScrubOptions.Bucket is set; backup may be nil otherwise.
+func NewLibraryScrubber(backup Backup) LibraryScrubber {
+	return &libraryScrubber{
+		backup: backup,
+		stats:  &fileStats{extensions: NewExtensions()},
+	}
+}
+
+// Scrub checks the files of libraryDir against their recorded checksums
+func (s *libraryScrubber) Scrub(ctx context.Context, libraryDir string, opts ScrubOptions) (ScrubReport, error) {
+	start, warnings := time.Now(), logger.Warnings()
+	if info, err := os.Stat(libraryDir); err != nil || !info.IsDir() {
+		return ScrubReport{}, fmt.Errorf("not a valid directory: %s", libraryDir)
+	}
+	if opts.Bucket != "" && s.backup == nil {
+		return ScrubReport{}, fmt.Errorf("repairing files from bucket %s needs a backup", opts.Bucket)
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultScrubOptions().MaxConcurrent
+	}
+
+	db, err := loadChecksumDatabase(libraryDir)
+	if err != nil {
+		return ScrubReport{}, err
+	}
+
+	var files []scrubFile
+	err = s.stats.walk(libraryDir, func(path string, info os.FileInfo) {
+		relPath, err := filepath.Rel(libraryDir, path)
+		if err == nil {
+			files = append(files, scrubFile{relPath: filepath.ToSlash(relPath), info: info})
+		}
+	})
+	if err != nil {
+		return ScrubReport{}, fmt.Errorf("failed to list files in %s: %w", libraryDir, err)
+	}
+
+	defer func() {
+		if err := db.save(libraryDir); err != nil {
+			logger.Warn("Failed to record the checksums of the library", "library", libraryDir, "error", err)
+		}
+	}()
+
+	// Files no longer in the library were deleted or renamed on purpose
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file.relPath] = true
+	}
+	removed := 0
+	for relPath := range db.Files {
+		if !present[relPath] {
+			db.remove(relPath)
+			removed++
+		}
+	}
+
+	logger.Info("Scrubbing library", "library", libraryDir, "files", len(files), "interval", opts.Interval)
+	tally := &scrubTally{}
+	var processed atomic.Int64
+	err = runWorkerPool(ctx, files, opts.MaxConcurrent, func(file scrubFile) error {
+		current := processed.Add(1)
+		if opts.ProgressChan != nil {
+			select {
+			case opts.ProgressChan <- ProgressEvent{
+				Stage:   "scrubbing",
+				Current: int(current),
+				Total:   len(files),
+				Message: i18n.T("progress.scrubbing", current, len(files)),
+				File:    file.relPath,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "scrubbing")
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.scrubFile(libraryDir, file, db, opts, tally)
+		return nil
+	})
+	if err != nil {
+		return ScrubReport{}, err
+	}
+
+	report := ScrubReport{
+		Verified:      int(tally.verified.Load()),
+		Added:         int(tally.added.Load()),
+		Updated:       int(tally.updated.Load()),
+		Skipped:       int(tally.skipped.Load()),
+		Removed:       removed,
+		QuarantineDir: filepath.Join(libraryDir, QuarantineDirName),
+	}
+	corrupted := tally.corrupted
+	sort.Strings(corrupted)
+
+	if len(corrupted) > 0 && opts.Bucket != "" {
+		report.Repaired = s.repair(ctx, libraryDir, corrupted, db, opts)
+		corrupted = without(corrupted, report.Repaired)
+	}
+	if len(corrupted) > 0 && opts.Quarantine {
+		report.Quarantined = s.quarantine(libraryDir, corrupted, db)
+		corrupted = without(corrupted, report.Quarantined)
+	}
+	report.Corrupted = corrupted
+
+	logger.Info("Scrub completed", "verified", report.Verified, "added", report.Added, "updated", report.Updated, "repaired", len(report.Repaired), "quarantined", len(report.Quarantined), "corrupted", len(report.Corrupted))
+	report.Warnings = logger.Warnings() - warnings
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// scrubFile checks a file against its recorded checksum, or records the checksum of a new or
+// modified file. A file whose contents changed while its size and modification time didn't is
+// corrupted: editing it would have changed its modification time.
+func (s *libraryScrubber) scrubFile(libraryDir string, file scrubFile, db *checksumDatabase, opts ScrubOptions, tally *scrubTally) {
+	entry, known := db.get(file.relPath)
+	size, modTime := file.info.Size(), file.info.ModTime().UnixNano()
+	unchanged := known && entry.Size == size && entry.ModTime == modTime
+	if unchanged && opts.Interval > 0 && time.Since(entry.Verified) < opts.Interval {
+		tally.skipped.Add(1)
+		return
+	}
+
+	sum, err := fileSHA256(filepath.Join(libraryDir, filepath.FromSlash(file.relPath)))
+	if err != nil {
+		logger.Warn("Failed to read file, it may be corrupted", "file", file.relPath, "error", err)
+		tally.corrupt(file.relPath)
+		return
+	}
+	current := checksumEntry{Size: size, ModTime: modTime, SHA256: hex.EncodeToString(sum), Verified: time.Now()}
+
+	switch {
+	case !known:
+		db.set(file.relPath, current)
+		tally.added.Add(1)
+	case !unchanged:
+		logger.Debug("File modified since the last scrub", "file", file.relPath)
+		db.set(file.relPath, current)
+		tally.updated.Add(1)
+	case current.SHA256 != entry.SHA256:
+		logger.Warn("File contents changed without being modified, it is corrupted", "file", file.relPath, "expected", entry.SHA256, "actual", current.SHA256)
+		tally.corrupt(file.relPath)
+	default:
+		db.set(file.relPath, current)
+		tally.verified.Add(1)
+	}
+}
+
+// repair replaces corrupted files with copies recovered from the backup whose contents match
+// their recorded checksum, and returns the files repaired. Files without a checksum can't be
+// told apart from a bad copy and are left alone.
+func (s *libraryScrubber) repair(ctx context.Context, libraryDir string, corrupted []string, db *checksumDatabase, opts ScrubOptions) []string {
+	expected := make(map[string]string)
+	for _, relPath := range corrupted {
+		if entry, ok := db.get(relPath); ok {
+			expected[relPath] = entry.SHA256
+		}
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+
+	// Recover next to the library so good copies are moved into place rather than copied
+	recoverDir, err := os.MkdirTemp(libraryDir, recoverDirPattern)
+	if err != nil {
+		logger.Warn("Failed to create a directory to recover files to", "library", libraryDir, "error", err)
+		return nil
+	}
+	defer os.RemoveAll(recoverDir)
+
+	restoreOpts := DefaultRestoreOptions()
+	restoreOpts.StagingDir = opts.StagingDir
+	recovered, err := s.backup.RecoverFiles(ctx, opts.Bucket, expected, recoverDir, restoreOpts)
+	if err != nil {
+		logger.Warn("Failed to recover files from the backup", "bucket", opts.Bucket, "error", err)
+		return nil
+	}
+
+	var repaired []string
+	for _, relPath := range recovered {
+		target := filepath.Join(libraryDir, filepath.FromSlash(relPath))
+		if err := os.Rename(filepath.Join(recoverDir, filepath.FromSlash(relPath)), target); err != nil {
+			logger.Warn("Failed to replace corrupted file", "file", relPath, "error", err)
+			continue
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			logger.Warn("Failed to read repaired file", "file", relPath, "error", err)
+			continue
+		}
+		db.set(relPath, checksumEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), SHA256: expected[relPath], Verified: time.Now()})
+		logger.Info("Repaired corrupted file from the backup", "file", relPath)
+		repaired = append(repaired, relPath)
+	}
+	return repaired
+}
+
+// quarantine moves corrupted files out of the library, keeping their paths under
+// QuarantineDirName, and returns the files moved
+func (s *libraryScrubber) quarantine(libraryDir string, corrupted []string, db *checksumDatabase) []string {
+	var quarantined []string
+	for _, relPath := range corrupted {
+		target := filepath.Join(libraryDir, QuarantineDirName, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(target), libraryDirMode); err != nil {
+			logger.Warn("Failed to quarantine corrupted file", "file", relPath, "error", err)
+			continue
+		}
+		if err := os.Rename(filepath.Join(libraryDir, filepath.FromSlash(relPath)), target); err != nil {
+			logger.Warn("Failed to quarantine corrupted file", "file", relPath, "error", err)
+			continue
+		}
+		db.remove(relPath)
+		logger.Info("Quarantined corrupted file", "file", relPath, "quarantine", target)
+		quarantined = append(quarantined, relPath)
+	}
+	return quarantined
+}
+
+// without returns the paths of all that aren't in drop
+func without(all, drop []string) []string {
+	dropped := make(map[string]bool, len(drop))
+	for _, path := range drop {
+		dropped[path] = true
+	}
+	var kept []string
+	for _, path := range all {
+		if !dropped[path] {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// RecoverFiles extracts good copies of files of the library from the archives of their
+// directories, newest first
+func (b *s3Backup) RecoverFiles(ctx context.Context, bucket string, expected map[string]string, recoverDir string, opts RestoreOptions) ([]string, error) {
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer b.storage.Close()
+	b = b.withObjectTimeout(opts.ObjectTimeout)
+	inv, err := b.listBucket(ctx, bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The archives of each directory, newest first
+	wanted := make(map[string]bool)
+	for relPath := range expected {
+		if dirName, _ := splitDateDirectory(relPath); dirName != "." {
+			wanted[dirName] = true
+		}
+	}
+	archives := make(map[string][]StoredObject)
+	for _, obj := range inv.objects() {
+		if isSyncStateKey(obj.Key) || isArchivePartKey(obj.Key) || isIncrementalManifestKey(obj.Key) {
+			continue
+		}
+		if dirName := b.extractDirNameFromKey(obj.Key); wanted[dirName] {
+			archives[dirName] = append(archives[dirName], obj)
+		}
+	}
+
+	space := newStagingSpace(opts.StagingDir)
+	var recovered []string
+	dirNames := make([]string, 0, len(archives))
+	for dirName := range archives {
+		dirNames = append(dirNames, dirName)
+	}
+	sort.Strings(dirNames)
+	for _, dirName := range dirNames {
+		objects := archives[dirName]
+		sort.Slice(objects, func(i, j int) bool {
+			return objects[i].LastModified.After(objects[j].LastModified)
+		})
+		missing := make(map[string]string)
+		for relPath, sum := range expected {
+			if dir, _ := splitDateDirectory(relPath); dir == dirName {
+				missing[relPath] = sum
+			}
+		}
+		for _, obj := range objects {
+			if len(missing) == 0 {
+				break
+			}
+			found, err := b.recoverFromArchive(ctx, bucket, obj, missing, recoverDir, opts, space, inv)
+			if err != nil {
+				logger.Warn("Failed to recover files from archive", "key", obj.Key, "error", err)
+				continue
+			}
+			for _, relPath := range found {
+				delete(missing, relPath)
+			}
+			recovered = append(recovered, found...)
+		}
+	}
+	sort.Strings(recovered)
+	return recovered, nil
+}
+
+// recoverFromArchive extracts an archive, or the parts of a manifest, and moves to recoverDir the
+// files of expected whose SHA-256 matches, returning them
+func (b *s3Backup) recoverFromArchive(ctx context.Context, bucket string, obj StoredObject, expected map[string]string, recoverDir string, opts RestoreOptions, space *stagingSpace, inv *bucketInventory) ([]string, error) {
+	extractDir, err := os.MkdirTemp(recoverDir, recoverDirPattern)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(extractDir)
+
+	if isManifestKey(obj.Key) {
+		_, err = b.restoreParts(ctx, bucket, obj.Key, extractDir, opts, space, inv, nil)
+	} else {
+		_, err = b.downloadAndExtract(ctx, bucket, obj.Key, obj.Size, obj.ETag, extractDir, opts, space, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for relPath, expectedSum := range expected {
+		copyPath := filepath.Join(extractDir, filepath.FromSlash(relPath))
+		sum, err := fileSHA256(copyPath)
+		if err != nil || hex.EncodeToString(sum) != expectedSum {
+			continue
+		}
+		target := filepath.Join(recoverDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(target), libraryDirMode); err != nil {
+			return found, err
+		}
+		if err := os.Rename(copyPath, target); err != nil {
+			return found, err
+		}
+		logger.Debug("Recovered good copy", "file", relPath, "key", obj.Key)
+		found = append(found, relPath)
+	}
+	return found, nil
+}
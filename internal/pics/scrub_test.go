@@ -0,0 +1,194 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// corruptFile replaces the contents of a file with others of the same size, keeping its
+// modification time, like bit rot would
+func corruptFile(t *testing.T, path, content string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(content)) != info.Size() {
+		t.Fatalf("Corrupted contents must keep the size of %s", path)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scrub(t *testing.T, scrubber LibraryScrubber, libraryDir string, opts ScrubOptions) ScrubReport {
+	t.Helper()
+	report, err := scrubber.Scrub(testCtx, libraryDir, opts)
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	return report
+}
+
+func TestLibraryScrubber_Scrub(t *testing.T) {
+	libraryDir := t.TempDir()
+	dirPath := filepath.Join(libraryDir, "2023 06 June 15")
+	beach := writeContentFile(t, dirPath, "2023_06_June_15_00001.jpg", "beach")
+	dinner := writeContentFile(t, dirPath, "2023_06_June_15_00002.jpg", "dinner")
+	writeContentFile(t, filepath.Join(dirPath, "videos"), "2023_06_June_15_00001.mov", "waves")
+	scrubber := NewLibraryScrubber(nil)
+
+	report := scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if report.Added != 3 || report.Verified != 0 || len(report.Corrupted) != 0 {
+		t.Errorf("Expected 3 files added, got %+v", report)
+	}
+
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if report.Verified != 3 || report.Added != 0 {
+		t.Errorf("Expected 3 files verified, got %+v", report)
+	}
+
+	// Modified, deleted and corrupted files
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(dinner, []byte("dessert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dinner, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dirPath, "videos", "2023_06_June_15_00001.mov")); err != nil {
+		t.Fatal(err)
+	}
+	corruptFile(t, beach, "bEach")
+
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if report.Updated != 1 || report.Removed != 1 || report.Verified != 0 {
+		t.Errorf("Expected 1 file updated and 1 removed, got %+v", report)
+	}
+	expected := []string{"2023 06 June 15/2023_06_June_15_00001.jpg"}
+	if !reflect.DeepEqual(report.Corrupted, expected) {
+		t.Errorf("Expected %v corrupted, got %v", expected, report.Corrupted)
+	}
+
+	// A corrupted file is reported until it is dealt with
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if !reflect.DeepEqual(report.Corrupted, expected) || report.Verified != 1 {
+		t.Errorf("Expected %v still corrupted, got %+v", expected, report)
+	}
+}
+
+func TestLibraryScrubber_Scrub_Interval(t *testing.T) {
+	libraryDir := t.TempDir()
+	path := writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	scrubber := NewLibraryScrubber(nil)
+	opts := DefaultScrubOptions()
+	opts.Interval = time.Hour
+
+	scrub(t, scrubber, libraryDir, opts)
+	corruptFile(t, path, "bEach")
+
+	report := scrub(t, scrubber, libraryDir, opts)
+	if report.Skipped != 1 || len(report.Corrupted) != 0 {
+		t.Errorf("Expected the recently verified file skipped, got %+v", report)
+	}
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if len(report.Corrupted) != 1 {
+		t.Errorf("Expected the corrupted file found without an interval, got %+v", report)
+	}
+}
+
+func TestLibraryScrubber_Scrub_Quarantine(t *testing.T) {
+	libraryDir := t.TempDir()
+	dirPath := filepath.Join(libraryDir, "2023 06 June 15")
+	path := writeContentFile(t, dirPath, "2023_06_June_15_00001.jpg", "beach")
+	scrubber := NewLibraryScrubber(nil)
+
+	scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	corruptFile(t, path, "bEach")
+
+	opts := DefaultScrubOptions()
+	opts.Quarantine = true
+	report := scrub(t, scrubber, libraryDir, opts)
+	if len(report.Quarantined) != 1 || len(report.Corrupted) != 0 {
+		t.Errorf("Expected the corrupted file quarantined, got %+v", report)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupted file moved out of the library")
+	}
+	data, err := os.ReadFile(filepath.Join(libraryDir, QuarantineDirName, "2023 06 June 15", "2023_06_June_15_00001.jpg"))
+	if err != nil || string(data) != "bEach" {
+		t.Errorf("Expected the corrupted file in quarantine, got %q (%v)", data, err)
+	}
+
+	// Quarantined files are neither scrubbed nor reported as removed
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if report.Verified != 0 || report.Removed != 0 || len(report.Corrupted) != 0 {
+		t.Errorf("Expected nothing left to scrub, got %+v", report)
+	}
+}
+
+func TestLibraryScrubber_Scrub_RepairFromBackup(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	libraryDir := t.TempDir()
+	dirName := "2023 06 June 15"
+	beach := writeContentFile(t, filepath.Join(libraryDir, dirName), "2023_06_June_15_00001.jpg", "beach")
+	dinner := writeContentFile(t, filepath.Join(libraryDir, dirName), "2023_06_June_15_00002.jpg", "dinner")
+	scrubber := NewLibraryScrubber(backup)
+
+	if _, err := backup.BackupDirectories(testCtx, libraryDir, bucket, BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+
+	// The backup only holds a good copy of the first file
+	newer := time.Now().Add(time.Hour)
+	if err := os.WriteFile(dinner, []byte("dinner at eight"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dinner, newer, newer); err != nil {
+		t.Fatal(err)
+	}
+	scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	corruptFile(t, beach, "bEach")
+	corruptFile(t, dinner, "dinner at EIGHT")
+
+	opts := DefaultScrubOptions()
+	opts.Bucket = bucket
+	opts.Quarantine = true
+	report := scrub(t, scrubber, libraryDir, opts)
+	if !reflect.DeepEqual(report.Repaired, []string{dirName + "/2023_06_June_15_00001.jpg"}) {
+		t.Errorf("Expected the first file repaired, got %+v", report)
+	}
+	if !reflect.DeepEqual(report.Quarantined, []string{dirName + "/2023_06_June_15_00002.jpg"}) {
+		t.Errorf("Expected the file without a good copy quarantined, got %+v", report)
+	}
+	data, err := os.ReadFile(beach)
+	if err != nil || string(data) != "beach" {
+		t.Errorf("Expected the good copy in the library, got %q (%v)", data, err)
+	}
+
+	report = scrub(t, scrubber, libraryDir, DefaultScrubOptions())
+	if report.Verified != 1 || len(report.Corrupted) != 0 {
+		t.Errorf("Expected the repaired file verified, got %+v", report)
+	}
+	if got := listDir(t, libraryDir); !reflect.DeepEqual(got, []string{libraryActivityName, checksumDatabaseName, QuarantineDirName, dirName}) {
+		t.Errorf("Expected no recovery leftovers in the library, got %v", got)
+	}
+}
+
+func TestLibraryScrubber_Scrub_BucketWithoutBackup(t *testing.T) {
+	opts := DefaultScrubOptions()
+	opts.Bucket = "test-bucket"
+	if _, err := NewLibraryScrubber(nil).Scrub(testCtx, t.TempDir(), opts); err == nil {
+		t.Error("Expected error when repairing without a backup")
+	}
+}
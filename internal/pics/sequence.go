@@ -0,0 +1,118 @@
+package pics
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// SequenceOrder decides the order files of a directory are numbered in
+// ({baseName}_00001.ext, {baseName}_00002.ext, ...)
+type SequenceOrder string
+
+const (
+	// SequenceByDate numbers files by capture date, then by filename
+	SequenceByDate SequenceOrder = "date"
+	// SequenceByCapture numbers files by capture time, corrected by the UTC offset the camera
+	// recorded so shots taken either side of a DST change stay in order, then by camera model,
+	// sub-second time and original filename. Bursts of photos taken in the same second by
+	// several cameras are kept together per camera rather than interleaved by filename.
+	SequenceByCapture SequenceOrder = "capture"
+)
+
+// ParseSequenceOrder parses "date" or "capture"
+func ParseSequenceOrder(s string) (SequenceOrder, error) {
+	switch order := SequenceOrder(s); order {
+	case SequenceByDate, SequenceByCapture:
+		return order, nil
+	}
+	return "", fmt.Errorf("invalid sequence order %q (expected date or capture)", s)
+}
+
+// sequenceFields are the EXIF fields SequenceByCapture orders files of the same date by
+var sequenceFields = []string{"Model", "SubSecTimeOriginal", "OffsetTimeOriginal", ExifOriginalFileName}
+
+// sequencedFile is a file to number with the values it is ordered by
+type sequencedFile struct {
+	path string
+	date time.Time
+	// model is the camera model ("" = unknown)
+	model string
+	// subSec is the sub-second part of the capture time in nanoseconds (0 = unknown)
+	subSec int
+	// originalName is the name the file had before pics renamed it, or its name
+	originalName string
+}
+
+// readSequenceFields fills in the values SequenceByCapture orders file by. A file whose
+// metadata can't be read is ordered by its date and name only.
+func readSequenceFields(metadata MetadataReader, file *sequencedFile) {
+	file.originalName = filepath.Base(file.path)
+
+	values, err := metadata.ReadFields(file.path, sequenceFields)
+	if err != nil {
+		logger.Debug("Failed to read sequence fields", "file", file.path, "error", err)
+		return
+	}
+	file.model = values["Model"]
+	file.subSec = parseSubSec(values["SubSecTimeOriginal"])
+	if name := values[ExifOriginalFileName]; name != "" {
+		file.originalName = name
+	}
+	file.date = withUTCOffset(file.date, values["OffsetTimeOriginal"])
+}
+
+// parseSubSec converts the digits of an EXIF SubSecTime field, the fraction of a second
+// (e.g. "5" = 0.5s, "05" = 0.05s), to nanoseconds. Invalid values count as 0.
+func parseSubSec(digits string) int {
+	digits = strings.TrimSpace(digits)
+	if digits == "" || len(digits) > 9 {
+		return 0
+	}
+	nanos, err := strconv.Atoi(digits + strings.Repeat("0", 9-len(digits)))
+	if err != nil || nanos < 0 {
+		return 0
+	}
+	return nanos
+}
+
+// withUTCOffset places an EXIF date, which is read as UTC because EXIF dates carry no zone, in
+// the zone of offset (e.g. "+02:00"), so dates taken in different zones or either side of a
+// DST change compare by the instant they were taken. Other dates are returned as they are.
+func withUTCOffset(date time.Time, offset string) time.Time {
+	if offset == "" || date.Location() != time.UTC {
+		return date
+	}
+	zone, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return date
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), zone.Location())
+}
+
+// sortForSequence sorts files in the order they are numbered in
+func sortForSequence(files []sequencedFile, order SequenceOrder) {
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if !a.date.Equal(b.date) {
+			return a.date.Before(b.date)
+		}
+		if order == SequenceByCapture {
+			if a.model != b.model {
+				return a.model < b.model
+			}
+			if a.subSec != b.subSec {
+				return a.subSec < b.subSec
+			}
+			if a.originalName != b.originalName {
+				return a.originalName < b.originalName
+			}
+		}
+		return a.path < b.path
+	})
+}
@@ -0,0 +1,106 @@
+package pics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSequenceOrder(t *testing.T) {
+	for _, s := range []string{"date", "capture"} {
+		if order, err := ParseSequenceOrder(s); err != nil || string(order) != s {
+			t.Errorf("ParseSequenceOrder(%q) = %q, %v", s, order, err)
+		}
+	}
+	if _, err := ParseSequenceOrder("camera"); err == nil {
+		t.Error("Expected an error for an unknown order")
+	}
+}
+
+func TestSortForSequence(t *testing.T) {
+	burst := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+	files := func() []sequencedFile {
+		return []sequencedFile{
+			{path: "a/CAM_0002.jpg", date: burst, model: "iPhone 14", subSec: 500000000, originalName: "CAM_0002.jpg"},
+			{path: "a/DSC_0101.jpg", date: burst, model: "NIKON D750", subSec: 200000000, originalName: "DSC_0101.jpg"},
+			{path: "a/CAM_0001.jpg", date: burst, model: "iPhone 14", subSec: 100000000, originalName: "CAM_0001.jpg"},
+			{path: "a/DSC_0100.jpg", date: burst, model: "NIKON D750", subSec: 0, originalName: "DSC_0100.jpg"},
+			{path: "a/early.jpg", date: burst.Add(-time.Hour), model: "NIKON D750"},
+		}
+	}
+	paths := func(files []sequencedFile) []string {
+		var result []string
+		for _, file := range files {
+			result = append(result, file.path)
+		}
+		return result
+	}
+
+	byDate := files()
+	sortForSequence(byDate, SequenceByDate)
+	expected := []string{"a/early.jpg", "a/CAM_0001.jpg", "a/CAM_0002.jpg", "a/DSC_0100.jpg", "a/DSC_0101.jpg"}
+	if got := paths(byDate); !reflect.DeepEqual(got, expected) {
+		t.Errorf("SequenceByDate: expected %v, got %v", expected, got)
+	}
+
+	byCapture := files()
+	sortForSequence(byCapture, SequenceByCapture)
+	expected = []string{"a/early.jpg", "a/DSC_0100.jpg", "a/DSC_0101.jpg", "a/CAM_0001.jpg", "a/CAM_0002.jpg"}
+	if got := paths(byCapture); !reflect.DeepEqual(got, expected) {
+		t.Errorf("SequenceByCapture: expected %v, got %v", expected, got)
+	}
+}
+
+func TestSortForSequence_OriginalName(t *testing.T) {
+	date := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+	files := []sequencedFile{
+		{path: "a/2023_06_June_15_00001.jpg", date: date, originalName: "IMG_0009.jpg"},
+		{path: "a/2023_06_June_15_00002.jpg", date: date, originalName: "IMG_0008.jpg"},
+	}
+
+	// Files renamed by an earlier import keep the order of their original names
+	sortForSequence(files, SequenceByCapture)
+	if files[0].originalName != "IMG_0008.jpg" {
+		t.Errorf("Expected IMG_0008.jpg first, got %s", files[0].originalName)
+	}
+}
+
+func TestParseSubSec(t *testing.T) {
+	tests := map[string]int{
+		"5":          500000000,
+		"05":         50000000,
+		"123":        123000000,
+		" 42 ":       420000000,
+		"":           0,
+		"abc":        0,
+		"1234567890": 0,
+	}
+	for digits, expected := range tests {
+		if got := parseSubSec(digits); got != expected {
+			t.Errorf("parseSubSec(%q) = %d, expected %d", digits, got, expected)
+		}
+	}
+}
+
+func TestWithUTCOffset(t *testing.T) {
+	// 01:30 after the clocks went back (+01:00) was taken after 01:45 before the change (+02:00)
+	before := withUTCOffset(time.Date(2023, 10, 29, 1, 45, 0, 0, time.UTC), "+02:00")
+	after := withUTCOffset(time.Date(2023, 10, 29, 1, 30, 0, 0, time.UTC), "+01:00")
+	if !before.Before(after) {
+		t.Errorf("Expected %v before %v", before, after)
+	}
+
+	date := time.Date(2023, 6, 15, 10, 0, 0, 0, time.UTC)
+	if got := withUTCOffset(date, ""); !got.Equal(date) {
+		t.Errorf("Expected a date without offset unchanged, got %v", got)
+	}
+	if got := withUTCOffset(date, "bogus"); !got.Equal(date) {
+		t.Errorf("Expected a date with an invalid offset unchanged, got %v", got)
+	}
+	local := time.Date(2023, 6, 15, 10, 0, 0, 0, time.Local)
+	if time.Local != time.UTC {
+		if got := withUTCOffset(local, "+02:00"); !got.Equal(local) {
+			t.Errorf("Expected a modification time unchanged, got %v", got)
+		}
+	}
+}
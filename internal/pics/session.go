@@ -0,0 +1,213 @@
+package pics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// sessionJournalName is the file in each temporary directory of a session describing the run
+// that created it, so the directory can be recovered if the run is interrupted
+const sessionJournalName = ".pics-session.json"
+
+// ParseSessionsDirName is the directory of a library holding the temporary directories of the
+// parses into it. Being on the drive of the library, they outlive reboots that clear the system
+// temp directory, and their files are moved into the library by renaming them.
+const ParseSessionsDirName = ".pics-session"
+
+// sessionFilesDirName is the directory of a parse session holding the files it copied, apart
+// from the journal
+const sessionFilesDirName = "files"
+
+// Kinds of temporary directories, which tell what their leftovers hold
+const (
+	// SessionKindParse holds the files a parse copied and compressed before organising them
+	SessionKindParse = "parse"
+	// SessionKindBackup holds an archive being created for upload
+	SessionKindBackup = "backup"
+	// SessionKindRestore holds an archive being downloaded for extraction
+	SessionKindRestore = "restore"
+)
+
+// Stages of a parse session
+const (
+	// SessionStageCopying is set while files are copied and compressed; they may be incomplete
+	SessionStageCopying = "copying"
+	// SessionStageOrganising is set once every file is copied, while they are moved to the target
+	SessionStageOrganising = "organising"
+)
+
+// sessionID identifies the current run of pics in the names and journals of its temporary
+// directories
+var sessionID = newSessionID()
+
+// newSessionID returns a session ID made of the start time and a random suffix,
+// e.g. "20231015-143005-9f2c"
+func newSessionID() string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Session describes a temporary directory pics created for a run
+type Session struct {
+	// ID identifies the run; the temporary directories of one run share it
+	ID string `json:"id"`
+	// Kind is what the directory holds: SessionKindParse, SessionKindBackup or SessionKindRestore
+	Kind string `json:"kind"`
+	// Stage is how far a parse got (SessionStageCopying or SessionStageOrganising)
+	Stage string `json:"stage,omitempty"`
+	// PID is the process ID of the run
+	PID int `json:"pid"`
+	// Host is the name of the machine the run was on
+	Host string `json:"host"`
+	// Started is when the directory was created
+	Started time.Time `json:"started"`
+	// Target is the library a parse imports into ("" for other kinds)
+	Target string `json:"target,omitempty"`
+	// AssignedDate is the date a parse imports every file under (nil = their own dates)
+	AssignedDate *ApproximateDate `json:"assignedDate,omitempty"`
+	// SequenceOrder is the order a parse numbers files in
+	SequenceOrder SequenceOrder `json:"sequenceOrder,omitempty"`
+	// TimeZone is the time zone a parse dates files in, as ParseTimeZone reads it ("" = their own)
+	TimeZone string `json:"timeZone,omitempty"`
+	// Args are the command line arguments of the run, without the program name
+	Args []string `json:"args"`
+	// Dir is the temporary directory
+	Dir string `json:"-"`
+}
+
+// sessionDirPrefix returns the prefix of the names of the temporary directories of kind
+// created by the current session
+func sessionDirPrefix(kind string) string {
+	return "pics-" + kind + "-" + sessionID + "-"
+}
+
+// createSessionDir creates a temporary directory of kind inside parentDir ("" = the system temp
+// directory) with the journal of the current session
+func createSessionDir(parentDir, kind, target string) (*Session, error) {
+	dir, err := os.MkdirTemp(parentDir, sessionDirPrefix(kind)+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	session := &Session{
+		ID:      sessionID,
+		Kind:    kind,
+		PID:     os.Getpid(),
+		Host:    host,
+		Started: time.Now(),
+		Target:  target,
+		Args:    os.Args[1:],
+		Dir:     dir,
+	}
+	if err := session.save(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return session, nil
+}
+
+// setStage records how far the session got
+func (s *Session) setStage(stage string) error {
+	s.Stage = stage
+	return s.save()
+}
+
+// save writes the journal of the session to its directory
+func (s *Session) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session journal: %w", err)
+	}
+	if err := writeFileAtomically(s.Dir, sessionJournalName, data); err != nil {
+		return fmt.Errorf("failed to write session journal: %w", err)
+	}
+	return nil
+}
+
+// Orphaned reports whether the run of the session ended without removing its directory, e.g.
+// because it crashed or was killed. Sessions of other machines, such as in a staging directory
+// on a shared drive, are never reported orphaned since their process can't be checked.
+func (s Session) Orphaned() bool {
+	host, _ := os.Hostname()
+	if s.Host != host {
+		return false
+	}
+	return !processRunning(s.PID)
+}
+
+// Adoptable reports whether the files of the session can be organised into its target: only
+// those of a parse that copied every file
+func (s Session) Adoptable() bool {
+	return s.Kind == SessionKindParse && s.Stage == SessionStageOrganising && s.Target != ""
+}
+
+// FindOrphanedSessions returns the temporary directories left in dirs by runs that ended
+// without removing them, oldest first. Directories that don't exist are skipped.
+func FindOrphanedSessions(dirs ...string) ([]Session, error) {
+	var orphaned []Session
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "pics-") {
+				continue
+			}
+			session, err := loadSession(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				logger.Debug("Skipping directory without a readable session journal", "dir", entry.Name(), "error", err)
+				continue
+			}
+			if session.Orphaned() {
+				orphaned = append(orphaned, session)
+			}
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool {
+		return orphaned[i].Started.Before(orphaned[j].Started)
+	})
+	return orphaned, nil
+}
+
+// loadSession reads the journal of a session directory
+func loadSession(dir string) (Session, error) {
+	data, err := os.ReadFile(filepath.Join(dir, sessionJournalName))
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, fmt.Errorf("invalid session journal: %w", err)
+	}
+	session.Dir = dir
+	return session, nil
+}
+
+// CleanSession removes the temporary directory of a session
+func CleanSession(session Session) error {
+	if err := os.RemoveAll(session.Dir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", session.Dir, err)
+	}
+	return nil
+}
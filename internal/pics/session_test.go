@@ -0,0 +1,132 @@
+package pics
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exitedPID returns the process ID of a process that has already exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Can't run a process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+// orphanSession rewrites the journal of a session directory as if its run had died
+func orphanSession(t *testing.T, session *Session, pid int) {
+	t.Helper()
+	session.PID = pid
+	if err := session.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateSessionDir(t *testing.T) {
+	parentDir := t.TempDir()
+	session, err := createSessionDir(parentDir, SessionKindParse, "/pics")
+	if err != nil {
+		t.Fatalf("createSessionDir failed: %v", err)
+	}
+
+	if !strings.HasPrefix(filepath.Base(session.Dir), "pics-parse-"+sessionID+"-") {
+		t.Errorf("Expected the directory tagged with the session ID, got %s", session.Dir)
+	}
+	loaded, err := loadSession(session.Dir)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	if loaded.ID != sessionID || loaded.Kind != SessionKindParse || loaded.PID != os.Getpid() || loaded.Target != "/pics" {
+		t.Errorf("Unexpected journal: %+v", loaded)
+	}
+	if loaded.Orphaned() {
+		t.Error("Expected the session of this run not orphaned")
+	}
+}
+
+func TestFindOrphanedSessions(t *testing.T) {
+	parentDir := t.TempDir()
+	running, err := createSessionDir(parentDir, SessionKindBackup, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := createSessionDir(parentDir, SessionKindParse, "/pics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphanSession(t, crashed, exitedPID(t))
+	if err := crashed.setStage(SessionStageOrganising); err != nil {
+		t.Fatal(err)
+	}
+	// Directories without a journal aren't sessions
+	if err := os.Mkdir(filepath.Join(parentDir, "pics-other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := FindOrphanedSessions(parentDir, parentDir, filepath.Join(parentDir, "missing"))
+	if err != nil {
+		t.Fatalf("FindOrphanedSessions failed: %v", err)
+	}
+	if len(orphaned) != 1 || orphaned[0].Dir != crashed.Dir {
+		t.Fatalf("Expected only the crashed session, got %+v", orphaned)
+	}
+	if !orphaned[0].Adoptable() {
+		t.Error("Expected a parse that copied every file adoptable")
+	}
+	if _, err := os.Stat(running.Dir); err != nil {
+		t.Errorf("Expected the running session left alone: %v", err)
+	}
+}
+
+func TestFindOrphanedSessions_OtherHost(t *testing.T) {
+	parentDir := t.TempDir()
+	session, err := createSessionDir(parentDir, SessionKindRestore, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Host = "another-machine"
+	orphanSession(t, session, exitedPID(t))
+
+	orphaned, err := FindOrphanedSessions(parentDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("Expected sessions of other machines never orphaned, got %+v", orphaned)
+	}
+}
+
+func TestSession_Adoptable(t *testing.T) {
+	tests := []struct {
+		session  Session
+		expected bool
+	}{
+		{Session{Kind: SessionKindParse, Stage: SessionStageOrganising, Target: "/pics"}, true},
+		{Session{Kind: SessionKindParse, Stage: SessionStageCopying, Target: "/pics"}, false},
+		{Session{Kind: SessionKindBackup, Stage: SessionStageOrganising, Target: "/pics"}, false},
+		{Session{Kind: SessionKindParse, Stage: SessionStageOrganising}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.session.Adoptable(); got != tt.expected {
+			t.Errorf("Adoptable() of %+v = %v, expected %v", tt.session, got, tt.expected)
+		}
+	}
+}
+
+func TestCleanSession(t *testing.T) {
+	session, err := createSessionDir(t.TempDir(), SessionKindBackup, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CleanSession(*session); err != nil {
+		t.Fatalf("CleanSession failed: %v", err)
+	}
+	if _, err := os.Stat(session.Dir); !os.IsNotExist(err) {
+		t.Error("Expected the session directory removed")
+	}
+}
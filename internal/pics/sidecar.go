@@ -0,0 +1,86 @@
+package pics
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// sidecarDirName is the directory next to the copies of videos where their sidecar files are
+// copied. The organiser skips directories and the stats skip dot directories, so sidecars
+// never reach the library.
+const sidecarDirName = ".sidecars"
+
+// sidecarSuffixes are the endings, after the name of the video without extension, of the
+// sidecar files camcorders write the recording date to:
+//
+//   - .THM: JPEG thumbnail of Canon and older Sony cameras, with the date in EXIF.
+//   - .MOI: index file of JVC and Panasonic MOD/TOD footage.
+//   - M01.XML: Sony XAVC and AVCHD metadata, such as C0001M01.XML for C0001.MP4.
+//   - .XML: metadata of other professional camcorders.
+var sidecarSuffixes = []string{".THM", ".MOI", "M01.XML", ".XML"}
+
+// findSidecars returns the sidecar files next to videoPath, in the order of sidecarSuffixes.
+// The video itself doesn't need to exist.
+func findSidecars(videoPath string) []string {
+	stem := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	var sidecars []string
+	for _, suffix := range sidecarSuffixes {
+		for _, candidate := range []string{stem + suffix, stem + strings.ToLower(suffix)} {
+			if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+				sidecars = append(sidecars, candidate)
+				break
+			}
+		}
+	}
+	return sidecars
+}
+
+// copySidecars copies the sidecar files of the video at srcPath to the sidecar directory next
+// to its copy at destPath, renamed after the copy so the date extractor finds them
+func copySidecars(srcPath, destPath string) error {
+	srcStem := strings.TrimSuffix(filepath.Base(srcPath), filepath.Ext(srcPath))
+	destStem := strings.TrimSuffix(filepath.Base(destPath), filepath.Ext(destPath))
+	sidecarDir := filepath.Join(filepath.Dir(destPath), sidecarDirName)
+	for _, sidecar := range findSidecars(srcPath) {
+		if err := os.MkdirAll(sidecarDir, libraryDirMode); err != nil {
+			return fmt.Errorf("failed to create sidecar directory: %w", err)
+		}
+		name := destStem + strings.TrimPrefix(filepath.Base(sidecar), srcStem)
+		if err := copyFilePreserveTime(sidecar, filepath.Join(sidecarDir, name)); err != nil {
+			return fmt.Errorf("failed to copy sidecar %s: %w", sidecar, err)
+		}
+		logger.Debug("Copied sidecar", "video", srcPath, "sidecar", sidecar)
+	}
+	return nil
+}
+
+// sidecarXMLMetadata is the part of the XML sidecars of Sony camcorders holding the date,
+// such as <CreationDate value="2023-06-15T10:00:00+02:00"/>
+type sidecarXMLMetadata struct {
+	CreationDate struct {
+		Value string `xml:"value,attr"`
+	} `xml:"CreationDate"`
+}
+
+// readSidecarXMLDate reads the creation date of an XML sidecar file
+func readSidecarXMLDate(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var metadata sidecarXMLMetadata
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if metadata.CreationDate.Value == "" {
+		return time.Time{}, errors.New("no CreationDate in XML sidecar")
+	}
+	return time.Parse(time.RFC3339, metadata.CreationDate.Value)
+}
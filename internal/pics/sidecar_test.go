@@ -0,0 +1,89 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sonyXMLSidecar is a trimmed down XML sidecar of a Sony camcorder
+const sonyXMLSidecar = `<?xml version="1.0" encoding="UTF-8"?>
+<NonRealTimeMeta xmlns="urn:schemas-professionalDisc:nonRealTimeMeta:ver.2.00" lastUpdate="2023-06-15T10:05:00+02:00">
+	<Duration value="1500"/>
+	<CreationDate value="2023-06-15T10:00:00+02:00"/>
+</NonRealTimeMeta>
+`
+
+func writeSidecar(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+	return path
+}
+
+func TestFindSidecars(t *testing.T) {
+	dir := t.TempDir()
+	thm := writeSidecar(t, dir, "MVI_0001.THM", "thumbnail")
+	xml := writeSidecar(t, dir, "C0001M01.XML", sonyXMLSidecar)
+	moi := writeSidecar(t, dir, "mov001.moi", "index")
+	writeSidecar(t, dir, "MVI_0002.THM", "thumbnail of another video")
+
+	tests := []struct {
+		video    string
+		expected []string
+	}{
+		{"MVI_0001.AVI", []string{thm}},
+		{"C0001.MP4", []string{xml}},
+		{"mov001.mod", []string{moi}},
+		{"MVI_0003.AVI", nil},
+	}
+	for _, tt := range tests {
+		if got := findSidecars(filepath.Join(dir, tt.video)); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("findSidecars(%s) = %v, expected %v", tt.video, got, tt.expected)
+		}
+	}
+}
+
+func TestCopySidecars(t *testing.T) {
+	sourceDir, tmpTarget := t.TempDir(), t.TempDir()
+	writeSidecar(t, sourceDir, "C0001M01.XML", sonyXMLSidecar)
+
+	if err := copySidecars(filepath.Join(sourceDir, "C0001.MP4"), filepath.Join(tmpTarget, "root-C0001.MP4")); err != nil {
+		t.Fatalf("copySidecars failed: %v", err)
+	}
+	assertMediaFileExists(t, filepath.Join(tmpTarget, sidecarDirName, "root-C0001M01.XML"))
+
+	// Videos without sidecars leave no sidecar directory behind
+	emptyTarget := t.TempDir()
+	if err := copySidecars(filepath.Join(sourceDir, "C0002.MP4"), filepath.Join(emptyTarget, "root-C0002.MP4")); err != nil {
+		t.Fatalf("copySidecars failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(emptyTarget, sidecarDirName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no sidecar directory, got %v", err)
+	}
+}
+
+func TestReadSidecarXMLDate(t *testing.T) {
+	dir := t.TempDir()
+	date, err := readSidecarXMLDate(writeSidecar(t, dir, "C0001M01.XML", sonyXMLSidecar))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := time.Date(2023, 6, 15, 10, 0, 0, 0, time.FixedZone("", 2*60*60))
+	if !date.Equal(expected) || date.Day() != 15 || date.Hour() != 10 {
+		t.Errorf("Expected %v in the time zone of the camera, got %v", expected, date)
+	}
+
+	for name, content := range map[string]string{
+		"nodate.XML":  "<NonRealTimeMeta><Duration value=\"1500\"/></NonRealTimeMeta>",
+		"invalid.XML": "not xml",
+	} {
+		if _, err := readSidecarXMLDate(writeSidecar(t, dir, name, content)); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// archiveExtension is the suffix of every archive key
+	archiveExtension = ".tar.gz"
+	// manifestExtension is the suffix of the key listing the parts of a split directory
+	manifestExtension = ".manifest.json"
+)
+
+// archivePartKeyPattern matches the keys of split archive parts, e.g. "... (3 images, 1 videos).part-0001.tar.gz"
+var archivePartKeyPattern = regexp.MustCompile(`\.part-\d{4,}\.tar\.gz$`)
+
+// archiveManifest binds together the archives of a directory split into parts.
+// It is uploaded after all its parts, so its presence means the backup is complete.
+type archiveManifest struct {
+	// Directory is the name of the backed up directory
+	Directory string `json:"directory"`
+	// Parts lists the archives in the order they were created
+	Parts []archivePart `json:"parts"`
+}
+
+// archivePart describes a single archive of a split directory
+type archivePart struct {
+	// Key is the S3 key of the archive
+	Key string `json:"key"`
+	// Size is the size of the archive in bytes
+	Size int64 `json:"size"`
+}
+
+// filePart holds files archived together, with the total size of their contents
+type filePart struct {
+	paths []string
+	size  int64
+}
+
+// archivePartKey returns the key of the index-th part (starting at 1) of a split directory
+func archivePartKey(baseKey string, index int) string {
+	return fmt.Sprintf("%s.part-%04d%s", baseKey, index, archiveExtension)
+}
+
+// isArchivePartKey reports whether key is a part of a split directory rather than a whole archive
+func isArchivePartKey(key string) bool {
+	return archivePartKeyPattern.MatchString(key)
+}
+
+// isManifestKey reports whether key is the manifest of a split directory
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestExtension)
+}
+
+// planArchiveParts groups the files of dirPath, leaving out paths matched by skip, into parts
+// holding at most maxSize bytes each. Files keep their walk order, so a directory always
+// splits the same way. A file larger than maxSize gets a part of its own.
+func planArchiveParts(dirPath string, maxSize int64, skip skipFunc) ([]filePart, error) {
+	var parts []filePart
+	current := filePart{}
+
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dirPath {
+			return nil
+		}
+		if skip.skips(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if info.Size() > maxSize {
+			logger.Warn("File is larger than the archive size limit, archiving it on its own", "file", path, "size", info.Size(), "limit", maxSize)
+		}
+		if len(current.paths) > 0 && current.size+info.Size() > maxSize {
+			parts = append(parts, current)
+			current = filePart{}
+		}
+		current.paths = append(current.paths, path)
+		current.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(current.paths) > 0 {
+		parts = append(parts, current)
+	}
+	return parts, nil
+}
+
+// skipOutside extends skip to also leave out files that are not in part.
+// Directories are kept so every part recreates the directory tree.
+func (p filePart) skipOutside(skip skipFunc) skipFunc {
+	inPart := make(map[string]bool, len(p.paths))
+	for _, path := range p.paths {
+		inPart[path] = true
+	}
+	return func(path string, isDir bool) bool {
+		if skip.skips(path, isDir) {
+			return true
+		}
+		return !isDir && !inPart[path]
+	}
+}
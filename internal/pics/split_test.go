@@ -0,0 +1,102 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSizedFile(t *testing.T, dir, filename string, size int) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create directory %s: %v", dir, err)
+	}
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatalf("Failed to create file %s: %v", filename, err)
+	}
+	return path
+}
+
+func TestPlanArchiveParts(t *testing.T) {
+	dir := t.TempDir()
+	a := writeSizedFile(t, dir, "a.jpg", 40)
+	b := writeSizedFile(t, dir, "b.jpg", 50)
+	c := writeSizedFile(t, dir, "c.jpg", 20)
+	big := writeSizedFile(t, filepath.Join(dir, "videos"), "big.mov", 250)
+	small := writeSizedFile(t, filepath.Join(dir, "videos"), "small.mov", 10)
+	writeSizedFile(t, filepath.Join(dir, "excluded"), "skipped.jpg", 90)
+
+	skip := excludeDirsSkip(dir, []string{"excluded"})
+	parts, err := planArchiveParts(dir, 100, skip)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []filePart{
+		{paths: []string{a, b}, size: 90},
+		{paths: []string{c}, size: 20},
+		{paths: []string{big}, size: 250},
+		{paths: []string{small}, size: 10},
+	}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, parts)
+	}
+}
+
+func TestPlanArchiveParts_EmptyDirectory(t *testing.T) {
+	parts, err := planArchiveParts(t.TempDir(), 100, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(parts) != 0 {
+		t.Errorf("Expected no parts, got %+v", parts)
+	}
+}
+
+func TestFilePart_SkipOutside(t *testing.T) {
+	dir := t.TempDir()
+	part := filePart{paths: []string{filepath.Join(dir, "a.jpg")}}
+	skip := part.skipOutside(excludeDirsSkip(dir, []string{"excluded"}))
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{path: filepath.Join(dir, "a.jpg"), expected: false},
+		{path: filepath.Join(dir, "b.jpg"), expected: true},
+		{path: filepath.Join(dir, "videos"), isDir: true, expected: false},
+		{path: filepath.Join(dir, "excluded"), isDir: true, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			if result := skip.skips(tt.path, tt.isDir); result != tt.expected {
+				t.Errorf("skips(%s) = %v, expected %v", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestArchiveKeys(t *testing.T) {
+	baseKey := "2023 06 June 15 trip (3 images, 1 videos)"
+
+	partKey := archivePartKey(baseKey, 2)
+	if partKey != "2023 06 June 15 trip (3 images, 1 videos).part-0002.tar.gz" {
+		t.Errorf("Unexpected part key: %s", partKey)
+	}
+	if !isArchivePartKey(partKey) {
+		t.Errorf("Expected %s to be a part key", partKey)
+	}
+	if isArchivePartKey(baseKey + archiveExtension) {
+		t.Error("Expected whole archive not to be a part key")
+	}
+	if !isManifestKey(baseKey + manifestExtension) {
+		t.Error("Expected manifest key to be recognised")
+	}
+	if isManifestKey(partKey) {
+		t.Error("Expected part key not to be a manifest key")
+	}
+}
@@ -0,0 +1,343 @@
+package pics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+)
+
+// stagingIndexName is the file listing the archives kept in a staging directory between the
+// archive and upload phases of a backup
+const stagingIndexName = ".pics-staged.json"
+
+// archiveHashes are the hashes an archive is compared by before it is uploaded
+type archiveHashes struct {
+	// MD5 is the hex encoded MD5 hash of the archive, compared with the ETag of existing objects
+	MD5 string `json:"md5"`
+	// MultipartETag is the ETag S3 gives the archive when it is streamed in parts, compared with
+	// the ETag of existing multipart objects ("" = not calculated)
+	MultipartETag string `json:"multipart_etag,omitempty"`
+	// SHA256 is the base64 encoded SHA-256 checksum S3 verifies on upload ("" = not calculated)
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// stagedArchive is an archive waiting in the staging directory to be uploaded
+type stagedArchive struct {
+	// Key is the key the archive is uploaded under
+	Key string `json:"key"`
+	// Directory is the name of the backed up directory
+	Directory string `json:"directory"`
+	// File is the name of the archive in the staging directory
+	File string `json:"file"`
+	// Size is the size of the archive in bytes
+	Size int64 `json:"size"`
+	// Hashes are the hashes calculated when the archive was created
+	Hashes archiveHashes `json:"hashes"`
+}
+
+// stagingIndex lists the archives of a staging directory in the order they were created,
+// so the parts of a split directory are uploaded before its manifest
+type stagingIndex struct {
+	Archives []stagedArchive `json:"archives"`
+
+	dir string
+	mu  sync.Mutex
+}
+
+// loadStagingIndex reads the index of a staging directory, or returns an empty one if there is none
+func loadStagingIndex(dir string) (*stagingIndex, error) {
+	index := &stagingIndex{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, stagingIndexName))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to read staged archives of %s: %w", dir, err)
+	}
+	return index, nil
+}
+
+// save writes the index to the staging directory, replacing the previous one at once, or
+// removes it once no archives are left. The caller must hold the lock.
+func (s *stagingIndex) save() error {
+	path := filepath.Join(s.dir, stagingIndexName)
+	if len(s.Archives) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".pics-staged-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// find returns the position of the archive staged under key, or -1. The caller must hold the lock.
+func (s *stagingIndex) find(key string) int {
+	for i, archive := range s.Archives {
+		if archive.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// stored returns the size of the archive staged under key, if its file is still there
+func (s *stagingIndex) stored(key string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(key)
+	if i < 0 {
+		return 0, false
+	}
+	info, err := os.Stat(filepath.Join(s.dir, s.Archives[i].File))
+	if err != nil || info.Size() != s.Archives[i].Size {
+		return 0, false
+	}
+	return s.Archives[i].Size, true
+}
+
+// add records an archive moved into the staging directory
+func (s *stagingIndex) add(archive stagedArchive) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(archive.Key); i >= 0 {
+		s.Archives[i] = archive
+	} else {
+		s.Archives = append(s.Archives, archive)
+	}
+	return s.save()
+}
+
+// remove forgets an uploaded archive and deletes its file
+func (s *stagingIndex) remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(key)
+	if i < 0 {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(s.dir, s.Archives[i].File)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.Archives = append(s.Archives[:i], s.Archives[i+1:]...)
+	return s.save()
+}
+
+// byDirectory groups the staged archives by directory, keeping their order within each
+// directory and the order in which directories were first staged
+func (s *stagingIndex) byDirectory() [][]stagedArchive {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	positions := make(map[string]int)
+	var groups [][]stagedArchive
+	for _, archive := range s.Archives {
+		i, ok := positions[archive.Directory]
+		if !ok {
+			i = len(groups)
+			positions[archive.Directory] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], archive)
+	}
+	return groups
+}
+
+// stagingSink keeps archives in the staging directory together with their hashes
+type stagingSink struct {
+	backup     *s3Backup
+	index      *stagingIndex
+	withSHA256 bool
+}
+
+// stored returns the size of the archive already staged under key
+func (s *stagingSink) stored(key string) (int64, bool) {
+	return s.index.stored(key)
+}
+
+// store hashes the archive and moves it into the staging directory
+func (s *stagingSink) store(ctx context.Context, path, key, dirName string) error {
+	hashes, err := s.backup.archiveHashes(path, s.withSHA256)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Base(key)
+	if err := os.Rename(path, filepath.Join(s.index.dir, file)); err != nil {
+		return fmt.Errorf("failed to move archive to staging directory: %w", err)
+	}
+	logger.Info("Staged archive", "directory", dirName, "key", key, "hash", hashes.MD5)
+	return s.index.add(stagedArchive{Key: key, Directory: dirName, File: file, Size: info.Size(), Hashes: hashes})
+}
+
+// ArchiveDirectories creates the archives of all subdirectories in parallel and keeps them in
+// opts.StagingDir. Archives already staged by an earlier run are not created again.
+func (b *s3Backup) ArchiveDirectories(ctx context.Context, sourceDir string, opts BackupOptions) (BackupReport, error) {
+	if opts.StagingDir == "" {
+		return BackupReport{}, fmt.Errorf("a staging directory is required to keep archives for a later upload")
+	}
+	if opts.Mode == BackupModeIncremental {
+		return BackupReport{}, fmt.Errorf("incremental backups upload files as they are and can't be archived for a later upload")
+	}
+	if err := validateStagingDir(opts.StagingDir); err != nil {
+		return BackupReport{}, err
+	}
+	index, err := loadStagingIndex(opts.StagingDir)
+	if err != nil {
+		return BackupReport{}, err
+	}
+
+	logger.Info("Archiving directories for a later upload", "staging_dir", opts.StagingDir)
+	tally := newBackupTally()
+	sink := &stagingSink{backup: b, index: index, withSHA256: opts.SHA256Checksums}
+	if err := b.backupDirectories(ctx, "backing up", sourceDir, opts, b.archiveTo(sourceDir, opts, sink), tally); err != nil {
+		return BackupReport{}, err
+	}
+	logger.Info("Archives staged, upload them with --upload-only", "staging_dir", opts.StagingDir, "archives", len(index.Archives))
+
+	report := tally.report()
+	report.Staged, report.StagingDir = len(index.Archives), opts.StagingDir
+	return report, nil
+}
+
+// UploadArchives uploads the archives staged in stagingDir, a directory at a time in parallel.
+// Each archive is checked against the MD5 hash taken when it was created, and removed from the
+// staging directory once uploaded, so an interrupted upload resumes where it stopped.
+func (b *s3Backup) UploadArchives(ctx context.Context, stagingDir, bucket string, opts BackupOptions) (BackupReport, error) {
+	if err := validateStagingDir(stagingDir); err != nil {
+		return BackupReport{}, err
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultBackupOptions().MaxConcurrent
+	}
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return BackupReport{}, err
+	}
+	defer b.storage.Close()
+	if b, err = b.withStorageClass(opts.StorageClass); err != nil {
+		return BackupReport{}, err
+	}
+	b, invalidateInventory := b.withInventoryInvalidation(opts.InventoryCacheDir, bucket)
+	defer invalidateInventory()
+	b = b.withBandwidthLimit(opts.BandwidthLimit).withObjectTimeout(opts.ObjectTimeout)
+	index, err := loadStagingIndex(stagingDir)
+	if err != nil {
+		return BackupReport{}, err
+	}
+	directories := index.byDirectory()
+	if len(directories) == 0 {
+		return BackupReport{}, fmt.Errorf("no staged archives found in %s", stagingDir)
+	}
+	tally := newBackupTally()
+	tally.directories.Store(int64(len(directories)))
+
+	logger.Info("Uploading staged archives", "directories", len(directories), "archives", len(index.Archives), "bucket", bucket, "concurrency", opts.MaxConcurrent)
+
+	var processedCount atomic.Int64
+	totalDirs := len(directories)
+	err = runWorkerPool(ctx, directories, opts.MaxConcurrent, func(archives []stagedArchive) error {
+		dirName := archives[0].Directory
+		if err := ctx.Err(); err != nil {
+			return &ItemFailure{Item: dirName, Err: err}
+		}
+		processedCount.Add(1)
+
+		dirCtx := ctx
+		if opts.ProgressChan != nil {
+			current := processedCount.Load()
+
+			event := ProgressEvent{
+				Stage:   "uploading",
+				Current: int(current),
+				Total:   totalDirs,
+				Message: i18n.T("progress.uploading", current, totalDirs),
+				File:    dirName,
+			}
+			select {
+			case opts.ProgressChan <- event:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "uploading")
+			}
+			// Uploading the archives reports their bytes as progress of this event
+			dirCtx = withByteProgress(ctx, opts.ProgressChan, event, &processedCount)
+		}
+
+		for _, archive := range archives {
+			if err := b.uploadStagedArchive(dirCtx, index, bucket, archive, opts, tally); err != nil {
+				logger.Error("Failed to upload staged archive", "directory", dirName, "key", archive.Key, "error", err)
+				return &ItemFailure{Item: dirName, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		err = runTimeoutError(ctx, err)
+		logger.Error("Upload completed with errors", "error", err)
+		if failures := partialFailures(err); failures != nil {
+			report := tally.report()
+			report.Failed = failures
+			return report, err
+		}
+		return BackupReport{}, err
+	}
+
+	logger.Info("Upload completed successfully", "directories", totalDirs)
+	return tally.report(), nil
+}
+
+// uploadStagedArchive checks a staged archive against its recorded MD5 hash, uploads it and
+// removes it from the staging directory
+func (b *s3Backup) uploadStagedArchive(ctx context.Context, index *stagingIndex, bucket string, archive stagedArchive, opts BackupOptions, tally *backupTally) error {
+	path := filepath.Join(index.dir, archive.File)
+	hash, err := b.calculateMD5(path)
+	if err != nil {
+		return fmt.Errorf("failed to calculate MD5: %w", err)
+	}
+	if hash != archive.Hashes.MD5 {
+		return fmt.Errorf("staged archive %s changed since it was created (expected MD5 %s, got %s)", archive.File, archive.Hashes.MD5, hash)
+	}
+
+	hashes := archive.Hashes
+	if opts.SHA256Checksums && hashes.SHA256 == "" {
+		if hashes.SHA256, err = fileSHA256Base64(path); err != nil {
+			return fmt.Errorf("failed to calculate SHA-256: %w", err)
+		}
+	}
+
+	uploaded, err := b.uploadHashedUnlessExists(ctx, path, bucket, archive.Key, archive.Directory, hashes, opts.ObjectTags)
+	if err != nil {
+		return err
+	}
+	tally.uploadedArchive(archive.Directory, uploaded, archive.Size)
+	return index.remove(archive.Key)
+}
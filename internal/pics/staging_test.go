@@ -0,0 +1,184 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stagedKeys returns the keys listed in the staging index of dir, in order
+func stagedKeys(t *testing.T, dir string) []string {
+	t.Helper()
+	index, err := loadStagingIndex(dir)
+	if err != nil {
+		t.Fatalf("Failed to load staging index: %v", err)
+	}
+	var keys []string
+	for _, archive := range index.Archives {
+		keys = append(keys, archive.Key)
+	}
+	return keys
+}
+
+func TestBackup_ArchiveThenUpload(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	stagingDir := filepath.Join(tmpDir, "staging")
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		t.Fatalf("Failed to create staging directory: %v", err)
+	}
+
+	dirName := "2023 06 June 15 vacation"
+	dir := filepath.Join(sourceDir, dirName)
+	writeSizedFile(t, dir, "photo1.jpg", 600)
+	writeSizedFile(t, dir, "photo2.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01 park"), "photo.jpg", 10)
+
+	opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000, StagingDir: stagingDir}
+	report, err := backup.ArchiveDirectories(testCtx, sourceDir, opts)
+	if err != nil {
+		t.Fatalf("ArchiveDirectories failed: %v", err)
+	}
+	if report.Directories != 2 || report.Staged != 4 || report.StagingDir != stagingDir || report.Uploaded != 0 {
+		t.Errorf("Expected 2 directories archived into 4 staged archives, got %+v", report)
+	}
+	if client.GetObjectCount(bucket) != 0 {
+		t.Errorf("Expected nothing uploaded while archiving, got %d objects", client.GetObjectCount(bucket))
+	}
+
+	// Parts are staged before the manifest that binds them
+	baseKey := "2023 06 June 15 vacation (2 images, 0 videos)"
+	expected := []string{
+		archivePartKey(baseKey, 1),
+		archivePartKey(baseKey, 2),
+		baseKey + manifestExtension,
+		"2023 07 July 01 park (1 images, 0 videos).tar.gz",
+	}
+	if keys := stagedKeys(t, stagingDir); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected staged keys %v, got %v", expected, keys)
+	}
+
+	// Archiving again leaves the staged archives alone
+	staged := filepath.Join(stagingDir, filepath.Base(archivePartKey(baseKey, 1)))
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(staged, old, old); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	if _, err := backup.ArchiveDirectories(testCtx, sourceDir, opts); err != nil {
+		t.Fatalf("Second ArchiveDirectories failed: %v", err)
+	}
+	assertFileModTime(t, staged, old)
+	if keys := stagedKeys(t, stagingDir); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected staged keys %v after archiving again, got %v", expected, keys)
+	}
+
+	report, err = backup.UploadArchives(testCtx, stagingDir, bucket, BackupOptions{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatalf("UploadArchives failed: %v", err)
+	}
+	if report.Directories != 2 || report.Uploaded != 4 || report.Staged != 0 {
+		t.Errorf("Expected 4 archives of 2 directories uploaded, got %+v", report)
+	}
+	for _, key := range expected {
+		if _, err := client.GetObjectData(bucket, key); err != nil {
+			t.Errorf("Expected to find %s in bucket", key)
+		}
+	}
+
+	// Uploaded archives leave the staging directory
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatalf("Failed to read staging directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty staging directory, got %d entries", len(entries))
+	}
+
+	// The uploaded archives are those a direct backup creates
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, bucket, BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if client.GetObjectCount(bucket) != len(expected) {
+		t.Errorf("Expected %d objects in bucket, got: %d", len(expected), client.GetObjectCount(bucket))
+	}
+}
+
+func TestBackup_UploadArchives_ChangedArchive(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	stagingDir := filepath.Join(tmpDir, "staging")
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		t.Fatalf("Failed to create staging directory: %v", err)
+	}
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01 park"), "photo.jpg", 10)
+
+	if _, err := backup.ArchiveDirectories(testCtx, sourceDir, BackupOptions{MaxConcurrent: 1, StagingDir: stagingDir}); err != nil {
+		t.Fatalf("ArchiveDirectories failed: %v", err)
+	}
+
+	key := "2023 07 July 01 park (1 images, 0 videos).tar.gz"
+	if err := os.WriteFile(filepath.Join(stagingDir, key), []byte("bit rot"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt archive: %v", err)
+	}
+
+	if _, err := backup.UploadArchives(testCtx, stagingDir, bucket, BackupOptions{MaxConcurrent: 1}); err == nil {
+		t.Fatal("Expected an error for an archive that changed after staging")
+	}
+	if client.GetObjectCount(bucket) != 0 {
+		t.Errorf("Expected the changed archive not to be uploaded, got %d objects", client.GetObjectCount(bucket))
+	}
+	if keys := stagedKeys(t, stagingDir); !reflect.DeepEqual(keys, []string{key}) {
+		t.Errorf("Expected the changed archive to stay staged, got %v", keys)
+	}
+}
+
+func TestBackup_ArchiveDirectories_RequiresStagingDir(t *testing.T) {
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if _, err := backup.ArchiveDirectories(testCtx, t.TempDir(), BackupOptions{MaxConcurrent: 1}); err == nil {
+		t.Error("Expected an error without a staging directory")
+	}
+}
+
+func TestBackup_UploadArchives_NothingStaged(t *testing.T) {
+	backup := &s3Backup{client: NewInMemoryS3Client(), extensions: NewExtensions()}
+	if _, err := backup.UploadArchives(testCtx, t.TempDir(), "test-bucket", BackupOptions{MaxConcurrent: 1}); err == nil {
+		t.Error("Expected an error when no archives are staged")
+	}
+}
+
+func TestStagingIndex_ByDirectory(t *testing.T) {
+	index := &stagingIndex{Archives: []stagedArchive{
+		{Key: "a.part001.tar.gz", Directory: "a"},
+		{Key: "b.tar.gz", Directory: "b"},
+		{Key: "a.part002.tar.gz", Directory: "a"},
+		{Key: "a.manifest.json", Directory: "a"},
+	}}
+
+	groups := index.byDirectory()
+	var got [][]string
+	for _, group := range groups {
+		var keys []string
+		for _, archive := range group {
+			keys = append(keys, archive.Key)
+		}
+		got = append(got, keys)
+	}
+
+	expected := [][]string{
+		{"a.part001.tar.gz", "a.part002.tar.gz", "a.manifest.json"},
+		{"b.tar.gz"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
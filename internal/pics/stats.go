@@ -11,10 +11,62 @@ import (
 type FileStats interface {
 	// ValidateDirectories checks if source and target directories exist
 	ValidateDirectories(sourceDir, targetDir string) error
-	// GetFileCount returns the number of supported media files in a directory recursively
+	// GetFileCount returns the number of supported media files in a directory recursively, honouring .picsignore files
 	GetFileCount(dir string) (int, error)
-	// GetUnsupportedFiles returns a list of unsupported files in a directory recursively
+	// GetStats returns the number of files and bytes in a directory recursively, grouped by extension
+	// and media class, honouring .picsignore files
+	GetStats(dir string) (DirStats, error)
+	// GetUnsupportedFiles returns a list of unsupported files in a directory recursively, honouring .picsignore files
 	GetUnsupportedFiles(dir string) ([]string, error)
+	// GetLibraryStats counts the media files of the date directories of a library by year and
+	// reads when it was last imported into and backed up
+	GetLibraryStats(libraryDir string) (LibraryStats, error)
+}
+
+// MediaClass is the kind of media a file holds, as told by its extension
+type MediaClass string
+
+const (
+	// MediaClassImage is a supported image format
+	MediaClassImage MediaClass = "image"
+	// MediaClassVideo is a supported video format
+	MediaClassVideo MediaClass = "video"
+	// MediaClassOther is any unsupported file
+	MediaClassOther MediaClass = "other"
+)
+
+// GroupStats counts the files of a group and their size
+type GroupStats struct {
+	// Files is the number of files
+	Files int `json:"files"`
+	// Bytes is the total size of the files
+	Bytes int64 `json:"bytes"`
+}
+
+// add returns the stats with one more file of the given size
+func (g GroupStats) add(size int64) GroupStats {
+	return GroupStats{Files: g.Files + 1, Bytes: g.Bytes + size}
+}
+
+// DirStats breaks down the files of a directory tree
+type DirStats struct {
+	// ByExtension groups files by lower-cased extension including the dot, e.g. ".jpg".
+	// Files without an extension are grouped under "".
+	ByExtension map[string]GroupStats
+	// ByClass groups files by media class
+	ByClass map[MediaClass]GroupStats
+}
+
+// Media returns the stats of all supported media files
+func (s DirStats) Media() GroupStats {
+	images, videos := s.ByClass[MediaClassImage], s.ByClass[MediaClassVideo]
+	return GroupStats{Files: images.Files + videos.Files, Bytes: images.Bytes + videos.Bytes}
+}
+
+// Total returns the stats of all files, supported or not
+func (s DirStats) Total() GroupStats {
+	media, other := s.Media(), s.ByClass[MediaClassOther]
+	return GroupStats{Files: media.Files + other.Files, Bytes: media.Bytes + other.Bytes}
 }
 
 // fileStats implements the FileStats interface
@@ -40,34 +92,63 @@ func (f *fileStats) ValidateDirectories(sourceDir, targetDir string) error {
 	return nil
 }
 
-// GetFileCount counts all supported media files in a directory tree, excluding dot files
+// GetFileCount counts all supported media files in a directory tree, excluding dot files and ignored paths
 func (f *fileStats) GetFileCount(dir string) (int, error) {
-	count := 0
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip dot files and dot directories
-		if strings.HasPrefix(info.Name(), ".") {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	stats, err := f.GetStats(dir)
+	if err != nil {
+		return 0, err
+	}
+	return stats.Media().Files, nil
+}
 
-		if !info.IsDir() && f.extensions.IsSupported(path) {
-			count++
-		}
-		return nil
+// GetStats counts the files and bytes of a directory tree by extension and media class,
+// excluding dot files and ignored paths
+func (f *fileStats) GetStats(dir string) (DirStats, error) {
+	stats := DirStats{
+		ByExtension: make(map[string]GroupStats),
+		ByClass:     make(map[MediaClass]GroupStats),
+	}
+	err := f.walk(dir, func(path string, info os.FileInfo) {
+		ext := strings.ToLower(filepath.Ext(path))
+		stats.ByExtension[ext] = stats.ByExtension[ext].add(info.Size())
+		class := f.mediaClass(path)
+		stats.ByClass[class] = stats.ByClass[class].add(info.Size())
 	})
-	return count, err
+	if err != nil {
+		return DirStats{}, err
+	}
+	return stats, nil
 }
 
-// GetUnsupportedFiles returns a list of unsupported files in a directory tree, excluding dot files
+// GetUnsupportedFiles returns a list of unsupported files in a directory tree, excluding dot files and ignored paths
 func (f *fileStats) GetUnsupportedFiles(dir string) ([]string, error) {
 	var unsupported []string
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	err := f.walk(dir, func(path string, info os.FileInfo) {
+		if !f.extensions.IsSupported(path) {
+			unsupported = append(unsupported, path)
+		}
+	})
+	return unsupported, err
+}
+
+// mediaClass returns the media class of a file from its extension
+func (f *fileStats) mediaClass(path string) MediaClass {
+	switch {
+	case f.extensions.IsImage(path):
+		return MediaClassImage
+	case f.extensions.IsVideo(path):
+		return MediaClassVideo
+	}
+	return MediaClassOther
+}
+
+// walk calls fn for every file of a directory tree, excluding dot files and ignored paths
+func (f *fileStats) walk(dir string, fn func(path string, info os.FileInfo)) error {
+	ignore, err := newIgnoreMatcher(dir)
+	if err != nil {
+		return fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -80,10 +161,17 @@ func (f *fileStats) GetUnsupportedFiles(dir string) ([]string, error) {
 			return nil
 		}
 
-		if !info.IsDir() && !f.extensions.IsSupported(path) {
-			unsupported = append(unsupported, path)
+		// Skip paths excluded by .picsignore files
+		if ignore.isIgnored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			fn(path, info)
 		}
 		return nil
 	})
-	return unsupported, err
 }
@@ -3,6 +3,7 @@ package pics
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -231,3 +232,87 @@ func TestFileStats_GetFileCount_OnlyDirectories(t *testing.T) {
 		t.Errorf("Expected count 0, got %d", count)
 	}
 }
+
+func TestFileStats_HonoursIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	createTestFile(t, tmpDir, "keep.jpg")
+	createTestFile(t, tmpDir, "thumb.jpg")
+	createTestFile(t, tmpDir, "notes.txt")
+	cacheDir := createTestDir(t, tmpDir, "cache")
+	createTestFile(t, cacheDir, "cached.jpg")
+	createTestFile(t, cacheDir, "cached.txt")
+	writeIgnoreFile(t, tmpDir, "thumb.jpg\ncache/\n*.txt\n")
+
+	stats := NewFileStats()
+	count, err := stats.GetFileCount(tmpDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected count 1 (ignored files skipped), got %d", count)
+	}
+
+	unsupported, err := stats.GetUnsupportedFiles(tmpDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(unsupported) != 0 {
+		t.Errorf("Expected no unsupported files (all ignored), got %v", unsupported)
+	}
+}
+
+func TestFileStats_GetStats(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	createTestFile(t, tmpDir, "a.jpg")
+	createTestFile(t, tmpDir, "b.JPG")
+	subDir := createTestDir(t, tmpDir, "subdir")
+	writeSizedFile(t, subDir, "clip.mov", 100)
+	createTestFile(t, subDir, "notes.txt")
+	createTestFile(t, subDir, "README")
+	createTestFile(t, tmpDir, ".DS_Store")
+	createTestFile(t, tmpDir, "skip.png")
+	writeIgnoreFile(t, tmpDir, "skip.png\n")
+
+	stats, err := NewFileStats().GetStats(tmpDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expectedExtensions := map[string]GroupStats{
+		".jpg": {Files: 2, Bytes: 8},
+		".mov": {Files: 1, Bytes: 100},
+		".txt": {Files: 1, Bytes: 4},
+		"":     {Files: 1, Bytes: 4},
+	}
+	if !reflect.DeepEqual(stats.ByExtension, expectedExtensions) {
+		t.Errorf("Expected extensions %v, got %v", expectedExtensions, stats.ByExtension)
+	}
+
+	expectedClasses := map[MediaClass]GroupStats{
+		MediaClassImage: {Files: 2, Bytes: 8},
+		MediaClassVideo: {Files: 1, Bytes: 100},
+		MediaClassOther: {Files: 2, Bytes: 8},
+	}
+	if !reflect.DeepEqual(stats.ByClass, expectedClasses) {
+		t.Errorf("Expected classes %v, got %v", expectedClasses, stats.ByClass)
+	}
+
+	if media := stats.Media(); media != (GroupStats{Files: 3, Bytes: 108}) {
+		t.Errorf("Expected 3 media files of 108 bytes, got %+v", media)
+	}
+	if total := stats.Total(); total != (GroupStats{Files: 5, Bytes: 116}) {
+		t.Errorf("Expected 5 files of 116 bytes, got %+v", total)
+	}
+}
+
+func TestFileStats_GetStats_EmptyDirectory(t *testing.T) {
+	stats, err := NewFileStats().GetStats(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if total := stats.Total(); total != (GroupStats{}) {
+		t.Errorf("Expected no files, got %+v", total)
+	}
+}
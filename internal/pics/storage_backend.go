@@ -0,0 +1,413 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3Scheme is the optional scheme of a bucket path, e.g. s3://photos/family/
+	s3Scheme = "s3://"
+	// objectsMetaDir is the directory of a StorageBackend keeping objects as files where the
+	// metadata of each object is kept, under its key followed by objectMetaExtension
+	objectsMetaDir       = ".pics-objects"
+	objectMetaExtension  = ".json"
+	storageTempExtension = ".tmp"
+)
+
+var (
+	// ErrObjectNotFound is the error a StorageBackend returns for a key with no object
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectChanged is the error a StorageBackend returns when the object stored under a key
+	// isn't the one a request expects
+	ErrObjectChanged = errors.New("object changed")
+	// ErrObjectArchived is the error a StorageBackend returns when downloading an object kept in
+	// an archived storage class, which must be restored first
+	ErrObjectArchived = errors.New("object archived")
+)
+
+// StorageBackend stores the objects of a backup by key: an S3 bucket, a local directory or a
+// directory of an SFTP server. Keys use "/" as separator.
+type StorageBackend interface {
+	// Put stores what body reads under key, replacing any object stored there, and returns the
+	// key, size and ETag of the object stored
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error)
+	// Get opens the object stored under key. A non-empty ifMatch fails the request with
+	// ErrObjectChanged unless the object still has that ETag.
+	Get(ctx context.Context, key, ifMatch string) (io.ReadCloser, StoredObject, error)
+	// Head returns the object stored under key
+	Head(ctx context.Context, key string) (StoredObject, error)
+	// List returns the objects directly under prefix, which is "" or ends with "/", sorted by
+	// key. Objects whose key has a "/" past prefix are left out, and the prefixes up to that
+	// "/" returned instead, once each.
+	List(ctx context.Context, prefix string) ([]StoredObject, []string, error)
+	// Delete removes the object stored under key, if there is one
+	Delete(ctx context.Context, key string) error
+	// Checksum returns the base64 encoded SHA-256 checksum of the whole object stored under key,
+	// or "" if the backend has none
+	Checksum(ctx context.Context, key string) (string, error)
+	// RequestRestore asks for a copy of the archived object stored under key that can be
+	// downloaded. Backends that never archive objects fail.
+	RequestRestore(ctx context.Context, key string) error
+	// Close releases the connection of the backend, if it keeps one
+	Close() error
+}
+
+// PutOptions are the options of StorageBackend.Put
+type PutOptions struct {
+	// Tags are stored with the object, URL query encoded
+	Tags string
+	// StorageClass is the class the object is stored in ("" = the default of the backend)
+	StorageClass StorageClass
+	// SHA256 is the base64 encoded SHA-256 checksum of the body, which the backend verifies
+	// and stores with the object
+	SHA256 string
+	// ChecksumParts has a body streamed in parts, whose SHA-256 checksum isn't known beforehand,
+	// checksummed and verified part by part
+	ChecksumParts bool
+	// IfMatch only replaces the object stored under key if it has this ETag
+	IfMatch string
+	// IfNoneMatch only stores the object if there is none under key
+	IfNoneMatch bool
+}
+
+// StoredObject describes an object of a StorageBackend
+type StoredObject struct {
+	// Key is the key of the object
+	Key string `json:"key"`
+	// Size is its size in bytes
+	Size int64 `json:"size"`
+	// ETag identifies the contents of the object, without quotes: the hex encoded MD5 hash of
+	// objects stored at once, as S3 gives them
+	ETag string `json:"etag"`
+	// SHA256 is the base64 encoded SHA-256 checksum of the object
+	SHA256 string `json:"sha256"`
+	// Tags are the tags of the object, URL query encoded
+	Tags string `json:"tags,omitempty"`
+	// LastModified is when the object was stored
+	LastModified time.Time `json:"last_modified"`
+	// StorageClass is the storage class of the object ("" = the default of the backend)
+	StorageClass StorageClass `json:"-"`
+	// RestoreRequested reports whether the restore of the archived object was requested
+	RestoreRequested bool `json:"-"`
+	// Restored reports whether a restored copy of the archived object can be downloaded
+	Restored bool `json:"-"`
+}
+
+// OpenStorageBackend returns the StorageBackend a file:// or sftp:// URI names, such as
+// file:///mnt/nas/backups or sftp://user@nas:2222/volume1/backups, or nil for a bucket path,
+// with or without the s3:// scheme, which is stored through the S3 client of the backup
+func OpenStorageBackend(uri string) (StorageBackend, error) {
+	scheme, _, ok := strings.Cut(uri, "://")
+	if !ok || scheme == "s3" {
+		return nil, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %w", uri, err)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid destination %q: no query or fragment expected", uri)
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("invalid destination %q: passwords aren't supported, log in with an ssh key", uri)
+	}
+	dir := path.Clean(u.Path)
+	if !path.IsAbs(u.Path) || dir == "/" {
+		return nil, fmt.Errorf("invalid destination %q: expected an absolute path below /", uri)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("invalid destination %q: file URIs name a local path, e.g. file:///mnt/nas/backups", uri)
+		}
+		return newLocalBackend(fileURIPath(dir)), nil
+	case "sftp":
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid destination %q: no host", uri)
+		}
+		return newSFTPBackend(u, dir), nil
+	}
+	return nil, fmt.Errorf("invalid destination %q: unknown scheme %q (expected s3, file or sftp)", uri, u.Scheme)
+}
+
+// fileURIPath returns the local path of the path of a file URI. Windows paths start with their
+// drive, so the slash before it, as in file:///C:/backups, is dropped there.
+func fileURIPath(uriPath string) string {
+	if trimmed := strings.TrimPrefix(uriPath, "/"); filepath.VolumeName(filepath.FromSlash(trimmed)) != "" {
+		uriPath = trimmed
+	}
+	return filepath.FromSlash(uriPath)
+}
+
+// ValidateDestination checks that path names where backups go: a bucket path, with or without
+// the s3:// scheme, or a file:// or sftp:// URI
+func ValidateDestination(path string) error {
+	backend, err := OpenStorageBackend(path)
+	if err != nil || backend != nil {
+		return err
+	}
+	_, _, err = ParseBucketPath(path)
+	return err
+}
+
+// fileStore is the file access fileBackend needs from the place it keeps objects in. Names are
+// relative to that place and use "/" as separator.
+type fileStore interface {
+	// write stores what r reads as the file name, creating its directory, and replaces the
+	// file at once once it is complete
+	write(ctx context.Context, name string, r io.Reader) error
+	// read opens the file name, failing with ErrObjectNotFound if there is none
+	read(ctx context.Context, name string) (io.ReadCloser, error)
+	// list returns the names of the files and of the directories in dir. A directory that
+	// doesn't exist has none.
+	list(ctx context.Context, dir string) (files, dirs []string, err error)
+	// remove deletes the file name, if there is one
+	remove(ctx context.Context, name string) error
+	// close releases the place, if it has to be
+	close() error
+}
+
+// fileBackend implements the StorageBackend interface by keeping each object as a file named
+// by its key, and its metadata as a file of objectsMetaDir, written after the object. The
+// metadata are the index of the backend: an object whose metadata are missing doesn't exist, so
+// an interrupted Put leaves no object. Conditional requests are checked before writing, which
+// unlike S3 doesn't stop two runs writing the same key at once.
+type fileBackend struct {
+	store fileStore
+}
+
+// newLocalBackend creates a StorageBackend keeping its objects in the local directory root,
+// such as a mounted NAS share
+func newLocalBackend(root string) *fileBackend {
+	return &fileBackend{store: &localStore{root: root}}
+}
+
+// newSFTPBackend creates a StorageBackend keeping its objects in the directory root of the SFTP
+// server of u
+func newSFTPBackend(u *url.URL, root string) *fileBackend {
+	return &fileBackend{store: newSFTPStore(u, root)}
+}
+
+// objectMetaName returns the name of the file holding the metadata of key
+func objectMetaName(key string) string {
+	return objectsMetaDir + "/" + key + objectMetaExtension
+}
+
+// checkObjectKey refuses keys that would be stored outside the backend or among its metadata
+func checkObjectKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid key %q", key)
+		}
+	}
+	if key == objectsMetaDir || strings.HasPrefix(key, objectsMetaDir+"/") {
+		return fmt.Errorf("invalid key %q: %s holds the metadata of the objects", key, objectsMetaDir)
+	}
+	return nil
+}
+
+// Put writes the object, hashing it on the way, followed by its metadata
+func (f *fileBackend) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error) {
+	if err := checkObjectKey(key); err != nil {
+		return StoredObject{}, err
+	}
+	if opts.StorageClass != "" {
+		return StoredObject{}, fmt.Errorf("storage class %s only applies to S3 buckets", opts.StorageClass)
+	}
+	if err := f.checkConditions(ctx, key, opts); err != nil {
+		return StoredObject{}, err
+	}
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	counter := &countingWriter{}
+	body = newChecksumReader(body, sha256.New(), opts.SHA256)
+	if err := f.store.write(ctx, key, io.TeeReader(body, io.MultiWriter(md5Hash, sha256Hash, counter))); err != nil {
+		return StoredObject{}, err
+	}
+
+	obj := StoredObject{
+		Key:          key,
+		Size:         counter.n,
+		ETag:         hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA256:       base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)),
+		Tags:         opts.Tags,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+	meta, err := json.Marshal(obj)
+	if err != nil {
+		return StoredObject{}, err
+	}
+	if err := f.store.write(ctx, objectMetaName(key), bytes.NewReader(meta)); err != nil {
+		return StoredObject{}, fmt.Errorf("failed to write metadata of %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// checkConditions checks the IfMatch and IfNoneMatch conditions of opts on key
+func (f *fileBackend) checkConditions(ctx context.Context, key string, opts PutOptions) error {
+	if opts.IfMatch == "" && !opts.IfNoneMatch {
+		return nil
+	}
+	obj, err := f.Head(ctx, key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	if (opts.IfNoneMatch && exists) || (opts.IfMatch != "" && (!exists || opts.IfMatch != obj.ETag)) {
+		return fmt.Errorf("%s: %w", key, ErrObjectChanged)
+	}
+	return nil
+}
+
+// Get opens the object of a key that has metadata
+func (f *fileBackend) Get(ctx context.Context, key, ifMatch string) (io.ReadCloser, StoredObject, error) {
+	obj, err := f.Head(ctx, key)
+	if err != nil {
+		return nil, StoredObject{}, err
+	}
+	if ifMatch != "" && ifMatch != obj.ETag {
+		return nil, StoredObject{}, fmt.Errorf("%s: %w", key, ErrObjectChanged)
+	}
+	body, err := f.store.read(ctx, key)
+	if err != nil {
+		return nil, StoredObject{}, err
+	}
+	return body, obj, nil
+}
+
+// Head reads the metadata of key
+func (f *fileBackend) Head(ctx context.Context, key string) (StoredObject, error) {
+	if err := checkObjectKey(key); err != nil {
+		return StoredObject{}, err
+	}
+	r, err := f.store.read(ctx, objectMetaName(key))
+	if err != nil {
+		return StoredObject{}, err
+	}
+	defer r.Close()
+	var obj StoredObject
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return StoredObject{}, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// List reads the metadata of the objects directly under prefix, and no others
+func (f *fileBackend) List(ctx context.Context, prefix string) ([]StoredObject, []string, error) {
+	if prefix != "" && (!strings.HasSuffix(prefix, "/") || checkObjectKey(strings.TrimSuffix(prefix, "/")) != nil) {
+		return nil, nil, fmt.Errorf("invalid prefix %q", prefix)
+	}
+	names, dirs, err := f.store.list(ctx, strings.TrimSuffix(objectsMetaDir+"/"+prefix, "/"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var objects []StoredObject
+	for _, name := range names {
+		// Temporary files of writes in progress are left out
+		if !strings.HasSuffix(name, objectMetaExtension) {
+			continue
+		}
+		obj, err := f.Head(ctx, prefix+strings.TrimSuffix(name, objectMetaExtension))
+		if errors.Is(err, ErrObjectNotFound) {
+			// Deleted since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, obj)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	prefixes := make([]string, len(dirs))
+	for i, dir := range dirs {
+		prefixes[i] = prefix + dir + "/"
+	}
+	sort.Strings(prefixes)
+	return objects, prefixes, nil
+}
+
+// Delete removes the metadata of key before the object, so an interrupted Delete leaves no object
+func (f *fileBackend) Delete(ctx context.Context, key string) error {
+	if err := checkObjectKey(key); err != nil {
+		return err
+	}
+	if err := f.store.remove(ctx, objectMetaName(key)); err != nil {
+		return fmt.Errorf("failed to delete metadata of %s: %w", key, err)
+	}
+	return f.store.remove(ctx, key)
+}
+
+// Checksum returns the SHA-256 checksum every object of the backend has
+func (f *fileBackend) Checksum(ctx context.Context, key string) (string, error) {
+	obj, err := f.Head(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return obj.SHA256, nil
+}
+
+// RequestRestore fails, as the objects of the backend are never archived
+func (f *fileBackend) RequestRestore(ctx context.Context, key string) error {
+	return fmt.Errorf("%s is not archived, objects of this destination can be downloaded at once", key)
+}
+
+// Close releases the store of the backend
+func (f *fileBackend) Close() error {
+	return f.store.close()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// checksumReader fails at the end of what it reads if it doesn't match the base64 encoded
+// checksum expected, as S3 refuses the upload
+type checksumReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+// newChecksumReader returns r checked against expected with h, or r itself if expected is ""
+func newChecksumReader(r io.Reader, h hash.Hash, expected string) io.Reader {
+	if expected == "" {
+		return r
+	}
+	return &checksumReader{r: r, hash: h, expected: expected}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := base64.StdEncoding.EncodeToString(c.hash.Sum(nil)); actual != c.expected {
+			return n, fmt.Errorf("checksum of the data (%s) does not match the one expected (%s)", actual, c.expected)
+		}
+	}
+	return n, err
+}
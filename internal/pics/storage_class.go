@@ -0,0 +1,226 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// restoredCopyDays is how many days S3 keeps the copy of an archived object restored for a
+// restore, long enough to run the restore again if it is interrupted
+const restoredCopyDays = 7
+
+// StorageClass is the S3 storage class backups are uploaded with
+type StorageClass string
+
+const (
+	// StorageClassStandard keeps archives readable at any time, at the highest storage price
+	StorageClassStandard StorageClass = "STANDARD"
+	// StorageClassStandardIA is cheaper to store and dearer to read, for archives rarely restored
+	StorageClassStandardIA StorageClass = "STANDARD_IA"
+	// StorageClassGlacierIR is cheaper still, and archives can still be read at any time
+	StorageClassGlacierIR StorageClass = "GLACIER_IR"
+	// StorageClassGlacier archives must be restored, which takes hours, before they can be read
+	StorageClassGlacier StorageClass = "GLACIER"
+	// StorageClassDeepArchive is the cheapest, and restoring its archives takes up to 48 hours
+	StorageClassDeepArchive StorageClass = "DEEP_ARCHIVE"
+)
+
+// ParseStorageClass parses STANDARD, STANDARD_IA, GLACIER_IR, GLACIER or DEEP_ARCHIVE
+func ParseStorageClass(s string) (StorageClass, error) {
+	switch class := StorageClass(s); class {
+	case StorageClassStandard, StorageClassStandardIA, StorageClassGlacierIR, StorageClassGlacier, StorageClassDeepArchive:
+		return class, nil
+	}
+	return "", fmt.Errorf("invalid storage class %q (expected STANDARD, STANDARD_IA, GLACIER_IR, GLACIER or DEEP_ARCHIVE)", s)
+}
+
+// Archived reports whether objects of the class must be restored before they can be downloaded
+func (c StorageClass) Archived() bool {
+	return c == StorageClassGlacier || c == StorageClassDeepArchive
+}
+
+// withStorageClass returns a copy of b uploading archives and the files of incremental backups
+// with class ("" = b itself, which uploads with the default class of the bucket)
+func (b *s3Backup) withStorageClass(class StorageClass) (*s3Backup, error) {
+	if class == "" {
+		return b, nil
+	}
+	if _, ok := b.storage.(*s3Storage); !ok {
+		return nil, fmt.Errorf("storage class %s only applies to S3 buckets", class)
+	}
+	classed := *b
+	classed.storageClass = class
+	return &classed, nil
+}
+
+// objectStorageClass returns the storage class key is uploaded with. Manifests keep the default
+// class of the bucket, as restores read them before anything else.
+func (b *s3Backup) objectStorageClass(key string) StorageClass {
+	if isManifestKey(key) {
+		return ""
+	}
+	return b.storageClass
+}
+
+// isRestoreInProgressError reports whether err is S3 refusing to restore an object whose
+// restore was already requested
+func isRestoreInProgressError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress"
+}
+
+// isInvalidObjectStateError reports whether err is S3 refusing to download an archived object
+func isInvalidObjectStateError(err error) bool {
+	var stateErr *types.InvalidObjectState
+	var apiErr smithy.APIError
+	return errors.As(err, &stateErr) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState")
+}
+
+// restoreCompleted reports whether the Restore header of an object says a restored copy of it
+// can be downloaded
+func restoreCompleted(restore *string) bool {
+	return strings.Contains(aws.ToString(restore), `ongoing-request="false"`)
+}
+
+// archivedKeys returns the keys of the archives obj is restored from that are kept in an
+// archived storage class: the archive itself, or the parts of a split directory
+func archivedKeys(inv *bucketInventory, obj StoredObject) []string {
+	key := obj.Key
+	if isIncrementalManifestKey(key) {
+		return nil
+	}
+	var keys []string
+	if isManifestKey(key) {
+		partPrefix := strings.TrimSuffix(key, manifestExtension) + ".part-"
+		for _, part := range inv.Objects {
+			if strings.HasPrefix(part.Key, partPrefix) && StorageClass(part.StorageClass).Archived() {
+				keys = append(keys, part.Key)
+			}
+		}
+	} else if obj.StorageClass.Archived() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// thawArchives requests the restore of the archives of objects kept in an archived storage
+// class and, with opts.WaitForRestore, waits until they can all be downloaded. Otherwise the
+// objects whose archives can't be downloaded yet are left out, and their directories recorded
+// in tally.
+func (b *s3Backup) thawArchives(ctx context.Context, bucket string, inv *bucketInventory, objects []StoredObject, opts RestoreOptions, tally *restoreTally) ([]StoredObject, error) {
+	archived := make(map[string][]string)
+	var keys []string
+	for _, obj := range objects {
+		if objKeys := archivedKeys(inv, obj); len(objKeys) > 0 {
+			archived[obj.Key] = objKeys
+			keys = append(keys, objKeys...)
+		}
+	}
+	if len(keys) == 0 {
+		return objects, nil
+	}
+
+	logger.Info("Requesting the restore of archived objects", "objects", len(keys), "days", restoredCopyDays)
+	var mu sync.Mutex
+	pending := make(map[string]bool)
+	err := runWorkerPool(ctx, keys, opts.MaxConcurrent, func(key string) error {
+		restored, err := b.requestRestore(ctx, bucket, key)
+		if err != nil {
+			logger.Error("Failed to request the restore of archived object", "key", key, "error", err)
+			return fmt.Errorf("object %s: %w", key, err)
+		}
+		if !restored {
+			mu.Lock()
+			pending[key] = true
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return objects, nil
+	}
+
+	if opts.WaitForRestore {
+		if err := b.waitForRestores(ctx, bucket, pending, opts.RestorePollInterval); err != nil {
+			return nil, err
+		}
+		return objects, nil
+	}
+
+	var ready []StoredObject
+	for _, obj := range objects {
+		waiting := false
+		for _, key := range archived[obj.Key] {
+			waiting = waiting || pending[key]
+		}
+		if !waiting {
+			ready = append(ready, obj)
+			continue
+		}
+		dirName := b.extractDirNameFromKey(obj.Key)
+		logger.Warn("Skipping directory until its archives are restored from cold storage", "directory", dirName)
+		tally.thawing = append(tally.thawing, dirName)
+	}
+	sort.Strings(tally.thawing)
+	return ready, nil
+}
+
+// requestRestore requests the restore of the archived object key unless it was requested
+// already, and reports whether a restored copy can be downloaded
+func (b *s3Backup) requestRestore(ctx context.Context, bucket, key string) (bool, error) {
+	head, err := b.storage.Head(ctx, key)
+	if err != nil {
+		return false, describeGetError(key, err)
+	}
+	if !head.StorageClass.Archived() || head.Restored {
+		return true, nil
+	}
+	if head.RestoreRequested {
+		return false, nil
+	}
+	if err := b.storage.RequestRestore(ctx, key); err != nil {
+		return false, fmt.Errorf("failed to request restore: %w", err)
+	}
+	return false, nil
+}
+
+// waitForRestores checks the objects of pending every interval until they can all be downloaded
+func (b *s3Backup) waitForRestores(ctx context.Context, bucket string, pending map[string]bool, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultRestoreOptions().RestorePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for len(pending) > 0 {
+		logger.Info("Waiting for archived objects to be restored", "pending", len(pending), "next_check", interval)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for %d archived objects to be restored: %w", len(pending), ctx.Err())
+		case <-ticker.C:
+		}
+		for key := range pending {
+			head, err := b.storage.Head(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to check the restore of %s: %w", key, describeGetError(key, err))
+			}
+			if head.Restored {
+				logger.Info("Archived object restored", "key", key)
+				delete(pending, key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseStorageClass(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected StorageClass
+		archived bool
+		wantErr  bool
+	}{
+		{input: "STANDARD", expected: StorageClassStandard},
+		{input: "STANDARD_IA", expected: StorageClassStandardIA},
+		{input: "GLACIER_IR", expected: StorageClassGlacierIR},
+		{input: "GLACIER", expected: StorageClassGlacier, archived: true},
+		{input: "DEEP_ARCHIVE", expected: StorageClassDeepArchive, archived: true},
+		{input: "glacier", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			class, err := ParseStorageClass(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStorageClass(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if class != tt.expected || class.Archived() != tt.archived {
+				t.Errorf("Expected %q (archived %v), got %q (archived %v)", tt.expected, tt.archived, class, class.Archived())
+			}
+		})
+	}
+}
+
+// storageClasses returns the storage class of every object of bucket by key
+func storageClasses(t *testing.T, client *InMemoryS3Client, bucket string) map[string]types.ObjectStorageClass {
+	t.Helper()
+	output, err := client.ListObjectsV2(testCtx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	if err != nil {
+		t.Fatalf("ListObjectsV2 failed: %v", err)
+	}
+	classes := make(map[string]types.ObjectStorageClass)
+	for _, obj := range output.Contents {
+		classes[aws.ToString(obj.Key)] = obj.StorageClass
+	}
+	return classes
+}
+
+func TestBackup_StorageClass(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "photo1.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "photo2.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "photo.jpg", 100)
+
+	for _, stream := range []bool{false, true} {
+		client := NewInMemoryS3Client()
+		backup := &s3Backup{client: client, extensions: NewExtensions()}
+		opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000, StreamArchives: stream, StorageClass: StorageClassDeepArchive}
+		if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err != nil {
+			t.Fatalf("BackupDirectories failed: %v", err)
+		}
+
+		// The manifest of the split directory stays readable
+		expected := map[string]types.ObjectStorageClass{
+			"2023 06 June 15 (2 images, 0 videos).manifest.json":    "",
+			"2023 06 June 15 (2 images, 0 videos).part-0001.tar.gz": types.ObjectStorageClassDeepArchive,
+			"2023 06 June 15 (2 images, 0 videos).part-0002.tar.gz": types.ObjectStorageClassDeepArchive,
+			"2023 07 July 01 (1 images, 0 videos).tar.gz":           types.ObjectStorageClassDeepArchive,
+		}
+		if classes := storageClasses(t, client, "bucket"); !reflect.DeepEqual(classes, expected) {
+			t.Errorf("Expected storage classes %v with stream %v, got %v", expected, stream, classes)
+		}
+	}
+}
+
+func TestBackup_StorageClass_Incremental(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	client := NewInMemoryS3Client()
+	client.CreateBucket("bucket")
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+
+	opts := BackupOptions{Mode: BackupModeIncremental, MaxConcurrent: 1, StorageClass: StorageClassGlacier}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err == nil {
+		t.Error("Expected an archived storage class to be refused for incremental backups")
+	}
+
+	opts.StorageClass = StorageClassStandardIA
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if class := client.buckets["bucket"][incrementalFileKey("2023 06 June 15", "beach.jpg")].storageClass; class != types.StorageClassStandardIa {
+		t.Errorf("Expected the file uploaded as STANDARD_IA, got %q", class)
+	}
+}
+
+func TestBackup_StorageClass_FileDestination(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	backup := &s3Backup{client: nil, extensions: NewExtensions()}
+
+	opts := BackupOptions{MaxConcurrent: 1, StorageClass: StorageClassGlacier}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "file://"+filepath.ToSlash(t.TempDir()), opts); err == nil {
+		t.Error("Expected a storage class to be refused for a file destination")
+	}
+}
+
+// backUpArchived backs up a split directory and a directory of a single archive to a new
+// bucket in the GLACIER storage class
+func backUpArchived(t *testing.T) (*InMemoryS3Client, *s3Backup) {
+	t.Helper()
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "photo1.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "photo2.jpg", 600)
+	writeSizedFile(t, filepath.Join(sourceDir, "2023 07 July 01"), "photo.jpg", 100)
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+
+	opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000, StorageClass: StorageClassGlacier}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	return client, backup
+}
+
+func TestRestore_ArchivedStorageClass(t *testing.T) {
+	client, backup := backUpArchived(t)
+	targetDir := t.TempDir()
+
+	// The first run requests the restores and skips the directories
+	report, err := backup.RestoreDirectories(testCtx, "bucket", targetDir, RestoreOptions{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 0 || !reflect.DeepEqual(report.Thawing, []string{"2023 06 June 15", "2023 07 July 01"}) {
+		t.Errorf("Expected both directories to be thawing, got %+v", report)
+	}
+	if client.RestoresRequested() != 3 {
+		t.Errorf("Expected the restore of 3 archives requested, got %d", client.RestoresRequested())
+	}
+
+	// Running again before they are restored requests nothing more
+	if report, err = backup.RestoreDirectories(testCtx, "bucket", targetDir, RestoreOptions{MaxConcurrent: 2}); err != nil || len(report.Thawing) != 2 {
+		t.Errorf("Expected both directories still thawing, got %+v (%v)", report, err)
+	}
+
+	client.CompleteRestores()
+	report, err = backup.RestoreDirectories(testCtx, "bucket", targetDir, RestoreOptions{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 2 || len(report.Thawing) != 0 {
+		t.Errorf("Expected both directories restored, got %+v", report)
+	}
+	if info, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15", "photo2.jpg")); err != nil || info.Size() != 600 {
+		t.Errorf("Expected the split directory restored, got %v", err)
+	}
+}
+
+func TestRestore_WaitForRestore(t *testing.T) {
+	client, backup := backUpArchived(t)
+
+	// Complete the restores once they are all requested
+	go func() {
+		for client.RestoresRequested() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		client.CompleteRestores()
+	}()
+
+	targetDir := t.TempDir()
+	opts := RestoreOptions{MaxConcurrent: 2, WaitForRestore: true, RestorePollInterval: 10 * time.Millisecond}
+	report, err := backup.RestoreDirectories(testCtx, "bucket", targetDir, opts)
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 2 || len(report.Thawing) != 0 {
+		t.Errorf("Expected both directories restored, got %+v", report)
+	}
+}
+
+func TestRestore_ArchivedObjectError(t *testing.T) {
+	client, _ := backUpArchived(t)
+	_, err := client.GetObject(testCtx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("2023 07 July 01 (1 images, 0 videos).tar.gz")})
+	if !isInvalidObjectStateError(err) {
+		t.Fatalf("Expected the archived object to be unreadable, got %v", err)
+	}
+	if err := describeGetError("key", err); err == nil || !isInvalidObjectStateError(err) {
+		t.Errorf("Expected the error explained, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// localStore keeps the files of a fileBackend in a local directory, such as a mounted NAS share
+type localStore struct {
+	root string
+}
+
+// write copies r to a temporary file next to name and renames it into place once complete
+func (s *localStore) write(ctx context.Context, name string, r io.Reader) error {
+	target := filepath.Join(s.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*"+storageTempExtension)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r}); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The file must be on disk before it replaces the previous one
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// read opens the file name
+func (s *localStore) read(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(s.root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// remove deletes the file name
+func (s *localStore) remove(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(s.root, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// list reads dir, which may not exist yet
+func (s *localStore) list(ctx context.Context, dir string) ([]string, []string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, filepath.FromSlash(dir)))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var files, dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		} else {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, dirs, nil
+}
+
+// close has nothing to release
+func (s *localStore) close() error {
+	return nil
+}
+
+// contextReader stops reading once its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
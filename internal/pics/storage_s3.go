@@ -0,0 +1,333 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// streamPartSize is the size of the parts a body that can't seek, such as a streamed
+	// archive, is uploaded in. Each upload holds one part in memory.
+	streamPartSize = 16 << 20
+	// maxStreamParts is the most parts S3 takes in a multipart upload, so archives of up to
+	// about 160GB stream
+	maxStreamParts = 10000
+)
+
+// s3Storage implements the StorageBackend interface with an S3 bucket, keeping the keys of a
+// library under prefix when it shares the bucket. Keys are given and returned without the
+// prefix, as if the library had the bucket to itself.
+type s3Storage struct {
+	client S3ClientInterface
+	bucket string
+	prefix string
+}
+
+// objectKey returns the key of the bucket key is stored under
+func (s *s3Storage) objectKey(key string) *string {
+	return aws.String(s.prefix + key)
+}
+
+// quoteETag quotes etag, as S3 takes ETags in conditions
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// unquoteETag returns an ETag S3 returned without its quotes
+func unquoteETag(etag *string) string {
+	return strings.Trim(aws.ToString(etag), `"`)
+}
+
+// s3StorageError returns the error S3 returned for key as the error of a StorageBackend
+func s3StorageError(key string, err error) error {
+	var noSuchKey *types.NoSuchKey
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &noSuchKey) || isNotFoundError(err):
+		return fmt.Errorf("%s: %w: %w", key, ErrObjectNotFound, err)
+	case isPreconditionFailedError(err):
+		return fmt.Errorf("%s: %w: %w", key, ErrObjectChanged, err)
+	case isInvalidObjectStateError(err):
+		return fmt.Errorf("%s: %w: %w", key, ErrObjectArchived, err)
+	}
+	return err
+}
+
+// Put uploads a body that can seek, such as a file, at once, so the SDK can retry it. Any other
+// body is streamed: at once if it is shorter than streamPartSize, and as a multipart upload
+// otherwise.
+func (s *s3Storage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error) {
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		return s.putObject(ctx, key, seeker, opts.SHA256, opts)
+	}
+	body = newChecksumReader(body, sha256.New(), opts.SHA256)
+	buf := make([]byte, streamPartSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		checksum := opts.SHA256
+		if opts.ChecksumParts {
+			checksum = sha256Base64(buf[:n])
+		}
+		return s.putObject(ctx, key, bytes.NewReader(buf[:n]), checksum, opts)
+	}
+	if err != nil {
+		return StoredObject{}, err
+	}
+	return s.putMultipart(ctx, key, body, buf, opts)
+}
+
+// putObject uploads body under key at once, verified against checksum if it isn't ""
+func (s *s3Storage) putObject(ctx context.Context, key string, body io.ReadSeeker, checksum string, opts PutOptions) (StoredObject, error) {
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          s.objectKey(key),
+		Body:         body,
+		StorageClass: types.StorageClass(opts.StorageClass),
+	}
+	if checksum != "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	if opts.Tags != "" {
+		input.Tagging = aws.String(opts.Tags)
+	}
+	if opts.IfMatch != "" {
+		input.IfMatch = aws.String(quoteETag(opts.IfMatch))
+	}
+	if opts.IfNoneMatch {
+		input.IfNoneMatch = aws.String("*")
+	}
+	output, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return StoredObject{}, s3StorageError(key, err)
+	}
+	return StoredObject{Key: key, ETag: unquoteETag(output.ETag)}, nil
+}
+
+// putMultipart uploads the full part in buf, then the rest of what r reads, as a multipart
+// upload. A failed upload is aborted so its parts aren't kept, and billed, by S3.
+func (s *s3Storage) putMultipart(ctx context.Context, key string, r io.Reader, buf []byte, opts PutOptions) (StoredObject, error) {
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          s.objectKey(key),
+		StorageClass: types.StorageClass(opts.StorageClass),
+	}
+	if opts.ChecksumParts {
+		createInput.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	if opts.Tags != "" {
+		createInput.Tagging = aws.String(opts.Tags)
+	}
+	upload, err := s.client.CreateMultipartUpload(ctx, createInput)
+	if err != nil {
+		return StoredObject{}, s3StorageError(key, err)
+	}
+
+	var output *s3.CompleteMultipartUploadOutput
+	parts, err := s.uploadParts(ctx, r, buf, key, upload.UploadId, opts.ChecksumParts)
+	if err == nil {
+		completeInput := &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             s.objectKey(key),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		}
+		if opts.IfMatch != "" {
+			completeInput.IfMatch = aws.String(quoteETag(opts.IfMatch))
+		}
+		if opts.IfNoneMatch {
+			completeInput.IfNoneMatch = aws.String("*")
+		}
+		output, err = s.client.CompleteMultipartUpload(ctx, completeInput)
+		err = s3StorageError(key, err)
+	}
+	if err != nil {
+		// The upload is aborted even when ctx was cancelled
+		if _, abortErr := s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      s.objectKey(key),
+			UploadId: upload.UploadId,
+		}); abortErr != nil {
+			logger.Warn("Failed to abort multipart upload", "key", key, "upload_id", aws.ToString(upload.UploadId), "error", abortErr)
+		}
+		return StoredObject{}, err
+	}
+	return StoredObject{Key: key, ETag: unquoteETag(output.ETag)}, nil
+}
+
+// uploadParts uploads the full part in buf, then the rest of what r reads, as the parts of the
+// multipart upload uploadID, and returns them. With withSHA256, S3 verifies the SHA-256 checksum
+// of every part.
+func (s *s3Storage) uploadParts(ctx context.Context, r io.Reader, buf []byte, key string, uploadID *string, withSHA256 bool) ([]types.CompletedPart, error) {
+	var parts []types.CompletedPart
+	n := len(buf)
+	for partNumber := int32(1); ; partNumber++ {
+		if partNumber > maxStreamParts {
+			return nil, fmt.Errorf("archive larger than %d parts of %d bytes, split it with a maximum archive size", maxStreamParts, streamPartSize)
+		}
+		input := &s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        s.objectKey(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(buf[:n]),
+		}
+		if withSHA256 {
+			input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+			input.ChecksumSHA256 = aws.String(sha256Base64(buf[:n]))
+		}
+		output, err := s.client.UploadPart(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", partNumber, err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:           output.ETag,
+			PartNumber:     aws.Int32(partNumber),
+			ChecksumSHA256: input.ChecksumSHA256,
+		})
+
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+}
+
+// Get downloads the object, only if it still has the ETag ifMatch when that isn't ""
+func (s *s3Storage) Get(ctx context.Context, key, ifMatch string) (io.ReadCloser, StoredObject, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	}
+	if ifMatch != "" {
+		input.IfMatch = aws.String(quoteETag(ifMatch))
+	}
+	output, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, StoredObject{}, s3StorageError(key, err)
+	}
+	return output.Body, StoredObject{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		ETag:         unquoteETag(output.ETag),
+		SHA256:       aws.ToString(output.ChecksumSHA256),
+		LastModified: aws.ToTime(output.LastModified),
+		StorageClass: StorageClass(output.StorageClass),
+	}, nil
+}
+
+// Head describes the object, and the state of its restore if it is archived
+func (s *s3Storage) Head(ctx context.Context, key string) (StoredObject, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	if err != nil {
+		return StoredObject{}, s3StorageError(key, err)
+	}
+	return StoredObject{
+		Key:              key,
+		Size:             aws.ToInt64(output.ContentLength),
+		ETag:             unquoteETag(output.ETag),
+		LastModified:     aws.ToTime(output.LastModified),
+		StorageClass:     StorageClass(output.StorageClass),
+		RestoreRequested: output.Restore != nil,
+		Restored:         restoreCompleted(output.Restore),
+	}, nil
+}
+
+// List lists the keys under prefix page by page. The keys past a further "/" are grouped by S3
+// and left out.
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]StoredObject, []string, error) {
+	var objects []StoredObject
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(s.prefix + prefix),
+		Delimiter: aws.String(keyDelimiter),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, StoredObject{
+				Key:          strings.TrimPrefix(aws.ToString(obj.Key), s.prefix),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         unquoteETag(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+				StorageClass: StorageClass(obj.StorageClass),
+			})
+		}
+		for _, common := range page.CommonPrefixes {
+			prefixes = append(prefixes, strings.TrimPrefix(aws.ToString(common.Prefix), s.prefix))
+		}
+	}
+	return objects, prefixes, nil
+}
+
+// Delete deletes the object, which S3 does whether or not there is one
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	return s3StorageError(key, err)
+}
+
+// Checksum returns the full-object SHA-256 checksum S3 stored for the object, or "" if it was
+// uploaded without one or in parts with a checksum of each
+func (s *s3Storage) Checksum(ctx context.Context, key string) (string, error) {
+	attrs, err := s.client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket:           aws.String(s.bucket),
+		Key:              s.objectKey(key),
+		ObjectAttributes: []types.ObjectAttributes{types.ObjectAttributesChecksum},
+	})
+	if err != nil {
+		return "", s3StorageError(key, err)
+	}
+	if attrs.Checksum == nil || attrs.Checksum.ChecksumType == types.ChecksumTypeComposite {
+		return "", nil
+	}
+	return aws.ToString(attrs.Checksum.ChecksumSHA256), nil
+}
+
+// RequestRestore asks S3 for a copy of the archived object kept restoredCopyDays, with the
+// standard retrieval tier. A restore already in progress is left to complete.
+func (s *s3Storage) RequestRestore(ctx context.Context, key string) error {
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(restoredCopyDays),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+		},
+	})
+	if err != nil && !isRestoreInProgressError(err) {
+		return s3StorageError(key, err)
+	}
+	return nil
+}
+
+// Close does nothing, as the client is shared by every backup
+func (s *s3Storage) Close() error {
+	return nil
+}
@@ -0,0 +1,247 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpPosixRename is the OpenSSH extension renaming a file over an existing one
+const sftpPosixRename = "posix-rename@openssh.com"
+
+// sftpStore keeps the files of a fileBackend in a directory of an SFTP server, reached through
+// the sftp subsystem of the ssh command, so logins use the keys and settings of the user's ssh.
+// Only SFTP requests are sent, so hosts allowing nothing else, or chrooting their users, work.
+// The store connects on its first request and keeps the connection until it is closed.
+type sftpStore struct {
+	root string
+	// command returns the command whose input and output are the SFTP connection
+	command func() *exec.Cmd
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *sftp.Client
+	// lost is closed once the connection of client ends
+	lost   chan struct{}
+	stderr *bytes.Buffer
+}
+
+// newSFTPStore creates an sftpStore keeping its files in root on the host of u
+func newSFTPStore(u *url.URL, root string) *sftpStore {
+	// Batch mode fails instead of asking for a password nobody can type in
+	args := []string{"-o", "BatchMode=yes"}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	host := u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		host = u.User.Username() + "@" + host
+	}
+	args = append(args, "-s", "--", host, "sftp")
+	return &sftpStore{
+		root: root,
+		command: func() *exec.Cmd {
+			return exec.Command("ssh", args...)
+		},
+	}
+}
+
+// connect returns the connection to the server, connecting again if the last one was lost
+func (s *sftpStore) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		select {
+		case <-s.lost:
+			s.disconnect()
+		default:
+			return s.client, nil
+		}
+	}
+
+	cmd := s.command()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to connect to the sftp server: %w", err)
+	}
+	// Writes of a file are sent without waiting for each other, as files are only renamed
+	// into place once all of them succeeded
+	client, err := sftp.NewClientPipe(stdout, stdin, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to connect to the sftp server: %w", sftpCommandError(err, stderr))
+	}
+	lost := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(lost)
+	}()
+	s.cmd, s.client, s.lost, s.stderr = cmd, client, lost, stderr
+	return client, nil
+}
+
+// disconnect closes the connection and waits for its command to end
+func (s *sftpStore) disconnect() error {
+	s.client.Close()
+	err := s.cmd.Wait()
+	if err != nil {
+		err = sftpCommandError(err, s.stderr)
+	}
+	s.cmd, s.client, s.lost, s.stderr = nil, nil, nil, nil
+	return err
+}
+
+// close closes the connection, if the store has one
+func (s *sftpStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	return s.disconnect()
+}
+
+// path returns the path of name on the server
+func (s *sftpStore) path(name string) string {
+	return s.root + "/" + name
+}
+
+// write sends r to a temporary file, which is only renamed into place once all of r was sent
+func (s *sftpStore) write(ctx context.Context, name string, r io.Reader) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	target := s.path(name)
+	tmp := target + "." + hex.EncodeToString(suffix) + storageTempExtension
+
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	file, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	_, err = file.ReadFrom(&contextReader{ctx: ctx, r: r})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = sftpRename(client, tmp, target)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// sftpRename renames from to to, replacing to if it exists. Without the posix-rename extension,
+// which version 3 of the protocol needs to replace files, to is removed first.
+func sftpRename(client *sftp.Client, from, to string) error {
+	if _, ok := client.HasExtension(sftpPosixRename); ok {
+		return client.PosixRename(from, to)
+	}
+	if err := client.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+// read opens the file name on the server
+func (s *sftpStore) read(ctx context.Context, name string) (io.ReadCloser, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(s.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return &sftpFileReader{contextReader: contextReader{ctx: ctx, r: file}, file: file}, nil
+}
+
+// sftpFileReader reads a file of the server until its context is cancelled
+type sftpFileReader struct {
+	contextReader
+	file *sftp.File
+}
+
+func (f *sftpFileReader) Close() error {
+	return f.file.Close()
+}
+
+// list reads dir on the server, which may not exist yet
+func (s *sftpStore) list(ctx context.Context, dir string) ([]string, []string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := client.ReadDir(strings.TrimSuffix(s.path(dir), "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var files, dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		} else {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, dirs, nil
+}
+
+// remove deletes the file name on the server
+func (s *sftpStore) remove(ctx context.Context, name string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	if err := client.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// sftpCommandError adds what the ssh command wrote to its error output to its error
+func sftpCommandError(err error, stderr *bytes.Buffer) error {
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("%w: %s", err, message)
+	}
+	return err
+}
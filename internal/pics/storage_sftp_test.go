@@ -0,0 +1,185 @@
+package pics
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// TestSFTPServerProcess is the SFTP server of pkg/sftp run by newTestSFTPStore, serving the files
+// under its working directory on its input and output. It does nothing as a test.
+func TestSFTPServerProcess(t *testing.T) {
+	root := os.Getenv("PICS_TEST_SFTP_ROOT")
+	if root == "" {
+		return
+	}
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{os.Stdin, os.Stdout}, sftp.WithServerWorkingDirectory(root))
+	if err == nil {
+		err = server.Serve()
+	}
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// sftpServers returns the commands of the SFTP servers the store is tested with, serving the
+// files under root: the server of pkg/sftp and, if it is installed, OpenSSH's sftp-server
+func sftpServers(t *testing.T) map[string]func(root string) *exec.Cmd {
+	servers := map[string]func(root string) *exec.Cmd{
+		"pkg-sftp": func(root string) *exec.Cmd {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestSFTPServerProcess$")
+			cmd.Env = append(os.Environ(), "PICS_TEST_SFTP_ROOT="+root)
+			return cmd
+		},
+	}
+	for _, candidate := range []string{"sftp-server", "/usr/lib/openssh/sftp-server", "/usr/libexec/openssh/sftp-server", "/usr/lib/ssh/sftp-server", "/usr/libexec/sftp-server"} {
+		if server, err := exec.LookPath(candidate); err == nil {
+			servers["openssh"] = func(root string) *exec.Cmd {
+				return exec.Command(server, "-d", root)
+			}
+			break
+		}
+	}
+	if _, ok := servers["openssh"]; !ok {
+		t.Log("OpenSSH's sftp-server isn't installed, testing with pkg/sftp only")
+	}
+	return servers
+}
+
+// newTestSFTPStore returns an sftpStore keeping its files in backups under serverRoot, on a
+// server started by command as ssh would start the sftp subsystem
+func newTestSFTPStore(serverRoot string, command func(root string) *exec.Cmd) *sftpStore {
+	return &sftpStore{
+		root: "backups",
+		command: func() *exec.Cmd {
+			return command(serverRoot)
+		},
+	}
+}
+
+func TestFileBackend_SFTP(t *testing.T) {
+	for name, server := range sftpServers(t) {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			testFileBackend(t, &fileBackend{store: newTestSFTPStore(root, server)})
+
+			// The server only holds the objects and their metadata
+			if _, err := os.Stat(filepath.Join(root, "backups", objectsMetaDir)); err != nil {
+				t.Errorf("Expected the metadata under the root of the store, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSFTPStore_LargeFiles(t *testing.T) {
+	// Files spanning many packets, written and read with several requests in flight
+	data := make([]byte, 5<<20+123)
+	rand.Read(data)
+	for name, server := range sftpServers(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newTestSFTPStore(t.TempDir(), server)
+			defer store.close()
+			if err := store.write(testCtx, "dir/archive.tar.gz", bytes.NewReader(data)); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			r, err := store.read(testCtx, "dir/archive.tar.gz")
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Expected the file back, got %d bytes (%v)", len(got), err)
+			}
+			if err := r.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+
+			// A file left unread closes
+			r, err = store.read(testCtx, "dir/archive.tar.gz")
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			r.Read(make([]byte, 10))
+			if err := r.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+
+			// So does a file written again over it
+			if err := store.write(testCtx, "dir/archive.tar.gz", strings.NewReader("replaced")); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if got := readTestSFTPFile(t, store, "dir/archive.tar.gz"); got != "replaced" {
+				t.Errorf("Expected the replaced file, got %d bytes", len(got))
+			}
+		})
+	}
+}
+
+// readTestSFTPFile returns the content of the file name of store
+func readTestSFTPFile(t *testing.T, store *sftpStore, name string) string {
+	t.Helper()
+	r, err := store.read(testCtx, name)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestSFTPStore_Errors(t *testing.T) {
+	root := t.TempDir()
+	store := newTestSFTPStore(root, sftpServers(t)["pkg-sftp"])
+	defer store.close()
+
+	if _, err := store.read(testCtx, "missing.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	if files, dirs, err := store.list(testCtx, "missing"); err != nil || files != nil || dirs != nil {
+		t.Errorf("Expected a missing directory to have no files, got %v %v (%v)", files, dirs, err)
+	}
+
+	writeContentFile(t, filepath.Join(root, "backups"), "file", "not a directory")
+	err := store.write(testCtx, "file/name", strings.NewReader("content"))
+	if err == nil || !strings.Contains(err.Error(), "file/name") {
+		t.Errorf("Expected the write to fail, got %v", err)
+	}
+	if files := listDir(t, filepath.Join(root, "backups")); len(files) != 1 {
+		t.Errorf("Expected no temporary file left, got %v", files)
+	}
+
+	// A connection that fails to start reports what ssh wrote
+	failing := &sftpStore{root: "backups", command: func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'Permission denied (publickey).' >&2; exit 255")
+	}}
+	if _, err := failing.read(testCtx, "file"); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("Expected the error of ssh, got %v", err)
+	}
+
+	// A lost connection is opened again by the next request
+	if err := store.write(testCtx, "name", strings.NewReader("content")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	store.cmd.Process.Kill()
+	<-store.lost
+	if got := readTestSFTPFile(t, store, "name"); got != "content" {
+		t.Errorf("Expected the file after connecting again, got %q", got)
+	}
+}
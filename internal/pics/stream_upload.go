@@ -0,0 +1,171 @@
+package pics
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// streamingSink is an archiveSink that takes archives as they are written, so they aren't
+// created on disk first
+type streamingSink interface {
+	archiveSink
+	// storeStream takes the archive write writes, which belongs to directory dirName, under
+	// key, and returns its size
+	storeStream(ctx context.Context, key, dirName string, write func(io.Writer) error) (int64, error)
+}
+
+// streamingUploadSink uploads archives to a bucket while they are written
+type streamingUploadSink struct {
+	*uploadSink
+}
+
+// storeStream uploads the archive unless the bucket already has it
+func (u *streamingUploadSink) storeStream(ctx context.Context, key, dirName string, write func(io.Writer) error) (int64, error) {
+	uploaded, size, err := u.backup.streamUnlessExists(ctx, u.bucket, key, dirName, u.opts, write)
+	if err != nil {
+		return 0, err
+	}
+	u.tally.uploadedArchive(dirName, uploaded, size)
+	return size, nil
+}
+
+// archiveHasher calculates the hashes of an archive as it is written: its MD5 hash, the ETag S3
+// gives it when uploaded in parts of streamPartSize, and its SHA-256 checksum if asked for
+type archiveHasher struct {
+	md5      hash.Hash
+	sha256   hash.Hash
+	part     hash.Hash
+	partLen  int64
+	partSums []byte
+	parts    int
+	size     int64
+}
+
+// newArchiveHasher creates an archiveHasher, calculating the SHA-256 checksum if withSHA256 is set
+func newArchiveHasher(withSHA256 bool) *archiveHasher {
+	h := &archiveHasher{md5: md5.New(), part: md5.New()}
+	if withSHA256 {
+		h.sha256 = sha256.New()
+	}
+	return h
+}
+
+// Write adds p to the hashes
+func (h *archiveHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.md5.Write(p)
+	if h.sha256 != nil {
+		h.sha256.Write(p)
+	}
+	for len(p) > 0 {
+		chunk := min(int64(len(p)), streamPartSize-h.partLen)
+		h.part.Write(p[:chunk])
+		h.partLen += chunk
+		p = p[chunk:]
+		if h.partLen == streamPartSize {
+			h.partSums = h.part.Sum(h.partSums)
+			h.part.Reset()
+			h.partLen = 0
+			h.parts++
+		}
+	}
+	h.size += int64(n)
+	return n, nil
+}
+
+// hashes returns the hashes of what was written so far
+func (h *archiveHasher) hashes() archiveHashes {
+	partSums, parts := append([]byte(nil), h.partSums...), h.parts
+	if h.partLen > 0 {
+		partSums = h.part.Sum(partSums)
+		parts++
+	}
+
+	hashes := archiveHashes{MD5: hex.EncodeToString(h.md5.Sum(nil))}
+	if parts > 0 {
+		sum := md5.Sum(partSums)
+		hashes.MultipartETag = fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts)
+	}
+	if h.sha256 != nil {
+		hashes.SHA256 = base64.StdEncoding.EncodeToString(h.sha256.Sum(nil))
+	}
+	return hashes
+}
+
+// streamUnlessExists uploads the archive write writes under key unless an object with the same
+// contents is already stored there, and returns whether it was uploaded and the size of the
+// archive. An existing object with different contents is an error.
+func (b *s3Backup) streamUnlessExists(ctx context.Context, bucket, key, dirName string, opts BackupOptions, write func(io.Writer) error) (bool, int64, error) {
+	existingKey, remoteETag, exists, err := b.existingArchive(ctx, bucket, key, dirName)
+	if err != nil {
+		return false, 0, err
+	}
+	if exists {
+		// The hashes of the archive are only known once it is written, so it is written to
+		// them alone to be compared
+		hasher := newArchiveHasher(opts.SHA256Checksums)
+		if err := write(hasher); err != nil {
+			return false, 0, fmt.Errorf("failed to create tar.gz: %w", err)
+		}
+		return false, hasher.size, b.matchExisting(ctx, bucket, existingKey, dirName, remoteETag, hasher.hashes())
+	}
+
+	logger.Info("Streaming to S3", "directory", dirName, "bucket", bucket, "key", key)
+	var tagging string
+	if opts.ObjectTags {
+		tagging = archiveTags(key)
+	}
+	hashes, size, err := b.streamToS3(ctx, bucket, key, tagging, opts.SHA256Checksums, write)
+	if err != nil {
+		return false, 0, err
+	}
+	logger.Debug("Streamed archive", "key", key, "size", size, "hash", hashes.MD5, "multipart_etag", hashes.MultipartETag)
+	return true, size, nil
+}
+
+// streamToS3 uploads the archive write writes under key while it is written, returning its hashes
+// and size. An archive smaller than streamPartSize is uploaded at once, a larger one in parts.
+// Non-empty tags (URL query encoded) are stored with the object, and with withSHA256 S3 verifies
+// the SHA-256 checksum of every part.
+func (b *s3Backup) streamToS3(ctx context.Context, bucket, key, tags string, withSHA256 bool, write func(io.Writer) error) (archiveHashes, int64, error) {
+	reader, writer := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := write(writer)
+		writer.CloseWithError(err)
+		written <- err
+	}()
+
+	hasher := newArchiveHasher(withSHA256)
+	_, uploadErr := b.storage.Put(ctx, key, io.TeeReader(reader, hasher), PutOptions{
+		Tags:          tags,
+		StorageClass:  b.objectStorageClass(key),
+		ChecksumParts: withSHA256,
+	})
+	// An upload that failed stops the archive being written
+	reader.CloseWithError(uploadErr)
+	writeErr := <-written
+
+	switch {
+	case writeErr != nil && (uploadErr == nil || !errors.Is(writeErr, uploadErr)):
+		return archiveHashes{}, 0, fmt.Errorf("failed to create tar.gz: %w", writeErr)
+	case uploadErr != nil:
+		return archiveHashes{}, 0, fmt.Errorf("failed to upload to S3: %w", uploadErr)
+	}
+	return hasher.hashes(), hasher.size, nil
+}
+
+// sha256Base64 returns the base64 encoded SHA-256 checksum of data, as S3 takes it
+func sha256Base64(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// writeRandomFile writes a file of size bytes that don't compress, so its archive is about as large
+func writeRandomFile(t *testing.T, dir, filename string, size int) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	writeContentFile(t, dir, filename, string(data))
+}
+
+func TestArchiveHasher(t *testing.T) {
+	data := make([]byte, 2*streamPartSize+1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	hasher := newArchiveHasher(true)
+	// Writes don't line up with the parts
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 3<<20+7)
+		hasher.Write(rest[:n])
+		rest = rest[n:]
+	}
+	hashes := hasher.hashes()
+
+	var partSums []byte
+	for start := 0; start < len(data); start += streamPartSize {
+		sum := md5.Sum(data[start:min(start+streamPartSize, len(data))])
+		partSums = append(partSums, sum[:]...)
+	}
+	sum := md5.Sum(partSums)
+	md5Sum := md5.Sum(data)
+	expected := archiveHashes{
+		MD5:           hex.EncodeToString(md5Sum[:]),
+		MultipartETag: hex.EncodeToString(sum[:]) + "-3",
+		SHA256:        sha256Base64(data),
+	}
+	if hashes != expected {
+		t.Errorf("Expected %+v, got %+v", expected, hashes)
+	}
+	if hasher.size != int64(len(data)) {
+		t.Errorf("Expected size %d, got %d", len(data), hasher.size)
+	}
+}
+
+func TestBackup_StreamArchives(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "beach")
+	writeRandomFile(t, filepath.Join(sourceDir, "2023 07 July 01", "videos"), "party.mov", streamPartSize+1000)
+	opts := BackupOptions{MaxConcurrent: 2, SHA256Checksums: true, ObjectTags: true, StreamArchives: true}
+	smallKey := "2023 06 June 15 (1 images, 0 videos).tar.gz"
+	largeKey := "2023 07 July 01 (0 images, 1 videos).tar.gz"
+
+	// Archives are streamed as they would have been staged
+	staged := NewInMemoryS3Client()
+	stagedBackup := &s3Backup{client: staged, extensions: NewExtensions()}
+	if _, err := stagedBackup.BackupDirectories(testCtx, sourceDir, "bucket", BackupOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	report, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts)
+	if err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if report.Uploaded != 2 || report.UploadedBytes < streamPartSize {
+		t.Errorf("Expected 2 archives uploaded, got %+v", report)
+	}
+	for _, key := range []string{smallKey, largeKey} {
+		want, _ := staged.GetObjectData("bucket", key)
+		got, err := client.GetObjectData("bucket", key)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected %s to be the archive a staged backup uploads (%v)", key, err)
+		}
+	}
+	if tags := client.GetObjectTagging("bucket", largeKey); !strings.Contains(tags, "videos=1") {
+		t.Errorf("Expected the streamed archive to be tagged, got %q", tags)
+	}
+	head, err := client.HeadObject(testCtx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String(largeKey)})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if !strings.HasSuffix(aws.ToString(head.ETag), "-2\"") {
+		t.Errorf("Expected the large archive to be uploaded in 2 parts, got ETag %s", aws.ToString(head.ETag))
+	}
+
+	// Existing archives, multipart ones included, are recognised without being uploaded again
+	report, err = backup.BackupDirectories(testCtx, sourceDir, "bucket", opts)
+	if err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if report.Uploaded != 0 || report.Existing != 2 {
+		t.Errorf("Expected both archives to exist already, got %+v", report)
+	}
+	opts.StreamArchives = false
+	if report, err = backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err != nil || report.Existing != 2 {
+		t.Errorf("Expected a staged backup to recognise the streamed archives, got %+v (%v)", report, err)
+	}
+	verify, err := backup.VerifyBackups(testCtx, sourceDir, "bucket", opts)
+	if err != nil || !verify.OK() || verify.Current != 2 {
+		t.Errorf("Expected the streamed archives to match their directories, got %+v (%v)", verify, err)
+	}
+
+	// A changed directory is refused, as when staged
+	writeContentFile(t, filepath.Join(sourceDir, "2023 06 June 15"), "beach.jpg", "edited beach")
+	opts.StreamArchives = true
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err == nil {
+		t.Error("Expected the changed directory to fail the backup")
+	}
+}
+
+func TestBackup_StreamArchives_SplitDirectory(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	dir := filepath.Join(sourceDir, "2023 06 June 15")
+	writeSizedFile(t, dir, "photo1.jpg", 600)
+	writeSizedFile(t, dir, "photo2.jpg", 600)
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+
+	opts := BackupOptions{MaxConcurrent: 1, MaxArchiveSize: 1000, StreamArchives: true}
+	if _, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", opts); err != nil {
+		t.Fatalf("BackupDirectories failed: %v", err)
+	}
+	if client.GetObjectCount("bucket") != 3 {
+		t.Errorf("Expected 2 parts and a manifest, got %d objects", client.GetObjectCount("bucket"))
+	}
+
+	targetDir := t.TempDir()
+	if _, err := backup.RestoreDirectories(testCtx, "bucket", targetDir, RestoreOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	for _, name := range []string{"photo1.jpg", "photo2.jpg"} {
+		if info, err := os.Stat(filepath.Join(targetDir, "2023 06 June 15", name)); err != nil || info.Size() != 600 {
+			t.Errorf("Expected %s restored, got %v", name, err)
+		}
+	}
+}
+
+// failingPartClient fails the upload of a part of a multipart upload
+type failingPartClient struct {
+	*InMemoryS3Client
+	failPart int32
+}
+
+func (c *failingPartClient) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if aws.ToInt32(params.PartNumber) == c.failPart {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return c.InMemoryS3Client.UploadPart(ctx, params, optFns...)
+}
+
+func TestBackup_StreamArchives_AbortsFailedUpload(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	writeRandomFile(t, filepath.Join(sourceDir, "2023 07 July 01", "videos"), "party.mov", 2*streamPartSize+1000)
+	client := &failingPartClient{InMemoryS3Client: NewInMemoryS3Client(), failPart: 2}
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+
+	report, err := backup.BackupDirectories(testCtx, sourceDir, "bucket", BackupOptions{MaxConcurrent: 1, StreamArchives: true})
+	if err == nil {
+		t.Fatalf("Expected the failed part to fail the backup, got %v", err)
+	}
+	// The only directory failing fails the backup as a whole
+	if len(report.Failed) != 0 || !strings.Contains(err.Error(), "2023 07 July 01") {
+		t.Errorf("Expected the backup to fail naming the directory, got %v (%v)", err, report.Failed)
+	}
+	if client.GetObjectCount("bucket") != 0 || client.PendingUploads() != 0 {
+		t.Errorf("Expected no object and the upload aborted, got %d objects and %d uploads", client.GetObjectCount("bucket"), client.PendingUploads())
+	}
+}
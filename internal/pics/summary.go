@@ -0,0 +1,258 @@
+package pics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+// Summary is the block shown at the end of a command: what the run did and what the user
+// should look at next. It is built from the report of the run, so the CLI and the UI show
+// the same text.
+type Summary struct {
+	// Title names the command and how long it took
+	Title string
+	// Lines are the counts and sizes of the run
+	Lines []string
+	// NextSteps are the follow-ups the run calls for, such as files to review
+	NextSteps []string
+}
+
+// String renders the summary as indented plain text
+func (s Summary) String() string {
+	var b strings.Builder
+	b.WriteString(s.Title + "\n")
+	for _, line := range s.Lines {
+		b.WriteString("  " + line + "\n")
+	}
+	if len(s.NextSteps) > 0 {
+		b.WriteString(i18n.T("summary.next_steps") + "\n")
+		for _, step := range s.NextSteps {
+			b.WriteString("  - " + step + "\n")
+		}
+	}
+	return b.String()
+}
+
+// Summary returns the end of run summary of a parse
+func (r ParseReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.parse", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines, i18n.T("summary.imported", r.Imported, FormatByteSize(r.ImportedBytes)))
+	if saved := r.SourceBytes - r.ImportedBytes; saved > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.compression_saved", FormatByteSize(saved)))
+	}
+	if len(r.TooSmall) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.too_small", len(r.TooSmall)))
+	}
+	if len(r.Moved) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.moved", len(r.Moved), FormatByteSize(r.MovedBytes)))
+	}
+	if r.Pruned > 0 || len(r.KeptDirs) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.pruned", r.Pruned, len(r.KeptDirs)))
+	}
+	if r.Located > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.located", r.Located))
+	}
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if len(r.Review) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.review", len(r.Review), r.ReviewDir))
+	}
+	if len(r.Changing) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.changing", len(r.Changing)))
+	}
+	for _, dir := range r.Trashes {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.trash", dir))
+	}
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary returns the end of run summary of a backup, or of its archive or upload phase
+func (r BackupReport) Summary() Summary {
+	var s Summary
+	if r.StagingDir != "" {
+		s.Title = i18n.T("summary.archive", formatDuration(r.Duration))
+		s.Lines = append(s.Lines, i18n.T("summary.staged", r.Directories, r.Staged, r.StagingDir))
+	} else {
+		uploaded, existing := "summary.uploaded", "summary.existing"
+		if r.Mode == BackupModeIncremental {
+			uploaded, existing = "summary.uploaded_files", "summary.existing_files"
+		}
+		s.Title = i18n.T("summary.backup", formatDuration(r.Duration))
+		s.Lines = append(s.Lines, i18n.T(uploaded, r.Directories, r.Uploaded, FormatByteSize(r.UploadedBytes)))
+		if r.Existing > 0 {
+			s.Lines = append(s.Lines, i18n.T(existing, r.Existing, FormatByteSize(r.ExistingBytes)))
+			s.Lines = append(s.Lines, i18n.T("summary.unchanged_dirs", r.UploadedDirectories, r.UnchangedDirectories))
+		}
+	}
+	s.Lines = appendFailedLine(s.Lines, r.Failed)
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if r.Staged > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.upload", r.Staged, r.StagingDir))
+	}
+	s.NextSteps = appendFailedStep(s.NextSteps, r.Failed)
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary returns the end of run summary of a restore
+func (r RestoreReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.restore", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines, i18n.T("summary.restored", r.Restored, FormatByteSize(r.DownloadedBytes)))
+	if r.Merged > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.merged", r.Merged, r.Added, r.Duplicates))
+	}
+	if r.Replaced > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.replaced", r.Replaced))
+	}
+	if r.Skipped > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.skipped_existing", r.Skipped))
+	}
+	s.Lines = appendFailedLine(s.Lines, r.Failed)
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if len(r.Incomplete) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.incomplete", len(r.Incomplete)))
+	}
+	if len(r.Thawing) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.thawing", len(r.Thawing)))
+	}
+	s.NextSteps = appendFailedStep(s.NextSteps, r.Failed)
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary describes the sync run for the user
+func (r SyncReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.sync", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines,
+		i18n.T("summary.pushed", r.Pushed, FormatByteSize(r.UploadedBytes)),
+		i18n.T("summary.pulled", r.Pulled, FormatByteSize(r.DownloadedBytes)),
+	)
+	if r.Merged > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.merged_conflicts", r.Merged))
+	}
+	if r.InSync > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.in_sync", r.InSync))
+	}
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if len(r.Conflicts) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.conflicts", len(r.Conflicts)))
+	}
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary returns the end of run summary of a merge of libraries
+func (r MergeLibrariesReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.merge", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines,
+		i18n.T("summary.merged_libraries", r.Merged, r.Created),
+		i18n.T("summary.merged_files", r.Added, r.Duplicates),
+	)
+	if len(r.Skipped) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.merge_skipped", len(r.Skipped)))
+	}
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary returns the end of run summary of a scrub
+func (r ScrubReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.scrub", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines, i18n.T("summary.verified", r.Verified))
+	if r.Added > 0 || r.Updated > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.checksums_recorded", r.Added, r.Updated))
+	}
+	if r.Skipped > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.recently_verified", r.Skipped))
+	}
+	if len(r.Repaired) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.repaired", len(r.Repaired)))
+	}
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if len(r.Quarantined) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.quarantined", len(r.Quarantined), r.QuarantineDir))
+	}
+	if len(r.Corrupted) > 0 {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.corrupted", len(r.Corrupted)))
+	}
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// Summary returns the end of run summary of a backup verification
+func (r BackupVerifyReport) Summary() Summary {
+	s := Summary{Title: i18n.T("summary.verify_backup", formatDuration(r.Duration))}
+	s.Lines = append(s.Lines, i18n.T("summary.backup_current", r.Current, r.Directories))
+	if len(r.Stale) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.backup_stale", len(r.Stale)))
+	}
+	if len(r.Missing) > 0 {
+		s.Lines = append(s.Lines, i18n.T("summary.backup_missing", len(r.Missing)))
+	}
+	s.Lines = append(s.Lines, i18n.T("summary.warnings", r.Warnings))
+
+	if !r.OK() {
+		s.NextSteps = append(s.NextSteps, i18n.T("summary.next.backup_outdated", len(r.Stale)+len(r.Missing)))
+	}
+	s.NextSteps = appendWarningsStep(s.NextSteps, r.Warnings)
+	return s
+}
+
+// appendWarningsStep asks the user to read the warnings of the run, if there were any
+func appendWarningsStep(steps []string, warnings int) []string {
+	if warnings == 0 {
+		return steps
+	}
+	return append(steps, i18n.T("summary.next.warnings", warnings))
+}
+
+// appendFailedLine adds the number of directories that failed to lines, if any did
+func appendFailedLine(lines []string, failed []ItemFailure) []string {
+	if len(failed) == 0 {
+		return lines
+	}
+	return append(lines, i18n.T("summary.failed", len(failed)))
+}
+
+// appendFailedStep adds retrying the directories that failed to steps, if any did
+func appendFailedStep(steps []string, failed []ItemFailure) []string {
+	if len(failed) == 0 {
+		return steps
+	}
+	return append(steps, i18n.T("summary.next.failed", len(failed)))
+}
+
+// formatDuration rounds d to whole seconds, or to milliseconds for runs shorter than a second
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// FormatByteSize formats bytes with a binary unit (1KB = 1024 bytes), e.g. "1.5MB"
+func FormatByteSize(bytes int64) string {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+	}
+	for _, unit := range units {
+		if bytes >= unit.multiplier {
+			return strconv.FormatFloat(float64(bytes)/float64(unit.multiplier), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
@@ -0,0 +1,368 @@
+package pics
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+)
+
+// useLanguage switches the language of the summaries for the duration of the test
+func useLanguage(t *testing.T, lang i18n.Language) {
+	t.Helper()
+	original := i18n.CurrentLanguage()
+	i18n.SetLanguage(lang)
+	t.Cleanup(func() { i18n.SetLanguage(original) })
+}
+
+func TestParseReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := ParseReport{
+		Review:        []ReviewFile{{Name: "IMG_0001.jpg"}, {Name: "IMG_0002.jpg"}, {Name: "IMG_0003.jpg"}},
+		Changing:      []string{"/photos/IMG_0004.jpg"},
+		TooSmall:      []string{"/photos/thumb.jpg"},
+		Moved:         []string{"/photos/IMG_0005.jpg", "/photos/IMG_0006.jpg"},
+		MovedBytes:    5 << 20,
+		Trashes:       []string{"/photos"},
+		Pruned:        3,
+		KeptDirs:      []KeptDir{{Dir: "/photos/MISC", Reason: KeptDirFiles}},
+		Located:       4,
+		ReviewDir:     "/library/review",
+		Imported:      120,
+		SourceBytes:   3 << 30,
+		ImportedBytes: 2 << 30,
+		Warnings:      5,
+		Duration:      2*time.Minute + 3400*time.Millisecond,
+	}
+
+	summary := report.Summary()
+	if summary.Title != "Parse finished in 2m3s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expectedLines := []string{
+		"Imported 120 files (2.0GB)",
+		"Compression saved 1.0GB",
+		"Skipped 1 files smaller than the minimum file size",
+		"Moved 2 imported files (5.0MB) to the trash of the source",
+		"Removed 3 emptied source directories, left 1 in place",
+		"Named 4 directories after the place they were taken at",
+		"5 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{
+		"3 files have implausible dates, review them in /library/review",
+		"1 files were still being written, import them again once they are complete",
+		"The imported files still take up space in the trash, free it once the library is checked with: pics trash empty /photos",
+		"Read the 5 warnings in the log",
+	}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+}
+
+func TestParseReport_Summary_NothingToDo(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := ParseReport{Imported: 3, SourceBytes: 1024, ImportedBytes: 1024}.Summary()
+	if len(summary.NextSteps) != 0 {
+		t.Errorf("Expected no next steps, got %v", summary.NextSteps)
+	}
+	for _, line := range summary.Lines {
+		if strings.Contains(line, "saved") {
+			t.Errorf("Expected no compression line for uncompressed files, got %q", line)
+		}
+	}
+}
+
+func TestBackupReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := BackupReport{Directories: 4, Uploaded: 3, UploadedBytes: 1572864, Existing: 2, ExistingBytes: 2097152,
+		UploadedDirectories: 3, UnchangedDirectories: 1, Duration: 500 * time.Millisecond}.Summary()
+	if summary.Title != "Backup finished in 500ms" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expected := []string{
+		"Backed up 4 directories, uploaded 3 archives (1.5MB)",
+		"2 archives (2.0MB) were already in the bucket and not uploaded",
+		"3 directories needed uploading, 1 were unchanged",
+		"0 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expected) {
+		t.Errorf("Expected lines %v, got %v", expected, summary.Lines)
+	}
+	if len(summary.NextSteps) != 0 {
+		t.Errorf("Expected no next steps, got %v", summary.NextSteps)
+	}
+}
+
+func TestBackupReport_Summary_Incremental(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := BackupReport{Mode: BackupModeIncremental, Directories: 2, Uploaded: 1, UploadedBytes: 1048576, Existing: 40, ExistingBytes: 41943040}.Summary()
+	expected := []string{
+		"Backed up 2 directories, uploaded 1 files (1.0MB)",
+		"40 files (40.0MB) were unchanged since the last backup and not uploaded",
+	}
+	if !reflect.DeepEqual(summary.Lines[:2], expected) {
+		t.Errorf("Expected lines %v, got %v", expected, summary.Lines)
+	}
+}
+
+func TestBackupReport_Summary_Staged(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := BackupReport{Directories: 2, Staged: 4, StagingDir: "/mnt/usb/staging"}.Summary()
+	if !strings.HasPrefix(summary.Title, "Archiving finished") {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expected := []string{"Upload the 4 staged archives with --upload-only /mnt/usb/staging"}
+	if !reflect.DeepEqual(summary.NextSteps, expected) {
+		t.Errorf("Expected next steps %v, got %v", expected, summary.NextSteps)
+	}
+}
+
+func TestBackupReport_Summary_Failed(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := BackupReport{Directories: 3, Uploaded: 1, UploadedBytes: 1048576, Failed: []ItemFailure{
+		{Item: "2024 01 January 01", Err: errors.New("access denied")},
+		{Item: "2024 02 February 02", Err: errors.New("access denied")},
+	}}.Summary()
+	expectedLines := []string{
+		"Backed up 3 directories, uploaded 1 archives (1.0MB)",
+		"2 directories failed",
+		"0 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{"Run again to retry the 2 directories that failed"}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+}
+
+func TestRestoreReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := RestoreReport{
+		Restored:        2,
+		Merged:          1,
+		Added:           5,
+		Duplicates:      3,
+		DownloadedBytes: 819200,
+		Incomplete:      []string{"a.part-0001.tar.gz", "a.part-0002.tar.gz"},
+		Thawing:         []string{"2023 06 June 15"},
+		Warnings:        2,
+	}
+
+	summary := report.Summary()
+	expectedLines := []string{
+		"Restored 2 directories (800.0KB downloaded)",
+		"Merged 1 directories: 5 files added, 3 duplicates dropped",
+		"2 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{
+		"2 archive parts have no manifest, back up their directories again",
+		"1 directories are being restored from cold storage, which takes hours, restore again later or wait with --wait-for-restore",
+		"Read the 2 warnings in the log",
+	}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+}
+
+func TestSyncReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := SyncReport{
+		Pushed:          2,
+		Pulled:          1,
+		InSync:          10,
+		Conflicts:       []string{"2023 06 June 15"},
+		UploadedBytes:   2048,
+		DownloadedBytes: 1572864,
+		Duration:        3 * time.Second,
+	}
+
+	summary := report.Summary()
+	if summary.Title != "Sync finished in 3s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expectedLines := []string{
+		"Pushed 2 directories (2.0KB uploaded)",
+		"Pulled 1 directories (1.5MB downloaded)",
+		"10 directories were already in sync",
+		"0 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{"1 directories changed on both sides were skipped, sync again with --merge-conflicts to combine them"}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+}
+
+func TestSummary_String(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	summary := Summary{
+		Title:     "Parse finished in 3s",
+		Lines:     []string{"Imported 2 files (1.0MB)", "0 warnings"},
+		NextSteps: []string{"1 files have implausible dates, review them in /library/review"},
+	}
+
+	expected := "Parse finished in 3s\n" +
+		"  Imported 2 files (1.0MB)\n" +
+		"  0 warnings\n" +
+		"Next steps:\n" +
+		"  - 1 files have implausible dates, review them in /library/review\n"
+	if got := summary.String(); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestRestoreReport_Summary_Spanish(t *testing.T) {
+	useLanguage(t, i18n.Spanish)
+
+	summary := RestoreReport{Restored: 1, DownloadedBytes: 2048, Duration: 4 * time.Second}.Summary()
+	if summary.Title != "Restauración terminada en 4s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	if summary.Lines[0] != "1 directorios restaurados (2.0KB descargados)" {
+		t.Errorf("Unexpected line: %q", summary.Lines[0])
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{input: 0, expected: "0B"},
+		{input: 500, expected: "500B"},
+		{input: 819200, expected: "800.0KB"},
+		{input: 1572864, expected: "1.5MB"},
+		{input: 2147483648, expected: "2.0GB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := FormatByteSize(tt.input); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestScrubReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := ScrubReport{
+		Verified:      120,
+		Added:         3,
+		Updated:       1,
+		Skipped:       40,
+		Repaired:      []string{"2023 06 June 15/2023_06_June_15_00001.jpg"},
+		Quarantined:   []string{"2023 06 June 15/2023_06_June_15_00002.jpg"},
+		Corrupted:     []string{"2023 07 July 01/2023_07_July_01_00001.jpg"},
+		QuarantineDir: "/library/.pics-quarantine",
+		Warnings:      3,
+		Duration:      42 * time.Second,
+	}
+
+	summary := report.Summary()
+	if summary.Title != "Scrub finished in 42s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expectedLines := []string{
+		"Verified 120 files",
+		"Recorded the checksums of 3 new and 1 modified files",
+		"40 files were verified recently and skipped",
+		"Repaired 1 corrupted files from the backup",
+		"3 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{
+		"1 corrupted files were moved to /library/.pics-quarantine, restore them from another copy",
+		"1 files are corrupted or unreadable, restore them from another copy",
+		"Read the 3 warnings in the log",
+	}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+}
+
+func TestBackupVerifyReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := BackupVerifyReport{
+		Directories: 5,
+		Current:     2,
+		Stale:       []string{"2023 06 June 15", "2023 07 July 01"},
+		Missing:     []string{"2023 10 October 01"},
+		Duration:    3 * time.Second,
+	}
+
+	summary := report.Summary()
+	if summary.Title != "Backup verification finished in 3s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expectedLines := []string{
+		"2 of 5 directories match their backup",
+		"2 directories changed since they were backed up",
+		"1 directories were never backed up",
+		"0 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+	expectedSteps := []string{"Back up the 3 directories whose backup is out of date"}
+	if !reflect.DeepEqual(summary.NextSteps, expectedSteps) {
+		t.Errorf("Expected next steps %v, got %v", expectedSteps, summary.NextSteps)
+	}
+
+	if steps := (BackupVerifyReport{Directories: 2, Current: 2}).Summary().NextSteps; len(steps) != 0 {
+		t.Errorf("Expected no next steps when every backup matches, got %v", steps)
+	}
+}
+
+func TestMergeLibrariesReport_Summary(t *testing.T) {
+	useLanguage(t, i18n.English)
+
+	report := MergeLibrariesReport{
+		Merged:     3,
+		Created:    2,
+		Added:      40,
+		Duplicates: 12,
+		Skipped:    []string{"notes.txt"},
+		Duration:   5 * time.Second,
+	}
+
+	summary := report.Summary()
+	if summary.Title != "Merge finished in 5s" {
+		t.Errorf("Unexpected title: %q", summary.Title)
+	}
+	expectedLines := []string{
+		"Merged 3 directories into existing ones and copied 2 new ones",
+		"40 files added, 12 duplicates skipped",
+		"1 entries of the source library that aren't directories skipped",
+		"0 warnings",
+	}
+	if !reflect.DeepEqual(summary.Lines, expectedLines) {
+		t.Errorf("Expected lines %v, got %v", expectedLines, summary.Lines)
+	}
+}
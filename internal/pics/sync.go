@@ -0,0 +1,594 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acm19/pics/internal/i18n"
+	"github.com/acm19/pics/internal/logger"
+	"github.com/acm19/pics/internal/naming"
+)
+
+const (
+	// syncStatePrefix is the prefix of the objects recording the synced state of each directory
+	syncStatePrefix = "sync/"
+	// syncStateExtension is the suffix of the objects recording the synced state of each directory
+	syncStateExtension = ".json"
+	// syncBaseName is the file of a library recording the state of each directory at its last sync
+	syncBaseName = ".pics-sync.json"
+)
+
+// errSyncConflict is returned when the state of a directory in the bucket changed while it was synced
+var errSyncConflict = errors.New("directory changed in the bucket during the sync")
+
+// directoryState is the state of a directory as last synced: its files and the archive holding them
+type directoryState struct {
+	// Directory is the name of the directory
+	Directory string `json:"directory"`
+	// Key is the key of the archive holding the directory, or of the manifest of its parts
+	Key string `json:"key,omitempty"`
+	// Size is the size of the archive in bytes (0 for split directories)
+	Size int64 `json:"size,omitempty"`
+	// Files lists the files of the directory, sorted by path
+	Files []stateFile `json:"files"`
+}
+
+// stateFile is a file of a synced directory
+type stateFile struct {
+	// Path is the path of the file in the directory, using "/" as separator
+	Path string `json:"path"`
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+	// ModTime is the modification time of the file in Unix seconds, rounded to the nearest
+	// second like tar headers so a directory reads the same after a round trip through an archive
+	ModTime int64 `json:"modTime"`
+}
+
+// version identifies the contents of the directory by the path, size and modification time of
+// its files, without reading them. It doesn't depend on the archive, so the same files read the
+// same on every machine.
+func (s directoryState) version() string {
+	hash := sha256.New()
+	for _, file := range s.Files {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", file.Path, file.Size, file.ModTime)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readDirectoryState lists the files of the directory at dirPath, leaving out paths matched by
+// skip like the archives do
+func readDirectoryState(dirPath string, skip skipFunc) (directoryState, error) {
+	state := directoryState{Directory: filepath.Base(dirPath), Files: []stateFile{}}
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dirPath && skip.skips(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		state.Files = append(state.Files, stateFile{
+			Path:    filepath.ToSlash(relPath),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Round(time.Second).Unix(),
+		})
+		return nil
+	})
+	if err != nil {
+		return directoryState{}, err
+	}
+	sort.Slice(state.Files, func(i, j int) bool { return state.Files[i].Path < state.Files[j].Path })
+	return state, nil
+}
+
+// syncStateKey returns the key of the object recording the synced state of a directory
+func syncStateKey(dirName string) string {
+	return syncStatePrefix + naming.EncodeKeyName(dirName) + syncStateExtension
+}
+
+// isSyncStateKey reports whether key records the synced state of a directory rather than an archive
+func isSyncStateKey(key string) bool {
+	return strings.HasPrefix(key, syncStatePrefix)
+}
+
+// syncBase records the version of each directory at its last sync with each bucket. A directory
+// whose version differs from it changed on that side since.
+type syncBase struct {
+	// Buckets maps each bucket to the versions of its directories
+	Buckets map[string]map[string]string `json:"buckets"`
+
+	mu sync.Mutex
+}
+
+// loadSyncBase reads the sync base of a library, or returns an empty one if it was never synced
+func loadSyncBase(libraryDir string) (*syncBase, error) {
+	base := &syncBase{Buckets: make(map[string]map[string]string)}
+	data, err := os.ReadFile(filepath.Join(libraryDir, syncBaseName))
+	if os.IsNotExist(err) {
+		return base, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, base); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", syncBaseName, err)
+	}
+	if base.Buckets == nil {
+		base.Buckets = make(map[string]map[string]string)
+	}
+	return base, nil
+}
+
+// version returns the version of a directory at its last sync with bucket ("" = never synced)
+func (s *syncBase) version(bucket, dirName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Buckets[bucket][dirName]
+}
+
+// set records the version of a directory synced with bucket
+func (s *syncBase) set(bucket, dirName, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Buckets[bucket] == nil {
+		s.Buckets[bucket] = make(map[string]string)
+	}
+	s.Buckets[bucket][dirName] = version
+}
+
+// save writes the sync base to the library, replacing the previous one at once
+func (s *syncBase) save(libraryDir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(libraryDir, syncBaseName, data)
+}
+
+// writeFileAtomically writes data to the file name of dir through a temporary file, so readers
+// see either the previous contents or the new ones
+func writeFileAtomically(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// syncAction is what a sync does with a directory
+type syncAction int
+
+const (
+	// syncNone leaves a directory that is the same on both sides alone
+	syncNone syncAction = iota
+	// syncPush uploads a directory that only changed locally
+	syncPush
+	// syncPull downloads a directory that only changed in the bucket
+	syncPull
+	// syncConflict flags a directory that changed on both sides
+	syncConflict
+)
+
+// decideSync compares the local and remote states of a directory (nil = missing on that side)
+// with its version at the last sync. Deletions are not synced: a directory missing on one side
+// is copied from the other.
+func decideSync(local, remote *directoryState, base string) syncAction {
+	switch {
+	case remote == nil:
+		return syncPush
+	case local == nil:
+		return syncPull
+	}
+	localVersion, remoteVersion := local.version(), remote.version()
+	switch {
+	case localVersion == remoteVersion:
+		return syncNone
+	case remoteVersion == base:
+		return syncPush
+	case localVersion == base:
+		return syncPull
+	}
+	return syncConflict
+}
+
+// remoteState is the synced state of a directory read from the bucket, with the ETag of its object
+type remoteState struct {
+	state directoryState
+	etag  string
+}
+
+// syncJob is a directory to push, pull or merge
+type syncJob struct {
+	dirName string
+	action  syncAction
+	local   *directoryState
+	remote  *remoteState
+}
+
+// syncTally counts the directories and bytes of a sync run, shared by its workers
+type syncTally struct {
+	pushed          atomic.Int64
+	pulled          atomic.Int64
+	merged          atomic.Int64
+	downloadedBytes atomic.Int64
+	backup          *backupTally
+
+	mu        sync.Mutex
+	conflicts []string
+}
+
+// conflict records a directory changed on both sides
+func (t *syncTally) conflict(dirName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conflicts = append(t.conflicts, dirName)
+}
+
+// SyncDirectories brings the date directories of libraryDir and bucket in line, uploading those
+// that changed locally and downloading those that changed in the bucket since the last sync.
+// Directories changed on both sides are conflicts: they are left alone and reported unless
+// opts.MergeConflicts is set. Files are never deleted or overwritten on either side.
+func (b *s3Backup) SyncDirectories(ctx context.Context, bucket, libraryDir string, opts SyncOptions) (SyncReport, error) {
+	start, warnings := time.Now(), logger.Warnings()
+	if err := validateStagingDir(opts.StagingDir); err != nil {
+		return SyncReport{}, err
+	}
+	b, bucket, err := b.forBucket(bucket)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	defer b.storage.Close()
+	b, invalidateInventory := b.withInventoryInvalidation(opts.InventoryCacheDir, bucket)
+	defer invalidateInventory()
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultSyncOptions().MaxConcurrent
+	}
+	// A new machine starts with an empty library and pulls everything
+	if err := os.MkdirAll(libraryDir, libraryDirMode); err != nil {
+		return SyncReport{}, fmt.Errorf("failed to create library directory: %w", err)
+	}
+
+	ignore, err := newIgnoreMatcher(libraryDir)
+	if err != nil {
+		return SyncReport{}, fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	skip := skipFunc(ignore.isIgnored)
+
+	base, err := loadSyncBase(libraryDir)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	local, err := b.localStates(libraryDir, skip)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	remote, err := b.remoteStates(ctx, bucket, opts.MaxConcurrent)
+	if err != nil {
+		return SyncReport{}, err
+	}
+
+	tally := &syncTally{backup: newBackupTally()}
+	var jobs []syncJob
+	inSync := 0
+	for _, dirName := range syncDirectoryNames(local, remote) {
+		job := syncJob{dirName: dirName, local: local[dirName], remote: remote[dirName]}
+		var remoteDirState *directoryState
+		if job.remote != nil {
+			remoteDirState = &job.remote.state
+		}
+
+		job.action = decideSync(job.local, remoteDirState, base.version(bucket, dirName))
+		switch {
+		case job.action == syncNone:
+			// Directories synced from another machine get their base on the first run
+			base.set(bucket, dirName, job.local.version())
+			inSync++
+		case job.action == syncConflict && !opts.MergeConflicts:
+			logger.Warn("Directory changed locally and in the bucket since the last sync, skipping", "directory", dirName)
+			tally.conflict(dirName)
+		default:
+			jobs = append(jobs, job)
+		}
+	}
+
+	defer func() {
+		if err := base.save(libraryDir); err != nil {
+			logger.Warn("Failed to record the synced directories", "library", libraryDir, "error", err)
+		}
+	}()
+
+	logger.Info("Syncing directories", "bucket", bucket, "library", libraryDir, "directories", len(jobs), "in_sync", inSync, "conflicts", len(tally.conflicts))
+	space := newStagingSpace(opts.StagingDir)
+	var processedCount atomic.Int64
+	err = runWorkerPool(ctx, jobs, opts.MaxConcurrent, func(job syncJob) error {
+		processedCount.Add(1)
+
+		dirCtx := ctx
+		if opts.ProgressChan != nil {
+			current := processedCount.Load()
+
+			event := ProgressEvent{
+				Stage:   "syncing",
+				Current: int(current),
+				Total:   len(jobs),
+				Message: i18n.T("progress.syncing", current, len(jobs)),
+				File:    job.dirName,
+			}
+			select {
+			case opts.ProgressChan <- event:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "syncing")
+			}
+			// Uploading or downloading the directory reports its bytes as progress of this event
+			dirCtx = withByteProgress(ctx, opts.ProgressChan, event, &processedCount)
+		}
+
+		err := b.syncDirectory(dirCtx, bucket, libraryDir, job, opts, space, skip, base, tally)
+		if errors.Is(err, errSyncConflict) {
+			logger.Warn("Directory changed in the bucket during the sync, skipping", "directory", job.dirName)
+			tally.conflict(job.dirName)
+			return nil
+		}
+		if err != nil {
+			logger.Error("Failed to sync directory", "directory", job.dirName, "error", err)
+			return fmt.Errorf("directory %s: %w", job.dirName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Sync completed with errors", "error", err)
+		return SyncReport{}, err
+	}
+
+	sort.Strings(tally.conflicts)
+	recordLibraryActivity(libraryDir, func(a *libraryActivity) { a.LastBackup = time.Now() })
+	logger.Info("Sync completed successfully", "pushed", tally.pushed.Load(), "pulled", tally.pulled.Load(), "merged", tally.merged.Load())
+	return SyncReport{
+		Pushed:          int(tally.pushed.Load()),
+		Pulled:          int(tally.pulled.Load()),
+		Merged:          int(tally.merged.Load()),
+		InSync:          inSync,
+		Conflicts:       tally.conflicts,
+		UploadedBytes:   tally.backup.uploadedBytes.Load(),
+		DownloadedBytes: tally.downloadedBytes.Load(),
+		Warnings:        logger.Warnings() - warnings,
+		Duration:        time.Since(start),
+	}, nil
+}
+
+// syncDirectory pushes or pulls a directory, or for a conflict pulls it and pushes the
+// combined files, and records its new version in base
+func (b *s3Backup) syncDirectory(ctx context.Context, bucket, libraryDir string, job syncJob, opts SyncOptions, space *stagingSpace, skip skipFunc, base *syncBase, tally *syncTally) error {
+	if job.action == syncPull || job.action == syncConflict {
+		if err := b.pullDirectory(ctx, bucket, libraryDir, job.remote.state, opts, space, tally); err != nil {
+			return err
+		}
+		if job.action == syncPull {
+			base.set(bucket, job.dirName, job.remote.state.version())
+			tally.pulled.Add(1)
+			return nil
+		}
+
+		// Push the combined files over the state just pulled
+		state, err := readDirectoryState(filepath.Join(libraryDir, job.dirName), skip)
+		if err != nil {
+			return fmt.Errorf("failed to read merged directory: %w", err)
+		}
+		job.local = &state
+	}
+
+	if err := b.pushDirectory(ctx, bucket, libraryDir, *job.local, job.remote, opts, space, skip, tally); err != nil {
+		return err
+	}
+	base.set(bucket, job.dirName, job.local.version())
+	if job.action == syncConflict {
+		tally.merged.Add(1)
+	} else {
+		tally.pushed.Add(1)
+	}
+	return nil
+}
+
+// pushDirectory uploads the archive of a directory and then its state, which only replaces the
+// remote state it was decided against (nil = only if there is none), so concurrent pushes from
+// two machines can't overwrite each other. The archive key carries the version of the
+// directory, so the archive of the previous version stays in place until the new state names
+// the new one, and is only deleted then.
+func (b *s3Backup) pushDirectory(ctx context.Context, bucket, libraryDir string, state directoryState, remote *remoteState, opts SyncOptions, space *stagingSpace, skip skipFunc, tally *syncTally) error {
+	backupOpts := BackupOptions{StagingDir: opts.StagingDir, MaxArchiveSize: opts.MaxArchiveSize, ObjectTags: opts.ObjectTags}
+	sink := &uploadSink{backup: b, bucket: bucket, opts: backupOpts, tally: tally.backup}
+	version := state.version()
+	key, err := b.backupDirectory(ctx, libraryDir, state.Directory, " sync-"+version[:12], backupOpts, space, skip, sink)
+	if err != nil {
+		return err
+	}
+	state.Key = key
+	if !isManifestKey(key) {
+		head, err := b.storage.Head(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check uploaded archive: %w", err)
+		}
+		state.Size = head.Size
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode directory state: %w", err)
+	}
+	putOpts := PutOptions{IfNoneMatch: remote == nil}
+	if remote != nil {
+		putOpts.IfMatch = remote.etag
+	}
+	if _, err := b.storage.Put(ctx, syncStateKey(state.Directory), bytes.NewReader(data), putOpts); err != nil {
+		if errors.Is(err, ErrObjectChanged) {
+			return errSyncConflict
+		}
+		return fmt.Errorf("failed to upload directory state: %w", err)
+	}
+	logger.Info("Pushed directory", "directory", state.Directory, "key", key)
+
+	if remote != nil && remote.state.Key != key && b.extractDirNameFromKey(remote.state.Key) == state.Directory {
+		if err := b.deleteArchive(ctx, bucket, remote.state.Key); err != nil {
+			logger.Warn("Failed to delete previous archive of directory", "directory", state.Directory, "key", remote.state.Key, "error", err)
+		}
+	}
+	return nil
+}
+
+// deleteArchive deletes an archive, or the parts of a split archive followed by its manifest
+func (b *s3Backup) deleteArchive(ctx context.Context, bucket, key string) error {
+	if isManifestKey(key) {
+		manifest, err := b.readManifest(ctx, bucket, key, newBucketInventory(bucket, nil, nil))
+		if err != nil {
+			return err
+		}
+		for _, part := range manifest.Parts {
+			if err := b.storage.Delete(ctx, part.Key); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", part.Key, err)
+			}
+		}
+	}
+	if err := b.storage.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	logger.Debug("Deleted previous archive", "key", key)
+	return nil
+}
+
+// pullDirectory downloads the archive recorded in the state of a directory, merging it into the
+// local directory if there is one
+func (b *s3Backup) pullDirectory(ctx context.Context, bucket, libraryDir string, state directoryState, opts SyncOptions, space *stagingSpace, tally *syncTally) error {
+	if state.Key == "" || isSyncStateKey(state.Key) {
+		return fmt.Errorf("state of %s names no archive", state.Directory)
+	}
+	if dirName := b.extractDirNameFromKey(state.Key); dirName != state.Directory {
+		return fmt.Errorf("state of %s names the archive of another directory: %s", state.Directory, state.Key)
+	}
+
+	restoreOpts := RestoreOptions{StagingDir: opts.StagingDir, OnConflict: RestorePolicyMerge}
+	restore := &restoreTally{}
+	obj := StoredObject{Key: state.Key, Size: state.Size}
+	if err := b.restoreObject(ctx, bucket, libraryDir, restoreOpts, space, newBucketInventory(bucket, nil, nil), obj, restore); err != nil {
+		return err
+	}
+	tally.downloadedBytes.Add(restore.downloadedBytes.Load())
+	logger.Info("Pulled directory", "directory", state.Directory, "key", state.Key)
+	return nil
+}
+
+// localStates reads the state of every directory of the library, leaving out dot directories
+// and those matched by skip
+func (b *s3Backup) localStates(libraryDir string, skip skipFunc) (map[string]*directoryState, error) {
+	entries, err := os.ReadDir(libraryDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library directory: %w", err)
+	}
+
+	states := make(map[string]*directoryState)
+	for _, entry := range entries {
+		dirPath := filepath.Join(libraryDir, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || skip.skips(dirPath, true) {
+			continue
+		}
+		state, err := readDirectoryState(dirPath, skip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %s: %w", entry.Name(), err)
+		}
+		states[entry.Name()] = &state
+	}
+	return states, nil
+}
+
+// remoteStates downloads the state of every directory synced to bucket
+func (b *s3Backup) remoteStates(ctx context.Context, bucket string, maxConcurrent int) (map[string]*remoteState, error) {
+	objects, _, err := b.storage.List(ctx, syncStatePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list synced directories: %w", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, syncStateExtension) {
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	var mu sync.Mutex
+	states := make(map[string]*remoteState)
+	err = runWorkerPool(ctx, keys, maxConcurrent, func(key string) error {
+		body, obj, err := b.storage.Get(ctx, key, "")
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", key, err)
+		}
+		defer body.Close()
+
+		var state directoryState
+		if err := json.NewDecoder(body).Decode(&state); err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		if syncStateKey(state.Directory) != key || !isSafeDirectoryName(state.Directory) {
+			logger.Warn("Skipping directory state that doesn't match its key", "key", key, "directory", state.Directory)
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		states[state.Directory] = &remoteState{state: state, etag: obj.ETag}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// isSafeDirectoryName reports whether name can be used as a directory of the library without
+// escaping it
+func isSafeDirectoryName(name string) bool {
+	return name != "" && name != "." && !strings.Contains(name, "..") && !strings.ContainsAny(name, `/\`)
+}
+
+// syncDirectoryNames returns the directories present on either side, sorted
+func syncDirectoryNames(local map[string]*directoryState, remote map[string]*remoteState) []string {
+	var names []string
+	for name := range local {
+		names = append(names, name)
+	}
+	for name := range remote {
+		if local[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,264 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecideSync(t *testing.T) {
+	a := &directoryState{Files: []stateFile{{Path: "a.jpg", Size: 1, ModTime: 100}}}
+	b := &directoryState{Files: []stateFile{{Path: "b.jpg", Size: 1, ModTime: 100}}}
+	c := &directoryState{Files: []stateFile{{Path: "c.jpg", Size: 1, ModTime: 100}}}
+
+	tests := []struct {
+		name          string
+		local, remote *directoryState
+		base          string
+		expected      syncAction
+	}{
+		{name: "only local", local: a, expected: syncPush},
+		{name: "only remote", remote: a, expected: syncPull},
+		{name: "same on both sides", local: a, remote: a, base: b.version(), expected: syncNone},
+		{name: "changed locally", local: b, remote: a, base: a.version(), expected: syncPush},
+		{name: "changed remotely", local: a, remote: b, base: a.version(), expected: syncPull},
+		{name: "changed on both sides", local: b, remote: c, base: a.version(), expected: syncConflict},
+		{name: "never synced and different", local: a, remote: b, expected: syncConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideSync(tt.local, tt.remote, tt.base); got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestReadDirectoryState(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "2023 06 June 15")
+	writeContentFile(t, dir, "b.jpg", "beach")
+	writeContentFile(t, filepath.Join(dir, "videos"), "a.mov", "video")
+	modTime := time.Unix(1700000000, 600_000_000)
+	if err := os.Chtimes(filepath.Join(dir, "b.jpg"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := readDirectoryState(dir, nil)
+	if err != nil {
+		t.Fatalf("readDirectoryState failed: %v", err)
+	}
+	if state.Directory != "2023 06 June 15" {
+		t.Errorf("Unexpected directory: %q", state.Directory)
+	}
+	if len(state.Files) != 2 || state.Files[0].Path != "b.jpg" || state.Files[1].Path != "videos/a.mov" {
+		t.Fatalf("Expected b.jpg and videos/a.mov, got %+v", state.Files)
+	}
+	if state.Files[0].ModTime != 1700000001 || state.Files[0].Size != 5 {
+		t.Errorf("Expected the modification time rounded to the second, got %+v", state.Files[0])
+	}
+
+	// The version only changes with the files
+	again, err := readDirectoryState(dir, nil)
+	if err != nil || again.version() != state.version() {
+		t.Errorf("Expected the same version when nothing changed")
+	}
+	writeContentFile(t, dir, "c.jpg", "dinner")
+	changed, err := readDirectoryState(dir, nil)
+	if err != nil || changed.version() == state.version() {
+		t.Errorf("Expected a new version after adding a file")
+	}
+}
+
+func TestSyncBase_SaveAndLoad(t *testing.T) {
+	libraryDir := t.TempDir()
+
+	base, err := loadSyncBase(libraryDir)
+	if err != nil {
+		t.Fatalf("loadSyncBase failed: %v", err)
+	}
+	if got := base.version("bucket", "2023 06 June 15"); got != "" {
+		t.Errorf("Expected no version before the first sync, got %q", got)
+	}
+
+	base.set("bucket", "2023 06 June 15", "abc")
+	if err := base.save(libraryDir); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	loaded, err := loadSyncBase(libraryDir)
+	if err != nil {
+		t.Fatalf("loadSyncBase failed: %v", err)
+	}
+	if got := loaded.version("bucket", "2023 06 June 15"); got != "abc" {
+		t.Errorf("Expected the saved version, got %q", got)
+	}
+	if got := loaded.version("other", "2023 06 June 15"); got != "" {
+		t.Errorf("Expected versions to be kept per bucket, got %q", got)
+	}
+}
+
+func TestBackup_SyncDirectories_PushAndPull(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	tmpDir := t.TempDir()
+	laptop := filepath.Join(tmpDir, "laptop")
+	desktop := filepath.Join(tmpDir, "desktop")
+
+	dirName := "2023 06 June 15"
+	writeContentFile(t, filepath.Join(laptop, dirName), "2023_06_June_15_00001.jpg", "beach")
+	writeContentFile(t, filepath.Join(desktop, "2023 12 December 25"), "2023_12_December_25_00001.jpg", "tree")
+
+	report, err := backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pushed != 1 || report.Pulled != 0 || report.UploadedBytes == 0 {
+		t.Errorf("Expected 1 directory pushed, got %+v", report)
+	}
+
+	// The desktop pushes its own directory and pulls the one from the laptop
+	report, err = backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pushed != 1 || report.Pulled != 1 || report.DownloadedBytes == 0 {
+		t.Errorf("Expected 1 directory pushed and 1 pulled, got %+v", report)
+	}
+	data, err := os.ReadFile(filepath.Join(desktop, dirName, "2023_06_June_15_00001.jpg"))
+	if err != nil || string(data) != "beach" {
+		t.Errorf("Expected the laptop photo on the desktop, got %q (%v)", data, err)
+	}
+
+	// The laptop only pulls what the desktop pushed
+	report, err = backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pushed != 0 || report.Pulled != 1 || report.InSync != 1 {
+		t.Errorf("Expected 1 directory pulled and 1 in sync, got %+v", report)
+	}
+
+	// Nothing changed since
+	report, err = backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pushed != 0 || report.Pulled != 0 || report.InSync != 2 {
+		t.Errorf("Expected both directories in sync, got %+v", report)
+	}
+
+	// A local change is pushed under a new key
+	writeContentFile(t, filepath.Join(desktop, dirName), "2023_06_June_15_00002.jpg", "dinner")
+	report, err = backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pushed != 1 || report.InSync != 1 {
+		t.Errorf("Expected 1 directory pushed and 1 in sync, got %+v", report)
+	}
+	// The archive of the previous version is gone, leaving an archive and a state per directory
+	if count := client.GetObjectCount(bucket); count != 4 {
+		t.Errorf("Expected the previous archive deleted, got %d objects", count)
+	}
+	report, err = backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pulled != 1 {
+		t.Errorf("Expected 1 directory pulled, got %+v", report)
+	}
+	expected := []string{"2023_06_June_15_00001.jpg", "2023_06_June_15_00002.jpg"}
+	if got := listDir(t, filepath.Join(laptop, dirName)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestBackup_SyncDirectories_Conflict(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	tmpDir := t.TempDir()
+	laptop := filepath.Join(tmpDir, "laptop")
+	desktop := filepath.Join(tmpDir, "desktop")
+
+	dirName := "2023 06 June 15"
+	writeContentFile(t, filepath.Join(laptop, dirName), "2023_06_June_15_00001.jpg", "beach")
+	if _, err := backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	// Both machines add a photo to the same directory
+	writeContentFile(t, filepath.Join(laptop, dirName), "2023_06_June_15_00002.jpg", "sunset at the beach")
+	writeContentFile(t, filepath.Join(desktop, dirName), "2023_06_June_15_00002.jpg", "dinner")
+	if _, err := backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	report, err := backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if !reflect.DeepEqual(report.Conflicts, []string{dirName}) || report.Pushed != 0 || report.Pulled != 0 {
+		t.Errorf("Expected %q reported as a conflict, got %+v", dirName, report)
+	}
+	expected := []string{"2023_06_June_15_00001.jpg", "2023_06_June_15_00002.jpg"}
+	if got := listDir(t, filepath.Join(desktop, dirName)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the conflicting directory left alone, got %v", got)
+	}
+
+	// Merging keeps the photos of both machines
+	report, err = backup.SyncDirectories(testCtx, bucket, desktop, SyncOptions{MaxConcurrent: 1, MergeConflicts: true})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Merged != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("Expected 1 directory merged, got %+v", report)
+	}
+	expected = append(expected, "2023_06_June_15_00003.jpg")
+	if got := listDir(t, filepath.Join(desktop, dirName)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// The laptop gets the merged directory
+	report, err = backup.SyncDirectories(testCtx, bucket, laptop, SyncOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if report.Pulled != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("Expected the merged directory pulled, got %+v", report)
+	}
+	if got := listDir(t, filepath.Join(laptop, dirName)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestBackup_RestoreDirectories_SkipsSyncState(t *testing.T) {
+	client := NewInMemoryS3Client()
+	backup := &s3Backup{client: client, extensions: NewExtensions()}
+	bucket := "test-bucket"
+	client.CreateBucket(bucket)
+	tmpDir := t.TempDir()
+	libraryDir := filepath.Join(tmpDir, "library")
+	targetDir := filepath.Join(tmpDir, "restored")
+
+	writeContentFile(t, filepath.Join(libraryDir, "2023 06 June 15"), "2023_06_June_15_00001.jpg", "beach")
+	if _, err := backup.SyncDirectories(testCtx, bucket, libraryDir, SyncOptions{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	report, err := backup.RestoreDirectories(testCtx, bucket, targetDir, RestoreOptions{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("RestoreDirectories failed: %v", err)
+	}
+	if report.Restored != 1 {
+		t.Errorf("Expected only the archive restored, got %+v", report)
+	}
+}
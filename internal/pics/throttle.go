@@ -0,0 +1,150 @@
+package pics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter is a token bucket shared by the transfers of a run, letting them read at most
+// rate bytes per second between them, in bursts of up to a second's worth
+type bandwidthLimiter struct {
+	rate int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// sleep waits for d, or fails once ctx is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newBandwidthLimiter creates a bandwidthLimiter of rate bytes per second, starting with a full
+// bucket
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: rate, tokens: float64(rate), last: time.Now(), sleep: sleepContext}
+}
+
+// sleepContext waits for d, or fails once ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// wait takes n tokens, waiting until the bucket has refilled enough if it runs short. Tokens are
+// taken before waiting, so concurrent transfers queue up rather than racing for the refill.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, time.Duration(deficit/float64(l.rate)*float64(time.Second)))
+}
+
+// chunk returns how much of p a read may fill at once, a burst at most
+func (l *bandwidthLimiter) chunk(p []byte) []byte {
+	if int64(len(p)) > l.rate {
+		return p[:l.rate]
+	}
+	return p
+}
+
+// throttledReader reads through a bandwidthLimiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(r.limiter.chunk(p))
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledReadSeeker is a throttledReader that can seek, as the SDK needs to retry uploads
+type throttledReadSeeker struct {
+	throttledReader
+	seeker io.Seeker
+}
+
+func (r *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// throttledReadCloser is a throttledReader closing the body it reads
+type throttledReadCloser struct {
+	throttledReader
+	closer io.Closer
+}
+
+func (r *throttledReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// throttle returns body read through the limiter, seekable if body is
+func (l *bandwidthLimiter) throttle(ctx context.Context, body io.Reader) io.Reader {
+	if body == nil {
+		return nil
+	}
+	reader := throttledReader{ctx: ctx, r: body, limiter: l}
+	if seeker, ok := body.(io.Seeker); ok {
+		return &throttledReadSeeker{throttledReader: reader, seeker: seeker}
+	}
+	return &reader
+}
+
+// throttledStorage limits the bytes uploaded and downloaded through the StorageBackend it wraps,
+// together, to the rate of its limiter. Other requests transfer little and pass through.
+type throttledStorage struct {
+	StorageBackend
+	limiter *bandwidthLimiter
+}
+
+// withBandwidthLimit returns a copy of b whose uploads and downloads share at most limit bytes
+// per second (0 = b itself)
+func (b *s3Backup) withBandwidthLimit(limit int64) *s3Backup {
+	if limit <= 0 {
+		return b
+	}
+	limited := *b
+	limited.storage = &throttledStorage{StorageBackend: b.storage, limiter: newBandwidthLimiter(limit)}
+	return &limited
+}
+
+// Put throttles the body of the object as it is uploaded
+func (s *throttledStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error) {
+	return s.StorageBackend.Put(ctx, key, s.limiter.throttle(ctx, body), opts)
+}
+
+// Get throttles the body of the object as it is read
+func (s *throttledStorage) Get(ctx context.Context, key, ifMatch string) (io.ReadCloser, StoredObject, error) {
+	body, obj, err := s.StorageBackend.Get(ctx, key, ifMatch)
+	if err != nil {
+		return nil, StoredObject{}, err
+	}
+	return &throttledReadCloser{
+		throttledReader: throttledReader{ctx: ctx, r: body, limiter: s.limiter},
+		closer:          body,
+	}, obj, nil
+}
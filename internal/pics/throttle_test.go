@@ -0,0 +1,108 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeSleep makes limiter record how long it would have slept instead of sleeping
+func fakeSleep(limiter *bandwidthLimiter) *time.Duration {
+	var slept time.Duration
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		slept += d
+		// The time slept refills the bucket
+		limiter.mu.Lock()
+		limiter.last = limiter.last.Add(-d)
+		limiter.mu.Unlock()
+		return ctx.Err()
+	}
+	return &slept
+}
+
+// assertSlept checks that about expected was slept
+func assertSlept(t *testing.T, slept, expected time.Duration) {
+	t.Helper()
+	if slept < expected-50*time.Millisecond || slept > expected+50*time.Millisecond {
+		t.Errorf("Expected about %s slept, got %s", expected, slept)
+	}
+}
+
+func TestBandwidthLimiter_Wait(t *testing.T) {
+	limiter := newBandwidthLimiter(1000)
+	slept := fakeSleep(limiter)
+
+	// A full bucket lets a second's worth through at once
+	if err := limiter.wait(testCtx, 1000); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	assertSlept(t, *slept, 0)
+
+	if err := limiter.wait(testCtx, 500); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	assertSlept(t, *slept, 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	if err := limiter.wait(ctx, 1000); err == nil {
+		t.Error("Expected a cancelled wait to fail")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	limiter := newBandwidthLimiter(1000)
+	slept := fakeSleep(limiter)
+
+	reader := limiter.throttle(testCtx, bytes.NewReader(make([]byte, 3500)))
+	if _, ok := reader.(io.Seeker); !ok {
+		t.Error("Expected a seekable body to stay seekable")
+	}
+	buf := make([]byte, 4096)
+	if n, err := reader.Read(buf); err != nil || n != 1000 {
+		t.Errorf("Expected reads of a burst at most, got %d (%v)", n, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil || len(data) != 2500 {
+		t.Fatalf("Expected the rest of the data, got %d bytes (%v)", len(data), err)
+	}
+	assertSlept(t, *slept, 2500*time.Millisecond)
+
+	if _, ok := limiter.throttle(testCtx, io.MultiReader()).(io.Seeker); ok {
+		t.Error("Expected a body that can't seek not to seek")
+	}
+}
+
+func TestThrottledStorage(t *testing.T) {
+	client := NewInMemoryS3Client()
+	client.CreateBucket("bucket")
+	stored, _, err := (&s3Backup{client: client}).forBucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := stored.withBandwidthLimit(1000).storage.(*throttledStorage)
+	slept := fakeSleep(storage.limiter)
+
+	if _, err := storage.Put(testCtx, "a.tar.gz", bytes.NewReader(make([]byte, 3000)), PutOptions{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	assertSlept(t, *slept, 2*time.Second)
+
+	// Downloads share the limit with uploads
+	body, _, err := storage.Get(testCtx, "a.tar.gz", "")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || len(data) != 3000 {
+		t.Fatalf("Expected the object back, got %d bytes (%v)", len(data), err)
+	}
+	assertSlept(t, *slept, 5*time.Second)
+
+	if unlimited := stored.withBandwidthLimit(0); unlimited != stored {
+		t.Error("Expected no limit to leave the backup alone")
+	}
+}
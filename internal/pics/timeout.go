@@ -0,0 +1,115 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// timeoutStorage gives every request of the StorageBackend it wraps at most timeout, so one
+// stuck request fails on its own instead of hanging the whole run. Downloads must be read within
+// the timeout too.
+type timeoutStorage struct {
+	StorageBackend
+	timeout time.Duration
+}
+
+// withObjectTimeout returns a copy of b whose requests to the destination each time out after
+// timeout (0 = b itself)
+func (b *s3Backup) withObjectTimeout(timeout time.Duration) *s3Backup {
+	if timeout <= 0 {
+		return b
+	}
+	limited := *b
+	limited.storage = &timeoutStorage{StorageBackend: b.storage, timeout: timeout}
+	return &limited
+}
+
+// objectTimeoutError explains err when the request on key failed because it took longer than
+// the timeout
+func (s *timeoutStorage) objectTimeoutError(ctx context.Context, operation, key string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%s of %s took longer than %s: %w", operation, key, s.timeout, err)
+}
+
+func (s *timeoutStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (StoredObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	obj, err := s.StorageBackend.Put(ctx, key, body, opts)
+	return obj, s.objectTimeoutError(ctx, "upload", key, err)
+}
+
+// Get keeps the timeout running until the body of the object is closed
+func (s *timeoutStorage) Get(ctx context.Context, key, ifMatch string) (io.ReadCloser, StoredObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	body, obj, err := s.StorageBackend.Get(ctx, key, ifMatch)
+	if err != nil {
+		cancel()
+		return nil, StoredObject{}, s.objectTimeoutError(ctx, "download", key, err)
+	}
+	return &cancelOnClose{ReadCloser: body, cancel: cancel}, obj, nil
+}
+
+func (s *timeoutStorage) Head(ctx context.Context, key string) (StoredObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	obj, err := s.StorageBackend.Head(ctx, key)
+	return obj, s.objectTimeoutError(ctx, "head", key, err)
+}
+
+func (s *timeoutStorage) List(ctx context.Context, prefix string) ([]StoredObject, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	objects, prefixes, err := s.StorageBackend.List(ctx, prefix)
+	listed := prefix
+	if listed == "" {
+		listed = "the destination"
+	}
+	return objects, prefixes, s.objectTimeoutError(ctx, "listing", listed, err)
+}
+
+func (s *timeoutStorage) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.objectTimeoutError(ctx, "delete", key, s.StorageBackend.Delete(ctx, key))
+}
+
+func (s *timeoutStorage) Checksum(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	checksum, err := s.StorageBackend.Checksum(ctx, key)
+	return checksum, s.objectTimeoutError(ctx, "checksum", key, err)
+}
+
+func (s *timeoutStorage) RequestRestore(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.objectTimeoutError(ctx, "restore request", key, s.StorageBackend.RequestRestore(ctx, key))
+}
+
+// runTimeoutError explains err when a run failed because ctx ran out of time, which leaves the
+// jobs not started yet failed
+func runTimeoutError(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if err == ctx.Err() {
+		return fmt.Errorf("the run timed out: %w", err)
+	}
+	return fmt.Errorf("%v, the run timed out: %w", err, ctx.Err())
+}
+
+// cancelOnClose cancels the context of a download once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
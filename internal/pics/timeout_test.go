@@ -0,0 +1,86 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// stuckS3Client is an S3 client whose HEAD requests never complete, like one behind a
+// network that stopped answering
+type stuckS3Client struct {
+	*InMemoryS3Client
+}
+
+func (c *stuckS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestBackup_WithObjectTimeout(t *testing.T) {
+	backup, _, err := (&s3Backup{client: &stuckS3Client{NewInMemoryS3Client()}, extensions: NewExtensions()}).forBucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.withObjectTimeout(0) != backup {
+		t.Error("Expected no timeout to leave the backup as it is")
+	}
+
+	limited := backup.withObjectTimeout(10 * time.Millisecond)
+	_, err = limited.storage.Head(testCtx, "key")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "head of key took longer than 10ms") {
+		t.Errorf("Expected the request to time out, got %v", err)
+	}
+}
+
+func TestBackup_WithObjectTimeout_Download(t *testing.T) {
+	client := NewInMemoryS3Client()
+	client.CreateBucket("bucket")
+	if _, err := client.PutObject(testCtx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: strings.NewReader("archive")}); err != nil {
+		t.Fatal(err)
+	}
+	backup, _, err := (&s3Backup{client: client}).forBucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup = backup.withObjectTimeout(time.Minute)
+
+	// The timeout keeps running while the body is read
+	body, _, err := backup.storage.Get(testCtx, "key", "")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != "archive" {
+		t.Errorf("Expected the object read, got %q, %v", data, err)
+	}
+	if err := body.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestRunTimeoutError(t *testing.T) {
+	failed := fmt.Errorf("completed with 1 successes and 2 failures")
+	if err := runTimeoutError(testCtx, failed); err != failed {
+		t.Errorf("Expected errors of runs in time unchanged, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(testCtx, 0)
+	defer cancel()
+	if err := runTimeoutError(ctx, failed); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline reported, got %v", err)
+	}
+	if err := runTimeoutError(ctx, ctx.Err()); err == nil || err.Error() != "the run timed out: context deadline exceeded" {
+		t.Errorf("Expected the deadline reported once, got %v", err)
+	}
+	if err := runTimeoutError(ctx, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package pics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Time zone names are looked up in the database embedded in the binary on systems without
+	// one, such as Windows
+	_ "time/tzdata"
+)
+
+// utcOffsetPattern matches a UTC offset such as "+02:00", "-0530" or "+02"
+var utcOffsetPattern = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})?$`)
+
+// parseUTCOffset parses a UTC offset such as "+02:00", "-0530" or "+02", or "Z" for UTC, into a
+// fixed zone named after the offset, e.g. "+02:00"
+func parseUTCOffset(s string) (*time.Location, error) {
+	value := strings.TrimSpace(s)
+	if value == "Z" {
+		return time.UTC, nil
+	}
+	match := utcOffsetPattern.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	if hours > 14 || minutes > 59 {
+		return nil, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	seconds := hours*3600 + minutes*60
+	if match[1] == "-" {
+		seconds = -seconds
+	}
+	return time.FixedZone(fmt.Sprintf("%s%02d:%02d", match[1], hours, minutes), seconds), nil
+}
+
+// ParseTimeZone parses the name of a time zone, such as "Europe/Madrid" or "UTC", or a UTC
+// offset such as "+02:00"
+func ParseTimeZone(s string) (*time.Location, error) {
+	value := strings.TrimSpace(s)
+	if utcOffsetPattern.MatchString(value) {
+		return parseUTCOffset(value)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("invalid time zone %q (expected a name such as Europe/Madrid or an offset such as +02:00)", s)
+	}
+	zone, err := time.LoadLocation(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q (expected a name such as Europe/Madrid or an offset such as +02:00)", s)
+	}
+	return zone, nil
+}
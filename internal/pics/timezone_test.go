@@ -0,0 +1,44 @@
+package pics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	tests := []struct {
+		value  string
+		name   string
+		offset int
+	}{
+		{"+02:00", "+02:00", 2 * 60 * 60},
+		{"-0530", "-05:30", -(5*60 + 30) * 60},
+		{"+09", "+09:00", 9 * 60 * 60},
+		{"UTC", "UTC", 0},
+		{" Asia/Tokyo ", "Asia/Tokyo", 9 * 60 * 60},
+	}
+	for _, tt := range tests {
+		zone, err := ParseTimeZone(tt.value)
+		if err != nil {
+			t.Errorf("ParseTimeZone(%q) failed: %v", tt.value, err)
+			continue
+		}
+		_, offset := time.Date(2023, 1, 15, 12, 0, 0, 0, zone).Zone()
+		if zone.String() != tt.name || offset != tt.offset {
+			t.Errorf("ParseTimeZone(%q) = %s with offset %d, expected %s with %d", tt.value, zone, offset, tt.name, tt.offset)
+		}
+	}
+
+	for _, value := range []string{"", "Mars/Olympus", "+25:00", "+02:75", "2"} {
+		if _, err := ParseTimeZone(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseUTCOffset_Z(t *testing.T) {
+	zone, err := parseUTCOffset("Z")
+	if err != nil || zone != time.UTC {
+		t.Errorf("Expected UTC for Z, got %v and %v", zone, err)
+	}
+}
@@ -0,0 +1,171 @@
+package pics
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// TrashDirName is the directory of a source where pics moves the files it removes, under a
+// subdirectory per run, so they can be restored until the trash is emptied
+const TrashDirName = ".pics-trash"
+
+// DefaultTrashRetention is how long the runs of a trash are kept before a later run empties them
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashRunLayout names the directory of a run in a trash after the time the run started
+const trashRunLayout = "2006-01-02 15-04-05"
+
+// TrashRun is the directory of a trash holding the files a run removed, with their paths
+// within the directory of the trash
+type TrashRun struct {
+	// Name is the name of the run's directory, the time the run started
+	Name string
+	// Dir is the run's directory
+	Dir string
+	// Started is when the run started
+	Started time.Time
+	// Files is the number of files in the run
+	Files int
+	// Bytes is the size of the files
+	Bytes int64
+}
+
+// trashDir returns the trash of dir
+func trashDir(dir string) string {
+	return filepath.Join(dir, TrashDirName)
+}
+
+// moveToTrash moves the file at rel within dir to the directory of run in the trash of dir,
+// keeping its path, and returns where it went
+func moveToTrash(dir, rel string, run time.Time) (string, error) {
+	dest := filepath.Join(trashDir(dir), run.Format(trashRunLayout), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filepath.Join(dir, rel), dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// ListTrash returns the runs in the trash of dir, oldest first, as their names sort in the order
+// they started. Directories of the trash not named after the start of a run aren't pics' and are
+// left out.
+func ListTrash(dir string) ([]TrashRun, error) {
+	entries, err := os.ReadDir(trashDir(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the trash of %s: %w", dir, err)
+	}
+	var runs []TrashRun
+	for _, entry := range entries {
+		started, err := time.ParseInLocation(trashRunLayout, entry.Name(), time.Local)
+		if !entry.IsDir() || err != nil {
+			continue
+		}
+		run := TrashRun{Name: entry.Name(), Dir: filepath.Join(trashDir(dir), entry.Name()), Started: started}
+		err = filepath.WalkDir(run.Dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			run.Files++
+			run.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the trash of %s: %w", dir, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// EmptyTrash deletes the runs in the trash of dir that started before cutoff, or all of them
+// with a zero cutoff, and returns those deleted. The trash is removed once nothing is left in it.
+func EmptyTrash(dir string, cutoff time.Time) ([]TrashRun, error) {
+	runs, err := ListTrash(dir)
+	if err != nil {
+		return nil, err
+	}
+	var emptied []TrashRun
+	for _, run := range runs {
+		if !cutoff.IsZero() && !run.Started.Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(run.Dir); err != nil {
+			return emptied, fmt.Errorf("failed to empty %s: %w", run.Dir, err)
+		}
+		emptied = append(emptied, run)
+	}
+	// Fails while the trash holds anything else
+	os.Remove(trashDir(dir))
+	return emptied, nil
+}
+
+// RestoreTrash moves the files of the run named name in the trash of dir back to where they
+// were in dir, and returns them. A file whose place has been taken since stays in the trash
+// and is returned as kept. The run is removed from the trash once all of it is restored.
+func RestoreTrash(dir, name string) ([]string, []string, error) {
+	runs, err := ListTrash(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := slices.IndexFunc(runs, func(run TrashRun) bool { return run.Name == name })
+	if i < 0 {
+		return nil, nil, fmt.Errorf("no run %q in the trash of %s", name, dir)
+	}
+	run := runs[i]
+
+	var restored, kept []string
+	var dirs []string
+	err = filepath.WalkDir(run.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(run.Dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		original := filepath.Join(dir, rel)
+		if _, err := os.Lstat(original); err == nil {
+			logger.Warn("Keeping file in the trash, its place has been taken", "file", original, "trash", path)
+			kept = append(kept, original)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(original), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, original); err != nil {
+			return err
+		}
+		restored = append(restored, original)
+		return nil
+	})
+	if err != nil {
+		return restored, kept, fmt.Errorf("failed to restore %s: %w", run.Dir, err)
+	}
+
+	// Remove the directories emptied by the restore, deepest first, then the trash if it is empty
+	for _, d := range slices.Backward(dirs) {
+		os.Remove(d)
+	}
+	os.Remove(trashDir(dir))
+	return restored, kept, nil
+}
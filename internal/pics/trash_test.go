@@ -0,0 +1,95 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListTrash(t *testing.T) {
+	dir := t.TempDir()
+	if runs, err := ListTrash(dir); err != nil || runs != nil {
+		t.Fatalf("Expected no runs without a trash, got %v (%v)", runs, err)
+	}
+
+	trash := filepath.Join(dir, TrashDirName)
+	writeContentFile(t, filepath.Join(trash, "2024-01-02 03-04-05", "DCIM"), "IMG_0001.jpg", "first")
+	writeContentFile(t, filepath.Join(trash, "2024-01-02 03-04-05"), "IMG_0002.jpg", "second")
+	writeContentFile(t, filepath.Join(trash, "2023-12-01 10-00-00"), "IMG_0003.jpg", "third")
+	// Not a run of pics
+	writeContentFile(t, filepath.Join(trash, "kept by hand"), "notes.txt", "mine")
+
+	runs, err := ListTrash(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TrashRun{
+		{Name: "2023-12-01 10-00-00", Dir: filepath.Join(trash, "2023-12-01 10-00-00"), Started: time.Date(2023, 12, 1, 10, 0, 0, 0, time.Local), Files: 1, Bytes: 5},
+		{Name: "2024-01-02 03-04-05", Dir: filepath.Join(trash, "2024-01-02 03-04-05"), Started: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local), Files: 2, Bytes: 11},
+	}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, runs)
+	}
+}
+
+func TestEmptyTrash(t *testing.T) {
+	dir := t.TempDir()
+	trash := filepath.Join(dir, TrashDirName)
+	writeContentFile(t, filepath.Join(trash, "2023-12-01 10-00-00"), "IMG_0001.jpg", "old")
+	writeContentFile(t, filepath.Join(trash, "2024-01-02 03-04-05"), "IMG_0002.jpg", "new")
+
+	emptied, err := EmptyTrash(dir, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emptied) != 1 || emptied[0].Name != "2023-12-01 10-00-00" {
+		t.Errorf("Expected the run before the cutoff emptied, got %+v", emptied)
+	}
+	if got := listDir(t, trash); !reflect.DeepEqual(got, []string{"2024-01-02 03-04-05"}) {
+		t.Errorf("Expected the later run kept, got %v", got)
+	}
+
+	// A zero cutoff empties every run, and the trash with them
+	if emptied, err := EmptyTrash(dir, time.Time{}); err != nil || len(emptied) != 1 {
+		t.Fatalf("Expected the last run emptied, got %+v (%v)", emptied, err)
+	}
+	if _, err := os.Stat(trash); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty trash removed, got %v", err)
+	}
+}
+
+func TestRestoreTrash(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	for _, rel := range []string{filepath.Join("DCIM", "IMG_0001.jpg"), "IMG_0002.jpg"} {
+		writeContentFile(t, filepath.Join(dir, filepath.Dir(rel)), filepath.Base(rel), "original")
+		if _, err := moveToTrash(dir, rel, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A file has taken the place of one in the trash since
+	taken := writeContentFile(t, dir, "IMG_0002.jpg", "newer")
+
+	restored, kept, err := RestoreTrash(dir, "2024-01-02 03-04-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "DCIM", "IMG_0001.jpg")}; !reflect.DeepEqual(restored, want) {
+		t.Errorf("Expected %v restored, got %v", want, restored)
+	}
+	if !reflect.DeepEqual(kept, []string{taken}) {
+		t.Errorf("Expected %s kept in the trash, got %v", taken, kept)
+	}
+	if data, _ := os.ReadFile(taken); string(data) != "newer" {
+		t.Errorf("Expected the newer file left alone, got %q", data)
+	}
+	if got := listDir(t, filepath.Join(dir, TrashDirName, "2024-01-02 03-04-05")); !reflect.DeepEqual(got, []string{"IMG_0002.jpg"}) {
+		t.Errorf("Expected only the kept file left in the run, got %v", got)
+	}
+
+	if _, _, err := RestoreTrash(dir, "2020-01-01 00-00-00"); err == nil {
+		t.Error("Expected an error for a run not in the trash")
+	}
+}
@@ -1,14 +1,117 @@
 package pics
 
+import "time"
+
 // ParseOptions holds configuration options for parsing.
 type ParseOptions struct {
-	// CompressJPEGs enables JPEG compression.
+	// CompressJPEGs enables JPEG compression, and the conversions of OutputFormats. Without it
+	// every image is copied as it is.
 	CompressJPEGs bool
 	// JPEGQuality is the quality level for JPEG compression (0-100).
 	JPEGQuality int
+	// JPEGTargetSize is the size budget per JPEG in bytes (0 = compress to JPEGQuality instead).
+	// The highest quality that fits the budget is picked per image.
+	JPEGTargetSize int64
+	// ProgressiveJPEGs makes compressed JPEGs progressive.
+	ProgressiveJPEGs bool
+	// MinSizeForCompression is the size in bytes below which JPEGs are copied without compression (0 = compress all).
+	MinSizeForCompression int64
+	// MaxDimension is the number of pixels JPEGs larger on their longest side are downscaled to
+	// before compression, keeping their aspect ratio and metadata (0 = keep their size).
+	MaxDimension int
+	// MinBitsPerPixel is the bits per pixel at or below which JPEGs are copied without
+	// compression, as they are compact already and would lose quality for little gain (0 = compress all).
+	MinBitsPerPixel float64
+	// OutputFormats maps the extensions of source images, such as ".png", to the format they are
+	// written in, when CompressJPEGs is set. JPEGs not in it are compressed as JPEGs; other images
+	// not in it are copied as they are. Converted images get the extension of their format, and
+	// those that would grow are kept in their own.
+	OutputFormats map[string]ImageFormat
+	// CompressVideos re-encodes MOV and MP4 videos to H.264 with ffmpeg. Videos that don't
+	// shrink are kept as they are.
+	CompressVideos bool
+	// VideoCRF is the H.264 constant rate factor videos are encoded at (0-51, lower is better quality).
+	VideoCRF int
+	// VideoBitrate is the video bitrate in bits per second (0 = encode to VideoCRF instead).
+	VideoBitrate int64
+	// MaxVideoConcurrency is the maximum number of videos compressed concurrently, apart from
+	// MaxConcurrency, since ffmpeg already keeps every core busy with one (0 = unlimited).
+	MaxVideoConcurrency int
+	// MinFileSize is the size in bytes below which files are skipped instead of imported, to keep
+	// out thumbnail caches and junk files (0 = import all).
+	MinFileSize int64
+	// VerifyMetadataRate is the fraction of compressed JPEGs whose key EXIF fields (capture
+	// date, orientation and GPS position) are compared before and after compression
+	// (0 = none, 1 = all). A file that loses any of them fails the parse.
+	VerifyMetadataRate float64
+	// VerifyCopy hashes every file with SHA-256 as it is copied and compares the hash with the
+	// copy read back from the destination. A file whose copy doesn't match fails the parse.
+	VerifyCopy bool
+	// MoveFiles moves each source file to the TrashDirName directory of its source once the
+	// parse has organised its copy into the library, so a card dump isn't imported twice and can
+	// be deleted at once. Copies are verified as with VerifyCopy, and a source that changed since
+	// it was copied is kept.
+	MoveFiles bool
+	// TrashRetention is how long the runs in the trashes MoveFiles moves files to are kept; older
+	// runs are emptied once the files are moved (0 = never)
+	TrashRetention time.Duration
+	// PruneSourceDirs removes, after MoveFiles, the subdirectories of the sources left with
+	// nothing in them. Hidden directories, directories excluded by .picsignore and directories
+	// holding hidden files are kept and reported in ParseReport.KeptDirs.
+	PruneSourceDirs bool
+	// Resume picks up the newest parse into the same target that was interrupted while copying
+	// files, such as by a power loss, keeping the files it already copied and compressed instead
+	// of processing them again. Without such a parse, the run starts afresh.
+	Resume bool
+	// StallTimeout is how long a file may make no progress before its helper processes (exiftool,
+	// jpegoptim, ffmpeg) are killed and the import moves on (0 = wait indefinitely). ffmpeg
+	// reports its progress, so a long video is only given up on once its encode stops advancing.
+	StallTimeout time.Duration
+	// Timeout is how long the parse may run before it is aborted (0 = no limit). Once files are
+	// being moved into the library the parse runs to completion, so it is never left half organised.
+	Timeout time.Duration
+	// OnOrganising is called once every file is imported, before the files are moved into the
+	// library (nil = not called), so a caller can stop bounding the run by Timeout from then on.
+	OnOrganising func()
+	// OriginalNamePolicy decides what happens to an OriginalFileName other tools already wrote
+	// to an imported image ("" = keep it).
+	OriginalNamePolicy OriginalNamePolicy
+	// AlbumKeywords adds the names of the source subdirectories an image was found in, such as
+	// "Wedding", to its XMP keywords, so the grouping survives the move into date directories.
+	AlbumKeywords bool
+	// SequenceOrder decides the order files of the same date are numbered in ("" = by filename).
+	SequenceOrder SequenceOrder
+	// WriteProvenance writes how each imported image was processed to its XMP metadata: the
+	// path it was imported from, when, Version, and the JPEG quality it was compressed to.
+	WriteProvenance bool
+	// Version is the version of pics written by WriteProvenance.
+	Version string
+	// AssignedDate, if set, is written as the capture date of every imported image and decides the
+	// directory of every file, for scans and other files without a usable date of their own.
+	AssignedDate *ApproximateDate
+	// TimeZone, if set, is the time zone files are dated in to pick their directories, such as
+	// the zone of a trip. Capture times recorded with an offset, in UTC or as modification times
+	// are converted to it, and those recorded without one are taken to be in it. Unset, capture
+	// times keep the offset they were recorded in, and those in UTC or modification times are
+	// converted to the system time zone.
+	TimeZone *time.Location
+	// AppendLocation appends the place the files of each new date directory were taken at,
+	// according to their GPS position and Geocoder, to its name, e.g. "2023 06 June 15 Barcelona".
+	// Directories without GPS positions or known places keep the date alone.
+	AppendLocation bool
+	// Geocoder names the places of AppendLocation.
+	Geocoder Geocoder
+	// WriteManifests writes a manifest.sha256 listing the SHA-256 checksum of every file to each
+	// date directory the parse adds files to, and updates it there when numbering renames them.
+	WriteManifests bool
+	// OrganiseChangedOnly numbers the files and moves the videos of the date directories the parse
+	// adds files to alone, instead of those of every directory of the target, so files another
+	// parse left unnumbered elsewhere wait for a later one. Watches set it for each batch.
+	OrganiseChangedOnly bool
 	// TempDirName is the name of the temporary directory to use.
 	TempDirName string
-	// MaxConcurrency is the maximum number of files to process concurrently (0 = unlimited).
+	// MaxConcurrency is the maximum number of files to process concurrently, and of batches of
+	// files to date and move concurrently when organising them (0 = unlimited).
 	MaxConcurrency int
 	// ProgressChan is an optional channel for receiving progress events.
 	ProgressChan chan<- ProgressEvent
@@ -17,11 +120,38 @@ type ParseOptions struct {
 // DefaultParseOptions returns the default parsing options.
 func DefaultParseOptions() ParseOptions {
 	return ParseOptions{
-		CompressJPEGs:  true,
-		JPEGQuality:    50,
-		TempDirName:    "tmp_image",
-		MaxConcurrency: 100,
-		ProgressChan:   nil,
+		CompressJPEGs:         true,
+		JPEGQuality:           50,
+		JPEGTargetSize:        0,
+		ProgressiveJPEGs:      false,
+		MinSizeForCompression: 0,
+		MinBitsPerPixel:       0,
+		MaxDimension:          0,
+		OutputFormats:         nil,
+		CompressVideos:        false,
+		VideoCRF:              23,
+		VideoBitrate:          0,
+		MaxVideoConcurrency:   1,
+		MinFileSize:           10 * 1024,
+		VerifyMetadataRate:    0,
+		VerifyCopy:            false,
+		MoveFiles:             false,
+		TrashRetention:        DefaultTrashRetention,
+		PruneSourceDirs:       false,
+		Resume:                false,
+		StallTimeout:          5 * time.Minute,
+		Timeout:               0,
+		OriginalNamePolicy:    OriginalNameKeep,
+		SequenceOrder:         SequenceByDate,
+		WriteProvenance:       false,
+		TimeZone:              nil,
+		AppendLocation:        false,
+		Geocoder:              nil,
+		WriteManifests:        false,
+		OrganiseChangedOnly:   false,
+		TempDirName:           "tmp_image",
+		MaxConcurrency:        100,
+		ProgressChan:          nil,
 	}
 }
 
@@ -37,6 +167,14 @@ type ProgressEvent struct {
 	Message string
 	// File is the path of the file currently being processed.
 	File string
+	// Phase is the step of the current item Bytes counts during a backup or restore
+	// (PhaseArchiving, PhaseUploading, PhaseDownloading or PhaseExtracting), empty when the event
+	// only counts items.
+	Phase string
+	// Bytes is the number of bytes of the current item processed so far in Phase.
+	Bytes int64
+	// TotalBytes is the number of bytes of the current item to process in Phase (0 if unknown).
+	TotalBytes int64
 }
 
 // RestoreFilter defines the date range filter for restoring backups.
@@ -49,4 +187,223 @@ type RestoreFilter struct {
 	ToYear int
 	// ToMonth is the upper bound month (0 means December if ToYear is set).
 	ToMonth int
+	// IncludeUndated also restores directories whose name doesn't start with a date, such as
+	// review, when a date range is set. Without a date range they are always restored.
+	IncludeUndated bool
+}
+
+// hasDateRange reports whether the filter sets a lower or upper bound
+func (f RestoreFilter) hasDateRange() bool {
+	return f.FromYear > 0 || f.ToYear > 0
+}
+
+// S3Config holds the connection settings of the S3 client, for targeting S3-compatible
+// storage such as MinIO, Backblaze B2 or Wasabi instead of AWS.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible service ("" = AWS).
+	Endpoint string
+	// Region is the region of the bucket ("" = from the AWS config or environment).
+	Region string
+	// PathStyle addresses buckets in the URL path instead of the host name, which most
+	// self-hosted services such as MinIO require.
+	PathStyle bool
+}
+
+// DefaultS3Config returns the default S3 connection settings, targeting AWS.
+func DefaultS3Config() S3Config {
+	return S3Config{
+		Endpoint:  "",
+		Region:    "",
+		PathStyle: false,
+	}
+}
+
+// BackupOptions holds configuration options for backing up directories.
+type BackupOptions struct {
+	// Mode is how each directory is stored: as an archive, or file by file ("" = BackupModeArchive).
+	Mode BackupMode
+	// MaxConcurrent is the maximum number of directories to back up concurrently.
+	MaxConcurrent int
+	// StagingDir is the directory where archives are created before upload ("" = system temp directory).
+	StagingDir string
+	// ExcludeDirs is a list of glob patterns for directories to leave out of the backup.
+	// Patterns are matched against paths relative to the source directory using "/" as separator.
+	ExcludeDirs []string
+	// SHA256Checksums makes S3 verify a SHA-256 checksum on upload and store it with each archive.
+	// Existing archives are then compared by checksum instead of ETag.
+	SHA256Checksums bool
+	// MaxArchiveSize splits directories larger than this many bytes into several archives
+	// bound by a manifest (0 = one archive per directory).
+	MaxArchiveSize int64
+	// ObjectTimeout is how long each S3 request, such as the upload of an archive, may take (0 = no limit).
+	ObjectTimeout time.Duration
+	// ObjectTags tags each uploaded archive with its image and video counts and the year and month
+	// of its directory, so S3 lifecycle rules and inventories can select archives by them.
+	ObjectTags bool
+	// WriteManifests writes a manifest.sha256 with the checksums of its files to each directory
+	// without one before it is backed up, so the archives carry them. Existing ones are kept.
+	WriteManifests bool
+	// StreamArchives uploads each archive while it is created, in parts, instead of creating it
+	// in StagingDir first, so no staging space is needed. An archive already in the bucket is
+	// created a second time to be compared with it.
+	StreamArchives bool
+	// StorageClass is the S3 storage class archives and the files of incremental backups are
+	// uploaded with ("" = the default of the bucket). Manifests keep the default, so restores
+	// can read them at once.
+	StorageClass StorageClass
+	// BandwidthLimit is how many bytes per second the uploads may send between them (0 = no limit).
+	BandwidthLimit int64
+	// InventoryCacheDir is where bucket listings are cached between runs. The cached listing of
+	// the bucket is dropped once anything is uploaded to it ("" = no cache).
+	InventoryCacheDir string
+	// ProgressChan is an optional channel for receiving progress events.
+	ProgressChan chan<- ProgressEvent
+}
+
+// DefaultBackupOptions returns the default backup options.
+func DefaultBackupOptions() BackupOptions {
+	return BackupOptions{
+		Mode:              BackupModeArchive,
+		MaxConcurrent:     5,
+		StagingDir:        "",
+		ExcludeDirs:       nil,
+		SHA256Checksums:   false,
+		MaxArchiveSize:    0,
+		ObjectTimeout:     0,
+		ObjectTags:        false,
+		WriteManifests:    false,
+		StreamArchives:    false,
+		StorageClass:      "",
+		BandwidthLimit:    0,
+		InventoryCacheDir: "",
+		ProgressChan:      nil,
+	}
+}
+
+// RestoreOptions holds configuration options for restoring directories.
+type RestoreOptions struct {
+	// Filter restricts which backups are restored by date.
+	Filter RestoreFilter
+	// MaxConcurrent is the maximum number of archives to restore concurrently.
+	MaxConcurrent int
+	// StagingDir is the directory where archives are downloaded before extraction ("" = system temp directory).
+	StagingDir string
+	// VerifySHA256 checks each downloaded archive against the SHA-256 checksum stored in S3.
+	VerifySHA256 bool
+	// Owner changes the owner of every restored file and directory (nil = owned by the user running the restore).
+	Owner *FileOwner
+	// OnConflict decides what happens to date directories that already exist in the target
+	// ("" = RestorePolicyFail).
+	OnConflict RestorePolicy
+	// InventoryCacheDir is where bucket listings are cached between runs ("" = list the bucket every time).
+	InventoryCacheDir string
+	// InventoryMaxAge is how long a cached bucket listing is used before the bucket is listed again.
+	InventoryMaxAge time.Duration
+	// RefreshInventory lists the bucket even when a recent cached listing exists.
+	RefreshInventory bool
+	// ObjectTimeout is how long each S3 request, such as the download of an archive, may take (0 = no limit).
+	ObjectTimeout time.Duration
+	// WaitForRestore waits for the archives in an archived storage class, such as GLACIER, to be
+	// restored in the bucket and then downloads them (false = request their restore and skip their
+	// directories, to be restored by a later run).
+	WaitForRestore bool
+	// RestorePollInterval is how often the archives being restored are checked with WaitForRestore.
+	RestorePollInterval time.Duration
+	// BandwidthLimit is how many bytes per second the downloads may receive between them (0 = no limit).
+	BandwidthLimit int64
+	// MaxExtractSize is the most bytes the files of an archive may take once extracted, so a
+	// corrupted or malicious archive can't fill the disk (0 = no limit).
+	MaxExtractSize int64
+	// ProgressChan is an optional channel for receiving progress events.
+	ProgressChan chan<- ProgressEvent
+}
+
+// FileOwner identifies the numeric user and group that restored files are assigned to.
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// DefaultRestoreOptions returns the default restore options.
+func DefaultRestoreOptions() RestoreOptions {
+	return RestoreOptions{
+		Filter:              RestoreFilter{},
+		MaxConcurrent:       5,
+		StagingDir:          "",
+		VerifySHA256:        false,
+		Owner:               nil,
+		OnConflict:          RestorePolicyFail,
+		InventoryCacheDir:   "",
+		InventoryMaxAge:     24 * time.Hour,
+		RefreshInventory:    false,
+		ObjectTimeout:       0,
+		WaitForRestore:      false,
+		RestorePollInterval: 5 * time.Minute,
+		BandwidthLimit:      0,
+		MaxExtractSize:      0,
+		ProgressChan:        nil,
+	}
+}
+
+// SyncOptions holds configuration options for syncing a library with a bucket.
+type SyncOptions struct {
+	// MaxConcurrent is the maximum number of directories to sync concurrently.
+	MaxConcurrent int
+	// StagingDir is the directory where archives are created and downloaded ("" = system temp directory).
+	StagingDir string
+	// MaxArchiveSize splits directories larger than this many bytes into several archives
+	// bound by a manifest (0 = one archive per directory).
+	MaxArchiveSize int64
+	// MergeConflicts combines the files of directories changed both locally and in the bucket
+	// and uploads the result (false = leave them alone and report them).
+	MergeConflicts bool
+	// ObjectTags tags each uploaded archive like BackupOptions.ObjectTags.
+	ObjectTags bool
+	// InventoryCacheDir is where bucket listings are cached, as BackupOptions.InventoryCacheDir.
+	InventoryCacheDir string
+	// ProgressChan is an optional channel for receiving progress events.
+	ProgressChan chan<- ProgressEvent
+}
+
+// DefaultSyncOptions returns the default sync options.
+func DefaultSyncOptions() SyncOptions {
+	return SyncOptions{
+		MaxConcurrent:     5,
+		StagingDir:        "",
+		MaxArchiveSize:    0,
+		MergeConflicts:    false,
+		ObjectTags:        false,
+		InventoryCacheDir: "",
+		ProgressChan:      nil,
+	}
+}
+
+// ScrubOptions holds configuration options for checking a library for corrupted files.
+type ScrubOptions struct {
+	// Interval skips files verified less than this long ago, so frequent scheduled runs spread
+	// the reading of a large library (0 = verify every file).
+	Interval time.Duration
+	// MaxConcurrent is the maximum number of files to verify concurrently.
+	MaxConcurrent int
+	// Bucket is the bucket of the backups to replace corrupted files with good copies from
+	// ("" = don't repair).
+	Bucket string
+	// StagingDir is the directory where archives are downloaded to recover files ("" = system temp directory).
+	StagingDir string
+	// Quarantine moves corrupted files that weren't repaired to QuarantineDirName (false = leave them in place).
+	Quarantine bool
+	// ProgressChan is an optional channel for receiving progress events.
+	ProgressChan chan<- ProgressEvent
+}
+
+// DefaultScrubOptions returns the default scrub options.
+func DefaultScrubOptions() ScrubOptions {
+	return ScrubOptions{
+		Interval:      0,
+		MaxConcurrent: 4,
+		Bucket:        "",
+		StagingDir:    "",
+		Quarantine:    false,
+		ProgressChan:  nil,
+	}
 }
@@ -0,0 +1,287 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/acm19/pics/internal/naming"
+)
+
+// videosDirName is the directory of a date directory its videos are moved to
+const videosDirName = "videos"
+
+// ProblemKind is the kind of problem verify finds in a library
+type ProblemKind string
+
+const (
+	// ProblemDirectoryName is a directory whose name isn't "YYYY MM Month DD [name]"
+	ProblemDirectoryName ProblemKind = "directory_name"
+	// ProblemFileName is a media file not named after its directory and a sequence number
+	ProblemFileName ProblemKind = "file_name"
+	// ProblemSequenceGap is a run of sequence numbers missing from a directory
+	ProblemSequenceGap ProblemKind = "sequence_gap"
+	// ProblemDuplicateNumber is a sequence number used by more than one file of a directory
+	ProblemDuplicateNumber ProblemKind = "duplicate_number"
+	// ProblemMisplacedVideo is a video outside the videos directory of its date directory
+	ProblemMisplacedVideo ProblemKind = "misplaced_video"
+	// ProblemMisplacedImage is an image inside the videos directory of its date directory
+	ProblemMisplacedImage ProblemKind = "misplaced_image"
+	// ProblemEmptyFile is a file of zero bytes
+	ProblemEmptyFile ProblemKind = "empty_file"
+)
+
+// LibraryVerifier checks that a library is organised the way parse leaves it
+type LibraryVerifier interface {
+	// Verify checks the names of the date directories of libraryDir and of the media files inside
+	// them, that their sequence numbers have no gaps or duplicates, that videos are in the videos
+	// directory, and that no file is empty
+	Verify(libraryDir string) (VerifyReport, error)
+}
+
+// VerifyReport lists the problems found in a library
+type VerifyReport struct {
+	// Directories is the number of directories checked at the top of the library
+	Directories int `json:"directories"`
+	// Files is the number of files checked
+	Files int `json:"files"`
+	// Problems lists the problems found, sorted by path
+	Problems []LibraryProblem `json:"problems"`
+}
+
+// LibraryProblem is a problem found in a library
+type LibraryProblem struct {
+	// Kind is the kind of problem
+	Kind ProblemKind `json:"kind"`
+	// Path is the file or directory with the problem, relative to the library using "/" as separator
+	Path string `json:"path"`
+	// Detail is what was expected, such as the file name pattern, or the sequence numbers
+	// missing or duplicated ("" = nothing to add)
+	Detail string `json:"detail,omitempty"`
+}
+
+// OK reports whether no problems were found
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// libraryVerifier implements the LibraryVerifier interface
+type libraryVerifier struct {
+	extensions Extensions
+}
+
+// NewLibraryVerifier creates a new LibraryVerifier instance
+func NewLibraryVerifier() LibraryVerifier {
+	return &libraryVerifier{extensions: NewExtensions()}
+}
+
+// Verify checks the organisation of libraryDir. Dot files, ignored paths and the files of the
+// review directory, which keep their names, are only checked for being empty.
+func (v *libraryVerifier) Verify(libraryDir string) (VerifyReport, error) {
+	if info, err := os.Stat(libraryDir); err != nil || !info.IsDir() {
+		return VerifyReport{}, fmt.Errorf("not a valid directory: %s", libraryDir)
+	}
+	ignore, err := newIgnoreMatcher(libraryDir)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	entries, err := os.ReadDir(libraryDir)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("failed to read %s: %w", libraryDir, err)
+	}
+
+	report := VerifyReport{Problems: []LibraryProblem{}}
+	for _, entry := range entries {
+		path := filepath.Join(libraryDir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") || ignore.isIgnored(path, entry.IsDir()) {
+			continue
+		}
+		if !entry.IsDir() {
+			if err := v.checkEmpty(libraryDir, path, &report); err != nil {
+				return VerifyReport{}, err
+			}
+			continue
+		}
+		report.Directories++
+		if err := v.verifyDirectory(libraryDir, path, ignore, &report); err != nil {
+			return VerifyReport{}, err
+		}
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Path < report.Problems[j].Path
+	})
+	return report, nil
+}
+
+// verifyDirectory checks a directory at the top of the library and the files inside it
+func (v *libraryVerifier) verifyDirectory(libraryDir, dir string, ignore *ignoreMatcher, report *VerifyReport) error {
+	name := filepath.Base(dir)
+	var prefix string
+	if name != ReviewDirName {
+		parsed, err := naming.Parse(name)
+		if err != nil {
+			report.Problems = append(report.Problems, LibraryProblem{
+				Kind:   ProblemDirectoryName,
+				Path:   name,
+				Detail: "YYYY MM Month DD [name]",
+			})
+		} else {
+			prefix = parsed.FilePrefix()
+		}
+	}
+
+	images := newSequenceCheck(prefix)
+	videos := newSequenceCheck(prefix)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && (strings.HasPrefix(info.Name(), ".") || ignore.isIgnored(path, info.IsDir())) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := v.checkEmpty(libraryDir, path, report); err != nil {
+			return err
+		}
+		// Only files pics names are checked: media directly in the date directory or its videos
+		if prefix == "" {
+			return nil
+		}
+		relPath := libraryPath(libraryDir, path)
+		switch filepath.Dir(path) {
+		case dir:
+			if v.extensions.IsVideo(path) {
+				report.Problems = append(report.Problems, LibraryProblem{Kind: ProblemMisplacedVideo, Path: relPath, Detail: videosDirName + "/"})
+			} else if v.extensions.IsImage(path) {
+				images.add(relPath, report)
+			}
+		case filepath.Join(dir, videosDirName):
+			if v.extensions.IsImage(path) {
+				report.Problems = append(report.Problems, LibraryProblem{Kind: ProblemMisplacedImage, Path: relPath, Detail: libraryPath(libraryDir, dir) + "/"})
+			} else if v.extensions.IsVideo(path) {
+				videos.add(relPath, report)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	images.finish(libraryPath(libraryDir, dir), report)
+	videos.finish(libraryPath(libraryDir, filepath.Join(dir, videosDirName)), report)
+	return nil
+}
+
+// checkEmpty counts a file and reports it if it has no bytes
+func (v *libraryVerifier) checkEmpty(libraryDir, path string, report *VerifyReport) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	report.Files++
+	if info.Mode().IsRegular() && info.Size() == 0 {
+		report.Problems = append(report.Problems, LibraryProblem{Kind: ProblemEmptyFile, Path: libraryPath(libraryDir, path)})
+	}
+	return nil
+}
+
+// libraryPath returns path relative to libraryDir using "/" as separator
+func libraryPath(libraryDir, path string) string {
+	relPath, err := filepath.Rel(libraryDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// sequenceCheck collects the sequence numbers of the files of a directory named
+// "<prefix>_NNNNN.ext", as the renamer names them
+type sequenceCheck struct {
+	prefix  string
+	pattern *regexp.Regexp
+	files   map[int][]string
+}
+
+// newSequenceCheck creates a sequenceCheck for files starting with prefix
+func newSequenceCheck(prefix string) *sequenceCheck {
+	return &sequenceCheck{
+		prefix:  prefix,
+		pattern: regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_(\d{5,})\.[a-z0-9]+$`),
+		files:   make(map[int][]string),
+	}
+}
+
+// add records the number of the file at relPath, reporting it if its name doesn't follow the
+// pattern
+func (c *sequenceCheck) add(relPath string, report *VerifyReport) {
+	match := c.pattern.FindStringSubmatch(filepath.Base(relPath))
+	var number int
+	if match != nil {
+		number, _ = strconv.Atoi(match[1])
+	}
+	if number < 1 {
+		report.Problems = append(report.Problems, LibraryProblem{
+			Kind:   ProblemFileName,
+			Path:   relPath,
+			Detail: c.prefix + "_NNNNN.ext",
+		})
+		return
+	}
+	c.files[number] = append(c.files[number], relPath)
+}
+
+// finish reports the numbers used by more than one file and the runs of numbers missing
+// between 1 and the highest one, as problems of dir
+func (c *sequenceCheck) finish(dir string, report *VerifyReport) {
+	highest := 0
+	for number := range c.files {
+		highest = max(highest, number)
+	}
+	missingFrom := 0
+	for number := 1; number <= highest+1; number++ {
+		files, ok := c.files[number]
+		if !ok && number <= highest {
+			if missingFrom == 0 {
+				missingFrom = number
+			}
+			continue
+		}
+		if missingFrom != 0 {
+			report.Problems = append(report.Problems, LibraryProblem{
+				Kind:   ProblemSequenceGap,
+				Path:   dir,
+				Detail: sequenceRange(missingFrom, number-1),
+			})
+			missingFrom = 0
+		}
+		if len(files) > 1 {
+			sort.Strings(files)
+			for _, file := range files {
+				report.Problems = append(report.Problems, LibraryProblem{
+					Kind:   ProblemDuplicateNumber,
+					Path:   file,
+					Detail: fmt.Sprintf("%05d", number),
+				})
+			}
+		}
+	}
+}
+
+// sequenceRange formats the sequence numbers from first to last, e.g. "00003-00005" or "00003"
+func sequenceRange(first, last int) string {
+	if first == last {
+		return fmt.Sprintf("%05d", first)
+	}
+	return fmt.Sprintf("%05d-%05d", first, last)
+}
@@ -0,0 +1,69 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibraryVerifier_Organised(t *testing.T) {
+	libraryDir := t.TempDir()
+	june := filepath.Join(libraryDir, "2023 06 June 15 Beach")
+	writeDiffFile(t, june, "2023_06_June_15_Beach_00001.jpg", "a")
+	writeDiffFile(t, june, "2023_06_June_15_Beach_00002.heic", "b")
+	writeDiffFile(t, filepath.Join(june, "videos"), "2023_06_June_15_Beach_00001.mov", "c")
+	// Files pics doesn't name are left alone
+	writeDiffFile(t, june, "notes.txt", "d")
+	writeDiffFile(t, filepath.Join(libraryDir, ReviewDirName), "IMG_1234.jpg", "e")
+	writeDiffFile(t, filepath.Join(libraryDir, ".pics-quarantine"), "empty.jpg", "")
+
+	report, err := NewLibraryVerifier().Verify(libraryDir)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Expected no problems, got %+v", report.Problems)
+	}
+	if report.Directories != 2 || report.Files != 5 {
+		t.Errorf("Expected 2 directories and 5 files checked, got %d and %d", report.Directories, report.Files)
+	}
+}
+
+func TestLibraryVerifier_Problems(t *testing.T) {
+	libraryDir := t.TempDir()
+	june := filepath.Join(libraryDir, "2023 06 June 15")
+	writeDiffFile(t, june, "2023_06_June_15_00001.jpg", "a")
+	writeDiffFile(t, june, "2023_06_June_15_00002.jpg", "b")
+	writeDiffFile(t, june, "2023_06_June_15_00002.heic", "c")
+	writeDiffFile(t, june, "2023_06_June_15_00006.jpg", "d")
+	writeDiffFile(t, june, "IMG_0001.jpg", "e")
+	writeDiffFile(t, june, "clip.mov", "f")
+	writeDiffFile(t, filepath.Join(june, "videos"), "2023_06_June_15_00002.mov", "")
+	writeDiffFile(t, filepath.Join(june, "videos"), "photo.jpg", "g")
+	writeDiffFile(t, filepath.Join(libraryDir, "holiday"), "photo.jpg", "h")
+
+	report, err := NewLibraryVerifier().Verify(libraryDir)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	expected := []LibraryProblem{
+		{Kind: ProblemSequenceGap, Path: "2023 06 June 15", Detail: "00003-00005"},
+		{Kind: ProblemDuplicateNumber, Path: "2023 06 June 15/2023_06_June_15_00002.heic", Detail: "00002"},
+		{Kind: ProblemDuplicateNumber, Path: "2023 06 June 15/2023_06_June_15_00002.jpg", Detail: "00002"},
+		{Kind: ProblemFileName, Path: "2023 06 June 15/IMG_0001.jpg", Detail: "2023_06_June_15_NNNNN.ext"},
+		{Kind: ProblemMisplacedVideo, Path: "2023 06 June 15/clip.mov", Detail: "videos/"},
+		{Kind: ProblemSequenceGap, Path: "2023 06 June 15/videos", Detail: "00001"},
+		{Kind: ProblemEmptyFile, Path: "2023 06 June 15/videos/2023_06_June_15_00002.mov"},
+		{Kind: ProblemMisplacedImage, Path: "2023 06 June 15/videos/photo.jpg", Detail: "2023 06 June 15/"},
+		{Kind: ProblemDirectoryName, Path: "holiday", Detail: "YYYY MM Month DD [name]"},
+	}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, report.Problems)
+	}
+}
+
+func TestLibraryVerifier_InvalidDirectory(t *testing.T) {
+	if _, err := NewLibraryVerifier().Verify(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing library")
+	}
+}
@@ -0,0 +1,170 @@
+package pics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// compressibleVideoExtensions are the video formats ffmpeg re-encodes to H.264 without
+// changing the container
+var compressibleVideoExtensions = map[string]bool{
+	".mov": true,
+	".mp4": true,
+}
+
+// isCompressibleVideo reports whether the video at path can be compressed
+func isCompressibleVideo(path string) bool {
+	return compressibleVideoExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// ValidateVideoCRF checks that crf is an H.264 constant rate factor between 0 and 51
+func ValidateVideoCRF(crf int) error {
+	if crf < 0 || crf > 51 {
+		return fmt.Errorf("invalid video CRF %d (expected 0-51)", crf)
+	}
+	return nil
+}
+
+// videoSlotsKey is the context key of the slots limiting how many videos are compressed at once
+type videoSlotsKey struct{}
+
+// withVideoSlots returns a context under which at most n videos are compressed at once (0 = no limit)
+func withVideoSlots(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, videoSlotsKey{}, make(chan struct{}, n))
+}
+
+// acquireVideoSlot waits until a video can be compressed under ctx and returns the function
+// releasing its slot. The wait doesn't count against the stall timeout of the file.
+func acquireVideoSlot(ctx context.Context) (func(), error) {
+	slots, ok := ctx.Value(videoSlotsKey{}).(chan struct{})
+	if !ok {
+		return func() {}, nil
+	}
+	resume := pauseWatch(ctx)
+	defer resume()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// VideoCompressor defines the interface for compressing videos
+type VideoCompressor interface {
+	// CompressFile re-encodes a single video in place. Cancelling ctx kills the encoder.
+	CompressFile(ctx context.Context, path string, opts VideoCompressOptions) error
+}
+
+// VideoCompressOptions holds settings for compressing a single video.
+type VideoCompressOptions struct {
+	// CRF is the H.264 constant rate factor (0-51, lower is better quality). Ignored when
+	// Bitrate is set.
+	CRF int
+	// Bitrate is the video bitrate in bits per second (0 = encode to CRF instead).
+	Bitrate int64
+}
+
+// ffmpegCompressor implements the VideoCompressor interface
+type ffmpegCompressor struct {
+	ffmpegPath string
+}
+
+// NewVideoCompressor creates a new VideoCompressor instance using system ffmpeg
+func NewVideoCompressor() VideoCompressor {
+	return &ffmpegCompressor{}
+}
+
+// NewVideoCompressorWithPath creates a new VideoCompressor with a custom ffmpeg path
+func NewVideoCompressorWithPath(ffmpegPath string) VideoCompressor {
+	return &ffmpegCompressor{
+		ffmpegPath: ffmpegPath,
+	}
+}
+
+// CompressFile re-encodes a single video using ffmpeg, keeping its metadata and modification
+// time. The video is encoded to a temporary file next to it, which replaces it only if smaller.
+func (c *ffmpegCompressor) CompressFile(ctx context.Context, path string, opts VideoCompressOptions) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("file does not exist: %w", err)
+	}
+
+	// Determine ffmpeg path
+	ffmpeg := c.ffmpegPath
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg" // Use system PATH
+	}
+
+	// ffmpeg picks the container from the extension, so the temporary file keeps it
+	ext := filepath.Ext(path)
+	tmpPath := strings.TrimSuffix(path, ext) + ".compressing" + ext
+	cmd := exec.CommandContext(ctx, ffmpeg, ffmpegArgs(path, tmpPath, opts)...)
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	progress, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg failed for %s: %w", path, err)
+	}
+	// ffmpeg ends each block of its progress report with a progress= line, about twice a second
+	scanner := bufio.NewScanner(progress)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "progress=") {
+			reportProgress(ctx)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg failed for %s: %w, output: %s", path, err, output.Bytes())
+	}
+
+	compressed, err := os.Stat(tmpPath)
+	if err != nil {
+		return fmt.Errorf("ffmpeg wrote no output for %s: %w", path, err)
+	}
+	if compressed.Size() >= info.Size() {
+		// Already well compressed footage can grow when re-encoded
+		os.Remove(tmpPath)
+		logger.Debug("Keeping original video, re-encoding didn't shrink it", "file", path, "size", info.Size(), "compressed_size", compressed.Size())
+		return nil
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to preserve modification time: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// ffmpegArgs builds the ffmpeg arguments for re-encoding path to tmpPath.
+// The video is encoded to H.264 at a constant rate factor, or at a bitrate if set, while the
+// audio is copied. Container metadata, such as the creation time videos are dated by, is kept,
+// including the QuickTime keys iPhones write. ffmpeg reports its progress on stdout, which
+// keeps a long encode from being taken for a stalled one.
+func ffmpegArgs(path, tmpPath string, opts VideoCompressOptions) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-y", "-i", path,
+		"-map", "0:v:0", "-map", "0:a?", "-map_metadata", "0", "-c:v", "libx264"}
+	if opts.Bitrate > 0 {
+		args = append(args, "-b:v", fmt.Sprintf("%d", opts.Bitrate))
+	} else {
+		args = append(args, "-crf", fmt.Sprintf("%d", opts.CRF))
+	}
+	return append(args, "-c:a", "copy", "-movflags", "+faststart+use_metadata_tags", tmpPath)
+}
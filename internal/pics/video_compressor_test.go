@@ -0,0 +1,264 @@
+package pics
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// writeFakeFFmpeg writes a script standing in for ffmpeg that writes output to the last of its
+// arguments, the output file
+func writeFakeFFmpeg(t *testing.T, output string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available, skipping test")
+	}
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\nprintf '" + output + "' > \"$last\"\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake ffmpeg: %v", err)
+	}
+	return path
+}
+
+func TestIsCompressibleVideo(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"clip.mov": true,
+		"CLIP.MP4": true,
+		"clip.avi": false,
+		"clip.mts": false,
+		"img.jpg":  false,
+	} {
+		if got := isCompressibleVideo(path); got != expected {
+			t.Errorf("isCompressibleVideo(%q) = %v, expected %v", path, got, expected)
+		}
+	}
+}
+
+func TestValidateVideoCRF(t *testing.T) {
+	for _, crf := range []int{0, 23, 51} {
+		if err := ValidateVideoCRF(crf); err != nil {
+			t.Errorf("Expected CRF %d accepted, got: %v", crf, err)
+		}
+	}
+	for _, crf := range []int{-1, 52} {
+		if err := ValidateVideoCRF(crf); err == nil {
+			t.Errorf("Expected CRF %d refused", crf)
+		}
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     VideoCompressOptions
+		expected []string
+	}{
+		{
+			name:     "constant rate factor",
+			opts:     VideoCompressOptions{CRF: 23},
+			expected: []string{"-crf", "23"},
+		},
+		{
+			name:     "bitrate replaces CRF",
+			opts:     VideoCompressOptions{CRF: 23, Bitrate: 4000000},
+			expected: []string{"-b:v", "4000000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := ffmpegArgs("clip.mov", "clip.compressing.mov", tt.opts)
+			expected := append([]string{"-hide_banner", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-y", "-i", "clip.mov",
+				"-map", "0:v:0", "-map", "0:a?", "-map_metadata", "0", "-c:v", "libx264"}, tt.expected...)
+			expected = append(expected, "-c:a", "copy", "-movflags", "+faststart+use_metadata_tags", "clip.compressing.mov")
+			if !reflect.DeepEqual(args, expected) {
+				t.Errorf("Expected %v, got %v", expected, args)
+			}
+		})
+	}
+}
+
+func TestFFmpegCompressor_CompressFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{name: "smaller video replaces the original", output: "small", expected: "small"},
+		{name: "larger video is discarded", output: "much larger than the original", expected: "original video"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+			path := filepath.Join(t.TempDir(), "clip.mov")
+			if err := os.WriteFile(path, []byte("original video"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			compressor := NewVideoCompressorWithPath(writeFakeFFmpeg(t, tt.output))
+			if err := compressor.CompressFile(context.Background(), path, VideoCompressOptions{CRF: 23}); err != nil {
+				t.Fatalf("CompressFile failed: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil || string(data) != tt.expected {
+				t.Errorf("Expected %q, got %q (%v)", tt.expected, data, err)
+			}
+			if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected the modification time kept, got %v", info.ModTime())
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+				t.Errorf("Expected no temporary file left, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestFFmpegCompressor_CompressFile_Failure(t *testing.T) {
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false not available, skipping test")
+	}
+	path := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(path, []byte("original video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	falsePath, _ := exec.LookPath("false")
+	if err := NewVideoCompressorWithPath(falsePath).CompressFile(context.Background(), path, VideoCompressOptions{CRF: 23}); err == nil {
+		t.Error("Expected an error from the failed encoder")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "original video" {
+		t.Errorf("Expected the original kept, got %q", data)
+	}
+}
+
+func TestFFmpegCompressor_CompressFile_NonexistentFile(t *testing.T) {
+	err := NewVideoCompressor().CompressFile(context.Background(), "/nonexistent/clip.mov", VideoCompressOptions{CRF: 23})
+	if err == nil {
+		t.Error("Expected error for nonexistent file, got nil")
+	}
+}
+
+// recordingVideoCompressor records the videos it is asked to compress
+type recordingVideoCompressor struct {
+	paths []string
+}
+
+func (c *recordingVideoCompressor) CompressFile(ctx context.Context, path string, opts VideoCompressOptions) error {
+	c.paths = append(c.paths, path)
+	return nil
+}
+
+func TestMediaParser_ProcessFile_CompressesVideos(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		enabled  bool
+		expected bool
+	}{
+		{name: "MOV compressed", file: "clip.mov", enabled: true, expected: true},
+		{name: "AVI left alone", file: "clip.avi", enabled: true},
+		{name: "disabled", file: "clip.mov"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createMediaFile(t, tmpDir, tt.file, time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+			dest := filepath.Join(tmpDir, "copy"+filepath.Ext(tt.file))
+
+			compressor := &recordingVideoCompressor{}
+			parser := &mediaParser{
+				videoCompressor: compressor,
+				extensions:      NewExtensions(),
+				exifWriter:      NewExifWriter(nil),
+			}
+
+			opts := testParseOptions
+			opts.CompressVideos = tt.enabled
+			opts.VideoCRF = 23
+			file := discoveredFile(t, src, dest)
+			err := parser.processFile(context.Background(), &file, opts, logger.With("file", src), nil)
+			if err != nil {
+				t.Fatalf("processFile failed: %v", err)
+			}
+			if compressed := len(compressor.paths) == 1 && compressor.paths[0] == dest; compressed != tt.expected {
+				t.Errorf("Expected compressed %v, got %v", tt.expected, compressor.paths)
+			}
+		})
+	}
+}
+
+func TestAcquireVideoSlot(t *testing.T) {
+	dog, now := newTestWatchdog(5 * time.Minute)
+	dog.ctx = withVideoSlots(testCtx, 1)
+
+	first := dog.begin(1, "VID_0001.mov")
+	release, err := acquireVideoSlot(first)
+	if err != nil {
+		t.Fatalf("Expected a free slot, got: %v", err)
+	}
+
+	// A second video waits for the first, without being taken for stalled meanwhile
+	second := dog.begin(2, "VID_0002.mov")
+	acquired := make(chan error)
+	go func() {
+		release, err := acquireVideoSlot(second)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Expected the second video to wait, got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	*now = now.Add(time.Hour)
+	reportProgress(first)
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected no stalled videos, got %+v", stalled)
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the second video to get the slot, got: %v", err)
+	}
+}
+
+func TestFFmpegCompressor_CompressFile_ReportsProgress(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available, skipping test")
+	}
+	tmpDir := t.TempDir()
+	path := createMediaFile(t, tmpDir, "clip.mov", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\necho frame=1\necho progress=continue\nprintf 'small' > \"$last\"\necho progress=end\n"
+	if err := os.WriteFile(ffmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake ffmpeg: %v", err)
+	}
+
+	dog, now := newTestWatchdog(5 * time.Minute)
+	ctx := dog.begin(1, path)
+	*now = now.Add(time.Hour)
+	if err := NewVideoCompressorWithPath(ffmpeg).CompressFile(ctx, path, VideoCompressOptions{CRF: 23}); err != nil {
+		t.Fatalf("CompressFile failed: %v", err)
+	}
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected the progress of ffmpeg to keep the video from stalling, got %+v", stalled)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "small" {
+		t.Errorf("Expected the video compressed, got %q (%v)", data, err)
+	}
+}
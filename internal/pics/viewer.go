@@ -0,0 +1,143 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ViewerKind identifies a photo viewer pics can hand imported files to
+type ViewerKind string
+
+const (
+	// ViewerImmich is an Immich server with the library as an external library
+	ViewerImmich ViewerKind = "immich"
+	// ViewerPhotoPrism is a PhotoPrism server with the library as its originals folder
+	ViewerPhotoPrism ViewerKind = "photoprism"
+)
+
+// ParseViewerKind parses "immich" or "photoprism"
+func ParseViewerKind(s string) (ViewerKind, error) {
+	switch kind := ViewerKind(s); kind {
+	case ViewerImmich, ViewerPhotoPrism:
+		return kind, nil
+	}
+	return "", fmt.Errorf("invalid viewer %q (expected immich or photoprism)", s)
+}
+
+// ViewerOptions holds configuration options for notifying a photo viewer of imported files.
+type ViewerOptions struct {
+	// Kind is the viewer to notify.
+	Kind ViewerKind
+	// URL is the address of the viewer, e.g. http://photos.local:2283.
+	URL string
+	// Token is the Immich API key or PhotoPrism app password.
+	Token string
+	// LibraryID is the ID of the Immich external library holding the library (Immich only).
+	LibraryID string
+	// IndexPath is the folder PhotoPrism indexes, relative to its originals ("" = all originals).
+	IndexPath string
+	// Timeout is how long to wait for the viewer to accept the request.
+	Timeout time.Duration
+}
+
+// DefaultViewerOptions returns the default viewer options.
+func DefaultViewerOptions() ViewerOptions {
+	return ViewerOptions{
+		Kind:      "",
+		URL:       "",
+		Token:     "",
+		LibraryID: "",
+		IndexPath: "",
+		Timeout:   30 * time.Second,
+	}
+}
+
+// ViewerNotifier defines the interface for telling a photo viewer about imported files
+type ViewerNotifier interface {
+	// NotifyImported asks the viewer to pick up the files just imported into the library. The
+	// viewer scans the library in the background; this only waits for it to accept the request.
+	NotifyImported(ctx context.Context) error
+}
+
+// viewerNotifier implements the ViewerNotifier interface over the viewer's HTTP API
+type viewerNotifier struct {
+	opts   ViewerOptions
+	client *http.Client
+}
+
+// NewViewerNotifier creates a new ViewerNotifier for the viewer described by opts
+func NewViewerNotifier(opts ViewerOptions) (ViewerNotifier, error) {
+	if _, err := ParseViewerKind(string(opts.Kind)); err != nil {
+		return nil, err
+	}
+	if _, err := url.ParseRequestURI(opts.URL); err != nil {
+		return nil, fmt.Errorf("invalid viewer URL %q: %w", opts.URL, err)
+	}
+	if opts.Token == "" {
+		return nil, fmt.Errorf("no API token for %s", opts.Kind)
+	}
+	if opts.Kind == ViewerImmich && opts.LibraryID == "" {
+		return nil, fmt.Errorf("no external library ID for immich")
+	}
+	return &viewerNotifier{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// NotifyImported starts a scan of the Immich external library or a PhotoPrism index
+func (n *viewerNotifier) NotifyImported(ctx context.Context) error {
+	base := strings.TrimSuffix(n.opts.URL, "/")
+
+	var endpoint string
+	var body any
+	header := http.Header{}
+	switch n.opts.Kind {
+	case ViewerImmich:
+		endpoint = base + "/api/libraries/" + url.PathEscape(n.opts.LibraryID) + "/scan"
+		body = struct{}{}
+		header.Set("x-api-key", n.opts.Token)
+	case ViewerPhotoPrism:
+		path := n.opts.IndexPath
+		if path == "" {
+			path = "/"
+		}
+		endpoint = base + "/api/v1/index"
+		body = struct {
+			Path    string `json:"path"`
+			Rescan  bool   `json:"rescan"`
+			Cleanup bool   `json:"cleanup"`
+		}{Path: path}
+		header.Set("Authorization", "Bearer "+n.opts.Token)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", n.opts.Kind, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", n.opts.Kind, err)
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to notify %s: %w", n.opts.Kind, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s refused the request: %s %s", n.opts.Kind, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
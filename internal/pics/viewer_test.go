@@ -0,0 +1,115 @@
+package pics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestViewerNotifier_Immich(t *testing.T) {
+	var path, apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiKey = r.URL.Path, r.Header.Get("x-api-key")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	opts := DefaultViewerOptions()
+	opts.Kind = ViewerImmich
+	opts.URL = server.URL + "/"
+	opts.Token = "secret"
+	opts.LibraryID = "lib-1"
+	notifier, err := NewViewerNotifier(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.NotifyImported(testCtx); err != nil {
+		t.Fatalf("NotifyImported failed: %v", err)
+	}
+	if path != "/api/libraries/lib-1/scan" || apiKey != "secret" {
+		t.Errorf("Expected a scan of lib-1 with the API key, got %s with %q", path, apiKey)
+	}
+}
+
+func TestViewerNotifier_PhotoPrism(t *testing.T) {
+	var path, auth string
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	opts := DefaultViewerOptions()
+	opts.Kind = ViewerPhotoPrism
+	opts.URL = server.URL
+	opts.Token = "app-password"
+	notifier, err := NewViewerNotifier(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.NotifyImported(testCtx); err != nil {
+		t.Fatalf("NotifyImported failed: %v", err)
+	}
+	if path != "/api/v1/index" || auth != "Bearer app-password" {
+		t.Errorf("Expected an index request with the app password, got %s with %q", path, auth)
+	}
+	if body["path"] != "/" || body["rescan"] != false {
+		t.Errorf("Expected an index of all originals without rescan, got %v", body)
+	}
+}
+
+func TestViewerNotifier_Refused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	opts := DefaultViewerOptions()
+	opts.Kind = ViewerImmich
+	opts.URL = server.URL
+	opts.Token = "wrong"
+	opts.LibraryID = "lib-1"
+	notifier, err := NewViewerNotifier(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.NotifyImported(testCtx); err == nil {
+		t.Error("Expected an error for a refused request")
+	}
+}
+
+func TestViewerNotifier_Offline(t *testing.T) {
+	SetOffline(true)
+	defer SetOffline(false)
+
+	opts := DefaultViewerOptions()
+	opts.Kind = ViewerPhotoPrism
+	opts.URL = "http://photos.local:2342"
+	opts.Token = "app-password"
+	notifier, err := NewViewerNotifier(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.NotifyImported(testCtx); !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected ErrOffline, got %v", err)
+	}
+}
+
+func TestNewViewerNotifier_Invalid(t *testing.T) {
+	tests := map[string]ViewerOptions{
+		"unknown viewer": {Kind: "flickr", URL: "http://x", Token: "t"},
+		"invalid URL":    {Kind: ViewerPhotoPrism, URL: "photos", Token: "t"},
+		"no token":       {Kind: ViewerPhotoPrism, URL: "http://x"},
+		"no library ID":  {Kind: ViewerImmich, URL: "http://x", Token: "t"},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewViewerNotifier(opts); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
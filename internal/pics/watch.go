@@ -0,0 +1,347 @@
+package pics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// watchStateName is the file of a library recording the source files a watch imported into it
+const watchStateName = ".pics-watch.json"
+
+// WatchOptions holds configuration options for watching a source directory
+type WatchOptions struct {
+	// Parse are the options each batch of new files is parsed with
+	Parse ParseOptions
+	// PollInterval is how often the source directory is scanned for new files. On Linux it is
+	// also scanned as soon as it changes, so polling only catches the changes inotify misses,
+	// such as those made to network shares by other hosts.
+	PollInterval time.Duration
+	// Settle is how long new files must stay the same size and modification time, with no
+	// other file appearing, before they are imported, so copies in progress are left alone
+	Settle time.Duration
+	// OnBatch, if set, is called with the report of each batch once it is imported
+	OnBatch func(ParseReport)
+}
+
+// DefaultWatchOptions returns the default watch options
+func DefaultWatchOptions() WatchOptions {
+	return WatchOptions{
+		Parse:        DefaultParseOptions(),
+		PollInterval: 5 * time.Second,
+		Settle:       30 * time.Second,
+	}
+}
+
+// WatchReport holds what a watch did until it was stopped
+type WatchReport struct {
+	// Batches is the number of batches of new files parsed
+	Batches int
+	// Imported is the number of media files added to the library
+	Imported int
+	// Failed is the number of batches whose parse failed; their files are tried again once
+	// they change
+	Failed int
+	// Duration is how long the watch ran
+	Duration time.Duration
+}
+
+// Watcher imports media files into a library as they appear in a source directory
+type Watcher interface {
+	// Watch scans sourceDir whenever it changes, and every opts.PollInterval, and parses the
+	// media files that appeared into targetDir once none of them changed for opts.Settle. Only
+	// the directories of the library each batch adds files to are numbered. The files imported
+	// are recorded in targetDir, so a watch restarted later doesn't import them again, until
+	// they are gone from sourceDir. It runs until ctx is cancelled, which is not an error.
+	Watch(ctx context.Context, sourceDir, targetDir string, opts WatchOptions) (WatchReport, error)
+}
+
+// watcher implements the Watcher interface
+type watcher struct {
+	parser     MediaParser
+	extensions Extensions
+	now        func() time.Time
+}
+
+// NewWatcher creates a Watcher that imports new files with parser
+func NewWatcher(parser MediaParser) Watcher {
+	return &watcher{
+		parser:     parser,
+		extensions: NewExtensions(),
+		now:        time.Now,
+	}
+}
+
+// watchedFile identifies a version of a source file by its size and modification time
+type watchedFile struct {
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+	// ModTime is the modification time of the file in Unix nanoseconds
+	ModTime int64 `json:"modTime"`
+}
+
+// watchState records the source files a watch imported, or skipped for good, by absolute path
+type watchState struct {
+	// Imported maps the absolute path of each source file to the version of it that was imported
+	Imported map[string]watchedFile `json:"imported"`
+}
+
+// loadWatchState reads the watch state of a library, or returns an empty one if it was never watched into
+func loadWatchState(libraryDir string) (*watchState, error) {
+	state := &watchState{Imported: make(map[string]watchedFile)}
+	data, err := os.ReadFile(filepath.Join(libraryDir, watchStateName))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", watchStateName, err)
+	}
+	if state.Imported == nil {
+		state.Imported = make(map[string]watchedFile)
+	}
+	return state, nil
+}
+
+// save writes the watch state to the library, replacing the previous one at once
+func (s *watchState) save(libraryDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(libraryDir, watchStateName, data)
+}
+
+// prune forgets the files recorded under sourceDir that aren't in found, reporting whether
+// there were any
+func (s *watchState) prune(sourceDir string, found map[string]watchedFile) bool {
+	pruned := false
+	for path := range s.Imported {
+		if _, ok := found[path]; !ok && isSameOrNested(path, sourceDir) {
+			delete(s.Imported, path)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// changeNotifier tells when the directories it watches change, sooner than polling them would
+type changeNotifier interface {
+	// watch adds dir, without its subdirectories, to the directories watched
+	watch(dir string) error
+	// changes receives a value after any of the watched directories changed
+	changes() <-chan struct{}
+	// close stops watching
+	close() error
+}
+
+// Watch imports the media files that appear in sourceDir into targetDir until ctx is cancelled
+func (w *watcher) Watch(ctx context.Context, sourceDir, targetDir string, opts WatchOptions) (WatchReport, error) {
+	start := w.now()
+	if opts.PollInterval <= 0 {
+		return WatchReport{}, fmt.Errorf("poll interval must be positive")
+	}
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return WatchReport{}, err
+	}
+	if isSameOrNested(targetDir, sourceDir) {
+		return WatchReport{}, fmt.Errorf("target directory %s is inside the watched directory %s", targetDir, sourceDir)
+	}
+	state, err := loadWatchState(targetDir)
+	if err != nil {
+		return WatchReport{}, err
+	}
+	logger.Info("Watching for new media", "source", sourceDir, "target", targetDir, "interval", opts.PollInterval, "settle", opts.Settle)
+
+	// Numbering the whole library after each batch would take longer the bigger it grows
+	opts.Parse.OrganiseChangedOnly = true
+
+	var changes <-chan struct{}
+	notifier, err := newChangeNotifier()
+	if err != nil {
+		logger.Info("Changes can't be watched for, polling alone", "reason", err)
+	} else {
+		defer notifier.close()
+		changes = notifier.changes()
+	}
+
+	var report WatchReport
+	// failed holds the versions of files whose batch failed, tried again only once they change
+	failed := make(map[string]watchedFile)
+	var pending map[string]watchedFile
+	var lastChange time.Time
+
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		found, err := w.scan(sourceDir, notifier)
+		if err != nil {
+			// The source may be a card that is unmounted for a while, so keep watching
+			logger.Warn("Failed to scan watched directory", "source", sourceDir, "error", err)
+		} else {
+			// A source left empty may be the mount point of a card unmounted for a while, whose
+			// files mustn't be imported again once it is back
+			if len(found) > 0 && state.prune(sourceDir, found) {
+				if err := state.save(targetDir); err != nil {
+					logger.Warn("Failed to forget files gone from the watched directory", "error", err)
+				}
+			}
+			for path := range failed {
+				if _, ok := found[path]; !ok {
+					delete(failed, path)
+				}
+			}
+			current := newWatchedFiles(found, state, failed)
+			if !sameWatchedFiles(current, pending) {
+				pending, lastChange = current, w.now()
+			}
+			if len(pending) > 0 && w.now().Sub(lastChange) >= opts.Settle {
+				if err := w.importBatch(ctx, pending, targetDir, opts, state, failed, &report); err != nil && ctx.Err() == nil {
+					return report, err
+				}
+				pending = nil
+			}
+		}
+
+		// Pending files are imported once settled, even if nothing else happens by then
+		var settled <-chan time.Time
+		if len(pending) > 0 {
+			settled = time.After(opts.Settle - w.now().Sub(lastChange))
+		}
+		select {
+		case <-ctx.Done():
+			report.Duration = w.now().Sub(start)
+			logger.Info("Stopped watching", "source", sourceDir, "batches", report.Batches, "imported", report.Imported)
+			return report, nil
+		case <-ticker.C:
+		case <-changes:
+		case <-settled:
+		}
+	}
+}
+
+// importBatch parses the files of batch into targetDir and records them in state. Files the parse
+// skipped because they were still changing are left for a later batch, and files it moved away
+// aren't recorded as they can't come back. Only errors saving state and cancellation are returned:
+// a failed parse is logged and its files recorded in failed.
+func (w *watcher) importBatch(ctx context.Context, batch map[string]watchedFile, targetDir string, opts WatchOptions, state *watchState, failed map[string]watchedFile, report *WatchReport) error {
+	files := make([]string, 0, len(batch))
+	for path := range batch {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	logger.Info("Importing new files", "files", len(files))
+
+	parsed, err := w.parser.ParseFiles(ctx, files, targetDir, opts.Parse)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		logger.Error("Failed to import new files, trying again once they change", "files", len(files), "error", err)
+		for path, file := range batch {
+			failed[path] = file
+		}
+		report.Failed++
+		return nil
+	}
+
+	unrecorded := make(map[string]bool, len(parsed.Changing)+len(parsed.Moved))
+	for _, path := range parsed.Changing {
+		unrecorded[path] = true
+	}
+	for _, path := range parsed.Moved {
+		unrecorded[path] = true
+	}
+	for path, file := range batch {
+		delete(failed, path)
+		if !unrecorded[path] {
+			state.Imported[path] = file
+		}
+	}
+	if err := state.save(targetDir); err != nil {
+		return fmt.Errorf("failed to record imported files: %w", err)
+	}
+
+	report.Batches++
+	report.Imported += parsed.Imported
+	logger.Info("Imported new files", "imported", parsed.Imported, "changing", len(parsed.Changing), "too_small", len(parsed.TooSmall))
+	if opts.OnBatch != nil {
+		opts.OnBatch(parsed)
+	}
+	return nil
+}
+
+// scan lists the supported media files of sourceDir with their versions, skipping hidden files
+// and the paths its .picsignore files exclude, as a parse would. Each directory scanned is
+// watched by notifier, if set, before its files are listed, so none added since go unnoticed.
+func (w *watcher) scan(sourceDir string, notifier changeNotifier) (map[string]watchedFile, error) {
+	ignore, err := newIgnoreMatcher(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	found := make(map[string]watchedFile)
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != sourceDir && (strings.HasPrefix(info.Name(), ".") || ignore.isIgnored(path, info.IsDir())) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && notifier != nil {
+			if err := notifier.watch(path); err != nil {
+				// Such as when the limit of watches is reached, leaving the directory to polling
+				logger.Debug("Failed to watch directory for changes", "dir", path, "error", err)
+			}
+		}
+		if !info.Mode().IsRegular() || !w.extensions.IsSupported(path) {
+			return nil
+		}
+		found[path] = watchedFile{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// newWatchedFiles returns the files of found that haven't been imported, nor failed, as they are
+func newWatchedFiles(found map[string]watchedFile, state *watchState, failed map[string]watchedFile) map[string]watchedFile {
+	files := make(map[string]watchedFile)
+	for path, file := range found {
+		if imported, ok := state.Imported[path]; ok && imported == file {
+			continue
+		}
+		if failedFile, ok := failed[path]; ok && failedFile == file {
+			continue
+		}
+		files[path] = file
+	}
+	return files
+}
+
+// sameWatchedFiles reports whether a and b hold the same files at the same versions
+func sameWatchedFiles(a, b map[string]watchedFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, file := range a {
+		if other, ok := b[path]; !ok || other != file {
+			return false
+		}
+	}
+	return true
+}
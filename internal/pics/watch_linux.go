@@ -0,0 +1,61 @@
+//go:build linux
+
+package pics
+
+import (
+	"os"
+	"syscall"
+)
+
+// inotifyEvents are the changes to a watched directory that may bring, change or take away files
+const inotifyEvents = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// inotifyNotifier tells of changes to the directories it watches with inotify
+type inotifyNotifier struct {
+	fd   int
+	file *os.File
+	ch   chan struct{}
+}
+
+// newChangeNotifier returns a notifier watching no directory yet
+func newChangeNotifier() (changeNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotifyNotifier{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), ch: make(chan struct{}, 1)}
+	go n.read()
+	return n, nil
+}
+
+// read signals a change for every read of events, until the notifier is closed. The events
+// themselves don't matter, as the directory is scanned again after any of them.
+func (n *inotifyNotifier) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+			// A change is signalled already
+		}
+	}
+}
+
+func (n *inotifyNotifier) watch(dir string) error {
+	if _, err := syscall.InotifyAddWatch(n.fd, dir, inotifyEvents); err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	return nil
+}
+
+func (n *inotifyNotifier) changes() <-chan struct{} {
+	return n.ch
+}
+
+func (n *inotifyNotifier) close() error {
+	return n.file.Close()
+}
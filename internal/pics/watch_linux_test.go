@@ -0,0 +1,36 @@
+package pics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatcher_ImportsOnChange(t *testing.T) {
+	sourceDir := t.TempDir()
+	albumDir := filepath.Join(sourceDir, "album")
+
+	// Files added after the first scan, in a directory it didn't see, with no polling to find them
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Mkdir(albumDir, 0755)
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(albumDir, "photo.jpg"), []byte("photo"), 0644)
+	}()
+
+	ctx, cancel := context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	parser := &fakeBatchParser{onParse: func(int) { cancel() }}
+	opts := testWatchOptions()
+	opts.PollInterval = time.Hour
+	if _, err := NewWatcher(parser).Watch(ctx, sourceDir, t.TempDir(), opts); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := [][]string{{filepath.Join(albumDir, "photo.jpg")}}
+	if !reflect.DeepEqual(parser.batches, want) {
+		t.Errorf("Expected batches %v, got %v", want, parser.batches)
+	}
+}
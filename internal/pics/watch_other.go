@@ -0,0 +1,10 @@
+//go:build !linux
+
+package pics
+
+import "errors"
+
+// newChangeNotifier fails, as changes are only told of on Linux: elsewhere, watches poll alone
+func newChangeNotifier() (changeNotifier, error) {
+	return nil, errors.ErrUnsupported
+}
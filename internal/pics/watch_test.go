@@ -0,0 +1,214 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBatchParser records the files of each ParseFiles call, failing with err when set
+type fakeBatchParser struct {
+	MediaParser
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+	// onParse, if set, is called after each batch is recorded
+	onParse func(batch int)
+}
+
+func (p *fakeBatchParser) ParseFiles(ctx context.Context, files []string, targetDir string, opts ParseOptions) (ParseReport, error) {
+	p.mu.Lock()
+	p.batches = append(p.batches, files)
+	batch := len(p.batches)
+	p.mu.Unlock()
+	if p.onParse != nil {
+		p.onParse(batch)
+	}
+	if p.err != nil {
+		return ParseReport{}, p.err
+	}
+	return ParseReport{Imported: len(files)}, nil
+}
+
+// testWatchOptions returns watch options quick enough for tests
+func testWatchOptions() WatchOptions {
+	opts := DefaultWatchOptions()
+	opts.PollInterval = 10 * time.Millisecond
+	opts.Settle = 30 * time.Millisecond
+	return opts
+}
+
+func TestWatcher_ImportsNewFilesOnce(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeContentFile(t, sourceDir, "photo.jpg", "photo")
+	writeContentFile(t, sourceDir, "notes.txt", "notes")
+	writeContentFile(t, sourceDir, ".hidden.jpg", "hidden")
+
+	ctx, cancel := context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	parser := &fakeBatchParser{onParse: func(int) { cancel() }}
+	report, err := NewWatcher(parser).Watch(ctx, sourceDir, targetDir, testWatchOptions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := [][]string{{filepath.Join(sourceDir, "photo.jpg")}}
+	if !reflect.DeepEqual(parser.batches, want) {
+		t.Fatalf("Expected batches %v, got %v", want, parser.batches)
+	}
+	if report.Batches != 1 || report.Imported != 1 {
+		t.Errorf("Expected one batch of one file, got %+v", report)
+	}
+
+	// A restarted watch remembers the file, and only imports the new one
+	writeContentFile(t, filepath.Join(sourceDir, "album"), "video.mp4", "video")
+	ctx, cancel = context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	parser = &fakeBatchParser{onParse: func(int) { cancel() }}
+	if _, err := NewWatcher(parser).Watch(ctx, sourceDir, targetDir, testWatchOptions()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want = [][]string{{filepath.Join(sourceDir, "album", "video.mp4")}}
+	if !reflect.DeepEqual(parser.batches, want) {
+		t.Errorf("Expected batches %v, got %v", want, parser.batches)
+	}
+}
+
+func TestWatcher_WaitsForFilesToSettle(t *testing.T) {
+	sourceDir := t.TempDir()
+	path := writeContentFile(t, sourceDir, "photo.jpg", "p")
+
+	// Keep growing the file for a while, as a copy in progress would
+	stopWriting := make(chan struct{})
+	writing := time.Now()
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; i < 20; i++ {
+			select {
+			case <-stopWriting:
+				return
+			case <-ticker.C:
+			}
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return
+			}
+			f.WriteString("p")
+			f.Close()
+		}
+	}()
+	defer close(stopWriting)
+
+	ctx, cancel := context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	var parsedAfter time.Duration
+	parser := &fakeBatchParser{onParse: func(int) {
+		parsedAfter = time.Since(writing)
+		cancel()
+	}}
+	if _, err := NewWatcher(parser).Watch(ctx, sourceDir, t.TempDir(), testWatchOptions()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(parser.batches) != 1 {
+		t.Fatalf("Expected one batch, got %v", parser.batches)
+	}
+	if parsedAfter < 100*time.Millisecond {
+		t.Errorf("Expected the file imported once it stopped changing, got it after %v", parsedAfter)
+	}
+}
+
+func TestWatcher_DoesNotRetryFailedBatchUntilChanged(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	path := writeContentFile(t, sourceDir, "photo.jpg", "photo")
+
+	ctx, cancel := context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	parser := &fakeBatchParser{err: errors.New("exiftool failed")}
+	parser.onParse = func(batch int) {
+		if batch == 1 {
+			// Give the watch a few polls to try the failed file again, then change it
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				os.WriteFile(path, []byte("photo, fixed"), 0644)
+			}()
+			return
+		}
+		cancel()
+	}
+	report, err := NewWatcher(parser).Watch(ctx, sourceDir, targetDir, testWatchOptions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(parser.batches) != 2 || report.Failed != 1 {
+		t.Errorf("Expected the file tried again only after it changed, got batches %v and %+v", parser.batches, report)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, watchStateName)); !os.IsNotExist(err) {
+		t.Errorf("Expected failed files not recorded, got %v", err)
+	}
+}
+
+func TestWatcher_RejectsTargetInsideSource(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := filepath.Join(sourceDir, "library")
+	if err := os.Mkdir(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewWatcher(&fakeBatchParser{}).Watch(testCtx, sourceDir, targetDir, testWatchOptions())
+	if err == nil {
+		t.Fatal("Expected a target inside the watched directory to be rejected")
+	}
+}
+
+func TestWatcher_ForgetsFilesGoneFromSource(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	photo := writeContentFile(t, sourceDir, "photo.jpg", "photo")
+	watch := func(timeout time.Duration) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(testCtx, timeout)
+		defer cancel()
+		parser := &fakeBatchParser{onParse: func(int) { cancel() }}
+		if _, err := NewWatcher(parser).Watch(ctx, sourceDir, targetDir, testWatchOptions()); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	watch(5 * time.Second)
+
+	// Files watched from another source are left alone
+	state, err := loadWatchState(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := filepath.Join(t.TempDir(), "card.jpg")
+	state.Imported[elsewhere] = watchedFile{Size: 4}
+	if err := state.save(targetDir); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(photo)
+	video := writeContentFile(t, sourceDir, "video.mp4", "video")
+	watch(5 * time.Second)
+	if state, err = loadWatchState(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Imported[photo]; ok || len(state.Imported) != 2 {
+		t.Errorf("Expected the removed file forgotten, got %v", state.Imported)
+	}
+
+	// An empty source, such as the mount point of an unmounted card, forgets nothing
+	os.Remove(video)
+	watch(100 * time.Millisecond)
+	if state, err = loadWatchState(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Imported[video]; !ok {
+		t.Errorf("Expected the files of an empty source remembered, got %v", state.Imported)
+	}
+}
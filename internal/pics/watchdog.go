@@ -0,0 +1,166 @@
+package pics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// heartbeatInterval is how often a parse run logs its progress and looks for stalled workers
+const heartbeatInterval = time.Minute
+
+// retryWorkerID identifies the retry pass, which processes files after the workers are done
+const retryWorkerID = -1
+
+// watchdog tracks the file each worker is processing, so a worker stuck on one file (a hung
+// helper process, a stuck network mount) is reported instead of silently hanging the import.
+// Helper processes run under the context of their file, which the watchdog cancels once the
+// file has made no progress for the timeout, killing them. Helpers reporting their progress,
+// such as ffmpeg encoding a long video, are given the timeout again every time they do.
+// Blocking file system calls can't be interrupted; their file is reported at every heartbeat
+// until it completes.
+type watchdog struct {
+	ctx     context.Context
+	timeout time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	tasks map[int]*watchedTask
+}
+
+// watchedTask is the file a worker is processing
+type watchedTask struct {
+	dog  *watchdog
+	file string
+	// progressed is when the file was started or last made progress
+	progressed time.Time
+	cancel     context.CancelFunc
+	stalled    bool
+	// paused is set while the file waits its turn, which doesn't count as being stuck
+	paused bool
+}
+
+// watchedTaskKey is the context key of the task a file is processed under
+type watchedTaskKey struct{}
+
+// stalledTask is a file a worker has made no progress on for longer than the timeout
+type stalledTask struct {
+	worker int
+	file   string
+	// idle is how long the file has made no progress for
+	idle time.Duration
+	// killed is set the first time the task is found stalled, when its helper processes are killed
+	killed bool
+}
+
+// newWatchdog creates a watchdog that gives up on a file after timeout (0 = never). The contexts
+// of files are derived from ctx, so cancelling it kills every helper process.
+func newWatchdog(ctx context.Context, timeout time.Duration) *watchdog {
+	return &watchdog{
+		ctx:     ctx,
+		timeout: timeout,
+		now:     time.Now,
+		tasks:   make(map[int]*watchedTask),
+	}
+}
+
+// begin records that worker started on file and returns the context its helper processes run under
+func (w *watchdog) begin(worker int, file string) context.Context {
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	task := &watchedTask{dog: w, file: file, progressed: w.now(), cancel: cancel}
+	w.tasks[worker] = task
+	return context.WithValue(ctx, watchedTaskKey{}, task)
+}
+
+// end records that worker finished its file
+func (w *watchdog) end(worker int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if task, ok := w.tasks[worker]; ok {
+		task.cancel()
+		delete(w.tasks, worker)
+	}
+}
+
+// reportProgress records that the file processed under ctx made progress, so the watchdog
+// waits the whole timeout again before giving up on it
+func reportProgress(ctx context.Context) {
+	task, ok := ctx.Value(watchedTaskKey{}).(*watchedTask)
+	if !ok {
+		return
+	}
+	task.dog.mu.Lock()
+	defer task.dog.mu.Unlock()
+	task.progressed = task.dog.now()
+}
+
+// pauseWatch stops the watchdog from giving up on the file processed under ctx while it waits
+// its turn, until the returned function is called
+func pauseWatch(ctx context.Context) func() {
+	task, ok := ctx.Value(watchedTaskKey{}).(*watchedTask)
+	if !ok {
+		return func() {}
+	}
+	task.dog.mu.Lock()
+	defer task.dog.mu.Unlock()
+	task.paused = true
+	return func() {
+		task.dog.mu.Lock()
+		defer task.dog.mu.Unlock()
+		task.paused = false
+		task.progressed = task.dog.now()
+	}
+}
+
+// checkStalled returns the files that have made no progress for longer than the timeout,
+// sorted by worker. Files found stalled for the first time have their context cancelled.
+func (w *watchdog) checkStalled() []stalledTask {
+	if w.timeout <= 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	var stalled []stalledTask
+	for worker, task := range w.tasks {
+		idle := now.Sub(task.progressed)
+		if task.paused || idle < w.timeout {
+			continue
+		}
+		stalled = append(stalled, stalledTask{worker: worker, file: task.file, idle: idle, killed: !task.stalled})
+		if !task.stalled {
+			task.stalled = true
+			task.cancel()
+		}
+	}
+	sort.Slice(stalled, func(i, j int) bool { return stalled[i].worker < stalled[j].worker })
+	return stalled
+}
+
+// run logs a heartbeat with the progress of the run and any stalled files every interval,
+// until stop is closed
+func (w *watchdog) run(stop <-chan struct{}, interval time.Duration, processed, total func() int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			logger.Info("Still processing files", "processed", processed(), "total", total())
+			for _, task := range w.checkStalled() {
+				if task.killed {
+					logger.Warn("Worker made no progress, stopping its helper processes and moving on", "worker", task.worker, "file", task.file, "idle", task.idle.Round(time.Second))
+				} else {
+					logger.Warn("Worker is still stuck, the file system may not be responding", "worker", task.worker, "file", task.file, "idle", task.idle.Round(time.Second))
+				}
+			}
+		}
+	}
+}
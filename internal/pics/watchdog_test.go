@@ -0,0 +1,139 @@
+package pics
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestWatchdog creates a watchdog whose clock is advanced by the test
+func newTestWatchdog(timeout time.Duration) (*watchdog, *time.Time) {
+	now := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	dog := newWatchdog(testCtx, timeout)
+	dog.now = func() time.Time { return now }
+	return dog, &now
+}
+
+func TestWatchdog_CheckStalled(t *testing.T) {
+	dog, now := newTestWatchdog(5 * time.Minute)
+
+	stuckCtx := dog.begin(1, "/mnt/nas/IMG_0001.jpg")
+	*now = now.Add(3 * time.Minute)
+	busyCtx := dog.begin(2, "/mnt/nas/IMG_0002.jpg")
+
+	*now = now.Add(2 * time.Minute)
+	stalled := dog.checkStalled()
+	if len(stalled) != 1 || stalled[0].worker != 1 || stalled[0].file != "/mnt/nas/IMG_0001.jpg" {
+		t.Fatalf("Expected worker 1 to be stalled, got %+v", stalled)
+	}
+	if !stalled[0].killed || stalled[0].idle != 5*time.Minute {
+		t.Errorf("Expected the stalled task to be killed after 5m, got %+v", stalled[0])
+	}
+	if stuckCtx.Err() == nil {
+		t.Error("Expected the context of the stalled file to be cancelled")
+	}
+	if busyCtx.Err() != nil {
+		t.Error("Expected the context of the busy file to stay open")
+	}
+
+	// A worker still stuck after its helpers were killed is reported again, but only killed once
+	*now = now.Add(time.Minute)
+	stalled = dog.checkStalled()
+	if len(stalled) != 1 || stalled[0].killed {
+		t.Errorf("Expected worker 1 to be reported as still stuck, got %+v", stalled)
+	}
+
+	dog.end(1)
+	dog.end(2)
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected no stalled tasks once the workers are done, got %+v", stalled)
+	}
+	if busyCtx.Err() == nil {
+		t.Error("Expected the context to be released when the file is done")
+	}
+}
+
+func TestWatchdog_Progress(t *testing.T) {
+	dog, now := newTestWatchdog(5 * time.Minute)
+
+	// A long encode reporting its progress is never stalled
+	ctx := dog.begin(1, "/mnt/nas/VID_0001.mov")
+	for i := 0; i < 3; i++ {
+		*now = now.Add(4 * time.Minute)
+		reportProgress(ctx)
+	}
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected a file making progress not to stall, got %+v", stalled)
+	}
+
+	// Waiting for a turn doesn't count, and the timeout starts over once it comes
+	resume := pauseWatch(ctx)
+	*now = now.Add(time.Hour)
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected a waiting file not to stall, got %+v", stalled)
+	}
+	resume()
+	*now = now.Add(4 * time.Minute)
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected the timeout to start over, got %+v", stalled)
+	}
+	*now = now.Add(time.Minute)
+	if stalled := dog.checkStalled(); len(stalled) != 1 || stalled[0].idle != 5*time.Minute {
+		t.Errorf("Expected the file stalled once it stopped making progress, got %+v", stalled)
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected the context of the stalled file to be cancelled")
+	}
+}
+
+func TestWatchdog_NoTimeout(t *testing.T) {
+	dog, now := newTestWatchdog(0)
+
+	ctx := dog.begin(1, "IMG_0001.jpg")
+	*now = now.Add(24 * time.Hour)
+	if stalled := dog.checkStalled(); len(stalled) != 0 {
+		t.Errorf("Expected no stalled tasks without a timeout, got %+v", stalled)
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the context to stay open without a timeout")
+	}
+}
+
+func TestWatchdog_KillsHungCompressor(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available, skipping test")
+	}
+
+	tmpDir := t.TempDir()
+	hung := filepath.Join(tmpDir, "jpegoptim")
+	if err := os.WriteFile(hung, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatalf("Failed to write helper: %v", err)
+	}
+	photo := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Now())
+
+	dog := newWatchdog(testCtx, 50*time.Millisecond)
+	ctx := dog.begin(0, photo)
+	defer dog.end(0)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewImageCompressorWithPath(hung).CompressFile(ctx, photo, CompressOptions{Quality: 50})
+	}()
+
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("Expected an error from the killed compressor")
+			}
+			return
+		case <-deadline:
+			t.Fatal("Expected the watchdog to kill the hung compressor")
+		case <-time.After(10 * time.Millisecond):
+			dog.checkStalled()
+		}
+	}
+}